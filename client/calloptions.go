@@ -0,0 +1,100 @@
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+)
+
+// CallOption tunes a single call.
+type CallOption func(*callOptions)
+
+type callOptions struct {
+	timeout        time.Duration
+	header         http.Header
+	idempotencyKey string
+	noRetry        bool
+}
+
+// WithTimeout bounds the whole call, its retries included, within any
+// deadline the context already has. WithAttemptTimeout still bounds each
+// attempt.
+func WithTimeout(d time.Duration) CallOption {
+	return func(o *callOptions) { o.timeout = d }
+}
+
+// WithHeader adds a header to the call's requests.
+func WithHeader(key, value string) CallOption {
+	return func(o *callOptions) {
+		if o.header == nil {
+			o.header = http.Header{}
+		}
+		o.header.Add(key, value)
+	}
+}
+
+// WithIdempotencyKey sends key as the call's Idempotency-Key, so that the
+// service runs the call once however often it is sent, retried by the
+// caller as well as by the Client. With WithInstances, calls with a key go
+// to the instance it picks, which is the one that knows it. Shorten sends
+// a key of its own unless given one.
+func WithIdempotencyKey(key string) CallOption {
+	return func(o *callOptions) { o.idempotencyKey = key }
+}
+
+// WithoutRetry tries the call only once.
+func WithoutRetry() CallOption {
+	return func(o *callOptions) { o.noRetry = true }
+}
+
+type callOptionsKey struct{}
+
+func callOptionsFrom(ctx context.Context) *callOptions {
+	o, _ := ctx.Value(callOptionsKey{}).(*callOptions)
+	if o == nil {
+		return &callOptions{}
+	}
+	return o
+}
+
+// call sends request to e under opts.
+func call(ctx context.Context, e endpoint.Endpoint, request interface{}, opts []CallOption) (interface{}, error) {
+	o := &callOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.timeout)
+		defer cancel()
+	}
+	return e(context.WithValue(ctx, callOptionsKey{}, o), request)
+}
+
+// newIdempotencyKey returns a random key.
+func newIdempotencyKey() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// setCallHeaders is a ClientBefore func that sets the headers a call's
+// options ask for on each of its requests.
+func setCallHeaders(ctx context.Context, r *http.Request) context.Context {
+	o := callOptionsFrom(ctx)
+	for key, values := range o.header {
+		for _, v := range values {
+			r.Header.Add(key, v)
+		}
+	}
+	if o.idempotencyKey != "" {
+		r.Header.Set("Idempotency-Key", o.idempotencyKey)
+	}
+	return ctx
+}
@@ -0,0 +1,423 @@
+// Package client is the Go client for the string service. Each call goes
+// through middlewares that time out hung attempts, retry retryable
+// failures with jittered backoff, and stop calling a failing service for a
+// while; all three are on by default and can be tuned or turned off with
+// Options. Given several instances of the service, a Client spreads calls
+// across them and can hedge slow calls by sending them to a second one.
+// Failed calls return the *svcerrors.Error the service sent, with its code,
+// message and field violations, so callers can branch on it as the service
+// does: errors.Is(err, svcerrors.ErrStringEmpty) holds for an empty input,
+// and svcerrors.CodeOf(err) gives any failure's code, including failures
+// to reach the service.
+package client
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/metrics"
+	httptransport "github.com/go-kit/kit/transport/http"
+	"github.com/sony/gobreaker"
+
+	"github.com/mcclayac/gokit/codec"
+	"github.com/mcclayac/gokit/svcerrors"
+	"github.com/mcclayac/gokit/tracing"
+)
+
+// Service is the string service as Client calls it. Code that depends on
+// Service rather than *Client can be tested against a fake, such as the
+// one in package mocks.
+type Service interface {
+	Uppercase(ctx context.Context, s string, opts ...CallOption) (string, error)
+	Count(ctx context.Context, s string, opts ...CallOption) (int, error)
+	Hash(ctx context.Context, password string, opts ...CallOption) (string, error)
+	Verify(ctx context.Context, password, hash string, opts ...CallOption) (bool, error)
+	Shorten(ctx context.Context, url string, ttl time.Duration, opts ...CallOption) (Link, error)
+	Resolve(ctx context.Context, code string, opts ...CallOption) (Link, error)
+	Detect(ctx context.Context, s string, max int, opts ...CallOption) ([]LanguageGuess, error)
+	ListHistory(ctx context.Context, q HistoryQuery, opts ...CallOption) *HistoryIterator
+	ListJobs(ctx context.Context, opts ...CallOption) *JobIterator
+	ListAudit(ctx context.Context, q AuditQuery, opts ...CallOption) *AuditIterator
+}
+
+var _ Service = (*Client)(nil)
+
+// Client calls the string service. It is safe for concurrent use.
+type Client struct {
+	uppercase endpoint.Endpoint
+	count     endpoint.Endpoint
+	hash      endpoint.Endpoint
+	verify    endpoint.Endpoint
+	shorten   endpoint.Endpoint
+	resolve   endpoint.Endpoint
+	detect    endpoint.Endpoint
+	history   endpoint.Endpoint
+	jobs      endpoint.Endpoint
+	audit     endpoint.Endpoint
+}
+
+// Option configures a Client.
+type Option func(*options)
+
+type options struct {
+	httpClient  *http.Client
+	transport   TransportConfig
+	opened      metrics.Counter
+	reused      metrics.Counter
+	instances   []string
+	subsetID    int
+	subsetSize  int
+	timeout     time.Duration
+	retry       RetryPolicy
+	breaker     *gobreaker.Settings
+	hedge       *HedgePolicy
+	hooks       []Hooks
+	middlewares []endpoint.Middleware
+	codec       codec.Codec
+}
+
+// WithHTTPClient sends requests with c instead of a client of the Client's
+// own, set up by WithTransport.
+func WithHTTPClient(c *http.Client) Option {
+	return func(o *options) { o.httpClient = c }
+}
+
+// WithInstances adds the base URLs of more instances of the service.
+// Calls go to each instance in turn.
+func WithInstances(baseURLs ...string) Option {
+	return func(o *options) { o.instances = append(o.instances, baseURLs...) }
+}
+
+// WithAttemptTimeout sets the limit on each attempt at a call, 5 seconds
+// by default. Zero removes it, leaving only the call's deadline.
+func WithAttemptTimeout(d time.Duration) Option {
+	return func(o *options) { o.timeout = d }
+}
+
+// WithRetry replaces DefaultRetryPolicy. WithRetry(RetryPolicy{}) turns
+// retries off.
+func WithRetry(p RetryPolicy) Option {
+	return func(o *options) { o.retry = p }
+}
+
+// WithBreaker replaces DefaultBreakerSettings.
+func WithBreaker(s gobreaker.Settings) Option {
+	return func(o *options) { o.breaker = &s }
+}
+
+// WithoutBreaker turns the circuit breaker off.
+func WithoutBreaker() Option {
+	return func(o *options) { o.breaker = nil }
+}
+
+// WithHedging hedges calls under p, which needs WithInstances. Only cheap
+// calls without side effects are hedged: Shorten and Resolve never are,
+// since instances don't share idempotency keys and a hedged Resolve would
+// count two hits, and nor are Hash and Verify, whose hashing is slow on
+// purpose and shares a rate limit on the service.
+func WithHedging(p HedgePolicy) Option {
+	return func(o *options) { o.hedge = &p }
+}
+
+// WithCodec sends requests and asks for responses in c's wire format
+// instead of JSON, such as codec.Protobuf to skip JSON's overhead. The
+// listing calls stay JSON, since the service has no other form of its
+// pages.
+func WithCodec(c codec.Codec) Option {
+	return func(o *options) { o.codec = c }
+}
+
+// WithMiddleware wraps every call in mw, outside the retries, so mw sees
+// each call once whatever the number of attempts. Middlewares given first
+// are outermost.
+func WithMiddleware(mw ...endpoint.Middleware) Option {
+	return func(o *options) { o.middlewares = append(o.middlewares, mw...) }
+}
+
+// New returns a Client for the service at baseURL, such as
+// "http://localhost:9090".
+func New(baseURL string, opts ...Option) (*Client, error) {
+	breaker := DefaultBreakerSettings
+	o := options{
+		transport: DefaultTransportConfig,
+		timeout:   5 * time.Second,
+		retry:     DefaultRetryPolicy,
+		breaker:   &breaker,
+		codec:     codec.JSON,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.httpClient == nil {
+		o.httpClient = &http.Client{Transport: newTransport(o.transport)}
+	}
+	clientOpts := []httptransport.ClientOption{
+		httptransport.SetClient(o.httpClient),
+		httptransport.ClientBefore(setCallHeaders, tracing.ContextToHTTP),
+	}
+	if o.opened != nil {
+		clientOpts = append(clientOpts, httptransport.ClientBefore(traceConns(o.opened, o.reused)))
+	}
+	var urls []string
+	for _, s := range append([]string{baseURL}, o.instances...) {
+		urls = append(urls, strings.TrimSuffix(s, "/"))
+	}
+	var bases []*url.URL
+	for _, s := range subset(urls, o.subsetID, o.subsetSize) {
+		u, err := url.Parse(s)
+		if err != nil {
+			return nil, err
+		}
+		bases = append(bases, u)
+	}
+
+	// One breaker guards every method: they share the service's health.
+	var cb endpoint.Middleware
+	if o.breaker != nil {
+		cb = Breaker(*o.breaker)
+	}
+	chain := func(name string, e endpoint.Endpoint) endpoint.Endpoint {
+		if cb != nil {
+			e = cb(e)
+		}
+		e = Retry(o.retry)(e)
+		for i := len(o.middlewares) - 1; i >= 0; i-- {
+			e = o.middlewares[i](e)
+		}
+		if len(o.hooks) > 0 {
+			e = hooksMiddleware(o.hooks, name)(e)
+		}
+		return e
+	}
+	ep := func(name, method, path string, newResponse func() interface{}, hedge bool) endpoint.Endpoint {
+		enc, c := encodeRequest(o.codec), o.codec
+		if method == http.MethodGet {
+			enc, c = encodeQuery, codec.JSON
+		}
+		in := &instances{next: uint32(o.subsetID)} // clients start on different instances
+		if hedge {
+			in.policy = o.hedge
+		}
+		for _, base := range bases {
+			u := *base
+			u.Path += path
+			e := httptransport.NewClient(method, &u,
+				enc,
+				decodeResponse(newResponse, c),
+				clientOpts...,
+			).Endpoint()
+			if o.timeout > 0 {
+				e = Timeout(o.timeout)(e)
+			}
+			in.endpoints = append(in.endpoints, e)
+		}
+		return chain(name, in.call)
+	}
+	return &Client{
+		uppercase: ep("uppercase", http.MethodPost, "/uppercase", func() interface{} { return new(stringResponse) }, true),
+		count:     ep("count", http.MethodPost, "/count", func() interface{} { return new(countResponse) }, true),
+		hash:      ep("hash", http.MethodPost, "/password/hash", func() interface{} { return new(hashResponse) }, false),
+		verify:    ep("verify", http.MethodPost, "/password/verify", func() interface{} { return new(verifyResponse) }, false),
+		shorten:   ep("shorten", http.MethodPost, "/links", func() interface{} { return new(linkResponse) }, false),
+		resolve:   ep("resolve", http.MethodPost, "/links/resolve", func() interface{} { return new(linkResponse) }, false),
+		detect:    ep("detect", http.MethodPost, "/detect", func() interface{} { return new(detectResponse) }, true),
+		history:   ep("list_history", http.MethodGet, "/history", func() interface{} { return new(historyPage) }, true),
+		jobs:      ep("list_jobs", http.MethodGet, "/jobs", func() interface{} { return new(jobsPage) }, true),
+		audit:     ep("list_audit", http.MethodGet, "/admin/audit", func() interface{} { return new(auditPage) }, true),
+	}, nil
+}
+
+// Link is a short link.
+type Link struct {
+	Code    string    `json:"code"`
+	URL     string    `json:"url"`
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires,omitempty"` // zero when the link never expires
+	Hits    int64     `json:"hits"`
+}
+
+// LanguageGuess is a language a text may be in.
+type LanguageGuess struct {
+	Lang       string  `json:"lang"`
+	Confidence float64 `json:"confidence"`
+}
+
+// Uppercase returns s in upper case.
+func (c *Client) Uppercase(ctx context.Context, s string, opts ...CallOption) (string, error) {
+	resp, err := call(ctx, c.uppercase, stringRequest{S: s}, opts)
+	if err != nil {
+		return "", err
+	}
+	return resp.(*stringResponse).V, nil
+}
+
+// Count returns the number of characters in s.
+func (c *Client) Count(ctx context.Context, s string, opts ...CallOption) (int, error) {
+	resp, err := call(ctx, c.count, stringRequest{S: s}, opts)
+	if err != nil {
+		return 0, err
+	}
+	return resp.(*countResponse).V, nil
+}
+
+// Hash returns a hash of password for storing.
+func (c *Client) Hash(ctx context.Context, password string, opts ...CallOption) (string, error) {
+	resp, err := call(ctx, c.hash, hashRequest{Password: password}, opts)
+	if err != nil {
+		return "", err
+	}
+	return resp.(*hashResponse).Hash, nil
+}
+
+// Verify reports whether password matches hash.
+func (c *Client) Verify(ctx context.Context, password, hash string, opts ...CallOption) (bool, error) {
+	resp, err := call(ctx, c.verify, verifyRequest{Password: password, Hash: hash}, opts)
+	if err != nil {
+		return false, err
+	}
+	return resp.(*verifyResponse).V, nil
+}
+
+// Shorten stores url under a new short code. The link expires after ttl,
+// or the service's default when ttl is zero. Retries send the same
+// Idempotency-Key to the same instance, so they can't create a second
+// link.
+func (c *Client) Shorten(ctx context.Context, url string, ttl time.Duration, opts ...CallOption) (Link, error) {
+	key, err := newIdempotencyKey()
+	if err != nil {
+		return Link{}, err
+	}
+	opts = append([]CallOption{WithIdempotencyKey(key)}, opts...)
+	resp, err := call(ctx, c.shorten, shortenRequest{URL: url, TTL: int64(ttl / time.Second)}, opts)
+	if err != nil {
+		return Link{}, err
+	}
+	return resp.(*linkResponse).Link, nil
+}
+
+// Resolve returns the link stored under code, counting a hit on it. Its
+// retries carry the call's Idempotency-Key, so the hit is counted once.
+func (c *Client) Resolve(ctx context.Context, code string, opts ...CallOption) (Link, error) {
+	key, err := newIdempotencyKey()
+	if err != nil {
+		return Link{}, err
+	}
+	opts = append([]CallOption{WithIdempotencyKey(key)}, opts...)
+	resp, err := call(ctx, c.resolve, resolveRequest{Code: code}, opts)
+	if err != nil {
+		return Link{}, err
+	}
+	return resp.(*linkResponse).Link, nil
+}
+
+// Detect returns the languages s is most likely in, at most max of them
+// (the service's default when zero), most likely first.
+func (c *Client) Detect(ctx context.Context, s string, max int, opts ...CallOption) ([]LanguageGuess, error) {
+	resp, err := call(ctx, c.detect, detectRequest{S: s, Max: max}, opts)
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*detectResponse).Languages, nil
+}
+
+// The wire types mirror the service's JSON.
+type (
+	stringRequest struct {
+		S string `json:"s"`
+	}
+	stringResponse struct {
+		V string `json:"v"`
+	}
+	countResponse struct {
+		V int `json:"v"`
+	}
+	hashRequest struct {
+		Password string `json:"password"`
+	}
+	hashResponse struct {
+		Hash string `json:"hash"`
+	}
+	verifyRequest struct {
+		Password string `json:"password"`
+		Hash     string `json:"hash"`
+	}
+	verifyResponse struct {
+		V bool `json:"v"`
+	}
+	shortenRequest struct {
+		URL string `json:"url"`
+		TTL int64  `json:"ttl_seconds,omitempty"`
+	}
+	resolveRequest struct {
+		Code string `json:"code"`
+	}
+	linkResponse struct {
+		Link Link `json:"link"`
+	}
+	detectRequest struct {
+		S   string `json:"s"`
+		Max int    `json:"max,omitempty"`
+	}
+	detectResponse struct {
+		Languages []LanguageGuess `json:"languages"`
+	}
+	errorResponse struct {
+		Err *svcerrors.Error `json:"err"`
+	}
+)
+
+// encodeRequest sends request as the body, in c's wire format.
+func encodeRequest(c codec.Codec) httptransport.EncodeRequestFunc {
+	return func(_ context.Context, r *http.Request, request interface{}) error {
+		var buf bytes.Buffer
+		if err := c.Encode(&buf, request); err != nil {
+			return err
+		}
+		r.Header.Set("Content-Type", c.ContentType())
+		r.Header.Set("Accept", c.ContentType())
+		r.ContentLength = int64(buf.Len())
+		r.Body = io.NopCloser(&buf)
+		return nil
+	}
+}
+
+// encodeQuery sends request, a url.Values, as the query string.
+func encodeQuery(_ context.Context, r *http.Request, request interface{}) error {
+	r.URL.RawQuery = request.(url.Values).Encode()
+	r.Header.Set("Accept", "application/json")
+	return nil
+}
+
+// decodeResponse decodes a successful response into the value newResponse
+// returns, and a failed one into an *svcerrors.Error, in c's wire format.
+// Failures without the service's error body, such as from a proxy, get a
+// code from their status; the service writes errors it can't negotiate,
+// such as an unsupported media type, as JSON.
+func decodeResponse(newResponse func() interface{}, c codec.Codec) httptransport.DecodeResponseFunc {
+	return func(_ context.Context, r *http.Response) (interface{}, error) {
+		if r.StatusCode >= 400 {
+			ec := c
+			got, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			if want, _, _ := mime.ParseMediaType(c.ContentType()); got != want {
+				ec = codec.JSON
+			}
+			var e errorResponse
+			if err := ec.Decode(r.Body, &e); err != nil || e.Err == nil || e.Err.Code == "" {
+				return nil, svcerrors.Errorf(svcerrors.CodeForStatus(r.StatusCode), "%s", r.Status)
+			}
+			return nil, e.Err
+		}
+		response := newResponse()
+		if err := c.Decode(r.Body, response); err != nil {
+			return nil, err
+		}
+		return response, nil
+	}
+}
@@ -0,0 +1,37 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResolveRetriesWithOneKey(t *testing.T) {
+	var keys []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys = append(keys, r.Header.Get("Idempotency-Key"))
+		w.Header().Set("Content-Type", "application/json")
+		if len(keys) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, `{"err":{"code":"INTERNAL","message":"internal error"}}`)
+			return
+		}
+		fmt.Fprintf(w, `{"link":{"code":"abc","url":"https://example.com","hits":%d}}`, len(keys))
+	}))
+	defer srv.Close()
+	c, err := New(srv.URL, WithRetry(RetryPolicy{MaxAttempts: 3}), WithoutBreaker())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		keys = nil
+		if _, err := c.Resolve(context.Background(), "abc"); err != nil {
+			t.Fatal(err)
+		}
+		if len(keys) != 2 || keys[0] == "" || keys[0] != keys[1] {
+			t.Errorf("call %d: got keys %q, want one key on both attempts", i+1, keys)
+		}
+	}
+}
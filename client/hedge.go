@@ -0,0 +1,143 @@
+package client
+
+import (
+	"context"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+)
+
+// HedgePolicy says when a call that is slow to answer is sent again to
+// another instance. Whichever answers first is used and the other is
+// canceled, so one slow replica no longer sets the tail latency.
+type HedgePolicy struct {
+	// Percentile is the share of recent calls to the method that answered
+	// within the delay before a call is hedged; 0.95 hedges the slowest
+	// twentieth or so.
+	Percentile float64
+	// The delay is kept between MinDelay and MaxDelay, and is MaxDelay
+	// until the method has answered enough calls to estimate it.
+	MinDelay time.Duration
+	MaxDelay time.Duration
+}
+
+// DefaultHedgePolicy hedges calls slower than the 95th percentile.
+var DefaultHedgePolicy = HedgePolicy{
+	Percentile: 0.95,
+	MinDelay:   5 * time.Millisecond,
+	MaxDelay:   time.Second,
+}
+
+const (
+	// latencySamples is how many recent latencies a method keeps.
+	latencySamples = 256
+	// minLatencySamples is how many it needs before it estimates a
+	// percentile.
+	minLatencySamples = 20
+)
+
+// latencies keeps the latencies of a method's recent successful calls.
+type latencies struct {
+	mtx     sync.Mutex
+	samples [latencySamples]time.Duration
+	n       int64 // samples ever added
+}
+
+func (l *latencies) add(d time.Duration) {
+	l.mtx.Lock()
+	l.samples[l.n%latencySamples] = d
+	l.n++
+	l.mtx.Unlock()
+}
+
+// percentile returns the pth percentile of the samples, or false if there
+// are too few.
+func (l *latencies) percentile(p float64) (time.Duration, bool) {
+	l.mtx.Lock()
+	n := l.n
+	if n > latencySamples {
+		n = latencySamples
+	}
+	s := append([]time.Duration(nil), l.samples[:n]...)
+	l.mtx.Unlock()
+	if n < minLatencySamples {
+		return 0, false
+	}
+	sort.Slice(s, func(i, j int) bool { return s[i] < s[j] })
+	return s[int(p*float64(n-1))], true
+}
+
+// instances sends a method's calls to each instance of the service in
+// turn, hedging them under policy when it is set.
+type instances struct {
+	endpoints []endpoint.Endpoint
+	policy    *HedgePolicy
+	next      uint32
+	latencies latencies
+}
+
+func (in *instances) delay() time.Duration {
+	p := in.policy
+	d, ok := in.latencies.percentile(p.Percentile)
+	switch {
+	case !ok || d > p.MaxDelay:
+		return p.MaxDelay
+	case d < p.MinDelay:
+		return p.MinDelay
+	}
+	return d
+}
+
+// call sends request to the next instance and, if it hasn't answered
+// within the hedging delay, to the one after. A call that fails before the
+// delay isn't hedged; retrying it is up to Retry. Calls with an
+// Idempotency-Key always go to the instance the key picks, unhedged:
+// unless the service keeps keys in Redis, instances keep them to
+// themselves, so only that one can tell a retry from a new call.
+func (in *instances) call(ctx context.Context, request interface{}) (interface{}, error) {
+	if key := callOptionsFrom(ctx).idempotencyKey; key != "" {
+		h := fnv.New32a()
+		h.Write([]byte(key))
+		return in.endpoints[int(h.Sum32()%uint32(len(in.endpoints)))](ctx, request)
+	}
+	first := int(atomic.AddUint32(&in.next, 1) % uint32(len(in.endpoints)))
+	if in.policy == nil || len(in.endpoints) < 2 {
+		return in.endpoints[first](ctx, request)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel() // cancels the slower attempt
+	type result struct {
+		response interface{}
+		err      error
+	}
+	results := make(chan result, 2)
+	send := func(i int) {
+		begin := time.Now()
+		response, err := in.endpoints[i](ctx, request)
+		if err == nil {
+			in.latencies.add(time.Since(begin))
+		}
+		results <- result{response, err}
+	}
+	go send(first)
+	hedge := time.NewTimer(in.delay())
+	defer hedge.Stop()
+	pending := 1
+	for {
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil || pending == 0 {
+				return r.response, r.err
+			}
+		case <-hedge.C:
+			pending++
+			go send((first + 1) % len(in.endpoints))
+		}
+	}
+}
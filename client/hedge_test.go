@@ -0,0 +1,37 @@
+package client
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-kit/kit/endpoint"
+)
+
+func TestInstancesIdempotencyKey(t *testing.T) {
+	var got []int
+	in := &instances{}
+	for i := 0; i < 3; i++ {
+		i := i
+		in.endpoints = append(in.endpoints, endpoint.Endpoint(func(context.Context, interface{}) (interface{}, error) {
+			got = append(got, i)
+			return nil, nil
+		}))
+	}
+	keyed := context.WithValue(context.Background(), callOptionsKey{}, &callOptions{idempotencyKey: "k1"})
+	for i := 0; i < 5; i++ {
+		in.call(keyed, nil)
+	}
+	for _, i := range got[1:] {
+		if i != got[0] {
+			t.Fatalf("a keyed call's attempts went to instances %v, want one", got)
+		}
+	}
+
+	got = nil
+	for i := 0; i < 3; i++ {
+		in.call(context.Background(), nil)
+	}
+	if got[0] == got[1] || got[1] == got[2] {
+		t.Errorf("calls without a key went to instances %v, want each in turn", got)
+	}
+}
@@ -0,0 +1,138 @@
+package client
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/metrics"
+	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/mcclayac/gokit/svcerrors"
+)
+
+// Hooks are called around each call a Client makes, once however many
+// attempts it takes. method is the service method called, in snake case,
+// such as "uppercase" or "list_history". Any of the hooks may be nil.
+type Hooks struct {
+	// OnRequest is called before the call. The context it returns is the
+	// call's, and is passed to OnResponse or OnError.
+	OnRequest func(ctx context.Context, method string, request interface{}) context.Context
+	// OnResponse is called after the call succeeds.
+	OnResponse func(ctx context.Context, method string, response interface{}, took time.Duration)
+	// OnError is called after the call fails.
+	OnError func(ctx context.Context, method string, err error, took time.Duration)
+}
+
+// WithHooks calls h around each call, after the Hooks of earlier WithHooks
+// options and outside WithMiddleware's middlewares.
+func WithHooks(h Hooks) Option {
+	return func(o *options) { o.hooks = append(o.hooks, h) }
+}
+
+func hooksMiddleware(hooks []Hooks, method string) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			for _, h := range hooks {
+				if h.OnRequest != nil {
+					ctx = h.OnRequest(ctx, method, request)
+				}
+			}
+			begin := time.Now()
+			response, err := next(ctx, request)
+			took := time.Since(begin)
+			for _, h := range hooks {
+				switch {
+				case err == nil && h.OnResponse != nil:
+					h.OnResponse(ctx, method, response, took)
+				case err != nil && h.OnError != nil:
+					h.OnError(ctx, method, err, took)
+				}
+			}
+			return response, err
+		}
+	}
+}
+
+// MetricsHooks counts calls in requests and records their latency in
+// seconds in duration, both labeled with the method and the call's code:
+// "OK", or the code of the error it failed with.
+func MetricsHooks(requests metrics.Counter, duration metrics.Histogram) Hooks {
+	record := func(method, code string, took time.Duration) {
+		lvs := []string{"method", method, "code", code}
+		requests.With(lvs...).Add(1)
+		duration.With(lvs...).Observe(took.Seconds())
+	}
+	return Hooks{
+		OnResponse: func(_ context.Context, method string, _ interface{}, took time.Duration) {
+			record(method, "OK", took)
+		},
+		OnError: func(_ context.Context, method string, err error, took time.Duration) {
+			record(method, string(svcerrors.CodeOf(err)), took)
+		},
+	}
+}
+
+// PrometheusHooks are MetricsHooks with instruments registered with the
+// default Prometheus registry, as stringsvc_client_requests_total and
+// stringsvc_client_request_duration_seconds. They must be created once
+// per process.
+func PrometheusHooks() Hooks {
+	return MetricsHooks(
+		kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: "stringsvc",
+			Subsystem: "client",
+			Name:      "requests_total",
+			Help:      "Number of calls made to stringsvc.",
+		}, []string{"method", "code"}),
+		kitprometheus.NewHistogramFrom(stdprometheus.HistogramOpts{
+			Namespace: "stringsvc",
+			Subsystem: "client",
+			Name:      "request_duration_seconds",
+			Help:      "Time taken by calls to stringsvc, retries included.",
+			Buckets:   stdprometheus.DefBuckets,
+		}, []string{"method", "code"}),
+	)
+}
+
+// OTelHooks trace each call in a client span named after its method, such
+// as stringsvc.uppercase, and record its duration in seconds in the
+// stringsvc.client.duration histogram. The Client sends the trace context
+// of its calls to the service whether or not they are used.
+func OTelHooks(tp trace.TracerProvider, mp metric.MeterProvider) Hooks {
+	const name = "github.com/mcclayac/gokit/client"
+	tracer := tp.Tracer(name)
+	duration, err := mp.Meter(name).Float64Histogram("stringsvc.client.duration",
+		metric.WithDescription("Time taken by calls to stringsvc, retries included."),
+		metric.WithUnit("s"))
+	if err != nil {
+		duration = nil // an invalid name; only spans are recorded
+	}
+	end := func(ctx context.Context, method, code string, took time.Duration) {
+		if duration != nil {
+			duration.Record(ctx, took.Seconds(), metric.WithAttributes(
+				attribute.String("method", method), attribute.String("code", code)))
+		}
+		trace.SpanFromContext(ctx).End()
+	}
+	return Hooks{
+		OnRequest: func(ctx context.Context, method string, _ interface{}) context.Context {
+			ctx, _ = tracer.Start(ctx, "stringsvc."+method, trace.WithSpanKind(trace.SpanKindClient))
+			return ctx
+		},
+		OnResponse: func(ctx context.Context, method string, _ interface{}, took time.Duration) {
+			end(ctx, method, "OK", took)
+		},
+		OnError: func(ctx context.Context, method string, err error, took time.Duration) {
+			span := trace.SpanFromContext(ctx)
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			end(ctx, method, string(svcerrors.CodeOf(err)), took)
+		},
+	}
+}
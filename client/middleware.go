@@ -0,0 +1,141 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/sony/gobreaker"
+
+	"github.com/mcclayac/gokit/svcerrors"
+)
+
+// ErrCircuitOpen is returned, wrapped, by calls the circuit breaker
+// refuses because the service has been failing.
+var ErrCircuitOpen = errors.New("client: circuit breaker open")
+
+// Timeout bounds each attempt at a call, so that a retry can follow a
+// hung attempt within the caller's own deadline.
+func Timeout(d time.Duration) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			ctx, cancel := context.WithTimeout(ctx, d)
+			defer cancel()
+			return next(ctx, request)
+		}
+	}
+}
+
+// RetryPolicy says how often and when failed calls are tried again.
+type RetryPolicy struct {
+	// MaxAttempts is the most times a call is tried, the first included.
+	// One or less disables retries.
+	MaxAttempts int
+	// The delay before the nth retry is random, up to BaseDelay doubled
+	// n-1 times but never more than MaxDelay ("full jitter"), so that
+	// clients retrying together spread out.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Retryable reports whether a call that failed with err may succeed
+	// if tried again. It defaults to IsRetryable.
+	Retryable func(err error) bool
+}
+
+// DefaultRetryPolicy tries a call three times over about a second.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   100 * time.Millisecond,
+	MaxDelay:    2 * time.Second,
+}
+
+// IsRetryable reports whether a call that failed with err may succeed if
+// tried again: the service's retryable error codes, and failures to reach
+// it at all. Calls refused by the circuit breaker are not retried.
+func IsRetryable(err error) bool {
+	var e *svcerrors.Error
+	switch {
+	case errors.As(err, &e):
+		return svcerrors.Retryable(e.Code)
+	case errors.Is(err, ErrCircuitOpen):
+		return false
+	}
+	return true
+}
+
+func (p RetryPolicy) delay(retry int) time.Duration {
+	d := p.BaseDelay << uint(retry-1)
+	if d > p.MaxDelay || d <= 0 {
+		d = p.MaxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d)))
+}
+
+// Retry tries failed calls again under p, until one succeeds, fails with
+// an error p doesn't retry, or the context is done. Calls the service
+// doesn't treat as idempotent must carry an Idempotency-Key to be retried
+// safely; Client's methods do. Calls made WithoutRetry are tried once.
+func Retry(p RetryPolicy) endpoint.Middleware {
+	retryable := p.Retryable
+	if retryable == nil {
+		retryable = IsRetryable
+	}
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			maxAttempts := p.MaxAttempts
+			if callOptionsFrom(ctx).noRetry {
+				maxAttempts = 1
+			}
+			for attempt := 1; ; attempt++ {
+				response, err := next(ctx, request)
+				if err == nil || attempt >= maxAttempts || !retryable(err) || ctx.Err() != nil {
+					return response, err
+				}
+				t := time.NewTimer(p.delay(attempt))
+				select {
+				case <-ctx.Done():
+					t.Stop()
+					return nil, err
+				case <-t.C:
+				}
+			}
+		}
+	}
+}
+
+// DefaultBreakerSettings opens the breaker after five calls in a row fail
+// and lets a call through to try the service again after 30 seconds.
+var DefaultBreakerSettings = gobreaker.Settings{
+	Name:    "stringsvc",
+	Timeout: 30 * time.Second,
+	ReadyToTrip: func(c gobreaker.Counts) bool {
+		return c.ConsecutiveFailures >= 5
+	},
+}
+
+// Breaker fails calls fast with ErrCircuitOpen while the service is
+// failing. Only failures that IsRetryable counts toward opening it: a
+// call the service rejects as invalid says nothing about its health, and
+// neither does one the caller canceled.
+func Breaker(settings gobreaker.Settings) endpoint.Middleware {
+	settings.IsSuccessful = func(err error) bool {
+		return err == nil || errors.Is(err, context.Canceled) || !IsRetryable(err)
+	}
+	cb := gobreaker.NewCircuitBreaker(settings)
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			response, err := cb.Execute(func() (interface{}, error) {
+				return next(ctx, request)
+			})
+			if err == gobreaker.ErrOpenState || err == gobreaker.ErrTooManyRequests {
+				return nil, fmt.Errorf("%w: %v", ErrCircuitOpen, err)
+			}
+			return response, err
+		}
+	}
+}
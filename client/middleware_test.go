@@ -0,0 +1,71 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/mcclayac/gokit/svcerrors"
+)
+
+// failing returns an endpoint failing with errs in turn, then succeeding,
+// and a count of its calls.
+func failing(errs ...error) (func(context.Context, interface{}) (interface{}, error), *int) {
+	calls := 0
+	return func(context.Context, interface{}) (interface{}, error) {
+		calls++
+		if calls <= len(errs) {
+			return nil, errs[calls-1]
+		}
+		return "ok", nil
+	}, &calls
+}
+
+func TestRetry(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 3}
+	for _, c := range []struct {
+		name  string
+		errs  []error
+		calls int
+		ok    bool
+	}{
+		{"retryable", []error{svcerrors.ErrRateLimited, svcerrors.ErrInternal}, 3, true},
+		{"unreachable", []error{errors.New("connection refused")}, 2, true},
+		{"exhausted", []error{svcerrors.ErrRateLimited, svcerrors.ErrRateLimited, svcerrors.ErrRateLimited}, 3, false},
+		{"invalid", []error{svcerrors.ErrStringEmpty}, 1, false},
+		{"circuit open", []error{ErrCircuitOpen}, 1, false},
+	} {
+		e, calls := failing(c.errs...)
+		_, err := Retry(p)(e)(context.Background(), nil)
+		if *calls != c.calls || (err == nil) != c.ok {
+			t.Errorf("%s: got %d calls, error %v; want %d calls", c.name, *calls, err, c.calls)
+		}
+	}
+
+	e, calls := failing(svcerrors.ErrRateLimited)
+	ctx := context.WithValue(context.Background(), callOptionsKey{}, &callOptions{noRetry: true})
+	if _, err := Retry(p)(e)(ctx, nil); err == nil || *calls != 1 {
+		t.Errorf("WithoutRetry: got %d calls, error %v, want one failed call", *calls, err)
+	}
+}
+
+func TestBreaker(t *testing.T) {
+	b := Breaker(DefaultBreakerSettings)
+
+	invalid, _ := failing(svcerrors.ErrStringEmpty, svcerrors.ErrStringEmpty, svcerrors.ErrStringEmpty,
+		svcerrors.ErrStringEmpty, svcerrors.ErrStringEmpty, svcerrors.ErrStringEmpty)
+	for i := 0; i < 6; i++ {
+		if _, err := b(invalid)(context.Background(), nil); errors.Is(err, ErrCircuitOpen) {
+			t.Fatal("calls rejected as invalid opened the breaker")
+		}
+	}
+
+	down, calls := failing(svcerrors.ErrInternal, svcerrors.ErrInternal, svcerrors.ErrInternal,
+		svcerrors.ErrInternal, svcerrors.ErrInternal)
+	for i := 0; i < 5; i++ {
+		b(down)(context.Background(), nil)
+	}
+	if _, err := b(down)(context.Background(), nil); !errors.Is(err, ErrCircuitOpen) || *calls != 5 {
+		t.Errorf("after five failures: got %v after %d calls, want ErrCircuitOpen without a call", err, *calls)
+	}
+}
@@ -0,0 +1,258 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+)
+
+// Done is returned by an iterator's Next when there are no more results.
+var Done = errors.New("client: no more results")
+
+// pager fetches the pages of a list, following their page tokens.
+type pager struct {
+	ctx   context.Context
+	e     endpoint.Endpoint
+	query url.Values
+	opts  []CallOption
+	token string
+	done  bool
+}
+
+type pageResponse interface {
+	nextPageToken() string
+}
+
+// fetch returns the next page, or Done after the last.
+func (p *pager) fetch() (interface{}, error) {
+	if p.done {
+		return nil, Done
+	}
+	q := url.Values{}
+	for k, v := range p.query {
+		q[k] = v
+	}
+	if p.token != "" {
+		q.Set("page_token", p.token)
+	}
+	resp, err := call(p.ctx, p.e, q, p.opts)
+	if err != nil {
+		return nil, err
+	}
+	p.token = resp.(pageResponse).nextPageToken()
+	p.done = p.token == ""
+	return resp, nil
+}
+
+func setTime(q url.Values, key string, t time.Time) {
+	if !t.IsZero() {
+		q.Set(key, t.Format(time.RFC3339Nano))
+	}
+}
+
+func setPageSize(q url.Values, n int) {
+	if n > 0 {
+		q.Set("limit", strconv.Itoa(n))
+	}
+}
+
+// HistoryRecord is a call recorded in the service's history.
+type HistoryRecord struct {
+	ID         string    `json:"id"`
+	Time       time.Time `json:"time"`
+	Operation  string    `json:"operation"`
+	Identity   string    `json:"identity"`
+	RequestID  string    `json:"request_id,omitempty"`
+	InputHash  string    `json:"input_sha256"`
+	Status     string    `json:"status"`
+	ResultSize int       `json:"result_size"`
+	DurationMS int64     `json:"duration_ms"`
+}
+
+// HistoryQuery selects history records. Zero fields don't filter.
+type HistoryQuery struct {
+	Operation string
+	Identity  string
+	Since     time.Time // inclusive
+	Until     time.Time // exclusive
+	// PageSize is how many records are fetched at a time, the service's
+	// default when zero.
+	PageSize int
+}
+
+type historyPage struct {
+	Records       []HistoryRecord `json:"records"`
+	NextPageToken string          `json:"next_page_token"`
+}
+
+func (p *historyPage) nextPageToken() string { return p.NextPageToken }
+
+// HistoryIterator iterates over history records.
+type HistoryIterator struct {
+	p   *pager
+	buf []HistoryRecord
+}
+
+// NewHistoryIterator returns an iterator over records, such as for a fake
+// Service to return.
+func NewHistoryIterator(records ...HistoryRecord) *HistoryIterator {
+	return &HistoryIterator{p: &pager{done: true}, buf: records}
+}
+
+// Next returns the next record, fetching the next page when it needs to.
+// It returns Done after the last.
+func (it *HistoryIterator) Next() (HistoryRecord, error) {
+	for len(it.buf) == 0 {
+		resp, err := it.p.fetch()
+		if err != nil {
+			return HistoryRecord{}, err
+		}
+		it.buf = resp.(*historyPage).Records
+	}
+	r := it.buf[0]
+	it.buf = it.buf[1:]
+	return r, nil
+}
+
+// ListHistory iterates over the history records matching q, newest first.
+func (c *Client) ListHistory(ctx context.Context, q HistoryQuery, opts ...CallOption) *HistoryIterator {
+	query := url.Values{}
+	if q.Operation != "" {
+		query.Set("operation", q.Operation)
+	}
+	if q.Identity != "" {
+		query.Set("identity", q.Identity)
+	}
+	setTime(query, "since", q.Since)
+	setTime(query, "until", q.Until)
+	setPageSize(query, q.PageSize)
+	return &HistoryIterator{p: &pager{ctx: ctx, e: c.history, query: query, opts: opts}}
+}
+
+// Job is a batch job as listed, without its results.
+type Job struct {
+	ID       string     `json:"id"`
+	Status   string     `json:"status"`
+	Created  time.Time  `json:"created"`
+	Identity string     `json:"identity"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+}
+
+type jobsPage struct {
+	Jobs          []Job  `json:"jobs"`
+	NextPageToken string `json:"next_page_token"`
+}
+
+func (p *jobsPage) nextPageToken() string { return p.NextPageToken }
+
+// JobIterator iterates over jobs.
+type JobIterator struct {
+	p   *pager
+	buf []Job
+}
+
+// NewJobIterator returns an iterator over jobs, such as for a fake Service
+// to return.
+func NewJobIterator(jobs ...Job) *JobIterator {
+	return &JobIterator{p: &pager{done: true}, buf: jobs}
+}
+
+// Next returns the next job, fetching the next page when it needs to. It
+// returns Done after the last.
+func (it *JobIterator) Next() (Job, error) {
+	for len(it.buf) == 0 {
+		resp, err := it.p.fetch()
+		if err != nil {
+			return Job{}, err
+		}
+		it.buf = resp.(*jobsPage).Jobs
+	}
+	j := it.buf[0]
+	it.buf = it.buf[1:]
+	return j, nil
+}
+
+// ListJobs iterates over the jobs the caller submitted that the service
+// still keeps, newest first.
+func (c *Client) ListJobs(ctx context.Context, opts ...CallOption) *JobIterator {
+	return &JobIterator{p: &pager{ctx: ctx, e: c.jobs, query: url.Values{}, opts: opts}}
+}
+
+// AuditRecord is an entry in the service's audit log.
+type AuditRecord struct {
+	Seq       int64     `json:"seq"`
+	Time      time.Time `json:"time"`
+	Identity  string    `json:"identity"`
+	Method    string    `json:"method"`
+	InputHash string    `json:"input_sha256"`
+	Status    string    `json:"status"`
+	RequestID string    `json:"request_id,omitempty"`
+	PrevHash  string    `json:"prev_hash"`
+	Hash      string    `json:"hash"`
+}
+
+// AuditQuery selects audit records. Zero fields don't filter.
+type AuditQuery struct {
+	Identity string
+	Method   string
+	Since    time.Time // inclusive
+	Until    time.Time // exclusive
+	// PageSize is how many records are fetched at a time, the service's
+	// default when zero.
+	PageSize int
+}
+
+type auditPage struct {
+	Records       []AuditRecord `json:"records"`
+	NextPageToken string        `json:"next_page_token"`
+}
+
+func (p *auditPage) nextPageToken() string { return p.NextPageToken }
+
+// AuditIterator iterates over audit records.
+type AuditIterator struct {
+	p   *pager
+	buf []AuditRecord
+}
+
+// NewAuditIterator returns an iterator over records, such as for a fake
+// Service to return.
+func NewAuditIterator(records ...AuditRecord) *AuditIterator {
+	return &AuditIterator{p: &pager{done: true}, buf: records}
+}
+
+// Next returns the next record, fetching the next page when it needs to.
+// It returns Done after the last.
+func (it *AuditIterator) Next() (AuditRecord, error) {
+	for len(it.buf) == 0 {
+		resp, err := it.p.fetch()
+		if err != nil {
+			return AuditRecord{}, err
+		}
+		it.buf = resp.(*auditPage).Records
+	}
+	r := it.buf[0]
+	it.buf = it.buf[1:]
+	return r, nil
+}
+
+// ListAudit iterates over the audit records matching q, oldest first. It
+// needs the service to keep its audit log in a database.
+func (c *Client) ListAudit(ctx context.Context, q AuditQuery, opts ...CallOption) *AuditIterator {
+	query := url.Values{}
+	if q.Identity != "" {
+		query.Set("identity", q.Identity)
+	}
+	if q.Method != "" {
+		query.Set("method", q.Method)
+	}
+	setTime(query, "since", q.Since)
+	setTime(query, "until", q.Until)
+	setPageSize(query, q.PageSize)
+	return &AuditIterator{p: &pager{ctx: ctx, e: c.audit, query: query, opts: opts}}
+}
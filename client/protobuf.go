@@ -0,0 +1,126 @@
+package client
+
+import (
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/mcclayac/gokit/stringsvcpb"
+	"github.com/mcclayac/gokit/svcerrors"
+)
+
+// The protobuf forms of the wire types are the messages of stringsvc.proto,
+// generated into package stringsvcpb and shared with the service, for
+// WithCodec(codec.Protobuf).
+
+// MarshalProto encodes r as an UppercaseRequest, which is the same message
+// as CountRequest.
+func (r stringRequest) MarshalProto() ([]byte, error) {
+	return proto.Marshal(&stringsvcpb.UppercaseRequest{S: r.S})
+}
+
+func (r hashRequest) MarshalProto() ([]byte, error) {
+	return proto.Marshal(&stringsvcpb.HashRequest{Password: r.Password})
+}
+
+func (r verifyRequest) MarshalProto() ([]byte, error) {
+	return proto.Marshal(&stringsvcpb.VerifyRequest{Password: r.Password, Hash: r.Hash})
+}
+
+func (r shortenRequest) MarshalProto() ([]byte, error) {
+	return proto.Marshal(&stringsvcpb.ShortenRequest{Url: r.URL, TtlSeconds: r.TTL})
+}
+
+func (r resolveRequest) MarshalProto() ([]byte, error) {
+	return proto.Marshal(&stringsvcpb.ResolveRequest{Code: r.Code})
+}
+
+func (r detectRequest) MarshalProto() ([]byte, error) {
+	return proto.Marshal(&stringsvcpb.DetectRequest{S: r.S, Max: int64(r.Max)})
+}
+
+func (r *stringResponse) UnmarshalProto(b []byte) error {
+	var m stringsvcpb.UppercaseResponse
+	if err := proto.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	r.V = m.V
+	return nil
+}
+
+func (r *countResponse) UnmarshalProto(b []byte) error {
+	var m stringsvcpb.CountResponse
+	if err := proto.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	r.V = int(m.V)
+	return nil
+}
+
+func (r *hashResponse) UnmarshalProto(b []byte) error {
+	var m stringsvcpb.HashResponse
+	if err := proto.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	r.Hash = m.Hash
+	return nil
+}
+
+func (r *verifyResponse) UnmarshalProto(b []byte) error {
+	var m stringsvcpb.VerifyResponse
+	if err := proto.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	r.V = m.V
+	return nil
+}
+
+func (r *linkResponse) UnmarshalProto(b []byte) error {
+	var m stringsvcpb.LinkResponse
+	if err := proto.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	l := m.GetLink()
+	r.Link = Link{
+		Code:    l.GetCode(),
+		URL:     l.GetUrl(),
+		Created: unixMillis(l.GetCreatedUnixMillis()),
+		Expires: unixMillis(l.GetExpiresUnixMillis()),
+		Hits:    l.GetHits(),
+	}
+	return nil
+}
+
+func (r *detectResponse) UnmarshalProto(b []byte) error {
+	var m stringsvcpb.DetectResponse
+	if err := proto.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	r.Languages = []LanguageGuess{}
+	for _, g := range m.Languages {
+		r.Languages = append(r.Languages, LanguageGuess{Lang: g.Lang, Confidence: g.Confidence})
+	}
+	return nil
+}
+
+func (r *errorResponse) UnmarshalProto(b []byte) error {
+	var m stringsvcpb.ErrorResponse
+	if err := proto.Unmarshal(b, &m); err != nil || m.Err == nil {
+		return err
+	}
+	e := &svcerrors.Error{Code: svcerrors.Code(m.Err.Code), Message: m.Err.Message}
+	for _, f := range m.Err.Fields {
+		e.Fields = append(e.Fields, svcerrors.FieldViolation{Field: f.Field, Rule: f.Rule, Message: f.Message})
+	}
+	r.Err = e
+	return nil
+}
+
+// unixMillis is the time of an int64 field of Unix milliseconds, zero
+// when unset.
+func unixMillis(ms int64) time.Time {
+	if ms == 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms)
+}
@@ -0,0 +1,36 @@
+package client
+
+import (
+	"math/rand"
+	"sort"
+)
+
+// WithSubset makes the client call only size of its instances, chosen by
+// deterministic subsetting: each client in a fleet of them is given a
+// distinct clientID, such as its replica's index, and clients with
+// consecutive IDs take disjoint subsets, so every instance is picked by
+// about as many clients and serves about as much load, while each client
+// keeps connections to only size instances. Every client must be given the
+// same instances, in any order.
+func WithSubset(clientID, size int) Option {
+	return func(o *options) { o.subsetID, o.subsetSize = clientID, size }
+}
+
+// subset returns the size instances that client clientID uses. Clients are
+// taken in rounds of len(instances)/size, each round on the instances
+// shuffled by a seed of its own, so that the instances left over when size
+// doesn't divide their number differ from round to round.
+func subset(instances []string, clientID, size int) []string {
+	if size <= 0 || size >= len(instances) || clientID < 0 {
+		return instances
+	}
+	s := append([]string(nil), instances...)
+	sort.Strings(s)
+	subsets := len(s) / size
+	round := clientID / subsets
+	rand.New(rand.NewSource(int64(round))).Shuffle(len(s), func(i, j int) {
+		s[i], s[j] = s[j], s[i]
+	})
+	first := (clientID % subsets) * size
+	return s[first : first+size]
+}
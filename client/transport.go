@@ -0,0 +1,93 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"time"
+
+	"github.com/go-kit/kit/metrics"
+)
+
+// TransportConfig tunes the connections a Client makes.
+type TransportConfig struct {
+	// MaxIdleConnsPerHost is how many idle connections are kept open to
+	// each instance. net/http keeps two, so a client making more calls at
+	// once than that closes and reopens connections, and under load can
+	// run out of ephemeral ports on connections left in TIME_WAIT.
+	MaxIdleConnsPerHost int
+	// MaxConnsPerHost, if positive, limits the connections to each
+	// instance; calls over the limit wait for one.
+	MaxConnsPerHost int
+	// IdleConnTimeout is how long an idle connection is kept.
+	IdleConnTimeout time.Duration
+	// DialTimeout bounds opening a connection, and KeepAlive is the TCP
+	// keep-alive period of open ones.
+	DialTimeout time.Duration
+	KeepAlive   time.Duration
+	// TLSHandshakeTimeout bounds the TLS handshake.
+	TLSHandshakeTimeout time.Duration
+	// TLSConfig configures TLS to https instances, such as with the CAs
+	// to trust or a client certificate. Nil uses the defaults.
+	TLSConfig *tls.Config
+	// HTTP2 negotiates HTTP/2 with https instances, which then share one
+	// connection to each instance for all calls.
+	HTTP2 bool
+}
+
+// DefaultTransportConfig keeps enough idle connections for a busy client.
+var DefaultTransportConfig = TransportConfig{
+	MaxIdleConnsPerHost: 100,
+	IdleConnTimeout:     90 * time.Second,
+	DialTimeout:         5 * time.Second,
+	KeepAlive:           30 * time.Second,
+	TLSHandshakeTimeout: 5 * time.Second,
+	HTTP2:               true,
+}
+
+// WithTransport makes connections as cfg says, in place of
+// DefaultTransportConfig. It is ignored after WithHTTPClient.
+func WithTransport(cfg TransportConfig) Option {
+	return func(o *options) { o.transport = cfg }
+}
+
+// WithConnMetrics counts the connections calls use: opened, for each new
+// connection, and reused, for each call that took an idle one. Few reuses
+// against many opens means MaxIdleConnsPerHost is too low.
+func WithConnMetrics(opened, reused metrics.Counter) Option {
+	return func(o *options) { o.opened, o.reused = opened, reused }
+}
+
+func newTransport(cfg TransportConfig) *http.Transport {
+	dialer := &net.Dialer{Timeout: cfg.DialTimeout, KeepAlive: cfg.KeepAlive}
+	return &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		DialContext:         dialer.DialContext,
+		MaxIdleConns:        0, // no limit across instances
+		MaxIdleConnsPerHost: cfg.MaxIdleConnsPerHost,
+		MaxConnsPerHost:     cfg.MaxConnsPerHost,
+		IdleConnTimeout:     cfg.IdleConnTimeout,
+		TLSHandshakeTimeout: cfg.TLSHandshakeTimeout,
+		TLSClientConfig:     cfg.TLSConfig,
+		ForceAttemptHTTP2:   cfg.HTTP2,
+	}
+}
+
+// traceConns returns a ClientBefore func that counts the connections
+// requests get.
+func traceConns(opened, reused metrics.Counter) func(context.Context, *http.Request) context.Context {
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				reused.Add(1)
+			} else {
+				opened.Add(1)
+			}
+		},
+	}
+	return func(ctx context.Context, _ *http.Request) context.Context {
+		return httptrace.WithClientTrace(ctx, trace)
+	}
+}
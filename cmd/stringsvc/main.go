@@ -0,0 +1,13 @@
+// Command stringsvc serves the string service; run stringsvc help for its
+// subcommands.
+package main
+
+import (
+	"os"
+
+	stringsvc "github.com/mcclayac/gokit"
+)
+
+func main() {
+	os.Exit(stringsvc.Main(os.Args[1:]))
+}
@@ -0,0 +1,63 @@
+package codec
+
+import (
+	"io"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// CBOR encodes values as CBOR (RFC 8949) maps keyed by their JSON field
+// names, so the same struct tags describe both formats, and times as RFC
+// 3339 strings, as in JSON.
+var CBOR Codec = CBORCodec{}
+
+// CBORCodec reads and writes CBOR. With Canonical set, encoding is core
+// deterministic (RFC 8949 section 4.2.1): map keys are sorted and numbers
+// take their shortest form, so equal values always encode to the same
+// bytes, for clients that sign or hash payloads. Decoding accepts either,
+// and bounds nesting and lengths so a small hostile body can't make the
+// decoder allocate without limit.
+type CBORCodec struct {
+	Canonical bool
+}
+
+var (
+	cborEnc, cborCanonicalEnc cbor.EncMode
+	cborDec                   cbor.DecMode
+)
+
+func init() {
+	opts := cbor.EncOptions{Time: cbor.TimeRFC3339Nano}
+	canonical := cbor.CoreDetEncOptions()
+	canonical.Time = cbor.TimeRFC3339Nano
+	dec := cbor.DecOptions{
+		DupMapKey:        cbor.DupMapKeyEnforcedAPF,
+		MaxNestedLevels:  16,
+		MaxArrayElements: 65536,
+		MaxMapPairs:      4096,
+		IndefLength:      cbor.IndefLengthAllowed,
+	}
+	var err error
+	if cborEnc, err = opts.EncMode(); err != nil {
+		panic(err)
+	}
+	if cborCanonicalEnc, err = canonical.EncMode(); err != nil {
+		panic(err)
+	}
+	if cborDec, err = dec.DecMode(); err != nil {
+		panic(err)
+	}
+}
+
+func (CBORCodec) ContentType() string { return "application/cbor" }
+
+func (CBORCodec) Decode(r io.Reader, v interface{}) error {
+	return cborDec.NewDecoder(r).Decode(v)
+}
+
+func (c CBORCodec) Encode(w io.Writer, v interface{}) error {
+	if c.Canonical {
+		return cborCanonicalEnc.NewEncoder(w).Encode(v)
+	}
+	return cborEnc.NewEncoder(w).Encode(v)
+}
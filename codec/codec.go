@@ -0,0 +1,145 @@
+// Package codec provides the wire formats the transports can speak and a
+// Registry that picks one from the Content-Type and Accept headers.
+package codec
+
+import (
+	"io"
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mcclayac/gokit/svcerrors"
+)
+
+// Codec reads and writes values in a single wire format.
+type Codec interface {
+	// ContentType is the value written to the Content-Type header of
+	// responses encoded by the codec.
+	ContentType() string
+	Decode(r io.Reader, v interface{}) error
+	Encode(w io.Writer, v interface{}) error
+}
+
+// Registry maps media types to codecs. The first codec registered is the
+// default, used when a request names no Content-Type or accepts anything.
+type Registry struct {
+	byType map[string]Codec
+	def    Codec
+}
+
+// NewRegistry returns a Registry with def as the default codec and others
+// registered under their own content types.
+func NewRegistry(def Codec, others ...Codec) *Registry {
+	r := &Registry{byType: map[string]Codec{}}
+	r.Register(def)
+	for _, c := range others {
+		r.Register(c)
+	}
+	return r
+}
+
+// Register adds c under its content type and any aliases, replacing the
+// codec previously registered for them. Replacing the default codec's
+// content type makes c the new default.
+func (r *Registry) Register(c Codec, aliases ...string) {
+	for _, t := range append([]string{c.ContentType()}, aliases...) {
+		mt := mediaType(t)
+		if r.def == nil || mt == mediaType(r.def.ContentType()) {
+			r.def = c
+		}
+		r.byType[mt] = c
+	}
+}
+
+// Default returns the default codec.
+func (r *Registry) Default() Codec {
+	return r.def
+}
+
+// ForContentType returns the codec for a request's Content-Type header. An
+// empty header selects the default codec.
+func (r *Registry) ForContentType(contentType string) (Codec, error) {
+	if contentType == "" {
+		return r.def, nil
+	}
+	if c, ok := r.byType[mediaType(contentType)]; ok {
+		return c, nil
+	}
+	return nil, svcerrors.Errorf(svcerrors.CodeUnsupportedMediaType, "unsupported content type %q", contentType)
+}
+
+// ForAccept returns the most preferred codec acceptable to a request's Accept
+// header. An empty header, or one that accepts */*, selects the default codec
+// unless a registered type is preferred explicitly.
+func (r *Registry) ForAccept(accept string) (Codec, error) {
+	if strings.TrimSpace(accept) == "" {
+		return r.def, nil
+	}
+	for _, rng := range parseAccept(accept) {
+		switch {
+		case rng.q == 0:
+			continue
+		case rng.mediaType == "*/*":
+			return r.def, nil
+		case strings.HasSuffix(rng.mediaType, "/*"):
+			prefix := strings.TrimSuffix(rng.mediaType, "*")
+			if strings.HasPrefix(mediaType(r.def.ContentType()), prefix) {
+				return r.def, nil
+			}
+			for _, t := range r.sortedTypes() {
+				if strings.HasPrefix(t, prefix) {
+					return r.byType[t], nil
+				}
+			}
+		default:
+			if c, ok := r.byType[rng.mediaType]; ok {
+				return c, nil
+			}
+		}
+	}
+	return nil, svcerrors.Errorf(svcerrors.CodeNotAcceptable, "no acceptable content type in %q", accept)
+}
+
+func (r *Registry) sortedTypes() []string {
+	types := make([]string, 0, len(r.byType))
+	for t := range r.byType {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+type acceptRange struct {
+	mediaType string
+	q         float64
+}
+
+// parseAccept returns the media ranges in an Accept header, most preferred
+// first. Ranges with equal quality keep their order in the header.
+func parseAccept(accept string) []acceptRange {
+	var ranges []acceptRange
+	for _, part := range strings.Split(accept, ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if s, ok := params["q"]; ok {
+			if f, err := strconv.ParseFloat(s, 64); err == nil {
+				q = f
+			}
+		}
+		ranges = append(ranges, acceptRange{mt, q})
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].q > ranges[j].q })
+	return ranges
+}
+
+func mediaType(contentType string) string {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(contentType))
+	}
+	return mt
+}
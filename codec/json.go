@@ -0,0 +1,39 @@
+package codec
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+// JSON is the default, lenient JSON codec.
+var JSON Codec = JSONCodec{}
+
+// JSONCodec reads and writes JSON. With Strict set, decoding rejects objects
+// with fields the target type doesn't have and bodies with anything but
+// whitespace after the first value, instead of silently ignoring them.
+type JSONCodec struct {
+	Strict bool
+}
+
+func (JSONCodec) ContentType() string { return "application/json; charset=utf-8" }
+
+func (c JSONCodec) Decode(r io.Reader, v interface{}) error {
+	dec := json.NewDecoder(r)
+	if c.Strict {
+		dec.DisallowUnknownFields()
+	}
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if c.Strict {
+		if err := dec.Decode(&struct{}{}); err != io.EOF {
+			return errors.New("json: unexpected data after top-level value")
+		}
+	}
+	return nil
+}
+
+func (JSONCodec) Encode(w io.Writer, v interface{}) error {
+	return json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,62 @@
+package codec
+
+import (
+	"fmt"
+	"io"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// ProtoMarshaler is implemented by types that can write themselves in the
+// protocol buffer wire format without generated code.
+type ProtoMarshaler interface {
+	MarshalProto() ([]byte, error)
+}
+
+// ProtoUnmarshaler is the decoding counterpart of ProtoMarshaler.
+type ProtoUnmarshaler interface {
+	UnmarshalProto([]byte) error
+}
+
+// Protobuf encodes generated proto.Message values and types implementing
+// ProtoMarshaler and ProtoUnmarshaler.
+var Protobuf Codec = protobufCodec{}
+
+type protobufCodec struct{}
+
+func (protobufCodec) ContentType() string { return "application/x-protobuf" }
+
+func (protobufCodec) Decode(r io.Reader, v interface{}) error {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	switch m := v.(type) {
+	case proto.Message:
+		return proto.Unmarshal(b, m)
+	case ProtoUnmarshaler:
+		return m.UnmarshalProto(b)
+	default:
+		return fmt.Errorf("%T cannot be decoded from protobuf", v)
+	}
+}
+
+func (protobufCodec) Encode(w io.Writer, v interface{}) error {
+	var (
+		b   []byte
+		err error
+	)
+	switch m := v.(type) {
+	case proto.Message:
+		b, err = proto.Marshal(m)
+	case ProtoMarshaler:
+		b, err = m.MarshalProto()
+	default:
+		return fmt.Errorf("%T cannot be encoded as protobuf", v)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
@@ -0,0 +1,21 @@
+package codec
+
+import (
+	"encoding/xml"
+	"io"
+)
+
+// XML encodes values with encoding/xml. It is also registered for text/xml.
+var XML Codec = xmlCodec{}
+
+type xmlCodec struct{}
+
+func (xmlCodec) ContentType() string { return "application/xml; charset=utf-8" }
+
+func (xmlCodec) Decode(r io.Reader, v interface{}) error {
+	return xml.NewDecoder(r).Decode(v)
+}
+
+func (xmlCodec) Encode(w io.Writer, v interface{}) error {
+	return xml.NewEncoder(w).Encode(v)
+}
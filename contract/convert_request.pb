@@ -0,0 +1,3 @@
+
+2024-03-01T12:00:00ZUTC
+Asia/Tokyo
\ No newline at end of file
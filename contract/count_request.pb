@@ -0,0 +1,2 @@
+
+hello
\ No newline at end of file
@@ -0,0 +1,2 @@
+
+bonjour tout le monde
\ No newline at end of file
@@ -0,0 +1,5 @@
+
+
+fr(\?
+
+it{Gz?
\ No newline at end of file
@@ -0,0 +1,4 @@
+
+A
+INVALID_ARGUMENTinvalid request
+srequireds is required
\ No newline at end of file
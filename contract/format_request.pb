@@ -0,0 +1,2 @@
+
+2024-03-01T12:00:00ZMon Jan 2 15:04UTC
\ No newline at end of file
@@ -0,0 +1,2 @@
+
+correct horse
\ No newline at end of file
@@ -0,0 +1,2 @@
+
+stringsvc-0
\ No newline at end of file
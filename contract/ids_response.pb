@@ -0,0 +1,3 @@
+
+01HQ8Z4X2N0000000000000000
+01HQ8Z4X2N0000000000000001
\ No newline at end of file
@@ -0,0 +1,3 @@
+
+9
+abc123https://example.com/a/long/path1 1(
\ No newline at end of file
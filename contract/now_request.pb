@@ -0,0 +1,2 @@
+
+Europe/Paris
\ No newline at end of file
@@ -0,0 +1,2 @@
+
+https://example.comsvg"M
\ No newline at end of file
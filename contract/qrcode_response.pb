@@ -0,0 +1,3 @@
+
+:
+image/svg+xml)<svg xmlns="http://www.w3.org/2000/svg"/>
\ No newline at end of file
@@ -0,0 +1,2 @@
+
+abc123
\ No newline at end of file
@@ -0,0 +1,2 @@
+
+tok_abc
\ No newline at end of file
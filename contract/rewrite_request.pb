@@ -0,0 +1,2 @@
+
+hey, send it overformal
\ No newline at end of file
@@ -0,0 +1,2 @@
+
+https://example.com/a/long/path
\ No newline at end of file
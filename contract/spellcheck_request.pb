@@ -0,0 +1,2 @@
+
+	helo wrld
\ No newline at end of file
@@ -0,0 +1,5 @@
+
+
+helohellohelp
+
+wrldworld
\ No newline at end of file
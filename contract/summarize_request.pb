@@ -0,0 +1,2 @@
+
+A long text.d
\ No newline at end of file
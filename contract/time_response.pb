@@ -0,0 +1,2 @@
+
+2024-03-01T21:00:00+09:00
\ No newline at end of file
@@ -0,0 +1,2 @@
+
+reportss3cret	uppercasecount 
\ No newline at end of file
@@ -0,0 +1,2 @@
+
+tok_abcBearer"	uppercase"count
\ No newline at end of file
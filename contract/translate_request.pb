@@ -0,0 +1,2 @@
+
+helloende
\ No newline at end of file
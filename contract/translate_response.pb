@@ -0,0 +1,2 @@
+
+halloendeepl
\ No newline at end of file
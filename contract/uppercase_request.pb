@@ -0,0 +1,2 @@
+
+hello, world
\ No newline at end of file
@@ -0,0 +1,2 @@
+
+HELLO, WORLD
\ No newline at end of file
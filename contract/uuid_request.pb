@@ -0,0 +1,2 @@
+
+v7
\ No newline at end of file
@@ -0,0 +1,2 @@
+
+correct horse6$argon2id$v=19$m=65536,t=1,p=4$c2FsdHNhbHQ$aGFzaGhhc2g
\ No newline at end of file
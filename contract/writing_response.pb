@@ -0,0 +1,2 @@
+
+Could you please send it?
\ No newline at end of file
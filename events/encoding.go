@@ -0,0 +1,104 @@
+package events
+
+import (
+	"encoding/binary"
+	"encoding/json"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// An Encoder turns events into message payloads.
+type Encoder interface {
+	Encode(e Event) ([]byte, error)
+}
+
+// JSON encodes events as JSON objects. It is the default encoding.
+var JSON Encoder = jsonEncoder{}
+
+type jsonEncoder struct{}
+
+func (jsonEncoder) Encode(e Event) ([]byte, error) {
+	return json.Marshal(e)
+}
+
+// AvroSchema is the Avro schema of Event.
+const AvroSchema = `{
+  "type": "record",
+  "name": "Event",
+  "namespace": "stringsvc.events",
+  "fields": [
+    {"name": "id", "type": "string"},
+    {"name": "operation", "type": "string"},
+    {"name": "input_sha256", "type": "string"},
+    {"name": "result_size", "type": "long"},
+    {"name": "status", "type": "string"},
+    {"name": "identity", "type": "string"},
+    {"name": "request_id", "type": "string", "default": ""},
+    {"name": "time", "type": {"type": "long", "logicalType": "timestamp-millis"}}
+  ]
+}`
+
+// ProtobufSchema is the Protobuf schema of Event.
+const ProtobufSchema = `syntax = "proto3";
+
+package stringsvc.events;
+
+message Event {
+  string id = 1;
+  string operation = 2;
+  string input_sha256 = 3;
+  int64 result_size = 4;
+  string status = 5;
+  string identity = 6;
+  string request_id = 7;
+  int64 time_unix_millis = 8;
+}
+`
+
+// EventRecordName is the fully qualified name of Event in both schemas.
+const EventRecordName = "stringsvc.events.Event"
+
+// appendAvro appends the Avro binary encoding of e, following AvroSchema.
+// Avro longs, including string lengths, are zigzag varints, which is what
+// binary.AppendVarint writes.
+func appendAvro(b []byte, e Event) []byte {
+	str := func(b []byte, s string) []byte {
+		return append(binary.AppendVarint(b, int64(len(s))), s...)
+	}
+	b = str(b, e.ID)
+	b = str(b, e.Operation)
+	b = str(b, e.InputHash)
+	b = binary.AppendVarint(b, int64(e.ResultSize))
+	b = str(b, e.Status)
+	b = str(b, e.Identity)
+	b = str(b, e.RequestID)
+	return binary.AppendVarint(b, e.Time.UnixMilli())
+}
+
+// appendProtobuf appends the Protobuf encoding of e, following
+// ProtobufSchema. Like the service's own messages, it is written with
+// protowire rather than generated.
+func appendProtobuf(b []byte, e Event) []byte {
+	str := func(b []byte, num protowire.Number, s string) []byte {
+		if s == "" {
+			return b
+		}
+		b = protowire.AppendTag(b, num, protowire.BytesType)
+		return protowire.AppendString(b, s)
+	}
+	i64 := func(b []byte, num protowire.Number, v int64) []byte {
+		if v == 0 {
+			return b
+		}
+		b = protowire.AppendTag(b, num, protowire.VarintType)
+		return protowire.AppendVarint(b, uint64(v))
+	}
+	b = str(b, 1, e.ID)
+	b = str(b, 2, e.Operation)
+	b = str(b, 3, e.InputHash)
+	b = i64(b, 4, int64(e.ResultSize))
+	b = str(b, 5, e.Status)
+	b = str(b, 6, e.Identity)
+	b = str(b, 7, e.RequestID)
+	return i64(b, 8, e.Time.UnixMilli())
+}
@@ -0,0 +1,29 @@
+// Package events publishes a record of each completed operation for
+// downstream consumers such as usage analytics.
+package events
+
+import (
+	"context"
+	"time"
+)
+
+// Event describes one completed operation. It deliberately carries no user
+// content: the input is identified only by its hash.
+type Event struct {
+	ID         string    `json:"id"`
+	Operation  string    `json:"operation"`
+	InputHash  string    `json:"input_sha256"`
+	ResultSize int       `json:"result_size"`
+	Status     string    `json:"status"`
+	Identity   string    `json:"identity"`
+	RequestID  string    `json:"request_id,omitempty"`
+	Time       time.Time `json:"time"`
+}
+
+// Publisher sends events to a broker. Publish may return before the event
+// is delivered; delivery failures are then only visible in the publisher's
+// metrics and logs.
+type Publisher interface {
+	Publish(ctx context.Context, e Event) error
+	Close() error
+}
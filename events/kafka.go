@@ -0,0 +1,75 @@
+package events
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/go-kit/kit/metrics"
+	"github.com/segmentio/kafka-go"
+)
+
+// KafkaConfig configures a KafkaPublisher.
+type KafkaConfig struct {
+	Brokers      []string
+	Topic        string
+	BatchSize    int           // events per produce request
+	BatchTimeout time.Duration // longest an event waits for its batch to fill
+	Sync         bool          // Publish waits for the brokers to acknowledge the event
+	Encoder      Encoder       // JSON when nil
+}
+
+// KafkaPublisher publishes events to a Kafka topic, keyed by caller
+// identity so each caller's events stay ordered within a partition. Events
+// are batched and, unless the publisher is synchronous, sent in the
+// background.
+type KafkaPublisher struct {
+	w   *kafka.Writer
+	enc Encoder
+}
+
+// NewKafkaPublisher returns a publisher for cfg. Every event is counted in
+// delivered or failed once the broker has acknowledged or rejected it.
+func NewKafkaPublisher(cfg KafkaConfig, delivered, failed metrics.Counter, logger log.Logger) *KafkaPublisher {
+	enc := cfg.Encoder
+	if enc == nil {
+		enc = JSON
+	}
+	return &KafkaPublisher{enc: enc, w: &kafka.Writer{
+		Addr:         kafka.TCP(cfg.Brokers...),
+		Topic:        cfg.Topic,
+		Balancer:     &kafka.Hash{},
+		BatchSize:    cfg.BatchSize,
+		BatchTimeout: cfg.BatchTimeout,
+		RequiredAcks: kafka.RequireAll,
+		Async:        !cfg.Sync,
+		Completion: func(messages []kafka.Message, err error) {
+			if err != nil {
+				failed.Add(float64(len(messages)))
+				level.Error(logger).Log("msg", "delivering events", "topic", cfg.Topic, "events", len(messages), "err", err)
+				return
+			}
+			delivered.Add(float64(len(messages)))
+		},
+	}}
+}
+
+// Publish queues e for delivery. A synchronous publisher returns once the
+// brokers have acknowledged it, or with the reason they didn't.
+func (p *KafkaPublisher) Publish(ctx context.Context, e Event) error {
+	b, err := p.enc.Encode(e)
+	if err != nil {
+		return err
+	}
+	return p.w.WriteMessages(ctx, kafka.Message{
+		Key:   []byte(e.Identity),
+		Value: b,
+		Time:  e.Time,
+	})
+}
+
+// Close flushes queued events and closes the connection to the brokers.
+func (p *KafkaPublisher) Close() error {
+	return p.w.Close()
+}
@@ -0,0 +1,156 @@
+package events
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// OutboxSchema creates the table Outbox writes to. It is PostgreSQL.
+const OutboxSchema = `CREATE TABLE IF NOT EXISTS event_outbox (
+	id         TEXT PRIMARY KEY,
+	payload    JSONB NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+)`
+
+// Outbox is a Publisher that stores events in the event_outbox table
+// instead of sending them, so that an event commits or rolls back with the
+// transaction that records what it describes. Relay sends stored events on
+// to a broker.
+type Outbox struct {
+	db *sql.DB
+}
+
+// NewOutbox returns an Outbox writing to db, creating the table if needed.
+func NewOutbox(ctx context.Context, db *sql.DB) (*Outbox, error) {
+	if _, err := db.ExecContext(ctx, OutboxSchema); err != nil {
+		return nil, err
+	}
+	return &Outbox{db}, nil
+}
+
+// Publish stores e in a transaction of its own.
+func (o *Outbox) Publish(ctx context.Context, e Event) error {
+	return o.insert(ctx, o.db, e)
+}
+
+// PublishTx stores e as part of tx.
+func (o *Outbox) PublishTx(ctx context.Context, tx *sql.Tx, e Event) error {
+	return o.insert(ctx, tx, e)
+}
+
+// Close does nothing; the database belongs to the caller.
+func (o *Outbox) Close() error {
+	return nil
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+func (o *Outbox) insert(ctx context.Context, db execer, e Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, `INSERT INTO event_outbox (id, payload) VALUES ($1, $2)`, e.ID, b)
+	return err
+}
+
+// Relay sends stored events to pub until ctx is done, polling every
+// interval for up to batch events at a time. An event is deleted from the
+// outbox in the same transaction that claimed it, after pub has accepted
+// it, so pub must be synchronous. Several relays may run against one
+// outbox; each event is claimed by one of them. A relay that dies between
+// publishing an event and committing leaves the event to be published
+// again, so consumers should discard events whose ID they have seen.
+func (o *Outbox) Relay(ctx context.Context, pub Publisher, interval time.Duration, batch int, logger log.Logger) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		for {
+			n, err := o.relayBatch(ctx, pub, batch)
+			if err != nil && ctx.Err() == nil {
+				level.Error(logger).Log("msg", "relaying events", "err", err)
+			}
+			if err != nil || n < batch {
+				break
+			}
+		}
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// relayBatch publishes one batch and returns how many events it claimed.
+// The events are published concurrently, so that a publisher that batches
+// internally can send them together.
+func (o *Outbox) relayBatch(ctx context.Context, pub Publisher, batch int) (int, error) {
+	tx, err := o.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `SELECT id, payload FROM event_outbox
+		ORDER BY created_at LIMIT $1 FOR UPDATE SKIP LOCKED`, batch)
+	if err != nil {
+		return 0, err
+	}
+	var claimed []Event
+	for rows.Next() {
+		var (
+			id      string
+			payload []byte
+			e       Event
+		)
+		if err := rows.Scan(&id, &payload); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if err := json.Unmarshal(payload, &e); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		claimed = append(claimed, e)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	errs := make([]error, len(claimed))
+	var wg sync.WaitGroup
+	for i, e := range claimed {
+		wg.Add(1)
+		go func(i int, e Event) {
+			defer wg.Done()
+			errs[i] = pub.Publish(ctx, e)
+		}(i, e)
+	}
+	wg.Wait()
+
+	var firstErr error
+	for i, e := range claimed {
+		if errs[i] != nil {
+			if firstErr == nil {
+				firstErr = errs[i]
+			}
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM event_outbox WHERE id = $1`, e.ID); err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return len(claimed), firstErr
+}
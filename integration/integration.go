@@ -0,0 +1,175 @@
+//go:build integration
+
+// Package integration runs the service end to end against real
+// dependencies, started in containers with testcontainers-go: Postgres for
+// persistence, Redis for shared storage and Kafka for call events. It needs
+// Docker, so it is only built with the integration build tag, and
+// TestIntegration runs every scenario:
+//
+//	go test -tags integration ./integration/
+//
+// Each scenario starts the dependencies it needs and one or more service
+// instances through stringsvctest, and talks to them over HTTP with the
+// client package, so the transports, the stores and the event pipeline are
+// all exercised as in production. Env can also be used directly to write
+// other scenarios.
+//
+// The service has no service discovery, so there is no Consul here.
+package integration
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/modules/kafka"
+	"github.com/testcontainers/testcontainers-go/modules/postgres"
+	"github.com/testcontainers/testcontainers-go/modules/redis"
+
+	"github.com/mcclayac/gokit/stringsvctest"
+)
+
+// The images the dependencies run. Override them, before calling Start,
+// to test against other versions.
+var (
+	PostgresImage = "postgres:16-alpine"
+	RedisImage    = "redis:7-alpine"
+	KafkaImage    = "confluentinc/confluent-local:7.6.0"
+)
+
+// Dependency is a dependency Start can run.
+type Dependency string
+
+const (
+	Postgres Dependency = "postgres"
+	Redis    Dependency = "redis"
+	Kafka    Dependency = "kafka"
+)
+
+// startTimeout bounds starting each container, pulling its image included.
+const startTimeout = 3 * time.Minute
+
+// Env is a set of running dependencies. The fields of those not started
+// are empty.
+type Env struct {
+	PostgresDSN  string
+	RedisAddr    string
+	KafkaBrokers []string
+}
+
+// Start runs deps in containers, which are removed when the test ends. It
+// skips the test when -short is set, since pulling images can take minutes.
+func Start(t testing.TB, deps ...Dependency) *Env {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("integration: skipped with -short")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), startTimeout*time.Duration(len(deps)))
+	defer cancel()
+	e := &Env{}
+	for _, d := range deps {
+		var err error
+		switch d {
+		case Postgres:
+			err = e.startPostgres(ctx, t)
+		case Redis:
+			err = e.startRedis(ctx, t)
+		case Kafka:
+			err = e.startKafka(ctx, t)
+		default:
+			err = fmt.Errorf("unknown dependency %q", d)
+		}
+		if err != nil {
+			t.Fatalf("integration: starting %s: %v", d, err)
+		}
+	}
+	return e
+}
+
+// terminateOnCleanup removes c when the test ends.
+func terminateOnCleanup(t testing.TB, c testcontainers.Container) {
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := c.Terminate(ctx); err != nil {
+			t.Logf("integration: removing container: %v", err)
+		}
+	})
+}
+
+func (e *Env) startPostgres(ctx context.Context, t testing.TB) error {
+	c, err := postgres.Run(ctx, PostgresImage,
+		postgres.WithDatabase("stringsvc"),
+		postgres.WithUsername("stringsvc"),
+		postgres.WithPassword("stringsvc"),
+		postgres.BasicWaitStrategies(),
+	)
+	if c != nil {
+		terminateOnCleanup(t, c)
+	}
+	if err != nil {
+		return err
+	}
+	e.PostgresDSN, err = c.ConnectionString(ctx, "sslmode=disable")
+	return err
+}
+
+func (e *Env) startRedis(ctx context.Context, t testing.TB) error {
+	c, err := redis.Run(ctx, RedisImage)
+	if c != nil {
+		terminateOnCleanup(t, c)
+	}
+	if err != nil {
+		return err
+	}
+	host, err := c.Host(ctx)
+	if err != nil {
+		return err
+	}
+	port, err := c.MappedPort(ctx, "6379/tcp")
+	if err != nil {
+		return err
+	}
+	e.RedisAddr = net.JoinHostPort(host, port.Port())
+	return nil
+}
+
+func (e *Env) startKafka(ctx context.Context, t testing.TB) error {
+	c, err := kafka.Run(ctx, KafkaImage, kafka.WithClusterID("stringsvc"))
+	if c != nil {
+		terminateOnCleanup(t, c)
+	}
+	if err != nil {
+		return err
+	}
+	e.KafkaBrokers, err = c.Brokers(ctx)
+	return err
+}
+
+// Flags returns the service flags that point it at the dependencies
+// running: Postgres for persistence, Redis for storage when there is no
+// Postgres, and Kafka for call events.
+func (e *Env) Flags() []string {
+	var args []string
+	switch {
+	case e.PostgresDSN != "":
+		args = append(args, "-postgres-dsn", e.PostgresDSN)
+	case e.RedisAddr != "":
+		args = append(args, "-storage", "redis", "-storage-redis-addr", e.RedisAddr)
+	}
+	if len(e.KafkaBrokers) > 0 {
+		args = append(args, "-kafka-brokers", strings.Join(e.KafkaBrokers, ","))
+	}
+	return args
+}
+
+// StartServer starts a service instance using the dependencies, as
+// configured by Flags and then opts.
+func (e *Env) StartServer(t testing.TB, opts ...stringsvctest.Option) *stringsvctest.Server {
+	t.Helper()
+	return stringsvctest.StartTestServer(t, append([]stringsvctest.Option{stringsvctest.WithFlags(e.Flags()...)}, opts...)...)
+}
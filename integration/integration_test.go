@@ -0,0 +1,12 @@
+//go:build integration
+
+package integration
+
+import "testing"
+
+func TestIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts containers")
+	}
+	Run(t)
+}
@@ -0,0 +1,109 @@
+//go:build integration
+
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+
+	"github.com/mcclayac/gokit/events"
+	"github.com/mcclayac/gokit/stringsvctest"
+)
+
+// callTimeout bounds each call a scenario makes.
+const callTimeout = 30 * time.Second
+
+// Run runs every scenario as a subtest of t, each with its own
+// dependencies.
+func Run(t *testing.T) {
+	t.Run("postgres persistence", PostgresPersistence)
+	t.Run("redis shared storage", RedisSharedStorage)
+	t.Run("kafka events", KafkaEvents)
+}
+
+// PostgresPersistence checks that a short link is kept in the database,
+// not by the instance that created it: a second instance on the same
+// database, which it migrates first, resolves it.
+func PostgresPersistence(t *testing.T) {
+	env := Start(t, Postgres)
+	ctx, cancel := context.WithTimeout(context.Background(), callTimeout)
+	defer cancel()
+
+	first := env.StartServer(t)
+	link, err := first.Client.Shorten(ctx, "https://example.com/persisted", time.Hour)
+	if err != nil {
+		t.Fatalf("shortening: %v", err)
+	}
+
+	second := env.StartServer(t)
+	got, err := second.Client.Resolve(ctx, link.Code)
+	if err != nil {
+		t.Fatalf("resolving %s on a second instance: %v", link.Code, err)
+	}
+	if got.URL != link.URL {
+		t.Errorf("resolved %s to %q, want %q", link.Code, got.URL, link.URL)
+	}
+}
+
+// RedisSharedStorage checks that replicas sharing Redis storage see each
+// other's links, and count hits together.
+func RedisSharedStorage(t *testing.T) {
+	env := Start(t, Redis)
+	ctx, cancel := context.WithTimeout(context.Background(), callTimeout)
+	defer cancel()
+
+	a, b := env.StartServer(t), env.StartServer(t)
+	link, err := a.Client.Shorten(ctx, "https://example.com/shared", 0)
+	if err != nil {
+		t.Fatalf("shortening: %v", err)
+	}
+	for _, srv := range []*stringsvctest.Server{a, b} {
+		if _, err := srv.Client.Resolve(ctx, link.Code); err != nil {
+			t.Fatalf("resolving %s on %s: %v", link.Code, srv.URL, err)
+		}
+	}
+	got, err := b.Client.Resolve(ctx, link.Code)
+	if err != nil {
+		t.Fatalf("resolving %s: %v", link.Code, err)
+	}
+	if got.Hits != 3 {
+		t.Errorf("%s has %d hits, want 3", link.Code, got.Hits)
+	}
+}
+
+// KafkaEvents checks that a call publishes its event to Kafka.
+func KafkaEvents(t *testing.T) {
+	env := Start(t, Kafka)
+	const topic = "stringsvc.integration"
+	ctx, cancel := context.WithTimeout(context.Background(), callTimeout)
+	defer cancel()
+
+	srv := env.StartServer(t, stringsvctest.WithFlags("-kafka-topic", topic, "-kafka-batch-timeout", "10ms"))
+	if _, err := srv.Client.Uppercase(ctx, "hello"); err != nil {
+		t.Fatalf("uppercasing: %v", err)
+	}
+
+	r := kafka.NewReader(kafka.ReaderConfig{Brokers: env.KafkaBrokers, Topic: topic, MaxWait: 100 * time.Millisecond})
+	defer r.Close()
+	for {
+		m, err := r.ReadMessage(ctx)
+		if err != nil {
+			t.Fatalf("no uppercase event read from %s: %v", topic, err)
+		}
+		var e events.Event
+		if err := json.Unmarshal(m.Value, &e); err != nil {
+			t.Fatalf("decoding event %s: %v", m.Value, err)
+		}
+		if e.Operation != "uppercase" {
+			continue
+		}
+		if e.Status != "OK" {
+			t.Errorf("uppercase event has status %q, want OK", e.Status)
+		}
+		return
+	}
+}
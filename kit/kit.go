@@ -0,0 +1,322 @@
+// Package kit assembles an HTTP service from its endpoints with the
+// toolkit's production defaults, so that a new service doesn't copy the
+// wiring of the string service:
+//
+//	h := kit.New(svc).
+//		WithLogging(logger).
+//		WithMetrics(metricsFactory).
+//		WithTracing(tracer).
+//		Build()
+//	http.ListenAndServe(":8080", h)
+//
+// Build wraps every endpoint in, outermost first: a span, a log line, a
+// request count and latency, the middlewares added with WithMiddleware, and
+// panic recovery, which answers INTERNAL with a fixed message and logs the
+// panic. Leaving out WithLogging, WithMetrics or WithTracing
+// leaves out that middleware. Requests and responses are in the wire format
+// negotiated from the Content-Type and Accept headers, JSON by default, and
+// every failure is written as an svcerrors envelope with the HTTP status of
+// its code. The string service encodes and traces its calls with the same
+// EncodeResponse, EncodeError and TracingMiddleware.
+package kit
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"runtime/debug"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/transport"
+	httptransport "github.com/go-kit/kit/transport/http"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/mcclayac/gokit/codec"
+	"github.com/mcclayac/gokit/svcerrors"
+	"github.com/mcclayac/gokit/tracing"
+)
+
+// Endpoint is one operation of a service, served at Path.
+type Endpoint struct {
+	// Name names the operation in spans, logs and metrics, such as
+	// "uppercase".
+	Name     string
+	Path     string
+	Endpoint endpoint.Endpoint
+	// Decode decodes requests; Decoder covers requests that are a body.
+	Decode httptransport.DecodeRequestFunc
+}
+
+// Service is a service to serve.
+type Service interface {
+	Endpoints() []Endpoint
+}
+
+// Metrics creates the instruments Build records with. The string
+// service's metrics factories are Metrics. Histograms observe seconds.
+type Metrics interface {
+	Counter(name, help string, labels ...string) metrics.Counter
+	Histogram(name, help string, buckets []float64, labels ...string) metrics.Histogram
+}
+
+// LatencyBuckets are the bucket bounds, in seconds, of the latency
+// histogram.
+var LatencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Builder assembles a service. Its methods return it, for chaining.
+type Builder struct {
+	svc         Service
+	logger      log.Logger
+	metrics     Metrics
+	tracer      trace.Tracer
+	middlewares []endpoint.Middleware
+	codecs      *codec.Registry
+}
+
+// New returns a Builder for svc that speaks JSON, XML, MessagePack,
+// protobuf and CBOR.
+func New(svc Service) *Builder {
+	codecs := codec.NewRegistry(codec.JSON)
+	codecs.Register(codec.XML, "text/xml")
+	codecs.Register(codec.MsgPack, "application/x-msgpack", "application/vnd.msgpack")
+	codecs.Register(codec.Protobuf, "application/protobuf", "application/vnd.google.protobuf")
+	codecs.Register(codec.CBOR)
+	return &Builder{svc: svc, codecs: codecs}
+}
+
+// WithLogging logs every call, and the transport errors, to logger.
+func (b *Builder) WithLogging(logger log.Logger) *Builder {
+	b.logger = logger
+	return b
+}
+
+// WithMetrics counts calls in the requests counter and times them in the
+// request_duration histogram, both labeled with the method and the error
+// code, "OK" for success.
+func (b *Builder) WithMetrics(m Metrics) *Builder {
+	b.metrics = m
+	return b
+}
+
+// WithTracing wraps every call in a span of tracer, continuing the trace
+// of the request's traceparent header.
+func (b *Builder) WithTracing(tracer trace.Tracer) *Builder {
+	b.tracer = tracer
+	return b
+}
+
+// WithMiddleware adds mws, outermost first, inside the default middlewares
+// and after those already added.
+func (b *Builder) WithMiddleware(mws ...endpoint.Middleware) *Builder {
+	b.middlewares = append(b.middlewares, mws...)
+	return b
+}
+
+// WithCodecs replaces the wire formats.
+func (b *Builder) WithCodecs(r *codec.Registry) *Builder {
+	b.codecs = r
+	return b
+}
+
+// Build returns the handler serving the service's endpoints.
+func (b *Builder) Build() http.Handler {
+	var requests metrics.Counter
+	var latency metrics.Histogram
+	if b.metrics != nil {
+		requests = b.metrics.Counter("requests", "Number of requests received.", "method", "code")
+		latency = b.metrics.Histogram("request_duration", "Time spent processing requests.", LatencyBuckets, "method", "code")
+	}
+	logger := b.logger
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+	options := []httptransport.ServerOption{
+		httptransport.ServerBefore(httptransport.PopulateRequestContext, tracing.HTTPToContext),
+		httptransport.ServerErrorEncoder(b.encodeError),
+		httptransport.ServerErrorHandler(transport.NewLogErrorHandler(level.Error(logger))),
+	}
+
+	mux := http.NewServeMux()
+	for _, e := range b.svc.Endpoints() {
+		var mws []endpoint.Middleware
+		if b.tracer != nil {
+			mws = append(mws, TracingMiddleware(b.tracer, e.Name))
+		}
+		if b.logger != nil {
+			mws = append(mws, loggingMiddleware(b.logger, e.Name))
+		}
+		if b.metrics != nil {
+			mws = append(mws, instrumentingMiddleware(requests, latency, e.Name))
+		}
+		mws = append(append(mws, b.middlewares...), recoveringMiddleware(logger, e.Name))
+		ep := e.Endpoint
+		for i := len(mws) - 1; i >= 0; i-- {
+			ep = mws[i](ep)
+		}
+		mux.Handle(e.Path, httptransport.NewServer(ep, e.Decode, b.encodeResponse, options...))
+	}
+	return mux
+}
+
+// Decoder returns a DecodeRequestFunc for requests that are a body. new
+// returns a pointer to decode the body into, and the value it points to is
+// the request.
+func Decoder(codecs *codec.Registry, new func() interface{}) httptransport.DecodeRequestFunc {
+	return func(_ context.Context, r *http.Request) (interface{}, error) {
+		c, err := codecs.ForContentType(r.Header.Get("Content-Type"))
+		if err != nil {
+			return nil, err
+		}
+		v := new()
+		if err := c.Decode(r.Body, v); err != nil {
+			return nil, svcerrors.Errorf(svcerrors.CodeInvalidArgument, "malformed request body: %v", err)
+		}
+		return reflect.ValueOf(v).Elem().Interface(), nil
+	}
+}
+
+// Codecs returns the wire formats, for Decoder.
+func (b *Builder) Codecs() *codec.Registry { return b.codecs }
+
+// ErrorResponse is the envelope every failure is written in.
+type ErrorResponse struct {
+	Err *svcerrors.Error `json:"err" xml:"err"`
+}
+
+func (b *Builder) encodeResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	if f, ok := response.(endpoint.Failer); ok && f.Failed() != nil {
+		b.encodeError(ctx, f.Failed(), w)
+		return nil
+	}
+	return EncodeResponse(ctx, b.codecs, w, response)
+}
+
+func (b *Builder) encodeError(ctx context.Context, err error, w http.ResponseWriter) {
+	e := svcerrors.From(err)
+	EncodeError(ctx, b.codecs, w, e, ErrorResponse{e})
+}
+
+// EncodeResponse writes response in the format of codecs negotiated from
+// the request's Accept header, with the headers and status it gives as an
+// httptransport.Headerer and StatusCoder. Business errors are the
+// caller's to write, with EncodeError.
+func EncodeResponse(ctx context.Context, codecs *codec.Registry, w http.ResponseWriter, response interface{}) error {
+	c, err := codecs.ForAccept(acceptHeader(ctx))
+	if err != nil {
+		return err
+	}
+	if h, ok := response.(httptransport.Headerer); ok {
+		for k, v := range h.Headers() {
+			w.Header()[k] = v
+		}
+	}
+	w.Header().Set("Content-Type", c.ContentType())
+	if sc, ok := response.(httptransport.StatusCoder); ok {
+		w.WriteHeader(sc.StatusCode())
+	}
+	return c.Encode(w, response)
+}
+
+// EncodeError writes envelope, the envelope of e such as ErrorResponse,
+// with the HTTP status of e's code, in the format of codecs negotiated from
+// the request's Accept header, or their default when none is supported.
+func EncodeError(ctx context.Context, codecs *codec.Registry, w http.ResponseWriter, e *svcerrors.Error, envelope interface{}) {
+	c, err := codecs.ForAccept(acceptHeader(ctx))
+	if err != nil {
+		c = codecs.Default()
+	}
+	w.Header().Set("Content-Type", c.ContentType())
+	w.WriteHeader(svcerrors.HTTPStatus(e.Code))
+	c.Encode(w, envelope)
+}
+
+func acceptHeader(ctx context.Context) string {
+	accept, _ := ctx.Value(httptransport.ContextKeyRequestAccept).(string)
+	return accept
+}
+
+// OutcomeCode returns the error code of a call, business errors reported
+// through endpoint.Failer included, or "OK".
+func OutcomeCode(response interface{}, err error) string {
+	if f, ok := response.(endpoint.Failer); ok && err == nil {
+		err = f.Failed()
+	}
+	if err == nil {
+		return "OK"
+	}
+	return string(svcerrors.CodeOf(err))
+}
+
+// TracingMiddleware wraps each call in a span of tracer named after the
+// method. Failed calls, business errors included, mark the span as an
+// error and record the error code.
+func TracingMiddleware(tracer trace.Tracer, method string) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			ctx, span := tracer.Start(ctx, method, trace.WithSpanKind(trace.SpanKindServer))
+			defer span.End()
+			response, err := next(ctx, request)
+			if code := OutcomeCode(response, err); code != "OK" {
+				span.SetAttributes(attribute.String("error_code", code))
+				span.SetStatus(codes.Error, code)
+				if err != nil {
+					span.RecordError(err)
+				}
+			}
+			return response, err
+		}
+	}
+}
+
+func loggingMiddleware(logger log.Logger, method string) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+			defer func(begin time.Time) {
+				code := OutcomeCode(response, err)
+				l := level.Info(logger)
+				if svcerrors.HTTPStatus(svcerrors.Code(code)) >= 500 {
+					l = level.Error(logger)
+				}
+				l.Log("method", method, "code", code, "took", time.Since(begin))
+			}(time.Now())
+			return next(ctx, request)
+		}
+	}
+}
+
+func instrumentingMiddleware(requests metrics.Counter, latency metrics.Histogram, method string) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+			defer func(begin time.Time) {
+				lvs := []string{"method", method, "code", OutcomeCode(response, err)}
+				requests.With(lvs...).Add(1)
+				latency.With(lvs...).Observe(time.Since(begin).Seconds())
+			}(time.Now())
+			return next(ctx, request)
+		}
+	}
+}
+
+// recoveringMiddleware turns a panic into an INTERNAL error, so that it is
+// counted and answered like any other failure. The panic is logged, with
+// its stack, to logger: callers get only the fixed INTERNAL message.
+func recoveringMiddleware(logger log.Logger, method string) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					level.Error(logger).Log("msg", "recovered from panic", "err", fmt.Sprint(r), "method", method, "stack", string(debug.Stack()))
+					response, err = nil, svcerrors.ErrInternal
+				}
+			}()
+			return next(ctx, request)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package kit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+)
+
+type panickingService struct{}
+
+func (panickingService) Endpoints() []Endpoint {
+	return []Endpoint{{
+		Name: "boom",
+		Path: "/boom",
+		Endpoint: func(context.Context, interface{}) (interface{}, error) {
+			panic("secret detail")
+		},
+		Decode: func(context.Context, *http.Request) (interface{}, error) { return nil, nil },
+	}}
+}
+
+func TestRecoveringMiddleware(t *testing.T) {
+	var logs strings.Builder
+	h := New(panickingService{}).WithLogging(log.NewLogfmtLogger(&logs)).Build()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/boom", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("got %d, want 500", w.Code)
+	}
+	if strings.Contains(w.Body.String(), "secret detail") {
+		t.Errorf("the panic reached the caller: %s", w.Body)
+	}
+	if !strings.Contains(logs.String(), "secret detail") {
+		t.Errorf("the panic wasn't logged: %s", logs.String())
+	}
+}
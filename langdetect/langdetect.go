@@ -0,0 +1,246 @@
+// Package langdetect guesses the language of a text. Scripts used by a
+// single language settle it outright; otherwise the text's character
+// trigrams are scored against profiles built from the sample texts in
+// corpus/, which are embedded in the binary.
+package langdetect
+
+import (
+	"embed"
+	"math"
+	"path"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+//go:embed corpus/*.txt
+var corpus embed.FS
+
+// Guess is a language and how confident the detector is in it, from 0 to 1.
+// The confidences of all the guesses for a text add up to 1.
+type Guess struct {
+	Lang       string // ISO 639-1 code
+	Confidence float64
+}
+
+// profile is a language's trigram model: the log probability of each
+// trigram, and the log probability given to trigrams it has never seen.
+type profile struct {
+	logProb map[string]float64
+	unseen  float64
+	script  *unicode.RangeTable
+}
+
+var (
+	profiles = loadProfiles()
+	// scriptLangs holds the languages sharing each script that has trigram
+	// profiles, so a Cyrillic text is only scored against Cyrillic ones.
+	scriptLangs = groupByScript()
+)
+
+// maxRunes bounds how much of a text is looked at; a few hundred letters
+// settle the language as well as the whole text does.
+const maxRunes = 2000
+
+// Languages returns the codes of the languages Detect can return, sorted.
+func Languages() []string {
+	seen := map[string]bool{}
+	for lang := range profiles {
+		seen[lang] = true
+	}
+	for _, s := range scriptOnly {
+		seen[s.lang] = true
+	}
+	langs := make([]string, 0, len(seen))
+	for lang := range seen {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	return langs
+}
+
+// Detect returns up to n guesses at the language of s, most likely first.
+// It returns no guesses when s has no letters.
+func Detect(s string, n int) []Guess {
+	if r := []rune(s); len(r) > maxRunes {
+		s = string(r[:maxRunes])
+	}
+	script := dominantScript(s)
+	if script == nil {
+		return nil
+	}
+	for _, so := range scriptOnly {
+		if so.table == script {
+			return []Guess{{so.lang, 1}}
+		}
+	}
+	langs := scriptLangs[script]
+	if len(langs) == 0 {
+		return nil
+	}
+	grams := trigrams(s)
+	if len(grams) == 0 {
+		return nil
+	}
+	guesses := make([]Guess, len(langs))
+	max := math.Inf(-1)
+	for i, lang := range langs {
+		p := profiles[lang]
+		var score float64
+		for _, g := range grams {
+			if lp, ok := p.logProb[g]; ok {
+				score += lp
+			} else {
+				score += p.unseen
+			}
+		}
+		// Scaling by the square root of the trigram count keeps long texts
+		// from pushing every confidence to exactly 0 or 1.
+		score /= math.Sqrt(float64(len(grams)))
+		guesses[i] = Guess{lang, score}
+		if score > max {
+			max = score
+		}
+	}
+	var sum float64
+	for i := range guesses {
+		guesses[i].Confidence = math.Exp(guesses[i].Confidence - max)
+		sum += guesses[i].Confidence
+	}
+	for i := range guesses {
+		guesses[i].Confidence /= sum
+	}
+	sort.Slice(guesses, func(i, j int) bool {
+		if guesses[i].Confidence != guesses[j].Confidence {
+			return guesses[i].Confidence > guesses[j].Confidence
+		}
+		return guesses[i].Lang < guesses[j].Lang
+	})
+	if n > 0 && len(guesses) > n {
+		guesses = guesses[:n]
+	}
+	return guesses
+}
+
+// scriptOnly lists the scripts that, among the languages supported, only
+// one language uses. Han counts as Chinese only when there are no kana
+// alongside it; see dominantScript.
+var scriptOnly = []struct {
+	table *unicode.RangeTable
+	lang  string
+}{
+	{unicode.Hangul, "ko"},
+	{unicode.Hiragana, "ja"},
+	{unicode.Katakana, "ja"},
+	{unicode.Greek, "el"},
+	{unicode.Arabic, "ar"},
+	{unicode.Hebrew, "he"},
+	{unicode.Thai, "th"},
+	{unicode.Devanagari, "hi"},
+	{unicode.Han, "zh"},
+}
+
+// profileScripts are the scripts of the languages with trigram profiles.
+var profileScripts = []*unicode.RangeTable{unicode.Latin, unicode.Cyrillic}
+
+// dominantScript returns the script most of the letters in s are written
+// in. Kana win over Han however few there are, since Japanese mixes them.
+func dominantScript(s string) *unicode.RangeTable {
+	counts := map[*unicode.RangeTable]int{}
+	for _, r := range s {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		for _, t := range profileScripts {
+			if unicode.Is(t, r) {
+				counts[t]++
+			}
+		}
+		for _, so := range scriptOnly {
+			if unicode.Is(so.table, r) {
+				counts[so.table]++
+			}
+		}
+	}
+	if counts[unicode.Hiragana]+counts[unicode.Katakana] > 0 && counts[unicode.Han] > 0 {
+		if counts[unicode.Hiragana] >= counts[unicode.Katakana] {
+			return unicode.Hiragana
+		}
+		return unicode.Katakana
+	}
+	var (
+		best  *unicode.RangeTable
+		count int
+	)
+	for t, c := range counts {
+		if c > count || c == count && t == unicode.Latin {
+			best, count = t, c
+		}
+	}
+	return best
+}
+
+// trigrams returns the character trigrams of each word in s, lower cased
+// and padded with a space at both ends so that word boundaries count.
+func trigrams(s string) []string {
+	var grams []string
+	words := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool { return !unicode.IsLetter(r) })
+	for _, w := range words {
+		r := []rune(" " + w + " ")
+		for i := 0; i+3 <= len(r); i++ {
+			grams = append(grams, string(r[i:i+3]))
+		}
+	}
+	return grams
+}
+
+// loadProfiles builds a profile from each embedded sample text, with
+// add-one smoothing over the trigrams seen in any of them.
+func loadProfiles() map[string]profile {
+	files, err := corpus.ReadDir("corpus")
+	if err != nil {
+		panic(err)
+	}
+	counts := map[string]map[string]int{}
+	scripts := map[string]*unicode.RangeTable{}
+	vocabulary := map[string]bool{}
+	for _, f := range files {
+		b, err := corpus.ReadFile(path.Join("corpus", f.Name()))
+		if err != nil {
+			panic(err)
+		}
+		c := map[string]int{}
+		for _, g := range trigrams(string(b)) {
+			c[g]++
+			vocabulary[g] = true
+		}
+		lang := strings.TrimSuffix(f.Name(), ".txt")
+		counts[lang] = c
+		scripts[lang] = dominantScript(string(b))
+	}
+	profiles := make(map[string]profile, len(counts))
+	for lang, c := range counts {
+		var total int
+		for _, n := range c {
+			total += n
+		}
+		denom := float64(total + len(vocabulary) + 1)
+		p := profile{logProb: make(map[string]float64, len(c)), unseen: math.Log(1 / denom), script: scripts[lang]}
+		for g, n := range c {
+			p.logProb[g] = math.Log(float64(n+1) / denom)
+		}
+		profiles[lang] = p
+	}
+	return profiles
+}
+
+func groupByScript() map[*unicode.RangeTable][]string {
+	m := map[*unicode.RangeTable][]string{}
+	for lang, p := range profiles {
+		m[p.script] = append(m[p.script], lang)
+	}
+	for _, langs := range m {
+		sort.Strings(langs)
+	}
+	return m
+}
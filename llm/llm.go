@@ -0,0 +1,49 @@
+// Package llm calls large language models through the Provider interface.
+// OpenAI implements it for any service with an OpenAI-compatible chat
+// completions API.
+package llm
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// Message is a chat message. Role is "system", "user" or "assistant".
+type Message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// Request asks for a completion of a conversation.
+type Request struct {
+	Model     string
+	Messages  []Message
+	MaxTokens int // the provider's default when zero
+}
+
+// Completion is a model's reply.
+type Completion struct {
+	Text string
+	// FinishReason is why the model stopped, such as "stop", or "length"
+	// when it ran out of tokens.
+	FinishReason string
+}
+
+// Provider completes conversations. When emit is non-nil, the reply is
+// streamed: emit is called with each piece of text as it arrives, and an
+// error from emit ends the call. The returned Completion holds the whole
+// reply either way.
+type Provider interface {
+	Complete(ctx context.Context, req Request, emit func(delta string) error) (Completion, error)
+}
+
+// StatusError is returned when a provider answers with an HTTP error.
+type StatusError struct {
+	StatusCode int
+	Message    string // from the response body, if any
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("llm: %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
@@ -0,0 +1,122 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// OpenAI is a Provider for OpenAI's chat completions API and the many
+// servers that implement it.
+type OpenAI struct {
+	URL    string // base URL, such as https://api.openai.com/v1
+	Key    string // sent as a bearer token when set
+	Client *http.Client
+}
+
+type openAIRequest struct {
+	Model     string    `json:"model"`
+	Messages  []Message `json:"messages"`
+	MaxTokens int       `json:"max_tokens,omitempty"`
+	Stream    bool      `json:"stream,omitempty"`
+}
+
+type openAIChoice struct {
+	Message      Message `json:"message"`
+	Delta        Message `json:"delta"`
+	FinishReason string  `json:"finish_reason"`
+}
+
+type openAIResponse struct {
+	Choices []openAIChoice `json:"choices"`
+}
+
+// maxErrorBody bounds how much of an error response is kept as its message.
+const maxErrorBody = 1024
+
+func (p OpenAI) Complete(ctx context.Context, req Request, emit func(string) error) (Completion, error) {
+	b, err := json.Marshal(openAIRequest{req.Model, req.Messages, req.MaxTokens, emit != nil})
+	if err != nil {
+		return Completion{}, err
+	}
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(p.URL, "/")+"/chat/completions", bytes.NewReader(b))
+	if err != nil {
+		return Completion{}, err
+	}
+	hreq.Header.Set("Content-Type", "application/json")
+	if p.Key != "" {
+		hreq.Header.Set("Authorization", "Bearer "+p.Key)
+	}
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(hreq)
+	if err != nil {
+		return Completion{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		return Completion{}, &StatusError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+	}
+	if emit != nil {
+		return readStream(resp.Body, emit)
+	}
+	var out openAIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return Completion{}, fmt.Errorf("llm: decoding response: %w", err)
+	}
+	if len(out.Choices) == 0 {
+		return Completion{}, errors.New("llm: response has no choices")
+	}
+	return Completion{Text: out.Choices[0].Message.Content, FinishReason: out.Choices[0].FinishReason}, nil
+}
+
+// readStream reads a streamed completion: server-sent events whose data is
+// a JSON chunk, ending with the data "[DONE]".
+func readStream(r io.Reader, emit func(string) error) (Completion, error) {
+	var (
+		c    Completion
+		text strings.Builder
+	)
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		data := strings.TrimPrefix(sc.Text(), "data:")
+		if data == sc.Text() {
+			continue // blank lines, comments and other fields
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			c.Text = text.String()
+			return c, nil
+		}
+		var chunk openAIResponse
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return Completion{}, fmt.Errorf("llm: decoding stream: %w", err)
+		}
+		if len(chunk.Choices) == 0 {
+			continue
+		}
+		if fr := chunk.Choices[0].FinishReason; fr != "" {
+			c.FinishReason = fr
+		}
+		if delta := chunk.Choices[0].Delta.Content; delta != "" {
+			text.WriteString(delta)
+			if err := emit(delta); err != nil {
+				return Completion{}, err
+			}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return Completion{}, err
+	}
+	return Completion{}, io.ErrUnexpectedEOF
+}
@@ -0,0 +1,36 @@
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+
+	"github.com/go-kit/kit/log"
+)
+
+type slogLogger struct {
+	logger *slog.Logger
+}
+
+// newSlogLogger returns a logger backed by log/slog's JSON or text handler.
+// As with zap, level filtering is left to level.NewFilter.
+func newSlogLogger(w io.Writer, format string) log.Logger {
+	opts := &slog.HandlerOptions{Level: slog.LevelDebug}
+	var h slog.Handler = slog.NewTextHandler(w, opts)
+	if format == "json" {
+		h = slog.NewJSONHandler(w, opts)
+	}
+	return slogLogger{slog.New(h)}
+}
+
+func (l slogLogger) Log(keyvals ...interface{}) error {
+	r := parseKeyvals(keyvals)
+	var lvl slog.Level
+	lvl.UnmarshalText([]byte(r.level))
+	attrs := make([]slog.Attr, 0, len(r.fields)/2)
+	for i := 0; i < len(r.fields); i += 2 {
+		attrs = append(attrs, slog.Any(r.fields[i].(string), r.fields[i+1]))
+	}
+	l.logger.LogAttrs(context.Background(), lvl, r.msg, attrs...)
+	return nil
+}
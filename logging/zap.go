@@ -0,0 +1,43 @@
+package logging
+
+import (
+	"io"
+
+	"github.com/go-kit/kit/log"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+type zapLogger struct {
+	logger *zap.Logger
+}
+
+// newZapLogger returns a logger backed by zap, using zap's production field
+// names (ts, level, msg). Level filtering is left to level.NewFilter, so the
+// core accepts everything. The logfmt format maps to zap's console encoder.
+func newZapLogger(w io.Writer, format string) log.Logger {
+	encCfg := zap.NewProductionEncoderConfig()
+	encCfg.EncodeTime = zapcore.ISO8601TimeEncoder
+	enc := zapcore.NewConsoleEncoder(encCfg)
+	if format == "json" {
+		enc = zapcore.NewJSONEncoder(encCfg)
+	}
+	core := zapcore.NewCore(enc, zapcore.AddSync(w), zapcore.DebugLevel)
+	return zapLogger{zap.New(core)}
+}
+
+func (l zapLogger) Log(keyvals ...interface{}) error {
+	r := parseKeyvals(keyvals)
+	lvl := zapcore.InfoLevel
+	lvl.UnmarshalText([]byte(r.level))
+	ce := l.logger.Check(lvl, r.msg)
+	if ce == nil {
+		return nil
+	}
+	fields := make([]zap.Field, 0, len(r.fields)/2)
+	for i := 0; i < len(r.fields); i += 2 {
+		fields = append(fields, zap.Any(r.fields[i].(string), r.fields[i+1]))
+	}
+	ce.Write(fields...)
+	return nil
+}
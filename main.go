@@ -1,143 +1,1285 @@
-package main
+// Package stringsvc is the string service and the stringsvc command's
+// subcommands, which cmd/stringsvc runs. NewApp builds the service as the
+// serve subcommand does, for tests to serve in-process.
+package stringsvc
 
 import (
 	"context"
-	"encoding/json"
+	"database/sql"
 	"errors"
-	"log"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
+	"github.com/coreos/go-systemd/v22/daemon"
 	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/go-kit/kit/transport"
 	httptransport "github.com/go-kit/kit/transport/http"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel"
+
+	"github.com/mcclayac/gokit/codec"
+	"github.com/mcclayac/gokit/events"
+	"github.com/mcclayac/gokit/kit"
+	"github.com/mcclayac/gokit/llm"
+	"github.com/mcclayac/gokit/logging"
+	"github.com/mcclayac/gokit/migrate"
+	"github.com/mcclayac/gokit/redact"
+	"github.com/mcclayac/gokit/svcerrors"
+	"github.com/mcclayac/gokit/tracing"
 )
 
 // StringService provides operations on strings.
 type StringService interface {
-	Uppercase(string) (string, error)
-	Count(string) int
+	Uppercase(context.Context, string) (string, error)
+	Count(context.Context, string) int
 }
 
 type OSInfoService interface {
-	Hostname() (string, error)
+	Hostname(context.Context) (string, error)
 }
 
 // stringService is a concrete implementation of StringService
 type stringService struct{}
 
-func (stringService) Uppercase(s string) (string, error) {
+func (stringService) Uppercase(ctx context.Context, s string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
 	if s == "" {
 		return "", ErrEmpty
 	}
 	return strings.ToUpper(s), nil
 }
 
-func (stringService) Count(s string) int {
+func (stringService) Count(_ context.Context, s string) int {
 	return len(s)
 }
 
 type osInfoService struct{}
 
-func (osInfoService) Hostname() (string, error) {
+func (osInfoService) Hostname(ctx context.Context) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
 	hostName, err := os.Hostname()
 	if err != nil {
-		return "", ErrEmpty
+		return "", svcerrors.ErrHostnameUnavailable
 	}
 	return hostName, nil
 }
 
 // ErrEmpty is returned when an input string is empty.
-var ErrEmpty = errors.New("empty string")
+var ErrEmpty = svcerrors.ErrStringEmpty
 
-// For each method, we define request and response structs
+// For each method, we define request and response structs. The validate
+// tags are enforced by validatingMiddleware before the service is called.
 type uppercaseRequest struct {
-	S string `json:"s"`
+	S string `json:"s" xml:"s" validate:"max=65536"` // empty fails with STRING_EMPTY
 }
 
 type uppercaseResponse struct {
-	V   string `json:"v"`
-	Err string `json:"err,omitempty"` // errors don't define JSON marshaling
+	V   string `json:"v" xml:"v"`
+	Err error  `json:"-" xml:"-"` // business errors are written by encodeError
 }
 
+// Failed implements endpoint.Failer.
+func (r uppercaseResponse) Failed() error { return r.Err }
+
 type countRequest struct {
-	S string `json:"s"`
+	S string `json:"s" xml:"s" validate:"max=65536"`
 }
 
 type countResponse struct {
-	V int `json:"v"`
+	V int `json:"v" xml:"v"`
 }
 
 type hostnameRequest struct{}
 
 type hostnameResponse struct {
-	V   string `json:"v"`
-	Err string `json:"err,omitempty"`
+	V   string `json:"v" xml:"v"`
+	Err error  `json:"-" xml:"-"`
+}
+
+// Failed implements endpoint.Failer.
+func (r hostnameResponse) Failed() error { return r.Err }
+
+// Headers implements httptransport.Headerer. The hostname is the serving
+// instance's, so no cache may keep it, whatever the route's policy.
+func (r hostnameResponse) Headers() http.Header {
+	return http.Header{"Cache-Control": {"no-store"}}
 }
 
 // Endpoints are a primary abstraction in go-kit. An endpoint represents a single RPC (method in our service interface)
 func makeUppercaseEndpoint(svc StringService) endpoint.Endpoint {
-	return func(_ context.Context, request interface{}) (interface{}, error) {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(uppercaseRequest)
-		v, err := svc.Uppercase(req.S)
-		if err != nil {
-			return uppercaseResponse{v, err.Error()}, nil
-		}
-		return uppercaseResponse{v, ""}, nil
+		v, err := svc.Uppercase(ctx, req.S)
+		return uppercaseResponse{v, err}, nil
 	}
 }
 
 func makeCountEndpoint(svc StringService) endpoint.Endpoint {
-	return func(_ context.Context, request interface{}) (interface{}, error) {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(countRequest)
-		v := svc.Count(req.S)
+		v := svc.Count(ctx, req.S)
 		return countResponse{v}, nil
 	}
 }
 
 func makeHostnameEndpoint(svc OSInfoService) endpoint.Endpoint {
-	return func(_ context.Context, request interface{}) (interface{}, error) {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		//  request.(hostnameRequest)
-		v, err := svc.Hostname()
-		if err != nil {
-			return hostnameResponse{v, err.Error()}, nil
+		v, err := svc.Hostname(ctx)
+		return hostnameResponse{v, err}, nil
+	}
+}
+
+// version is the release reported to error tracking. It is set at build time
+// with -ldflags "-X github.com/mcclayac/gokit.version=...".
+var version = "dev"
+
+// runServe is the serve subcommand, and what runs without one: it serves
+// the string service over HTTP, or consumes requests as a worker, until it
+// fails or is stopped.
+func runServe(args []string) int {
+	cfg, err := parseConfig("stringsvc serve", args)
+	if err != nil {
+		return 2
+	}
+	logger, err := logging.New(os.Stderr, cfg.Log)
+	if err != nil {
+		log.NewLogfmtLogger(os.Stderr).Log("err", err)
+		return 2
+	}
+	if problems := checkConfig(cfg); len(problems) > 0 {
+		for _, p := range problems {
+			level.Error(logger).Log("msg", "configuration problem", "flag", p.Flag, "problem", p.Message)
 		}
-		return hostnameResponse{v, ""}, nil
+		return 2
 	}
+	now := time.Now
+	if !cfg.FixedTime.IsZero() {
+		now = func() time.Time { return cfg.FixedTime }
+	}
+	a, err := newApp(cfg, logger, now)
+	if err != nil {
+		level.Error(logger).Log("msg", "starting", "err", err)
+		return 2
+	}
+	return a.run()
+}
+
+// App is the service as the serve subcommand builds it from its flags,
+// with every store, middleware and handler they configure.
+type App struct {
+	srv   *http.Server
+	run   func() int
+	close func()
+}
+
+// NewApp builds the service the serve subcommand would run with args,
+// logging to w and reading the time from now, without listening: tests
+// serve App.Server themselves, as with httptest. Worker mode, which serves
+// no API, is refused.
+func NewApp(args []string, w io.Writer, now func() time.Time) (*App, error) {
+	cfg, err := parseConfig("stringsvc serve", args)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Worker.Enabled() {
+		return nil, errors.New("worker mode serves no API")
+	}
+	logger, err := logging.New(w, cfg.Log)
+	if err != nil {
+		return nil, err
+	}
+	if problems := checkConfig(cfg); len(problems) > 0 {
+		return nil, errors.New(problems[0].String())
+	}
+	return newApp(cfg, logger, now)
 }
 
-// Transports expose the service to the network. In this first example we utilize JSON over HTTP.
-func main() {
-	svc := stringService{}
-	osSVC := osInfoService{}
+// Server returns the HTTP server for the service's API. Its address is
+// that of -http-addr, which a caller serving it on its own listener can
+// ignore.
+func (a *App) Server() *http.Server { return a.srv }
+
+// Close stops the service's background work and closes its stores.
+func (a *App) Close() { a.close() }
+
+// newApp builds the service cfg configures, for the serve subcommand or
+// NewApp.
+func newApp(cfg config, logger log.Logger, now func() time.Time) (*App, error) {
+	env := newAppEnv()
+	env.proxies = cfg.TrustedProxies
+	if cfg.StrictJSON {
+		env.codecs.Register(codec.JSONCodec{Strict: true})
+	}
+	if cfg.CanonicalCBOR {
+		env.codecs.Register(codec.CBORCodec{Canonical: true})
+	}
+	var features *featureSet
+	stopFeatures := func() {}
+	if cfg.Features.enabled() {
+		features = newFeatureSet(cfg.Features, logger)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err := features.Refresh(ctx)
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("reading feature flags: %w", err)
+		}
+		ctx, stopFeatures = context.WithCancel(context.Background())
+		go features.Run(ctx, cfg.Features.Refresh)
+		env.features = features
+	}
+	var tenants *tenantSet
+	if cfg.Tenancy.File != "" {
+		t, err := loadTenants(cfg.Tenancy)
+		if err != nil {
+			return nil, fmt.Errorf("reading tenants: %w", err)
+		}
+		tenants = t
+	}
+	if cfg.SentryDSN != "" {
+		r, err := newSentryReporter(cfg.SentryDSN, cfg.SentryEnvironment, cfg.SentrySampleRate)
+		if err != nil {
+			return nil, fmt.Errorf("configuring error reporting: %w", err)
+		}
+		env.reporter = r
+	}
+
+	var svc StringService
+	svc = stringService{}
+	if features != nil || tenants != nil {
+		svc = featureStringService{features, svc}
+	}
+	if tenants != nil {
+		svc = tenantStringService{svc}
+	}
+	svc = loggingMiddleware{log.With(logger, "service", "string"), svc}
+
+	var osSVC OSInfoService
+	osSVC = osInfoService{}
+	osSVC = osInfoLoggingMiddleware{log.With(logger, "service", "osinfo"), osSVC}
+
+	var mathSVC MathService
+	mathSVC = mathService{}
+	mathSVC = mathLoggingMiddleware{log.With(logger, "service", "math"), mathSVC}
+
+	var timeSVC TimeService
+	timeSVC = timeService{now: now}
+	timeSVC = timeLoggingMiddleware{log.With(logger, "service", "time"), timeSVC}
+
+	var idSVC IDService
+	idSVC = newIDService(now)
+	idSVC = idLoggingMiddleware{log.With(logger, "service", "id"), idSVC}
+
+	var qrcodeSVC QRCodeService
+	qrcodeSVC = qrcodeService{}
+	qrcodeSVC = qrcodeLoggingMiddleware{log.With(logger, "service", "qrcode"), qrcodeSVC}
+
+	var detectSVC DetectService
+	detectSVC = detectService{}
+	detectSVC = detectLoggingMiddleware{log.With(logger, "service", "detect"), detectSVC}
+
+	var translateSVC TranslateService
+	if len(cfg.Translate.Providers) > 0 {
+		providers, err := newTranslateProviders(cfg.Translate)
+		if err != nil {
+			return nil, fmt.Errorf("configuring translation: %w", err)
+		}
+		impl := translateService{providers: providers}
+		if cfg.Translate.CacheTTL > 0 {
+			impl.cache = newTranslationCache(cfg.Translate.CacheTTL, cfg.Translate.CacheSize, now)
+		}
+		translateSVC = translateLoggingMiddleware{log.With(logger, "service", "translate"), impl}
+	}
+
+	var spellSVC SpellService
+	if cfg.Spell.Dictionary != "" {
+		spellLogger := log.With(logger, "service", "spell")
+		impl, err := newSpellService(cfg.Spell, spellLogger)
+		if err != nil {
+			return nil, fmt.Errorf("loading dictionary: %w", err)
+		}
+		spellSVC = spellLoggingMiddleware{spellLogger, impl}
+	}
+
+	var writingSVC WritingService
+	if cfg.LLM.URL != "" {
+		impl, err := newWritingService(cfg.LLM, llm.OpenAI{URL: cfg.LLM.URL, Key: cfg.LLM.Key})
+		if err != nil {
+			return nil, fmt.Errorf("configuring language model: %w", err)
+		}
+		writingSVC = writingLoggingMiddleware{log.With(logger, "service", "writing"), impl}
+	}
+
+	cryptoImpl, err := newCryptoService(cfg.Crypto)
+	if err != nil {
+		return nil, fmt.Errorf("configuring password hashing: %w", err)
+	}
+	var cryptoSVC CryptoService
+	cryptoSVC = cryptoImpl
+	cryptoSVC = cryptoLoggingMiddleware{log.With(logger, "service", "crypto"), cryptoSVC}
+
+	mf, err := newMetricsFactory(cfg.Metrics, logger)
+	if err != nil {
+		return nil, fmt.Errorf("configuring metrics: %w", err)
+	}
+	em := newEndpointMetrics(mf, cfg.Metrics.SLO)
+
+	tp, shutdownTracing, err := newTracerProvider(cfg.Tracing)
+	if err != nil {
+		return nil, fmt.Errorf("configuring tracing: %w", err)
+	}
+	tracer := tp.Tracer("github.com/mcclayac/gokit")
+	otel.SetTextMapPropagator(tracing.Propagator)
+
+	// Audit records go to the file, the database or both, each keeping
+	// its own hash chain.
+	var audit auditSinks
+	if cfg.AuditLog != "" {
+		auditFile, err := newAuditLog(cfg.AuditLog, cfg.AuditMaxSizeMB, cfg.AuditMaxBackups, cfg.AuditRetention)
+		if err != nil {
+			return nil, fmt.Errorf("opening audit log: %w", err)
+		}
+		audit = append(audit, auditFile)
+	}
+
+	var db *sql.DB
+	if cfg.PostgresDSN != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		db, err = openDatabase(ctx, cfg.PostgresDSN)
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("connecting to database: %w", err)
+		}
+	}
+
+	var lite *sql.DB
+	if cfg.SQLitePath != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		lite, err = openSQLite(ctx, cfg.SQLitePath)
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("opening SQLite database: %w", err)
+		}
+	}
+
+	if cfg.MigrateOnStart {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		err = migrateDatabases(ctx, db, lite, logger)
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("migrating: %w", err)
+		}
+	}
+
+	var auditDB *sqlAuditStore
+	stopPruning := func() {}
+	if cfg.AuditDB {
+		if db != nil {
+			auditDB = newPostgresAuditStore(db)
+		} else {
+			auditDB = newSQLiteAuditStore(lite)
+		}
+		audit = append(audit, auditDB)
+		if cfg.AuditRetention > 0 {
+			ctx, cancel := context.WithCancel(context.Background())
+			pruned := make(chan struct{})
+			go func() {
+				pruneAudit(ctx, auditDB, cfg.AuditRetention, logger)
+				close(pruned)
+			}()
+			stopPruning = func() {
+				cancel()
+				<-pruned
+			}
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	stores, err := openStorage(ctx, cfg.Storage, db, lite, now)
+	cancel()
+	if err != nil {
+		return nil, fmt.Errorf("opening storage: %w", err)
+	}
+	links := stores.links
+	if tenants != nil {
+		links = tenantShortenerStore{links}
+	}
+	var shortenerSVC ShortenerService
+	shortenerSVC = shortenerService{
+		cfg:      cfg.Shortener,
+		store:    links,
+		now:      now,
+		newCode:  newShortCode,
+		created:  mf.Counter("short_links_created", "Number of short links created."),
+		resolved: mf.Counter("short_links_resolved", "Number of short link lookups, by result.", "result"),
+	}
+	shortenerSVC = shortenerLoggingMiddleware{log.With(logger, "service", "shortener"), shortenerSVC}
+
+	var authSVC AuthService
+	if cfg.Auth.ClientsFile != "" {
+		clients, err := loadAuthClients(cfg.Auth.ClientsFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading auth clients: %w", err)
+		}
+		dummy, err := cryptoImpl.Hash(context.Background(), "not a client secret")
+		if err != nil {
+			return nil, fmt.Errorf("configuring auth: %w", err)
+		}
+		auth := authService{cfg: cfg.Auth, clients: clients, store: stores.tokens, crypto: cryptoImpl, now: now, dummyHash: dummy}
+		if cfg.Auth.Keys {
+			auth.keys = stores.keys
+		}
+		authSVC = auth
+		authSVC = authLoggingMiddleware{log.With(logger, "service", "auth"), authSVC}
+	}
 
-	uppercaseHandler := httptransport.NewServer(
-		makeUppercaseEndpoint(svc),
-		decodeUppercaseRequest,
+	// With persistence enabled, events go to the outbox and a relay sends
+	// them on to Kafka, waiting for each to be acknowledged.
+	var publisher events.Publisher
+	stopEvents := func() {}
+	if len(cfg.Kafka.Brokers) > 0 {
+		cfg.Kafka.Sync = db != nil
+		if cfg.KafkaEncoding != "json" {
+			if cfg.Kafka.Encoder, err = newSchemaEncoder(cfg); err != nil {
+				return nil, fmt.Errorf("registering event schema: %w", err)
+			}
+		}
+		kafkaPublisher := events.NewKafkaPublisher(cfg.Kafka,
+			mf.Counter("events_delivered", "Number of call events delivered to Kafka."),
+			mf.Counter("events_failed", "Number of call events Kafka failed to accept."),
+			logger)
+		publisher, stopEvents = kafkaPublisher, func() { kafkaPublisher.Close() }
+		if db != nil {
+			outbox, err := events.NewOutbox(context.Background(), db)
+			if err != nil {
+				return nil, fmt.Errorf("creating event outbox: %w", err)
+			}
+			ctx, stopRelay := context.WithCancel(context.Background())
+			relayed := make(chan struct{})
+			go func() {
+				outbox.Relay(ctx, kafkaPublisher, cfg.OutboxInterval, cfg.Kafka.BatchSize, logger)
+				close(relayed)
+			}()
+			publisher = outbox
+			stopEvents = func() {
+				stopRelay()
+				<-relayed
+				kafkaPublisher.Close()
+			}
+		}
+	}
+
+	// With an outbox, history kept in PostgreSQL is written in one
+	// transaction with the event for the call, so the history middleware
+	// publishes the events instead.
+	var history historyStore
+	if cfg.History {
+		history = stores.history
+		if pg, ok := history.(*postgresHistoryStore); ok {
+			if outbox, ok := publisher.(*events.Outbox); ok {
+				pg.outbox = outbox
+				publisher = nil
+			}
+		}
+	}
+
+	// Rate limits fall back to per-process limits when Redis is down, so
+	// it isn't checked here.
+	var limitsRedis *redis.Client
+	if cfg.RateLimit.RedisAddr != "" {
+		limitsRedis = redis.NewClient(&redis.Options{Addr: cfg.RateLimit.RedisAddr})
+	}
+	limiters := limiterSource{limitsRedis, cfg.RateLimit.RedisPrefix, now, logger}
+
+	var meter *usageMeter
+	if cfg.Usage {
+		switch {
+		case db != nil:
+			meter = newUsageMeter(newPostgresUsageStore(db), cfg.UsageFlush, logger)
+		case lite != nil:
+			meter = newUsageMeter(newSQLiteUsageStore(lite), cfg.UsageFlush, logger)
+		default:
+			return nil, errors.New("-usage-metering needs -postgres-dsn or -sqlite-path")
+		}
+	}
+	stopBilling := func() {}
+	if meter != nil && cfg.Billing.PushURL != "" {
+		sink, err := newBillingSink(context.Background(), cfg.Billing.PushURL)
+		if err != nil {
+			return nil, fmt.Errorf("configuring the billing push: %w", err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		go billingPusher{meter, sink, cfg.Billing.Format, now, logger}.Run(ctx, cfg.Billing.PushInterval)
+		stopBilling = cancel
+	}
+
+	var counters *usageCounters
+	if cfg.Counters.Path != "" {
+		if counters, err = newUsageCounters(cfg.Counters, logger); err != nil {
+			return nil, fmt.Errorf("opening usage counters: %w", err)
+		}
+	}
+
+	var faults *chaos
+	if cfg.Chaos.Enabled {
+		faults = &chaos{}
+		if cfg.Chaos.File != "" {
+			list, err := loadChaosFaults(cfg.Chaos.File)
+			if err == nil {
+				err = faults.Set(list)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("loading chaos faults: %w", err)
+			}
+		}
+		level.Warn(logger).Log("msg", "chaos enabled: faults set at /admin/chaos are injected into calls", "faults", len(faults.Faults()))
+	}
+
+	var experiments *experimentSet
+	if cfg.Experiments != "" {
+		list, err := loadExperiments(cfg.Experiments)
+		if err != nil {
+			return nil, fmt.Errorf("loading experiments: %w", err)
+		}
+		experiments = newExperimentSet(list, mf)
+	}
+
+	// Endpoint middlewares common to every endpoint, outermost first.
+	middlewares := func(method string) endpoint.Middleware {
+		mw := kit.TracingMiddleware(tracer, method)
+		if cfg.SlowRequest > 0 {
+			mw = endpoint.Chain(mw, slowRequestMiddleware(logger, cfg.SlowRequest, method))
+		}
+		mw = endpoint.Chain(mw, instrumentingMiddleware(em, method))
+		if experiments != nil {
+			mw = endpoint.Chain(mw, experimentMetricsMiddleware(experiments, method))
+		}
+		// Token requests authenticate with client credentials instead.
+		if authSVC != nil && method != "token" && method != "revoke" {
+			mw = endpoint.Chain(mw, authMiddleware(authSVC, method, contains(cfg.Auth.Public, method)))
+		}
+		if tenants != nil {
+			mw = endpoint.Chain(mw, tenantOperationsMiddleware(method))
+		}
+		// Faults can't be injected into the endpoint that removes them.
+		if faults != nil && method != "admin_chaos" {
+			mw = endpoint.Chain(mw, chaosMiddleware(faults, method))
+		}
+		if len(audit) > 0 {
+			mw = endpoint.Chain(mw, auditingMiddleware(audit, logger, method))
+		}
+		if history != nil {
+			mw = endpoint.Chain(mw, historyMiddleware(history, logger, method))
+		}
+		if counters != nil {
+			mw = endpoint.Chain(mw, countingMiddleware(counters, method))
+		}
+		if meter != nil {
+			mw = endpoint.Chain(mw, meteringMiddleware(meter, method))
+		}
+		if publisher != nil {
+			mw = endpoint.Chain(mw, eventsMiddleware(publisher, logger, method))
+		}
+		return endpoint.Chain(mw, validatingMiddleware)
+	}
+
+	uppercaseEndpoint := middlewares("uppercase")(makeUppercaseEndpoint(svc))
+	var canaries *canaryRouter
+	if cfg.Canary.Percents != nil {
+		canaries = newCanaryRouter(cfg.Canary, mf)
+	}
+	countCanary := loggingMiddleware{log.With(logger, "service", "string", "variant", "canary"), runeCountStringService{svc}}
+	countEndpoint := middlewares("count")(canaries.Route("count", makeCountEndpoint(svc), makeCountEndpoint(countCanary)))
+	hostnameEndpoint := middlewares("hostname")(makeHostnameEndpoint(osSVC))
+	addEndpoint := middlewares("add")(makeAddEndpoint(mathSVC))
+	subtractEndpoint := middlewares("subtract")(makeSubtractEndpoint(mathSVC))
+	multiplyEndpoint := middlewares("multiply")(makeMultiplyEndpoint(mathSVC))
+	divideEndpoint := middlewares("divide")(makeDivideEndpoint(mathSVC))
+	nowEndpoint := middlewares("now")(makeNowEndpoint(timeSVC))
+	formatEndpoint := middlewares("format")(makeFormatEndpoint(timeSVC))
+	convertEndpoint := middlewares("convert")(makeConvertEndpoint(timeSVC))
+	uuidEndpoint := middlewares("uuid")(makeUUIDEndpoint(idSVC))
+	ulidEndpoint := middlewares("ulid")(makeULIDEndpoint(idSVC))
+
+	// Hashing is deliberately expensive, so hash and verify share a limit
+	// that keeps them from taking over the CPU.
+	// With -rate-limit-redis-addr the limit is shared by all replicas.
+	passwordLimit := endpoint.Middleware(func(next endpoint.Endpoint) endpoint.Endpoint { return next })
+	if cfg.Crypto.RateLimit > 0 {
+		passwordLimit = rateLimitingMiddleware(limiters.limiter("password", cfg.Crypto.RateLimit, cfg.Crypto.RateBurst))
+	}
+	hashEndpoint := middlewares("hash")(passwordLimit(makeHashEndpoint(cryptoSVC)))
+	verifyEndpoint := middlewares("verify")(passwordLimit(makeVerifyEndpoint(cryptoSVC)))
+	qrcodeEndpoint := middlewares("qrcode")(makeQRCodeEndpoint(qrcodeSVC))
+	detectEndpoint := middlewares("detect")(makeDetectEndpoint(detectSVC))
+	var translateEndpoint endpoint.Endpoint
+	if translateSVC != nil {
+		translateEndpoint = middlewares("translate")(makeTranslateEndpoint(translateSVC))
+	}
+	var spellcheckEndpoint endpoint.Endpoint
+	if spellSVC != nil {
+		spellcheckEndpoint = middlewares("spellcheck")(makeSpellcheckEndpoint(spellSVC))
+	}
+	var summarizeEndpoint, rewriteEndpoint endpoint.Endpoint
+	if writingSVC != nil {
+		summarizeEndpoint = middlewares("summarize")(makeSummarizeEndpoint(writingSVC))
+		rewriteEndpoint = middlewares("rewrite")(makeRewriteEndpoint(writingSVC))
+	}
+	shortenEndpoint := middlewares("shorten")(makeShortenEndpoint(shortenerSVC))
+	resolveEndpoint := middlewares("resolve")(makeResolveEndpoint(shortenerSVC))
+
+	shutdown := func() {
+		stopFeatures()
+		stopEvents()
+		stopPruning()
+		if counters != nil {
+			counters.Close()
+		}
+		stopBilling()
+		if meter != nil {
+			meter.Close()
+		}
+		stores.Close()
+		if limitsRedis != nil {
+			limitsRedis.Close()
+		}
+		if db != nil {
+			db.Close()
+		}
+		if lite != nil {
+			lite.Close()
+		}
+		env.reporter.Flush(2 * time.Second)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		shutdownTracing(ctx)
+		mf.Stop(ctx)
+		cancel()
+	}
+
+	messageEndpoints := map[string]messageEndpoint{
+		"uppercase": {uppercaseEndpoint, decodeUppercaseMessage},
+		"count":     {countEndpoint, decodeCountMessage},
+		"hostname":  {hostnameEndpoint, decodeHostnameMessage},
+		"add":       {addEndpoint, decodeMathMessage},
+		"subtract":  {subtractEndpoint, decodeMathMessage},
+		"multiply":  {multiplyEndpoint, decodeMathMessage},
+		"divide":    {divideEndpoint, decodeMathMessage},
+		"now":       {nowEndpoint, decodeNowMessage},
+		"format":    {formatEndpoint, decodeFormatMessage},
+		"convert":   {convertEndpoint, decodeConvertMessage},
+		"uuid":      {uuidEndpoint, decodeUUIDMessage},
+		"ulid":      {ulidEndpoint, decodeULIDMessage},
+		"hash":      {hashEndpoint, decodeHashMessage},
+		"verify":    {verifyEndpoint, decodeVerifyMessage},
+		"qrcode":    {qrcodeEndpoint, decodeQRCodeMessage},
+		"detect":    {detectEndpoint, decodeDetectMessage},
+		"shorten":   {shortenEndpoint, decodeShortenMessage},
+		"resolve":   {resolveEndpoint, decodeResolveMessage},
+	}
+	if translateEndpoint != nil {
+		messageEndpoints["translate"] = messageEndpoint{translateEndpoint, decodeTranslateMessage}
+	}
+	if spellcheckEndpoint != nil {
+		messageEndpoints["spellcheck"] = messageEndpoint{spellcheckEndpoint, decodeSpellcheckMessage}
+	}
+	if writingSVC != nil {
+		messageEndpoints["summarize"] = messageEndpoint{summarizeEndpoint, decodeSummarizeMessage}
+		messageEndpoints["rewrite"] = messageEndpoint{rewriteEndpoint, decodeRewriteMessage}
+	}
+
+	// Critical dependencies fail startup above when they can't be reached;
+	// the checks catch them going away later, and report the rest.
+	checks := newSelfChecks(now)
+	checks.Register("clock", true, clockCheck(now, !cfg.FixedTime.IsZero()))
+	if cfg.TLS.CertFile != "" {
+		checks.Register("certificate", true, certificateCheck(cfg.TLS, now))
+	}
+	if db != nil {
+		checks.Register("postgres", true, databaseCheck(db, migrate.Postgres))
+	}
+	if lite != nil {
+		checks.Register("sqlite", true, databaseCheck(lite, migrate.SQLite))
+	}
+	if stores.rdb != nil {
+		checks.Register("storage_redis", true, redisCheck(stores.rdb))
+	}
+	if len(cfg.Kafka.Brokers) > 0 {
+		// Events wait in the outbox, or are dropped, while Kafka is down.
+		checks.Register("kafka", false, kafkaCheck(cfg.Kafka.Brokers))
+	}
+	if limitsRedis != nil {
+		checks.Register("rate_limit_redis", false, redisCheck(limitsRedis))
+	}
+	checks.Run(context.Background()).Log(logger)
+
+	options := []httptransport.ServerOption{
+		httptransport.ServerBefore(httptransport.PopulateRequestContext, tracing.HTTPToContext, countRequestBytes),
+		httptransport.ServerErrorEncoder(encodeError),
+		httptransport.ServerErrorHandler(transport.NewLogErrorHandler(level.Error(logger))),
+	}
+	if canaries != nil {
+		options = append(options, httptransport.ServerBefore(canaryHeaderToContext(cfg.Canary.Header)))
+	}
+
+	// In worker mode, HTTP serves only metrics and the admin endpoints,
+	// which need -auth-clients.
+	if cfg.Worker.Enabled() {
+		a := &App{close: shutdown}
+		a.run = func() int {
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			w, err := newWorker(ctx, cfg.Worker, messageEndpoints, env, logger)
+			if err != nil {
+				level.Error(logger).Log("transport", "worker", "err", err)
+				return 1
+			}
+			if dlq := w.DeadLetters(); dlq != nil && authSVC != nil {
+				listDeadLettersHandler := httptransport.NewServer(
+					middlewares("list_dead_letters")(requirePrincipal(makeListDeadLettersEndpoint(dlq))),
+					decodeListDeadLettersRequest,
+					encodeResponse,
+					options...,
+				)
+				redriveDeadLetterHandler := httptransport.NewServer(
+					middlewares("redrive_dead_letter")(requirePrincipal(makeRedriveDeadLetterEndpoint(dlq))),
+					decodeRedriveDeadLetterRequest,
+					encodeResponse,
+					options...,
+				)
+				recovering := recoveryMiddleware(logger)
+				http.Handle("/admin/dlq", env.handler(requestIDMiddleware(recovering(listDeadLettersHandler))))
+				http.Handle("/admin/dlq/", env.handler(requestIDMiddleware(recovering(redriveDeadLetterHandler))))
+			}
+			http.Handle("/readyz", readyzHandler(checks))
+			if h := mf.Handler(); h != nil {
+				http.Handle("/metrics", h)
+			}
+			ln, err := systemdListener()
+			if ln == nil && err == nil {
+				ln, err = listen(cfg.HTTPAddr, cfg.HTTPAddrFile)
+			}
+			if err != nil {
+				level.Error(logger).Log("transport", "HTTP", "err", err)
+				return 1
+			}
+			go func() {
+				level.Error(logger).Log("transport", "HTTP", "err", http.Serve(ln, nil))
+			}()
+			level.Info(logger).Log("transport", "worker", "broker", strings.Join(cfg.Worker.brokers(), ","), "admin_addr", ln.Addr())
+			notifySystemd(daemon.SdNotifyReady, logger)
+			go runWatchdog(ctx, checks, logger)
+			err = w.Run(ctx)
+			notifySystemd(daemon.SdNotifyStopping, logger)
+			stop()
+			a.Close()
+			if err != nil {
+				level.Error(logger).Log("transport", "worker", "err", err)
+				return 1
+			}
+			return 0
+		}
+		return a, nil
+	}
+
+	hostnameHandler := httptransport.NewServer(
+		hostnameEndpoint,
+		decodeHostnameRequest,
 		encodeResponse,
+		options...,
 	)
 
-	countHandler := httptransport.NewServer(
-		makeCountEndpoint(svc),
-		decodeCountRequest,
+	addHandler := httptransport.NewServer(
+		addEndpoint,
+		decodeMathRequest,
 		encodeResponse,
+		options...,
 	)
 
-	hostnameHandler := httptransport.NewServer(
-		makeHostnameEndpoint(osSVC),
-		decodeHostnameRequest,
+	subtractHandler := httptransport.NewServer(
+		subtractEndpoint,
+		decodeMathRequest,
 		encodeResponse,
+		options...,
 	)
 
-	http.Handle("/uppercase", uppercaseHandler)
-	http.Handle("/count", countHandler)
-	http.Handle("/hostname", hostnameHandler)
-	log.Fatal(http.ListenAndServe(":9090", nil))
+	multiplyHandler := httptransport.NewServer(
+		multiplyEndpoint,
+		decodeMathRequest,
+		encodeResponse,
+		options...,
+	)
+
+	divideHandler := httptransport.NewServer(
+		divideEndpoint,
+		decodeMathRequest,
+		encodeResponse,
+		options...,
+	)
+
+	nowHandler := httptransport.NewServer(
+		nowEndpoint,
+		decodeNowRequest,
+		encodeResponse,
+		options...,
+	)
+
+	formatHandler := httptransport.NewServer(
+		formatEndpoint,
+		decodeFormatRequest,
+		encodeResponse,
+		options...,
+	)
+
+	convertHandler := httptransport.NewServer(
+		convertEndpoint,
+		decodeConvertRequest,
+		encodeResponse,
+		options...,
+	)
+
+	uuidHandler := httptransport.NewServer(
+		uuidEndpoint,
+		decodeUUIDRequest,
+		encodeResponse,
+		options...,
+	)
+
+	ulidHandler := httptransport.NewServer(
+		ulidEndpoint,
+		decodeULIDRequest,
+		encodeResponse,
+		options...,
+	)
+
+	hashHandler := httptransport.NewServer(
+		hashEndpoint,
+		decodeHashRequest,
+		encodeResponse,
+		options...,
+	)
+
+	verifyHandler := httptransport.NewServer(
+		verifyEndpoint,
+		decodeVerifyRequest,
+		encodeResponse,
+		options...,
+	)
+
+	qrcodeHandler := httptransport.NewServer(
+		qrcodeEndpoint,
+		decodeQRCodeRequest,
+		encodeQRCodeResponse,
+		options...,
+	)
+
+	detectHandler := httptransport.NewServer(
+		detectEndpoint,
+		decodeDetectRequest,
+		encodeResponse,
+		options...,
+	)
+
+	shortenHandler := httptransport.NewServer(
+		shortenEndpoint,
+		decodeShortenRequest,
+		encodeResponse,
+		options...,
+	)
+
+	resolveHandler := httptransport.NewServer(
+		resolveEndpoint,
+		decodeResolveRequest,
+		encodeResponse,
+		options...,
+	)
+
+	redirectHandler := httptransport.NewServer(
+		resolveEndpoint,
+		decodeRedirectRequest,
+		encodeRedirect,
+		options...,
+	)
+
+	jobs := newJobQueue(cfg.Jobs, messageEndpoints, stores.jobs, now, randomJobID, logger)
+
+	submitJobHandler := httptransport.NewServer(
+		middlewares("submit_job")(makeSubmitJobEndpoint(jobs)),
+		decodeSubmitJobRequest,
+		encodeResponse,
+		options...,
+	)
+
+	getJobHandler := httptransport.NewServer(
+		middlewares("get_job")(makeGetJobEndpoint(jobs)),
+		decodeGetJobRequest,
+		encodeResponse,
+		options...,
+	)
+
+	listJobsHandler := httptransport.NewServer(
+		middlewares("list_jobs")(makeListJobsEndpoint(jobs)),
+		decodeListJobsRequest,
+		encodeResponse,
+		options...,
+	)
+
+	// Only requests that send an Idempotency-Key header are affected.
+	idempotent := idempotencyMiddleware(stores.idempotency, cfg.IdempotencyTTL, cfg.IdempotencyMaxBytes)
+	recovering := recoveryMiddleware(logger)
+	var rec *recorder
+	if cfg.Record.File != "" {
+		if rec, err = newRecorder(cfg.Record, logger); err != nil {
+			return nil, fmt.Errorf("opening recording: %w", err)
+		}
+	}
+	wrappers := []func(http.Handler) http.Handler{requestIDMiddleware, recovering, deadlineMiddleware}
+	// Bearer tokens are authenticated once, here, for every middleware
+	// and endpoint after.
+	if authSVC != nil {
+		wrappers = append(wrappers, authenticatingMiddleware(authSVC))
+	}
+	if cfg.RateLimit.Headers != "off" {
+		wrappers = append(wrappers, rateLimitHeadersMiddleware(cfg.RateLimit.Headers, now))
+	}
+	maint := &maintenance{now: now}
+	if cfg.Maintenance {
+		maint.Set(maintenanceState{Enabled: true})
+		level.Warn(logger).Log("msg", "starting in maintenance mode: calls are refused until it is turned off at /admin/maintenance")
+	}
+	wrappers = append(wrappers, maintenanceMiddleware(maint))
+	if tenants != nil {
+		wrappers = append(wrappers, tenantMiddleware(tenants), tenantConfigMiddleware(stores.tenantConfigs, logger))
+		var counter quotaCounter = newMemoryQuotaCounter(now)
+		if limitsRedis != nil {
+			counter = redisQuotaCounter{limitsRedis, cfg.RateLimit.RedisPrefix + "quota:"}
+		}
+		wrappers = append(wrappers, quotaMiddleware(newQuotaEnforcer(counter, now, logger)))
+	}
+	if experiments != nil {
+		wrappers = append(wrappers, experimentsMiddleware(experiments))
+	}
+	stopReloading := func() {}
+	if cfg.PolicyFile != "" {
+		policies := newPolicySet(cfg.PolicyFile, limiters, now)
+		if err := policies.Load(); err != nil {
+			return nil, fmt.Errorf("reading policy file: %w", err)
+		}
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		stopReloading = func() {
+			signal.Stop(hup)
+			close(hup)
+		}
+		go func() {
+			for range hup {
+				if err := policies.Load(); err != nil {
+					level.Error(logger).Log("msg", "reloading policy file, keeping the policies in force", "err", err)
+					continue
+				}
+				level.Info(logger).Log("msg", "reloaded policy file", "file", cfg.PolicyFile)
+			}
+		}()
+		wrappers = append(wrappers, policyMiddleware(policies))
+	}
+	wrappers = append(wrappers, idempotent)
+	if cfg.PayloadLog {
+		redactor := redact.New(strings.Split(cfg.PayloadLogRedact, ",")...)
+		wrappers = append(wrappers, payloadLoggingMiddleware(logger, redactor, cfg.PayloadLogMaxBytes))
+	}
+	if rec != nil {
+		wrappers = append(wrappers, recordingMiddleware(rec))
+	}
+	stopShadow := func() {}
+	if cfg.Shadow.URL != "" {
+		compare := redact.New(append(strings.Split(cfg.PayloadLogRedact, ","), strings.Split(cfg.Shadow.Ignore, ",")...)...)
+		shadow := newShadower(cfg.Shadow, compare, mf, logger)
+		stopShadow = shadow.Close
+		wrappers = append(wrappers, shadowingMiddleware(shadow))
+		level.Info(logger).Log("msg", "mirroring calls to shadow", "url", cfg.Shadow.URL, "percent", cfg.Shadow.Percent)
+	}
+	mux := http.NewServeMux()
+	serverOpts := []Option{
+		WithRouter(mux),
+		WithStringService(svc),
+		WithMiddlewares(middlewares),
+		WithHTTPMiddleware(wrappers...),
+		WithServerOptions(options...),
+		WithHTTP2(cfg.HTTP2.MaxConcurrentStreams, cfg.HTTP2.IdleTimeout),
+		withAppEnv(env),
+	}
+	if cfg.HTTP2.H2C {
+		serverOpts = append(serverOpts, WithH2C())
+	}
+	handle := newServerOptions(serverOpts).handle
+
+	handle("/hostname", hostnameHandler)
+	handle("/math/add", etagMiddleware(addHandler))
+	handle("/math/subtract", etagMiddleware(subtractHandler))
+	handle("/math/multiply", etagMiddleware(multiplyHandler))
+	handle("/math/divide", etagMiddleware(divideHandler))
+	handle("/time/now", nowHandler)
+	handle("/time/format", etagMiddleware(formatHandler))
+	handle("/time/convert", etagMiddleware(convertHandler))
+	handle("/uuid", uuidHandler)
+	handle("/ulid", ulidHandler)
+	handle("/password/hash", hashHandler)
+	handle("/password/verify", verifyHandler)
+	handle("/qrcode", etagMiddleware(qrcodeHandler))
+	handle("/detect", etagMiddleware(detectHandler))
+	handle("/process/file", sseMiddleware(processFileHandler(svc, cfg.UploadMaxBytes)))
+	handle("/links", shortenHandler)
+	if translateEndpoint != nil {
+		handle("/translate", httptransport.NewServer(
+			translateEndpoint,
+			decodeTranslateRequest,
+			encodeResponse,
+			options...,
+		))
+	}
+	if writingSVC != nil {
+		handle("/summarize", sseMiddleware(httptransport.NewServer(
+			summarizeEndpoint,
+			decodeSummarizeRequest,
+			encodeWritingResponse,
+			options...,
+		)))
+		handle("/rewrite", sseMiddleware(httptransport.NewServer(
+			rewriteEndpoint,
+			decodeRewriteRequest,
+			encodeWritingResponse,
+			options...,
+		)))
+	}
+	if spellcheckEndpoint != nil {
+		handle("/spellcheck", httptransport.NewServer(
+			spellcheckEndpoint,
+			decodeSpellcheckRequest,
+			encodeResponse,
+			options...,
+		))
+	}
+	handle("/links/resolve", resolveHandler)
+	handle("/s/", redirectHandler)
+	handle("/jobs", jobsHandler(submitJobHandler, listJobsHandler))
+	handle("/jobs/", getJobHandler)
+	handle("/bulk", bulkHandler(messageEndpoints, cfg.Bulk))
+	if meter != nil {
+		handle("/usage", httptransport.NewServer(
+			middlewares("usage")(makeUsageEndpoint(meter)),
+			decodeUsageRequest,
+			encodeResponse,
+			options...,
+		))
+		if authSVC != nil {
+			handle("/admin/usage", httptransport.NewServer(
+				middlewares("admin_usage")(requirePrincipal(makeAdminUsageEndpoint(meter))),
+				decodeUsageRequest,
+				encodeResponse,
+				options...,
+			))
+			mux.Handle("/admin/usage/export", requestIDMiddleware(recovering(requireScope(authSVC, "admin_usage", billingExportHandler(meter, now)))))
+		}
+	}
+	if counters != nil {
+		handle("/counters", httptransport.NewServer(
+			middlewares("counters")(makeCountersEndpoint(counters)),
+			decodeCountersRequest,
+			encodeResponse,
+			options...,
+		))
+	}
+	if history != nil {
+		handle("/history", httptransport.NewServer(
+			middlewares("history")(makeHistoryEndpoint(history)),
+			decodeHistoryRequest,
+			encodeResponse,
+			options...,
+		))
+	}
+	if authSVC != nil {
+		handle("/admin/maintenance", httptransport.NewServer(
+			middlewares("admin_maintenance")(requirePrincipal(makeMaintenanceEndpoint(maint))),
+			decodeMaintenanceRequest,
+			encodeResponse,
+			options...,
+		))
+	}
+	if faults != nil && authSVC != nil {
+		handle("/admin/chaos", httptransport.NewServer(
+			middlewares("admin_chaos")(requirePrincipal(makeChaosEndpoint(faults))),
+			decodeChaosRequest,
+			encodeResponse,
+			options...,
+		))
+	}
+	if experiments != nil && authSVC != nil {
+		handle("/admin/experiments", httptransport.NewServer(
+			middlewares("admin_experiments")(requirePrincipal(makeExperimentsEndpoint(experiments))),
+			decodeExperimentsRequest,
+			encodeResponse,
+			options...,
+		))
+	}
+	if features != nil && authSVC != nil {
+		handle("/admin/flags", httptransport.NewServer(
+			middlewares("admin_flags")(requirePrincipal(makeFeaturesEndpoint(features))),
+			decodeFeaturesRequest,
+			encodeResponse,
+			options...,
+		))
+	}
+	if tenants != nil && authSVC != nil {
+		tenantsServer := httptransport.NewServer(
+			middlewares("admin_tenants")(requirePrincipal(makeTenantConfigEndpoint(tenants, stores.tenantConfigs))),
+			decodeTenantConfigRequest,
+			encodeResponse,
+			options...,
+		)
+		handle("/admin/tenants", tenantsServer)
+		handle("/admin/tenants/", tenantsServer)
+	}
+	if authSVC != nil {
+		handle("/admin/config", httptransport.NewServer(
+			middlewares("admin_config")(requirePrincipal(makeConfigEndpoint(cfg.Profile))),
+			decodeConfigRequest,
+			encodeResponse,
+			options...,
+		))
+	}
+	// The export holds every stored token hash, key and link, so it is
+	// only served to operators with a token.
+	if authSVC != nil {
+		mux.Handle("/admin/export", requestIDMiddleware(recovering(requireScope(authSVC, "admin_export", exportHandler(stores, logger)))))
+	}
+	if auditDB != nil && authSVC != nil {
+		handle("/admin/audit", httptransport.NewServer(
+			middlewares("admin_audit")(requirePrincipal(makeAdminAuditEndpoint(auditDB))),
+			decodeAuditRequest,
+			encodeResponse,
+			options...,
+		))
+		mux.Handle("/admin/audit/export", requestIDMiddleware(recovering(requireScope(authSVC, "admin_audit", auditExportHandler(auditDB, logger)))))
+	}
+	if authSVC != nil {
+		handle("/auth/token", httptransport.NewServer(
+			middlewares("token")(passwordLimit(makeTokenEndpoint(authSVC, now))),
+			decodeTokenRequest,
+			encodeResponse,
+			options...,
+		))
+		handle("/auth/revoke", httptransport.NewServer(
+			middlewares("revoke")(makeRevokeEndpoint(authSVC)),
+			decodeRevokeRequest,
+			encodeResponse,
+			options...,
+		))
+		if cfg.Auth.Keys {
+			admin := keyAdmin{store: stores.keys, crypto: cryptoImpl, tenants: tenants, now: now}
+			keysServer := func(method string, e endpoint.Endpoint, dec httptransport.DecodeRequestFunc) http.Handler {
+				return httptransport.NewServer(middlewares(method)(requirePrincipal(e)), dec, encodeResponse, options...)
+			}
+			keys := keysRouter{
+				create: keysServer("admin_keys_create", makeCreateKeyEndpoint(admin), decodeCreateKeyRequest),
+				list:   keysServer("admin_keys_list", makeListKeysEndpoint(admin), decodeListKeysRequest),
+				rotate: keysServer("admin_keys_rotate", makeRotateKeyEndpoint(admin), decodeKeyIDRequest),
+				scope:  keysServer("admin_keys_scope", makeScopeKeyEndpoint(admin), decodeScopeKeyRequest),
+				revoke: keysServer("admin_keys_revoke", makeRevokeKeyEndpoint(admin), decodeKeyIDRequest),
+			}
+			handle("/admin/keys", keys)
+			handle("/admin/keys/", keys)
+		}
+	}
+	mux.Handle("/healthz", healthHandler(db, lite))
+	mux.Handle("/readyz", readyzHandler(checks))
+	if h := mf.Handler(); h != nil {
+		mux.Handle("/metrics", h)
+	}
+	a := &App{
+		srv: NewServer(cfg.HTTPAddr, serverOpts...),
+		close: func() {
+			stopReloading()
+			stopShadow()
+			jobs.Close()
+			shutdown()
+		},
+	}
+	a.run = func() int {
+		if cfg.Upgrade.Enabled {
+			err := serveUpgradable(a.srv, cfg.HTTPAddrFile, cfg.Upgrade, cfg.TLS, logger)
+			a.Close()
+			if err != nil {
+				level.Error(logger).Log("transport", "HTTP", "err", err)
+				return 1
+			}
+			return 0
+		}
+		ln, err := systemdListener()
+		if ln == nil && err == nil {
+			ln, err = listen(a.srv.Addr, cfg.HTTPAddrFile)
+		}
+		if err == nil {
+			level.Info(logger).Log("transport", "HTTP", "addr", ln.Addr())
+			notifySystemd(daemon.SdNotifyReady, logger)
+			go runWatchdog(context.Background(), checks, logger)
+			err = serveHTTP(a.srv, ln, cfg.TLS)
+		}
+		level.Error(logger).Log("transport", "HTTP", "err", err)
+		notifySystemd(daemon.SdNotifyStopping, logger)
+		a.Close()
+		return 1
+	}
+	return a, nil
 }
 
+// listen listens on addr and, if addrFile is set, writes the address
+// listened on to it.
+func listen(addr, addrFile string) (net.Listener, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeAddrFile(ln, addrFile); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// writeAddrFile writes the address ln listens on to addrFile, if set. The
+// file is written whole or not at all, so whoever waits for it never reads
+// a partial address.
+func writeAddrFile(ln net.Listener, addrFile string) error {
+	if addrFile == "" {
+		return nil
+	}
+	tmp := addrFile + ".tmp"
+	if err := os.WriteFile(tmp, []byte(ln.Addr().String()), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, addrFile)
+}
+
+// The read-only operations also take their parameters from the query
+// string when the request has no body, so that they can be called with
+// GET, as in GET /uppercase?s=hello, from curl or a browser, and cached.
+
 func decodeUppercaseRequest(_ context.Context, r *http.Request) (interface{}, error) {
 	var request uppercaseRequest
-	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+	if !hasBody(r) {
+		request.S = r.URL.Query().Get("s")
+	} else if err := decodeBody(r, &request); err != nil {
 		return nil, err
 	}
 	return request, nil
@@ -145,7 +1287,9 @@ func decodeUppercaseRequest(_ context.Context, r *http.Request) (interface{}, er
 
 func decodeCountRequest(_ context.Context, r *http.Request) (interface{}, error) {
 	var request countRequest
-	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+	if !hasBody(r) {
+		request.S = r.URL.Query().Get("s")
+	} else if err := decodeBody(r, &request); err != nil {
 		return nil, err
 	}
 	return request, nil
@@ -153,12 +1297,42 @@ func decodeCountRequest(_ context.Context, r *http.Request) (interface{}, error)
 
 func decodeHostnameRequest(_ context.Context, r *http.Request) (interface{}, error) {
 	var request hostnameRequest
-	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+	if !hasBody(r) {
+		return request, nil
+	}
+	if err := decodeBody(r, &request); err != nil {
 		return nil, err
 	}
 	return request, nil
 }
 
-func encodeResponse(_ context.Context, w http.ResponseWriter, response interface{}) error {
-	return json.NewEncoder(w).Encode(response)
+// encodeResponse is shared by all endpoints. It writes the response in the
+// format negotiated from the Accept header. Business errors reported through
+// endpoint.Failer are handed to encodeError, so they get the same envelope
+// and status mapping as transport errors while still letting endpoint
+// middlewares tell the two apart: only transport errors come back as the
+// endpoint's error value.
+func encodeResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	if f, ok := response.(endpoint.Failer); ok && f.Failed() != nil {
+		encodeError(ctx, f.Failed(), w)
+		return nil
+	}
+	setCacheHeaders(ctx, w)
+	return kit.EncodeResponse(ctx, appEnvFromContext(ctx).codecs, w, response)
+}
+
+// encodeError writes every failure, whether a transport error (decode
+// failures, and anything a middleware rejects) or a business error from the
+// service, as {"err": {"code": ..., "message": ...}} with the HTTP status
+// implied by the code. It falls back to the default codec when nothing in
+// the Accept header is supported.
+func encodeError(ctx context.Context, err error, w http.ResponseWriter) {
+	reportUnexpected(ctx, err)
+	e := svcerrors.From(err)
+	kit.EncodeError(ctx, appEnvFromContext(ctx).codecs, w, e, errorResponse{e})
+}
+
+func acceptHeader(ctx context.Context) string {
+	accept, _ := ctx.Value(httptransport.ContextKeyRequestAccept).(string)
+	return accept
 }
@@ -0,0 +1,96 @@
+package stringsvc
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mcclayac/gokit/testutil"
+)
+
+func TestStringServiceProperties(t *testing.T) {
+	ctx := context.Background()
+	svc := stringService{}
+	uppercase := func(s string) string {
+		v, _ := svc.Uppercase(ctx, s)
+		return v
+	}
+	count := func(s string) int { return svc.Count(ctx, s) }
+	testutil.Check(t,
+		testutil.KeepsValidUTF8("uppercase", uppercase),
+		testutil.Idempotent("uppercase", uppercase),
+		testutil.ReverseInvariant("count", count),
+		testutil.Additive("count", count),
+		testutil.ReverseInvariant("count of uppercase", func(s string) int { return count(uppercase(s)) }),
+	)
+}
+
+func TestUppercaseEmpty(t *testing.T) {
+	if _, err := (stringService{}).Uppercase(context.Background(), ""); err != ErrEmpty {
+		t.Errorf("uppercasing \"\": got error %v, want %v", err, ErrEmpty)
+	}
+}
+
+// newTestServer serves an App made with args until the test ends.
+func newTestServer(t *testing.T, args ...string) *httptest.Server {
+	t.Helper()
+	a, err := NewApp(args, io.Discard, time.Now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(a.Server().Handler)
+	t.Cleanup(func() {
+		srv.Close()
+		a.Close()
+	})
+	return srv
+}
+
+// callTestServer sends a request to srv with header and body, returning
+// the response and its body.
+func callTestServer(t *testing.T, srv *httptest.Server, method, path string, header http.Header, body string) (*http.Response, []byte) {
+	t.Helper()
+	r, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range header {
+		r.Header[k] = v
+	}
+	resp, err := srv.Client().Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, b
+}
+
+func TestNewAppIsolated(t *testing.T) {
+	strict := newTestServer(t, "-strict-json")
+	lax := newTestServer(t)
+	for _, c := range []struct {
+		name string
+		srv  *httptest.Server
+		want int
+	}{
+		{"strict", strict, http.StatusBadRequest},
+		{"lax", lax, http.StatusOK},
+	} {
+		resp, err := http.Post(c.srv.URL+"/uppercase", "application/json", strings.NewReader(`{"s":"hello","x":1}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != c.want {
+			t.Errorf("%s app: got %d for an unknown field, want %d", c.name, resp.StatusCode, c.want)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+// Package migrate brings the service's databases up to date with the SQL
+// migrations embedded in it. Each dialect has its own numbered files, such
+// as postgres/0002_request_history.sql; a migration is applied in a
+// transaction with the row recording it in schema_migrations, so it
+// applies completely or not at all.
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed postgres/*.sql sqlite/*.sql
+var files embed.FS
+
+// Dialect is a database's flavor of SQL.
+type Dialect struct {
+	name string // also the directory of its migrations
+	// lock, if set, is run at the start of each migration's transaction
+	// to keep several processes from applying it at once.
+	lock string
+}
+
+var (
+	// Postgres serializes migrations with a transaction-level advisory
+	// lock, so replicas starting together apply each migration once.
+	Postgres = Dialect{name: "postgres", lock: "SELECT pg_advisory_xact_lock(7283401)"}
+	// SQLite needs no lock: a write transaction excludes other writers.
+	SQLite = Dialect{name: "sqlite"}
+)
+
+func (d Dialect) String() string { return d.name }
+
+const schema = `CREATE TABLE IF NOT EXISTS schema_migrations (
+	version    INTEGER PRIMARY KEY,
+	name       TEXT NOT NULL,
+	applied_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP
+)`
+
+// migration is one embedded file.
+type migration struct {
+	version int
+	name    string
+	sql     string
+}
+
+func migrations(d Dialect) ([]migration, error) {
+	entries, err := fs.ReadDir(files, d.name)
+	if err != nil {
+		return nil, err
+	}
+	var ms []migration
+	for _, e := range entries {
+		n := e.Name()
+		i := strings.IndexByte(n, '_')
+		if i < 0 {
+			return nil, fmt.Errorf("migrate: %s/%s: name isn't VERSION_NAME.sql", d.name, n)
+		}
+		v, err := strconv.Atoi(n[:i])
+		if err != nil {
+			return nil, fmt.Errorf("migrate: %s/%s: name isn't VERSION_NAME.sql", d.name, n)
+		}
+		b, err := fs.ReadFile(files, path.Join(d.name, n))
+		if err != nil {
+			return nil, err
+		}
+		ms = append(ms, migration{v, strings.TrimSuffix(n[i+1:], ".sql"), string(b)})
+	}
+	sort.Slice(ms, func(i, j int) bool { return ms[i].version < ms[j].version })
+	return ms, nil
+}
+
+// Latest returns the version of the newest migration for d.
+func Latest(d Dialect) int {
+	ms, err := migrations(d)
+	if err != nil || len(ms) == 0 {
+		return 0
+	}
+	return ms[len(ms)-1].version
+}
+
+// Version returns the version of the newest migration applied to db. It
+// fails if Up has never been run against db.
+func Version(ctx context.Context, db *sql.DB) (int, error) {
+	var v sql.NullInt64
+	if err := db.QueryRowContext(ctx, `SELECT MAX(version) FROM schema_migrations`).Scan(&v); err != nil {
+		return 0, err
+	}
+	return int(v.Int64), nil
+}
+
+// Up applies the migrations for d that db doesn't have yet, in order, and
+// returns the versions it applied.
+func Up(ctx context.Context, db *sql.DB, d Dialect) ([]int, error) {
+	ms, err := migrations(d)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.ExecContext(ctx, schema); err != nil {
+		return nil, err
+	}
+	var applied []int
+	for _, m := range ms {
+		ok, err := apply(ctx, db, d, m)
+		if err != nil {
+			return applied, fmt.Errorf("migrate: %s %04d_%s: %w", d.name, m.version, m.name, err)
+		}
+		if ok {
+			applied = append(applied, m.version)
+		}
+	}
+	return applied, nil
+}
+
+// apply applies m unless it already has been, reporting whether it did.
+func apply(ctx context.Context, db *sql.DB, d Dialect, m migration) (bool, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	if d.lock != "" {
+		if _, err := tx.ExecContext(ctx, d.lock); err != nil {
+			return false, err
+		}
+	}
+	// Versions are integers from file names, so they are safe to format
+	// into the SQL, which spares us the dialects' parameter syntaxes.
+	var n int
+	if err := tx.QueryRowContext(ctx, fmt.Sprintf(`SELECT COUNT(*) FROM schema_migrations WHERE version = %d`, m.version)).Scan(&n); err != nil {
+		return false, err
+	}
+	if n > 0 {
+		return false, nil
+	}
+	if _, err := tx.ExecContext(ctx, m.sql); err != nil {
+		return false, err
+	}
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(`INSERT INTO schema_migrations (version, name) VALUES (%d, '%s')`, m.version, strings.ReplaceAll(m.name, "'", "''"))); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
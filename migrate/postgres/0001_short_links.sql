@@ -0,0 +1,8 @@
+-- Tables created before migrations existed are adopted as they are.
+CREATE TABLE IF NOT EXISTS short_links (
+	code       TEXT PRIMARY KEY,
+	url        TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL,
+	expires_at TIMESTAMPTZ,
+	hits       BIGINT NOT NULL DEFAULT 0
+);
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS request_history (
+	id           TEXT PRIMARY KEY,
+	time         TIMESTAMPTZ NOT NULL,
+	operation    TEXT NOT NULL,
+	identity     TEXT NOT NULL,
+	request_id   TEXT NOT NULL,
+	input_sha256 TEXT NOT NULL,
+	status       TEXT NOT NULL,
+	result_size  INTEGER NOT NULL,
+	duration_ms  BIGINT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS request_history_time ON request_history (time DESC, id DESC);
+CREATE INDEX IF NOT EXISTS request_history_operation ON request_history (operation, time DESC);
+CREATE INDEX IF NOT EXISTS request_history_identity ON request_history (identity, time DESC);
@@ -0,0 +1,8 @@
+-- Jobs are stored as JSON. expires_at is in Unix nanoseconds, as in
+-- SQLite, so that one store serves both databases.
+CREATE TABLE IF NOT EXISTS jobs (
+	id         TEXT PRIMARY KEY,
+	body       TEXT NOT NULL,
+	expires_at BIGINT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS jobs_expires_at ON jobs (expires_at);
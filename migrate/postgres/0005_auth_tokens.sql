@@ -0,0 +1,11 @@
+-- Issued tokens are stored by the SHA-256 of the token, never the token
+-- itself. scopes is space-separated; times are in Unix nanoseconds.
+CREATE TABLE IF NOT EXISTS auth_tokens (
+	token_sha256 TEXT PRIMARY KEY,
+	id           TEXT NOT NULL,
+	subject      TEXT NOT NULL,
+	scopes       TEXT NOT NULL,
+	created_at   BIGINT NOT NULL,
+	expires_at   BIGINT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS auth_tokens_expires_at ON auth_tokens (expires_at);
@@ -0,0 +1,26 @@
+-- Audit records form one hash chain ordered by seq. Times are in Unix
+-- nanoseconds, as in SQLite, so that one store serves both databases.
+-- Records can't be changed; only retention deletes them.
+CREATE TABLE IF NOT EXISTS audit_records (
+	seq          BIGINT PRIMARY KEY,
+	time         BIGINT NOT NULL,
+	identity     TEXT NOT NULL,
+	method       TEXT NOT NULL,
+	input_sha256 TEXT NOT NULL,
+	status       TEXT NOT NULL,
+	request_id   TEXT NOT NULL,
+	prev_hash    TEXT NOT NULL,
+	hash         TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS audit_records_time ON audit_records (time);
+CREATE INDEX IF NOT EXISTS audit_records_identity ON audit_records (identity, seq);
+
+CREATE OR REPLACE FUNCTION audit_records_immutable() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'audit records are immutable';
+END
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_records_no_update ON audit_records;
+CREATE TRIGGER audit_records_no_update BEFORE UPDATE ON audit_records
+	FOR EACH ROW EXECUTE FUNCTION audit_records_immutable();
@@ -0,0 +1,6 @@
+-- Jobs are listed by the identity that submitted them, newest first.
+-- Jobs stored before this migration have neither and are never listed;
+-- they expire within a day.
+ALTER TABLE jobs ADD COLUMN identity TEXT NOT NULL DEFAULT '';
+ALTER TABLE jobs ADD COLUMN created_at BIGINT NOT NULL DEFAULT 0;
+CREATE INDEX IF NOT EXISTS jobs_identity_created_at ON jobs (identity, created_at, id);
@@ -0,0 +1,4 @@
+-- Tokens are issued to a client of a tenant, when the service is
+-- multi-tenant, and resolve their requests to it. Tokens issued before
+-- this migration belong to no tenant.
+ALTER TABLE auth_tokens ADD COLUMN tenant TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,5 @@
+-- Usage is rolled up by tenant too, when the service is multi-tenant, for
+-- chargeback. Usage rolled up before this migration belongs to no tenant.
+ALTER TABLE usage_rollups ADD COLUMN tenant TEXT NOT NULL DEFAULT '';
+ALTER TABLE usage_rollups DROP CONSTRAINT usage_rollups_pkey;
+ALTER TABLE usage_rollups ADD PRIMARY KEY (month, tenant, identity, operation);
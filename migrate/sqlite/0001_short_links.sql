@@ -0,0 +1,9 @@
+-- SQLite has no time type: times are Unix nanoseconds, which sort and
+-- compare correctly as integers.
+CREATE TABLE IF NOT EXISTS short_links (
+	code       TEXT PRIMARY KEY,
+	url        TEXT NOT NULL,
+	created_at INTEGER NOT NULL,
+	expires_at INTEGER,
+	hits       INTEGER NOT NULL DEFAULT 0
+);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS usage_rollups (
+	month      TEXT NOT NULL,
+	identity   TEXT NOT NULL,
+	operation  TEXT NOT NULL,
+	calls      BIGINT NOT NULL,
+	bytes_in   BIGINT NOT NULL,
+	bytes_out  BIGINT NOT NULL,
+	compute_us BIGINT NOT NULL,
+	PRIMARY KEY (month, identity, operation)
+);
@@ -0,0 +1,20 @@
+-- Audit records form one hash chain ordered by seq. Times are in Unix
+-- nanoseconds. Records can't be changed; only retention deletes them.
+CREATE TABLE IF NOT EXISTS audit_records (
+	seq          INTEGER PRIMARY KEY,
+	time         INTEGER NOT NULL,
+	identity     TEXT NOT NULL,
+	method       TEXT NOT NULL,
+	input_sha256 TEXT NOT NULL,
+	status       TEXT NOT NULL,
+	request_id   TEXT NOT NULL,
+	prev_hash    TEXT NOT NULL,
+	hash         TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS audit_records_time ON audit_records (time);
+CREATE INDEX IF NOT EXISTS audit_records_identity ON audit_records (identity, seq);
+
+CREATE TRIGGER IF NOT EXISTS audit_records_no_update BEFORE UPDATE ON audit_records
+BEGIN
+	SELECT RAISE(ABORT, 'audit records are immutable');
+END;
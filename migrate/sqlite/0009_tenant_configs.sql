@@ -0,0 +1,6 @@
+-- Tenants' overrides of the global configuration, as JSON, set through
+-- /admin/tenants.
+CREATE TABLE IF NOT EXISTS tenant_configs (
+	tenant TEXT PRIMARY KEY,
+	config TEXT NOT NULL
+);
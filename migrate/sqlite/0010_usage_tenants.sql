@@ -0,0 +1,18 @@
+-- Usage is rolled up by tenant too, when the service is multi-tenant, for
+-- chargeback. Usage rolled up before this migration belongs to no tenant.
+-- SQLite can't change a primary key, so the table is rebuilt.
+CREATE TABLE usage_rollups_new (
+	month      TEXT NOT NULL,
+	tenant     TEXT NOT NULL DEFAULT '',
+	identity   TEXT NOT NULL,
+	operation  TEXT NOT NULL,
+	calls      BIGINT NOT NULL,
+	bytes_in   BIGINT NOT NULL,
+	bytes_out  BIGINT NOT NULL,
+	compute_us BIGINT NOT NULL,
+	PRIMARY KEY (month, tenant, identity, operation)
+);
+INSERT INTO usage_rollups_new (month, identity, operation, calls, bytes_in, bytes_out, compute_us)
+	SELECT month, identity, operation, calls, bytes_in, bytes_out, compute_us FROM usage_rollups;
+DROP TABLE usage_rollups;
+ALTER TABLE usage_rollups_new RENAME TO usage_rollups;
@@ -0,0 +1,12 @@
+-- API keys managed through /admin/keys. Secrets are stored as their
+-- bcrypt or argon2id hash; scopes is space-separated; times are in Unix
+-- nanoseconds, 0 if unset. Revoked keys are kept for their history.
+CREATE TABLE IF NOT EXISTS api_keys (
+	id          TEXT PRIMARY KEY,
+	secret_hash TEXT NOT NULL,
+	scopes      TEXT NOT NULL,
+	tenant      TEXT NOT NULL DEFAULT '',
+	created_at  BIGINT NOT NULL,
+	rotated_at  BIGINT NOT NULL DEFAULT 0,
+	revoked_at  BIGINT NOT NULL DEFAULT 0
+);
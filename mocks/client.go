@@ -0,0 +1,153 @@
+package mocks
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mcclayac/gokit/client"
+	"github.com/mcclayac/gokit/svcerrors"
+)
+
+var _ client.Service = (*Client)(nil)
+
+// Client is a fake client.Service. By default it keeps the links it
+// shortens in memory for Resolve, hashes a password by prefixing it with
+// "fake:", and detects no languages. Like the service, it fails with
+// STRING_EMPTY on empty strings and NOT_FOUND on unknown or expired links.
+type Client struct {
+	calls
+	UppercaseFunc func(ctx context.Context, s string) (string, error)
+	CountFunc     func(ctx context.Context, s string) (int, error)
+	HashFunc      func(ctx context.Context, password string) (string, error)
+	VerifyFunc    func(ctx context.Context, password, hash string) (bool, error)
+	ShortenFunc   func(ctx context.Context, url string, ttl time.Duration) (client.Link, error)
+	ResolveFunc   func(ctx context.Context, code string) (client.Link, error)
+	DetectFunc    func(ctx context.Context, s string, max int) ([]client.LanguageGuess, error)
+	// The List funcs default to iterating over nothing.
+	ListHistoryFunc func(ctx context.Context, q client.HistoryQuery) *client.HistoryIterator
+	ListJobsFunc    func(ctx context.Context) *client.JobIterator
+	ListAuditFunc   func(ctx context.Context, q client.AuditQuery) *client.AuditIterator
+
+	mtx   sync.Mutex
+	links map[string]client.Link
+}
+
+// Uppercase implements client.Service.
+func (m *Client) Uppercase(ctx context.Context, s string, _ ...client.CallOption) (string, error) {
+	m.record("Uppercase")
+	if m.UppercaseFunc != nil {
+		return m.UppercaseFunc(ctx, s)
+	}
+	if s == "" {
+		return "", svcerrors.ErrStringEmpty
+	}
+	return strings.ToUpper(s), nil
+}
+
+// Count implements client.Service.
+func (m *Client) Count(ctx context.Context, s string, _ ...client.CallOption) (int, error) {
+	m.record("Count")
+	if m.CountFunc != nil {
+		return m.CountFunc(ctx, s)
+	}
+	return len(s), nil
+}
+
+// Hash implements client.Service.
+func (m *Client) Hash(ctx context.Context, password string, _ ...client.CallOption) (string, error) {
+	m.record("Hash")
+	if m.HashFunc != nil {
+		return m.HashFunc(ctx, password)
+	}
+	return "fake:" + password, nil
+}
+
+// Verify implements client.Service.
+func (m *Client) Verify(ctx context.Context, password, hash string, _ ...client.CallOption) (bool, error) {
+	m.record("Verify")
+	if m.VerifyFunc != nil {
+		return m.VerifyFunc(ctx, password, hash)
+	}
+	if !strings.HasPrefix(hash, "fake:") {
+		return false, svcerrors.Errorf(svcerrors.CodeHashInvalid, "malformed hash")
+	}
+	return hash == "fake:"+password, nil
+}
+
+// Shorten implements client.Service. Codes are numbered from 1.
+func (m *Client) Shorten(ctx context.Context, url string, ttl time.Duration, _ ...client.CallOption) (client.Link, error) {
+	m.record("Shorten")
+	if m.ShortenFunc != nil {
+		return m.ShortenFunc(ctx, url, ttl)
+	}
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if m.links == nil {
+		m.links = map[string]client.Link{}
+	}
+	l := client.Link{Code: strconv.Itoa(len(m.links) + 1), URL: url, Created: time.Now().UTC()}
+	if ttl > 0 {
+		l.Expires = l.Created.Add(ttl)
+	}
+	m.links[l.Code] = l
+	return l, nil
+}
+
+// Resolve implements client.Service.
+func (m *Client) Resolve(ctx context.Context, code string, _ ...client.CallOption) (client.Link, error) {
+	m.record("Resolve")
+	if m.ResolveFunc != nil {
+		return m.ResolveFunc(ctx, code)
+	}
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	l, ok := m.links[code]
+	if !ok || (!l.Expires.IsZero() && !time.Now().Before(l.Expires)) {
+		return client.Link{}, svcerrors.Errorf(svcerrors.CodeNotFound, "no link %q", code)
+	}
+	l.Hits++
+	m.links[code] = l
+	return l, nil
+}
+
+// Detect implements client.Service.
+func (m *Client) Detect(ctx context.Context, s string, max int, _ ...client.CallOption) ([]client.LanguageGuess, error) {
+	m.record("Detect")
+	if m.DetectFunc != nil {
+		return m.DetectFunc(ctx, s, max)
+	}
+	if s == "" {
+		return nil, svcerrors.ErrStringEmpty
+	}
+	return []client.LanguageGuess{}, nil
+}
+
+// ListHistory implements client.Service.
+func (m *Client) ListHistory(ctx context.Context, q client.HistoryQuery, _ ...client.CallOption) *client.HistoryIterator {
+	m.record("ListHistory")
+	if m.ListHistoryFunc != nil {
+		return m.ListHistoryFunc(ctx, q)
+	}
+	return client.NewHistoryIterator()
+}
+
+// ListJobs implements client.Service.
+func (m *Client) ListJobs(ctx context.Context, _ ...client.CallOption) *client.JobIterator {
+	m.record("ListJobs")
+	if m.ListJobsFunc != nil {
+		return m.ListJobsFunc(ctx)
+	}
+	return client.NewJobIterator()
+}
+
+// ListAudit implements client.Service.
+func (m *Client) ListAudit(ctx context.Context, q client.AuditQuery, _ ...client.CallOption) *client.AuditIterator {
+	m.record("ListAudit")
+	if m.ListAuditFunc != nil {
+		return m.ListAuditFunc(ctx, q)
+	}
+	return client.NewAuditIterator()
+}
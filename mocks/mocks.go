@@ -0,0 +1,84 @@
+// Package mocks provides fakes of the string service's interfaces, for
+// testing code that uses the service without running it. Each fake's
+// methods call the matching Func field when it is set, and otherwise
+// behave like a plausible service; all of them count their calls.
+//
+// StringService and OSInfoService have the method sets of the service's
+// interfaces of the same names, and Client implements client.Service.
+package mocks
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/mcclayac/gokit/svcerrors"
+)
+
+// calls counts the calls to each method of a fake.
+type calls struct {
+	mtx sync.Mutex
+	n   map[string]int
+}
+
+func (c *calls) record(method string) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.n == nil {
+		c.n = map[string]int{}
+	}
+	c.n[method]++
+}
+
+// Calls returns how many times method has been called.
+func (c *calls) Calls(method string) int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.n[method]
+}
+
+// StringService is a fake StringService. By default Uppercase upper-cases
+// its input, failing with STRING_EMPTY on an empty one, and Count returns
+// its length in bytes.
+type StringService struct {
+	calls
+	UppercaseFunc func(ctx context.Context, s string) (string, error)
+	CountFunc     func(ctx context.Context, s string) int
+}
+
+// Uppercase implements StringService.
+func (m *StringService) Uppercase(ctx context.Context, s string) (string, error) {
+	m.record("Uppercase")
+	if m.UppercaseFunc != nil {
+		return m.UppercaseFunc(ctx, s)
+	}
+	if s == "" {
+		return "", svcerrors.ErrStringEmpty
+	}
+	return strings.ToUpper(s), nil
+}
+
+// Count implements StringService.
+func (m *StringService) Count(ctx context.Context, s string) int {
+	m.record("Count")
+	if m.CountFunc != nil {
+		return m.CountFunc(ctx, s)
+	}
+	return len(s)
+}
+
+// OSInfoService is a fake OSInfoService. By default Hostname returns
+// "localhost".
+type OSInfoService struct {
+	calls
+	HostnameFunc func(ctx context.Context) (string, error)
+}
+
+// Hostname implements OSInfoService.
+func (m *OSInfoService) Hostname(ctx context.Context) (string, error) {
+	m.record("Hostname")
+	if m.HostnameFunc != nil {
+		return m.HostnameFunc(ctx)
+	}
+	return "localhost", nil
+}
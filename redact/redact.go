@@ -0,0 +1,68 @@
+// Package redact masks selected values in JSON documents, so that payloads
+// can be logged without the user content they carry.
+package redact
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+// Mask replaces every redacted value.
+const Mask = "[REDACTED]"
+
+// Redactor masks the values at a set of paths. A path is a dot-separated
+// list of object keys, where * matches any key or any array element:
+// "s" masks the top-level s field, "err.fields.*.message" masks the message
+// of every entry in err.fields. Masked values are replaced whole, whatever
+// their type.
+type Redactor struct {
+	paths [][]string
+}
+
+// New returns a Redactor for paths. Empty paths are ignored.
+func New(paths ...string) *Redactor {
+	r := &Redactor{}
+	for _, p := range paths {
+		if p = strings.TrimSpace(p); p != "" {
+			r.paths = append(r.paths, strings.Split(p, "."))
+		}
+	}
+	return r
+}
+
+// JSON returns doc with the values at r's paths masked. It returns an error
+// if doc isn't valid JSON.
+func (r *Redactor) JSON(doc []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(doc))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	for _, p := range r.paths {
+		v = mask(v, p)
+	}
+	return json.Marshal(v)
+}
+
+func mask(v interface{}, path []string) interface{} {
+	if len(path) == 0 {
+		return Mask
+	}
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, child := range t {
+			if path[0] == "*" || path[0] == k {
+				t[k] = mask(child, path[1:])
+			}
+		}
+	case []interface{}:
+		if path[0] == "*" {
+			for i, child := range t {
+				t[i] = mask(child, path[1:])
+			}
+		}
+	}
+	return v
+}
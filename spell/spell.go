@@ -0,0 +1,160 @@
+// Package spell checks words against a dictionary and suggests corrections
+// for the ones it doesn't contain.
+package spell
+
+import (
+	"bufio"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Dictionary is a set of known words. It is safe for concurrent use once
+// loaded.
+type Dictionary struct {
+	words   map[string]bool // lower case
+	letters []rune          // every letter used by the words, for suggestions
+}
+
+// Load reads a dictionary with one word per line. Hunspell .dic files are
+// accepted: a first line holding only the word count is skipped, as are
+// the affix flags after a slash, which are not expanded. Blank lines and
+// lines starting with # are ignored.
+func Load(r io.Reader) (*Dictionary, error) {
+	d := &Dictionary{words: map[string]bool{}}
+	letters := map[rune]bool{}
+	sc := bufio.NewScanner(r)
+	for first := true; sc.Scan(); first = false {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if _, err := strconv.Atoi(line); err == nil && first {
+			continue
+		}
+		if i := strings.IndexAny(line, "/\t "); i >= 0 {
+			line = line[:i]
+		}
+		w := strings.ToLower(line)
+		d.words[w] = true
+		for _, r := range w {
+			letters[r] = true
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	for r := range letters {
+		d.letters = append(d.letters, r)
+	}
+	sort.Slice(d.letters, func(i, j int) bool { return d.letters[i] < d.letters[j] })
+	return d, nil
+}
+
+// Len returns the number of words in d.
+func (d *Dictionary) Len() int {
+	return len(d.words)
+}
+
+// Contains reports whether word is in d, ignoring case.
+func (d *Dictionary) Contains(word string) bool {
+	return d.words[strings.ToLower(word)]
+}
+
+// maxDistance2Len is the longest word for which Suggest looks two edits
+// away, which takes time quadratic in the word's length.
+const maxDistance2Len = 8
+
+// Suggest returns up to max words in d that are one edit (a deletion,
+// transposition, replacement or insertion) away from word or, when there
+// are none, two edits away. They are sorted, and in lower case.
+func (d *Dictionary) Suggest(word string, max int) []string {
+	w := []rune(strings.ToLower(word))
+	found := map[string]bool{}
+	edits1 := d.edits(w)
+	for _, e := range edits1 {
+		if d.words[e] {
+			found[e] = true
+		}
+	}
+	if len(found) == 0 && len(w) <= maxDistance2Len {
+		for _, e1 := range edits1 {
+			for _, e2 := range d.edits([]rune(e1)) {
+				if d.words[e2] {
+					found[e2] = true
+				}
+			}
+		}
+	}
+	suggestions := make([]string, 0, len(found))
+	for s := range found {
+		suggestions = append(suggestions, s)
+	}
+	sort.Strings(suggestions)
+	if len(suggestions) > max {
+		suggestions = suggestions[:max]
+	}
+	return suggestions
+}
+
+// edits returns the strings one edit away from w.
+func (d *Dictionary) edits(w []rune) []string {
+	var out []string
+	for i := 0; i <= len(w); i++ {
+		head, tail := w[:i], w[i:]
+		if len(tail) > 0 {
+			out = append(out, string(head)+string(tail[1:]))
+		}
+		if len(tail) > 1 {
+			out = append(out, string(head)+string(tail[1])+string(tail[0])+string(tail[2:]))
+		}
+		for _, r := range d.letters {
+			if len(tail) > 0 {
+				out = append(out, string(head)+string(r)+string(tail[1:]))
+			}
+			out = append(out, string(head)+string(r)+string(tail))
+		}
+	}
+	return out
+}
+
+// Token is a word in a text.
+type Token struct {
+	Word   string
+	Offset int // in bytes from the start of the text
+}
+
+// Tokenize splits text into words: runs of letters, with apostrophes and
+// hyphens allowed between letters. Tokens containing digits are skipped.
+func Tokenize(text string) []Token {
+	var tokens []Token
+	start := -1
+	digits := false
+	flush := func(end int) {
+		if start >= 0 && !digits {
+			w := strings.TrimRight(text[start:end], "'-’")
+			tokens = append(tokens, Token{w, start})
+		}
+		start, digits = -1, false
+	}
+	for i, r := range text {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if start < 0 {
+				start = i
+			}
+			digits = digits || unicode.IsDigit(r)
+		case (r == '\'' || r == '’' || r == '-') && start >= 0:
+			if next, _ := utf8.DecodeRuneInString(text[i+utf8.RuneLen(r):]); !unicode.IsLetter(next) {
+				flush(i)
+			}
+		default:
+			flush(i)
+		}
+	}
+	flush(len(text))
+	return tokens
+}
@@ -0,0 +1,240 @@
+package stringsvc
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+
+	"github.com/mcclayac/gokit/logging"
+)
+
+// An archive is the stored state of the service as JSON lines: a header,
+// an entry for each short link, history record, job, issued token, API
+// key and tenant's overrides, and an end marker, without which the archive is truncated. Expired entries
+// are left out. Tokens are archived by hash, so an archive can't be used
+// to call the service, but it should still be kept as carefully as the
+// database.
+const archiveVersion = 1
+
+type archiveEntry struct {
+	Kind         string                `json:"kind"` // "header", "link", "history", "job", "token", "key", "tenant_config" or "end"
+	Header       *archiveHeader        `json:"header,omitempty"`
+	Link         *shortLink            `json:"link,omitempty"`
+	History      *historyRecord        `json:"history,omitempty"`
+	Job          *archivedJob          `json:"job,omitempty"`
+	Token        *archivedToken        `json:"token,omitempty"`
+	Key          *apiKey               `json:"key,omitempty"`
+	TenantConfig *archivedTenantConfig `json:"tenant_config,omitempty"`
+}
+
+type archiveHeader struct {
+	Version        int       `json:"version"`
+	Created        time.Time `json:"created"`
+	ServiceVersion string    `json:"service_version"`
+	Storage        string    `json:"storage"` // the backend exported from
+}
+
+type archivedJob struct {
+	Job     job       `json:"job"`
+	Expires time.Time `json:"expires"`
+}
+
+type archivedToken struct {
+	Hash  string      `json:"sha256"`
+	Token issuedToken `json:"token"`
+}
+
+type archivedTenantConfig struct {
+	Tenant    string          `json:"tenant"`
+	Overrides tenantOverrides `json:"overrides"`
+}
+
+// archiveHistoryBatch is how many history records are read at a time.
+const archiveHistoryBatch = 1000
+
+// writeArchive writes everything in s to w.
+func writeArchive(ctx context.Context, w io.Writer, s *storage) error {
+	enc := json.NewEncoder(w)
+	now := time.Now().UTC()
+	if err := enc.Encode(archiveEntry{Kind: "header", Header: &archiveHeader{archiveVersion, now, version, s.backend}}); err != nil {
+		return err
+	}
+	if err := s.links.Export(ctx, now, func(l shortLink) error {
+		return enc.Encode(archiveEntry{Kind: "link", Link: &l})
+	}); err != nil {
+		return fmt.Errorf("exporting links: %v", err)
+	}
+	q := historyQuery{Limit: archiveHistoryBatch}
+	for {
+		records, err := s.history.List(ctx, q)
+		if err != nil {
+			return fmt.Errorf("exporting history: %v", err)
+		}
+		for i := range records {
+			if err := enc.Encode(archiveEntry{Kind: "history", History: &records[i]}); err != nil {
+				return err
+			}
+		}
+		if len(records) < q.Limit {
+			break
+		}
+		last := records[len(records)-1]
+		q.After = &historyCursor{last.Time, last.ID}
+	}
+	if err := s.jobs.Export(ctx, func(j job, expires time.Time) error {
+		return enc.Encode(archiveEntry{Kind: "job", Job: &archivedJob{j, expires}})
+	}); err != nil {
+		return fmt.Errorf("exporting jobs: %v", err)
+	}
+	if err := s.tokens.Export(ctx, now, func(hash string, t issuedToken) error {
+		return enc.Encode(archiveEntry{Kind: "token", Token: &archivedToken{hash, t}})
+	}); err != nil {
+		return fmt.Errorf("exporting tokens: %v", err)
+	}
+	keys, err := s.keys.List(ctx)
+	if err != nil {
+		return fmt.Errorf("exporting keys: %v", err)
+	}
+	for i := range keys {
+		if err := enc.Encode(archiveEntry{Kind: "key", Key: &keys[i]}); err != nil {
+			return err
+		}
+	}
+	configs, err := s.tenantConfigs.List(ctx)
+	if err != nil {
+		return fmt.Errorf("exporting tenant configs: %v", err)
+	}
+	for id, o := range configs {
+		if err := enc.Encode(archiveEntry{Kind: "tenant_config", TenantConfig: &archivedTenantConfig{id, o}}); err != nil {
+			return err
+		}
+	}
+	return enc.Encode(archiveEntry{Kind: "end"})
+}
+
+// exportHandler serves /admin/export, streaming an archive of s.
+func exportHandler(s *storage, logger log.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", `attachment; filename="stringsvc.ndjson"`)
+		w.Header().Set("Cache-Control", "no-store")
+		if err := writeArchive(r.Context(), w, s); err != nil {
+			// The status has been sent, so the archive just ends without
+			// its end marker.
+			level.Error(logger).Log("msg", "exporting archive", "err", err)
+		}
+	})
+}
+
+// restoreArchive loads the archive read from r into s, returning the
+// number of entries of each kind it loaded. It stops at the first entry
+// that can't be stored, such as a link whose code is taken, so s should
+// be empty.
+func restoreArchive(ctx context.Context, r io.Reader, s *storage) (map[string]int, error) {
+	counts := map[string]int{}
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 16<<20) // jobs carry their results
+	for line := 1; sc.Scan(); line++ {
+		var e archiveEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return counts, fmt.Errorf("line %d: %v", line, err)
+		}
+		if line == 1 && (e.Header == nil || e.Header.Version != archiveVersion) {
+			return counts, fmt.Errorf("not a version %d archive", archiveVersion)
+		}
+		var err error
+		switch {
+		case e.Kind == "header":
+			continue
+		case e.Kind == "end":
+			return counts, nil
+		case e.Kind == "link" && e.Link != nil:
+			err = s.links.Create(ctx, *e.Link)
+		case e.Kind == "history" && e.History != nil:
+			err = s.history.Record(ctx, *e.History)
+		case e.Kind == "job" && e.Job != nil:
+			err = s.jobs.Put(ctx, e.Job.Job, e.Job.Expires)
+		case e.Kind == "token" && e.Token != nil:
+			err = s.tokens.Create(ctx, e.Token.Hash, e.Token.Token)
+		case e.Kind == "key" && e.Key != nil:
+			err = s.keys.Create(ctx, *e.Key)
+		case e.Kind == "tenant_config" && e.TenantConfig != nil:
+			err = s.tenantConfigs.Put(ctx, e.TenantConfig.Tenant, e.TenantConfig.Overrides)
+		default:
+			err = fmt.Errorf("unknown entry kind %q", e.Kind)
+		}
+		if err != nil {
+			return counts, fmt.Errorf("line %d: %s: %v", line, e.Kind, err)
+		}
+		counts[e.Kind]++
+	}
+	if err := sc.Err(); err != nil {
+		return counts, err
+	}
+	return counts, errors.New("archive is truncated: no end marker")
+}
+
+// runRestore is the restore subcommand: it loads an archive read from
+// stdin into the storage configured by args, migrating the databases
+// first unless -migrate-on-start=false.
+func runRestore(args []string) int {
+	cfg, err := parseConfig("stringsvc restore", args)
+	if err != nil {
+		return 2
+	}
+	logger, err := logging.New(os.Stderr, cfg.Log)
+	if err != nil {
+		log.NewLogfmtLogger(os.Stderr).Log("err", err)
+		return 2
+	}
+	if cfg.Storage.Backend == "memory" || (cfg.Storage.Backend == "" && cfg.PostgresDSN == "" && cfg.SQLitePath == "") {
+		level.Error(logger).Log("msg", "restore needs persistent storage: -postgres-dsn, -sqlite-path or -storage redis")
+		return 2
+	}
+	ctx := context.Background()
+	var pg, lite *sql.DB
+	if cfg.PostgresDSN != "" {
+		if pg, err = openDatabase(ctx, cfg.PostgresDSN); err != nil {
+			level.Error(logger).Log("msg", "connecting to database", "err", err)
+			return 1
+		}
+		defer pg.Close()
+	}
+	if cfg.SQLitePath != "" {
+		if lite, err = openSQLite(ctx, cfg.SQLitePath); err != nil {
+			level.Error(logger).Log("msg", "opening SQLite database", "err", err)
+			return 1
+		}
+		defer lite.Close()
+	}
+	if cfg.MigrateOnStart {
+		if err := migrateDatabases(ctx, pg, lite, logger); err != nil {
+			level.Error(logger).Log("msg", "migrating", "err", err)
+			return 1
+		}
+	}
+	s, err := openStorage(ctx, cfg.Storage, pg, lite, time.Now)
+	if err != nil {
+		level.Error(logger).Log("msg", "opening storage", "err", err)
+		return 1
+	}
+	defer s.Close()
+	counts, err := restoreArchive(ctx, os.Stdin, s)
+	level.Info(logger).Log("msg", "restored", "storage", s.backend,
+		"links", counts["link"], "history", counts["history"], "jobs", counts["job"], "tokens", counts["token"], "keys", counts["key"], "tenant_configs", counts["tenant_config"])
+	if err != nil {
+		level.Error(logger).Log("msg", "restoring", "err", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,182 @@
+package stringsvc
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"gopkg.in/natefinch/lumberjack.v2"
+
+	"github.com/mcclayac/gokit/kit"
+)
+
+// auditRecord is one line of the audit log. Records form a hash chain: Hash
+// is the SHA-256 of the record encoded with Hash empty, and PrevHash is the
+// Hash of the record before it, so editing, removing or reordering records
+// breaks the chain from that point on.
+type auditRecord struct {
+	Seq       int64     `json:"seq,omitempty"` // position in the database's chain; unset in files
+	Time      time.Time `json:"time"`
+	Identity  string    `json:"identity"`
+	Method    string    `json:"method"`
+	InputHash string    `json:"input_sha256"`
+	Status    string    `json:"status"`
+	RequestID string    `json:"request_id,omitempty"`
+	PrevHash  string    `json:"prev_hash"`
+	Hash      string    `json:"hash"`
+}
+
+// auditSink keeps audit records. Each sink chains the records it keeps,
+// so a record has a different PrevHash and Hash in each.
+type auditSink interface {
+	Append(ctx context.Context, rec auditRecord) error
+}
+
+// auditSinks appends each record to all of its sinks.
+type auditSinks []auditSink
+
+func (s auditSinks) Append(ctx context.Context, rec auditRecord) error {
+	var first error
+	for _, sink := range s {
+		if err := sink.Append(ctx, rec); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// chainAudit links rec to the record whose hash is prev.
+func chainAudit(prev string, rec auditRecord) (auditRecord, error) {
+	rec.PrevHash = prev
+	rec.Hash = ""
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return auditRecord{}, err
+	}
+	sum := sha256.Sum256(b)
+	rec.Hash = hex.EncodeToString(sum[:])
+	return rec, nil
+}
+
+// auditLog appends chained records to w as JSON lines.
+type auditLog struct {
+	mtx  sync.Mutex
+	w    io.Writer
+	last string // hash of the last record written
+}
+
+// newAuditLog returns an audit log that writes to path, rotating it when it
+// grows past maxSizeMB and keeping maxBackups old files for up to
+// retention (forever when zero). The chain resumes from the last record
+// already in the file. A path of "-" writes to stdout, for shipping to an
+// external collector.
+func newAuditLog(path string, maxSizeMB, maxBackups int, retention time.Duration) (*auditLog, error) {
+	if path == "-" {
+		return &auditLog{w: os.Stdout}, nil
+	}
+	last, err := lastAuditHash(path)
+	if err != nil {
+		return nil, err
+	}
+	return &auditLog{
+		w: &lumberjack.Logger{
+			Filename:   path,
+			MaxSize:    maxSizeMB,
+			MaxBackups: maxBackups,
+			MaxAge:     int((retention + 24*time.Hour - 1) / (24 * time.Hour)),
+		},
+		last: last,
+	}, nil
+}
+
+// lastAuditHash returns the hash of the last record in the file at path, or
+// "" if there is no such file.
+func lastAuditHash(path string) (string, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	var last string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		var rec auditRecord
+		if err := json.Unmarshal(s.Bytes(), &rec); err == nil {
+			last = rec.Hash
+		}
+	}
+	return last, s.Err()
+}
+
+// Append links rec to the chain and writes it.
+func (l *auditLog) Append(_ context.Context, rec auditRecord) error {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	rec, err := chainAudit(l.last, rec)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := l.w.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	l.last = rec.Hash
+	return nil
+}
+
+// secretRequest is implemented by requests that carry secrets such as
+// passwords, which an unsalted hash would not protect from guessing.
+type secretRequest interface {
+	secret()
+}
+
+// inputHash identifies a request without revealing it: the hex SHA-256 of
+// its JSON encoding. It is empty for secret requests.
+func inputHash(request interface{}) string {
+	if _, ok := request.(secretRequest); ok {
+		return ""
+	}
+	b, _ := json.Marshal(request)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// auditingMiddleware appends a record of every call to the audit log. The
+// input is recorded only as a hash. Failing to write the record is logged
+// but doesn't fail the call.
+func auditingMiddleware(audit auditSink, logger log.Logger, method string) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+			defer func(begin time.Time) {
+				if aerr := audit.Append(ctx, auditRecord{
+					Time:      begin.UTC(),
+					Identity:  identity(ctx),
+					Method:    method,
+					InputHash: inputHash(request),
+					Status:    kit.OutcomeCode(response, err),
+					RequestID: requestID(ctx),
+				}); aerr != nil {
+					level.Error(logger).Log("msg", "writing audit record", "method", method, "err", aerr)
+				}
+			}(time.Now())
+			return next(ctx, request)
+		}
+	}
+}
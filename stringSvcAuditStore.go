@@ -0,0 +1,297 @@
+package stringsvc
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// sqlAuditStore keeps audit records in the audit_records table in
+// PostgreSQL or SQLite, as one hash chain ordered by Seq however many
+// replicas append to it. The table refuses updates; records are only
+// deleted by Prune. Times are in Unix nanoseconds in both databases.
+type sqlAuditStore struct {
+	db          *sql.DB
+	placeholder func(n int) string
+	// lock, if set, is run at the start of each append's transaction so
+	// that replicas append one at a time.
+	lock string
+	mtx  sync.Mutex // serializes this process's appends
+}
+
+func newPostgresAuditStore(db *sql.DB) *sqlAuditStore {
+	return &sqlAuditStore{
+		db:          db,
+		placeholder: func(n int) string { return fmt.Sprintf("$%d", n) },
+		lock:        "SELECT pg_advisory_xact_lock(7283402)",
+	}
+}
+
+func newSQLiteAuditStore(db *sql.DB) *sqlAuditStore {
+	return &sqlAuditStore{db: db, placeholder: func(n int) string { return fmt.Sprintf("?%d", n) }}
+}
+
+// auditAppendTimeout bounds an append. Appends don't use the caller's
+// context, since canceled calls must be recorded too.
+const auditAppendTimeout = 5 * time.Second
+
+// Append links rec to the last record in the table and inserts it.
+func (s *sqlAuditStore) Append(_ context.Context, rec auditRecord) error {
+	ctx, cancel := context.WithTimeout(context.Background(), auditAppendTimeout)
+	defer cancel()
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if s.lock != "" {
+		if _, err := tx.ExecContext(ctx, s.lock); err != nil {
+			return err
+		}
+	}
+	var prev string
+	err = tx.QueryRowContext(ctx, `SELECT seq, hash FROM audit_records ORDER BY seq DESC LIMIT 1`).Scan(&rec.Seq, &prev)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	rec.Seq++
+	if rec, err = chainAudit(prev, rec); err != nil {
+		return err
+	}
+	p := s.placeholder
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(`
+		INSERT INTO audit_records (seq, time, identity, method, input_sha256, status, request_id, prev_hash, hash)
+		VALUES (%s, %s, %s, %s, %s, %s, %s, %s, %s)`, p(1), p(2), p(3), p(4), p(5), p(6), p(7), p(8), p(9)),
+		rec.Seq, rec.Time.UnixNano(), rec.Identity, rec.Method, rec.InputHash, rec.Status, rec.RequestID, rec.PrevHash, rec.Hash); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// auditQuery selects audit records. Zero fields don't filter.
+type auditQuery struct {
+	Identity string
+	Method   string
+	Since    time.Time // inclusive
+	Until    time.Time // exclusive
+	AfterSeq int64
+	Limit    int
+}
+
+// List returns the records matching q, oldest first.
+func (s *sqlAuditStore) List(ctx context.Context, q auditQuery) ([]auditRecord, error) {
+	var (
+		where []string
+		args  []interface{}
+	)
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		where = append(where, fmt.Sprintf(cond, s.placeholder(len(args))))
+	}
+	add("seq > %s", q.AfterSeq)
+	if q.Identity != "" {
+		add("identity = %s", q.Identity)
+	}
+	if q.Method != "" {
+		add("method = %s", q.Method)
+	}
+	if !q.Since.IsZero() {
+		add("time >= %s", q.Since.UnixNano())
+	}
+	if !q.Until.IsZero() {
+		add("time < %s", q.Until.UnixNano())
+	}
+	args = append(args, q.Limit)
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT seq, time, identity, method, input_sha256, status, request_id, prev_hash, hash
+		FROM audit_records WHERE %s ORDER BY seq LIMIT %s`,
+		strings.Join(where, " AND "), s.placeholder(len(args))), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	records := []auditRecord{}
+	for rows.Next() {
+		var (
+			r auditRecord
+			t int64
+		)
+		if err := rows.Scan(&r.Seq, &t, &r.Identity, &r.Method, &r.InputHash, &r.Status, &r.RequestID, &r.PrevHash, &r.Hash); err != nil {
+			return nil, err
+		}
+		r.Time = time.Unix(0, t).UTC()
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
+
+// Prune deletes the records from before cutoff, returning how many it
+// deleted. The newest record is always kept, so the chain carries on from
+// it.
+func (s *sqlAuditStore) Prune(ctx context.Context, cutoff time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx, fmt.Sprintf(`
+		DELETE FROM audit_records
+		WHERE time < %s AND seq < (SELECT MAX(seq) FROM audit_records)`, s.placeholder(1)),
+		cutoff.UnixNano())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// auditPruneInterval is how often records past their retention are
+// deleted.
+const auditPruneInterval = time.Hour
+
+// pruneAudit deletes the records in s older than retention, now and then
+// every auditPruneInterval, until ctx is done.
+func pruneAudit(ctx context.Context, s *sqlAuditStore, retention time.Duration, logger log.Logger) {
+	ticker := time.NewTicker(auditPruneInterval)
+	defer ticker.Stop()
+	for {
+		n, err := s.Prune(ctx, time.Now().Add(-retention))
+		if err != nil && ctx.Err() == nil {
+			level.Error(logger).Log("msg", "pruning audit records", "err", err)
+		} else if n > 0 {
+			level.Info(logger).Log("msg", "pruned audit records", "count", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+type auditRequest struct {
+	Identity  string `json:"identity" validate:"max=256"`
+	Method    string `json:"method" validate:"max=64"`
+	Since     time.Time
+	Until     time.Time
+	Limit     int `json:"limit" validate:"min=1,max=1000"`
+	PageToken string
+}
+
+type auditResponse struct {
+	Records []auditRecord `json:"records" xml:"records>record"`
+	// NextPageToken is passed as page_token to get the records after these.
+	// It is empty on the last page.
+	NextPageToken string `json:"next_page_token,omitempty" xml:"next_page_token,omitempty"`
+	next          string // the next page's URL
+}
+
+// Headers implements httptransport.Headerer.
+func (r auditResponse) Headers() http.Header { return pageLinkHeader(r.next) }
+
+// auditCursor is the position of a record in the oldest-first order
+// records are listed in.
+type auditCursor struct {
+	Seq int64 `json:"seq"`
+}
+
+func (r auditRequest) query() (auditQuery, error) {
+	q := auditQuery{Identity: r.Identity, Method: r.Method, Since: r.Since, Until: r.Until, Limit: r.Limit}
+	if r.PageToken != "" {
+		var c auditCursor
+		if err := decodeCursor(r.PageToken, &c, func() bool { return c.Seq > 0 }); err != nil {
+			return auditQuery{}, err
+		}
+		q.AfterSeq = c.Seq
+	}
+	return q, nil
+}
+
+func makeAdminAuditEndpoint(s *sqlAuditStore) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(auditRequest)
+		q, err := req.query()
+		if err != nil {
+			return nil, err
+		}
+		records, err := s.List(ctx, q)
+		if err != nil {
+			return nil, err
+		}
+		resp := auditResponse{Records: records}
+		if len(records) == req.Limit {
+			resp.NextPageToken = encodeCursor(auditCursor{records[len(records)-1].Seq})
+			resp.next = nextPageURL(ctx, resp.NextPageToken)
+		}
+		return resp, nil
+	}
+}
+
+// decodeAuditRequest reads the identity, method, since, until, limit and
+// page_token query parameters, as decodeHistoryRequest does.
+func decodeAuditRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	h, err := decodeHistoryRequest(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	hr := h.(historyRequest)
+	return auditRequest{
+		Identity:  hr.Identity,
+		Method:    r.URL.Query().Get("method"),
+		Since:     hr.Since,
+		Until:     hr.Until,
+		Limit:     hr.Limit,
+		PageToken: hr.PageToken,
+	}, nil
+}
+
+// auditExportBatch is how many records the export reads at a time.
+const auditExportBatch = 1000
+
+// auditExportHandler serves /admin/audit/export: every record matching
+// the query parameters of /admin/audit, oldest first, as JSON lines with
+// their chain hashes, for archiving and verifying elsewhere. The limit and
+// page_token parameters are ignored.
+func auditExportHandler(s *sqlAuditStore, logger log.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, err := decodeAuditRequest(r.Context(), r)
+		if err != nil {
+			encodeError(r.Context(), err, w)
+			return
+		}
+		ar := req.(auditRequest)
+		ar.Limit, ar.PageToken = auditExportBatch, ""
+		q, _ := ar.query()
+		w.Header().Set("Content-Disposition", `attachment; filename="audit.ndjson"`)
+		enc := json.NewEncoder(w)
+		for first := true; ; first = false {
+			records, err := s.List(r.Context(), q)
+			if first && err != nil {
+				encodeError(r.Context(), err, w)
+				return
+			}
+			if err != nil {
+				// The status has been sent, so the export just ends short.
+				level.Error(logger).Log("msg", "exporting audit records", "err", err)
+				return
+			}
+			if first {
+				w.Header().Set("Content-Type", "application/x-ndjson")
+			}
+			for _, rec := range records {
+				if err := enc.Encode(rec); err != nil {
+					return
+				}
+			}
+			if len(records) < q.Limit {
+				return
+			}
+			q.AfterSeq = records[len(records)-1].Seq
+		}
+	})
+}
@@ -0,0 +1,410 @@
+package stringsvc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	httptransport "github.com/go-kit/kit/transport/http"
+
+	"github.com/mcclayac/gokit/svcerrors"
+)
+
+// AuthService issues and checks the service's own bearer tokens, which
+// machine callers get by presenting client credentials.
+type AuthService interface {
+	// IssueToken returns a token for the client, limited to scopes (all of
+	// the client's when empty), that expires after ttl, or the configured
+	// default when ttl is zero.
+	IssueToken(ctx context.Context, clientID, secret string, scopes []string, ttl time.Duration) (tokenGrant, error)
+	// RevokeToken ends token. Revoking an unknown or expired token isn't
+	// an error.
+	RevokeToken(ctx context.Context, token string) error
+	// Authenticate returns the token, failing with UNAUTHENTICATED if it
+	// is unknown, revoked or expired.
+	Authenticate(ctx context.Context, token string) (issuedToken, error)
+}
+
+// authConfig configures the AuthService.
+type authConfig struct {
+	ClientsFile string   // token issuing is off when empty
+	Keys        bool     // manage API keys at /admin/keys
+	Public      []string // operations callers may make without a token
+	DefaultTTL  time.Duration
+	MaxTTL      time.Duration
+}
+
+// authClient is a machine caller that may be issued tokens. Clients are
+// read from a JSON file holding an array of them. The secret is kept as a
+// bcrypt or argon2id hash, such as one made by the hash endpoint.
+type authClient struct {
+	ID         string   `json:"id"`
+	SecretHash string   `json:"secret_hash"`
+	Scopes     []string `json:"scopes"` // operation names, prefixes of them such as "admin_*", or "*" for all
+	// Tenant is the tenant the client's tokens act for, if any.
+	Tenant string `json:"tenant,omitempty"`
+}
+
+func loadAuthClients(path string) (map[string]authClient, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var list []authClient
+	if err := json.Unmarshal(b, &list); err != nil {
+		return nil, err
+	}
+	clients := make(map[string]authClient, len(list))
+	for _, c := range list {
+		clients[c.ID] = c
+	}
+	return clients, nil
+}
+
+// issuedToken is what is stored about a token. The token itself is only
+// ever seen by the client it was issued to.
+type issuedToken struct {
+	ID      string    `json:"id"` // identifies the token in logs
+	Subject string    `json:"subject"`
+	Tenant  string    `json:"tenant,omitempty"`
+	Scopes  []string  `json:"scopes"`
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"`
+}
+
+// allows reports whether t may call the operation.
+func (t issuedToken) allows(operation string) bool {
+	return hasScope(t.Scopes, operation)
+}
+
+func hasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope || (strings.HasSuffix(s, "*") && strings.HasPrefix(scope, strings.TrimSuffix(s, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// tokenGrant is an issued token as returned to its client.
+type tokenGrant struct {
+	Token   string
+	Expires time.Time
+	Scopes  []string
+}
+
+// tokenPrefix marks the service's tokens, so that leaked ones are easy to
+// find with secret scanners.
+const tokenPrefix = "sst_"
+
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return tokenPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// tokenHash is the key a token is stored under.
+func tokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+var errInvalidToken = svcerrors.New(svcerrors.CodeUnauthenticated, "invalid or expired token")
+
+// authService is a concrete implementation of AuthService. Client secrets
+// are checked with the CryptoService. Clients are those of the clients
+// file and, if keys isn't nil, the active API keys.
+type authService struct {
+	cfg     authConfig
+	clients map[string]authClient
+	keys    keyStore
+	store   tokenStore
+	crypto  CryptoService
+	now     func() time.Time
+	// dummyHash is checked against the secrets of unknown clients, so
+	// that they take as long to refuse as wrong secrets of known ones.
+	dummyHash string
+}
+
+// client returns the client with id.
+func (s authService) client(ctx context.Context, id string) (authClient, bool, error) {
+	if c, ok := s.clients[id]; ok {
+		return c, true, nil
+	}
+	if s.keys == nil {
+		return authClient{}, false, nil
+	}
+	k, err := s.keys.Get(ctx, id)
+	if svcerrors.CodeOf(err) == svcerrors.CodeNotFound {
+		return authClient{}, false, nil
+	}
+	if err != nil {
+		return authClient{}, false, err
+	}
+	return k.client(), !k.revoked(), nil
+}
+
+func (s authService) IssueToken(ctx context.Context, clientID, secret string, scopes []string, ttl time.Duration) (tokenGrant, error) {
+	c, ok, err := s.client(ctx, clientID)
+	if err != nil {
+		return tokenGrant{}, err
+	}
+	if !ok {
+		s.crypto.Verify(ctx, secret, s.dummyHash)
+		return tokenGrant{}, svcerrors.New(svcerrors.CodeUnauthenticated, "invalid client credentials")
+	}
+	if ok, err := s.crypto.Verify(ctx, secret, c.SecretHash); err != nil {
+		return tokenGrant{}, err
+	} else if !ok {
+		return tokenGrant{}, svcerrors.New(svcerrors.CodeUnauthenticated, "invalid client credentials")
+	}
+	if len(scopes) == 0 {
+		scopes = c.Scopes
+	}
+	for _, sc := range scopes {
+		if !hasScope(c.Scopes, sc) {
+			return tokenGrant{}, svcerrors.Errorf(svcerrors.CodePermissionDenied, "client %q may not have scope %q", c.ID, sc)
+		}
+	}
+	if ttl == 0 {
+		ttl = s.cfg.DefaultTTL
+	}
+	if ttl < 0 || ttl > s.cfg.MaxTTL {
+		return tokenGrant{}, svcerrors.Errorf(svcerrors.CodeInvalidArgument, "ttl must be positive and at most %v", s.cfg.MaxTTL)
+	}
+	token, err := newToken()
+	if err != nil {
+		return tokenGrant{}, err
+	}
+	id, err := newShortCode()
+	if err != nil {
+		return tokenGrant{}, err
+	}
+	now := s.now().UTC()
+	t := issuedToken{ID: id, Subject: c.ID, Tenant: c.Tenant, Scopes: scopes, Created: now, Expires: now.Add(ttl)}
+	if err := s.store.Create(ctx, tokenHash(token), t); err != nil {
+		return tokenGrant{}, err
+	}
+	return tokenGrant{token, t.Expires, t.Scopes}, nil
+}
+
+func (s authService) RevokeToken(ctx context.Context, token string) error {
+	return s.store.Revoke(ctx, tokenHash(token))
+}
+
+func (s authService) Authenticate(ctx context.Context, token string) (issuedToken, error) {
+	if !strings.HasPrefix(token, tokenPrefix) {
+		return issuedToken{}, errInvalidToken
+	}
+	t, err := s.store.Lookup(ctx, tokenHash(token), s.now())
+	if err != nil {
+		return issuedToken{}, err
+	}
+	// The tokens of a revoked key end with it.
+	if _, ok := s.clients[t.Subject]; !ok && s.keys != nil {
+		if _, ok, err := s.client(ctx, t.Subject); err != nil {
+			return issuedToken{}, err
+		} else if !ok {
+			return issuedToken{}, errInvalidToken
+		}
+	}
+	return t, nil
+}
+
+type principalKey struct{}
+
+// principal returns the token the request was authenticated with, if any.
+func principal(ctx context.Context) (issuedToken, bool) {
+	t, ok := ctx.Value(principalKey{}).(issuedToken)
+	return t, ok
+}
+
+type authErrorKey struct{}
+
+// authenticatingMiddleware authenticates each request's bearer token, once,
+// for the HTTP middlewares and endpoints after it: a token that
+// authenticates is the request's principal, and the error of one that
+// doesn't is kept for authMiddleware to refuse the call with.
+func authenticatingMiddleware(svc AuthService) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header := r.Header.Get("Authorization")
+			token := strings.TrimPrefix(header, "Bearer ")
+			if token == header {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ctx := r.Context()
+			if t, err := svc.Authenticate(ctx, token); err != nil {
+				ctx = context.WithValue(ctx, authErrorKey{}, err)
+			} else {
+				ctx = context.WithValue(ctx, principalKey{}, t)
+			}
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// authError returns why the request's bearer token failed to
+// authenticate, if it did.
+func authError(ctx context.Context) error {
+	err, _ := ctx.Value(authErrorKey{}).(error)
+	return err
+}
+
+// authMiddleware authenticates calls by their bearer token, rejecting
+// those whose token is invalid or lacks the operation's scope. Calls
+// without a token are rejected too, unless public: then they are let
+// through unidentified. Calls already made for a principal, such as the
+// operations of a job or requests authenticated by
+// authenticatingMiddleware, are checked against its scopes.
+func authMiddleware(svc AuthService, method string, public bool) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			if t, ok := principal(ctx); ok {
+				if !t.allows(method) {
+					return nil, svcerrors.Errorf(svcerrors.CodePermissionDenied, "token lacks the %q scope", method)
+				}
+				return next(ctx, request)
+			}
+			if err := authError(ctx); err != nil {
+				return nil, err
+			}
+			header, _ := ctx.Value(httptransport.ContextKeyRequestAuthorization).(string)
+			token := strings.TrimPrefix(header, "Bearer ")
+			if token == header {
+				if public {
+					return next(ctx, request)
+				}
+				return nil, svcerrors.Errorf(svcerrors.CodeUnauthenticated, "%s needs a bearer token", method)
+			}
+			t, err := svc.Authenticate(ctx, token)
+			if err != nil {
+				return nil, err
+			}
+			if !t.allows(method) {
+				return nil, svcerrors.Errorf(svcerrors.CodePermissionDenied, "token lacks the %q scope", method)
+			}
+			return next(context.WithValue(ctx, principalKey{}, t), request)
+		}
+	}
+}
+
+// requireScope guards handlers that stream rather than go through an
+// endpoint, and so miss authMiddleware, such as the exports: only requests
+// whose bearer token has scope reach next, with the token as their
+// principal.
+func requireScope(svc AuthService, scope string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		token := strings.TrimPrefix(header, "Bearer ")
+		if token == header {
+			encodeError(r.Context(), svcerrors.New(svcerrors.CodeUnauthenticated, "this endpoint needs a bearer token"), w)
+			return
+		}
+		t, err := svc.Authenticate(r.Context(), token)
+		if err != nil {
+			encodeError(r.Context(), err, w)
+			return
+		}
+		if !t.allows(scope) {
+			encodeError(r.Context(), svcerrors.Errorf(svcerrors.CodePermissionDenied, "token lacks the %q scope", scope), w)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, t)))
+	})
+}
+
+// For each method, we define request and response structs. Both requests
+// carry a credential, so they are kept out of audit and event input hashes.
+type tokenRequest struct {
+	ClientID     string   `json:"client_id" xml:"client_id" validate:"required,max=256"`
+	ClientSecret string   `json:"client_secret" xml:"client_secret" validate:"required,max=1024"`
+	Scopes       []string `json:"scopes,omitempty" xml:"scopes>scope,omitempty" validate:"max=64"`
+	TTL          int64    `json:"ttl_seconds" xml:"ttl_seconds" validate:"min=0"`
+}
+
+func (tokenRequest) secret() {}
+
+// tokenResponse follows the OAuth 2.0 token response.
+type tokenResponse struct {
+	AccessToken string   `json:"access_token" xml:"access_token"`
+	TokenType   string   `json:"token_type" xml:"token_type"`
+	ExpiresIn   int64    `json:"expires_in" xml:"expires_in"` // seconds
+	Scopes      []string `json:"scopes" xml:"scopes>scope"`
+	Err         error    `json:"-" xml:"-"`
+}
+
+// Failed implements endpoint.Failer.
+func (r tokenResponse) Failed() error { return r.Err }
+
+type revokeRequest struct {
+	Token string `json:"token" xml:"token" validate:"required,max=256"`
+}
+
+func (revokeRequest) secret() {}
+
+type revokeResponse struct {
+	Err error `json:"-" xml:"-"`
+}
+
+// Failed implements endpoint.Failer.
+func (r revokeResponse) Failed() error { return r.Err }
+
+// makeTokenEndpoint returns the token endpoint, which reports how long
+// tokens last by the clock now, the one the AuthService expires them by.
+func makeTokenEndpoint(svc AuthService, now func() time.Time) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(tokenRequest)
+		// TTLs too long for a Duration are clamped to the longest, which
+		// IssueToken refuses as over the maximum.
+		ttl := time.Duration(math.MaxInt64)
+		if req.TTL < int64(ttl/time.Second) {
+			ttl = time.Duration(req.TTL) * time.Second
+		}
+		g, err := svc.IssueToken(ctx, req.ClientID, req.ClientSecret, req.Scopes, ttl)
+		if err != nil {
+			return tokenResponse{Err: err}, nil
+		}
+		return tokenResponse{
+			AccessToken: g.Token,
+			TokenType:   "Bearer",
+			ExpiresIn:   int64(g.Expires.Sub(now()).Round(time.Second) / time.Second),
+			Scopes:      g.Scopes,
+		}, nil
+	}
+}
+
+func makeRevokeEndpoint(svc AuthService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(revokeRequest)
+		return revokeResponse{svc.RevokeToken(ctx, req.Token)}, nil
+	}
+}
+
+func decodeTokenRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request tokenRequest
+	if err := decodeBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+func decodeRevokeRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request revokeRequest
+	if err := decodeBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
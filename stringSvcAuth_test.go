@@ -0,0 +1,67 @@
+package stringsvc
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/mcclayac/gokit/svcerrors"
+)
+
+// fixedGrantAuth grants tokens that expire an hour after a fixed time.
+type fixedGrantAuth struct {
+	AuthService
+	at time.Time
+}
+
+func (a fixedGrantAuth) IssueToken(context.Context, string, string, []string, time.Duration) (tokenGrant, error) {
+	return tokenGrant{Token: "sst_test", Expires: a.at.Add(time.Hour)}, nil
+}
+
+func TestTokenEndpointExpiresIn(t *testing.T) {
+	at := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+	e := makeTokenEndpoint(fixedGrantAuth{at: at}, func() time.Time { return at })
+	resp, err := e(context.Background(), tokenRequest{ClientID: "c", ClientSecret: "s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := resp.(tokenResponse).ExpiresIn; got != 3600 {
+		t.Errorf("got expires_in %d, want 3600 by the injected clock", got)
+	}
+}
+
+// countingCrypto accepts the secret "right" and counts verifications.
+type countingCrypto struct {
+	CryptoService
+	verified *int
+}
+
+func (c countingCrypto) Verify(_ context.Context, password, _ string) (bool, error) {
+	*c.verified++
+	return password == "right", nil
+}
+
+func TestIssueTokenUnknownClient(t *testing.T) {
+	verified := 0
+	s := authService{
+		cfg:     authConfig{DefaultTTL: time.Hour, MaxTTL: time.Hour},
+		clients: map[string]authClient{"billing": {ID: "billing", Scopes: []string{"*"}}},
+		store:   newMemoryTokenStore(),
+		crypto:  countingCrypto{verified: &verified},
+		now:     time.Now,
+	}
+	_, err := s.IssueToken(context.Background(), "nobody", "right", nil, 0)
+	if svcerrors.CodeOf(err) != svcerrors.CodeUnauthenticated || verified != 1 {
+		t.Errorf("unknown client: got %v after %d verifications, want UNAUTHENTICATED after one", err, verified)
+	}
+
+	e := makeTokenEndpoint(s, time.Now)
+	resp, err := e(context.Background(), tokenRequest{ClientID: "billing", ClientSecret: "right", TTL: math.MaxInt64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := svcerrors.CodeOf(resp.(tokenResponse).Err); code != svcerrors.CodeInvalidArgument {
+		t.Errorf("overflowing TTL: got %v, want INVALID_ARGUMENT", resp.(tokenResponse).Err)
+	}
+}
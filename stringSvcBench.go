@@ -0,0 +1,494 @@
+package stringsvc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mcclayac/gokit/client"
+	"github.com/mcclayac/gokit/codec"
+	"github.com/mcclayac/gokit/svcerrors"
+)
+
+// The bench subcommand generates traffic for performance tests, shaped by
+// a workload profile: the mix of operations, the distribution of payload
+// sizes for each, and bursts. It runs closed loop, where -concurrency
+// callers each make a call as soon as their last one returns, or open
+// loop, where calls arrive at -rate a second whether or not earlier ones
+// have returned, as production traffic does. Open-loop latencies are
+// measured from when a call was due rather than when it was sent, so a
+// slow service isn't flattered by the calls it delayed.
+//
+//	stringsvc bench -profile prod.json -mode open -rate 500 -duration 5m
+//
+// A profile is a JSON object:
+//
+//	{
+//	  "ops": [
+//	    {"op": "uppercase", "weight": 70, "size": {"dist": "lognormal", "median": 120, "sigma": 1.1, "max": 65536}},
+//	    {"op": "count", "weight": 25, "size": {"dist": "buckets", "buckets": [{"bytes": 16, "weight": 9}, {"bytes": 4096, "weight": 1}]}},
+//	    {"op": "hash", "weight": 5, "size": {"dist": "uniform", "min": 12, "max": 64}}
+//	  ],
+//	  "text": "unicode",
+//	  "burst": {"period_ms": 60000, "duration_ms": 5000, "factor": 4},
+//	  "think_ms": 0
+//	}
+//
+// The operations are uppercase, count, detect, hash, verify, shorten and
+// resolve; weights are relative. Sizes are in bytes, drawn from a fixed,
+// uniform, lognormal or bucketed distribution. Text is ASCII words, or
+// words in several scripts with "unicode". During a burst, open-loop calls
+// arrive factor times as fast and closed-loop callers think factor times
+// less between calls.
+
+type benchProfile struct {
+	Ops     []benchOp  `json:"ops"`
+	Text    string     `json:"text"`
+	Burst   benchBurst `json:"burst"`
+	ThinkMS int64      `json:"think_ms"`
+}
+
+type benchOp struct {
+	Op     string   `json:"op"`
+	Weight float64  `json:"weight"`
+	Size   sizeDist `json:"size"`
+}
+
+type benchBurst struct {
+	PeriodMS   int64   `json:"period_ms"` // bursts are off when zero
+	DurationMS int64   `json:"duration_ms"`
+	Factor     float64 `json:"factor"`
+}
+
+// sizeDist is a distribution of payload sizes.
+type sizeDist struct {
+	Dist    string       `json:"dist"` // fixed, uniform, lognormal or buckets
+	Bytes   int          `json:"bytes"`
+	Min     int          `json:"min"`
+	Max     int          `json:"max"` // also caps lognormal sizes
+	Median  float64      `json:"median"`
+	Sigma   float64      `json:"sigma"`
+	Buckets []sizeBucket `json:"buckets"`
+}
+
+type sizeBucket struct {
+	Bytes  int     `json:"bytes"`
+	Weight float64 `json:"weight"`
+}
+
+// defaultBenchProfile is used without -profile: mostly small strings with
+// a long tail.
+var defaultBenchProfile = benchProfile{
+	Ops: []benchOp{
+		{Op: "uppercase", Weight: 60, Size: sizeDist{Dist: "lognormal", Median: 64, Sigma: 1, Max: 65536}},
+		{Op: "count", Weight: 30, Size: sizeDist{Dist: "lognormal", Median: 64, Sigma: 1, Max: 65536}},
+		{Op: "detect", Weight: 10, Size: sizeDist{Dist: "lognormal", Median: 256, Sigma: 0.8, Max: 65536}},
+	},
+	Text: "ascii",
+}
+
+var benchOps = map[string]bool{
+	"uppercase": true, "count": true, "detect": true, "hash": true,
+	"verify": true, "shorten": true, "resolve": true,
+}
+
+func loadBenchProfile(path string) (benchProfile, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return benchProfile{}, err
+	}
+	var p benchProfile
+	if err := json.Unmarshal(b, &p); err != nil {
+		return benchProfile{}, err
+	}
+	return p, p.check()
+}
+
+func (p benchProfile) check() error {
+	if len(p.Ops) == 0 {
+		return errors.New("the profile has no ops")
+	}
+	for _, op := range p.Ops {
+		if !benchOps[op.Op] {
+			return fmt.Errorf("unknown op %q", op.Op)
+		}
+		if op.Weight <= 0 {
+			return fmt.Errorf("%s: weight must be positive", op.Op)
+		}
+		switch op.Size.Dist {
+		case "", "fixed", "uniform", "lognormal", "buckets":
+		default:
+			return fmt.Errorf("%s: unknown size distribution %q", op.Op, op.Size.Dist)
+		}
+	}
+	switch p.Text {
+	case "", "ascii", "unicode":
+	default:
+		return fmt.Errorf("text must be ascii or unicode, not %q", p.Text)
+	}
+	if p.Burst.PeriodMS > 0 && (p.Burst.Factor <= 0 || p.Burst.DurationMS > p.Burst.PeriodMS) {
+		return errors.New("a burst needs a positive factor and to be shorter than its period")
+	}
+	return nil
+}
+
+// pick returns an op drawn by weight.
+func (p benchProfile) pick(rng *rand.Rand) benchOp {
+	var total float64
+	for _, op := range p.Ops {
+		total += op.Weight
+	}
+	x := rng.Float64() * total
+	for _, op := range p.Ops {
+		if x -= op.Weight; x < 0 {
+			return op
+		}
+	}
+	return p.Ops[len(p.Ops)-1]
+}
+
+// factor returns how much faster traffic comes at elapsed into the run.
+func (b benchBurst) factor(elapsed time.Duration) float64 {
+	if b.PeriodMS <= 0 {
+		return 1
+	}
+	period := time.Duration(b.PeriodMS) * time.Millisecond
+	if elapsed%period < time.Duration(b.DurationMS)*time.Millisecond {
+		return b.Factor
+	}
+	return 1
+}
+
+// sample returns a size in bytes, at least 1.
+func (d sizeDist) sample(rng *rand.Rand) int {
+	var n int
+	switch d.Dist {
+	case "", "fixed":
+		n = d.Bytes
+	case "uniform":
+		n = d.Min
+		if d.Max > d.Min {
+			n += rng.Intn(d.Max - d.Min + 1)
+		}
+	case "lognormal":
+		n = int(d.Median * math.Exp(d.Sigma*rng.NormFloat64()))
+		if d.Max > 0 && n > d.Max {
+			n = d.Max
+		}
+	case "buckets":
+		var total float64
+		for _, b := range d.Buckets {
+			total += b.Weight
+		}
+		x := rng.Float64() * total
+		for _, b := range d.Buckets {
+			if n = b.Bytes; x < b.Weight {
+				break
+			}
+			x -= b.Weight
+		}
+	}
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+var benchWords = map[string][]string{
+	"ascii":   {"the", "quick", "brown", "fox", "jumps", "over", "lazy", "dog", "string", "service", "hello", "world"},
+	"unicode": {"the", "quick", "straße", "café", "привет", "мир", "こんにちは", "世界", "مرحبا", "γειά", "İstanbul", "👋"},
+}
+
+// benchText returns words of the profile's text, cut to n bytes.
+func benchText(rng *rand.Rand, text string, n int) string {
+	words := benchWords[text]
+	if words == nil {
+		words = benchWords["ascii"]
+	}
+	var b strings.Builder
+	for b.Len() < n {
+		if b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(words[rng.Intn(len(words))])
+	}
+	s := b.String()[:n]
+	for !utf8.ValidString(s) {
+		s = s[:len(s)-1]
+	}
+	return s
+}
+
+// benchRun holds what a run needs across calls: the links to resolve and
+// the hash to verify.
+type benchRun struct {
+	c       *client.Client
+	profile benchProfile
+	hash    string
+
+	mtx   sync.Mutex
+	codes []string
+	stats map[string]*benchStats
+}
+
+type benchStats struct {
+	latencies []time.Duration
+	errors    map[svcerrors.Code]int
+}
+
+const benchPassword = "correct horse battery staple"
+
+// call makes a call of op, recording the time it took from due.
+func (r *benchRun) call(ctx context.Context, rng *rand.Rand, op benchOp, due time.Time) {
+	size := op.Size.sample(rng)
+	var err error
+	switch op.Op {
+	case "uppercase":
+		_, err = r.c.Uppercase(ctx, benchText(rng, r.profile.Text, size))
+	case "count":
+		_, err = r.c.Count(ctx, benchText(rng, r.profile.Text, size))
+	case "detect":
+		_, err = r.c.Detect(ctx, benchText(rng, r.profile.Text, size), 3)
+	case "hash":
+		if size > 1024 {
+			size = 1024
+		}
+		_, err = r.c.Hash(ctx, benchText(rng, r.profile.Text, size))
+	case "verify":
+		_, err = r.c.Verify(ctx, benchPassword, r.hash)
+	case "shorten":
+		var l client.Link
+		l, err = r.c.Shorten(ctx, "https://example.com/"+strings.Repeat("p", size), time.Hour)
+		if err == nil {
+			r.mtx.Lock()
+			if len(r.codes) < 10000 {
+				r.codes = append(r.codes, l.Code)
+			} else {
+				r.codes[rng.Intn(len(r.codes))] = l.Code
+			}
+			r.mtx.Unlock()
+		}
+	case "resolve":
+		r.mtx.Lock()
+		code := r.codes[rng.Intn(len(r.codes))]
+		r.mtx.Unlock()
+		_, err = r.c.Resolve(ctx, code)
+	}
+	took := time.Since(due)
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	s := r.stats[op.Op]
+	if s == nil {
+		s = &benchStats{errors: map[svcerrors.Code]int{}}
+		r.stats[op.Op] = s
+	}
+	s.latencies = append(s.latencies, took)
+	if err != nil {
+		s.errors[svcerrors.CodeOf(err)]++
+	}
+}
+
+// prepare makes what verify and resolve calls need.
+func (r *benchRun) prepare(ctx context.Context) error {
+	for _, op := range r.profile.Ops {
+		switch op.Op {
+		case "verify":
+			h, err := r.c.Hash(ctx, benchPassword)
+			if err != nil {
+				return fmt.Errorf("hashing the password to verify: %v", err)
+			}
+			r.hash = h
+		case "resolve":
+			for i := 0; i < 100; i++ {
+				l, err := r.c.Shorten(ctx, fmt.Sprintf("https://example.com/bench/%d", i), time.Hour)
+				if err != nil {
+					return fmt.Errorf("shortening links to resolve: %v", err)
+				}
+				r.codes = append(r.codes, l.Code)
+			}
+		}
+	}
+	return nil
+}
+
+// closedLoop runs concurrency callers until ctx is done. Calls under way
+// then are let finish, so they aren't counted as canceled.
+func (r *benchRun) closedLoop(ctx context.Context, concurrency int, seed int64) {
+	begin := time.Now()
+	think := time.Duration(r.profile.ThinkMS) * time.Millisecond
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func(rng *rand.Rand) {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				r.call(context.Background(), rng, r.profile.pick(rng), time.Now())
+				if think > 0 {
+					d := time.Duration(float64(think) / r.profile.Burst.factor(time.Since(begin)))
+					select {
+					case <-ctx.Done():
+					case <-time.After(d):
+					}
+				}
+			}
+		}(rand.New(rand.NewSource(seed + int64(i))))
+	}
+	wg.Wait()
+}
+
+// openLoop starts calls at rate a second, as a Poisson process, until ctx
+// is done. Calls due while maxInFlight are in flight are dropped and
+// counted.
+func (r *benchRun) openLoop(ctx context.Context, rate float64, maxInFlight int, seed int64) (dropped int) {
+	rng := rand.New(rand.NewSource(seed))
+	sem := make(chan struct{}, maxInFlight)
+	var wg sync.WaitGroup
+	begin := time.Now()
+	due := begin
+	for {
+		due = due.Add(time.Duration(rng.ExpFloat64() / (rate * r.profile.Burst.factor(due.Sub(begin))) * float64(time.Second)))
+		if d := time.Until(due); d > 0 {
+			select {
+			case <-ctx.Done():
+				wg.Wait()
+				return dropped
+			case <-time.After(d):
+			}
+		}
+		if ctx.Err() != nil {
+			wg.Wait()
+			return dropped
+		}
+		select {
+		case sem <- struct{}{}:
+		default:
+			dropped++
+			continue
+		}
+		wg.Add(1)
+		go func(rng *rand.Rand, due time.Time) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			r.call(context.Background(), rng, r.profile.pick(rng), due)
+		}(rand.New(rand.NewSource(rng.Int63())), due)
+	}
+}
+
+// report prints each op's calls, errors and latency percentiles.
+func (r *benchRun) report(took time.Duration) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	ops := make([]string, 0, len(r.stats))
+	for op := range r.stats {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+	fmt.Printf("%-10s %8s %8s %9s %9s %9s %9s %9s\n", "op", "calls", "errors", "calls/s", "p50", "p90", "p99", "max")
+	for _, op := range ops {
+		s := r.stats[op]
+		sort.Slice(s.latencies, func(i, j int) bool { return s.latencies[i] < s.latencies[j] })
+		q := func(p float64) time.Duration {
+			return s.latencies[int(p*float64(len(s.latencies)-1))].Round(time.Microsecond)
+		}
+		var errs int
+		for _, n := range s.errors {
+			errs += n
+		}
+		fmt.Printf("%-10s %8d %8d %9.1f %9v %9v %9v %9v\n", op, len(s.latencies), errs,
+			float64(len(s.latencies))/took.Seconds(), q(0.5), q(0.9), q(0.99), q(1))
+		for code, n := range s.errors {
+			fmt.Printf("%-10s %8s %8d %s\n", "", "", n, code)
+		}
+	}
+}
+
+// codecByName returns the codec a client uses for -codec.
+func codecByName(name string) (codec.Codec, error) {
+	switch strings.ToLower(name) {
+	case "json":
+		return codec.JSON, nil
+	case "msgpack":
+		return codec.MsgPack, nil
+	case "cbor":
+		return codec.CBOR, nil
+	case "protobuf":
+		return codec.Protobuf, nil
+	}
+	return nil, fmt.Errorf("-codec must be json, msgpack, cbor or protobuf, not %q", name)
+}
+
+// runBench generates traffic against a running service.
+func runBench(args []string) int {
+	fs := flag.NewFlagSet("stringsvc bench", flag.ContinueOnError)
+	target := fs.String("target", "http://localhost:9090", "comma-separated base URLs of the instances to call")
+	profilePath := fs.String("profile", "", "JSON workload profile (a mix of small uppercase, count and detect calls when empty)")
+	mode := fs.String("mode", "closed", "closed: -concurrency callers each call again once their last call returns; open: calls arrive at -rate a second regardless")
+	concurrency := fs.Int("concurrency", 16, "callers in closed-loop mode")
+	rate := fs.Float64("rate", 100, "calls a second in open-loop mode, outside bursts")
+	maxInFlight := fs.Int("max-in-flight", 1000, "calls in flight in open-loop mode past which due calls are dropped")
+	duration := fs.Duration("duration", 30*time.Second, "how long to generate traffic")
+	seed := fs.Int64("seed", 0, "random seed, to repeat a run's sequence of calls (the time when zero)")
+	codecName := fs.String("codec", "json", "wire format of the calls: json, msgpack, cbor or protobuf")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	wire, err := codecByName(*codecName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	profile := defaultBenchProfile
+	if *profilePath != "" {
+		if profile, err = loadBenchProfile(*profilePath); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", *profilePath, err)
+			return 2
+		}
+	}
+	if *mode != "closed" && *mode != "open" {
+		fmt.Fprintf(os.Stderr, "-mode must be closed or open, not %q\n", *mode)
+		return 2
+	}
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+	// Failures are counted rather than retried, so they show.
+	targets := strings.Split(*target, ",")
+	c, err := client.New(targets[0],
+		client.WithInstances(targets[1:]...),
+		client.WithRetry(client.RetryPolicy{}),
+		client.WithoutBreaker(),
+		client.WithCodec(wire),
+	)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	run := &benchRun{c: c, profile: profile, stats: map[string]*benchStats{}}
+	if err := run.prepare(context.Background()); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *duration)
+	defer cancel()
+	begin := time.Now()
+	if *mode == "closed" {
+		run.closedLoop(ctx, *concurrency, *seed)
+	} else if dropped := run.openLoop(ctx, *rate, *maxInFlight, *seed); dropped > 0 {
+		fmt.Printf("%d calls dropped with -max-in-flight calls in flight\n", dropped)
+	}
+	run.report(time.Since(begin))
+	fmt.Printf("(-seed %d)\n", *seed)
+	return 0
+}
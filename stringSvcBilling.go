@@ -0,0 +1,287 @@
+package stringsvc
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	gcs "cloud.google.com/go/storage"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+
+	"github.com/mcclayac/gokit/svcerrors"
+)
+
+// Billing reports are the month's usage per tenant and key, for the
+// chargeback process: each line is one caller of one tenant, with its
+// calls, bytes and compute time summed over the operations it called.
+// GET /admin/usage/export?month=2026-09&format=csv returns one, as CSV or
+// JSON (format defaults to csv if the Accept header asks for text/csv,
+// and to json otherwise).
+//
+// With -billing-push-url, set to s3://bucket/prefix or gs://bucket/prefix,
+// the service also pushes the reports there every -billing-push-interval,
+// as usage-2026-09.csv: the month just ended, so that usage flushed late
+// is counted, and the month so far. Replicas all push the same reports,
+// each overwriting the last, so the objects are always whole.
+
+// billingLine is one caller's usage over a month.
+type billingLine struct {
+	Month         string `json:"month"`
+	Tenant        string `json:"tenant"`
+	Key           string `json:"key"` // the caller's identity
+	Requests      int64  `json:"requests"`
+	BytesIn       int64  `json:"bytes_in"`
+	BytesOut      int64  `json:"bytes_out"`
+	ComputeMillis int64  `json:"compute_ms"`
+}
+
+// billingReport is a month's billing lines.
+type billingReport struct {
+	Month     string        `json:"month"`
+	Generated time.Time     `json:"generated"`
+	Lines     []billingLine `json:"lines"`
+}
+
+// newBillingReport sums the rollups of month by tenant and identity.
+func newBillingReport(month string, rollups []usageRollup, now time.Time) billingReport {
+	type caller struct{ tenant, identity string }
+	totals := map[caller]usageTotals{}
+	for _, r := range rollups {
+		c := caller{r.Tenant, r.Identity}
+		t := totals[c]
+		t.add(r.usageTotals)
+		totals[c] = t
+	}
+	report := billingReport{Month: month, Generated: now.UTC(), Lines: make([]billingLine, 0, len(totals))}
+	for c, t := range totals {
+		report.Lines = append(report.Lines, billingLine{month, c.tenant, c.identity, t.Calls, t.BytesIn, t.BytesOut, t.ComputeMicros / 1000})
+	}
+	sort.Slice(report.Lines, func(i, j int) bool {
+		a, b := report.Lines[i], report.Lines[j]
+		if a.Tenant != b.Tenant {
+			return a.Tenant < b.Tenant
+		}
+		return a.Key < b.Key
+	})
+	return report
+}
+
+// billingFormats are the formats reports are written in, by name.
+var billingFormats = map[string]struct {
+	contentType string
+	write       func(io.Writer, billingReport) error
+}{
+	"csv":  {"text/csv; charset=utf-8", writeBillingCSV},
+	"json": {"application/json", writeBillingJSON},
+}
+
+// writeBillingCSV writes a report as CSV with a header row.
+func writeBillingCSV(w io.Writer, report billingReport) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"month", "tenant", "key", "requests", "bytes_in", "bytes_out", "compute_ms"})
+	for _, l := range report.Lines {
+		cw.Write([]string{
+			l.Month, l.Tenant, l.Key,
+			strconv.FormatInt(l.Requests, 10),
+			strconv.FormatInt(l.BytesIn, 10),
+			strconv.FormatInt(l.BytesOut, 10),
+			strconv.FormatInt(l.ComputeMillis, 10),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeBillingJSON(w io.Writer, report billingReport) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
+
+// buildBillingReport reads the usage of month from m and encodes its
+// report in format.
+func buildBillingReport(ctx context.Context, m *usageMeter, month, format string, now time.Time) ([]byte, error) {
+	rollups, err := m.List(ctx, month, "")
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := billingFormats[format].write(&buf, newBillingReport(month, rollups, now)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// billingExportHandler serves /admin/usage/export.
+func billingExportHandler(m *usageMeter, now func() time.Time) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			encodeError(r.Context(), svcerrors.Errorf(svcerrors.CodeInvalidArgument, "%s /admin/usage/export: want GET", r.Method), w)
+			return
+		}
+		q := r.URL.Query()
+		month := q.Get("month")
+		if month == "" {
+			month = now().UTC().Format(usageMonth)
+		}
+		if _, err := time.Parse(usageMonth, month); err != nil {
+			encodeError(r.Context(), svcerrors.Errorf(svcerrors.CodeInvalidArgument, "malformed month %q: want YYYY-MM", month), w)
+			return
+		}
+		format := q.Get("format")
+		if format == "" {
+			format = "json"
+			if strings.Contains(r.Header.Get("Accept"), "text/csv") {
+				format = "csv"
+			}
+		}
+		f, ok := billingFormats[format]
+		if !ok {
+			encodeError(r.Context(), svcerrors.Errorf(svcerrors.CodeInvalidArgument, "unknown format %q: want csv or json", format), w)
+			return
+		}
+		b, err := buildBillingReport(r.Context(), m, month, format, now())
+		if err != nil {
+			encodeError(r.Context(), err, w)
+			return
+		}
+		w.Header().Set("Content-Type", f.contentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, billingObjectName(month, format)))
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write(b)
+	})
+}
+
+// billingObjectName names the report of month in format.
+func billingObjectName(month, format string) string {
+	return "usage-" + month + "." + format
+}
+
+// billingConfig configures the scheduled push of billing reports.
+type billingConfig struct {
+	PushURL      string // s3://bucket/prefix or gs://bucket/prefix; reports aren't pushed when empty
+	PushInterval time.Duration
+	Format       string // csv or json
+}
+
+// billingSink stores pushed reports.
+type billingSink interface {
+	Put(ctx context.Context, name, contentType string, body []byte) error
+}
+
+// newBillingSink returns the sink of an s3:// or gs:// URL.
+func newBillingSink(ctx context.Context, rawURL string) (billingSink, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	prefix := strings.Trim(u.Path, "/")
+	switch u.Scheme {
+	case "s3":
+		awsCfg, err := awsconfig.LoadDefaultConfig(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return s3Sink{s3.NewFromConfig(awsCfg), u.Host, prefix}, nil
+	case "gs":
+		client, err := gcs.NewClient(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return gcsSink{client, u.Host, prefix}, nil
+	}
+	return nil, fmt.Errorf("%q: want an s3:// or gs:// URL", rawURL)
+}
+
+// s3Sink puts reports in an S3 bucket under a prefix.
+type s3Sink struct {
+	client *s3.Client
+	bucket string
+	prefix string
+}
+
+func (s s3Sink) Put(ctx context.Context, name, contentType string, body []byte) error {
+	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(path.Join(s.prefix, name)),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String(contentType),
+	})
+	return err
+}
+
+// gcsSink puts reports in a Cloud Storage bucket under a prefix.
+type gcsSink struct {
+	client *gcs.Client
+	bucket string
+	prefix string
+}
+
+func (s gcsSink) Put(ctx context.Context, name, contentType string, body []byte) error {
+	w := s.client.Bucket(s.bucket).Object(path.Join(s.prefix, name)).NewWriter(ctx)
+	w.ContentType = contentType
+	if _, err := w.Write(body); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// billingPusher pushes the reports of the last month and this one to a
+// sink.
+type billingPusher struct {
+	meter  *usageMeter
+	sink   billingSink
+	format string
+	now    func() time.Time
+	logger log.Logger
+}
+
+// push pushes the reports once.
+func (p billingPusher) push(ctx context.Context) error {
+	now := p.now().UTC()
+	this := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	for _, month := range []time.Time{this.AddDate(0, -1, 0), this} {
+		m := month.Format(usageMonth)
+		b, err := buildBillingReport(ctx, p.meter, m, p.format, now)
+		if err != nil {
+			return fmt.Errorf("%s: %v", m, err)
+		}
+		if err := p.sink.Put(ctx, billingObjectName(m, p.format), billingFormats[p.format].contentType, b); err != nil {
+			return fmt.Errorf("%s: %v", m, err)
+		}
+	}
+	return nil
+}
+
+// Run pushes the reports now and every interval until ctx is done.
+func (p billingPusher) Run(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		pushCtx, cancel := context.WithTimeout(ctx, time.Minute)
+		if err := p.push(pushCtx); err != nil {
+			level.Error(p.logger).Log("msg", "pushing billing reports", "err", err)
+		}
+		cancel()
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
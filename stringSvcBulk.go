@@ -0,0 +1,227 @@
+package stringsvc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/go-kit/kit/endpoint"
+	httptransport "github.com/go-kit/kit/transport/http"
+
+	"github.com/mcclayac/gokit/svcerrors"
+	"github.com/mcclayac/gokit/tracing"
+)
+
+// POST /bulk runs a batch of calls and streams their results back, so
+// that batch callers get each result as soon as it is ready rather than
+// waiting for the whole batch. The body is newline-delimited JSON, one
+// operation per line, as in a job:
+//
+//	{"id": "a", "method": "uppercase", "request": {"s": "hello"}}
+//	{"id": "b", "method": "count", "request": {"s": "hello"}}
+//
+// and the response is newline-delimited JSON too, one result per
+// operation, with its index in the body (from 0), its id if it had one,
+// and either its result or its error:
+//
+//	{"index": 1, "id": "b", "method": "count", "result": {"v": 5}}
+//	{"index": 0, "id": "a", "method": "uppercase", "result": {"v": "HELLO"}}
+//
+// Up to -bulk-concurrency operations run at once, and results are written
+// as they complete; with ?ordered=true they are written in the order of
+// the body instead. Each operation goes through its method's endpoint, so
+// it is authorized, limited and metered as a call of its own. Over
+// HTTP/2, operations start while the body is still arriving; over HTTP/1.x,
+// where a handler can't read the body once it has begun the response,
+// the body is read first, up to -bulk-max-bytes. A line longer than
+// -bulk-max-line-bytes ends the batch with an error result.
+
+// bulkConfig configures POST /bulk.
+type bulkConfig struct {
+	Concurrency  int
+	MaxLineBytes int
+	MaxBytes     int64 // of HTTP/1.x bodies, which are read before the results are written
+}
+
+// bulkOperation is one line of a bulk body.
+type bulkOperation struct {
+	ID      string          `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Request json.RawMessage `json:"request"`
+}
+
+// bulkResult is one line of a bulk response.
+type bulkResult struct {
+	Index  int              `json:"index"`
+	ID     string           `json:"id,omitempty"`
+	Method string           `json:"method,omitempty"`
+	Result interface{}      `json:"result,omitempty"`
+	Err    *svcerrors.Error `json:"err,omitempty"`
+}
+
+// runBulkOperation decodes and calls the operation on line index.
+func runBulkOperation(ctx context.Context, endpoints map[string]messageEndpoint, index int, line []byte) bulkResult {
+	var op bulkOperation
+	if err := json.Unmarshal(line, &op); err != nil {
+		return bulkResult{Index: index, Err: svcerrors.Errorf(svcerrors.CodeInvalidArgument, "malformed operation: %v", err)}
+	}
+	res := bulkResult{Index: index, ID: op.ID, Method: op.Method}
+	me, ok := endpoints[op.Method]
+	if !ok {
+		res.Err = svcerrors.Errorf(svcerrors.CodeInvalidArgument, "unknown method %q", op.Method)
+		return res
+	}
+	request, err := me.decodeJSON(ctx, op.Request)
+	if err != nil {
+		res.Err = svcerrors.From(err)
+		return res
+	}
+	response, err := me.e(ctx, request)
+	if f, ok := response.(endpoint.Failer); ok && err == nil {
+		err = f.Failed()
+	}
+	if err != nil {
+		reportUnexpected(ctx, err)
+		res.Err = svcerrors.From(err)
+		return res
+	}
+	res.Result = response
+	return res
+}
+
+// bulkHandler serves POST /bulk with the message endpoints.
+func bulkHandler(endpoints map[string]messageEndpoint, cfg bulkConfig) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := tracing.HTTPToContext(httptransport.PopulateRequestContext(r.Context(), r), r)
+		if r.Method != http.MethodPost {
+			encodeError(ctx, svcerrors.Errorf(svcerrors.CodeInvalidArgument, "%s /bulk: want POST", r.Method), w)
+			return
+		}
+		if ct := r.Header.Get("Content-Type"); ct != "" {
+			if mt, _, _ := mime.ParseMediaType(ct); mt != "application/x-ndjson" && mt != "application/jsonl" {
+				encodeError(ctx, svcerrors.Errorf(svcerrors.CodeUnsupportedMediaType, "expected an application/x-ndjson body, not %q", ct), w)
+				return
+			}
+		}
+		ordered := false
+		if v := r.URL.Query().Get("ordered"); v != "" {
+			var err error
+			if ordered, err = strconv.ParseBool(v); err != nil {
+				encodeError(ctx, svcerrors.Errorf(svcerrors.CodeInvalidArgument, "malformed ordered %q: want true or false", v), w)
+				return
+			}
+		}
+		var body io.Reader = r.Body
+		if r.ProtoMajor < 2 {
+			b, err := io.ReadAll(http.MaxBytesReader(w, r.Body, cfg.MaxBytes))
+			if err != nil {
+				encodeError(ctx, svcerrors.Errorf(svcerrors.CodePayloadTooLarge, "reading the bulk body: %v", err), w)
+				return
+			}
+			body = bytes.NewReader(b)
+		}
+
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		// A slot is taken for each operation before it starts and given
+		// back once its result is written, so at most Concurrency results
+		// are ever running or waiting to be written.
+		slots := make(chan struct{}, cfg.Concurrency)
+		results := make(chan bulkResult, cfg.Concurrency)
+		total := make(chan int, 1) // the number of results, once the body is read
+		go func() {
+			sc := bufio.NewScanner(body)
+			size := 64 * 1024
+			if cfg.MaxLineBytes < size {
+				size = cfg.MaxLineBytes
+			}
+			sc.Buffer(make([]byte, 0, size), cfg.MaxLineBytes)
+			n := 0
+			for sc.Scan() {
+				line := bytes.TrimSpace(sc.Bytes())
+				if len(line) == 0 {
+					continue
+				}
+				select {
+				case slots <- struct{}{}:
+				case <-ctx.Done():
+					total <- n
+					return
+				}
+				line = append([]byte(nil), line...)
+				go func(index int) {
+					results <- runBulkOperation(ctx, endpoints, index, line)
+				}(n)
+				n++
+			}
+			if err := sc.Err(); err != nil {
+				if err == bufio.ErrTooLong {
+					err = svcerrors.Errorf(svcerrors.CodePayloadTooLarge, "operation %d is longer than %d bytes", n, cfg.MaxLineBytes)
+				}
+				select {
+				case slots <- struct{}{}:
+					results <- bulkResult{Index: n, Err: svcerrors.From(err)}
+					n++
+				case <-ctx.Done():
+				}
+			}
+			total <- n
+		}()
+
+		h := w.Header()
+		h.Set("Content-Type", "application/x-ndjson")
+		h.Set("Cache-Control", "no-cache")
+		h.Set("X-Accel-Buffering", "no") // keep proxies such as nginx from buffering the stream
+		w.WriteHeader(http.StatusOK)
+		enc := json.NewEncoder(w)
+		flusher, _ := w.(http.Flusher)
+		write := func(res bulkResult) bool {
+			<-slots
+			if err := enc.Encode(res); err != nil {
+				return false
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+			return true
+		}
+
+		pending := map[int]bulkResult{} // results waiting for their turn, when ordered
+		next, written, expected := 0, 0, -1
+		for expected < 0 || written < expected {
+			select {
+			case res := <-results:
+				if !ordered {
+					if !write(res) {
+						return
+					}
+					written++
+					continue
+				}
+				pending[res.Index] = res
+				for {
+					res, ok := pending[next]
+					if !ok {
+						break
+					}
+					delete(pending, next)
+					if !write(res) {
+						return
+					}
+					next++
+					written++
+				}
+			case expected = <-total:
+				total = nil
+			case <-ctx.Done():
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,34 @@
+package stringsvc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mcclayac/gokit/svcerrors"
+)
+
+func TestRunBulkOperation(t *testing.T) {
+	endpoints := map[string]messageEndpoint{
+		"uppercase": {makeUppercaseEndpoint(stringService{}), decodeUppercaseMessage},
+	}
+	ctx := context.Background()
+
+	res := runBulkOperation(ctx, endpoints, 0, []byte(`{"id":"a","method":"uppercase","request":{"s":"hello"}}`))
+	if r, ok := res.Result.(uppercaseResponse); res.Err != nil || !ok || r.V != "HELLO" || res.ID != "a" {
+		t.Errorf("a valid operation: got %+v", res)
+	}
+	for i, c := range []struct {
+		line string
+		code svcerrors.Code
+	}{
+		{`{"method":`, svcerrors.CodeInvalidArgument},
+		{`{"method":"reverse","request":{}}`, svcerrors.CodeInvalidArgument},
+		{`{"method":"uppercase","request":{"s":7}}`, svcerrors.CodeInvalidArgument},
+		{`{"method":"uppercase","request":{"s":""}}`, svcerrors.CodeStringEmpty},
+	} {
+		res := runBulkOperation(ctx, endpoints, i+1, []byte(c.line))
+		if res.Index != i+1 || res.Err == nil || res.Err.Code != c.code {
+			t.Errorf("%s: got %+v, want %s on line %d", c.line, res, c.code, i+1)
+		}
+	}
+}
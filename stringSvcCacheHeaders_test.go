@@ -0,0 +1,53 @@
+package stringsvc
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCacheHeaders(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	policy := `
+default: {cache_control: "public, max-age=60"}
+endpoints:
+  /uppercase: {cache_control: "public, max-age=3600", expires: 1h, vary: [Accept, Accept-Language]}
+`
+	if err := os.WriteFile(path, []byte(policy), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	srv := newTestServer(t, "-policy-file", path)
+
+	resp, _ := callTestServer(t, srv, "GET", "/uppercase?s=hello", nil, "")
+	if got := resp.Header.Get("Cache-Control"); got != "public, max-age=3600" {
+		t.Errorf("GET /uppercase: got Cache-Control %q", got)
+	}
+	vary := map[string]bool{}
+	for _, v := range resp.Header.Values("Vary") {
+		for _, h := range strings.Split(v, ",") {
+			vary[http.CanonicalHeaderKey(strings.TrimSpace(h))] = true
+		}
+	}
+	if !vary["Accept"] || !vary["Accept-Language"] {
+		t.Errorf("GET /uppercase: got Vary %q, want Accept and Accept-Language", resp.Header.Values("Vary"))
+	}
+	if exp, err := http.ParseTime(resp.Header.Get("Expires")); err != nil || time.Until(exp) < 59*time.Minute || time.Until(exp) > time.Hour {
+		t.Errorf("GET /uppercase: got Expires %q, %v, want in an hour", resp.Header.Get("Expires"), err)
+	}
+
+	if resp, _ := callTestServer(t, srv, "GET", "/count?s=hello", nil, ""); resp.Header.Get("Cache-Control") != "public, max-age=60" {
+		t.Errorf("GET /count: got Cache-Control %q, want the default's", resp.Header.Get("Cache-Control"))
+	}
+	if resp, _ := callTestServer(t, srv, "POST", "/uppercase", http.Header{"Content-Type": {"application/json"}}, `{"s":"hello"}`); resp.Header.Get("Expires") != "" || resp.Header.Get("Cache-Control") == "public, max-age=3600" {
+		t.Errorf("POST /uppercase: got caching headers %q", resp.Header)
+	}
+	if resp, _ := callTestServer(t, srv, "GET", "/uppercase", nil, ""); resp.StatusCode != http.StatusBadRequest || resp.Header.Get("Expires") != "" {
+		t.Errorf("a failed GET: got %d with Expires %q, want 400 without", resp.StatusCode, resp.Header.Get("Expires"))
+	}
+	if resp, _ := callTestServer(t, srv, "GET", "/hostname", nil, ""); resp.Header.Get("Cache-Control") != "no-store" {
+		t.Errorf("GET /hostname: got Cache-Control %q, want no-store", resp.Header.Get("Cache-Control"))
+	}
+}
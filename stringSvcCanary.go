@@ -0,0 +1,154 @@
+package stringsvc
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/metrics"
+
+	"github.com/mcclayac/gokit/kit"
+)
+
+// Canaries roll a change of behavior out gradually: a canary is an
+// alternate implementation of an endpoint, and -canary sends a percentage
+// of each endpoint's callers to its canary, such as
+//
+//	stringsvc -canary count=5 ...
+//
+// A caller is routed by the hash of their identity, so they keep getting
+// the same implementation while the percentage stays the same. A request
+// whose -canary-header (X-Canary) is on or off is routed to the canary or
+// away from it regardless, for trying it out before it gets traffic.
+// canary_requests and canary_request_duration count and time the calls of
+// both implementations by method, variant (primary or canary) and code,
+// for comparing them before the percentage is raised.
+
+// knownCanaries are the endpoints that have a canary, with what it does
+// differently.
+var knownCanaries = map[string]string{
+	"count": "counts characters rather than bytes",
+}
+
+// canaryConfig configures canary routing.
+type canaryConfig struct {
+	Percents canaryPercents
+	Header   string // routes requests to the canary when on, away when off
+}
+
+// canaryPercents is the percentage of each endpoint's callers routed to its
+// canary. It implements flag.Value, parsing lists like "count=5".
+type canaryPercents map[string]float64
+
+func (p *canaryPercents) Set(s string) error {
+	percents := canaryPercents{}
+	for _, kv := range strings.Split(s, ",") {
+		if kv = strings.TrimSpace(kv); kv == "" {
+			continue
+		}
+		i := strings.IndexByte(kv, '=')
+		if i < 0 {
+			return fmt.Errorf("%q: want method=percent", kv)
+		}
+		v, err := strconv.ParseFloat(strings.TrimSuffix(kv[i+1:], "%"), 64)
+		if err != nil || v < 0 || v > 100 {
+			return fmt.Errorf("%q: want a percentage between 0 and 100", kv)
+		}
+		percents[kv[:i]] = v
+	}
+	*p = percents
+	return nil
+}
+
+func (p *canaryPercents) String() string {
+	var parts []string
+	for m, v := range *p {
+		parts = append(parts, m+"="+strconv.FormatFloat(v, 'f', -1, 64))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+type canaryHeaderKey struct{}
+
+// canaryHeaderToContext returns a ServerBefore function that keeps the
+// value of the canary header in the context.
+func canaryHeaderToContext(header string) func(context.Context, *http.Request) context.Context {
+	return func(ctx context.Context, r *http.Request) context.Context {
+		if v := r.Header.Get(header); v != "" {
+			return context.WithValue(ctx, canaryHeaderKey{}, v)
+		}
+		return ctx
+	}
+}
+
+// canaryRouter routes calls between endpoints and their canaries.
+type canaryRouter struct {
+	percents canaryPercents
+	requests metrics.Counter   // labeled by method, variant and code
+	latency  metrics.Histogram // seconds, labeled by method and variant
+}
+
+func newCanaryRouter(cfg canaryConfig, f metricsFactory) *canaryRouter {
+	return &canaryRouter{
+		percents: cfg.Percents,
+		requests: f.Counter("canary_requests", "Number of calls to endpoints with a canary, by implementation.", "method", "variant", "code"),
+		latency: f.Histogram("canary_request_duration", "Time spent in endpoints with a canary, by implementation.",
+			defaultLatencyBuckets, "method", "variant"),
+	}
+}
+
+// chooses reports whether the call of ctx to method goes to the canary.
+func (c *canaryRouter) chooses(ctx context.Context, method string) bool {
+	if v, ok := ctx.Value(canaryHeaderKey{}).(string); ok {
+		switch strings.ToLower(v) {
+		case "on", "true", "1":
+			return true
+		case "off", "false", "0":
+			return false
+		}
+	}
+	percent := c.percents[method]
+	if percent <= 0 {
+		return false
+	}
+	h := fnv.New32a()
+	h.Write([]byte("canary/" + method + "/" + identity(ctx)))
+	return float64(h.Sum32()%10000) < percent*100
+}
+
+// Route returns an endpoint that calls canary or primary, and records how
+// each does. With a nil router, it returns primary.
+func (c *canaryRouter) Route(method string, primary, canary endpoint.Endpoint) endpoint.Endpoint {
+	if c == nil {
+		return primary
+	}
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		variant, next := "primary", primary
+		if c.chooses(ctx, method) {
+			variant, next = "canary", canary
+		}
+		defer func(begin time.Time) {
+			c.requests.With("method", method, "variant", variant, "code", kit.OutcomeCode(response, err)).Add(1)
+			c.latency.With("method", method, "variant", variant).Observe(time.Since(begin).Seconds())
+		}(time.Now())
+		return next(ctx, request)
+	}
+}
+
+// runeCountStringService is the count canary: it counts characters, as
+// count_runes does, rather than bytes.
+type runeCountStringService struct {
+	StringService
+}
+
+func (runeCountStringService) Count(_ context.Context, s string) int {
+	return utf8.RuneCountInString(s)
+}
@@ -0,0 +1,71 @@
+package stringsvc
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestCanaryRouting(t *testing.T) {
+	mf, err := newMetricsFactory(metricsSink{}, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newCanaryRouter(canaryConfig{Percents: canaryPercents{"count": 20}}, mf)
+	variant := func(name string) func(context.Context, interface{}) (interface{}, error) {
+		return func(context.Context, interface{}) (interface{}, error) { return name, nil }
+	}
+	count := c.Route("count", variant("primary"), variant("canary"))
+	uppercase := c.Route("uppercase", variant("primary"), variant("canary"))
+	call := func(e func(context.Context, interface{}) (interface{}, error), ctx context.Context) string {
+		v, _ := e(ctx, nil)
+		return v.(string)
+	}
+
+	canaries := 0
+	for i := 0; i < 10000; i++ {
+		ctx := context.WithValue(context.Background(), principalKey{}, issuedToken{Subject: fmt.Sprintf("caller-%d", i)})
+		got := call(count, ctx)
+		if got == "canary" {
+			canaries++
+		}
+		if again := call(count, ctx); again != got {
+			t.Fatalf("caller-%d: routed to %s, then %s", i, got, again)
+		}
+		if call(uppercase, ctx) != "primary" {
+			t.Fatalf("caller-%d: routed to an endpoint's canary at 0%%", i)
+		}
+	}
+	if canaries < 1700 || canaries > 2300 {
+		t.Errorf("routed %d of 10000 callers to the canary at 20%%, want about 2000", canaries)
+	}
+
+	for header, want := range map[string]string{"on": "canary", "true": "canary", "off": "primary", "0": "primary"} {
+		ctx := context.WithValue(context.Background(), canaryHeaderKey{}, header)
+		if got := call(uppercase, ctx); got != want {
+			t.Errorf("with the canary header %s: routed to %s, want %s", header, got, want)
+		}
+	}
+
+	var none *canaryRouter
+	if got := call(none.Route("count", variant("primary"), variant("canary")), context.Background()); got != "primary" {
+		t.Errorf("without a router: routed to %s", got)
+	}
+}
+
+func TestCanaryPercentsFlag(t *testing.T) {
+	var p canaryPercents
+	if err := p.Set("count=5, uppercase=12.5%"); err != nil {
+		t.Fatal(err)
+	}
+	if p["count"] != 5 || p["uppercase"] != 12.5 || p.String() != "count=5,uppercase=12.5" {
+		t.Errorf("got %v, %q", p, p.String())
+	}
+	for _, bad := range []string{"count", "count=101", "count=-1", "count=five"} {
+		if err := p.Set(bad); err == nil {
+			t.Errorf("%q: no error", bad)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package stringsvc
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+
+	"github.com/mcclayac/gokit/svcerrors"
+)
+
+// chaosConfig enables fault injection, for chaos experiments such as
+// checking that clients retry and trip their breakers as they should.
+type chaosConfig struct {
+	Enabled bool   // faults can be set at /admin/chaos, with a token
+	File    string // JSON array of the faults to start with
+}
+
+// chaosFault is the fault injected into the calls of a method. Each rate is
+// the fraction of calls, from 0 to 1, that its fault hits.
+type chaosFault struct {
+	Method string `json:"method" xml:"method"` // or "*" for the methods not named
+
+	// Delayed calls wait LatencyMS plus up to JitterMS milliseconds, or
+	// until their deadline, before going ahead.
+	LatencyRate float64 `json:"latency_rate" xml:"latency_rate"`
+	LatencyMS   int64   `json:"latency_ms" xml:"latency_ms"`
+	JitterMS    int64   `json:"jitter_ms" xml:"jitter_ms"`
+
+	// Failed calls return ErrorCode, INTERNAL by default, without reaching
+	// the service.
+	ErrorRate float64        `json:"error_rate" xml:"error_rate"`
+	ErrorCode svcerrors.Code `json:"error_code,omitempty" xml:"error_code,omitempty"`
+
+	// Partially failed calls reach the service and then return ErrorCode,
+	// as if the response were lost: what the call did stays done, which
+	// shows whether clients retry such calls safely.
+	PartialRate float64 `json:"partial_rate" xml:"partial_rate"`
+}
+
+func (f chaosFault) check() error {
+	switch {
+	case f.Method == "":
+		return svcerrors.New(svcerrors.CodeInvalidArgument, "every fault needs a method, or \"*\"")
+	case !isRate(f.LatencyRate) || !isRate(f.ErrorRate) || !isRate(f.PartialRate):
+		return svcerrors.Errorf(svcerrors.CodeInvalidArgument, "%s: rates must be between 0 and 1", f.Method)
+	case f.LatencyMS < 0 || f.JitterMS < 0:
+		return svcerrors.Errorf(svcerrors.CodeInvalidArgument, "%s: latencies must not be negative", f.Method)
+	}
+	return nil
+}
+
+func isRate(r float64) bool { return r >= 0 && r <= 1 }
+
+func (f chaosFault) err() error {
+	code := f.ErrorCode
+	if code == "" {
+		code = svcerrors.CodeInternal
+	}
+	return svcerrors.Errorf(code, "fault injected into %s", f.Method)
+}
+
+// chaos holds the faults being injected.
+type chaos struct {
+	mtx    sync.RWMutex
+	faults []chaosFault
+	byName map[string]chaosFault
+}
+
+// Set replaces the faults injected.
+func (c *chaos) Set(faults []chaosFault) error {
+	byName := make(map[string]chaosFault, len(faults))
+	for _, f := range faults {
+		if err := f.check(); err != nil {
+			return err
+		}
+		if _, ok := byName[f.Method]; ok {
+			return svcerrors.Errorf(svcerrors.CodeInvalidArgument, "%s has two faults", f.Method)
+		}
+		byName[f.Method] = f
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.faults, c.byName = faults, byName
+	return nil
+}
+
+// Faults returns the faults injected.
+func (c *chaos) Faults() []chaosFault {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+	return c.faults
+}
+
+func (c *chaos) fault(method string) (chaosFault, bool) {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+	if f, ok := c.byName[method]; ok {
+		return f, true
+	}
+	f, ok := c.byName["*"]
+	return f, ok
+}
+
+func loadChaosFaults(path string) ([]chaosFault, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var faults []chaosFault
+	if err := json.Unmarshal(b, &faults); err != nil {
+		return nil, err
+	}
+	return faults, nil
+}
+
+// chaosMiddleware injects the faults c holds for method into its calls.
+func chaosMiddleware(c *chaos, method string) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			f, ok := c.fault(method)
+			if !ok {
+				return next(ctx, request)
+			}
+			if rand.Float64() < f.LatencyRate {
+				d := time.Duration(f.LatencyMS) * time.Millisecond
+				if f.JitterMS > 0 {
+					d += time.Duration(rand.Int63n(f.JitterMS * int64(time.Millisecond)))
+				}
+				t := time.NewTimer(d)
+				select {
+				case <-ctx.Done():
+					t.Stop()
+					return nil, ctx.Err()
+				case <-t.C:
+				}
+			}
+			if rand.Float64() < f.ErrorRate {
+				return nil, f.err()
+			}
+			response, err := next(ctx, request)
+			if err == nil && rand.Float64() < f.PartialRate {
+				return nil, f.err()
+			}
+			return response, err
+		}
+	}
+}
+
+// chaosRequest reads or replaces the faults injected: GET reads them, PUT
+// replaces them with the body's and DELETE removes them all.
+type chaosRequest struct {
+	Set    bool         `json:"-" xml:"-"`
+	Faults []chaosFault `json:"faults" xml:"faults>fault"`
+}
+
+type chaosResponse struct {
+	Faults []chaosFault `json:"faults" xml:"faults>fault"`
+}
+
+func makeChaosEndpoint(c *chaos) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(chaosRequest)
+		if req.Set {
+			if err := c.Set(req.Faults); err != nil {
+				return nil, err
+			}
+		}
+		faults := c.Faults()
+		if faults == nil {
+			faults = []chaosFault{}
+		}
+		return chaosResponse{faults}, nil
+	}
+}
+
+func decodeChaosRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request chaosRequest
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		if err := decodeBody(r, &request); err != nil {
+			return nil, err
+		}
+		request.Set = true
+	case http.MethodDelete:
+		request.Set = true
+	default:
+		return nil, svcerrors.Errorf(svcerrors.CodeInvalidArgument, "%s /admin/chaos: want GET, PUT or DELETE", r.Method)
+	}
+	return request, nil
+}
@@ -0,0 +1,77 @@
+package stringsvc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mcclayac/gokit/svcerrors"
+)
+
+func TestChaosRates(t *testing.T) {
+	c := &chaos{}
+	if err := c.Set([]chaosFault{
+		{Method: "count", ErrorRate: 0.25, ErrorCode: svcerrors.CodeRateLimited},
+		{Method: "uppercase", PartialRate: 0.5},
+		{Method: "*", ErrorRate: 1},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	run := func(method string, n int) (reached, failed int, codes map[svcerrors.Code]int) {
+		codes = map[svcerrors.Code]int{}
+		e := chaosMiddleware(c, method)(func(context.Context, interface{}) (interface{}, error) {
+			reached++
+			return nil, nil
+		})
+		for i := 0; i < n; i++ {
+			if _, err := e(context.Background(), nil); err != nil {
+				failed++
+				codes[svcerrors.CodeOf(err)]++
+			}
+		}
+		return reached, failed, codes
+	}
+
+	const n = 10000
+	// The bounds are over 6 standard deviations from the rates.
+	if reached, failed, codes := run("count", n); failed < 2200 || failed > 2800 || reached != n-failed || codes[svcerrors.CodeRateLimited] != failed {
+		t.Errorf("count at an error rate of 0.25: %d of %d failed, %d reached the service, codes %v", failed, n, reached, codes)
+	}
+	if reached, failed, codes := run("uppercase", n); reached != n || failed < 4700 || failed > 5300 || codes[svcerrors.CodeInternal] != failed {
+		t.Errorf("uppercase at a partial rate of 0.5: %d of %d failed, %d reached the service, codes %v", failed, n, reached, codes)
+	}
+	if reached, failed, _ := run("hostname", 100); reached != 0 || failed != 100 {
+		t.Errorf("the \"*\" fault: %d of 100 failed, %d reached the service", failed, reached)
+	}
+
+	c.Set(nil)
+	if reached, failed, _ := run("count", 100); reached != 100 || failed != 0 {
+		t.Errorf("without faults: %d of 100 failed", failed)
+	}
+}
+
+func TestChaosLatency(t *testing.T) {
+	c := &chaos{}
+	if err := c.Set([]chaosFault{{Method: "count", LatencyRate: 1, LatencyMS: 60000}}); err != nil {
+		t.Fatal(err)
+	}
+	e := chaosMiddleware(c, "count")(func(context.Context, interface{}) (interface{}, error) { return nil, nil })
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := e(ctx, nil); err != context.DeadlineExceeded {
+		t.Errorf("a delay past the deadline: got %v, want the deadline", err)
+	}
+}
+
+func TestChaosFaultCheck(t *testing.T) {
+	for _, faults := range [][]chaosFault{
+		{{ErrorRate: 0.1}},
+		{{Method: "count", ErrorRate: 1.5}},
+		{{Method: "count", LatencyMS: -1}},
+		{{Method: "count"}, {Method: "count"}},
+	} {
+		if err := (&chaos{}).Set(faults); svcerrors.CodeOf(err) != svcerrors.CodeInvalidArgument {
+			t.Errorf("%+v: got %v, want INVALID_ARGUMENT", faults, err)
+		}
+	}
+}
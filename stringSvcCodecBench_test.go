@@ -0,0 +1,66 @@
+package stringsvc
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/mcclayac/gokit/codec"
+)
+
+// BenchmarkCodecs measures the codecs on the payloads clients send most:
+// how long each takes to encode and decode them, how much it allocates
+// doing so, and how large its encoding is, next to JSON's.
+//
+//	go test -run '^$' -bench Codecs -benchmem
+func BenchmarkCodecs(b *testing.B) {
+	// The small requests and responses of the hot calls, and a few
+	// larger, nested ones, by contract case name.
+	payloads := map[string]bool{
+		"uppercase_request":   true,
+		"uppercase_response":  true,
+		"count_response":      true,
+		"link_response":       true,
+		"detect_response":     true,
+		"spellcheck_response": true,
+		"error_response":      true,
+	}
+	codecs := []struct {
+		name  string
+		codec codec.Codec
+	}{
+		{"json", codec.JSON},
+		{"msgpack", codec.MsgPack},
+		{"cbor", codec.CBOR},
+	}
+	for _, cc := range contractCases {
+		if !payloads[cc.name] {
+			continue
+		}
+		for _, c := range codecs {
+			var buf bytes.Buffer
+			if err := c.codec.Encode(&buf, cc.value); err != nil {
+				b.Fatalf("%s in %s: %v", cc.name, c.name, err)
+			}
+			encoded := buf.Bytes()
+			b.Run(cc.name+"/"+c.name+"/encode", func(b *testing.B) {
+				b.ReportAllocs()
+				b.ReportMetric(float64(len(encoded)), "bytes")
+				var w bytes.Buffer
+				for i := 0; i < b.N; i++ {
+					w.Reset()
+					if err := c.codec.Encode(&w, cc.value); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+			b.Run(cc.name+"/"+c.name+"/decode", func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					if err := c.codec.Decode(bytes.NewReader(encoded), cc.new()); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
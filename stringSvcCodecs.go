@@ -0,0 +1,359 @@
+package stringsvc
+
+import (
+	"net/http"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/mcclayac/gokit/codec"
+	"github.com/mcclayac/gokit/stringsvcpb"
+	"github.com/mcclayac/gokit/svcerrors"
+)
+
+// newCodecRegistry returns the wire formats the transports negotiate
+// between. JSON is the default for requests without Content-Type or Accept
+// headers.
+func newCodecRegistry() *codec.Registry {
+	r := codec.NewRegistry(codec.JSON)
+	r.Register(codec.XML, "text/xml")
+	r.Register(codec.MsgPack, "application/x-msgpack", "application/vnd.msgpack")
+	r.Register(codec.Protobuf, "application/protobuf", "application/vnd.google.protobuf")
+	r.Register(codec.CBOR)
+	return r
+}
+
+// decodeBody decodes the request body into v using the codec selected by the
+// Content-Type header, strictly for callers with the strict_json feature.
+// Forms are decoded by decodeForm.
+func decodeBody(r *http.Request, v interface{}) error {
+	if isForm(r) {
+		return decodeForm(r, v)
+	}
+	env := appEnvFromContext(r.Context())
+	c, err := env.codecs.ForContentType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return err
+	}
+	if jc, ok := c.(codec.JSONCodec); ok && !jc.Strict && env.features.Enabled(featureContext(r), "strict_json") {
+		c = codec.JSONCodec{Strict: true}
+	}
+	if err := c.Decode(r.Body, v); err != nil {
+		return svcerrors.Errorf(svcerrors.CodeInvalidArgument, "malformed request body: %v", err)
+	}
+	return nil
+}
+
+// hasBody reports whether r has a body, which it doesn't if it declares a
+// length of zero, as requests without one do. A body of unknown length,
+// sent chunked, counts as one.
+func hasBody(r *http.Request) bool {
+	return r.Body != nil && r.Body != http.NoBody && r.ContentLength != 0
+}
+
+// errorResponse is the envelope encodeError writes for every failure.
+type errorResponse struct {
+	Err *svcerrors.Error `json:"err" xml:"err"`
+}
+
+// The protobuf forms of the request and response types are the messages
+// of stringsvc.proto, generated into package stringsvcpb. The structs above
+// stay the single definition of each type, and convert to and from them.
+
+//go:generate protoc --go_out=. --go_opt=module=github.com/mcclayac/gokit stringsvc.proto
+
+func (r *uppercaseRequest) UnmarshalProto(b []byte) error {
+	var m stringsvcpb.UppercaseRequest
+	if err := proto.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	r.S = m.S
+	return nil
+}
+
+func (r *countRequest) UnmarshalProto(b []byte) error {
+	var m stringsvcpb.CountRequest
+	if err := proto.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	r.S = m.S
+	return nil
+}
+
+func (r *hostnameRequest) UnmarshalProto(b []byte) error {
+	return proto.Unmarshal(b, &stringsvcpb.HostnameRequest{})
+}
+
+func (r *mathRequest) UnmarshalProto(b []byte) error {
+	var m stringsvcpb.MathRequest
+	if err := proto.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	r.A, r.B = m.A, m.B
+	return nil
+}
+
+func (r *nowRequest) UnmarshalProto(b []byte) error {
+	var m stringsvcpb.NowRequest
+	if err := proto.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	r.TZ = m.Tz
+	return nil
+}
+
+func (r *formatRequest) UnmarshalProto(b []byte) error {
+	var m stringsvcpb.FormatRequest
+	if err := proto.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	r.TS, r.Layout, r.TZ = m.Ts, m.Layout, m.Tz
+	return nil
+}
+
+func (r *convertRequest) UnmarshalProto(b []byte) error {
+	var m stringsvcpb.ConvertRequest
+	if err := proto.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	r.TS, r.From, r.To = m.Ts, m.From, m.To
+	return nil
+}
+
+func (r *uuidRequest) UnmarshalProto(b []byte) error {
+	var m stringsvcpb.UUIDRequest
+	if err := proto.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	r.Version, r.Count = m.Version, int(m.Count)
+	return nil
+}
+
+func (r *ulidRequest) UnmarshalProto(b []byte) error {
+	var m stringsvcpb.ULIDRequest
+	if err := proto.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	r.Count, r.Monotonic = int(m.Count), m.Monotonic
+	return nil
+}
+
+func (r *hashRequest) UnmarshalProto(b []byte) error {
+	var m stringsvcpb.HashRequest
+	if err := proto.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	r.Password = m.Password
+	return nil
+}
+
+func (r *verifyRequest) UnmarshalProto(b []byte) error {
+	var m stringsvcpb.VerifyRequest
+	if err := proto.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	r.Password, r.Hash = m.Password, m.Hash
+	return nil
+}
+
+func (r *shortenRequest) UnmarshalProto(b []byte) error {
+	var m stringsvcpb.ShortenRequest
+	if err := proto.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	r.URL, r.TTL = m.Url, m.TtlSeconds
+	return nil
+}
+
+func (r *resolveRequest) UnmarshalProto(b []byte) error {
+	var m stringsvcpb.ResolveRequest
+	if err := proto.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	r.Code = m.Code
+	return nil
+}
+
+func (r *qrcodeRequest) UnmarshalProto(b []byte) error {
+	var m stringsvcpb.QRCodeRequest
+	if err := proto.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	r.S, r.Format, r.Size, r.Level = m.S, m.Format, int(m.Size), m.Level
+	return nil
+}
+
+func (r *translateRequest) UnmarshalProto(b []byte) error {
+	var m stringsvcpb.TranslateRequest
+	if err := proto.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	r.Text, r.Source, r.Target = m.Text, m.Source, m.Target
+	return nil
+}
+
+func (r *spellcheckRequest) UnmarshalProto(b []byte) error {
+	var m stringsvcpb.SpellcheckRequest
+	if err := proto.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	r.Text, r.MaxSuggestions = m.Text, int(m.MaxSuggestions)
+	return nil
+}
+
+func (r *detectRequest) UnmarshalProto(b []byte) error {
+	var m stringsvcpb.DetectRequest
+	if err := proto.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	r.S, r.Max = m.S, int(m.Max)
+	return nil
+}
+
+func (r *summarizeRequest) UnmarshalProto(b []byte) error {
+	var m stringsvcpb.SummarizeRequest
+	if err := proto.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	r.Text, r.MaxTokens = m.Text, int(m.MaxTokens)
+	return nil
+}
+
+func (r *rewriteRequest) UnmarshalProto(b []byte) error {
+	var m stringsvcpb.RewriteRequest
+	if err := proto.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	r.Text, r.Style, r.MaxTokens = m.Text, m.Style, int(m.MaxTokens)
+	return nil
+}
+
+func (r *tokenRequest) UnmarshalProto(b []byte) error {
+	var m stringsvcpb.TokenRequest
+	if err := proto.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	r.ClientID, r.ClientSecret = m.ClientId, m.ClientSecret
+	r.Scopes, r.TTL = m.Scopes, m.TtlSeconds
+	return nil
+}
+
+func (r *revokeRequest) UnmarshalProto(b []byte) error {
+	var m stringsvcpb.RevokeRequest
+	if err := proto.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	r.Token = m.Token
+	return nil
+}
+
+func (r uppercaseResponse) MarshalProto() ([]byte, error) {
+	return proto.Marshal(&stringsvcpb.UppercaseResponse{V: r.V})
+}
+
+func (r countResponse) MarshalProto() ([]byte, error) {
+	return proto.Marshal(&stringsvcpb.CountResponse{V: int64(r.V)})
+}
+
+func (r hostnameResponse) MarshalProto() ([]byte, error) {
+	return proto.Marshal(&stringsvcpb.HostnameResponse{V: r.V})
+}
+
+func (r mathResponse) MarshalProto() ([]byte, error) {
+	return proto.Marshal(&stringsvcpb.MathResponse{V: r.V})
+}
+
+func (r timeResponse) MarshalProto() ([]byte, error) {
+	return proto.Marshal(&stringsvcpb.TimeResponse{V: r.V})
+}
+
+func (r idsResponse) MarshalProto() ([]byte, error) {
+	return proto.Marshal(&stringsvcpb.IDsResponse{Ids: r.IDs})
+}
+
+func (r hashResponse) MarshalProto() ([]byte, error) {
+	return proto.Marshal(&stringsvcpb.HashResponse{Hash: r.Hash})
+}
+
+func (r verifyResponse) MarshalProto() ([]byte, error) {
+	return proto.Marshal(&stringsvcpb.VerifyResponse{V: r.V})
+}
+
+func (r linkResponse) MarshalProto() ([]byte, error) {
+	return proto.Marshal(&stringsvcpb.LinkResponse{Link: &stringsvcpb.ShortLink{
+		Code:              r.Link.Code,
+		Url:               r.Link.URL,
+		CreatedUnixMillis: unixMillis(r.Link.Created),
+		ExpiresUnixMillis: unixMillis(r.Link.Expires),
+		Hits:              r.Link.Hits,
+	}})
+}
+
+func (r qrcodeResponse) MarshalProto() ([]byte, error) {
+	return proto.Marshal(&stringsvcpb.QRCodeResponse{Image: &stringsvcpb.QRImage{
+		ContentType: r.Image.ContentType,
+		Data:        r.Image.Data,
+	}})
+}
+
+func (r translateResponse) MarshalProto() ([]byte, error) {
+	return proto.Marshal(&stringsvcpb.TranslateResponse{
+		V:              r.V,
+		DetectedSource: r.DetectedSource,
+		Provider:       r.Provider,
+	})
+}
+
+func (r spellcheckResponse) MarshalProto() ([]byte, error) {
+	m := &stringsvcpb.SpellcheckResponse{}
+	for _, v := range r.Misspellings {
+		m.Misspellings = append(m.Misspellings, &stringsvcpb.Misspelling{
+			Word:        v.Word,
+			Offset:      int64(v.Offset),
+			Suggestions: v.Suggestions,
+		})
+	}
+	return proto.Marshal(m)
+}
+
+func (r detectResponse) MarshalProto() ([]byte, error) {
+	m := &stringsvcpb.DetectResponse{}
+	for _, g := range r.Languages {
+		m.Languages = append(m.Languages, &stringsvcpb.LanguageGuess{Lang: g.Lang, Confidence: g.Confidence})
+	}
+	return proto.Marshal(m)
+}
+
+func (r writingResponse) MarshalProto() ([]byte, error) {
+	return proto.Marshal(&stringsvcpb.WritingResponse{V: r.V})
+}
+
+func (r tokenResponse) MarshalProto() ([]byte, error) {
+	return proto.Marshal(&stringsvcpb.TokenResponse{
+		AccessToken: r.AccessToken,
+		TokenType:   r.TokenType,
+		ExpiresIn:   r.ExpiresIn,
+		Scopes:      r.Scopes,
+	})
+}
+
+func (r revokeResponse) MarshalProto() ([]byte, error) {
+	return proto.Marshal(&stringsvcpb.RevokeResponse{})
+}
+
+func (r errorResponse) MarshalProto() ([]byte, error) {
+	e := &stringsvcpb.Error{Code: string(r.Err.Code), Message: r.Err.Message}
+	for _, f := range r.Err.Fields {
+		e.Fields = append(e.Fields, &stringsvcpb.FieldViolation{Field: f.Field, Rule: f.Rule, Message: f.Message})
+	}
+	return proto.Marshal(&stringsvcpb.ErrorResponse{Err: e})
+}
+
+// unixMillis is t in Unix milliseconds, or zero, which leaves the field
+// unset, for the zero time.
+func unixMillis(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixMilli()
+}
@@ -0,0 +1,67 @@
+package stringsvc
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/mcclayac/gokit/codec"
+	"github.com/mcclayac/gokit/stringsvcpb"
+	"github.com/mcclayac/gokit/svcerrors"
+)
+
+func TestCodecNegotiation(t *testing.T) {
+	srv := newTestServer(t)
+	call := func(contentType, accept, body string) (*http.Response, []byte) {
+		return callTestServer(t, srv, "POST", "/uppercase", http.Header{"Content-Type": {contentType}, "Accept": {accept}}, body)
+	}
+
+	resp, b := call("application/xml", "application/xml", `<uppercaseRequest><s>hello</s></uppercaseRequest>`)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(b), "<v>HELLO</v>") || !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/xml") {
+		t.Errorf("XML: got %d %s %q", resp.StatusCode, resp.Header.Get("Content-Type"), b)
+	}
+
+	var req bytes.Buffer
+	if err := codec.MsgPack.Encode(&req, uppercaseRequest{S: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	resp, b = call("application/x-msgpack", "application/vnd.msgpack", req.String())
+	var up struct {
+		V string `json:"v"`
+	}
+	if err := codec.MsgPack.Decode(bytes.NewReader(b), &up); err != nil || resp.StatusCode != http.StatusOK || up.V != "HELLO" {
+		t.Errorf("MessagePack: got %d %+v, %v", resp.StatusCode, up, err)
+	}
+
+	pb, err := proto.Marshal(&stringsvcpb.UppercaseRequest{S: "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, b = call("application/protobuf", "application/protobuf", string(pb))
+	var pbResp stringsvcpb.UppercaseResponse
+	if err := proto.Unmarshal(b, &pbResp); err != nil || resp.StatusCode != http.StatusOK || pbResp.V != "HELLO" {
+		t.Errorf("Protobuf: got %d %q, %v", resp.StatusCode, pbResp.V, err)
+	}
+
+	// JSON is the default, and what Accept: */* gets.
+	if resp, b := call("", "*/*", `{"s":"hello"}`); resp.StatusCode != http.StatusOK || !strings.Contains(string(b), `"HELLO"`) {
+		t.Errorf("no Content-Type: got %d %q", resp.StatusCode, b)
+	}
+
+	for _, c := range []struct {
+		name, contentType, accept string
+		status                    int
+		code                      svcerrors.Code
+	}{
+		{"unknown Content-Type", "text/plain", "", http.StatusUnsupportedMediaType, svcerrors.CodeUnsupportedMediaType},
+		{"unknown Accept", "application/json", "image/png", http.StatusNotAcceptable, svcerrors.CodeNotAcceptable},
+	} {
+		resp, b := call(c.contentType, c.accept, `{"s":"hello"}`)
+		if resp.StatusCode != c.status || !strings.Contains(string(b), string(c.code)) {
+			t.Errorf("%s: got %d %q, want %d %s", c.name, resp.StatusCode, b, c.status, c.code)
+		}
+	}
+}
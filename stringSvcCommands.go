@@ -0,0 +1,220 @@
+package stringsvc
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mcclayac/gokit/client"
+)
+
+// The binary is a set of subcommands:
+//
+//	stringsvc [serve] [flags]        serve the string service (the default)
+//	stringsvc client [flags] METHOD  call a running service
+//	stringsvc migrate [flags]        apply pending database migrations
+//	stringsvc config validate [flags]
+//	stringsvc version
+//
+// and the operational ones, bench, replay and restore.
+// serve, migrate, restore and config validate share the server's flags,
+// profiles included.
+
+// command is a subcommand. run returns the exit status: 0 on success, 2 on
+// a usage error and 1 on any other failure.
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) int
+}
+
+// commands are the subcommands, in the order usage lists them. They are set
+// in init, since help refers to them.
+var commands []command
+
+func init() {
+	commands = []command{
+		{"serve", "serve the string service over HTTP, or as a worker (the default)", runServe},
+		{"client", "call a running service and print the result as JSON", runClient},
+		{"migrate", "apply pending database migrations and exit", runMigrate},
+		{"restore", "load an archive written by /admin/export, read from stdin, into the stores", runRestore},
+		{"config", "check the configuration: config validate [flags]", runConfig},
+		{"bench", "generate traffic against a running service", runBench},
+		{"replay", "replay recorded calls against a candidate and report the differences", runReplay},
+		{"version", "print the version and exit", runVersion},
+		{"help", "list the subcommands", runHelp},
+	}
+}
+
+// Main runs the subcommand args name and returns the exit status. Without
+// one, or when args start with a flag, it serves, as the binary did before
+// it had subcommands.
+func Main(args []string) int {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") && args[0] != "-h" && args[0] != "-help" && args[0] != "--help" {
+		return runServe(args)
+	}
+	for _, c := range commands {
+		if c.name == args[0] {
+			return c.run(args[1:])
+		}
+	}
+	if args[0] == "-h" || args[0] == "-help" || args[0] == "--help" {
+		return runHelp(nil)
+	}
+	fmt.Fprintf(os.Stderr, "stringsvc: unknown subcommand %q\n\n", args[0])
+	usage()
+	return 2
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: stringsvc [subcommand] [flags]")
+	fmt.Fprintln(os.Stderr)
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-9s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Run stringsvc <subcommand> -h for its flags.")
+}
+
+func runHelp([]string) int {
+	usage()
+	return 0
+}
+
+func runVersion(args []string) int {
+	fs := flag.NewFlagSet("stringsvc version", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	fmt.Printf("stringsvc %s %s %s/%s\n", version, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	return 0
+}
+
+// runConfig is the config subcommand. Its only subcommand, validate,
+// parses the server's flags and profile and reports every problem
+// checkConfig finds with them, without starting anything. It exits 1 if
+// there are any.
+func runConfig(args []string) int {
+	if len(args) == 0 || args[0] != "validate" {
+		fmt.Fprintln(os.Stderr, "usage: stringsvc config validate [flags]")
+		return 2
+	}
+	cfg, err := parseConfig("stringsvc config validate", args[1:])
+	if err != nil {
+		return 2
+	}
+	problems := checkConfig(cfg)
+	for _, p := range problems {
+		fmt.Fprintln(os.Stderr, p)
+	}
+	if len(problems) > 0 {
+		fmt.Fprintf(os.Stderr, "%d configuration problems\n", len(problems))
+		return 1
+	}
+	fmt.Println("configuration ok")
+	return 0
+}
+
+// clientMethods are the calls the client subcommand makes, by name, with
+// their arguments.
+var clientMethods = map[string]struct {
+	args string
+	call func(ctx context.Context, c *client.Client, args []string, opts []client.CallOption) (interface{}, error)
+}{
+	"uppercase": {"TEXT", func(ctx context.Context, c *client.Client, args []string, opts []client.CallOption) (interface{}, error) {
+		return c.Uppercase(ctx, args[0], opts...)
+	}},
+	"count": {"TEXT", func(ctx context.Context, c *client.Client, args []string, opts []client.CallOption) (interface{}, error) {
+		return c.Count(ctx, args[0], opts...)
+	}},
+	"hash": {"PASSWORD", func(ctx context.Context, c *client.Client, args []string, opts []client.CallOption) (interface{}, error) {
+		return c.Hash(ctx, args[0], opts...)
+	}},
+	"verify": {"PASSWORD HASH", func(ctx context.Context, c *client.Client, args []string, opts []client.CallOption) (interface{}, error) {
+		return c.Verify(ctx, args[0], args[1], opts...)
+	}},
+	"shorten": {"URL [TTL]", func(ctx context.Context, c *client.Client, args []string, opts []client.CallOption) (interface{}, error) {
+		var ttl time.Duration
+		if len(args) > 1 {
+			var err error
+			if ttl, err = time.ParseDuration(args[1]); err != nil {
+				return nil, err
+			}
+		}
+		return c.Shorten(ctx, args[0], ttl, opts...)
+	}},
+	"resolve": {"CODE", func(ctx context.Context, c *client.Client, args []string, opts []client.CallOption) (interface{}, error) {
+		return c.Resolve(ctx, args[0], opts...)
+	}},
+	"detect": {"TEXT [MAX]", func(ctx context.Context, c *client.Client, args []string, opts []client.CallOption) (interface{}, error) {
+		max := 0
+		if len(args) > 1 {
+			var err error
+			if max, err = strconv.Atoi(args[1]); err != nil {
+				return nil, err
+			}
+		}
+		return c.Detect(ctx, args[0], max, opts...)
+	}},
+}
+
+// runClient calls a running service once and prints the result as JSON.
+func runClient(args []string) int {
+	fs := flag.NewFlagSet("stringsvc client", flag.ContinueOnError)
+	target := fs.String("target", "http://localhost:9090", "base URL of the service")
+	token := fs.String("token", "", "bearer token to call with")
+	timeout := fs.Duration("timeout", 10*time.Second, "timeout for the call, retries included")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: stringsvc client [flags] METHOD ARGS...")
+		fmt.Fprintln(os.Stderr)
+		for _, name := range []string{"uppercase", "count", "hash", "verify", "shorten", "resolve", "detect"} {
+			fmt.Fprintf(os.Stderr, "  %s %s\n", name, clientMethods[name].args)
+		}
+		fmt.Fprintln(os.Stderr)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	m, ok := clientMethods[fs.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "stringsvc client: unknown method %q\n", fs.Arg(0))
+		return 2
+	}
+	margs := fs.Args()[1:]
+	if want := len(strings.Fields(m.args)) - strings.Count(m.args, "["); len(margs) < want || len(margs) > len(strings.Fields(m.args)) {
+		fmt.Fprintf(os.Stderr, "usage: stringsvc client %s %s\n", fs.Arg(0), m.args)
+		return 2
+	}
+	c, err := client.New(*target)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	opts := []client.CallOption{client.WithTimeout(*timeout)}
+	if *token != "" {
+		opts = append(opts, client.WithHeader("Authorization", "Bearer "+*token))
+	}
+	v, err := m.call(context.Background(), c, margs, opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,321 @@
+package stringsvc
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mcclayac/gokit/events"
+	"github.com/mcclayac/gokit/logging"
+)
+
+// config holds the server settings. It is filled from command-line flags,
+// layered over those of the profile.
+type config struct {
+	HTTPAddr       string
+	HTTPAddrFile   string    // written with the listen address once listening
+	TrustedProxies proxyList // whose X-Forwarded-For is believed
+	FixedTime      time.Time // the clock runs normally when zero
+	StrictJSON     bool
+	CanonicalCBOR  bool
+	UploadMaxBytes int64
+	IdempotencyTTL time.Duration
+	Log            logging.Config
+	Metrics        metricsSink
+	Tracing        tracingConfig
+	SlowRequest    time.Duration // slow request logging is off when zero
+	Chaos          chaosConfig
+	Maintenance    bool // start refusing calls until /admin/maintenance is turned off
+	Profile        profileConfig
+	Features       featuresConfig
+	Canary         canaryConfig
+	Experiments    string // YAML or JSON file of A/B experiments
+	PolicyFile     string // per-endpoint policies, reloaded on SIGHUP
+	Tenancy        tenancyConfig
+	Upgrade        upgradeConfig
+	TLS            tlsConfig
+	HTTP2          http2Config
+
+	// IdempotencyMaxBytes bounds the bodies of requests with an
+	// Idempotency-Key, and of the responses kept for them.
+	IdempotencyMaxBytes int64
+
+	PayloadLog         bool
+	PayloadLogRedact   string // comma-separated JSON paths
+	PayloadLogMaxBytes int
+	Record             recordConfig
+	Shadow             shadowConfig
+
+	PostgresDSN    string // persistence is off when empty
+	SQLitePath     string // the single-node alternative to PostgresDSN
+	MigrateOnStart bool   // otherwise the migrate subcommand must be run first
+	Storage        storageConfig
+	History        bool
+	Counters       countersConfig
+	Usage          bool // needs PostgresDSN or SQLitePath
+	UsageFlush     time.Duration
+	Billing        billingConfig // needs Usage
+
+	Kafka             events.KafkaConfig // event publishing is off without brokers
+	KafkaEncoding     string             // json, avro or protobuf
+	SchemaRegistryURL string
+	SchemaSubject     events.SubjectStrategy
+	OutboxInterval    time.Duration
+
+	Worker    workerConfig
+	Jobs      jobsConfig
+	Bulk      bulkConfig
+	Crypto    cryptoConfig
+	RateLimit rateLimitConfig
+	Auth      authConfig
+	Shortener shortenerConfig
+	Translate translateConfig
+	Spell     spellConfig
+	LLM       llmConfig
+
+	AuditLog        string // audit logging to a file is off when empty
+	AuditMaxSizeMB  int
+	AuditMaxBackups int
+	AuditDB         bool // needs PostgresDSN or SQLitePath
+	AuditRetention  time.Duration
+
+	SentryDSN         string // error reporting is off when empty
+	SentryEnvironment string
+	SentrySampleRate  float64
+}
+
+// defaultRedactPaths are the JSON paths masked by default wherever bodies
+// are kept: the user content and the credentials.
+const defaultRedactPaths = "s,v,password,hash,client_secret,access_token,token"
+
+// parseConfig parses the flags of the subcommand name, which every
+// subcommand that runs the service or its stores shares.
+func parseConfig(name string, args []string) (config, error) {
+	var cfg config
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(&cfg.Profile.Name, "profile", os.Getenv(profileEnv), "profile to set flags from, such as dev, staging or prod, layered over base.yaml and under local.yaml in -profile-dir (defaults to $"+profileEnv+")")
+	fs.StringVar(&cfg.Profile.Dir, "profile-dir", "config", "directory of the profile files")
+	fs.StringVar(&cfg.HTTPAddr, "http-addr", ":9090", "HTTP listen address")
+	fs.StringVar(&cfg.HTTPAddrFile, "http-addr-file", "", "file to write the HTTP listen address to once listening, such as to find the port chosen for -http-addr 127.0.0.1:0")
+	fs.Var(&cfg.TrustedProxies, "trusted-proxies", "comma-separated addresses and CIDR ranges of the proxies in front of the service, whose X-Forwarded-For gives the caller's address (callers are identified by the connection's address when empty)")
+	fs.StringVar(&cfg.TLS.CertFile, "tls-cert", "", "PEM certificate chain to serve HTTPS and HTTP/2 with (plain HTTP when empty)")
+	fs.StringVar(&cfg.TLS.KeyFile, "tls-key", "", "PEM private key of -tls-cert")
+	fs.BoolVar(&cfg.HTTP2.H2C, "h2c", false, "also serve HTTP/2 in cleartext, for internal clients, when serving without TLS")
+	var maxStreams uint
+	fs.UintVar(&maxStreams, "http2-max-concurrent-streams", 250, "streams each HTTP/2 client may have open at once")
+	fs.DurationVar(&cfg.HTTP2.IdleTimeout, "http2-idle-timeout", 0, "close HTTP/2 connections idle for this long (never when zero)")
+	fs.BoolVar(&cfg.Upgrade.Enabled, "graceful-upgrades", false, "on SIGUSR2, start this binary again on the same listening socket and drain this process once it is ready; SIGTERM drains too")
+	fs.StringVar(&cfg.Upgrade.PIDFile, "pid-file", "", "file to write the PID of the serving process to with -graceful-upgrades, kept current across upgrades")
+	fs.DurationVar(&cfg.Upgrade.Timeout, "upgrade-timeout", time.Minute, "how long a new process has to become ready before a graceful upgrade is abandoned")
+	fs.DurationVar(&cfg.Upgrade.DrainTimeout, "drain-timeout", 30*time.Second, "how long requests in flight have to finish when the process stops serving with -graceful-upgrades")
+	fixedTime := fs.String("fixed-time", "", "RFC 3339 time to stop the service's clock at, for tests: times, IDs, link, token, job and cache expiry and rate limits all use it")
+	fs.BoolVar(&cfg.StrictJSON, "strict-json", false, "reject JSON bodies with unknown fields or trailing data")
+	fs.BoolVar(&cfg.CanonicalCBOR, "cbor-canonical", false, "encode CBOR responses deterministically (RFC 8949 core deterministic encoding), for clients that sign or hash them")
+	fs.Int64Var(&cfg.UploadMaxBytes, "upload-max-bytes", 32<<20, "largest file POST /process/file accepts, in bytes")
+	fs.DurationVar(&cfg.IdempotencyTTL, "idempotency-ttl", 24*time.Hour, "how long responses to requests with an Idempotency-Key are kept for replay, in Redis with -storage redis and in this process's memory otherwise")
+	fs.Int64Var(&cfg.IdempotencyMaxBytes, "idempotency-max-bytes", 1<<20, "largest body of a request with an Idempotency-Key, and of a response kept for replay, in bytes")
+	fs.StringVar(&cfg.Log.Backend, "log-backend", "kit", "logging backend: kit, zap or slog")
+	fs.StringVar(&cfg.Log.Format, "log-format", "logfmt", "log format: logfmt or json")
+	fs.StringVar(&cfg.Log.Level, "log-level", "info", "minimum log level: debug, info, warn or error")
+	fs.StringVar(&cfg.Metrics.Kind, "metrics-sink", "prometheus", "where to send metrics: prometheus, statsd, dogstatsd or otlp")
+	fs.StringVar(&cfg.Metrics.Addr, "statsd-addr", "127.0.0.1:8125", "StatsD/DogStatsD agent address")
+	fs.StringVar(&cfg.Metrics.Prefix, "statsd-prefix", "stringsvc.", "prefix for StatsD/DogStatsD metric names")
+	fs.DurationVar(&cfg.Metrics.Interval, "statsd-interval", 10*time.Second, "how often to flush StatsD/DogStatsD metrics")
+	fs.StringVar(&cfg.Metrics.OTLPEndpoint, "otlp-metrics-endpoint", "localhost:4318", "OTLP/HTTP collector address for the otlp metrics sink")
+	fs.DurationVar(&cfg.Metrics.OTLPInterval, "otlp-metrics-interval", defaultOTLPInterval, "how often to push OTLP metrics")
+	fs.DurationVar(&cfg.Metrics.SLO.Default, "slo-latency-default", 250*time.Millisecond, "latency objective for endpoints not listed in -slo-latency")
+	fs.Var(&cfg.Metrics.SLO, "slo-latency", "per-endpoint latency objectives, e.g. uppercase=100ms,count=50ms")
+	fs.StringVar(&cfg.Tracing.Exporter, "trace-exporter", "", "export spans to: otlp, zipkin or jaeger (off when empty)")
+	fs.StringVar(&cfg.Tracing.Endpoint, "trace-endpoint", "", "trace collector address (defaults to the exporter's local port)")
+	fs.StringVar(&cfg.Tracing.ServiceName, "trace-service-name", "stringsvc", "service name attached to spans")
+	fs.Float64Var(&cfg.Tracing.SampleRatio, "trace-sample-ratio", 1, "fraction of new traces to sample")
+	fs.DurationVar(&cfg.SlowRequest, "slow-request-threshold", time.Second, "log a warning for calls slower than this (0 disables)")
+	fs.BoolVar(&cfg.Chaos.Enabled, "chaos", false, "inject the faults set at /admin/chaos into calls, for chaos experiments (needs -auth-clients, for the operators who set them, or -chaos-file)")
+	fs.BoolVar(&cfg.Maintenance, "maintenance", false, "start in maintenance mode, refusing calls with 503 until it is turned off at /admin/maintenance (needs -auth-clients, for the operators who turn it off)")
+	fs.StringVar(&cfg.Chaos.File, "chaos-file", "", "JSON file of faults to inject from the start; implies -chaos")
+	fs.StringVar(&cfg.Features.File, "features-file", "", "JSON file of feature flags, shown at /admin/flags to tokens with the admin_flags scope")
+	fs.BoolVar(&cfg.Features.Env, "features-env", false, "read feature flags from "+envFeaturePrefix+"<NAME> environment variables set to on, off or a percentage such as 25%")
+	fs.StringVar(&cfg.Features.URL, "features-url", "", "URL serving a JSON array of feature flags")
+	fs.DurationVar(&cfg.Features.Refresh, "features-refresh", 30*time.Second, "how often feature flags are read again")
+	fs.Var(&cfg.Canary.Percents, "canary", "percentage of each endpoint's callers routed to its canary, e.g. count=5 (canaries are off when empty)")
+	fs.StringVar(&cfg.Canary.Header, "canary-header", "X-Canary", "request header that routes a call to the canary when on, or away from it when off")
+	fs.StringVar(&cfg.Experiments, "experiments-file", "", "YAML or JSON file of A/B experiments whose variants callers are assigned, shown at /admin/experiments to tokens with the admin_experiments scope")
+	fs.StringVar(&cfg.PolicyFile, "policy-file", "", "YAML or JSON file of per-endpoint timeouts, rate limits, body sizes, cache TTLs and auth requirements, read again on SIGHUP")
+	fs.StringVar(&cfg.Tenancy.File, "tenants-file", "", "YAML or JSON file of the tenants served; every request is resolved to one (multi-tenancy is off when empty)")
+	fs.StringVar(&cfg.Tenancy.Header, "tenant-header", "X-Tenant-ID", "header naming a request's tenant, which must be its bearer token's")
+	fs.StringVar(&cfg.Tenancy.Domain, "tenant-domain", "", "domain whose subdomains name tenants, such as strings.internal for payments.strings.internal")
+	fs.StringVar(&cfg.Tenancy.Default, "tenant-default", "", "tenant of requests without a bearer token's tenant (they are refused when empty)")
+	fs.BoolVar(&cfg.PayloadLog, "payload-log", false, "log request and response bodies at debug level")
+	fs.StringVar(&cfg.PayloadLogRedact, "payload-log-redact", defaultRedactPaths, "comma-separated JSON paths to mask in logged bodies, * matching any key or element")
+	fs.StringVar(&cfg.Record.File, "record-file", "", "append every call served to this file, sanitized, for the replay subcommand (off when empty)")
+	fs.StringVar(&cfg.Shadow.URL, "shadow-url", "", "base URL of a candidate to mirror calls to, comparing its responses with the service's (off when empty)")
+	fs.Float64Var(&cfg.Shadow.Percent, "shadow-percent", 100, "percentage of calls to mirror to -shadow-url")
+	fs.IntVar(&cfg.Shadow.Workers, "shadow-workers", 4, "number of calls mirrored to -shadow-url at once")
+	fs.DurationVar(&cfg.Shadow.Timeout, "shadow-timeout", 10*time.Second, "timeout of each call mirrored to -shadow-url")
+	fs.StringVar(&cfg.Shadow.Paths, "shadow-paths", defaultShadowPaths, "comma-separated paths of the calls to mirror to -shadow-url; list only operations without side effects, as the candidate runs them again")
+	fs.IntVar(&cfg.Shadow.MaxBytes, "shadow-max-bytes", 64*1024, "largest request or response body of a call mirrored to -shadow-url; larger calls aren't mirrored")
+	fs.StringVar(&cfg.Shadow.Ignore, "shadow-ignore", defaultVolatilePaths, "comma-separated JSON paths of response values that differ from call to call, which aren't compared")
+	fs.StringVar(&cfg.Record.Redact, "record-redact", defaultRedactPaths, "comma-separated JSON paths to mask in recorded bodies and query strings; when empty, nothing is masked and bodies other than JSON are recorded too")
+	fs.IntVar(&cfg.PayloadLogMaxBytes, "payload-log-max-bytes", 4096, "truncate logged bodies after this many bytes")
+	fs.StringVar(&cfg.PostgresDSN, "postgres-dsn", "", "PostgreSQL connection string; enables persistence")
+	fs.StringVar(&cfg.SQLitePath, "sqlite-path", "", "SQLite database file; enables persistence without a database server (instead of -postgres-dsn)")
+	fs.BoolVar(&cfg.MigrateOnStart, "migrate-on-start", true, "apply pending database migrations at startup (otherwise run the migrate subcommand first)")
+	fs.StringVar(&cfg.Storage.Backend, "storage", "", "where short links, history, jobs, issued tokens, API keys, tenant overrides and idempotent responses are kept: memory, sql or redis (defaults to sql with a database, memory otherwise; idempotent responses are in memory with sql)")
+	fs.StringVar(&cfg.Storage.RedisAddr, "storage-redis-addr", "localhost:6379", "Redis address for -storage redis")
+	fs.StringVar(&cfg.Storage.RedisPrefix, "storage-redis-prefix", "stringsvc:", "prefix for the keys kept in Redis with -storage redis")
+	fs.BoolVar(&cfg.History, "history", false, "record a summary of every call and serve it at GET /history")
+	fs.StringVar(&cfg.Counters.Path, "counters-path", "", "bbolt file to keep call totals by operation and identity in, served at GET /counters (off when empty)")
+	fs.DurationVar(&cfg.Counters.FlushInterval, "counters-flush-interval", 10*time.Second, "how often call counts are written to the counters file")
+	fs.BoolVar(&cfg.Usage, "usage-metering", false, "roll up calls, bytes and compute time per caller by month, served at GET /usage and /admin/usage and as billing reports at /admin/usage/export (needs -postgres-dsn or -sqlite-path)")
+	fs.DurationVar(&cfg.UsageFlush, "usage-flush-interval", 30*time.Second, "how often usage is written to the database")
+	fs.StringVar(&cfg.Billing.PushURL, "billing-push-url", "", "s3://bucket/prefix or gs://bucket/prefix to push monthly usage reports per tenant and key to (off when empty; needs -usage-metering)")
+	fs.DurationVar(&cfg.Billing.PushInterval, "billing-push-interval", 6*time.Hour, "how often usage reports are pushed to -billing-push-url")
+	fs.StringVar(&cfg.Billing.Format, "billing-format", "csv", "format of the pushed usage reports: csv or json")
+	kafkaBrokers := fs.String("kafka-brokers", "", "comma-separated Kafka brokers to publish an event per call to (off when empty)")
+	fs.StringVar(&cfg.Kafka.Topic, "kafka-topic", "stringsvc.events", "Kafka topic for call events")
+	fs.IntVar(&cfg.Kafka.BatchSize, "kafka-batch-size", 100, "maximum events per Kafka produce request")
+	fs.DurationVar(&cfg.Kafka.BatchTimeout, "kafka-batch-timeout", time.Second, "longest an event waits for its Kafka batch to fill")
+	fs.IntVar(&cfg.Jobs.Workers, "job-workers", 4, "number of asynchronous jobs run at once")
+	fs.IntVar(&cfg.Jobs.QueueSize, "job-queue-size", 100, "number of asynchronous jobs that may wait to run before new ones are rejected")
+	fs.IntVar(&cfg.Bulk.Concurrency, "bulk-concurrency", 8, "number of operations of a POST /bulk batch run at once")
+	fs.IntVar(&cfg.Bulk.MaxLineBytes, "bulk-max-line-bytes", 1<<20, "longest operation line POST /bulk accepts, in bytes")
+	fs.Int64Var(&cfg.Bulk.MaxBytes, "bulk-max-bytes", 32<<20, "largest POST /bulk body read over HTTP/1.x, in bytes")
+	fs.DurationVar(&cfg.Jobs.Retention, "job-retention", time.Hour, "how long finished asynchronous jobs can be fetched")
+	fs.StringVar(&cfg.Jobs.Webhooks.Secret, "webhook-secret", "", "HMAC key for signing job completion callbacks (callbacks are refused when empty)")
+	fs.IntVar(&cfg.Jobs.Webhooks.MaxAttempts, "webhook-max-attempts", 5, "attempts to deliver a job completion callback")
+	fs.DurationVar(&cfg.Jobs.Webhooks.Backoff, "webhook-backoff", time.Second, "delay before the first retry of a failed callback, doubled on each retry")
+	fs.DurationVar(&cfg.Jobs.Webhooks.Timeout, "webhook-timeout", 10*time.Second, "timeout for each callback attempt")
+	fs.Var(&cfg.Jobs.Webhooks.Allow, "webhook-allow", "comma-separated addresses and CIDR ranges that callbacks may reach although they are private, loopback or link-local")
+	fs.StringVar(&cfg.Crypto.Algorithm, "password-hash", "argon2id", "password hashing algorithm: argon2id or bcrypt")
+	fs.IntVar(&cfg.Crypto.BcryptCost, "bcrypt-cost", 12, "bcrypt cost factor for new password hashes")
+	argon2Time := fs.Uint("argon2-time", 1, "argon2id passes over memory for new password hashes")
+	argon2Memory := fs.Uint("argon2-memory", 64*1024, "argon2id memory in KiB for new password hashes")
+	argon2Threads := fs.Uint("argon2-threads", 4, "argon2id parallelism for new password hashes (at most 255)")
+	fs.Float64Var(&cfg.Crypto.RateLimit, "password-rate-limit", 20, "password hash and verify calls allowed per second (0 for no limit)")
+	fs.IntVar(&cfg.Crypto.RateBurst, "password-rate-burst", 40, "password hash and verify calls allowed in a burst")
+	fs.StringVar(&cfg.Auth.ClientsFile, "auth-clients", "", "JSON file of clients that may get tokens from POST /auth/token (token issuing is off when empty)")
+	fs.BoolVar(&cfg.Auth.Keys, "auth-keys", false, "manage API keys, further clients that may get tokens, at /admin/keys, keeping them in the -storage backend (needs -auth-clients for the operators who manage them)")
+	authPublic := fs.String("auth-public", "resolve", "comma-separated operations callers may make without a bearer token once -auth-clients is set; every other one needs a token with its scope")
+	fs.DurationVar(&cfg.Auth.DefaultTTL, "auth-token-ttl", time.Hour, "lifetime of issued tokens when the client doesn't ask for one")
+	fs.DurationVar(&cfg.Auth.MaxTTL, "auth-max-token-ttl", 24*time.Hour, "longest lifetime a client may ask for its token")
+	fs.StringVar(&cfg.RateLimit.RedisAddr, "rate-limit-redis-addr", "", "keep rate limits in Redis at this address so they hold across replicas (per process when empty)")
+	fs.StringVar(&cfg.RateLimit.RedisPrefix, "rate-limit-redis-prefix", "stringsvc:ratelimit:", "prefix for the rate limit keys kept in Redis")
+	fs.StringVar(&cfg.RateLimit.Headers, "rate-limit-headers", "x", "how responses report the rate limits applied to them: x (X-RateLimit-*), ietf (the draft RateLimit-* headers) or off")
+	fs.DurationVar(&cfg.Shortener.DefaultTTL, "shortener-default-ttl", 0, "lifetime of short links created without a ttl (0 for no expiry)")
+	fs.DurationVar(&cfg.Shortener.MaxTTL, "shortener-max-ttl", 0, "longest lifetime a short link may have (0 for no limit)")
+	translateProviders := fs.String("translate-providers", "", "comma-separated translation providers to try in order: deepl, google or libretranslate (translation is off when empty)")
+	fs.StringVar(&cfg.Translate.DeepLKey, "deepl-key", "", "DeepL API key")
+	fs.StringVar(&cfg.Translate.GoogleKey, "google-translate-key", "", "Google Cloud Translation API key")
+	fs.StringVar(&cfg.Translate.LibreURL, "libretranslate-url", "", "LibreTranslate server URL")
+	fs.StringVar(&cfg.Translate.LibreKey, "libretranslate-key", "", "LibreTranslate API key, for servers that require one")
+	fs.DurationVar(&cfg.Translate.Timeout, "translate-timeout", 10*time.Second, "timeout for each call to a translation provider")
+	fs.Var(&cfg.Translate.RateLimits, "translate-rate-limits", "calls per second allowed to each translation provider, e.g. deepl=5,google=10 (unlimited when not listed)")
+	breakerFailures := fs.Uint("translate-breaker-failures", 5, "consecutive failures after which a translation provider is skipped")
+	fs.DurationVar(&cfg.Translate.BreakerCooldown, "translate-breaker-cooldown", 30*time.Second, "how long a failing translation provider is skipped before being tried again")
+	fs.DurationVar(&cfg.Translate.CacheTTL, "translate-cache-ttl", time.Hour, "how long translations are cached (0 disables caching)")
+	fs.IntVar(&cfg.Translate.CacheSize, "translate-cache-size", 10000, "number of translations cached")
+	fs.StringVar(&cfg.Spell.Dictionary, "spell-dictionary", "", "word list or hunspell .dic file for /spellcheck (spell checking is off when empty)")
+	fs.DurationVar(&cfg.Spell.ReloadInterval, "spell-reload-interval", time.Minute, "how often the dictionary file is checked for changes and reloaded (0 disables)")
+	fs.StringVar(&cfg.LLM.URL, "llm-url", "", "OpenAI-compatible API base URL, e.g. https://api.openai.com/v1 (/summarize and /rewrite are off when empty)")
+	fs.StringVar(&cfg.LLM.Key, "llm-key", "", "API key for -llm-url")
+	fs.StringVar(&cfg.LLM.Model, "llm-model", "gpt-4o-mini", "model used for /summarize and /rewrite")
+	fs.DurationVar(&cfg.LLM.Timeout, "llm-timeout", time.Minute, "timeout for each model call, streamed or not")
+	fs.IntVar(&cfg.LLM.MaxInputTokens, "llm-max-input-tokens", 8000, "refuse texts estimated at more tokens than this")
+	fs.IntVar(&cfg.LLM.MaxOutputTokens, "llm-max-output-tokens", 1000, "the most tokens a request may generate, and the default")
+	fs.StringVar(&cfg.LLM.Prompts, "llm-prompts", "", "JSON file of prompt templates for summarize and rewrite (built-in prompts when empty)")
+	fs.IntVar(&cfg.Worker.MaxDeliver, "worker-max-deliver", 5, "attempts before a failing request is given up on")
+	fs.DurationVar(&cfg.Worker.Backoff, "worker-backoff", time.Second, "delay before the first retry of a failed request, doubled on each retry")
+	fs.StringVar(&cfg.Worker.NATS.URL, "worker-nats-url", "", "run as a worker consuming requests from NATS JetStream at this URL instead of serving HTTP")
+	fs.StringVar(&cfg.Worker.NATS.Stream, "worker-stream", "STRINGSVC", "JetStream stream to consume requests from")
+	fs.StringVar(&cfg.Worker.NATS.Subject, "worker-subject", "stringsvc.requests", "subject prefix; requests for a method arrive on <prefix>.<method>")
+	fs.StringVar(&cfg.Worker.NATS.Durable, "worker-durable", "stringsvc-worker", "durable consumer name shared by all workers")
+	fs.StringVar(&cfg.Worker.NATS.DeadLetterSubject, "worker-nats-dead-letter-subject", "", "JetStream subject for requests given up on (dropped when empty); must be in a stream and outside -worker-subject")
+	fs.StringVar(&cfg.Worker.SQS.QueueURL, "worker-sqs-queue-url", "", "run as a worker consuming requests from this SQS queue instead of serving HTTP")
+	fs.DurationVar(&cfg.Worker.SQS.WaitTime, "worker-sqs-wait-time", 20*time.Second, "how long each SQS receive waits for messages (at most 20s)")
+	fs.IntVar(&cfg.Worker.SQS.MaxMessages, "worker-sqs-max-messages", 10, "messages received and handled at once from SQS (at most 10)")
+	fs.DurationVar(&cfg.Worker.SQS.VisibilityTimeout, "worker-sqs-visibility-timeout", 30*time.Second, "SQS visibility timeout, renewed while a message is being handled")
+	fs.StringVar(&cfg.Worker.SQS.DeadLetterQueueURL, "worker-sqs-dead-letter-queue-url", "", "SQS queue for requests given up on (dropped when empty)")
+	fs.StringVar(&cfg.Worker.PubSub.Project, "worker-pubsub-project", "", "Google Cloud project of the Pub/Sub subscription")
+	fs.StringVar(&cfg.Worker.PubSub.Subscription, "worker-pubsub-subscription", "", "run as a worker consuming requests from this Pub/Sub subscription instead of serving HTTP")
+	fs.DurationVar(&cfg.Worker.PubSub.AckDeadline, "worker-pubsub-ack-deadline", 30*time.Second, "Pub/Sub ack deadline, renewed while a message is being handled")
+	fs.IntVar(&cfg.Worker.PubSub.MaxOutstanding, "worker-pubsub-max-outstanding", 100, "Pub/Sub messages handled at once")
+	fs.StringVar(&cfg.Worker.PubSub.DeadLetterTopic, "worker-pubsub-dead-letter-topic", "", "Pub/Sub topic for requests given up on (dropped when empty)")
+	fs.StringVar(&cfg.Worker.PubSub.DeadLetterSubscription, "worker-pubsub-dead-letter-subscription", "", "subscription to the dead-letter topic, for listing and redriving")
+	fs.StringVar(&cfg.Worker.Redis.Addr, "worker-redis-addr", "", "run as a worker consuming requests from a Redis stream at this address instead of serving HTTP")
+	fs.StringVar(&cfg.Worker.Redis.Stream, "worker-redis-stream", "stringsvc:requests", "Redis stream to read requests from")
+	fs.StringVar(&cfg.Worker.Redis.Group, "worker-redis-group", "stringsvc", "Redis consumer group shared by all workers")
+	fs.StringVar(&cfg.Worker.Redis.Consumer, "worker-redis-consumer", "", "name of this worker in the Redis consumer group (defaults to the hostname)")
+	fs.StringVar(&cfg.Worker.Redis.ResponseStream, "worker-redis-response-stream", "stringsvc:responses", "Redis stream to add responses to (none when empty)")
+	fs.Int64Var(&cfg.Worker.Redis.ResponseMaxLen, "worker-redis-response-maxlen", 100000, "approximate number of entries kept in the Redis response stream")
+	fs.DurationVar(&cfg.Worker.Redis.ClaimIdle, "worker-redis-claim-idle", time.Minute, "claim entries another worker has left pending for this long")
+	fs.IntVar(&cfg.Worker.Redis.Count, "worker-redis-count", 10, "Redis stream entries read and handled at once")
+	fs.StringVar(&cfg.Worker.Redis.DeadLetterStream, "worker-redis-dead-letter-stream", "", "Redis stream for requests given up on (dropped when empty)")
+	fs.StringVar(&cfg.KafkaEncoding, "kafka-encoding", "json", "call event encoding: json, or avro or protobuf registered with -schema-registry-url")
+	fs.StringVar(&cfg.SchemaRegistryURL, "schema-registry-url", "", "Confluent-compatible schema registry for avro and protobuf events (credentials may be in the URL)")
+	fs.StringVar((*string)(&cfg.SchemaSubject), "schema-subject-strategy", string(events.TopicNameStrategy), "schema registry subject naming: topic, record or topic_record")
+	fs.DurationVar(&cfg.OutboxInterval, "outbox-poll-interval", time.Second, "how often the event outbox is checked for events to send to Kafka (with -postgres-dsn)")
+	fs.StringVar(&cfg.AuditLog, "audit-log", "", "append an audit record of every call to this file (\"-\" for stdout)")
+	fs.IntVar(&cfg.AuditMaxSizeMB, "audit-max-size", 100, "rotate the audit log after this many megabytes")
+	fs.IntVar(&cfg.AuditMaxBackups, "audit-max-backups", 0, "number of rotated audit logs to keep (0 keeps all)")
+	fs.BoolVar(&cfg.AuditDB, "audit-db", false, "also keep audit records in the database, served to tokens with the admin_audit scope at GET /admin/audit and /admin/audit/export (needs -postgres-dsn or -sqlite-path, and -auth-clients to serve them)")
+	fs.DurationVar(&cfg.AuditRetention, "audit-retention", 0, "delete audit records older than this from the database and rotated audit logs (0 keeps them)")
+	fs.StringVar(&cfg.SentryDSN, "sentry-dsn", "", "report unexpected errors to this Sentry DSN")
+	fs.StringVar(&cfg.SentryEnvironment, "sentry-environment", "", "environment name attached to reported errors")
+	fs.Float64Var(&cfg.SentrySampleRate, "sentry-sample-rate", 1, "fraction of unexpected errors to report")
+	if err := fs.Parse(args); err != nil {
+		return config{}, err
+	}
+	settings, err := applyProfile(fs, cfg.Profile)
+	if err != nil {
+		return config{}, fmt.Errorf("profile: %v", err)
+	}
+	cfg.Profile.Settings = settings
+	if cfg.PostgresDSN != "" && cfg.SQLitePath != "" {
+		return config{}, errors.New("-postgres-dsn and -sqlite-path can't be used together")
+	}
+	if cfg.AuditDB && cfg.PostgresDSN == "" && cfg.SQLitePath == "" {
+		return config{}, errors.New("-audit-db needs -postgres-dsn or -sqlite-path")
+	}
+	switch cfg.Storage.Backend {
+	case "", "memory", "sql", "redis":
+	default:
+		return config{}, fmt.Errorf("-storage must be memory, sql or redis, not %q", cfg.Storage.Backend)
+	}
+	if cfg.Storage.Backend == "sql" && cfg.PostgresDSN == "" && cfg.SQLitePath == "" {
+		return config{}, errors.New("-storage sql needs -postgres-dsn or -sqlite-path")
+	}
+	if *fixedTime != "" {
+		t, err := time.Parse(time.RFC3339, *fixedTime)
+		if err != nil {
+			return config{}, fmt.Errorf("-fixed-time: %v", err)
+		}
+		cfg.FixedTime = t
+	}
+	if (cfg.TLS.CertFile == "") != (cfg.TLS.KeyFile == "") {
+		return config{}, errors.New("-tls-cert and -tls-key go together")
+	}
+	cfg.HTTP2.MaxConcurrentStreams = uint32(maxStreams)
+	cfg.Metrics.ServiceName = cfg.Tracing.ServiceName
+	if cfg.Maintenance && cfg.Auth.ClientsFile == "" {
+		return config{}, errors.New("-maintenance needs -auth-clients to turn it off at /admin/maintenance")
+	}
+	if cfg.Chaos.Enabled && cfg.Chaos.File == "" && cfg.Auth.ClientsFile == "" {
+		return config{}, errors.New("-chaos needs -auth-clients to set faults at /admin/chaos, or -chaos-file")
+	}
+	cfg.Chaos.Enabled = cfg.Chaos.Enabled || cfg.Chaos.File != ""
+	if *kafkaBrokers != "" {
+		cfg.Kafka.Brokers = strings.Split(*kafkaBrokers, ",")
+	}
+	if *authPublic != "" {
+		cfg.Auth.Public = strings.Split(*authPublic, ",")
+	}
+	if *translateProviders != "" {
+		cfg.Translate.Providers = strings.Split(*translateProviders, ",")
+	}
+	cfg.Translate.BreakerFailures = uint32(*breakerFailures)
+	cfg.Crypto.Argon2 = argon2Params{Time: uint32(*argon2Time), Memory: uint32(*argon2Memory), Threads: uint8(*argon2Threads)}
+	return cfg, nil
+}
@@ -0,0 +1,336 @@
+package stringsvc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mcclayac/gokit/logging"
+)
+
+// checkConfig looks for everything wrong with cfg that would otherwise
+// surface one failure at a time, at startup or on the first request:
+// malformed addresses and URLs, missing or malformed files, directories
+// that can't be written to and settings that need others. It reads the
+// files it names, but connects to nothing. The service has no service
+// discovery settings, so there are none to check.
+func checkConfig(cfg config) []configProblem {
+	var c configChecker
+	if _, err := logging.New(io.Discard, cfg.Log); err != nil {
+		c.addf("log-backend", "%v; see -log-backend, -log-format and -log-level", err)
+	}
+
+	c.addr("http-addr", cfg.HTTPAddr, false)
+	c.dir("http-addr-file", cfg.HTTPAddrFile)
+	c.dir("pid-file", cfg.Upgrade.PIDFile)
+	if c.file("tls-cert", cfg.TLS.CertFile) && c.file("tls-key", cfg.TLS.KeyFile) {
+		if cert, err := loadCertificate(cfg.TLS); err != nil {
+			c.addf("tls-cert", "%v; -tls-cert must be a PEM certificate chain and -tls-key its PEM private key", err)
+		} else if time.Now().After(cert.NotAfter) {
+			c.addf("tls-cert", "%s expired at %s; renew it", cfg.TLS.CertFile, cert.NotAfter.Format(time.RFC3339))
+		}
+	}
+	if cfg.HTTP2.H2C && cfg.TLS.CertFile != "" {
+		c.addf("h2c", "serves cleartext HTTP/2, but -tls-cert serves HTTP/2 over TLS instead; leave -h2c off")
+	}
+	if cfg.HTTP2.MaxConcurrentStreams == 0 {
+		c.addf("http2-max-concurrent-streams", "0 lets clients open no streams; use a positive number, such as 250")
+	}
+	if cfg.UploadMaxBytes <= 0 {
+		c.addf("upload-max-bytes", "%d refuses every upload; use a positive size, such as 33554432", cfg.UploadMaxBytes)
+	}
+	if cfg.IdempotencyMaxBytes <= 0 {
+		c.addf("idempotency-max-bytes", "%d refuses every request with an Idempotency-Key; use a positive size, such as 1048576", cfg.IdempotencyMaxBytes)
+	}
+	if cfg.Metrics.Kind == "statsd" || cfg.Metrics.Kind == "dogstatsd" {
+		c.addr("statsd-addr", cfg.Metrics.Addr, true)
+		c.interval("statsd-interval", cfg.Metrics.Interval, 10*time.Second)
+	}
+	switch cfg.Metrics.Kind {
+	case "", "prometheus", "statsd", "dogstatsd", "otlp":
+	default:
+		c.addf("metrics-sink", "unknown sink %q; use prometheus, statsd, dogstatsd or otlp", cfg.Metrics.Kind)
+	}
+	switch cfg.Tracing.Exporter {
+	case "", "otlp", "zipkin", "jaeger":
+	default:
+		c.addf("trace-exporter", "unknown exporter %q; use otlp, zipkin or jaeger, or leave it empty to turn tracing off", cfg.Tracing.Exporter)
+	}
+	if cfg.Tracing.SampleRatio < 0 || cfg.Tracing.SampleRatio > 1 {
+		c.addf("trace-sample-ratio", "%v is not a fraction between 0 and 1", cfg.Tracing.SampleRatio)
+	}
+
+	if c.file("chaos-file", cfg.Chaos.File) {
+		if _, err := loadChaosFaults(cfg.Chaos.File); err != nil {
+			c.add("chaos-file", err)
+		}
+	}
+	if c.file("features-file", cfg.Features.File) {
+		if _, err := fileFeatures(cfg.Features.File).Flags(context.Background()); err != nil {
+			c.add("features-file", err)
+		}
+	}
+	c.url("features-url", cfg.Features.URL)
+	if cfg.Features.enabled() {
+		c.interval("features-refresh", cfg.Features.Refresh, 30*time.Second)
+	}
+	for method := range cfg.Canary.Percents {
+		if _, ok := knownCanaries[method]; !ok {
+			c.addf("canary", "%s has no canary", method)
+		}
+	}
+	if c.file("experiments-file", cfg.Experiments) {
+		if _, err := loadExperiments(cfg.Experiments); err != nil {
+			c.add("experiments-file", err)
+		}
+	}
+	if c.file("policy-file", cfg.PolicyFile) {
+		if _, err := loadPolicyFile(cfg.PolicyFile); err != nil {
+			c.add("policy-file", err)
+		}
+	}
+	if c.file("tenants-file", cfg.Tenancy.File) {
+		if _, err := loadTenants(cfg.Tenancy); err != nil {
+			c.add("tenants-file", err)
+		}
+	} else if cfg.Tenancy.File == "" && (cfg.Tenancy.Default != "" || cfg.Tenancy.Domain != "") {
+		c.addf("tenants-file", "-tenant-default and -tenant-domain need the tenants listed in -tenants-file")
+	}
+	c.dir("record-file", cfg.Record.File)
+	if cfg.Bulk.Concurrency < 1 {
+		c.addf("bulk-concurrency", "%d runs nothing; use at least 1", cfg.Bulk.Concurrency)
+	}
+	if cfg.Bulk.MaxLineBytes < 1 {
+		c.addf("bulk-max-line-bytes", "%d accepts no operations; use a positive size", cfg.Bulk.MaxLineBytes)
+	}
+	if cfg.Shadow.URL != "" {
+		c.url("shadow-url", cfg.Shadow.URL)
+		if cfg.Shadow.Percent <= 0 || cfg.Shadow.Percent > 100 {
+			c.addf("shadow-percent", "%v is not a percentage above 0 and at most 100", cfg.Shadow.Percent)
+		}
+		if cfg.Shadow.Workers < 1 {
+			c.addf("shadow-workers", "%d mirrors nothing; use at least 1", cfg.Shadow.Workers)
+		}
+		if cfg.Shadow.MaxBytes < 1 {
+			c.addf("shadow-max-bytes", "%d mirrors nothing; use a positive size", cfg.Shadow.MaxBytes)
+		}
+		for _, path := range strings.Split(cfg.Shadow.Paths, ",") {
+			if path = strings.TrimSpace(path); path != "" && !strings.HasPrefix(path, "/") {
+				c.addf("shadow-paths", "%q is not a path; paths start with /", path)
+			}
+		}
+	}
+
+	c.dir("sqlite-path", cfg.SQLitePath)
+	c.dir("counters-path", cfg.Counters.Path)
+	if cfg.Counters.Path != "" {
+		c.interval("counters-flush-interval", cfg.Counters.FlushInterval, 10*time.Second)
+	}
+	if cfg.Usage {
+		c.interval("usage-flush-interval", cfg.UsageFlush, 30*time.Second)
+	}
+	if cfg.Storage.Backend == "redis" {
+		c.addr("storage-redis-addr", cfg.Storage.RedisAddr, true)
+	}
+	if cfg.Usage && cfg.PostgresDSN == "" && cfg.SQLitePath == "" {
+		c.addf("usage-metering", "needs -postgres-dsn or -sqlite-path to keep usage in")
+	}
+	if cfg.Billing.PushURL != "" {
+		switch u, err := url.Parse(cfg.Billing.PushURL); {
+		case err != nil || (u.Scheme != "s3" && u.Scheme != "gs") || u.Host == "":
+			c.addf("billing-push-url", "%q is not an s3:// or gs:// URL naming a bucket", cfg.Billing.PushURL)
+		case !cfg.Usage:
+			c.addf("billing-push-url", "needs -usage-metering to report usage from")
+		}
+		if cfg.Billing.PushInterval <= 0 {
+			c.addf("billing-push-interval", "%s never pushes; use a positive interval, such as 6h", cfg.Billing.PushInterval)
+		}
+	}
+	if _, ok := billingFormats[cfg.Billing.Format]; !ok {
+		c.addf("billing-format", "unknown format %q; use csv or json", cfg.Billing.Format)
+	}
+	for _, b := range cfg.Kafka.Brokers {
+		c.addr("kafka-brokers", b, true)
+	}
+	if len(cfg.Kafka.Brokers) > 0 && cfg.PostgresDSN != "" {
+		c.interval("outbox-poll-interval", cfg.OutboxInterval, time.Second)
+	}
+	c.url("schema-registry-url", cfg.SchemaRegistryURL)
+	switch {
+	case cfg.KafkaEncoding != "json" && cfg.KafkaEncoding != "avro" && cfg.KafkaEncoding != "protobuf":
+		c.addf("kafka-encoding", "unknown encoding %q; use json, avro or protobuf", cfg.KafkaEncoding)
+	case cfg.KafkaEncoding != "json" && cfg.SchemaRegistryURL == "":
+		c.addf("kafka-encoding", "%s events need -schema-registry-url", cfg.KafkaEncoding)
+	}
+
+	if cfg.Auth.Keys && cfg.Auth.ClientsFile == "" {
+		c.addf("auth-keys", "needs -auth-clients for the operators who manage the keys to get tokens")
+	}
+	if c.file("auth-clients", cfg.Auth.ClientsFile) {
+		if _, err := loadAuthClients(cfg.Auth.ClientsFile); err != nil {
+			c.add("auth-clients", err)
+		}
+	}
+	if h := cfg.RateLimit.Headers; h != "x" && h != "ietf" && h != "off" {
+		c.addf("rate-limit-headers", "unknown style %q; use x, ietf or off", h)
+	}
+	if cfg.RateLimit.RedisAddr != "" {
+		c.addr("rate-limit-redis-addr", cfg.RateLimit.RedisAddr, true)
+	}
+	for _, p := range cfg.Translate.Providers {
+		switch {
+		case p == "deepl" && cfg.Translate.DeepLKey == "":
+			c.addf("translate-providers", "deepl needs -deepl-key")
+		case p == "google" && cfg.Translate.GoogleKey == "":
+			c.addf("translate-providers", "google needs -google-translate-key")
+		case p == "libretranslate" && cfg.Translate.LibreURL == "":
+			c.addf("translate-providers", "libretranslate needs -libretranslate-url")
+		case p != "deepl" && p != "google" && p != "libretranslate":
+			c.addf("translate-providers", "unknown provider %q; use deepl, google or libretranslate", p)
+		}
+	}
+	c.url("libretranslate-url", cfg.Translate.LibreURL)
+	if c.file("spell-dictionary", cfg.Spell.Dictionary) {
+		if _, err := loadDictionary(cfg.Spell.Dictionary); err != nil {
+			c.add("spell-dictionary", err)
+		}
+	}
+	c.url("llm-url", cfg.LLM.URL)
+	if c.file("llm-prompts", cfg.LLM.Prompts) {
+		if _, err := newWritingService(cfg.LLM, nil); err != nil {
+			c.add("llm-prompts", err)
+		}
+	}
+
+	c.url("worker-nats-url", cfg.Worker.NATS.URL)
+	if cfg.Worker.Redis.Addr != "" {
+		c.addr("worker-redis-addr", cfg.Worker.Redis.Addr, true)
+	}
+	c.url("worker-sqs-queue-url", cfg.Worker.SQS.QueueURL)
+	// SQS counts the timeout in whole seconds, and renews it every half.
+	if cfg.Worker.SQS.QueueURL != "" && cfg.Worker.SQS.VisibilityTimeout < time.Second {
+		c.addf("worker-sqs-visibility-timeout", "%s is under a second, which SQS can't keep a message hidden for; use one such as 30s", cfg.Worker.SQS.VisibilityTimeout)
+	}
+	if cfg.AuditLog != "-" {
+		c.dir("audit-log", cfg.AuditLog)
+	}
+	if cfg.SentrySampleRate < 0 || cfg.SentrySampleRate > 1 {
+		c.addf("sentry-sample-rate", "%v is not a fraction between 0 and 1", cfg.SentrySampleRate)
+	}
+	return c.problems
+}
+
+// configProblem is something wrong with a flag's value, with what to do
+// about it.
+type configProblem struct {
+	Flag    string
+	Message string
+}
+
+func (p configProblem) String() string { return "-" + p.Flag + ": " + p.Message }
+
+// configChecker collects the problems of checkConfig.
+type configChecker struct {
+	problems []configProblem
+}
+
+func (c *configChecker) add(flag string, err error) {
+	c.problems = append(c.problems, configProblem{flag, err.Error()})
+}
+
+func (c *configChecker) addf(flag, format string, args ...interface{}) {
+	c.problems = append(c.problems, configProblem{flag, fmt.Sprintf(format, args...)})
+}
+
+// addr checks a host:port address. The host may be empty, to listen on
+// every interface, unless needHost.
+func (c *configChecker) addr(flag, addr string, needHost bool) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		c.addf(flag, "%q is not a host:port address, such as localhost:9090", addr)
+		return
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		c.addf(flag, "%q has port %q; use a number from 0 to 65535", addr, port)
+	}
+	if needHost && host == "" {
+		c.addf(flag, "%q has no host to connect to", addr)
+	}
+}
+
+// interval checks that an interval something is done at is positive, as
+// time.NewTicker panics otherwise; example is one to suggest.
+func (c *configChecker) interval(flag string, d, example time.Duration) {
+	if d <= 0 {
+		c.addf(flag, "%s is not a positive interval; use one such as %s", d, example)
+	}
+}
+
+// url checks a URL, if set.
+func (c *configChecker) url(flag, s string) {
+	if s == "" {
+		return
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		c.addf(flag, "%v", err)
+		return
+	}
+	if u.Scheme == "" || u.Host == "" {
+		c.addf(flag, "%q is not an absolute URL; it needs a scheme and a host, such as https://example.com", s)
+	}
+}
+
+// file checks that a file, if set, can be read, and reports whether it is
+// set and can be.
+func (c *configChecker) file(flag, path string) bool {
+	if path == "" {
+		return false
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			c.addf(flag, "%s doesn't exist; create it, or leave -%s empty", path, flag)
+		} else {
+			c.add(flag, err)
+		}
+		return false
+	}
+	f.Close()
+	return true
+}
+
+// dir checks that the directory of a file to be written, if set, exists
+// and can be written to.
+func (c *configChecker) dir(flag, path string) {
+	if path == "" {
+		return
+	}
+	dir := filepath.Dir(path)
+	fi, err := os.Stat(dir)
+	switch {
+	case os.IsNotExist(err):
+		c.addf(flag, "directory %s of %s doesn't exist; create it first", dir, path)
+		return
+	case err != nil:
+		c.add(flag, err)
+		return
+	case !fi.IsDir():
+		c.addf(flag, "%s is not a directory", dir)
+		return
+	}
+	f, err := os.CreateTemp(dir, ".stringsvc-check-")
+	if err != nil {
+		c.addf(flag, "can't write to %s: %v", dir, strings.TrimPrefix(err.Error(), "open "))
+		return
+	}
+	f.Close()
+	os.Remove(f.Name())
+}
@@ -0,0 +1,77 @@
+package stringsvc
+
+import (
+	"time"
+
+	"github.com/mcclayac/gokit/svcerrors"
+)
+
+// The contract samples are a value of each request and response type,
+// which TestContract checks against the golden files in contract/ and
+// bench codecs measures the codecs with.
+
+// contractCase is a type checked against its golden files.
+type contractCase struct {
+	name  string
+	value interface{}
+	// new returns a pointer to decode the golden files into.
+	new func() interface{}
+}
+
+var contractTime = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+var contractCases = []contractCase{
+	{"uppercase_request", uppercaseRequest{S: "hello, world"}, func() interface{} { return new(uppercaseRequest) }},
+	{"uppercase_response", uppercaseResponse{V: "HELLO, WORLD"}, func() interface{} { return new(uppercaseResponse) }},
+	{"count_request", countRequest{S: "hello"}, func() interface{} { return new(countRequest) }},
+	{"count_response", countResponse{V: 5}, func() interface{} { return new(countResponse) }},
+	{"hostname_response", hostnameResponse{V: "stringsvc-0"}, func() interface{} { return new(hostnameResponse) }},
+	{"math_request", mathRequest{A: 1.5, B: 2.25}, func() interface{} { return new(mathRequest) }},
+	{"math_response", mathResponse{V: 3.75}, func() interface{} { return new(mathResponse) }},
+	{"now_request", nowRequest{TZ: "Europe/Paris"}, func() interface{} { return new(nowRequest) }},
+	{"format_request", formatRequest{TS: "2024-03-01T12:00:00Z", Layout: "Mon Jan 2 15:04", TZ: "UTC"}, func() interface{} { return new(formatRequest) }},
+	{"convert_request", convertRequest{TS: "2024-03-01T12:00:00Z", From: "UTC", To: "Asia/Tokyo"}, func() interface{} { return new(convertRequest) }},
+	{"time_response", timeResponse{V: "2024-03-01T21:00:00+09:00"}, func() interface{} { return new(timeResponse) }},
+	{"uuid_request", uuidRequest{Version: "v7", Count: 2}, func() interface{} { return new(uuidRequest) }},
+	{"ulid_request", ulidRequest{Count: 2, Monotonic: true}, func() interface{} { return new(ulidRequest) }},
+	{"ids_response", idsResponse{IDs: []string{"01HQ8Z4X2N0000000000000000", "01HQ8Z4X2N0000000000000001"}}, func() interface{} { return new(idsResponse) }},
+	{"hash_request", hashRequest{Password: "correct horse"}, func() interface{} { return new(hashRequest) }},
+	{"hash_response", hashResponse{Hash: "$argon2id$v=19$m=65536,t=1,p=4$c2FsdHNhbHQ$aGFzaGhhc2g"}, func() interface{} { return new(hashResponse) }},
+	{"verify_request", verifyRequest{Password: "correct horse", Hash: "$argon2id$v=19$m=65536,t=1,p=4$c2FsdHNhbHQ$aGFzaGhhc2g"}, func() interface{} { return new(verifyRequest) }},
+	{"verify_response", verifyResponse{V: true}, func() interface{} { return new(verifyResponse) }},
+	{"shorten_request", shortenRequest{URL: "https://example.com/a/long/path", TTL: 3600}, func() interface{} { return new(shortenRequest) }},
+	{"resolve_request", resolveRequest{Code: "abc123"}, func() interface{} { return new(resolveRequest) }},
+	{"link_response", linkResponse{Link: shortLink{
+		Code:    "abc123",
+		URL:     "https://example.com/a/long/path",
+		Created: contractTime,
+		Expires: contractTime.Add(time.Hour),
+		Hits:    3,
+	}}, func() interface{} { return new(linkResponse) }},
+	{"qrcode_request", qrcodeRequest{S: "https://example.com", Format: "svg", Size: 256, Level: "M"}, func() interface{} { return new(qrcodeRequest) }},
+	{"qrcode_response", qrcodeResponse{Image: qrImage{ContentType: "image/svg+xml", Data: []byte(`<svg xmlns="http://www.w3.org/2000/svg"/>`)}}, func() interface{} { return new(qrcodeResponse) }},
+	{"translate_request", translateRequest{Text: "hello", Source: "en", Target: "de"}, func() interface{} { return new(translateRequest) }},
+	{"translate_response", translateResponse{V: "hallo", DetectedSource: "en", Provider: "deepl"}, func() interface{} { return new(translateResponse) }},
+	{"spellcheck_request", spellcheckRequest{Text: "helo wrld", MaxSuggestions: 3}, func() interface{} { return new(spellcheckRequest) }},
+	{"spellcheck_response", spellcheckResponse{Misspellings: []misspelling{
+		{Word: "helo", Offset: 0, Suggestions: []string{"hello", "help"}},
+		{Word: "wrld", Offset: 5, Suggestions: []string{"world"}},
+	}}, func() interface{} { return new(spellcheckResponse) }},
+	{"detect_request", detectRequest{S: "bonjour tout le monde", Max: 2}, func() interface{} { return new(detectRequest) }},
+	{"detect_response", detectResponse{Languages: []languageGuess{
+		{Lang: "fr", Confidence: 0.93},
+		{Lang: "it", Confidence: 0.04},
+	}}, func() interface{} { return new(detectResponse) }},
+	{"summarize_request", summarizeRequest{Text: "A long text.", MaxTokens: 100}, func() interface{} { return new(summarizeRequest) }},
+	{"rewrite_request", rewriteRequest{Text: "hey, send it over", Style: "formal", MaxTokens: 200}, func() interface{} { return new(rewriteRequest) }},
+	{"writing_response", writingResponse{V: "Could you please send it?"}, func() interface{} { return new(writingResponse) }},
+	{"token_request", tokenRequest{ClientID: "reports", ClientSecret: "s3cret", Scopes: []string{"uppercase", "count"}, TTL: 900}, func() interface{} { return new(tokenRequest) }},
+	{"token_response", tokenResponse{AccessToken: "tok_abc", TokenType: "Bearer", ExpiresIn: 900, Scopes: []string{"uppercase", "count"}}, func() interface{} { return new(tokenResponse) }},
+	{"revoke_request", revokeRequest{Token: "tok_abc"}, func() interface{} { return new(revokeRequest) }},
+	{"revoke_response", revokeResponse{}, func() interface{} { return new(revokeResponse) }},
+	{"error_response", errorResponse{Err: &svcerrors.Error{
+		Code:    svcerrors.CodeInvalidArgument,
+		Message: "invalid request",
+		Fields:  []svcerrors.FieldViolation{{Field: "s", Rule: "required", Message: "s is required"}},
+	}}, func() interface{} { return new(errorResponse) }},
+}
@@ -0,0 +1,111 @@
+package stringsvc
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mcclayac/gokit/codec"
+)
+
+var update = flag.Bool("update", false, "rewrite the contract golden files from the current wire formats")
+
+// contractCodecs are the wire formats checked, by golden file extension.
+var contractCodecs = []struct {
+	ext   string
+	codec codec.Codec
+}{
+	{"json", codec.JSON},
+	{"msgpack", codec.MsgPack},
+	{"pb", codec.Protobuf},
+	{"xml", codec.XML},
+	{"cbor", codec.CBOR},
+}
+
+// TestContract guards the wire formats against unintended changes: a
+// renamed field or tag, or a changed protobuf field number, breaks every
+// client built against the old one without failing anything else. Each
+// request and response type has a sample value, and each wire format it
+// can be written in has a golden file of that sample in the contract
+// directory, named after the type and the format, such as
+// uppercase_request.json or link_response.pb. The test encodes the sample
+// and compares it with the golden file byte for byte, and decodes the
+// golden file and compares it with the sample.
+//
+//	go test -run TestContract .          # check
+//	go test -run TestContract . -update  # rewrite the golden files after a deliberate change
+//
+// Protobuf request files are the exception: the service only decodes
+// requests from protobuf, so -update can't write them. They are written by
+// hand from stringsvc.proto, which makes them a check of the decoders
+// against the schema too.
+func TestContract(t *testing.T) {
+	for _, cc := range contractCases {
+		for _, f := range contractCodecs {
+			t.Run(cc.name+"."+f.ext, func(t *testing.T) {
+				if err := checkContract("contract", cc, f.ext, f.codec, *update); err != nil {
+					t.Error(err)
+				}
+			})
+		}
+	}
+}
+
+// checkContract checks cc against its golden file for one wire format.
+// Formats that can neither encode nor decode the type have no file.
+func checkContract(dir string, cc contractCase, ext string, c codec.Codec, update bool) error {
+	encodes, decodes := true, true
+	if c == codec.Protobuf {
+		_, encodes = cc.value.(codec.ProtoMarshaler)
+		_, decodes = cc.new().(codec.ProtoUnmarshaler)
+	}
+	if !encodes && !decodes {
+		return nil
+	}
+	path := filepath.Join(dir, cc.name+"."+ext)
+	var enc bytes.Buffer
+	if encodes {
+		if err := c.Encode(&enc, cc.value); err != nil {
+			return fmt.Errorf("%s: encoding: %v", path, err)
+		}
+		if update {
+			return os.WriteFile(path, enc.Bytes(), 0644)
+		}
+	}
+	golden, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if encodes && !bytes.Equal(enc.Bytes(), golden) {
+		return fmt.Errorf("%s: encoding changed\n got: %s\nwant: %s", path, showWire(ext, enc.Bytes()), showWire(ext, golden))
+	}
+	if decodes {
+		v := cc.new()
+		if err := c.Decode(bytes.NewReader(golden), v); err != nil {
+			return fmt.Errorf("%s: decoding: %v", path, err)
+		}
+		// Values are compared in JSON, which is blind to differences that
+		// don't reach the wire, such as a time's location.
+		got, _ := json.Marshal(v)
+		want, _ := json.Marshal(cc.value)
+		if !bytes.Equal(got, want) {
+			return fmt.Errorf("%s: decoding changed\n got: %s\nwant: %s", path, got, want)
+		}
+	}
+	return nil
+}
+
+// showWire formats an encoding for a mismatch report: text formats as they
+// are, binary ones in hex.
+func showWire(ext string, b []byte) string {
+	switch ext {
+	case "json", "xml":
+		return string(bytes.TrimSpace(b))
+	}
+	return hex.EncodeToString(b)
+}
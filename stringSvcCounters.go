@@ -0,0 +1,245 @@
+package stringsvc
+
+import (
+	"context"
+	"encoding/binary"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	bolt "go.etcd.io/bbolt"
+)
+
+// countersConfig configures the persistent usage counters.
+type countersConfig struct {
+	Path          string        // bbolt file; the counters are off when empty
+	FlushInterval time.Duration // how often counts are written to the file
+}
+
+// Each bucket maps a name to its total, a big-endian uint64.
+var (
+	operationsBucket = []byte("operations")
+	identitiesBucket = []byte("identities")
+)
+
+// usageCounters counts calls by operation and by identity. Counts are kept
+// in memory and added to the totals in a bbolt file every flush interval,
+// so at most one interval's counts are lost if the process dies.
+type usageCounters struct {
+	db     *bolt.DB
+	logger log.Logger
+	done   chan struct{}
+	wg     sync.WaitGroup
+	// flushing is held by flush while it moves counts into the file, so
+	// that Totals sees each count exactly once.
+	flushing sync.RWMutex
+
+	mtx        sync.Mutex
+	operations map[string]uint64 // not yet flushed
+	identities map[string]uint64
+}
+
+func newUsageCounters(cfg countersConfig, logger log.Logger) (*usageCounters, error) {
+	db, err := bolt.Open(cfg.Path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, b := range [][]byte{operationsBucket, identitiesBucket} {
+			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	c := &usageCounters{
+		db:         db,
+		logger:     logger,
+		done:       make(chan struct{}),
+		operations: map[string]uint64{},
+		identities: map[string]uint64{},
+	}
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		t := time.NewTicker(cfg.FlushInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				c.flushAndLog()
+			case <-c.done:
+				return
+			}
+		}
+	}()
+	return c, nil
+}
+
+// Add counts a call to operation by identity.
+func (c *usageCounters) Add(operation, identity string) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.operations[operation]++
+	c.identities[identity]++
+}
+
+// flush adds the counts since the last flush to the totals in the file. If
+// writing fails, the counts are kept for the next flush.
+func (c *usageCounters) flush() error {
+	c.flushing.Lock()
+	defer c.flushing.Unlock()
+
+	c.mtx.Lock()
+	operations, identities := c.operations, c.identities
+	c.operations, c.identities = map[string]uint64{}, map[string]uint64{}
+	c.mtx.Unlock()
+	if len(operations) == 0 && len(identities) == 0 {
+		return nil
+	}
+	err := c.db.Update(func(tx *bolt.Tx) error {
+		if err := addCounts(tx.Bucket(operationsBucket), operations); err != nil {
+			return err
+		}
+		return addCounts(tx.Bucket(identitiesBucket), identities)
+	})
+	if err != nil {
+		c.mtx.Lock()
+		mergeCounts(c.operations, operations)
+		mergeCounts(c.identities, identities)
+		c.mtx.Unlock()
+	}
+	return err
+}
+
+func (c *usageCounters) flushAndLog() {
+	if err := c.flush(); err != nil {
+		level.Error(c.logger).Log("msg", "flushing usage counters", "err", err)
+	}
+}
+
+func addCounts(b *bolt.Bucket, counts map[string]uint64) error {
+	for name, n := range counts {
+		if err := b.Put([]byte(name), encodeCount(decodeCount(b.Get([]byte(name)))+n)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func mergeCounts(dst, src map[string]uint64) {
+	for name, n := range src {
+		dst[name] += n
+	}
+}
+
+func encodeCount(n uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, n)
+	return b
+}
+
+func decodeCount(b []byte) uint64 {
+	if len(b) != 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(b)
+}
+
+// Totals returns the totals by operation and by identity, including counts
+// not yet flushed. A non-empty operation or identity limits its map to that
+// name.
+func (c *usageCounters) Totals(operation, identity string) (operations, identities map[string]uint64, err error) {
+	c.flushing.RLock()
+	defer c.flushing.RUnlock()
+
+	operations, identities = map[string]uint64{}, map[string]uint64{}
+	err = c.db.View(func(tx *bolt.Tx) error {
+		readCounts(tx.Bucket(operationsBucket), operation, operations)
+		readCounts(tx.Bucket(identitiesBucket), identity, identities)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	addPending(operations, operation, c.operations)
+	addPending(identities, identity, c.identities)
+	return operations, identities, nil
+}
+
+// addPending adds the counts not yet in the file to totals.
+func addPending(totals map[string]uint64, only string, pending map[string]uint64) {
+	for name, n := range pending {
+		if only == "" || name == only {
+			totals[name] += n
+		}
+	}
+}
+
+func readCounts(b *bolt.Bucket, only string, into map[string]uint64) {
+	if only != "" {
+		if v := b.Get([]byte(only)); v != nil {
+			into[only] = decodeCount(v)
+		}
+		return
+	}
+	b.ForEach(func(k, v []byte) error {
+		into[string(k)] = decodeCount(v)
+		return nil
+	})
+}
+
+// Close stops the flush loop, flushes the remaining counts and closes the
+// file.
+func (c *usageCounters) Close() {
+	close(c.done)
+	c.wg.Wait()
+	c.flushAndLog()
+	c.db.Close()
+}
+
+// countingMiddleware counts every call, successful or not.
+func countingMiddleware(c *usageCounters, method string) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			c.Add(method, identity(ctx))
+			return next(ctx, request)
+		}
+	}
+}
+
+type countersRequest struct {
+	Operation string `json:"operation" validate:"max=64"`
+	Identity  string `json:"identity" validate:"max=256"`
+}
+
+type countersResponse struct {
+	Operations map[string]uint64 `json:"operations"`
+	Identities map[string]uint64 `json:"identities"`
+}
+
+func makeCountersEndpoint(c *usageCounters) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(countersRequest)
+		operations, identities, err := c.Totals(req.Operation, req.Identity)
+		if err != nil {
+			return nil, err
+		}
+		return countersResponse{operations, identities}, nil
+	}
+}
+
+// decodeCountersRequest reads the operation and identity query parameters.
+func decodeCountersRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	q := r.URL.Query()
+	return countersRequest{Operation: q.Get("operation"), Identity: q.Get("identity")}, nil
+}
@@ -0,0 +1,211 @@
+package stringsvc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-kit/kit/endpoint"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/mcclayac/gokit/svcerrors"
+)
+
+// CryptoService hashes and verifies passwords.
+type CryptoService interface {
+	// Hash returns a salted hash of password in the configured algorithm,
+	// encoded with its parameters: bcrypt's modular crypt format or
+	// argon2id's PHC string format.
+	Hash(ctx context.Context, password string) (string, error)
+	// Verify reports whether password matches hash, which may be in either
+	// algorithm whatever is configured.
+	Verify(ctx context.Context, password, hash string) (bool, error)
+}
+
+// cryptoConfig configures password hashing.
+type cryptoConfig struct {
+	Algorithm  string // bcrypt or argon2id
+	BcryptCost int
+	Argon2     argon2Params
+	RateLimit  float64 // hash and verify calls per second; unlimited when zero
+	RateBurst  int
+}
+
+// argon2Params are the argon2id cost parameters, as recommended by RFC 9106.
+type argon2Params struct {
+	Time    uint32
+	Memory  uint32 // in KiB
+	Threads uint8
+}
+
+const (
+	argon2SaltLen = 16
+	argon2KeyLen  = 32
+)
+
+// maxArgon2Memory bounds the memory, in KiB, Verify will spend on an
+// argon2id hash, whose parameters come from the caller.
+const maxArgon2Memory = 1 << 20
+
+// maxBcryptPassword is the longest password bcrypt can hash; it ignores the
+// rest, so longer ones are refused rather than silently truncated.
+const maxBcryptPassword = 72
+
+// cryptoService is a concrete implementation of CryptoService.
+type cryptoService struct {
+	cfg cryptoConfig
+}
+
+func newCryptoService(cfg cryptoConfig) (cryptoService, error) {
+	switch cfg.Algorithm {
+	case "bcrypt":
+		if cfg.BcryptCost < bcrypt.MinCost || cfg.BcryptCost > bcrypt.MaxCost {
+			return cryptoService{}, fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+	case "argon2id":
+		if cfg.Argon2.Time == 0 || cfg.Argon2.Memory == 0 || cfg.Argon2.Threads == 0 {
+			return cryptoService{}, errors.New("argon2id time, memory and threads must be positive")
+		}
+	default:
+		return cryptoService{}, fmt.Errorf("unknown password hashing algorithm %q", cfg.Algorithm)
+	}
+	return cryptoService{cfg}, nil
+}
+
+func (s cryptoService) Hash(ctx context.Context, password string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	if s.cfg.Algorithm == "bcrypt" {
+		if len(password) > maxBcryptPassword {
+			return "", svcerrors.Errorf(svcerrors.CodeInvalidArgument, "bcrypt passwords are limited to %d bytes", maxBcryptPassword)
+		}
+		b, err := bcrypt.GenerateFromPassword([]byte(password), s.cfg.BcryptCost)
+		return string(b), err
+	}
+	salt := make([]byte, argon2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	p := s.cfg.Argon2
+	key := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, argon2KeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, p.Memory, p.Time, p.Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (s cryptoService) Verify(ctx context.Context, password, hash string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	if strings.HasPrefix(hash, "$argon2id$") {
+		return verifyArgon2(password, hash)
+	}
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
+		return false, nil
+	default:
+		return false, svcerrors.Errorf(svcerrors.CodeHashInvalid, "not a bcrypt or argon2id hash: %v", err)
+	}
+}
+
+// verifyArgon2 checks password against an argon2id hash in PHC string
+// format, using the parameters recorded in it.
+func verifyArgon2(password, hash string) (bool, error) {
+	invalid := svcerrors.New(svcerrors.CodeHashInvalid, "malformed argon2id hash")
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return false, invalid
+	}
+	var (
+		version int
+		p       argon2Params
+	)
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, invalid
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Time, &p.Threads); err != nil || p.Time == 0 || p.Threads == 0 || p.Memory > maxArgon2Memory {
+		return false, invalid
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, invalid
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return false, invalid
+	}
+	got := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(got, key) == 1, nil
+}
+
+// For each method, we define request and response structs. Both requests
+// carry a password, so they are kept out of audit and event input hashes.
+type hashRequest struct {
+	Password string `json:"password" xml:"password" validate:"required,max=1024"`
+}
+
+func (hashRequest) secret() {}
+
+type hashResponse struct {
+	Hash string `json:"hash" xml:"hash"`
+	Err  error  `json:"-" xml:"-"`
+}
+
+// Failed implements endpoint.Failer.
+func (r hashResponse) Failed() error { return r.Err }
+
+type verifyRequest struct {
+	Password string `json:"password" xml:"password" validate:"required,max=1024"`
+	Hash     string `json:"hash" xml:"hash" validate:"required,max=1024"`
+}
+
+func (verifyRequest) secret() {}
+
+type verifyResponse struct {
+	V   bool  `json:"v" xml:"v"`
+	Err error `json:"-" xml:"-"`
+}
+
+// Failed implements endpoint.Failer.
+func (r verifyResponse) Failed() error { return r.Err }
+
+func makeHashEndpoint(svc CryptoService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(hashRequest)
+		v, err := svc.Hash(ctx, req.Password)
+		return hashResponse{v, err}, nil
+	}
+}
+
+func makeVerifyEndpoint(svc CryptoService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(verifyRequest)
+		v, err := svc.Verify(ctx, req.Password, req.Hash)
+		return verifyResponse{v, err}, nil
+	}
+}
+
+func decodeHashRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request hashRequest
+	if err := decodeBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+func decodeVerifyRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request verifyRequest
+	if err := decodeBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
@@ -0,0 +1,90 @@
+package stringsvc
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	_ "github.com/jackc/pgx/v5/stdlib" // registers the "pgx" database/sql driver
+
+	"github.com/mcclayac/gokit/logging"
+	"github.com/mcclayac/gokit/migrate"
+)
+
+// openDatabase connects to the PostgreSQL database at dsn and checks that
+// it is reachable.
+func openDatabase(ctx context.Context, dsn string) (*sql.DB, error) {
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// migrateDatabases applies pending migrations to whichever of the
+// PostgreSQL and SQLite databases is open, logging what it applied.
+func migrateDatabases(ctx context.Context, pg, lite *sql.DB, logger log.Logger) error {
+	for _, d := range []struct {
+		db      *sql.DB
+		dialect migrate.Dialect
+	}{{pg, migrate.Postgres}, {lite, migrate.SQLite}} {
+		if d.db == nil {
+			continue
+		}
+		applied, err := migrate.Up(ctx, d.db, d.dialect)
+		for _, v := range applied {
+			level.Info(logger).Log("msg", "applied migration", "database", d.dialect, "version", v)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runMigrate is the migrate subcommand: it opens the databases configured
+// by args, applies pending migrations and exits.
+func runMigrate(args []string) int {
+	cfg, err := parseConfig("stringsvc migrate", args)
+	if err != nil {
+		return 2
+	}
+	logger, err := logging.New(os.Stderr, cfg.Log)
+	if err != nil {
+		log.NewLogfmtLogger(os.Stderr).Log("err", err)
+		return 2
+	}
+	if cfg.PostgresDSN == "" && cfg.SQLitePath == "" {
+		level.Error(logger).Log("msg", "migrate needs -postgres-dsn or -sqlite-path")
+		return 2
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	var pg, lite *sql.DB
+	if cfg.PostgresDSN != "" {
+		if pg, err = openDatabase(ctx, cfg.PostgresDSN); err != nil {
+			level.Error(logger).Log("msg", "connecting to database", "err", err)
+			return 1
+		}
+		defer pg.Close()
+	}
+	if cfg.SQLitePath != "" {
+		if lite, err = openSQLite(ctx, cfg.SQLitePath); err != nil {
+			level.Error(logger).Log("msg", "opening SQLite database", "err", err)
+			return 1
+		}
+		defer lite.Close()
+	}
+	if err := migrateDatabases(ctx, pg, lite, logger); err != nil {
+		level.Error(logger).Log("msg", "migrating", "err", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,147 @@
+package stringsvc
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+
+	"github.com/mcclayac/gokit/svcerrors"
+)
+
+// Headers (or attributes, or fields, depending on the broker) added to a
+// message when it is dead-lettered. The message keeps its own headers and
+// body, so that it can be redriven as it was.
+const (
+	deadLetterReasonHeader   = "Dead-Letter-Reason"   // the error message
+	deadLetterCodeHeader     = "Dead-Letter-Code"     // the error code
+	deadLetterAttemptsHeader = "Dead-Letter-Attempts" // deliveries made
+	deadLetterSourceHeader   = "Dead-Letter-Source"   // subject, queue, topic or stream it came from
+	deadLetterTimeHeader     = "Dead-Letter-Time"     // RFC 3339
+)
+
+// deadLetter is a message a worker gave up on.
+type deadLetter struct {
+	ID       string            `json:"id" xml:"id"` // what to pass to Redrive
+	Source   string            `json:"source" xml:"source"`
+	Code     svcerrors.Code    `json:"code" xml:"code"`
+	Reason   string            `json:"reason" xml:"reason"`
+	Attempts int               `json:"attempts" xml:"attempts"`
+	Time     time.Time         `json:"time" xml:"time"`
+	Headers  map[string]string `json:"headers" xml:"-"`
+	Body     []byte            `json:"body" xml:"body"`
+}
+
+// deadLetterQueue is where a worker sends the messages it gives up on:
+// those that fail MaxDeliver times, and those it can never process.
+type deadLetterQueue interface {
+	// List returns up to limit dead letters.
+	List(ctx context.Context, limit int) ([]deadLetter, error)
+	// Redrive sends the dead letter with the given ID back to where it came
+	// from, without its dead-letter headers, and removes it from the queue.
+	Redrive(ctx context.Context, id string) error
+}
+
+// deadLetterHeaders returns the headers describing why a message from
+// source was dead-lettered after the given number of attempts.
+func deadLetterHeaders(source string, attempts int, err error) map[string]string {
+	return map[string]string{
+		deadLetterReasonHeader:   err.Error(),
+		deadLetterCodeHeader:     string(svcerrors.CodeOf(err)),
+		deadLetterAttemptsHeader: strconv.Itoa(attempts),
+		deadLetterSourceHeader:   source,
+		deadLetterTimeHeader:     time.Now().UTC().Format(time.RFC3339),
+	}
+}
+
+// newDeadLetter builds a deadLetter from a dead-lettered message's headers
+// and body.
+func newDeadLetter(id string, headers map[string]string, body []byte) deadLetter {
+	dl := deadLetter{ID: id, Headers: map[string]string{}, Body: body}
+	for k, v := range headers {
+		switch k {
+		case deadLetterReasonHeader:
+			dl.Reason = v
+		case deadLetterCodeHeader:
+			dl.Code = svcerrors.Code(v)
+		case deadLetterAttemptsHeader:
+			dl.Attempts, _ = strconv.Atoi(v)
+		case deadLetterSourceHeader:
+			dl.Source = v
+		case deadLetterTimeHeader:
+			dl.Time, _ = time.Parse(time.RFC3339, v)
+		default:
+			dl.Headers[k] = v
+		}
+	}
+	return dl
+}
+
+func isDeadLetterHeader(k string) bool {
+	return strings.HasPrefix(k, "Dead-Letter-")
+}
+
+type listDeadLettersRequest struct {
+	Limit int `json:"limit" validate:"min=1,max=1000"`
+}
+
+type listDeadLettersResponse struct {
+	DeadLetters []deadLetter `json:"dead_letters" xml:"dead_letters>dead_letter"`
+}
+
+type redriveDeadLetterRequest struct {
+	ID string `json:"id" validate:"required"`
+}
+
+type redriveDeadLetterResponse struct{}
+
+// StatusCode implements httptransport.StatusCoder.
+func (redriveDeadLetterResponse) StatusCode() int { return http.StatusAccepted }
+
+func makeListDeadLettersEndpoint(dlq deadLetterQueue) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(listDeadLettersRequest)
+		dls, err := dlq.List(ctx, req.Limit)
+		if err != nil {
+			return nil, err
+		}
+		return listDeadLettersResponse{dls}, nil
+	}
+}
+
+func makeRedriveDeadLetterEndpoint(dlq deadLetterQueue) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(redriveDeadLetterRequest)
+		if err := dlq.Redrive(ctx, req.ID); err != nil {
+			return nil, err
+		}
+		return redriveDeadLetterResponse{}, nil
+	}
+}
+
+// decodeListDeadLettersRequest reads the limit query parameter, which
+// defaults to 100.
+func decodeListDeadLettersRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	request := listDeadLettersRequest{Limit: 100}
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, svcerrors.Errorf(svcerrors.CodeInvalidArgument, "malformed limit %q", s)
+		}
+		request.Limit = n
+	}
+	return request, nil
+}
+
+// decodeRedriveDeadLetterRequest reads the ID from a path of the form
+// /admin/dlq/{id}/redrive.
+func decodeRedriveDeadLetterRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	rest := strings.TrimPrefix(r.URL.Path, "/admin/dlq/")
+	if !strings.HasSuffix(rest, "/redrive") {
+		return nil, svcerrors.Errorf(svcerrors.CodeNotFound, "no route %s", r.URL.Path)
+	}
+	return redriveDeadLetterRequest{ID: strings.TrimSuffix(rest, "/redrive")}, nil
+}
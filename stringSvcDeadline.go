@@ -0,0 +1,91 @@
+package stringsvc
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mcclayac/gokit/svcerrors"
+)
+
+// Headers a caller can use to pass its deadline on to the service. Either
+// an absolute RFC 3339 time, or a timeout in gRPC's wire format.
+const (
+	requestDeadlineHeader = "X-Request-Deadline"
+	grpcTimeoutHeader     = "Grpc-Timeout"
+)
+
+// deadlineMiddleware bounds the request context by the caller's deadline, so
+// endpoints and the service stop working on requests nobody is waiting for.
+// Requests whose deadline has already passed are rejected outright.
+func deadlineMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deadline, ok, err := requestDeadline(r)
+		if err != nil {
+			encodeError(r.Context(), err, w)
+			return
+		}
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !time.Now().Before(deadline) {
+			encodeError(r.Context(), svcerrors.New(svcerrors.CodeDeadlineExceeded, "request deadline already passed"), w)
+			return
+		}
+		ctx, cancel := context.WithDeadline(r.Context(), deadline)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requestDeadline returns the deadline set by the request headers, if any.
+// X-Request-Deadline takes precedence over Grpc-Timeout.
+func requestDeadline(r *http.Request) (time.Time, bool, error) {
+	if v := r.Header.Get(requestDeadlineHeader); v != "" {
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return time.Time{}, false, svcerrors.Errorf(svcerrors.CodeInvalidArgument, "invalid %s header %q: want an RFC 3339 time", requestDeadlineHeader, v)
+		}
+		return t, true, nil
+	}
+	if v := r.Header.Get(grpcTimeoutHeader); v != "" {
+		d, err := parseGRPCTimeout(v)
+		if err != nil {
+			return time.Time{}, false, svcerrors.Errorf(svcerrors.CodeInvalidArgument, "invalid %s header %q: %v", grpcTimeoutHeader, v, err)
+		}
+		return time.Now().Add(d), true, nil
+	}
+	return time.Time{}, false, nil
+}
+
+// parseGRPCTimeout parses a timeout as sent in the grpc-timeout header: at
+// most eight digits followed by one of the units H, M, S, m, u or n.
+func parseGRPCTimeout(v string) (time.Duration, error) {
+	if len(v) < 2 || len(v) > 9 {
+		return 0, strconv.ErrSyntax
+	}
+	n, err := strconv.ParseInt(v[:len(v)-1], 10, 64)
+	if err != nil || n < 0 {
+		return 0, strconv.ErrSyntax
+	}
+	var unit time.Duration
+	switch v[len(v)-1] {
+	case 'H':
+		unit = time.Hour
+	case 'M':
+		unit = time.Minute
+	case 'S':
+		unit = time.Second
+	case 'm':
+		unit = time.Millisecond
+	case 'u':
+		unit = time.Microsecond
+	case 'n':
+		unit = time.Nanosecond
+	default:
+		return 0, strconv.ErrSyntax
+	}
+	return time.Duration(n) * unit, nil
+}
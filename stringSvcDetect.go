@@ -0,0 +1,73 @@
+package stringsvc
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/go-kit/kit/endpoint"
+
+	"github.com/mcclayac/gokit/langdetect"
+)
+
+// DetectService identifies the language of text.
+type DetectService interface {
+	// Detect returns up to max guesses at the language of s, most likely
+	// first. It returns none when s has no letters.
+	Detect(ctx context.Context, s string, max int) ([]languageGuess, error)
+}
+
+// languageGuess is a language code and the confidence in it, from 0 to 1.
+type languageGuess struct {
+	Lang       string  `json:"lang" xml:"lang"`
+	Confidence float64 `json:"confidence" xml:"confidence"`
+}
+
+// detectService is a concrete implementation of DetectService.
+type detectService struct{}
+
+func (detectService) Detect(_ context.Context, s string, max int) ([]languageGuess, error) {
+	if s == "" {
+		return nil, ErrEmpty
+	}
+	guesses := []languageGuess{}
+	for _, g := range langdetect.Detect(s, max) {
+		guesses = append(guesses, languageGuess{g.Lang, g.Confidence})
+	}
+	return guesses, nil
+}
+
+// defaultGuesses is the number of languages returned when the request
+// doesn't say.
+const defaultGuesses = 3
+
+type detectRequest struct {
+	S   string `json:"s" xml:"s" validate:"required,max=65536"`
+	Max int    `json:"max" xml:"max" validate:"min=0,max=20"`
+}
+
+type detectResponse struct {
+	Languages []languageGuess `json:"languages" xml:"languages>language"`
+	Err       error           `json:"-" xml:"-"`
+}
+
+// Failed implements endpoint.Failer.
+func (r detectResponse) Failed() error { return r.Err }
+
+func makeDetectEndpoint(svc DetectService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(detectRequest)
+		if req.Max == 0 {
+			req.Max = defaultGuesses
+		}
+		v, err := svc.Detect(ctx, req.S, req.Max)
+		return detectResponse{v, err}, nil
+	}
+}
+
+func decodeDetectRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request detectRequest
+	if err := decodeBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
@@ -0,0 +1,92 @@
+package stringsvc
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/mcclayac/gokit/svcerrors"
+)
+
+// The deterministic endpoints, whose response depends on nothing but the
+// request, send a strong ETag computed from the request rather than the
+// response: the path, query, body and the headers that pick the format,
+// and the build's version, since a new build may answer differently. With
+// tenancy, the tag covers the tenant and its overrides too, as a tenant's
+// locale changes its casing, and tenantMiddleware has the responses vary
+// by the headers that pick the tenant. The
+// tag is known before the endpoint runs, so a GET or HEAD with a matching
+// If-None-Match is answered 304 Not Modified without doing the work again.
+// POST responses carry the tag too, but a POST is always run. /count is
+// not deterministic in this sense: the count_runes flag can change its
+// answer from one caller to the next.
+
+// etagMiddleware tags the successful responses of next, a deterministic
+// endpoint, and answers conditional GET and HEAD requests.
+func etagMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			encodeError(r.Context(), svcerrors.Errorf(svcerrors.CodeInvalidArgument, "reading request body: %v", err), w)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		tag := requestETag(r, body, tenantVariant(r.Context()))
+		w.Header().Add("Vary", "Accept")
+		if (r.Method == http.MethodGet || r.Method == http.MethodHead) && etagMatches(r.Header.Get("If-None-Match"), tag) {
+			w.Header().Set("ETag", tag)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		next.ServeHTTP(&etagWriter{ResponseWriter: w, tag: tag}, r)
+	})
+}
+
+// requestETag is the entity tag of the response to r, whose body is body,
+// for the tenant variant, as tenantVariant returns it.
+func requestETag(r *http.Request, body []byte, variant string) string {
+	h := sha256.New()
+	for _, s := range []string{version, r.URL.Path, r.URL.RawQuery, r.Header.Get("Accept"), r.Header.Get("Content-Type"), variant} {
+		h.Write([]byte(s + "\n"))
+	}
+	h.Write(body)
+	return `"` + base64.RawURLEncoding.EncodeToString(h.Sum(nil)[:18]) + `"`
+}
+
+// etagMatches reports whether an If-None-Match header matches tag, by the
+// weak comparison RFC 9110 prescribes for it.
+func etagMatches(ifNoneMatch, tag string) bool {
+	for _, t := range strings.Split(ifNoneMatch, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || strings.TrimPrefix(t, "W/") == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// etagWriter sets the ETag header on 2xx responses, leaving errors
+// untagged.
+type etagWriter struct {
+	http.ResponseWriter
+	tag         string
+	wroteHeader bool
+}
+
+func (w *etagWriter) WriteHeader(code int) {
+	if !w.wroteHeader && code >= 200 && code < 300 {
+		w.Header().Set("ETag", w.tag)
+	}
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *etagWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
@@ -0,0 +1,40 @@
+package stringsvc
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestETagTenant(t *testing.T) {
+	h := etagMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"v":"HELLO"}`))
+	}))
+	call := func(ctx context.Context, ifNoneMatch string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/uppercase?s=hello", nil).WithContext(ctx)
+		if ifNoneMatch != "" {
+			r.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	payments := context.WithValue(context.Background(), tenantKey{}, tenant{ID: "payments"})
+	turkish := context.WithValue(payments, tenantOverridesKey{}, tenantOverrides{Locale: "tr"})
+
+	tag := call(payments, "").Header().Get("ETag")
+	if tag == "" {
+		t.Fatal("no ETag")
+	}
+	if w := call(payments, tag); w.Code != http.StatusNotModified {
+		t.Errorf("same tenant: got %d, want 304", w.Code)
+	}
+	if w := call(turkish, tag); w.Code != http.StatusOK {
+		t.Errorf("after a locale change: got %d, want 200", w.Code)
+	}
+	search := context.WithValue(context.Background(), tenantKey{}, tenant{ID: "search"})
+	if w := call(search, tag); w.Code != http.StatusOK {
+		t.Errorf("another tenant: got %d, want 200", w.Code)
+	}
+}
@@ -0,0 +1,120 @@
+package stringsvc
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	httptransport "github.com/go-kit/kit/transport/http"
+
+	"github.com/mcclayac/gokit/svcerrors"
+)
+
+// errorReporter sends unexpected failures (panics and INTERNAL errors) to an
+// error tracker. Expected failures such as validation errors are not reported.
+type errorReporter interface {
+	Report(ctx context.Context, err error)
+	Flush(timeout time.Duration)
+}
+
+// nopReporter is the reporter of an appEnv until error tracking is
+// configured.
+type nopReporter struct{}
+
+func (nopReporter) Report(context.Context, error) {}
+func (nopReporter) Flush(time.Duration)           {}
+
+// reportUnexpected passes err to the reporter of ctx's appEnv if it has no
+// more specific code than INTERNAL.
+func reportUnexpected(ctx context.Context, err error) {
+	if svcerrors.CodeOf(err) == svcerrors.CodeInternal {
+		appEnvFromContext(ctx).reporter.Report(ctx, err)
+	}
+}
+
+// panicError is reported for recovered panics. It keeps the stack of the
+// panicking goroutine.
+type panicError struct {
+	value interface{}
+	stack []byte
+}
+
+func (e panicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.value)
+}
+
+// recoveryMiddleware turns a panic in next into a 500 INTERNAL response,
+// logging and reporting it with its stack trace.
+func recoveryMiddleware(logger log.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer func() {
+				p := recover()
+				if p == nil {
+					return
+				}
+				if p == http.ErrAbortHandler {
+					panic(p) // net/http aborts the response without logging
+				}
+				err := panicError{p, debug.Stack()}
+				ctx := httptransport.PopulateRequestContext(r.Context(), r)
+				level.Error(logger).Log("msg", "recovered from panic", "err", err, "path", r.URL.Path, "stack", string(err.stack))
+				appEnvFromContext(ctx).reporter.Report(ctx, err)
+				encodeError(ctx, svcerrors.ErrInternal, w)
+			}()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// sentryReporter reports to Sentry, or any error tracker that accepts the
+// Sentry protocol.
+type sentryReporter struct {
+	hub *sentry.Hub
+}
+
+func newSentryReporter(dsn, environment string, sampleRate float64) (*sentryReporter, error) {
+	client, err := sentry.NewClient(sentry.ClientOptions{
+		Dsn:              dsn,
+		Release:          version,
+		Environment:      environment,
+		SampleRate:       sampleRate,
+		AttachStacktrace: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &sentryReporter{sentry.NewHub(client, sentry.NewScope())}, nil
+}
+
+// Report sends err tagged with the request details the HTTP transport puts
+// in the context.
+func (r *sentryReporter) Report(ctx context.Context, err error) {
+	hub := r.hub.Clone()
+	hub.WithScope(func(scope *sentry.Scope) {
+		for tag, key := range map[string]interface{}{
+			"http.method": httptransport.ContextKeyRequestMethod,
+			"http.path":   httptransport.ContextKeyRequestPath,
+			"request_id":  httptransport.ContextKeyRequestXRequestID,
+			"user_agent":  httptransport.ContextKeyRequestUserAgent,
+		} {
+			if v, ok := ctx.Value(key).(string); ok && v != "" {
+				scope.SetTag(tag, v)
+			}
+		}
+		if pe, ok := err.(panicError); ok {
+			scope.SetContext("panic", sentry.Context{"stack": string(pe.stack)})
+			scope.SetLevel(sentry.LevelFatal)
+		}
+		hub.CaptureException(err)
+	})
+}
+
+func (r *sentryReporter) Flush(timeout time.Duration) {
+	r.hub.Flush(timeout)
+}
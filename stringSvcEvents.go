@@ -0,0 +1,77 @@
+package stringsvc
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+
+	"github.com/mcclayac/gokit/events"
+	"github.com/mcclayac/gokit/kit"
+)
+
+// eventsMiddleware publishes an event for every completed call. The result
+// size is the length of the response encoded as JSON. Events are published
+// after the call returns and never change its outcome.
+func eventsMiddleware(pub events.Publisher, logger log.Logger, method string) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+			defer func(begin time.Time) {
+				var size int
+				if response != nil {
+					b, _ := json.Marshal(response)
+					size = len(b)
+				}
+				if perr := pub.Publish(ctx, events.Event{
+					ID:         newEventID(),
+					Operation:  method,
+					InputHash:  inputHash(request),
+					ResultSize: size,
+					Status:     kit.OutcomeCode(response, err),
+					Identity:   identity(ctx),
+					RequestID:  requestID(ctx),
+					Time:       begin.UTC(),
+				}); perr != nil {
+					level.Warn(logger).Log("msg", "publishing event", "method", method, "err", perr)
+				}
+			}(time.Now())
+			return next(ctx, request)
+		}
+	}
+}
+
+func newEventID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// newSchemaEncoder registers the event schema for cfg.KafkaEncoding with the
+// schema registry and returns the encoder that references it.
+func newSchemaEncoder(cfg config) (events.Encoder, error) {
+	var format events.SchemaFormat
+	switch cfg.KafkaEncoding {
+	case "avro":
+		format = events.Avro
+	case "protobuf":
+		format = events.Protobuf
+	default:
+		return nil, fmt.Errorf("unknown event encoding %q", cfg.KafkaEncoding)
+	}
+	if cfg.SchemaRegistryURL == "" {
+		return nil, fmt.Errorf("%s events need -schema-registry-url", cfg.KafkaEncoding)
+	}
+	subject, err := cfg.SchemaSubject.Subject(cfg.Kafka.Topic)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return events.NewSchemaEncoder(ctx, events.SchemaRegistry{URL: cfg.SchemaRegistryURL}, format, subject)
+}
@@ -0,0 +1,239 @@
+package stringsvc
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/metrics"
+
+	"github.com/mcclayac/gokit/kit"
+	"github.com/mcclayac/gokit/svcerrors"
+)
+
+// Experiments split callers between variants of the service's behavior,
+// for A/B tests. -experiments-file lists them, in YAML or JSON:
+//
+//	experiments:
+//	  - name: count_runes
+//	    salt: 2026-10
+//	    variants:
+//	      - {name: control, weight: 90}
+//	      - {name: runes, weight: 10}
+//
+// Each HTTP caller is assigned one variant of every experiment, by the hash
+// of the experiment's salt and their identity, the client a bearer token
+// was issued to or else their address; callers keep their variants for as
+// long as the salt and weights stay the same, and changing the salt
+// reshuffles them. Code consults the caller's variant with
+// assignedVariant; responses report all of them, as
+//
+//	X-Experiments: count_runes=runes, other=control
+//
+// and experiment_requests and experiment_request_duration count and time
+// calls by experiment, variant, method and code, to compare the variants.
+// An experiment nothing consults yet is an A/A test of the split. The
+// admin, token and health endpoints aren't assigned variants.
+
+// experimentNamePattern is what experiment and variant names look like,
+// so that they fit in the response header and metric labels.
+var experimentNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// experimentsFile is the experiments file's contents.
+type experimentsFile struct {
+	Experiments []experiment `json:"experiments"`
+}
+
+// experiment is an A/B test and its variants.
+type experiment struct {
+	Name     string              `json:"name" xml:"name"`
+	Salt     string              `json:"salt,omitempty" xml:"salt,omitempty"` // the name when empty
+	Variants []experimentVariant `json:"variants" xml:"variants>variant"`
+}
+
+// experimentVariant is a variant and its share of callers, relative to
+// the weights of the others.
+type experimentVariant struct {
+	Name   string `json:"name" xml:"name"`
+	Weight int    `json:"weight" xml:"weight"`
+}
+
+// assign returns the variant of the caller with identity.
+func (e experiment) assign(identity string) string {
+	total := 0
+	for _, v := range e.Variants {
+		total += v.Weight
+	}
+	salt := e.Salt
+	if salt == "" {
+		salt = e.Name
+	}
+	h := fnv.New32a()
+	h.Write([]byte(salt + "/" + identity))
+	n := int(h.Sum32() % uint32(total))
+	for _, v := range e.Variants {
+		if n < v.Weight {
+			return v.Name
+		}
+		n -= v.Weight
+	}
+	return e.Variants[len(e.Variants)-1].Name
+}
+
+// experimentSet is the experiments in force.
+type experimentSet struct {
+	experiments []experiment // by name
+	requests    metrics.Counter
+	latency     metrics.Histogram // seconds
+}
+
+// loadExperiments reads an experiments file.
+func loadExperiments(path string) ([]experiment, error) {
+	var f experimentsFile
+	if err := readSettingsFile(path, &f); err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	for _, e := range f.Experiments {
+		if !experimentNamePattern.MatchString(e.Name) {
+			return nil, fmt.Errorf("%s: experiment name %q must be lowercase letters, digits, hyphens and underscores", path, e.Name)
+		}
+		if seen[e.Name] {
+			return nil, fmt.Errorf("%s: experiment %q is listed twice", path, e.Name)
+		}
+		seen[e.Name] = true
+		if len(e.Variants) < 2 {
+			return nil, fmt.Errorf("%s: experiment %q needs at least two variants", path, e.Name)
+		}
+		names := map[string]bool{}
+		for _, v := range e.Variants {
+			switch {
+			case !experimentNamePattern.MatchString(v.Name):
+				return nil, fmt.Errorf("%s: experiment %q: variant name %q must be lowercase letters, digits, hyphens and underscores", path, e.Name, v.Name)
+			case names[v.Name]:
+				return nil, fmt.Errorf("%s: experiment %q: variant %q is listed twice", path, e.Name, v.Name)
+			case v.Weight <= 0:
+				return nil, fmt.Errorf("%s: experiment %q: variant %q needs a positive weight", path, e.Name, v.Name)
+			}
+			names[v.Name] = true
+		}
+	}
+	sort.Slice(f.Experiments, func(i, j int) bool { return f.Experiments[i].Name < f.Experiments[j].Name })
+	return f.Experiments, nil
+}
+
+func newExperimentSet(experiments []experiment, f metricsFactory) *experimentSet {
+	return &experimentSet{
+		experiments: experiments,
+		requests:    f.Counter("experiment_requests", "Number of requests, by experiment variant.", "experiment", "variant", "method", "code"),
+		latency: f.Histogram("experiment_request_duration", "Time spent processing requests, by experiment variant.",
+			defaultLatencyBuckets, "experiment", "variant", "method"),
+	}
+}
+
+// experimentAssignment is a caller's variant of an experiment.
+type experimentAssignment struct {
+	Experiment string `json:"experiment" xml:"experiment"`
+	Variant    string `json:"variant" xml:"variant"`
+}
+
+// Assign returns the variants of the caller with identity, by experiment.
+func (s *experimentSet) Assign(identity string) []experimentAssignment {
+	assigned := make([]experimentAssignment, 0, len(s.experiments))
+	for _, e := range s.experiments {
+		assigned = append(assigned, experimentAssignment{e.Name, e.assign(identity)})
+	}
+	return assigned
+}
+
+type experimentsKey struct{}
+
+// assignedVariant returns the variant of experiment assigned to the
+// caller of ctx, or "" if they weren't assigned one.
+func assignedVariant(ctx context.Context, experiment string) string {
+	assigned, _ := ctx.Value(experimentsKey{}).([]experimentAssignment)
+	for _, a := range assigned {
+		if a.Experiment == experiment {
+			return a.Variant
+		}
+	}
+	return ""
+}
+
+// experimentsMiddleware assigns each caller their variants, puts them in
+// the context and reports them in the X-Experiments header.
+func experimentsMiddleware(s *experimentSet) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if maintenanceExempt(r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			assigned := s.Assign(callerIdentity(r))
+			parts := make([]string, len(assigned))
+			for i, a := range assigned {
+				parts[i] = a.Experiment + "=" + a.Variant
+			}
+			if len(parts) > 0 {
+				w.Header().Set("X-Experiments", strings.Join(parts, ", "))
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), experimentsKey{}, assigned)))
+		})
+	}
+}
+
+// experimentMetricsMiddleware counts and times calls to the endpoint by
+// the variants of their callers.
+func experimentMetricsMiddleware(s *experimentSet, method string) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+			assigned, _ := ctx.Value(experimentsKey{}).([]experimentAssignment)
+			if len(assigned) == 0 {
+				return next(ctx, request)
+			}
+			defer func(begin time.Time) {
+				took, code := time.Since(begin).Seconds(), kit.OutcomeCode(response, err)
+				for _, a := range assigned {
+					s.requests.With("experiment", a.Experiment, "variant", a.Variant, "method", method, "code", code).Add(1)
+					s.latency.With("experiment", a.Experiment, "variant", a.Variant, "method", method).Observe(took)
+				}
+			}(time.Now())
+			return next(ctx, request)
+		}
+	}
+}
+
+// experimentsRequest asks for the experiments, with the variants of an
+// identity, the caller's when empty.
+type experimentsRequest struct {
+	Identity string `json:"identity,omitempty" xml:"identity,omitempty"`
+}
+
+type experimentsResponse struct {
+	Identity    string                 `json:"identity" xml:"identity"`
+	Experiments []experiment           `json:"experiments" xml:"experiments>experiment"`
+	Assigned    []experimentAssignment `json:"assigned" xml:"assigned>assignment"`
+}
+
+func makeExperimentsEndpoint(s *experimentSet) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		id := request.(experimentsRequest).Identity
+		if id == "" {
+			id = identity(ctx)
+		}
+		return experimentsResponse{Identity: id, Experiments: s.experiments, Assigned: s.Assign(id)}, nil
+	}
+}
+
+func decodeExperimentsRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	if r.Method != http.MethodGet {
+		return nil, svcerrors.Errorf(svcerrors.CodeInvalidArgument, "%s /admin/experiments: want GET", r.Method)
+	}
+	return experimentsRequest{Identity: r.URL.Query().Get("identity")}, nil
+}
@@ -0,0 +1,98 @@
+package stringsvc
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestExperimentAssign(t *testing.T) {
+	e := experiment{Name: "count_runes", Salt: "2026-10", Variants: []experimentVariant{{"control", 90}, {"runes", 10}}}
+	reshuffled := e
+	reshuffled.Salt = "2026-11"
+
+	runes, moved := 0, 0
+	for i := 0; i < 10000; i++ {
+		id := fmt.Sprintf("caller-%d", i)
+		v := e.assign(id)
+		if v == "runes" {
+			runes++
+		}
+		if again := e.assign(id); again != v {
+			t.Fatalf("%s: assigned %s, then %s", id, v, again)
+		}
+		if reshuffled.assign(id) != v {
+			moved++
+		}
+	}
+	if runes < 800 || runes > 1200 {
+		t.Errorf("assigned %d of 10000 callers to runes at weight 10 of 100, want about 1000", runes)
+	}
+	if moved < 1000 {
+		t.Errorf("a new salt moved %d of 10000 callers, want them reshuffled", moved)
+	}
+}
+
+func TestExperimentsMiddleware(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "experiments.yaml")
+	file := `
+experiments:
+  - name: count_runes
+    variants:
+      - {name: control, weight: 1}
+      - {name: runes, weight: 1}
+  - name: aa
+    variants:
+      - {name: a, weight: 1}
+      - {name: b, weight: 1}
+`
+	if err := os.WriteFile(path, []byte(file), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	experiments, err := loadExperiments(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mf, err := newMetricsFactory(metricsSink{}, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newExperimentSet(experiments, mf)
+	var variant string
+	h := experimentsMiddleware(s)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		variant = assignedVariant(r.Context(), "count_runes")
+	}))
+	call := func(path, remoteAddr string) string {
+		r := httptest.NewRequest("GET", path, nil)
+		r.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		variant = ""
+		h.ServeHTTP(w, r)
+		return w.Header().Get("X-Experiments")
+	}
+
+	seen := map[string]bool{}
+	for i := 1; i <= 50; i++ {
+		addr := fmt.Sprintf("192.0.2.%d:1234", i)
+		header := call("/count?s=a", addr)
+		want := fmt.Sprintf("aa=%s, count_runes=%s", s.experiments[0].assign(fmt.Sprintf("192.0.2.%d", i)), variant)
+		if header != want {
+			t.Fatalf("%s: got X-Experiments %q, want %q", addr, header, want)
+		}
+		if again := call("/uppercase?s=a", fmt.Sprintf("192.0.2.%d:5678", i)); again != header {
+			t.Errorf("%s: got X-Experiments %q, then %q", addr, header, again)
+		}
+		seen[variant] = true
+	}
+	if !seen["control"] || !seen["runes"] {
+		t.Errorf("50 callers were assigned only %v", seen)
+	}
+	if header := call("/healthz", "192.0.2.1:1234"); header != "" || variant != "" {
+		t.Errorf("/healthz: got X-Experiments %q, variant %q", header, variant)
+	}
+}
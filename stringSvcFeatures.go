@@ -0,0 +1,329 @@
+package stringsvc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	httptransport "github.com/go-kit/kit/transport/http"
+
+	"github.com/mcclayac/gokit/svcerrors"
+)
+
+// Feature flags switch behavior on at runtime, for everyone or for some
+// callers, without a restart. Flags come from providers, a JSON file, the
+// environment or a remote URL, which are read again every refresh period;
+// a provider that fails keeps its last flags. Later providers override the
+// flags of earlier ones, in the order file, environment, remote.
+
+// knownFeatures are the flags the service consults, with what they do.
+var knownFeatures = map[string]string{
+	"strict_json": "reject JSON bodies with unknown fields or trailing data, as -strict-json does for everyone; targeted by client address, as bodies are decoded before callers are authenticated",
+	"count_runes": "count characters rather than bytes",
+}
+
+// featuresConfig configures where feature flags come from.
+type featuresConfig struct {
+	File    string        // JSON array of flags
+	Env     bool          // read STRINGSVC_FEATURE_<NAME> variables
+	URL     string        // serves a JSON array of flags
+	Refresh time.Duration // how often the providers are read again
+}
+
+func (c featuresConfig) enabled() bool { return c.File != "" || c.Env || c.URL != "" }
+
+// featureFlag is a flag and who gets it. Identities in Off never get it and
+// those in On always do; anyone else gets it if it is Enabled, or else if
+// their identity hashes into the first Percent of 100 buckets.
+type featureFlag struct {
+	Name    string   `json:"name" xml:"name"`
+	Enabled bool     `json:"enabled" xml:"enabled"`
+	Percent int      `json:"percent,omitempty" xml:"percent,omitempty"`
+	On      []string `json:"on,omitempty" xml:"on>identity,omitempty"`
+	Off     []string `json:"off,omitempty" xml:"off>identity,omitempty"`
+}
+
+func (f featureFlag) evaluate(identity string) bool {
+	switch {
+	case contains(f.Off, identity):
+		return false
+	case contains(f.On, identity), f.Enabled:
+		return true
+	case f.Percent > 0:
+		h := fnv.New32a()
+		h.Write([]byte(f.Name + "/" + identity))
+		return int(h.Sum32()%100) < f.Percent
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// featureProvider reads feature flags from somewhere.
+type featureProvider interface {
+	Flags(ctx context.Context) ([]featureFlag, error)
+}
+
+// fileFeatures reads a JSON array of flags from a file.
+type fileFeatures string
+
+func (path fileFeatures) Flags(context.Context) ([]featureFlag, error) {
+	b, err := os.ReadFile(string(path))
+	if err != nil {
+		return nil, err
+	}
+	var flags []featureFlag
+	if err := json.Unmarshal(b, &flags); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return flags, nil
+}
+
+// envFeaturePrefix starts the environment variables envFeatures reads.
+const envFeaturePrefix = "STRINGSVC_FEATURE_"
+
+// envFeatures reads flags from variables such as
+// STRINGSVC_FEATURE_COUNT_RUNES, set to "on", "off" or a percentage such
+// as "25%".
+type envFeatures struct{}
+
+func (envFeatures) Flags(context.Context) ([]featureFlag, error) {
+	var flags []featureFlag
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, envFeaturePrefix) {
+			continue
+		}
+		kv = strings.TrimPrefix(kv, envFeaturePrefix)
+		i := strings.IndexByte(kv, '=')
+		f := featureFlag{Name: strings.ToLower(kv[:i])}
+		switch v := kv[i+1:]; {
+		case v == "on" || v == "true":
+			f.Enabled = true
+		case v == "off" || v == "false":
+		case strings.HasSuffix(v, "%"):
+			n, err := strconv.Atoi(strings.TrimSuffix(v, "%"))
+			if err != nil || n < 0 || n > 100 {
+				return nil, fmt.Errorf("%s%s: %q is not a percentage", envFeaturePrefix, kv[:i], v)
+			}
+			f.Percent = n
+		default:
+			return nil, fmt.Errorf("%s%s: want on, off or a percentage, not %q", envFeaturePrefix, kv[:i], v)
+		}
+		flags = append(flags, f)
+	}
+	return flags, nil
+}
+
+// remoteFeatures GETs a JSON array of flags from a URL.
+type remoteFeatures struct {
+	url    string
+	client *http.Client
+}
+
+func (p remoteFeatures) Flags(ctx context.Context) ([]featureFlag, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", p.url, resp.Status)
+	}
+	var flags []featureFlag
+	if err := json.NewDecoder(resp.Body).Decode(&flags); err != nil {
+		return nil, fmt.Errorf("%s: %v", p.url, err)
+	}
+	return flags, nil
+}
+
+// featureSet holds the flags read from its providers. A nil featureSet has
+// every flag off.
+type featureSet struct {
+	providers []featureProvider
+	logger    log.Logger
+
+	mtx   sync.RWMutex
+	last  [][]featureFlag // by provider
+	flags map[string]featureFlag
+}
+
+func newFeatureSet(cfg featuresConfig, logger log.Logger) *featureSet {
+	s := &featureSet{logger: logger}
+	if cfg.File != "" {
+		s.providers = append(s.providers, fileFeatures(cfg.File))
+	}
+	if cfg.Env {
+		s.providers = append(s.providers, envFeatures{})
+	}
+	if cfg.URL != "" {
+		s.providers = append(s.providers, remoteFeatures{cfg.URL, &http.Client{Timeout: 10 * time.Second}})
+	}
+	s.last = make([][]featureFlag, len(s.providers))
+	return s
+}
+
+// Refresh reads the providers again. It returns the first error, having
+// kept the last flags of the providers that failed.
+func (s *featureSet) Refresh(ctx context.Context) error {
+	var first error
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	flags := map[string]featureFlag{}
+	for i, p := range s.providers {
+		list, err := p.Flags(ctx)
+		if err != nil {
+			if first == nil {
+				first = err
+			}
+			list = s.last[i]
+		}
+		s.last[i] = list
+		for _, f := range list {
+			if _, ok := knownFeatures[f.Name]; !ok && err == nil {
+				level.Warn(s.logger).Log("msg", "unknown feature flag", "flag", f.Name)
+			}
+			flags[f.Name] = f
+		}
+	}
+	s.flags = flags
+	return first
+}
+
+// Run refreshes the flags every interval until ctx is done.
+func (s *featureSet) Run(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if err := s.Refresh(ctx); err != nil {
+				level.Warn(s.logger).Log("msg", "refreshing feature flags", "err", err)
+			}
+		}
+	}
+}
+
+// Enabled reports whether the caller of ctx gets the flag name. The
+// caller's tenant's setting, if it has one, wins.
+func (s *featureSet) Enabled(ctx context.Context, name string) bool {
+	if t, ok := tenantFromContext(ctx); ok {
+		if on, ok := t.Features[name]; ok {
+			return on
+		}
+	}
+	if s == nil {
+		return false
+	}
+	s.mtx.RLock()
+	f, ok := s.flags[name]
+	s.mtx.RUnlock()
+	return ok && f.evaluate(identity(ctx))
+}
+
+// Flags returns the flags, by name.
+func (s *featureSet) Flags() []featureFlag {
+	if s == nil {
+		return nil
+	}
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	flags := make([]featureFlag, 0, len(s.flags))
+	for _, f := range s.flags {
+		flags = append(flags, f)
+	}
+	sort.Slice(flags, func(i, j int) bool { return flags[i].Name < flags[j].Name })
+	return flags
+}
+
+// featureStringService switches the string service's behavior by flag.
+type featureStringService struct {
+	features *featureSet
+	StringService
+}
+
+func (s featureStringService) Count(ctx context.Context, str string) int {
+	if s.features.Enabled(ctx, "count_runes") {
+		return utf8.RuneCountInString(str)
+	}
+	return s.StringService.Count(ctx, str)
+}
+
+// featuresRequest asks for the flags as evaluated for an identity, the
+// caller's when empty.
+type featuresRequest struct {
+	Identity string `json:"identity,omitempty" xml:"identity,omitempty"`
+}
+
+type featuresResponse struct {
+	Identity string              `json:"identity" xml:"identity"`
+	Flags    []featureEvaluation `json:"flags" xml:"flags>flag"`
+}
+
+// featureEvaluation is a flag, whether the identity gets it and what the
+// service uses it for, empty for flags it doesn't consult.
+type featureEvaluation struct {
+	featureFlag
+	On          bool   `json:"on" xml:"on"`
+	Description string `json:"description,omitempty" xml:"description,omitempty"`
+}
+
+func makeFeaturesEndpoint(s *featureSet) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(featuresRequest)
+		id := req.Identity
+		if id == "" {
+			id = identity(ctx)
+		}
+		resp := featuresResponse{Identity: id, Flags: []featureEvaluation{}}
+		seen := map[string]bool{}
+		for _, f := range s.Flags() {
+			seen[f.Name] = true
+			resp.Flags = append(resp.Flags, featureEvaluation{f, f.evaluate(id), knownFeatures[f.Name]})
+		}
+		for name, desc := range knownFeatures {
+			if !seen[name] {
+				resp.Flags = append(resp.Flags, featureEvaluation{featureFlag{Name: name}, false, desc})
+			}
+		}
+		sort.Slice(resp.Flags, func(i, j int) bool { return resp.Flags[i].Name < resp.Flags[j].Name })
+		return resp, nil
+	}
+}
+
+func decodeFeaturesRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	if r.Method != http.MethodGet {
+		return nil, svcerrors.Errorf(svcerrors.CodeInvalidArgument, "%s /admin/flags: want GET", r.Method)
+	}
+	return featuresRequest{Identity: r.URL.Query().Get("identity")}, nil
+}
+
+// featureContext returns the context features are evaluated in while
+// decoding r, which knows the caller only by address.
+func featureContext(r *http.Request) context.Context {
+	return httptransport.PopulateRequestContext(r.Context(), r)
+}
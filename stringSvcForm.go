@@ -0,0 +1,107 @@
+package stringsvc
+
+import (
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+
+	"github.com/mcclayac/gokit/svcerrors"
+)
+
+// Request bodies may also be HTML forms, urlencoded or multipart, for the
+// internal tools that post them straight from a page. Each form field sets
+// the request field of the same wire name, as in
+//
+//	curl -d s=hello http://localhost:9090/uppercase
+//
+// and fields the request doesn't have are ignored. Responses are still
+// written in the format negotiated from the Accept header.
+
+// formMaxMemory is how much of a multipart form is held in memory; larger
+// parts are spooled to temporary files.
+const formMaxMemory = 1 << 20
+
+// isForm reports whether r's body is a form.
+func isForm(r *http.Request) bool {
+	mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && (mt == "application/x-www-form-urlencoded" || mt == "multipart/form-data")
+}
+
+// decodeForm parses the form in r's body into v, a pointer to a request
+// struct. Only the body's fields count, not the query string's. Strings,
+// numbers and booleans take the field's first value; slices of strings
+// take them all.
+func decodeForm(r *http.Request, v interface{}) error {
+	var err error
+	if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt == "multipart/form-data" {
+		err = r.ParseMultipartForm(formMaxMemory)
+		if r.MultipartForm != nil {
+			defer r.MultipartForm.RemoveAll()
+		}
+	} else {
+		err = r.ParseForm()
+	}
+	if err != nil {
+		return svcerrors.Errorf(svcerrors.CodeInvalidArgument, "malformed form: %v", err)
+	}
+
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Struct {
+		return nil
+	}
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		if rt.Field(i).PkgPath != "" || rt.Field(i).Tag.Get("json") == "-" {
+			continue
+		}
+		name := fieldName(rt.Field(i))
+		values, ok := r.PostForm[name]
+		if !ok || len(values) == 0 {
+			continue
+		}
+		if err := setFormField(rv.Field(i), values); err != nil {
+			return svcerrors.Errorf(svcerrors.CodeInvalidArgument, "form field %q: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// setFormField sets f from a form field's values. Fields of other kinds
+// can't be set from a form and are left alone.
+func setFormField(f reflect.Value, values []string) error {
+	s := values[0]
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(s)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		x, err := strconv.ParseFloat(s, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetFloat(x)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		f.SetBool(b)
+	case reflect.Slice:
+		if f.Type().Elem().Kind() == reflect.String {
+			f.Set(reflect.ValueOf(append([]string(nil), values...)).Convert(f.Type()))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package stringsvc
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/mcclayac/gokit/svcerrors"
+)
+
+func TestDecodeForm(t *testing.T) {
+	r := httptest.NewRequest("POST", "/math/add?a=100", strings.NewReader(url.Values{"a": {"1.5"}, "b": {"2"}, "x": {"ignored"}}.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	var m mathRequest
+	if err := decodeBody(r, &m); err != nil || m.A != 1.5 || m.B != 2 {
+		t.Errorf("urlencoded: got %+v, %v, want the body's a and b", m, err)
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("client_id", "billing")
+	mw.WriteField("scopes", "uppercase")
+	mw.WriteField("scopes", "count")
+	mw.Close()
+	r = httptest.NewRequest("POST", "/auth/token", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	var tr tokenRequest
+	if err := decodeBody(r, &tr); err != nil || tr.ClientID != "billing" || len(tr.Scopes) != 2 || tr.Scopes[1] != "count" {
+		t.Errorf("multipart: got %+v, %v", tr, err)
+	}
+
+	r = httptest.NewRequest("POST", "/math/add", strings.NewReader("a=one"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if err := decodeBody(r, &m); svcerrors.CodeOf(err) != svcerrors.CodeInvalidArgument {
+		t.Errorf("a number that isn't: got %v, want INVALID_ARGUMENT", err)
+	}
+}
+
+func TestFormOverHTTP(t *testing.T) {
+	srv := newTestServer(t)
+	resp, b := callTestServer(t, srv, "POST", "/uppercase", http.Header{"Content-Type": {"application/x-www-form-urlencoded"}}, "s=hello")
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(b), `"v":"HELLO"`) {
+		t.Errorf("got %d %s, want HELLO", resp.StatusCode, b)
+	}
+}
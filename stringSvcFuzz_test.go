@@ -0,0 +1,260 @@
+package stringsvc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"unicode/utf8"
+
+	httptransport "github.com/go-kit/kit/transport/http"
+
+	"github.com/mcclayac/gokit/codec"
+	"github.com/mcclayac/gokit/langdetect"
+	"github.com/mcclayac/gokit/spell"
+	"github.com/mcclayac/gokit/svcerrors"
+	"github.com/mcclayac/gokit/testutil"
+)
+
+// The fuzz tests feed the request decoders, the CBOR decoder and the
+// string operations inputs mutated from seeds, and fail on any input that
+// panics or breaks the target's invariants:
+//
+//	go test -run '^$' -fuzz FuzzDecodeUppercaseRequest -fuzztime 10m .
+//
+// The decoders are seeded with the contract golden files of their
+// requests. Failing inputs are saved under testdata/fuzz, and go test runs
+// them, with the seeds, as regression tests.
+
+// fuzzContentTypes are the bodies a decoder is fuzzed with, picked by the
+// fuzzer's first argument.
+var fuzzContentTypes = []struct {
+	ext, contentType string
+}{
+	{"json", "application/json"},
+	{"msgpack", "application/msgpack"},
+	{"pb", "application/x-protobuf"},
+	{"xml", "application/xml"},
+	{"cbor", "application/cbor"},
+	{"form", "application/x-www-form-urlencoded"},
+}
+
+// fuzzDecoder fuzzes decode with bodies of every content type, seeded with
+// the contract golden files of request. It checks that decode turns a
+// body into a request or a service error, and that a decoded request
+// validates to nil or a service error, so that bad input is always the
+// client's INVALID_ARGUMENT and never an internal error.
+func fuzzDecoder(f *testing.F, decode httptransport.DecodeRequestFunc, request string) {
+	f.Add(uint8(0), []byte("{}"))
+	for i, ct := range fuzzContentTypes {
+		if b, err := os.ReadFile(filepath.Join("contract", request+"."+ct.ext)); err == nil {
+			f.Add(uint8(i), b)
+		}
+	}
+	f.Fuzz(func(t *testing.T, kind uint8, body []byte) {
+		ct := fuzzContentTypes[int(kind)%len(fuzzContentTypes)]
+		r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+		r.Header.Set("Content-Type", ct.contentType)
+		request, err := decode(context.Background(), r)
+		var e *svcerrors.Error
+		if err != nil {
+			if !errors.As(err, &e) {
+				t.Fatalf("%s body: decoding failed with %T, not a service error: %v", ct.ext, err, err)
+			}
+			return
+		}
+		if err := validate(request); err != nil && !errors.As(err, &e) {
+			t.Fatalf("%s body: validation failed with %T, not a service error: %v", ct.ext, err, err)
+		}
+	})
+}
+
+func FuzzDecodeUppercaseRequest(f *testing.F) {
+	fuzzDecoder(f, decodeUppercaseRequest, "uppercase_request")
+}
+
+func FuzzDecodeCountRequest(f *testing.F) {
+	fuzzDecoder(f, decodeCountRequest, "count_request")
+}
+
+func FuzzDecodeMathRequest(f *testing.F) {
+	fuzzDecoder(f, decodeMathRequest, "math_request")
+}
+
+func FuzzDecodeNowRequest(f *testing.F) {
+	fuzzDecoder(f, decodeNowRequest, "now_request")
+}
+
+func FuzzDecodeFormatRequest(f *testing.F) {
+	fuzzDecoder(f, decodeFormatRequest, "format_request")
+}
+
+func FuzzDecodeConvertRequest(f *testing.F) {
+	fuzzDecoder(f, decodeConvertRequest, "convert_request")
+}
+
+func FuzzDecodeUUIDRequest(f *testing.F) {
+	fuzzDecoder(f, decodeUUIDRequest, "uuid_request")
+}
+
+func FuzzDecodeULIDRequest(f *testing.F) {
+	fuzzDecoder(f, decodeULIDRequest, "ulid_request")
+}
+
+func FuzzDecodeHashRequest(f *testing.F) {
+	fuzzDecoder(f, decodeHashRequest, "hash_request")
+}
+
+func FuzzDecodeVerifyRequest(f *testing.F) {
+	fuzzDecoder(f, decodeVerifyRequest, "verify_request")
+}
+
+func FuzzDecodeShortenRequest(f *testing.F) {
+	fuzzDecoder(f, decodeShortenRequest, "shorten_request")
+}
+
+func FuzzDecodeResolveRequest(f *testing.F) {
+	fuzzDecoder(f, decodeResolveRequest, "resolve_request")
+}
+
+func FuzzDecodeQRCodeRequest(f *testing.F) {
+	fuzzDecoder(f, decodeQRCodeRequest, "qrcode_request")
+}
+
+func FuzzDecodeTranslateRequest(f *testing.F) {
+	fuzzDecoder(f, decodeTranslateRequest, "translate_request")
+}
+
+func FuzzDecodeSpellcheckRequest(f *testing.F) {
+	fuzzDecoder(f, decodeSpellcheckRequest, "spellcheck_request")
+}
+
+func FuzzDecodeDetectRequest(f *testing.F) {
+	fuzzDecoder(f, decodeDetectRequest, "detect_request")
+}
+
+func FuzzDecodeSummarizeRequest(f *testing.F) {
+	fuzzDecoder(f, decodeSummarizeRequest, "summarize_request")
+}
+
+func FuzzDecodeRewriteRequest(f *testing.F) {
+	fuzzDecoder(f, decodeRewriteRequest, "rewrite_request")
+}
+
+func FuzzDecodeTokenRequest(f *testing.F) {
+	fuzzDecoder(f, decodeTokenRequest, "token_request")
+}
+
+func FuzzDecodeRevokeRequest(f *testing.F) {
+	fuzzDecoder(f, decodeRevokeRequest, "revoke_request")
+}
+
+func FuzzDecodeSubmitJobRequest(f *testing.F) {
+	fuzzDecoder(f, decodeSubmitJobRequest, "submit_job_request")
+}
+
+// FuzzCBOR checks the CBOR decoder on its own, below any request type:
+// that any body decodes or fails without panicking, within the decoder's
+// limits, and that what it decodes has a canonical encoding that decodes
+// and encodes back to the same bytes.
+func FuzzCBOR(f *testing.F) {
+	for _, cc := range contractCases {
+		var b bytes.Buffer
+		if codec.CBOR.Encode(&b, cc.value) == nil {
+			f.Add(b.Bytes())
+		}
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var v interface{}
+		if err := codec.CBOR.Decode(bytes.NewReader(data), &v); err != nil {
+			return
+		}
+		canonical := codec.CBORCodec{Canonical: true}
+		var first, second bytes.Buffer
+		if err := canonical.Encode(&first, v); err != nil {
+			t.Fatalf("encoding decoded %x: %v", data, err)
+		}
+		var w interface{}
+		if err := canonical.Decode(bytes.NewReader(first.Bytes()), &w); err != nil {
+			t.Fatalf("decoding canonical %x: %v", first.Bytes(), err)
+		}
+		if err := canonical.Encode(&second, w); err != nil {
+			t.Fatalf("encoding decoded %x: %v", first.Bytes(), err)
+		}
+		if !bytes.Equal(first.Bytes(), second.Bytes()) {
+			t.Fatalf("canonical encoding of %x is not stable: %x, then %x", data, first.Bytes(), second.Bytes())
+		}
+	})
+}
+
+// addStringSeeds seeds f with the samples the property tests generate text
+// from, which trip up byte and rune handling: case mappings that change
+// length, combining marks, joiners, bidi controls, several scripts and
+// invalid UTF-8.
+func addStringSeeds(f *testing.F) {
+	for _, s := range testutil.Samples() {
+		f.Add(s)
+	}
+}
+
+func FuzzUppercase(f *testing.F) {
+	addStringSeeds(f)
+	f.Fuzz(func(t *testing.T, s string) {
+		v, err := stringService{}.Uppercase(context.Background(), s)
+		switch {
+		case s == "" && err != ErrEmpty:
+			t.Fatalf("uppercasing %q: got error %v, want %v", s, err, ErrEmpty)
+		case s != "" && err != nil:
+			t.Fatalf("uppercasing %q: %v", s, err)
+		case utf8.ValidString(s) && !utf8.ValidString(v):
+			t.Fatalf("uppercasing valid UTF-8 %q gave invalid %q", s, v)
+		}
+	})
+}
+
+func FuzzCount(f *testing.F) {
+	addStringSeeds(f)
+	count := func(s string) int { return stringService{}.Count(context.Background(), s) }
+	f.Fuzz(func(t *testing.T, s string) {
+		if n := count(s); n != len(s) {
+			t.Fatalf("counting %q: got %d, want %d", s, n, len(s))
+		}
+		for _, p := range []testutil.Property{testutil.ReverseInvariant("count", count), testutil.Additive("count", count)} {
+			if err := p.Holds(s); err != nil {
+				t.Fatalf("counting %q: %s: %v", s, p.Name, err)
+			}
+		}
+	})
+}
+
+func FuzzTokenize(f *testing.F) {
+	addStringSeeds(f)
+	f.Fuzz(func(t *testing.T, s string) {
+		for _, tok := range spell.Tokenize(s) {
+			if tok.Word == "" || tok.Offset < 0 || tok.Offset+len(tok.Word) > len(s) || s[tok.Offset:tok.Offset+len(tok.Word)] != tok.Word {
+				t.Fatalf("tokenizing %q: token %q at %d isn't in the text", s, tok.Word, tok.Offset)
+			}
+		}
+	})
+}
+
+func FuzzDetect(f *testing.F) {
+	addStringSeeds(f)
+	f.Fuzz(func(t *testing.T, s string) {
+		const n = 3
+		guesses := langdetect.Detect(s, n)
+		if len(guesses) > n {
+			t.Fatalf("detecting %q: got %d guesses, want at most %d", s, len(guesses), n)
+		}
+		for _, g := range guesses {
+			if math.IsNaN(g.Confidence) || g.Confidence < 0 || g.Confidence > 1 {
+				t.Fatalf("detecting %q: %s has confidence %v", s, g.Lang, g.Confidence)
+			}
+		}
+	})
+}
@@ -0,0 +1,87 @@
+package stringsvc
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// The main listener speaks HTTP/1.1 and, with -tls-cert and -tls-key,
+// HTTP/2 over TLS, negotiated by ALPN. Without TLS, -h2c adds HTTP/2 in
+// cleartext, with prior knowledge or by upgrade, for internal clients that
+// multiplex calls over one connection; it is off by default, since
+// proxies in front of the service may not expect it.
+
+// tlsConfig names the certificate served. TLS is off when CertFile is
+// empty.
+type tlsConfig struct {
+	CertFile string
+	KeyFile  string
+}
+
+// http2Config configures HTTP/2.
+type http2Config struct {
+	H2C                  bool
+	MaxConcurrentStreams uint32
+	IdleTimeout          time.Duration
+}
+
+// configureHTTP2 sets srv up to serve HTTP/2 as cfg says. The handler is
+// wrapped for h2c, so it must be set first.
+func configureHTTP2(srv *http.Server, cfg http2Config) {
+	h2 := &http2.Server{MaxConcurrentStreams: cfg.MaxConcurrentStreams, IdleTimeout: cfg.IdleTimeout}
+	if cfg.H2C {
+		srv.Handler = h2c.NewHandler(srv.Handler, h2)
+		return
+	}
+	// ConfigureServer only fails on a TLSConfig with ciphers HTTP/2
+	// forbids, and srv has none of its own.
+	http2.ConfigureServer(srv, h2)
+}
+
+// serveHTTP serves srv on ln, over TLS if cfg has a certificate.
+func serveHTTP(srv *http.Server, ln net.Listener, cfg tlsConfig) error {
+	if cfg.CertFile != "" {
+		return srv.ServeTLS(ln, cfg.CertFile, cfg.KeyFile)
+	}
+	return srv.Serve(ln)
+}
+
+// loadCertificate loads the certificate served and parses its leaf.
+func loadCertificate(cfg tlsConfig) (*x509.Certificate, error) {
+	pair, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(pair.Certificate[0])
+}
+
+// certificateCheck is a self-check that the certificate served is
+// current. It reads the files each time, so that it checks a renewed
+// certificate, and warns in the detail when it is to expire within a
+// week.
+func certificateCheck(cfg tlsConfig, now func() time.Time) func(context.Context) (string, error) {
+	return func(context.Context) (string, error) {
+		cert, err := loadCertificate(cfg)
+		if err != nil {
+			return "", err
+		}
+		t := now()
+		switch {
+		case t.Before(cert.NotBefore):
+			return "", fmt.Errorf("certificate for %s is not valid until %s", cert.Subject.CommonName, cert.NotBefore.Format(time.RFC3339))
+		case !t.Before(cert.NotAfter):
+			return "", fmt.Errorf("certificate for %s expired at %s", cert.Subject.CommonName, cert.NotAfter.Format(time.RFC3339))
+		case cert.NotAfter.Sub(t) < 7*24*time.Hour:
+			return fmt.Sprintf("certificate for %s expires soon, at %s", cert.Subject.CommonName, cert.NotAfter.Format(time.RFC3339)), nil
+		}
+		return fmt.Sprintf("certificate for %s expires at %s", cert.Subject.CommonName, cert.NotAfter.Format(time.RFC3339)), nil
+	}
+}
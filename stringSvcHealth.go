@@ -0,0 +1,60 @@
+package stringsvc
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/mcclayac/gokit/migrate"
+)
+
+// schemaHealth reports a database's schema version against the newest
+// migration this build carries.
+type schemaHealth struct {
+	Version int    `json:"version"`
+	Latest  int    `json:"latest"`
+	Err     string `json:"error,omitempty"`
+}
+
+type healthResponse struct {
+	Status  string                  `json:"status"` // "ok", "degraded" or "unavailable"
+	Version string                  `json:"version"`
+	Schemas map[string]schemaHealth `json:"schemas,omitempty"`
+}
+
+// healthHandler serves /healthz. It answers 503 if a database can't be
+// reached. A schema behind this build's migrations is reported as degraded
+// but still answers 200, since the service runs while a deploy migrates.
+func healthHandler(pg, lite *sql.DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+		defer cancel()
+		resp := healthResponse{Status: "ok", Version: version, Schemas: map[string]schemaHealth{}}
+		code := http.StatusOK
+		for _, d := range []struct {
+			db      *sql.DB
+			dialect migrate.Dialect
+		}{{pg, migrate.Postgres}, {lite, migrate.SQLite}} {
+			if d.db == nil {
+				continue
+			}
+			h := schemaHealth{Latest: migrate.Latest(d.dialect)}
+			v, err := migrate.Version(ctx, d.db)
+			switch {
+			case err != nil:
+				h.Err = err.Error()
+				resp.Status, code = "unavailable", http.StatusServiceUnavailable
+			case v < h.Latest && resp.Status == "ok":
+				resp.Status = "degraded"
+			}
+			h.Version = v
+			resp.Schemas[d.dialect.String()] = h
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(resp)
+	})
+}
@@ -0,0 +1,358 @@
+package stringsvc
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+
+	"github.com/mcclayac/gokit/events"
+	"github.com/mcclayac/gokit/kit"
+	"github.com/mcclayac/gokit/svcerrors"
+)
+
+// historyRecord summarizes one call. Like events, it identifies the input
+// only by its hash.
+type historyRecord struct {
+	ID         string    `json:"id" xml:"id"`
+	Time       time.Time `json:"time" xml:"time"`
+	Operation  string    `json:"operation" xml:"operation"`
+	Identity   string    `json:"identity" xml:"identity"`
+	RequestID  string    `json:"request_id,omitempty" xml:"request_id,omitempty"`
+	InputHash  string    `json:"input_sha256" xml:"input_sha256"`
+	Status     string    `json:"status" xml:"status"`
+	ResultSize int       `json:"result_size" xml:"result_size"`
+	DurationMS int64     `json:"duration_ms" xml:"duration_ms"`
+}
+
+// event returns the event describing the same call as r.
+func (r historyRecord) event() events.Event {
+	return events.Event{
+		ID:         r.ID,
+		Operation:  r.Operation,
+		InputHash:  r.InputHash,
+		ResultSize: r.ResultSize,
+		Status:     r.Status,
+		Identity:   r.Identity,
+		RequestID:  r.RequestID,
+		Time:       r.Time,
+	}
+}
+
+// historyStore keeps history records. Implementations must be safe for
+// concurrent use.
+type historyStore interface {
+	Record(ctx context.Context, rec historyRecord) error
+	// List returns up to q.Limit records matching q, newest first.
+	List(ctx context.Context, q historyQuery) ([]historyRecord, error)
+}
+
+// historyQuery selects history records. Empty fields don't filter.
+type historyQuery struct {
+	Operation string
+	Identity  string
+	Since     time.Time // inclusive
+	Until     time.Time // exclusive
+	Limit     int
+	After     *historyCursor // resume after this record
+}
+
+// historyCursor is the position of a record in the newest-first order
+// records are listed in.
+type historyCursor struct {
+	Time time.Time `json:"t"`
+	ID   string    `json:"id"`
+}
+
+// historyListQuery builds the SELECT for q, numbering its parameters with
+// placeholder. Times are passed to the database as returned by dbTime.
+func historyListQuery(q historyQuery, placeholder func(n int) string, dbTime func(time.Time) interface{}) (string, []interface{}) {
+	var (
+		where []string
+		args  []interface{}
+	)
+	cond := func(format string, vs ...interface{}) {
+		refs := make([]interface{}, len(vs))
+		for i, v := range vs {
+			args = append(args, v)
+			refs[i] = placeholder(len(args))
+		}
+		where = append(where, fmt.Sprintf(format, refs...))
+	}
+	if q.Operation != "" {
+		cond("operation = %s", q.Operation)
+	}
+	if q.Identity != "" {
+		cond("identity = %s", q.Identity)
+	}
+	if !q.Since.IsZero() {
+		cond("time >= %s", dbTime(q.Since))
+	}
+	if !q.Until.IsZero() {
+		cond("time < %s", dbTime(q.Until))
+	}
+	if q.After != nil {
+		cond("(time, id) < (%s, %s)", dbTime(q.After.Time), q.After.ID)
+	}
+	query := `SELECT id, time, operation, identity, request_id, input_sha256, status, result_size, duration_ms FROM request_history`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	args = append(args, q.Limit)
+	query += " ORDER BY time DESC, id DESC LIMIT " + placeholder(len(args))
+	return query, args
+}
+
+// memoryHistoryStore keeps the most recent history records in memory,
+// dropping the oldest beyond its capacity.
+type memoryHistoryStore struct {
+	mtx     sync.Mutex
+	records []historyRecord // a ring, oldest at next once full
+	next    int
+	full    bool
+}
+
+func newMemoryHistoryStore(capacity int) *memoryHistoryStore {
+	return &memoryHistoryStore{records: make([]historyRecord, capacity)}
+}
+
+func (h *memoryHistoryStore) Record(_ context.Context, rec historyRecord) error {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	h.records[h.next] = rec
+	h.next = (h.next + 1) % len(h.records)
+	h.full = h.full || h.next == 0
+	return nil
+}
+
+func (h *memoryHistoryStore) List(_ context.Context, q historyQuery) ([]historyRecord, error) {
+	h.mtx.Lock()
+	n := h.next
+	if h.full {
+		n = len(h.records)
+	}
+	all := make([]historyRecord, 0, n)
+	for i := 1; i <= n; i++ {
+		all = append(all, h.records[(h.next-i+len(h.records))%len(h.records)])
+	}
+	h.mtx.Unlock()
+
+	// Records arrive about in time order, not exactly, so they are sorted.
+	sort.Slice(all, func(i, j int) bool { return historyBefore(all[j], all[i]) })
+	records := []historyRecord{}
+	for _, r := range all {
+		if len(records) == q.Limit {
+			break
+		}
+		if q.matches(r) {
+			records = append(records, r)
+		}
+	}
+	return records, nil
+}
+
+// historyBefore reports whether a comes before b in time order, ties broken
+// by ID.
+func historyBefore(a, b historyRecord) bool {
+	if !a.Time.Equal(b.Time) {
+		return a.Time.Before(b.Time)
+	}
+	return a.ID < b.ID
+}
+
+// matches reports whether r passes q's filters, for stores that filter
+// records themselves.
+func (q historyQuery) matches(r historyRecord) bool {
+	switch {
+	case q.Operation != "" && r.Operation != q.Operation,
+		q.Identity != "" && r.Identity != q.Identity,
+		!q.Since.IsZero() && r.Time.Before(q.Since),
+		!q.Until.IsZero() && !r.Time.Before(q.Until),
+		q.After != nil && !historyBefore(r, historyRecord{Time: q.After.Time, ID: q.After.ID}):
+		return false
+	}
+	return true
+}
+
+// postgresHistoryStore keeps history records in PostgreSQL.
+type postgresHistoryStore struct {
+	db *sql.DB
+	// outbox, when set, receives each record's event in the transaction
+	// that inserts the record, so the two are never out of step.
+	outbox *events.Outbox
+}
+
+// Record stores rec, and its event if the store has an outbox.
+func (h *postgresHistoryStore) Record(ctx context.Context, rec historyRecord) error {
+	if h.outbox == nil {
+		return h.insert(ctx, h.db, rec)
+	}
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := h.insert(ctx, tx, rec); err != nil {
+		return err
+	}
+	if err := h.outbox.PublishTx(ctx, tx, rec.event()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+func (h *postgresHistoryStore) insert(ctx context.Context, db execer, rec historyRecord) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO request_history (id, time, operation, identity, request_id, input_sha256, status, result_size, duration_ms)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		rec.ID, rec.Time, rec.Operation, rec.Identity, rec.RequestID, rec.InputHash, rec.Status, rec.ResultSize, rec.DurationMS)
+	return err
+}
+
+func (h *postgresHistoryStore) List(ctx context.Context, q historyQuery) ([]historyRecord, error) {
+	query, args := historyListQuery(q,
+		func(n int) string { return fmt.Sprintf("$%d", n) },
+		func(t time.Time) interface{} { return t })
+	rows, err := h.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	records := []historyRecord{}
+	for rows.Next() {
+		var r historyRecord
+		if err := rows.Scan(&r.ID, &r.Time, &r.Operation, &r.Identity, &r.RequestID, &r.InputHash, &r.Status, &r.ResultSize, &r.DurationMS); err != nil {
+			return nil, err
+		}
+		r.Time = r.Time.UTC()
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
+
+// historyMiddleware records a summary of every completed call. The result
+// size is the length of the response encoded as JSON. Failing to record a
+// call is logged but doesn't fail it.
+func historyMiddleware(h historyStore, logger log.Logger, method string) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+			defer func(begin time.Time) {
+				var size int
+				if response != nil {
+					b, _ := json.Marshal(response)
+					size = len(b)
+				}
+				if herr := h.Record(ctx, historyRecord{
+					ID:         newEventID(),
+					Time:       begin.UTC(),
+					Operation:  method,
+					Identity:   identity(ctx),
+					RequestID:  requestID(ctx),
+					InputHash:  inputHash(request),
+					Status:     kit.OutcomeCode(response, err),
+					ResultSize: size,
+					DurationMS: time.Since(begin).Milliseconds(),
+				}); herr != nil {
+					level.Error(logger).Log("msg", "recording history", "method", method, "err", herr)
+				}
+			}(time.Now())
+			return next(ctx, request)
+		}
+	}
+}
+
+type historyRequest struct {
+	Operation string `json:"operation" validate:"max=64"`
+	Identity  string `json:"identity" validate:"max=256"`
+	Since     time.Time
+	Until     time.Time
+	Limit     int `json:"limit" validate:"min=1,max=1000"`
+	PageToken string
+}
+
+type historyResponse struct {
+	Records []historyRecord `json:"records" xml:"records>record"`
+	// NextPageToken is passed as page_token to get the records after these.
+	// It is empty on the last page.
+	NextPageToken string `json:"next_page_token,omitempty" xml:"next_page_token,omitempty"`
+	next          string // the next page's URL
+}
+
+// Headers implements httptransport.Headerer.
+func (r historyResponse) Headers() http.Header { return pageLinkHeader(r.next) }
+
+func makeHistoryEndpoint(h historyStore) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(historyRequest)
+		q := historyQuery{
+			Operation: req.Operation,
+			Identity:  req.Identity,
+			Since:     req.Since,
+			Until:     req.Until,
+			Limit:     req.Limit,
+		}
+		if req.PageToken != "" {
+			var c historyCursor
+			if err := decodeCursor(req.PageToken, &c, func() bool { return c.ID != "" }); err != nil {
+				return nil, err
+			}
+			q.After = &c
+		}
+		records, err := h.List(ctx, q)
+		if err != nil {
+			return nil, err
+		}
+		resp := historyResponse{Records: records}
+		if len(records) == req.Limit {
+			last := records[len(records)-1]
+			resp.NextPageToken = encodeCursor(historyCursor{last.Time, last.ID})
+			resp.next = nextPageURL(ctx, resp.NextPageToken)
+		}
+		return resp, nil
+	}
+}
+
+// decodeHistoryRequest reads the operation, identity, since and until
+// query parameters, and the paging ones decodePageParams reads. Times are
+// RFC 3339.
+func decodeHistoryRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	page, err := decodePageParams(r)
+	if err != nil {
+		return nil, err
+	}
+	q := r.URL.Query()
+	request := historyRequest{
+		Operation: q.Get("operation"),
+		Identity:  q.Get("identity"),
+		Limit:     page.Limit,
+		PageToken: page.Token,
+	}
+	for _, p := range []struct {
+		name string
+		t    *time.Time
+	}{{"since", &request.Since}, {"until", &request.Until}} {
+		s := q.Get(p.name)
+		if s == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return nil, svcerrors.Errorf(svcerrors.CodeInvalidArgument, "malformed %s %q: want an RFC 3339 time", p.name, s)
+		}
+		*p.t = t
+	}
+	return request, nil
+}
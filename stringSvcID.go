@@ -0,0 +1,159 @@
+package stringsvc
+
+import (
+	"context"
+	"crypto/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/google/uuid"
+	"github.com/oklog/ulid/v2"
+
+	"github.com/mcclayac/gokit/svcerrors"
+)
+
+// maxIDBatch is the most IDs generated by one call; it matches the max rule
+// on the requests' count fields.
+const maxIDBatch = 1000
+
+// IDService generates unique identifiers, one or a batch at a time.
+type IDService interface {
+	// UUID returns count UUIDs of the given version, "v4" (random, the
+	// default) or "v7" (time-ordered).
+	UUID(ctx context.Context, version string, count int) ([]string, error)
+	// ULID returns count ULIDs. Monotonic ULIDs sort in the order they were
+	// generated, even within the same millisecond and across calls.
+	ULID(ctx context.Context, count int, monotonic bool) ([]string, error)
+}
+
+// idService is a concrete implementation of IDService.
+type idService struct {
+	now func() time.Time
+
+	mtx     sync.Mutex
+	entropy *ulid.MonotonicEntropy // for monotonic ULIDs, guarded by mtx
+}
+
+func newIDService(now func() time.Time) *idService {
+	return &idService{now: now, entropy: ulid.Monotonic(rand.Reader, 0)}
+}
+
+func (s *idService) UUID(ctx context.Context, version string, count int) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var gen func() (uuid.UUID, error)
+	switch version {
+	case "", "v4":
+		gen = uuid.NewRandom
+	case "v7":
+		gen = uuid.NewV7
+	default:
+		return nil, svcerrors.Errorf(svcerrors.CodeInvalidArgument, "unsupported UUID version %q: want v4 or v7", version)
+	}
+	ids := make([]string, batchSize(count))
+	for i := range ids {
+		id, err := gen()
+		if err != nil {
+			return nil, err
+		}
+		ids[i] = id.String()
+	}
+	return ids, nil
+}
+
+func (s *idService) ULID(ctx context.Context, count int, monotonic bool) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	ids := make([]string, batchSize(count))
+	if monotonic {
+		s.mtx.Lock()
+		defer s.mtx.Unlock()
+	}
+	for i := range ids {
+		ms := ulid.Timestamp(s.now())
+		var (
+			id  ulid.ULID
+			err error
+		)
+		if monotonic {
+			id, err = ulid.New(ms, s.entropy)
+		} else {
+			id, err = ulid.New(ms, rand.Reader)
+		}
+		if err != nil {
+			// The monotonic entropy overflows after 2^80 IDs in one
+			// millisecond; the caller can try again in the next one.
+			return nil, err
+		}
+		ids[i] = id.String()
+	}
+	return ids, nil
+}
+
+// batchSize returns the number of IDs to generate for a requested count,
+// which defaults to one.
+func batchSize(count int) int {
+	if count <= 0 {
+		return 1
+	}
+	if count > maxIDBatch {
+		return maxIDBatch
+	}
+	return count
+}
+
+// For each method, we define request and response structs.
+type uuidRequest struct {
+	Version string `json:"version" xml:"version" validate:"max=8"`
+	Count   int    `json:"count" xml:"count" validate:"min=0,max=1000"`
+}
+
+type ulidRequest struct {
+	Count     int  `json:"count" xml:"count" validate:"min=0,max=1000"`
+	Monotonic bool `json:"monotonic" xml:"monotonic"`
+}
+
+// idsResponse is the response of every IDService method.
+type idsResponse struct {
+	IDs []string `json:"ids" xml:"ids>id"`
+	Err error    `json:"-" xml:"-"`
+}
+
+// Failed implements endpoint.Failer.
+func (r idsResponse) Failed() error { return r.Err }
+
+func makeUUIDEndpoint(svc IDService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(uuidRequest)
+		ids, err := svc.UUID(ctx, req.Version, req.Count)
+		return idsResponse{ids, err}, nil
+	}
+}
+
+func makeULIDEndpoint(svc IDService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(ulidRequest)
+		ids, err := svc.ULID(ctx, req.Count, req.Monotonic)
+		return idsResponse{ids, err}, nil
+	}
+}
+
+func decodeUUIDRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request uuidRequest
+	if err := decodeBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+func decodeULIDRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request ulidRequest
+	if err := decodeBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
@@ -0,0 +1,243 @@
+package stringsvc
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mcclayac/gokit/svcerrors"
+)
+
+// idempotencyKeyHeader names the header clients set to make a request safe
+// to retry: the first response for a key is recorded and replayed to every
+// later request with the same key.
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// recordedResponse is a response captured for replay.
+type recordedResponse struct {
+	Fingerprint string // hash of the request that produced it
+	Status      int
+	Header      http.Header
+	Body        []byte
+}
+
+// idempotencyStore holds recorded responses by key. Implementations must be
+// safe for concurrent use.
+type idempotencyStore interface {
+	// Begin claims key for a request with the given fingerprint. If the key
+	// is already claimed it returns false, along with the recorded response,
+	// which is nil while the first request is still in flight.
+	Begin(ctx context.Context, key, fingerprint string, ttl time.Duration) (*recordedResponse, bool, error)
+	// Finish records the response for a key claimed by Begin.
+	Finish(ctx context.Context, key string, resp *recordedResponse, ttl time.Duration) error
+	// Abort releases a key claimed by Begin without recording a response,
+	// so that the request can be retried.
+	Abort(ctx context.Context, key string) error
+}
+
+// idempotencyMiddleware replays recorded responses to POST requests that
+// carry an Idempotency-Key header. Requests without the header pass through.
+// A key reused with a different body is rejected, as is a retry that arrives
+// while the original request is still being processed. Server errors are not
+// recorded, so retrying after one runs the request again; nor are streamed
+// responses or those over maxBytes, whose keys are released. Request bodies
+// over maxBytes are refused. Keys are the caller's own, by their identity as
+// callerIdentity finds it. A replay carries the request ID of the request it
+// answers.
+//
+// With -storage redis the store is in Redis, shared by the replicas, so a
+// retry is recognized whichever instance it reaches. Otherwise it is in the
+// process's memory and a retry is only recognized by the instance that
+// answered the original request; the client package sends every call with a
+// key to the instance the key picks, for deployments like that. A store
+// that fails refuses the call with INTERNAL, which clients retry, rather
+// than risk running it twice.
+func idempotencyMiddleware(store idempotencyStore, ttl time.Duration, maxBytes int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(idempotencyKeyHeader)
+			if key == "" || r.Method != http.MethodPost {
+				next.ServeHTTP(w, r)
+				return
+			}
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBytes))
+			if err != nil {
+				var tooLarge *http.MaxBytesError
+				if errors.As(err, &tooLarge) {
+					encodeError(r.Context(), svcerrors.Errorf(svcerrors.CodePayloadTooLarge, "request body is over %d bytes", maxBytes), w)
+					return
+				}
+				encodeError(r.Context(), svcerrors.Errorf(svcerrors.CodeInvalidArgument, "reading request body: %v", err), w)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			key = callerIdentity(r) + "\x00" + r.URL.Path + "\x00" + key
+			fingerprint := requestFingerprint(r, body)
+			recorded, ok, err := store.Begin(r.Context(), key, fingerprint, ttl)
+			switch {
+			case err != nil:
+				encodeError(r.Context(), err, w)
+				return
+			case !ok && recorded == nil:
+				encodeError(r.Context(), svcerrors.New(svcerrors.CodeIdempotencyConflict, "a request with this idempotency key is in progress"), w)
+				return
+			case !ok && recorded.Fingerprint != fingerprint:
+				encodeError(r.Context(), svcerrors.New(svcerrors.CodeIdempotencyMismatch, "idempotency key was used with a different request"), w)
+				return
+			case !ok:
+				replay(w, r, recorded)
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(recorded.Status)
+				w.Write(recorded.Body)
+				return
+			}
+
+			// The key is released if next panics, as the recovery
+			// middleware is outside this one. The store is updated even if
+			// the client has gone, since its retry is on the way.
+			ctx := context.WithoutCancel(r.Context())
+			finished := false
+			defer func() {
+				if !finished {
+					if err := store.Abort(ctx, key); err != nil {
+						reportUnexpected(ctx, err)
+					}
+				}
+			}()
+			rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK, max: maxBytes}
+			next.ServeHTTP(rec, r)
+			if rec.status >= 500 || rec.unkept {
+				return
+			}
+			finished = true
+			err = store.Finish(ctx, key, &recordedResponse{
+				Fingerprint: fingerprint,
+				Status:      rec.status,
+				Header:      w.Header().Clone(),
+				Body:        rec.body.Bytes(),
+			}, ttl)
+			if err != nil {
+				reportUnexpected(ctx, err)
+			}
+		})
+	}
+}
+
+// replay sets the headers of recorded, a response recorded for an earlier
+// request, on w, the response to r, keeping r's own request ID.
+func replay(w http.ResponseWriter, r *http.Request, recorded *recordedResponse) {
+	for k, v := range recorded.Header {
+		w.Header()[k] = v
+	}
+	if id := r.Header.Get(requestIDHeader); id != "" {
+		w.Header().Set(requestIDHeader, id)
+	}
+}
+
+func requestFingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method + " " + r.URL.RequestURI() + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// responseRecorder passes a response through while keeping a copy of its
+// status and body. If max is set, bodies over max bytes and streamed
+// responses, which are flushed, are passed through without a copy, and set
+// unkept.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+	max    int64
+	unkept bool
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if r.max > 0 && int64(r.body.Len()+len(b)) > r.max {
+		r.drop()
+	}
+	if !r.unkept {
+		r.body.Write(b)
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher, so that streamed responses pass through.
+func (r *responseRecorder) Flush() {
+	if r.max > 0 {
+		r.drop()
+	}
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// drop stops keeping a copy of the response.
+func (r *responseRecorder) drop() {
+	r.unkept = true
+	r.body = bytes.Buffer{}
+}
+
+// memoryIdempotencyStore is an in-process idempotencyStore. Expired entries
+// are swept lazily as new keys arrive.
+type memoryIdempotencyStore struct {
+	mtx       sync.Mutex
+	entries   map[string]idempotencyEntry
+	now       func() time.Time
+	lastSweep time.Time
+}
+
+type idempotencyEntry struct {
+	resp    *recordedResponse // nil while in flight
+	expires time.Time
+}
+
+func newMemoryIdempotencyStore(now func() time.Time) *memoryIdempotencyStore {
+	return &memoryIdempotencyStore{entries: map[string]idempotencyEntry{}, now: now}
+}
+
+func (s *memoryIdempotencyStore) Begin(_ context.Context, key, fingerprint string, ttl time.Duration) (*recordedResponse, bool, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	now := s.now()
+	if now.Sub(s.lastSweep) > time.Minute {
+		for k, e := range s.entries {
+			if now.After(e.expires) {
+				delete(s.entries, k)
+			}
+		}
+		s.lastSweep = now
+	}
+	if e, ok := s.entries[key]; ok && now.Before(e.expires) {
+		return e.resp, false, nil
+	}
+	s.entries[key] = idempotencyEntry{expires: now.Add(ttl)}
+	return nil, true, nil
+}
+
+func (s *memoryIdempotencyStore) Finish(_ context.Context, key string, resp *recordedResponse, ttl time.Duration) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.entries[key] = idempotencyEntry{resp: resp, expires: s.now().Add(ttl)}
+	return nil
+}
+
+func (s *memoryIdempotencyStore) Abort(_ context.Context, key string) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	delete(s.entries, key)
+	return nil
+}
@@ -0,0 +1,48 @@
+package stringsvc
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIdempotencyMiddleware(t *testing.T) {
+	calls := 0
+	h := idempotencyMiddleware(newMemoryIdempotencyStore(time.Now), time.Hour, 64)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set(requestIDHeader, r.Header.Get(requestIDHeader))
+		if r.URL.Path == "/stream" {
+			w.(http.Flusher).Flush()
+		}
+		fmt.Fprint(w, calls)
+	}))
+	call := func(path, body, id string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", path, strings.NewReader(body))
+		r.Header.Set(idempotencyKeyHeader, "k1")
+		r.Header.Set(requestIDHeader, id)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	call("/links", `{"url":"https://example.com"}`, "first")
+	w := call("/links", `{"url":"https://example.com"}`, "second")
+	if w.Body.String() != "1" || w.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("retry: got %q, want the replayed 1", w.Body)
+	}
+	if id := w.Header().Get(requestIDHeader); id != "second" {
+		t.Errorf("retry: got request ID %q, want the retry's own", id)
+	}
+
+	if w := call("/links", strings.Repeat("x", 65), "big"); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized body: got %d, want 413", w.Code)
+	}
+
+	call("/stream", "{}", "stream")
+	if w := call("/stream", "{}", "stream again"); w.Header().Get("Idempotent-Replayed") != "" {
+		t.Error("a streamed response was replayed")
+	}
+}
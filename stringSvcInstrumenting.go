@@ -0,0 +1,60 @@
+package stringsvc
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/metrics"
+
+	"github.com/mcclayac/gokit/kit"
+	"github.com/mcclayac/gokit/svcerrors"
+)
+
+// newEndpointMetrics creates the endpoint instruments with f.
+func newEndpointMetrics(f metricsFactory, slo sloThresholds) endpointMetrics {
+	return endpointMetrics{
+		requests: f.Counter("requests", "Number of requests received.", "method", "code", "tenant"),
+		latency: f.Histogram("request_duration", "Time spent processing requests.",
+			slo.Buckets(defaultLatencyBuckets), "method", "code", "tenant"),
+		withinSLO: f.Counter("requests_within_slo",
+			"Number of requests served without a server error within their latency objective.", "method"),
+		slo: slo,
+	}
+}
+
+// endpointMetrics are the instruments recorded for every endpoint call.
+// requests and latency are labeled with the endpoint's method name and the
+// error code of the outcome ("OK" for success); withinSLO only by method.
+type endpointMetrics struct {
+	requests  metrics.Counter
+	latency   metrics.Histogram // seconds
+	withinSLO metrics.Counter
+	slo       sloThresholds
+}
+
+// instrumentingMiddleware records a call count and latency for the endpoint.
+// Business errors reported through endpoint.Failer are counted under their
+// own code, just like transport errors.
+//
+// Calls that finish within the endpoint's latency objective without a server
+// error are also counted in withinSLO, so the SLO burn rate is simply
+// 1 - withinSLO/requests, with no quantile estimation.
+func instrumentingMiddleware(m endpointMetrics, method string) endpoint.Middleware {
+	threshold := m.slo.For(method)
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+			defer func(begin time.Time) {
+				took := time.Since(begin)
+				code := kit.OutcomeCode(response, err)
+				lvs := []string{"method", method, "code", code, "tenant", tenantLabel(ctx)}
+				m.requests.With(lvs...).Add(1)
+				m.latency.With(lvs...).Observe(took.Seconds())
+				if took <= threshold && (code == "OK" || svcerrors.HTTPStatus(svcerrors.Code(code)) < 500) {
+					m.withinSLO.With("method", method).Add(1)
+				}
+			}(time.Now())
+			return next(ctx, request)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package stringsvc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mcclayac/gokit/testutil"
+)
+
+func TestInstrumentingMiddleware(t *testing.T) {
+	m := testutil.NewMetrics()
+	em := newEndpointMetrics(m, sloThresholds{Default: time.Minute})
+	e := instrumentingMiddleware(em, "uppercase")(makeUppercaseEndpoint(stringService{}))
+	ctx := context.Background()
+	for _, s := range []string{"hello", "world", ""} {
+		if _, err := e(ctx, uppercaseRequest{S: s}); err != nil {
+			t.Fatalf("uppercasing %q: %v", s, err)
+		}
+	}
+
+	m.ExpectCounter(t, "requests", 2, "method", "uppercase", "code", "OK", "tenant", "none")
+	m.ExpectCounter(t, "requests", 1, "method", "uppercase", "code", "STRING_EMPTY")
+	m.ExpectCounter(t, "requests", 3, "method", "uppercase")
+	m.ExpectObservations(t, "request_duration", 3, "method", "uppercase")
+	// STRING_EMPTY is the client's error, so it doesn't count against the
+	// objective.
+	m.ExpectCounter(t, "requests_within_slo", 3, "method", "uppercase")
+}
+
+func TestInstrumentingMiddlewareSLO(t *testing.T) {
+	m := testutil.NewMetrics()
+	em := newEndpointMetrics(m, sloThresholds{Default: time.Nanosecond})
+	slow := func(context.Context, interface{}) (interface{}, error) {
+		time.Sleep(time.Millisecond)
+		return uppercaseResponse{V: "HELLO"}, nil
+	}
+	if _, err := instrumentingMiddleware(em, "uppercase")(slow)(context.Background(), uppercaseRequest{S: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+
+	m.ExpectCounter(t, "requests", 1, "method", "uppercase", "code", "OK")
+	m.ExpectCounter(t, "requests_within_slo", 0, "method", "uppercase")
+}
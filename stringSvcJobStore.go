@@ -0,0 +1,269 @@
+package stringsvc
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mcclayac/gokit/svcerrors"
+)
+
+// jobStore keeps job snapshots. Implementations must be safe for
+// concurrent use.
+type jobStore interface {
+	// Put stores j, replacing the job with its ID, until expires.
+	Put(ctx context.Context, j job, expires time.Time) error
+	// Get returns the job with id, failing with NOT_FOUND if there is none
+	// or it has expired.
+	Get(ctx context.Context, id string) (job, error)
+	// List returns up to q.Limit unexpired jobs matching q, newest first.
+	List(ctx context.Context, q jobQuery) ([]job, error)
+	// Export calls fn with each job that hasn't expired and when it
+	// expires, stopping at the first error.
+	Export(ctx context.Context, fn func(j job, expires time.Time) error) error
+}
+
+// jobQuery selects the jobs one identity submitted.
+type jobQuery struct {
+	Identity string
+	Limit    int
+	After    *jobCursor // resume after this job
+}
+
+// jobCursor is the position of a job in the newest-first order jobs are
+// listed in.
+type jobCursor struct {
+	Created time.Time `json:"t"`
+	ID      string    `json:"id"`
+}
+
+// matches reports whether j is selected by q, ignoring q.Limit.
+func (q jobQuery) matches(j job) bool {
+	if j.Identity != q.Identity {
+		return false
+	}
+	return q.After == nil || j.Created.Before(q.After.Created) ||
+		(j.Created.Equal(q.After.Created) && j.ID < q.After.ID)
+}
+
+// newestJobFirst orders jobs as they are listed.
+func newestJobFirst(jobs []job) {
+	sort.Slice(jobs, func(i, k int) bool {
+		if !jobs[i].Created.Equal(jobs[k].Created) {
+			return jobs[i].Created.After(jobs[k].Created)
+		}
+		return jobs[i].ID > jobs[k].ID
+	})
+}
+
+func errNoJob(id string) error {
+	return svcerrors.Errorf(svcerrors.CodeNotFound, "no job %q", id)
+}
+
+// memoryJobStore keeps jobs in memory. Expired jobs are swept lazily as
+// jobs are stored.
+type memoryJobStore struct {
+	mtx       sync.Mutex
+	jobs      map[string]storedJob
+	now       func() time.Time
+	lastSweep time.Time
+}
+
+type storedJob struct {
+	job     job
+	expires time.Time
+}
+
+func newMemoryJobStore(now func() time.Time) *memoryJobStore {
+	return &memoryJobStore{jobs: map[string]storedJob{}, now: now}
+}
+
+func (s *memoryJobStore) Put(_ context.Context, j job, expires time.Time) error {
+	j.Deliveries = append([]webhookDelivery(nil), j.Deliveries...)
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.sweep()
+	s.jobs[j.ID] = storedJob{j, expires}
+	return nil
+}
+
+func (s *memoryJobStore) Get(_ context.Context, id string) (job, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	sj, ok := s.jobs[id]
+	if !ok || !s.now().Before(sj.expires) {
+		return job{}, errNoJob(id)
+	}
+	return sj.job, nil
+}
+
+func (s *memoryJobStore) List(_ context.Context, q jobQuery) ([]job, error) {
+	now := s.now()
+	jobs := []job{}
+	s.mtx.Lock()
+	for _, sj := range s.jobs {
+		if now.Before(sj.expires) && q.matches(sj.job) {
+			jobs = append(jobs, sj.job)
+		}
+	}
+	s.mtx.Unlock()
+	newestJobFirst(jobs)
+	if len(jobs) > q.Limit {
+		jobs = jobs[:q.Limit]
+	}
+	return jobs, nil
+}
+
+func (s *memoryJobStore) Export(_ context.Context, fn func(j job, expires time.Time) error) error {
+	now := s.now()
+	s.mtx.Lock()
+	jobs := make([]storedJob, 0, len(s.jobs))
+	for _, sj := range s.jobs {
+		if now.Before(sj.expires) {
+			jobs = append(jobs, sj)
+		}
+	}
+	s.mtx.Unlock()
+	for _, sj := range jobs {
+		if err := fn(sj.job, sj.expires); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sweep deletes expired jobs, at most once a minute. s.mtx must be held.
+func (s *memoryJobStore) sweep() {
+	now := s.now()
+	if now.Sub(s.lastSweep) < time.Minute {
+		return
+	}
+	for id, sj := range s.jobs {
+		if !now.Before(sj.expires) {
+			delete(s.jobs, id)
+		}
+	}
+	s.lastSweep = now
+}
+
+// sqlJobStore keeps jobs as JSON in the jobs table in PostgreSQL or SQLite,
+// with their creation and expiry as Unix nanoseconds in both. Expired jobs are deleted
+// at most once a minute as jobs are stored.
+type sqlJobStore struct {
+	db          *sql.DB
+	placeholder func(n int) string
+	now         func() time.Time
+
+	mtx       sync.Mutex
+	lastSweep time.Time
+}
+
+func newPostgresJobStore(db *sql.DB, now func() time.Time) *sqlJobStore {
+	return &sqlJobStore{db: db, placeholder: func(n int) string { return fmt.Sprintf("$%d", n) }, now: now}
+}
+
+func newSQLiteJobStore(db *sql.DB, now func() time.Time) *sqlJobStore {
+	return &sqlJobStore{db: db, placeholder: func(n int) string { return fmt.Sprintf("?%d", n) }, now: now}
+}
+
+func (s *sqlJobStore) Put(ctx context.Context, j job, expires time.Time) error {
+	b, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+	p := s.placeholder
+	if _, err := s.db.ExecContext(ctx, fmt.Sprintf(`
+		INSERT INTO jobs (id, body, expires_at, identity, created_at) VALUES (%s, %s, %s, %s, %s)
+		ON CONFLICT (id) DO UPDATE SET body = excluded.body, expires_at = excluded.expires_at`,
+		p(1), p(2), p(3), p(4), p(5)), j.ID, string(b), expires.UnixNano(), j.Identity, j.Created.UnixNano()); err != nil {
+		return err
+	}
+	now := s.now()
+	s.mtx.Lock()
+	sweep := now.Sub(s.lastSweep) >= time.Minute
+	if sweep {
+		s.lastSweep = now
+	}
+	s.mtx.Unlock()
+	if sweep {
+		_, err = s.db.ExecContext(ctx, `DELETE FROM jobs WHERE expires_at <= `+p(1), now.UnixNano())
+	}
+	return err
+}
+
+func (s *sqlJobStore) Get(ctx context.Context, id string) (job, error) {
+	var body string
+	p := s.placeholder
+	err := s.db.QueryRowContext(ctx, fmt.Sprintf(`SELECT body FROM jobs WHERE id = %s AND expires_at > %s`, p(1), p(2)),
+		id, s.now().UnixNano()).Scan(&body)
+	if err == sql.ErrNoRows {
+		return job{}, errNoJob(id)
+	}
+	if err != nil {
+		return job{}, err
+	}
+	var j job
+	err = json.Unmarshal([]byte(body), &j)
+	return j, err
+}
+
+func (s *sqlJobStore) List(ctx context.Context, q jobQuery) ([]job, error) {
+	p := s.placeholder
+	query := fmt.Sprintf(`SELECT body FROM jobs WHERE identity = %s AND expires_at > %s`, p(1), p(2))
+	args := []interface{}{q.Identity, s.now().UnixNano()}
+	if q.After != nil {
+		query += fmt.Sprintf(` AND (created_at, id) < (%s, %s)`, p(3), p(4))
+		args = append(args, q.After.Created.UnixNano(), q.After.ID)
+	}
+	args = append(args, q.Limit)
+	query += ` ORDER BY created_at DESC, id DESC LIMIT ` + p(len(args))
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	jobs := []job{}
+	for rows.Next() {
+		var (
+			body string
+			j    job
+		)
+		if err := rows.Scan(&body); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(body), &j); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, rows.Err()
+}
+
+func (s *sqlJobStore) Export(ctx context.Context, fn func(j job, expires time.Time) error) error {
+	rows, err := s.db.QueryContext(ctx, `SELECT body, expires_at FROM jobs WHERE expires_at > `+s.placeholder(1), s.now().UnixNano())
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			body    string
+			expires int64
+			j       job
+		)
+		if err := rows.Scan(&body, &expires); err != nil {
+			return err
+		}
+		if err := json.Unmarshal([]byte(body), &j); err != nil {
+			return err
+		}
+		if err := fn(j, time.Unix(0, expires).UTC()); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,417 @@
+package stringsvc
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	httptransport "github.com/go-kit/kit/transport/http"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/mcclayac/gokit/svcerrors"
+)
+
+// jobsConfig configures the asynchronous job API.
+type jobsConfig struct {
+	Workers   int           // jobs run concurrently
+	QueueSize int           // jobs waiting beyond this many are rejected
+	Retention time.Duration // how long finished jobs can be fetched
+	Webhooks  webhookConfig
+}
+
+type jobStatus string
+
+const (
+	jobQueued    jobStatus = "queued"
+	jobRunning   jobStatus = "running"
+	jobCompleted jobStatus = "completed"
+)
+
+// jobOperation is one call in a batch job: the method to call and its
+// request, as it would be sent to the method's own endpoint.
+type jobOperation struct {
+	Method  string          `json:"method" validate:"required"`
+	Request json.RawMessage `json:"request"`
+}
+
+type submitJobRequest struct {
+	Operations []jobOperation `json:"operations" validate:"min=1,max=1000"`
+	// Callback, if set, is POSTed the job once it completes.
+	Callback string `json:"callback_url" validate:"max=2048,pattern=^(https?://.+)?$"`
+}
+
+type submitJobResponse struct {
+	ID     string    `json:"id" xml:"id"`
+	Status jobStatus `json:"status" xml:"status"`
+}
+
+// StatusCode implements httptransport.StatusCoder.
+func (submitJobResponse) StatusCode() int { return http.StatusAccepted }
+
+// Headers implements httptransport.Headerer.
+func (r submitJobResponse) Headers() http.Header {
+	return http.Header{"Location": {"/jobs/" + r.ID}}
+}
+
+type getJobRequest struct {
+	ID string `json:"id" validate:"required"`
+}
+
+type listJobsRequest struct {
+	Limit     int `json:"limit" validate:"min=1,max=1000"`
+	PageToken string
+}
+
+type listJobsResponse struct {
+	Jobs []job `json:"jobs" xml:"jobs>job"`
+	// NextPageToken is passed as page_token to get the jobs after these.
+	// It is empty on the last page.
+	NextPageToken string `json:"next_page_token,omitempty" xml:"next_page_token,omitempty"`
+	next          string // the next page's URL
+}
+
+// Headers implements httptransport.Headerer.
+func (r listJobsResponse) Headers() http.Header { return pageLinkHeader(r.next) }
+
+// job is a submitted batch and, once it has run, its results. It is also
+// the response to GET /jobs/{id}.
+type job struct {
+	ID       string      `json:"id" xml:"id"`
+	Status   jobStatus   `json:"status" xml:"status"`
+	Created  time.Time   `json:"created" xml:"created"`
+	Identity string      `json:"identity" xml:"identity"` // who submitted it
+	Started  *time.Time  `json:"started,omitempty" xml:"started,omitempty"`
+	Finished *time.Time  `json:"finished,omitempty" xml:"finished,omitempty"`
+	Results  []jobResult `json:"results,omitempty" xml:"results>result,omitempty"`
+
+	Callback   string            `json:"callback_url,omitempty" xml:"callback_url,omitempty"`
+	Deliveries []webhookDelivery `json:"deliveries,omitempty" xml:"deliveries>delivery,omitempty"`
+
+	ctx  context.Context // carries the submitting request's identity
+	reqs []jobCall
+}
+
+// jobResult is the outcome of one operation, in the order submitted.
+type jobResult struct {
+	Result interface{}      `json:"result,omitempty" xml:"result,omitempty"`
+	Err    *svcerrors.Error `json:"err,omitempty" xml:"err,omitempty"`
+}
+
+type jobCall struct {
+	e       endpoint.Endpoint
+	request interface{}
+}
+
+// unfinishedJobTTL is how long a job that never finishes, because the
+// process running it died, is kept.
+const unfinishedJobTTL = 24 * time.Hour
+
+// randomJobID returns a random 128-bit job ID in hex.
+func randomJobID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// jobQueue runs submitted jobs on a fixed pool of workers. A snapshot of
+// each job is saved to the store whenever it changes, and kept for
+// retrieval until the retention period after the job finishes. A job is
+// only ever changed by one goroutine at a time: its worker, then its
+// callback's.
+type jobQueue struct {
+	endpoints map[string]messageEndpoint
+	webhooks  *webhookSender // nil when callbacks are disabled
+	store     jobStore
+	retention time.Duration
+	now       func() time.Time
+	newID     func() string
+	logger    log.Logger
+	pending   chan *job
+	ctx       context.Context
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+}
+
+func newJobQueue(cfg jobsConfig, endpoints map[string]messageEndpoint, store jobStore, now func() time.Time, newID func() string, logger log.Logger) *jobQueue {
+	ctx, cancel := context.WithCancel(context.Background())
+	q := &jobQueue{
+		endpoints: endpoints,
+		store:     store,
+		retention: cfg.Retention,
+		now:       now,
+		newID:     newID,
+		logger:    logger,
+		pending:   make(chan *job, cfg.QueueSize),
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+	if cfg.Webhooks.Secret != "" {
+		q.webhooks = newWebhookSender(cfg.Webhooks, now)
+	}
+	for i := 0; i < cfg.Workers; i++ {
+		q.wg.Add(1)
+		go func() {
+			defer q.wg.Done()
+			for {
+				select {
+				case j := <-q.pending:
+					q.run(j)
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	return q
+}
+
+// Submit decodes the operations in req and queues them as a job. Every
+// operation must name a known method and carry a well-formed request, or
+// nothing is queued.
+func (q *jobQueue) Submit(ctx context.Context, req submitJobRequest) (submitJobResponse, error) {
+	if req.Callback != "" && q.webhooks == nil {
+		return submitJobResponse{}, svcerrors.New(svcerrors.CodeInvalidArgument, "job callbacks are not enabled")
+	}
+	ops := req.Operations
+	reqs := make([]jobCall, len(ops))
+	var violations []svcerrors.FieldViolation
+	for i, op := range ops {
+		je, ok := q.endpoints[op.Method]
+		if !ok {
+			violations = append(violations, svcerrors.FieldViolation{
+				Field:   fmt.Sprintf("operations[%d].method", i),
+				Rule:    "method",
+				Message: fmt.Sprintf("unknown method %q", op.Method),
+			})
+			continue
+		}
+		request, err := je.decodeJSON(ctx, op.Request)
+		if err != nil {
+			violations = append(violations, svcerrors.FieldViolation{
+				Field:   fmt.Sprintf("operations[%d].request", i),
+				Rule:    "decode",
+				Message: err.Error(),
+			})
+			continue
+		}
+		reqs[i] = jobCall{je.e, request}
+	}
+	if len(violations) > 0 {
+		err := svcerrors.New(svcerrors.CodeInvalidArgument, "job has invalid operations")
+		err.Fields = violations
+		return submitJobResponse{}, err
+	}
+
+	j := &job{
+		ID:       q.newID(),
+		Status:   jobQueued,
+		Created:  q.now().UTC(),
+		Identity: identity(ctx),
+		Callback: req.Callback,
+		ctx:      jobContext(q.ctx, ctx),
+		reqs:     reqs,
+	}
+
+	// The job is saved before it is queued so that its worker's saves
+	// come after this one. If the queue fills up in between, the saved
+	// job is never returned to anyone and just expires.
+	if len(q.pending) == cap(q.pending) {
+		return submitJobResponse{}, svcerrors.ErrJobQueueFull
+	}
+	if err := q.store.Put(ctx, *j, j.Created.Add(unfinishedJobTTL)); err != nil {
+		return submitJobResponse{}, err
+	}
+	resp := submitJobResponse{j.ID, j.Status} // j is the worker's once queued
+	select {
+	case q.pending <- j:
+	default:
+		return submitJobResponse{}, svcerrors.ErrJobQueueFull
+	}
+	return resp, nil
+}
+
+// Get returns the job with the given ID, if the caller submitted it. Other
+// callers' jobs are NOT_FOUND, as if they didn't exist.
+func (q *jobQueue) Get(ctx context.Context, id string) (job, error) {
+	j, err := q.store.Get(ctx, id)
+	if err != nil {
+		return job{}, err
+	}
+	if j.Identity != identity(ctx) {
+		return job{}, errNoJob(id)
+	}
+	return j, nil
+}
+
+// save stores a snapshot of j. Failing to is logged: the job carries on,
+// and only its callers polling for it see the older snapshot.
+func (q *jobQueue) save(j *job) {
+	expires := j.Created.Add(unfinishedJobTTL)
+	if j.Finished != nil {
+		expires = j.Finished.Add(q.retention)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := q.store.Put(ctx, *j, expires); err != nil {
+		level.Error(q.logger).Log("msg", "saving job", "id", j.ID, "err", err)
+	}
+}
+
+// List returns the jobs the caller submitted that are still kept, newest
+// first and without their results or deliveries, which GET /jobs/{id}
+// returns.
+func (q *jobQueue) List(ctx context.Context, req listJobsRequest) (listJobsResponse, error) {
+	jq := jobQuery{Identity: identity(ctx), Limit: req.Limit}
+	if req.PageToken != "" {
+		var c jobCursor
+		if err := decodeCursor(req.PageToken, &c, func() bool { return c.ID != "" }); err != nil {
+			return listJobsResponse{}, err
+		}
+		jq.After = &c
+	}
+	jobs, err := q.store.List(ctx, jq)
+	if err != nil {
+		return listJobsResponse{}, err
+	}
+	for i := range jobs {
+		jobs[i].Results, jobs[i].Deliveries = nil, nil
+	}
+	resp := listJobsResponse{Jobs: jobs}
+	if len(jobs) == req.Limit {
+		last := jobs[len(jobs)-1]
+		resp.NextPageToken = encodeCursor(jobCursor{last.Created, last.ID})
+		resp.next = nextPageURL(ctx, resp.NextPageToken)
+	}
+	return resp, nil
+}
+
+// Close stops the workers, canceling the operations of running jobs and
+// pending callbacks. Jobs still queued are abandoned.
+func (q *jobQueue) Close() {
+	q.cancel()
+	q.wg.Wait()
+}
+
+func (q *jobQueue) run(j *job) {
+	started := q.now().UTC()
+	j.Status, j.Started = jobRunning, &started
+	q.save(j)
+
+	results := make([]jobResult, len(j.reqs))
+	for i, c := range j.reqs {
+		response, err := c.e(j.ctx, c.request)
+		if f, ok := response.(endpoint.Failer); ok && err == nil {
+			err = f.Failed()
+		}
+		if err != nil {
+			reportUnexpected(j.ctx, err)
+			results[i] = jobResult{Err: svcerrors.From(err)}
+			continue
+		}
+		results[i] = jobResult{Result: response}
+	}
+
+	finished := q.now().UTC()
+	j.Status, j.Finished, j.Results = jobCompleted, &finished, results
+	j.reqs = nil
+	q.save(j)
+
+	body, err := json.Marshal(j)
+	if j.Callback != "" && err == nil {
+		q.wg.Add(1)
+		go func() {
+			defer q.wg.Done()
+			q.webhooks.Deliver(q.ctx, j.Callback, body, func(d webhookDelivery) {
+				j.Deliveries = append(j.Deliveries, d)
+				q.save(j)
+			})
+		}()
+	}
+}
+
+// jobContext returns a context that is canceled with parent but carries the
+// request ID, caller identity, tenant, trace and appEnv of the submitting
+// request, so that a job's operations are authorized, logged, audited,
+// traced and limited to the tenant's operations like direct calls.
+func jobContext(parent, submit context.Context) context.Context {
+	ctx := trace.ContextWithSpanContext(parent, trace.SpanContextFromContext(submit))
+	for _, k := range []interface{}{
+		httptransport.ContextKeyRequestXRequestID,
+		httptransport.ContextKeyRequestXForwardedFor,
+		httptransport.ContextKeyRequestRemoteAddr,
+		principalKey{},
+		tenantKey{},
+		tenantOverridesKey{},
+		appEnvKey{},
+	} {
+		ctx = context.WithValue(ctx, k, submit.Value(k))
+	}
+	return ctx
+}
+
+func makeSubmitJobEndpoint(q *jobQueue) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(submitJobRequest)
+		return q.Submit(ctx, req)
+	}
+}
+
+func makeGetJobEndpoint(q *jobQueue) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(getJobRequest)
+		return q.Get(ctx, req.ID)
+	}
+}
+
+func makeListJobsEndpoint(q *jobQueue) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		return q.List(ctx, request.(listJobsRequest))
+	}
+}
+
+// decodeSubmitJobRequest only accepts JSON, since the operations' requests
+// are embedded in the job as they are.
+func decodeSubmitJobRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	if ct := r.Header.Get("Content-Type"); ct != "" && !strings.HasPrefix(ct, "application/json") {
+		return nil, svcerrors.New(svcerrors.CodeUnsupportedMediaType, "jobs must be submitted as application/json")
+	}
+	var request submitJobRequest
+	if err := decodeBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+func decodeGetJobRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return getJobRequest{ID: strings.TrimPrefix(r.URL.Path, "/jobs/")}, nil
+}
+
+// decodeListJobsRequest reads the paging query parameters.
+func decodeListJobsRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	page, err := decodePageParams(r)
+	if err != nil {
+		return nil, err
+	}
+	return listJobsRequest{Limit: page.Limit, PageToken: page.Token}, nil
+}
+
+// jobsHandler serves /jobs: GET lists the caller's jobs, and anything else
+// submits one.
+func jobsHandler(submit, list http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			list.ServeHTTP(w, r)
+			return
+		}
+		submit.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,70 @@
+package stringsvc
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+
+	"github.com/mcclayac/gokit/svcerrors"
+)
+
+func newTestJobQueue(t *testing.T) *jobQueue {
+	t.Helper()
+	endpoints := map[string]messageEndpoint{
+		"uppercase": {tenantOperationsMiddleware("uppercase")(makeUppercaseEndpoint(stringService{})), decodeUppercaseMessage},
+	}
+	q := newJobQueue(jobsConfig{Workers: 1, QueueSize: 1, Retention: time.Hour}, endpoints, newMemoryJobStore(time.Now), time.Now, randomJobID, log.NewNopLogger())
+	t.Cleanup(q.Close)
+	return q
+}
+
+// waitForJob polls for the job until it completes.
+func waitForJob(t *testing.T, q *jobQueue, ctx context.Context, id string) job {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		j, err := q.Get(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if j.Status == jobCompleted {
+			return j
+		}
+	}
+	t.Fatalf("job %s didn't complete", id)
+	return job{}
+}
+
+func TestJobTenantOperations(t *testing.T) {
+	q := newTestJobQueue(t)
+	ctx := context.WithValue(context.Background(), tenantKey{}, tenant{ID: "payments"})
+	ctx = context.WithValue(ctx, tenantOverridesKey{}, tenantOverrides{Operations: []string{"count"}})
+	resp, err := q.Submit(ctx, submitJobRequest{Operations: []jobOperation{
+		{Method: "uppercase", Request: json.RawMessage(`{"s":"hello"}`)},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	j := waitForJob(t, q, ctx, resp.ID)
+	if len(j.Results) != 1 || j.Results[0].Err == nil || j.Results[0].Err.Code != svcerrors.CodePermissionDenied {
+		t.Errorf("got results %+v, want PERMISSION_DENIED", j.Results)
+	}
+}
+
+func TestJobGetOtherCaller(t *testing.T) {
+	q := newTestJobQueue(t)
+	owner := context.WithValue(context.Background(), principalKey{}, issuedToken{Subject: "billing"})
+	resp, err := q.Submit(owner, submitJobRequest{Operations: []jobOperation{
+		{Method: "uppercase", Request: json.RawMessage(`{"s":"hello"}`)},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForJob(t, q, owner, resp.ID)
+	other := context.WithValue(context.Background(), principalKey{}, issuedToken{Subject: "search"})
+	if _, err := q.Get(other, resp.ID); svcerrors.CodeOf(err) != svcerrors.CodeNotFound {
+		t.Errorf("another caller's Get: got %v, want NOT_FOUND", err)
+	}
+}
@@ -0,0 +1,545 @@
+package stringsvc
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+
+	"github.com/mcclayac/gokit/svcerrors"
+)
+
+// With -auth-keys, API keys, the client credentials callers exchange for
+// tokens at POST /auth/token, are managed through the admin API instead
+// of being edited into the -auth-clients file:
+//
+//	POST   /admin/keys                {"id": "billing-sync", "scopes": ["uppercase"]}
+//	GET    /admin/keys
+//	POST   /admin/keys/{id}/rotate
+//	PUT    /admin/keys/{id}/scopes    {"scopes": ["uppercase", "count"]}
+//	DELETE /admin/keys/{id}
+//
+// Creating or rotating a key returns its secret, which is shown only then;
+// the storage backend keeps its hash. Rotating replaces the secret, and
+// the old one can't get tokens from then on, though tokens it already got
+// last out their lifetime. Revoking a key ends its tokens too. Revoked
+// keys stay listed, so that their history can be traced.
+//
+// The endpoints need a bearer token with their scope, admin_keys_create
+// and so on, or "admin_keys_*" for all of them, so the operators who
+// manage keys get their tokens as clients in the -auth-clients file. Each
+// call is an operation of its own, so the audit log records every change
+// with the operator who made it. An operator can only give a key scopes
+// their own token has.
+
+// apiKey is a stored API key.
+type apiKey struct {
+	ID         string    `json:"id"`
+	SecretHash string    `json:"secret_hash"`
+	Scopes     []string  `json:"scopes"`
+	Tenant     string    `json:"tenant,omitempty"`
+	Created    time.Time `json:"created"`
+	Rotated    time.Time `json:"rotated,omitempty"` // zero if never
+	Revoked    time.Time `json:"revoked,omitempty"` // zero if active
+}
+
+func (k apiKey) revoked() bool { return !k.Revoked.IsZero() }
+
+// client is the key as an auth client.
+func (k apiKey) client() authClient {
+	return authClient{ID: k.ID, SecretHash: k.SecretHash, Scopes: k.Scopes, Tenant: k.Tenant}
+}
+
+// keyStore keeps API keys by ID. Implementations must be safe for
+// concurrent use.
+type keyStore interface {
+	// Create stores k, failing with INVALID_ARGUMENT if its ID is taken.
+	Create(ctx context.Context, k apiKey) error
+	// Get returns the key with id, failing with NOT_FOUND if there is none.
+	Get(ctx context.Context, id string) (apiKey, error)
+	// Update replaces the stored key with k's ID, failing with NOT_FOUND
+	// if there is none.
+	Update(ctx context.Context, k apiKey) error
+	// List returns every key, by ID.
+	List(ctx context.Context) ([]apiKey, error)
+}
+
+func errNoKey(id string) error {
+	return svcerrors.Errorf(svcerrors.CodeNotFound, "no key %q", id)
+}
+
+func errKeyTaken(id string) error {
+	return svcerrors.Errorf(svcerrors.CodeInvalidArgument, "key %q exists", id)
+}
+
+// memoryKeyStore keeps keys in memory.
+type memoryKeyStore struct {
+	mtx  sync.RWMutex
+	keys map[string]apiKey
+}
+
+func newMemoryKeyStore() *memoryKeyStore {
+	return &memoryKeyStore{keys: map[string]apiKey{}}
+}
+
+func (s *memoryKeyStore) Create(_ context.Context, k apiKey) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if _, ok := s.keys[k.ID]; ok {
+		return errKeyTaken(k.ID)
+	}
+	s.keys[k.ID] = k
+	return nil
+}
+
+func (s *memoryKeyStore) Get(_ context.Context, id string) (apiKey, error) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	k, ok := s.keys[id]
+	if !ok {
+		return apiKey{}, errNoKey(id)
+	}
+	return k, nil
+}
+
+func (s *memoryKeyStore) Update(_ context.Context, k apiKey) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if _, ok := s.keys[k.ID]; !ok {
+		return errNoKey(k.ID)
+	}
+	s.keys[k.ID] = k
+	return nil
+}
+
+func (s *memoryKeyStore) List(context.Context) ([]apiKey, error) {
+	s.mtx.RLock()
+	keys := make([]apiKey, 0, len(s.keys))
+	for _, k := range s.keys {
+		keys = append(keys, k)
+	}
+	s.mtx.RUnlock()
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+	return keys, nil
+}
+
+// sqlKeyStore keeps keys in the api_keys table in PostgreSQL or SQLite.
+// scopes is space-separated; times are in Unix nanoseconds, 0 if unset.
+type sqlKeyStore struct {
+	db          *sql.DB
+	placeholder func(n int) string
+}
+
+func newPostgresKeyStore(db *sql.DB) *sqlKeyStore {
+	return &sqlKeyStore{db, func(n int) string { return fmt.Sprintf("$%d", n) }}
+}
+
+func newSQLiteKeyStore(db *sql.DB) *sqlKeyStore {
+	return &sqlKeyStore{db, func(n int) string { return fmt.Sprintf("?%d", n) }}
+}
+
+// keyNanos is t in Unix nanoseconds, 0 if it is zero.
+func keyNanos(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func keyTime(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n).UTC()
+}
+
+func (s *sqlKeyStore) Create(ctx context.Context, k apiKey) error {
+	p := s.placeholder
+	res, err := s.db.ExecContext(ctx, fmt.Sprintf(`
+		INSERT INTO api_keys (id, secret_hash, scopes, tenant, created_at, rotated_at, revoked_at)
+		VALUES (%s, %s, %s, %s, %s, %s, %s)
+		ON CONFLICT (id) DO NOTHING`, p(1), p(2), p(3), p(4), p(5), p(6), p(7)),
+		k.ID, k.SecretHash, strings.Join(k.Scopes, " "), k.Tenant, keyNanos(k.Created), keyNanos(k.Rotated), keyNanos(k.Revoked))
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return errKeyTaken(k.ID)
+	}
+	return nil
+}
+
+const keyColumns = `id, secret_hash, scopes, tenant, created_at, rotated_at, revoked_at`
+
+func scanKey(scan func(...interface{}) error) (apiKey, error) {
+	var (
+		k                         apiKey
+		scopes                    string
+		created, rotated, revoked int64
+	)
+	if err := scan(&k.ID, &k.SecretHash, &scopes, &k.Tenant, &created, &rotated, &revoked); err != nil {
+		return apiKey{}, err
+	}
+	k.Scopes = strings.Fields(scopes)
+	k.Created, k.Rotated, k.Revoked = keyTime(created), keyTime(rotated), keyTime(revoked)
+	return k, nil
+}
+
+func (s *sqlKeyStore) Get(ctx context.Context, id string) (apiKey, error) {
+	k, err := scanKey(s.db.QueryRowContext(ctx, `SELECT `+keyColumns+` FROM api_keys WHERE id = `+s.placeholder(1), id).Scan)
+	if err == sql.ErrNoRows {
+		return apiKey{}, errNoKey(id)
+	}
+	return k, err
+}
+
+func (s *sqlKeyStore) Update(ctx context.Context, k apiKey) error {
+	p := s.placeholder
+	res, err := s.db.ExecContext(ctx, fmt.Sprintf(`
+		UPDATE api_keys SET secret_hash = %s, scopes = %s, tenant = %s, rotated_at = %s, revoked_at = %s
+		WHERE id = %s`, p(1), p(2), p(3), p(4), p(5), p(6)),
+		k.SecretHash, strings.Join(k.Scopes, " "), k.Tenant, keyNanos(k.Rotated), keyNanos(k.Revoked), k.ID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return errNoKey(k.ID)
+	}
+	return nil
+}
+
+func (s *sqlKeyStore) List(ctx context.Context) ([]apiKey, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+keyColumns+` FROM api_keys ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var keys []apiKey
+	for rows.Next() {
+		k, err := scanKey(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// keySecretPrefix marks API key secrets, as tokenPrefix marks tokens.
+const keySecretPrefix = "ssk_"
+
+func newKeySecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return keySecretPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// keyAdmin carries out the key admin calls.
+type keyAdmin struct {
+	store   keyStore
+	crypto  CryptoService
+	tenants *tenantSet // nil if the service isn't multi-tenant
+	now     func() time.Time
+}
+
+// newSecret returns a new secret and its hash.
+func (a keyAdmin) newSecret(ctx context.Context) (string, string, error) {
+	secret, err := newKeySecret()
+	if err != nil {
+		return "", "", err
+	}
+	hash, err := a.crypto.Hash(ctx, secret)
+	if err != nil {
+		return "", "", err
+	}
+	return secret, hash, nil
+}
+
+// checkScopes checks the scopes requested for a key. The caller must hold
+// each of them, or it could mint a key worth more than its own token.
+func (a keyAdmin) checkScopes(ctx context.Context, scopes []string) error {
+	if len(scopes) == 0 {
+		return svcerrors.New(svcerrors.CodeInvalidArgument, "a key needs at least one scope")
+	}
+	caller, _ := principal(ctx)
+	for _, s := range scopes {
+		if s == "" || strings.ContainsAny(s, " \t\n") {
+			return svcerrors.Errorf(svcerrors.CodeInvalidArgument, "malformed scope %q", s)
+		}
+		if !hasScope(caller.Scopes, s) {
+			return svcerrors.Errorf(svcerrors.CodePermissionDenied, "can't grant scope %q without holding it", s)
+		}
+	}
+	return nil
+}
+
+func (a keyAdmin) create(ctx context.Context, req createKeyRequest) (keyResponse, error) {
+	if err := a.checkScopes(ctx, req.Scopes); err != nil {
+		return keyResponse{}, err
+	}
+	if strings.ContainsAny(req.ID, "/ \t\n") {
+		// keyPath couldn't route calls for the key.
+		return keyResponse{}, svcerrors.Errorf(svcerrors.CodeInvalidArgument, "malformed key ID %q: it may not contain slashes or spaces", req.ID)
+	}
+	if req.Tenant != "" {
+		if a.tenants == nil {
+			return keyResponse{}, svcerrors.New(svcerrors.CodeInvalidArgument, "the service has no tenants")
+		}
+		if _, ok := a.tenants.byID[req.Tenant]; !ok {
+			return keyResponse{}, svcerrors.Errorf(svcerrors.CodeInvalidArgument, "unknown tenant %q", req.Tenant)
+		}
+	}
+	if req.ID == "" {
+		id, err := newShortCode()
+		if err != nil {
+			return keyResponse{}, err
+		}
+		req.ID = "key-" + id
+	}
+	secret, hash, err := a.newSecret(ctx)
+	if err != nil {
+		return keyResponse{}, err
+	}
+	k := apiKey{ID: req.ID, SecretHash: hash, Scopes: req.Scopes, Tenant: req.Tenant, Created: a.now().UTC()}
+	if err := a.store.Create(ctx, k); err != nil {
+		return keyResponse{}, err
+	}
+	return keyResponse{Key: newKeyView(k), Secret: secret}, nil
+}
+
+// update applies change to the active key with id.
+func (a keyAdmin) update(ctx context.Context, id string, change func(*apiKey) error) (apiKey, error) {
+	k, err := a.store.Get(ctx, id)
+	if err != nil {
+		return apiKey{}, err
+	}
+	if k.revoked() {
+		return apiKey{}, svcerrors.Errorf(svcerrors.CodeInvalidArgument, "key %q is revoked", id)
+	}
+	if err := change(&k); err != nil {
+		return apiKey{}, err
+	}
+	return k, a.store.Update(ctx, k)
+}
+
+func (a keyAdmin) rotate(ctx context.Context, id string) (keyResponse, error) {
+	secret, hash, err := a.newSecret(ctx)
+	if err != nil {
+		return keyResponse{}, err
+	}
+	k, err := a.update(ctx, id, func(k *apiKey) error {
+		k.SecretHash, k.Rotated = hash, a.now().UTC()
+		return nil
+	})
+	if err != nil {
+		return keyResponse{}, err
+	}
+	return keyResponse{Key: newKeyView(k), Secret: secret}, nil
+}
+
+func (a keyAdmin) scope(ctx context.Context, id string, scopes []string) (keyResponse, error) {
+	if err := a.checkScopes(ctx, scopes); err != nil {
+		return keyResponse{}, err
+	}
+	k, err := a.update(ctx, id, func(k *apiKey) error {
+		k.Scopes = scopes
+		return nil
+	})
+	if err != nil {
+		return keyResponse{}, err
+	}
+	return keyResponse{Key: newKeyView(k)}, nil
+}
+
+func (a keyAdmin) revoke(ctx context.Context, id string) (keyResponse, error) {
+	k, err := a.update(ctx, id, func(k *apiKey) error {
+		k.Revoked = a.now().UTC()
+		return nil
+	})
+	if err != nil {
+		return keyResponse{}, err
+	}
+	return keyResponse{Key: newKeyView(k)}, nil
+}
+
+// keyView is a key as the admin API shows it, without its hash.
+type keyView struct {
+	ID      string     `json:"id" xml:"id"`
+	Scopes  []string   `json:"scopes" xml:"scopes>scope"`
+	Tenant  string     `json:"tenant,omitempty" xml:"tenant,omitempty"`
+	Created time.Time  `json:"created" xml:"created"`
+	Rotated *time.Time `json:"rotated,omitempty" xml:"rotated,omitempty"`
+	Revoked *time.Time `json:"revoked,omitempty" xml:"revoked,omitempty"`
+}
+
+func newKeyView(k apiKey) keyView {
+	v := keyView{ID: k.ID, Scopes: k.Scopes, Tenant: k.Tenant, Created: k.Created}
+	if !k.Rotated.IsZero() {
+		v.Rotated = &k.Rotated
+	}
+	if k.revoked() {
+		v.Revoked = &k.Revoked
+	}
+	return v
+}
+
+type createKeyRequest struct {
+	ID     string   `json:"id,omitempty" xml:"id,omitempty" validate:"max=128"`
+	Scopes []string `json:"scopes" xml:"scopes>scope" validate:"required,max=64"`
+	Tenant string   `json:"tenant,omitempty" xml:"tenant,omitempty" validate:"max=63"`
+}
+
+type keyIDRequest struct {
+	ID string `json:"id" xml:"id" validate:"required,max=128"`
+}
+
+type scopeKeyRequest struct {
+	ID     string   `json:"id" xml:"id" validate:"required,max=128"`
+	Scopes []string `json:"scopes" xml:"scopes>scope" validate:"required,max=64"`
+}
+
+type listKeysRequest struct{}
+
+// keyResponse is a key, with its secret when it was just created or
+// rotated.
+type keyResponse struct {
+	Key    keyView `json:"key" xml:"key"`
+	Secret string  `json:"secret,omitempty" xml:"secret,omitempty"`
+}
+
+// Headers implements httptransport.Headerer: responses may carry a
+// secret, so no cache may keep them.
+func (keyResponse) Headers() http.Header {
+	return http.Header{"Cache-Control": {"no-store"}}
+}
+
+type listKeysResponse struct {
+	Keys []keyView `json:"keys" xml:"keys>key"`
+}
+
+// requirePrincipal refuses calls that weren't authenticated with a bearer
+// token, for endpoints that must never be called anonymously, even if
+// -auth-public lists them.
+func requirePrincipal(next endpoint.Endpoint) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		if _, ok := principal(ctx); !ok {
+			return nil, svcerrors.New(svcerrors.CodeUnauthenticated, "this endpoint needs a bearer token")
+		}
+		return next(ctx, request)
+	}
+}
+
+func makeCreateKeyEndpoint(a keyAdmin) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		return a.create(ctx, request.(createKeyRequest))
+	}
+}
+
+func makeListKeysEndpoint(a keyAdmin) endpoint.Endpoint {
+	return func(ctx context.Context, _ interface{}) (interface{}, error) {
+		keys, err := a.store.List(ctx)
+		if err != nil {
+			return nil, err
+		}
+		resp := listKeysResponse{Keys: make([]keyView, 0, len(keys))}
+		for _, k := range keys {
+			resp.Keys = append(resp.Keys, newKeyView(k))
+		}
+		return resp, nil
+	}
+}
+
+func makeRotateKeyEndpoint(a keyAdmin) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		return a.rotate(ctx, request.(keyIDRequest).ID)
+	}
+}
+
+func makeScopeKeyEndpoint(a keyAdmin) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(scopeKeyRequest)
+		return a.scope(ctx, req.ID, req.Scopes)
+	}
+}
+
+func makeRevokeKeyEndpoint(a keyAdmin) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		return a.revoke(ctx, request.(keyIDRequest).ID)
+	}
+}
+
+// keyPath splits a /admin/keys path into the key ID and what follows it.
+func keyPath(path string) (id, rest string) {
+	p := strings.Trim(strings.TrimPrefix(path, "/admin/keys"), "/")
+	if i := strings.IndexByte(p, '/'); i >= 0 {
+		return p[:i], p[i+1:]
+	}
+	return p, ""
+}
+
+func decodeCreateKeyRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request createKeyRequest
+	if err := decodeBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+func decodeListKeysRequest(context.Context, *http.Request) (interface{}, error) {
+	return listKeysRequest{}, nil
+}
+
+func decodeKeyIDRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	id, _ := keyPath(r.URL.Path)
+	return keyIDRequest{ID: id}, nil
+}
+
+func decodeScopeKeyRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request scopeKeyRequest
+	if err := decodeBody(r, &request); err != nil {
+		return nil, err
+	}
+	request.ID, _ = keyPath(r.URL.Path)
+	return request, nil
+}
+
+// keysRouter sends each /admin/keys call to the handler of its method and
+// path.
+type keysRouter struct {
+	create, list, rotate, scope, revoke http.Handler
+}
+
+func (k keysRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id, rest := keyPath(r.URL.Path)
+	var h http.Handler
+	switch {
+	case id == "" && r.Method == http.MethodPost:
+		h = k.create
+	case id == "" && r.Method == http.MethodGet:
+		h = k.list
+	case id != "" && rest == "rotate" && r.Method == http.MethodPost:
+		h = k.rotate
+	case id != "" && rest == "scopes" && r.Method == http.MethodPut:
+		h = k.scope
+	case id != "" && rest == "" && r.Method == http.MethodDelete:
+		h = k.revoke
+	default:
+		encodeError(r.Context(), svcerrors.Errorf(svcerrors.CodeNotFound, "no route %s %s", r.Method, r.URL.Path), w)
+		return
+	}
+	h.ServeHTTP(w, r)
+}
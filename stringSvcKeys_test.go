@@ -0,0 +1,99 @@
+package stringsvc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mcclayac/gokit/svcerrors"
+)
+
+// plainCrypto "hashes" secrets by prefixing them, so that tests needn't
+// wait for bcrypt.
+type plainCrypto struct{ CryptoService }
+
+func (plainCrypto) Hash(_ context.Context, password string) (string, error) {
+	return "plain:" + password, nil
+}
+
+func (plainCrypto) Verify(_ context.Context, password, hash string) (bool, error) {
+	return hash == "plain:"+password, nil
+}
+
+func TestKeyLifecycle(t *testing.T) {
+	ctx := context.WithValue(context.Background(), principalKey{}, issuedToken{Subject: "ops", Scopes: []string{"uppercase", "count", "admin_keys_*"}})
+	keys := newMemoryKeyStore()
+	admin := keyAdmin{store: keys, crypto: plainCrypto{}, now: time.Now}
+	auth := authService{
+		cfg:       authConfig{DefaultTTL: time.Hour, MaxTTL: time.Hour},
+		keys:      keys,
+		store:     newMemoryTokenStore(),
+		crypto:    plainCrypto{},
+		now:       time.Now,
+		dummyHash: "plain:",
+	}
+
+	if _, err := admin.create(ctx, createKeyRequest{ID: "unscoped"}); svcerrors.CodeOf(err) != svcerrors.CodeInvalidArgument {
+		t.Errorf("a key without scopes: got %v, want INVALID_ARGUMENT", err)
+	}
+	for _, scope := range []string{"*", "admin_policy_reload", "reverse"} {
+		if _, err := admin.create(ctx, createKeyRequest{ID: "escalated", Scopes: []string{scope}}); svcerrors.CodeOf(err) != svcerrors.CodePermissionDenied {
+			t.Errorf("a key with scope %q the caller lacks: got %v, want PERMISSION_DENIED", scope, err)
+		}
+	}
+	if _, err := admin.create(ctx, createKeyRequest{ID: "billing/sync", Scopes: []string{"uppercase"}}); svcerrors.CodeOf(err) != svcerrors.CodeInvalidArgument {
+		t.Errorf("a key ID with a slash: got %v, want INVALID_ARGUMENT", err)
+	}
+	created, err := admin.create(ctx, createKeyRequest{ID: "billing-sync", Scopes: []string{"uppercase"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := admin.create(ctx, createKeyRequest{ID: "billing-sync", Scopes: []string{"count"}}); svcerrors.CodeOf(err) != svcerrors.CodeInvalidArgument {
+		t.Errorf("creating a taken ID: got %v, want INVALID_ARGUMENT", err)
+	}
+	grant, err := auth.IssueToken(ctx, "billing-sync", created.Secret, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := auth.IssueToken(ctx, "billing-sync", created.Secret, []string{"count"}, 0); svcerrors.CodeOf(err) != svcerrors.CodePermissionDenied {
+		t.Errorf("a scope the key lacks: got %v, want PERMISSION_DENIED", err)
+	}
+
+	if _, err := admin.scope(ctx, "billing-sync", []string{"admin_keys_create"}); err != nil {
+		t.Errorf("rescoping within the caller's scopes: %v", err)
+	}
+	if _, err := admin.scope(ctx, "billing-sync", []string{"uppercase", "*"}); svcerrors.CodeOf(err) != svcerrors.CodePermissionDenied {
+		t.Errorf("rescoping beyond the caller's scopes: got %v, want PERMISSION_DENIED", err)
+	}
+	if _, err := admin.scope(ctx, "billing-sync", []string{"uppercase"}); err != nil {
+		t.Fatal(err)
+	}
+
+	rotated, err := admin.rotate(ctx, "billing-sync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := auth.IssueToken(ctx, "billing-sync", created.Secret, nil, 0); svcerrors.CodeOf(err) != svcerrors.CodeUnauthenticated {
+		t.Errorf("the secret rotated out: got %v, want UNAUTHENTICATED", err)
+	}
+	if _, err := auth.Authenticate(ctx, grant.Token); err != nil {
+		t.Errorf("a token from before the rotation: %v", err)
+	}
+	if _, err := auth.IssueToken(ctx, "billing-sync", rotated.Secret, nil, 0); err != nil {
+		t.Errorf("the new secret: %v", err)
+	}
+
+	revoked, err := admin.revoke(ctx, "billing-sync")
+	if err != nil || revoked.Key.Revoked == nil {
+		t.Fatalf("revoking: got %+v, %v", revoked, err)
+	}
+	if _, err := auth.Authenticate(ctx, grant.Token); err == nil {
+		t.Error("a revoked key's token still authenticates")
+	}
+	if _, err := admin.scope(ctx, "billing-sync", []string{"count"}); svcerrors.CodeOf(err) != svcerrors.CodeInvalidArgument {
+		t.Errorf("rescoping a revoked key: got %v, want INVALID_ARGUMENT", err)
+	}
+	if _, err := admin.rotate(ctx, "nobody"); svcerrors.CodeOf(err) != svcerrors.CodeNotFound {
+		t.Errorf("rotating an unknown key: got %v, want NOT_FOUND", err)
+	}
+}
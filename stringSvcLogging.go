@@ -0,0 +1,309 @@
+package stringsvc
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// loggingMiddleware logs every call to the StringService. Inputs and outputs
+// are logged by size only, never by content.
+type loggingMiddleware struct {
+	logger log.Logger
+	next   StringService
+}
+
+func (mw loggingMiddleware) Uppercase(ctx context.Context, s string) (output string, err error) {
+	defer func(begin time.Time) {
+		logCall(ctx, mw.logger, "uppercase", err, begin, "input_len", len(s), "output_len", len(output))
+	}(time.Now())
+	output, err = mw.next.Uppercase(ctx, s)
+	return
+}
+
+func (mw loggingMiddleware) Count(ctx context.Context, s string) (n int) {
+	defer func(begin time.Time) {
+		logCall(ctx, mw.logger, "count", nil, begin, "input_len", len(s), "n", n)
+	}(time.Now())
+	n = mw.next.Count(ctx, s)
+	return
+}
+
+// osInfoLoggingMiddleware logs every call to the OSInfoService.
+type osInfoLoggingMiddleware struct {
+	logger log.Logger
+	next   OSInfoService
+}
+
+func (mw osInfoLoggingMiddleware) Hostname(ctx context.Context) (output string, err error) {
+	defer func(begin time.Time) {
+		logCall(ctx, mw.logger, "hostname", err, begin)
+	}(time.Now())
+	output, err = mw.next.Hostname(ctx)
+	return
+}
+
+// mathLoggingMiddleware logs every call to the MathService.
+type mathLoggingMiddleware struct {
+	logger log.Logger
+	next   MathService
+}
+
+func (mw mathLoggingMiddleware) Add(ctx context.Context, a, b float64) (v float64, err error) {
+	defer func(begin time.Time) {
+		logCall(ctx, mw.logger, "add", err, begin)
+	}(time.Now())
+	v, err = mw.next.Add(ctx, a, b)
+	return
+}
+
+func (mw mathLoggingMiddleware) Subtract(ctx context.Context, a, b float64) (v float64, err error) {
+	defer func(begin time.Time) {
+		logCall(ctx, mw.logger, "subtract", err, begin)
+	}(time.Now())
+	v, err = mw.next.Subtract(ctx, a, b)
+	return
+}
+
+func (mw mathLoggingMiddleware) Multiply(ctx context.Context, a, b float64) (v float64, err error) {
+	defer func(begin time.Time) {
+		logCall(ctx, mw.logger, "multiply", err, begin)
+	}(time.Now())
+	v, err = mw.next.Multiply(ctx, a, b)
+	return
+}
+
+func (mw mathLoggingMiddleware) Divide(ctx context.Context, a, b float64) (v float64, err error) {
+	defer func(begin time.Time) {
+		logCall(ctx, mw.logger, "divide", err, begin)
+	}(time.Now())
+	v, err = mw.next.Divide(ctx, a, b)
+	return
+}
+
+// timeLoggingMiddleware logs every call to the TimeService.
+type timeLoggingMiddleware struct {
+	logger log.Logger
+	next   TimeService
+}
+
+func (mw timeLoggingMiddleware) Now(ctx context.Context, tz string) (t time.Time, err error) {
+	defer func(begin time.Time) {
+		logCall(ctx, mw.logger, "now", err, begin, "tz", tz)
+	}(time.Now())
+	t, err = mw.next.Now(ctx, tz)
+	return
+}
+
+func (mw timeLoggingMiddleware) Format(ctx context.Context, ts time.Time, layout, tz string) (output string, err error) {
+	defer func(begin time.Time) {
+		logCall(ctx, mw.logger, "format", err, begin, "tz", tz)
+	}(time.Now())
+	output, err = mw.next.Format(ctx, ts, layout, tz)
+	return
+}
+
+func (mw timeLoggingMiddleware) Convert(ctx context.Context, local string, fromTZ, toTZ string) (t time.Time, err error) {
+	defer func(begin time.Time) {
+		logCall(ctx, mw.logger, "convert", err, begin, "from", fromTZ, "to", toTZ)
+	}(time.Now())
+	t, err = mw.next.Convert(ctx, local, fromTZ, toTZ)
+	return
+}
+
+// idLoggingMiddleware logs every call to the IDService.
+type idLoggingMiddleware struct {
+	logger log.Logger
+	next   IDService
+}
+
+func (mw idLoggingMiddleware) UUID(ctx context.Context, version string, count int) (ids []string, err error) {
+	defer func(begin time.Time) {
+		logCall(ctx, mw.logger, "uuid", err, begin, "version", version, "n", len(ids))
+	}(time.Now())
+	ids, err = mw.next.UUID(ctx, version, count)
+	return
+}
+
+func (mw idLoggingMiddleware) ULID(ctx context.Context, count int, monotonic bool) (ids []string, err error) {
+	defer func(begin time.Time) {
+		logCall(ctx, mw.logger, "ulid", err, begin, "monotonic", monotonic, "n", len(ids))
+	}(time.Now())
+	ids, err = mw.next.ULID(ctx, count, monotonic)
+	return
+}
+
+// cryptoLoggingMiddleware logs every call to the CryptoService, never the
+// passwords or hashes.
+type cryptoLoggingMiddleware struct {
+	logger log.Logger
+	next   CryptoService
+}
+
+func (mw cryptoLoggingMiddleware) Hash(ctx context.Context, password string) (hash string, err error) {
+	defer func(begin time.Time) {
+		logCall(ctx, mw.logger, "hash", err, begin)
+	}(time.Now())
+	hash, err = mw.next.Hash(ctx, password)
+	return
+}
+
+func (mw cryptoLoggingMiddleware) Verify(ctx context.Context, password, hash string) (ok bool, err error) {
+	defer func(begin time.Time) {
+		logCall(ctx, mw.logger, "verify", err, begin, "ok", ok)
+	}(time.Now())
+	ok, err = mw.next.Verify(ctx, password, hash)
+	return
+}
+
+// shortenerLoggingMiddleware logs every call to the ShortenerService. URLs
+// are not logged, as they may carry tokens.
+type shortenerLoggingMiddleware struct {
+	logger log.Logger
+	next   ShortenerService
+}
+
+func (mw shortenerLoggingMiddleware) Shorten(ctx context.Context, url string, ttl time.Duration) (link shortLink, err error) {
+	defer func(begin time.Time) {
+		logCall(ctx, mw.logger, "shorten", err, begin, "code", link.Code, "ttl", ttl)
+	}(time.Now())
+	link, err = mw.next.Shorten(ctx, url, ttl)
+	return
+}
+
+func (mw shortenerLoggingMiddleware) Resolve(ctx context.Context, code string) (link shortLink, err error) {
+	defer func(begin time.Time) {
+		logCall(ctx, mw.logger, "resolve", err, begin, "code", code)
+	}(time.Now())
+	link, err = mw.next.Resolve(ctx, code)
+	return
+}
+
+// qrcodeLoggingMiddleware logs every call to the QRCodeService.
+type qrcodeLoggingMiddleware struct {
+	logger log.Logger
+	next   QRCodeService
+}
+
+func (mw qrcodeLoggingMiddleware) QRCode(ctx context.Context, s, format string, size int, level string) (img qrImage, err error) {
+	defer func(begin time.Time) {
+		logCall(ctx, mw.logger, "qrcode", err, begin, "input_len", len(s), "format", format, "size", size, "output_len", len(img.Data))
+	}(time.Now())
+	img, err = mw.next.QRCode(ctx, s, format, size, level)
+	return
+}
+
+// translateLoggingMiddleware logs every call to the TranslateService.
+type translateLoggingMiddleware struct {
+	logger log.Logger
+	next   TranslateService
+}
+
+func (mw translateLoggingMiddleware) Translate(ctx context.Context, text, source, target string) (t translation, err error) {
+	defer func(begin time.Time) {
+		logCall(ctx, mw.logger, "translate", err, begin, "input_len", len(text), "source", source, "target", target, "provider", t.Provider)
+	}(time.Now())
+	t, err = mw.next.Translate(ctx, text, source, target)
+	return
+}
+
+// spellLoggingMiddleware logs every call to the SpellService.
+type spellLoggingMiddleware struct {
+	logger log.Logger
+	next   SpellService
+}
+
+func (mw spellLoggingMiddleware) Check(ctx context.Context, text string, maxSuggestions int) (m []misspelling, err error) {
+	defer func(begin time.Time) {
+		logCall(ctx, mw.logger, "spellcheck", err, begin, "input_len", len(text), "misspellings", len(m))
+	}(time.Now())
+	m, err = mw.next.Check(ctx, text, maxSuggestions)
+	return
+}
+
+// detectLoggingMiddleware logs every call to the DetectService.
+type detectLoggingMiddleware struct {
+	logger log.Logger
+	next   DetectService
+}
+
+func (mw detectLoggingMiddleware) Detect(ctx context.Context, s string, max int) (guesses []languageGuess, err error) {
+	defer func(begin time.Time) {
+		lang := ""
+		if len(guesses) > 0 {
+			lang = guesses[0].Lang
+		}
+		logCall(ctx, mw.logger, "detect", err, begin, "input_len", len(s), "lang", lang)
+	}(time.Now())
+	guesses, err = mw.next.Detect(ctx, s, max)
+	return
+}
+
+// writingLoggingMiddleware logs every call to the WritingService.
+type writingLoggingMiddleware struct {
+	logger log.Logger
+	next   WritingService
+}
+
+func (mw writingLoggingMiddleware) Summarize(ctx context.Context, text string, maxTokens int, emit func(string) error) (output string, err error) {
+	defer func(begin time.Time) {
+		logCall(ctx, mw.logger, "summarize", err, begin, "input_len", len(text), "output_len", len(output), "stream", emit != nil)
+	}(time.Now())
+	output, err = mw.next.Summarize(ctx, text, maxTokens, emit)
+	return
+}
+
+func (mw writingLoggingMiddleware) Rewrite(ctx context.Context, text, style string, maxTokens int, emit func(string) error) (output string, err error) {
+	defer func(begin time.Time) {
+		logCall(ctx, mw.logger, "rewrite", err, begin, "input_len", len(text), "output_len", len(output), "stream", emit != nil)
+	}(time.Now())
+	output, err = mw.next.Rewrite(ctx, text, style, maxTokens, emit)
+	return
+}
+
+// authLoggingMiddleware logs token issuing and revocation. Tokens and
+// secrets are never logged. Authenticate runs on every call bearing a
+// token, so only its failures are.
+type authLoggingMiddleware struct {
+	logger log.Logger
+	next   AuthService
+}
+
+func (mw authLoggingMiddleware) IssueToken(ctx context.Context, clientID, secret string, scopes []string, ttl time.Duration) (g tokenGrant, err error) {
+	defer func(begin time.Time) {
+		logCall(ctx, mw.logger, "token", err, begin, "client_id", clientID, "scopes", strings.Join(g.Scopes, " "), "expires", g.Expires)
+	}(time.Now())
+	g, err = mw.next.IssueToken(ctx, clientID, secret, scopes, ttl)
+	return
+}
+
+func (mw authLoggingMiddleware) RevokeToken(ctx context.Context, token string) (err error) {
+	defer func(begin time.Time) {
+		logCall(ctx, mw.logger, "revoke", err, begin)
+	}(time.Now())
+	return mw.next.RevokeToken(ctx, token)
+}
+
+func (mw authLoggingMiddleware) Authenticate(ctx context.Context, token string) (t issuedToken, err error) {
+	begin := time.Now()
+	t, err = mw.next.Authenticate(ctx, token)
+	if err != nil {
+		logCall(ctx, mw.logger, "authenticate", err, begin)
+	}
+	return
+}
+
+// logCall logs a completed service call, at warn level if it failed.
+func logCall(ctx context.Context, logger log.Logger, method string, err error, begin time.Time, keyvals ...interface{}) {
+	l := level.Info(logger)
+	if err != nil {
+		l = level.Warn(logger)
+	}
+	if t, ok := tenantFromContext(ctx); ok {
+		keyvals = append(keyvals, "tenant", t.ID)
+	}
+	l.Log(append([]interface{}{"method", method, "err", err, "took", time.Since(begin)}, keyvals...)...)
+}
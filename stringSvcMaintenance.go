@@ -0,0 +1,144 @@
+package stringsvc
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	httptransport "github.com/go-kit/kit/transport/http"
+
+	"github.com/mcclayac/gokit/svcerrors"
+)
+
+// In maintenance mode, for controlled migrations, the service refuses
+// calls with 503 and a MAINTENANCE error, while the admin, token, health
+// and metrics endpoints stay up to run the migration and watch it.
+// Callers whose identity is allowed, the subject of a valid token or a
+// client address as resolved through -trusted-proxies, are let through,
+// to check the service before it reopens. Operators with a token with the
+// admin_maintenance scope switch it at /admin/maintenance:
+//
+//	curl -X PUT -H "Authorization: Bearer $TOKEN" \
+//		-d '{"enabled": true, "message": "moving to the new database", "allow": ["ops-smoke"], "until": "2026-10-17T02:00:00Z"}' \
+//		http://localhost:9090/admin/maintenance
+//	curl -X DELETE -H "Authorization: Bearer $TOKEN" http://localhost:9090/admin/maintenance
+//
+// until, the expected end, is sent to refused callers as Retry-After. The
+// mode is each process's own, as chaos faults are, so it is set on every
+// replica; -maintenance starts a process in it, for deploys that migrate.
+
+// maintenanceState is whether the service is in maintenance, and how.
+type maintenanceState struct {
+	Enabled bool      `json:"enabled" xml:"enabled"`
+	Message string    `json:"message,omitempty" xml:"message,omitempty"`
+	Allow   []string  `json:"allow,omitempty" xml:"allow>identity,omitempty"`
+	Until   time.Time `json:"until,omitempty" xml:"until,omitempty"` // the expected end; unknown when zero
+	Since   time.Time `json:"since,omitempty" xml:"since,omitempty"` // set when enabled
+}
+
+// maintenance holds the process's maintenance state.
+type maintenance struct {
+	now func() time.Time
+
+	mtx   sync.RWMutex
+	state maintenanceState
+}
+
+func (m *maintenance) State() maintenanceState {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+	return m.state
+}
+
+// Set replaces the state, keeping when maintenance began if it was on.
+func (m *maintenance) Set(s maintenanceState) maintenanceState {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	switch {
+	case !s.Enabled:
+		s = maintenanceState{}
+	case m.state.Enabled:
+		s.Since = m.state.Since
+	default:
+		s.Since = m.now().UTC()
+	}
+	m.state = s
+	return s
+}
+
+// maintenanceExempt reports whether the endpoint at path stays up in
+// maintenance.
+func maintenanceExempt(path string) bool {
+	for _, prefix := range []string{"/admin/", "/auth/"} {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return path == "/healthz" || path == "/readyz" || path == "/metrics"
+}
+
+// maintenanceMiddleware refuses calls in maintenance, except those of the
+// callers it allows, by their identity as callerIdentity finds it.
+func maintenanceMiddleware(m *maintenance) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			s := m.State()
+			if !s.Enabled || maintenanceExempt(r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ctx := httptransport.PopulateRequestContext(r.Context(), r)
+			if len(s.Allow) > 0 {
+				if contains(s.Allow, callerIdentity(r)) {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			if wait := s.Until.Sub(m.now()); wait > 0 {
+				w.Header().Set("Retry-After", strconv.FormatInt(seconds(wait), 10))
+			}
+			msg := s.Message
+			if msg == "" {
+				msg = "the service is down for maintenance"
+			}
+			encodeError(ctx, svcerrors.New(svcerrors.CodeMaintenance, msg), w)
+		})
+	}
+}
+
+// maintenanceRequest reads the state, or sets it when Set.
+type maintenanceRequest struct {
+	maintenanceState
+	Set bool `json:"-" xml:"-"`
+}
+
+func makeMaintenanceEndpoint(m *maintenance) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(maintenanceRequest)
+		if req.Set {
+			return m.Set(req.maintenanceState), nil
+		}
+		return m.State(), nil
+	}
+}
+
+func decodeMaintenanceRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request maintenanceRequest
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		if err := decodeBody(r, &request.maintenanceState); err != nil {
+			return nil, err
+		}
+		request.Set = true
+	case http.MethodDelete:
+		request.Set = true
+	default:
+		return nil, svcerrors.Errorf(svcerrors.CodeInvalidArgument, "%s /admin/maintenance: want GET, PUT or DELETE", r.Method)
+	}
+	return request, nil
+}
@@ -0,0 +1,58 @@
+package stringsvc
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMaintenanceMiddleware(t *testing.T) {
+	now := time.Date(2026, 10, 17, 1, 0, 0, 0, time.UTC)
+	m := &maintenance{now: func() time.Time { return now }}
+	h := maintenanceMiddleware(m)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	call := func(path string, ctx context.Context, remoteAddr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", path, nil).WithContext(ctx)
+		r.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	bg := context.Background()
+
+	if w := call("/uppercase?s=a", bg, "192.0.2.1:1234"); w.Code != http.StatusOK {
+		t.Errorf("out of maintenance: got %d, want 200", w.Code)
+	}
+	m.Set(maintenanceState{Enabled: true, Message: "moving databases", Allow: []string{"ops-smoke", "192.0.2.9"}, Until: now.Add(90 * time.Second)})
+
+	w := call("/uppercase?s=a", bg, "192.0.2.1:1234")
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "90" ||
+		!strings.Contains(w.Body.String(), `"code":"MAINTENANCE"`) || !strings.Contains(w.Body.String(), "moving databases") {
+		t.Errorf("in maintenance: got %d, Retry-After %q, %s", w.Code, w.Header().Get("Retry-After"), w.Body)
+	}
+	for _, path := range []string{"/healthz", "/readyz", "/metrics", "/admin/maintenance", "/auth/token"} {
+		if w := call(path, bg, "192.0.2.1:1234"); w.Code != http.StatusOK {
+			t.Errorf("%s in maintenance: got %d, want 200", path, w.Code)
+		}
+	}
+	smoke := context.WithValue(bg, principalKey{}, issuedToken{Subject: "ops-smoke"})
+	if w := call("/uppercase?s=a", smoke, "192.0.2.1:1234"); w.Code != http.StatusOK {
+		t.Errorf("an allowed subject: got %d, want 200", w.Code)
+	}
+	if w := call("/uppercase?s=a", bg, "192.0.2.9:1234"); w.Code != http.StatusOK {
+		t.Errorf("an allowed address: got %d, want 200", w.Code)
+	}
+	other := context.WithValue(bg, principalKey{}, issuedToken{Subject: "billing"})
+	if w := call("/uppercase?s=a", other, "192.0.2.9:1234"); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("another subject from an allowed address: got %d, want 503", w.Code)
+	}
+
+	if s := m.Set(maintenanceState{}); s.Enabled {
+		t.Errorf("turning maintenance off: got %+v", s)
+	}
+	if w := call("/uppercase?s=a", bg, "192.0.2.1:1234"); w.Code != http.StatusOK {
+		t.Errorf("after maintenance: got %d, want 200", w.Code)
+	}
+}
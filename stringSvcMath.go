@@ -0,0 +1,93 @@
+package stringsvc
+
+import (
+	"context"
+	"math"
+	"net/http"
+
+	"github.com/go-kit/kit/endpoint"
+
+	"github.com/mcclayac/gokit/svcerrors"
+)
+
+// MathService provides arithmetic on float64 operands.
+type MathService interface {
+	Add(ctx context.Context, a, b float64) (float64, error)
+	Subtract(ctx context.Context, a, b float64) (float64, error)
+	Multiply(ctx context.Context, a, b float64) (float64, error)
+	Divide(ctx context.Context, a, b float64) (float64, error)
+}
+
+// ErrDivisionByZero is returned when Divide is asked to divide by zero.
+var ErrDivisionByZero = svcerrors.ErrDivisionByZero
+
+// mathService is a concrete implementation of MathService.
+type mathService struct{}
+
+func (mathService) Add(ctx context.Context, a, b float64) (float64, error) {
+	return mathResult(ctx, a+b)
+}
+
+func (mathService) Subtract(ctx context.Context, a, b float64) (float64, error) {
+	return mathResult(ctx, a-b)
+}
+
+func (mathService) Multiply(ctx context.Context, a, b float64) (float64, error) {
+	return mathResult(ctx, a*b)
+}
+
+func (mathService) Divide(ctx context.Context, a, b float64) (float64, error) {
+	if b == 0 {
+		return 0, ErrDivisionByZero
+	}
+	return mathResult(ctx, a/b)
+}
+
+// mathResult checks that v can be returned: none of the wire formats can
+// carry an infinity or NaN, so results that overflow are an error.
+func mathResult(ctx context.Context, v float64) (float64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if math.IsInf(v, 0) || math.IsNaN(v) {
+		return 0, svcerrors.ErrNumberOverflow
+	}
+	return v, nil
+}
+
+// The four methods share their request and response types.
+type mathRequest struct {
+	A float64 `json:"a" xml:"a"`
+	B float64 `json:"b" xml:"b"`
+}
+
+type mathResponse struct {
+	V   float64 `json:"v" xml:"v"`
+	Err error   `json:"-" xml:"-"`
+}
+
+// Failed implements endpoint.Failer.
+func (r mathResponse) Failed() error { return r.Err }
+
+// makeMathEndpoint adapts one of the MathService methods, all of which
+// take two operands, to an endpoint.
+func makeMathEndpoint(op func(context.Context, float64, float64) (float64, error)) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(mathRequest)
+		v, err := op(ctx, req.A, req.B)
+		return mathResponse{v, err}, nil
+	}
+}
+
+func makeAddEndpoint(svc MathService) endpoint.Endpoint      { return makeMathEndpoint(svc.Add) }
+func makeSubtractEndpoint(svc MathService) endpoint.Endpoint { return makeMathEndpoint(svc.Subtract) }
+func makeMultiplyEndpoint(svc MathService) endpoint.Endpoint { return makeMathEndpoint(svc.Multiply) }
+func makeDivideEndpoint(svc MathService) endpoint.Endpoint   { return makeMathEndpoint(svc.Divide) }
+
+func decodeMathRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request mathRequest
+	if err := decodeBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
@@ -0,0 +1,55 @@
+package stringsvc
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/mcclayac/gokit/svcerrors"
+)
+
+func TestMathService(t *testing.T) {
+	ctx := context.Background()
+	svc := mathService{}
+	if v, err := svc.Divide(ctx, 7, 2); err != nil || v != 3.5 {
+		t.Errorf("7 / 2: got %v, %v, want 3.5", v, err)
+	}
+	if _, err := svc.Divide(ctx, 1, 0); err != ErrDivisionByZero {
+		t.Errorf("1 / 0: got %v, want %v", err, ErrDivisionByZero)
+	}
+	for name, op := range map[string]func(context.Context, float64, float64) (float64, error){
+		"add":      svc.Add,
+		"subtract": svc.Subtract,
+		"multiply": svc.Multiply,
+		"divide":   svc.Divide,
+	} {
+		b := math.MaxFloat64
+		if name == "subtract" {
+			b = -b
+		}
+		if name == "divide" {
+			b = 0.5
+		}
+		if _, err := op(ctx, math.MaxFloat64, b); svcerrors.CodeOf(err) != svcerrors.CodeNumberOverflow {
+			t.Errorf("%s past MaxFloat64: got %v, want NUMBER_OVERFLOW", name, err)
+		}
+	}
+}
+
+func TestMathErrorsOverHTTP(t *testing.T) {
+	srv := newTestServer(t)
+	for _, c := range []struct {
+		path, body string
+		code       svcerrors.Code
+	}{
+		{"/math/divide", `{"a":1,"b":0}`, svcerrors.CodeDivisionByZero},
+		{"/math/multiply", `{"a":1e308,"b":10}`, svcerrors.CodeNumberOverflow},
+	} {
+		resp, b := callTestServer(t, srv, "POST", c.path, http.Header{"Content-Type": {"application/json"}}, c.body)
+		if resp.StatusCode != http.StatusBadRequest || !strings.Contains(string(b), `"code":"`+string(c.code)+`"`) {
+			t.Errorf("%s %s: got %d %s, want 400 %s", c.path, c.body, resp.StatusCode, b, c.code)
+		}
+	}
+}
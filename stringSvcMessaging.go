@@ -0,0 +1,271 @@
+package stringsvc
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+
+	"github.com/mcclayac/gokit/codec"
+	"github.com/mcclayac/gokit/svcerrors"
+)
+
+// The queue-driven modes (the worker transports and the job queue) share
+// the endpoints of the HTTP transport. What differs is how a request
+// arrives: as a body in some format plus the name of the method to call.
+
+// messageEndpoint pairs an endpoint with the decoder for its requests when
+// they arrive as messages in the format of c.
+type messageEndpoint struct {
+	e      endpoint.Endpoint
+	decode func(c codec.Codec, body []byte) (interface{}, error)
+}
+
+// decodeJSON decodes body, a request in JSON as jobs and bulk calls embed
+// them, with the JSON codec of ctx's appEnv.
+func (me messageEndpoint) decodeJSON(ctx context.Context, body []byte) (interface{}, error) {
+	c, err := appEnvFromContext(ctx).codecs.ForContentType("application/json")
+	if err != nil {
+		return nil, err
+	}
+	return me.decode(c, body)
+}
+
+// messageOutcome tells a consumer what to do with a message it handled.
+type messageOutcome int
+
+const (
+	// messageDone: the call ran, successfully or with an error that
+	// retrying won't fix. The message should be acknowledged.
+	messageDone messageOutcome = iota
+	// messageRetry: the call failed in a way that may succeed later. The
+	// message should be redelivered after a backoff.
+	messageRetry
+	// messageRejected: the message can never be processed, because it names
+	// no known method or can't be decoded. It should not be redelivered.
+	messageRejected
+)
+
+// handleMessage decodes body with the codec of ctx's appEnv for
+// contentType and calls the endpoint for method. Business errors reported
+// through endpoint.Failer are returned as err, like transport errors.
+func handleMessage(ctx context.Context, endpoints map[string]messageEndpoint, method, contentType string, body []byte) (response interface{}, outcome messageOutcome, err error) {
+	me, ok := endpoints[method]
+	if !ok {
+		return nil, messageRejected, svcerrors.Errorf(svcerrors.CodeInvalidArgument, "unknown method %q", method)
+	}
+	c, err := appEnvFromContext(ctx).codecs.ForContentType(contentType)
+	if err != nil {
+		return nil, messageRejected, err
+	}
+	request, err := me.decode(c, body)
+	if err != nil {
+		return nil, messageRejected, err
+	}
+	response, err = me.e(ctx, request)
+	if f, ok := response.(endpoint.Failer); ok && err == nil {
+		err = f.Failed()
+	}
+	if err != nil {
+		reportUnexpected(ctx, err)
+		if svcerrors.Retryable(svcerrors.CodeOf(err)) {
+			return nil, messageRetry, err
+		}
+	}
+	return response, messageDone, err
+}
+
+// encodeReply encodes the response to a message, or the error envelope
+// when err is non-nil, in the format named by accept among codecs.
+func encodeReply(codecs *codec.Registry, accept string, response interface{}, err error) (contentType string, body []byte, encErr error) {
+	c, cerr := codecs.ForAccept(accept)
+	if cerr != nil {
+		c = codecs.Default()
+	}
+	if err != nil {
+		response = errorResponse{svcerrors.From(err)}
+	}
+	var buf bytes.Buffer
+	if err := c.Encode(&buf, response); err != nil {
+		return "", nil, err
+	}
+	return c.ContentType(), buf.Bytes(), nil
+}
+
+// redeliveryDelay is how long to wait before the given delivery attempt
+// (counting from 1) of a message is retried: base, doubled on each attempt,
+// up to maxRedeliveryDelay.
+func redeliveryDelay(base time.Duration, attempt int) time.Duration {
+	d := base << uint(attempt-1)
+	if d <= 0 || d > maxRedeliveryDelay {
+		return maxRedeliveryDelay
+	}
+	return d
+}
+
+const maxRedeliveryDelay = 5 * time.Minute
+
+// decodeMessage decodes body into v using c. An empty body leaves v as
+// the zero request.
+func decodeMessage(c codec.Codec, body []byte, v interface{}) error {
+	if len(body) == 0 {
+		return nil
+	}
+	if err := c.Decode(bytes.NewReader(body), v); err != nil {
+		return svcerrors.Errorf(svcerrors.CodeInvalidArgument, "malformed message: %v", err)
+	}
+	return nil
+}
+
+func decodeUppercaseMessage(c codec.Codec, body []byte) (interface{}, error) {
+	var request uppercaseRequest
+	if err := decodeMessage(c, body, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+func decodeCountMessage(c codec.Codec, body []byte) (interface{}, error) {
+	var request countRequest
+	if err := decodeMessage(c, body, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+func decodeMathMessage(c codec.Codec, body []byte) (interface{}, error) {
+	var request mathRequest
+	if err := decodeMessage(c, body, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+func decodeNowMessage(c codec.Codec, body []byte) (interface{}, error) {
+	var request nowRequest
+	if err := decodeMessage(c, body, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+func decodeFormatMessage(c codec.Codec, body []byte) (interface{}, error) {
+	var request formatRequest
+	if err := decodeMessage(c, body, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+func decodeConvertMessage(c codec.Codec, body []byte) (interface{}, error) {
+	var request convertRequest
+	if err := decodeMessage(c, body, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+func decodeUUIDMessage(c codec.Codec, body []byte) (interface{}, error) {
+	var request uuidRequest
+	if err := decodeMessage(c, body, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+func decodeULIDMessage(c codec.Codec, body []byte) (interface{}, error) {
+	var request ulidRequest
+	if err := decodeMessage(c, body, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+func decodeHashMessage(c codec.Codec, body []byte) (interface{}, error) {
+	var request hashRequest
+	if err := decodeMessage(c, body, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+func decodeVerifyMessage(c codec.Codec, body []byte) (interface{}, error) {
+	var request verifyRequest
+	if err := decodeMessage(c, body, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+func decodeShortenMessage(c codec.Codec, body []byte) (interface{}, error) {
+	var request shortenRequest
+	if err := decodeMessage(c, body, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+func decodeResolveMessage(c codec.Codec, body []byte) (interface{}, error) {
+	var request resolveRequest
+	if err := decodeMessage(c, body, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+func decodeQRCodeMessage(c codec.Codec, body []byte) (interface{}, error) {
+	var request qrcodeRequest
+	if err := decodeMessage(c, body, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+func decodeTranslateMessage(c codec.Codec, body []byte) (interface{}, error) {
+	var request translateRequest
+	if err := decodeMessage(c, body, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+func decodeSpellcheckMessage(c codec.Codec, body []byte) (interface{}, error) {
+	var request spellcheckRequest
+	if err := decodeMessage(c, body, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+func decodeDetectMessage(c codec.Codec, body []byte) (interface{}, error) {
+	var request detectRequest
+	if err := decodeMessage(c, body, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+func decodeSummarizeMessage(c codec.Codec, body []byte) (interface{}, error) {
+	var request summarizeRequest
+	if err := decodeMessage(c, body, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+func decodeRewriteMessage(c codec.Codec, body []byte) (interface{}, error) {
+	var request rewriteRequest
+	if err := decodeMessage(c, body, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+func decodeHostnameMessage(c codec.Codec, body []byte) (interface{}, error) {
+	var request hostnameRequest
+	if err := decodeMessage(c, body, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
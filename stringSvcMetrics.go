@@ -0,0 +1,201 @@
+package stringsvc
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/dogstatsd"
+	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
+	"github.com/go-kit/kit/metrics/statsd"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metricsSink holds the settings for where metrics are sent.
+type metricsSink struct {
+	Kind     string        // "prometheus" (default), "statsd", "dogstatsd" or "otlp"
+	Addr     string        // statsd/dogstatsd agent address
+	Prefix   string        // prepended to statsd/dogstatsd metric names
+	Interval time.Duration // how often statsd/dogstatsd metrics are flushed
+	SLO      sloThresholds // per-endpoint latency objectives
+
+	OTLPEndpoint string        // OTLP/HTTP collector address
+	OTLPInterval time.Duration // how often OTLP metrics are pushed
+	ServiceName  string        // service.name resource attribute for OTLP
+}
+
+// metricsFactory creates instruments in one metrics backend. Names are
+// snake_case without unit suffixes; each backend adapts them to its own
+// conventions. Histograms observe seconds.
+type metricsFactory interface {
+	Counter(name, help string, labels ...string) metrics.Counter
+	Histogram(name, help string, buckets []float64, labels ...string) metrics.Histogram
+	// Handler serves the metrics for scraping. It is nil for the push
+	// backends, which send to their agent or collector in the background.
+	Handler() http.Handler
+	// Stop stops pushing metrics, flushing what it can.
+	Stop(ctx context.Context) error
+}
+
+// newMetricsFactory returns the factory for the configured sink.
+func newMetricsFactory(sink metricsSink, logger log.Logger) (metricsFactory, error) {
+	switch sink.Kind {
+	case "", "prometheus":
+		reg := stdprometheus.NewRegistry()
+		reg.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+		return prometheusFactory{reg}, nil
+	case "statsd":
+		s := statsd.New(sink.Prefix, logger)
+		ctx, cancel := context.WithCancel(context.Background())
+		go s.SendLoop(ctx, time.NewTicker(sink.Interval).C, "udp", sink.Addr)
+		return statsdFactory{s, cancel}, nil
+	case "dogstatsd":
+		d := dogstatsd.New(sink.Prefix, logger)
+		ctx, cancel := context.WithCancel(context.Background())
+		go d.SendLoop(ctx, time.NewTicker(sink.Interval).C, "udp", sink.Addr)
+		return dogstatsdFactory{d, cancel}, nil
+	case "otlp":
+		return newOTelFactory(sink)
+	default:
+		return nil, fmt.Errorf("unknown metrics sink %q", sink.Kind)
+	}
+}
+
+// defaultLatencyBuckets are the bucket bounds, in seconds, of latency
+// histograms.
+var defaultLatencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// prometheusFactory registers instruments with a registry of its own, so
+// that each App serves its own /metrics, under the stringsvc namespace,
+// adding _total to counter names and _seconds to histogram names. The
+// registry also has the Go runtime and process collectors.
+type prometheusFactory struct {
+	reg *stdprometheus.Registry
+}
+
+func (f prometheusFactory) Counter(name, help string, labels ...string) metrics.Counter {
+	cv := stdprometheus.NewCounterVec(stdprometheus.CounterOpts{
+		Namespace: "stringsvc",
+		Name:      name + "_total",
+		Help:      help,
+	}, labels)
+	f.reg.MustRegister(cv)
+	return kitprometheus.NewCounter(cv)
+}
+
+func (f prometheusFactory) Histogram(name, help string, buckets []float64, labels ...string) metrics.Histogram {
+	hv := stdprometheus.NewHistogramVec(stdprometheus.HistogramOpts{
+		Namespace: "stringsvc",
+		Name:      name + "_seconds",
+		Help:      help,
+		Buckets:   buckets,
+	}, labels)
+	f.reg.MustRegister(hv)
+	return kitprometheus.NewHistogram(hv)
+}
+
+func (f prometheusFactory) Handler() http.Handler {
+	return promhttp.InstrumentMetricHandler(f.reg, promhttp.HandlerFor(f.reg, promhttp.HandlerOpts{}))
+}
+
+func (prometheusFactory) Stop(context.Context) error { return nil }
+
+// statsdFactory sends to a plain StatsD agent. StatsD has no tags, so label
+// values become part of the name: requests.With("method", "count", "code",
+// "OK") is sent as requests.count.OK. Histograms are sent as timers, in
+// milliseconds.
+type statsdFactory struct {
+	s      *statsd.Statsd
+	cancel context.CancelFunc
+}
+
+func (f statsdFactory) Counter(name, _ string, _ ...string) metrics.Counter {
+	return statsdCounter{f.s, name}
+}
+
+func (f statsdFactory) Histogram(name, _ string, _ []float64, _ ...string) metrics.Histogram {
+	return secondsToMillis{statsdTiming{f.s, name}}
+}
+
+func (statsdFactory) Handler() http.Handler { return nil }
+
+func (f statsdFactory) Stop(context.Context) error {
+	f.cancel()
+	return nil
+}
+
+// dogstatsdFactory sends to a DogStatsD agent, with labels as tags.
+// Histograms are sent as timers, in milliseconds.
+type dogstatsdFactory struct {
+	d      *dogstatsd.Dogstatsd
+	cancel context.CancelFunc
+}
+
+func (f dogstatsdFactory) Counter(name, _ string, _ ...string) metrics.Counter {
+	return f.d.NewCounter(name, 1)
+}
+
+func (f dogstatsdFactory) Histogram(name, _ string, _ []float64, _ ...string) metrics.Histogram {
+	return secondsToMillis{f.d.NewTiming(name, 1)}
+}
+
+func (dogstatsdFactory) Handler() http.Handler { return nil }
+
+func (f dogstatsdFactory) Stop(context.Context) error {
+	f.cancel()
+	return nil
+}
+
+type statsdCounter struct {
+	s    *statsd.Statsd
+	name string
+}
+
+func (c statsdCounter) With(labelValues ...string) metrics.Counter {
+	return statsdCounter{c.s, labeledName(c.name, labelValues)}
+}
+
+func (c statsdCounter) Add(delta float64) {
+	c.s.NewCounter(c.name, 1).Add(delta)
+}
+
+type statsdTiming struct {
+	s    *statsd.Statsd
+	name string
+}
+
+func (t statsdTiming) With(labelValues ...string) metrics.Histogram {
+	return statsdTiming{t.s, labeledName(t.name, labelValues)}
+}
+
+func (t statsdTiming) Observe(value float64) {
+	t.s.NewTiming(t.name, 1).Observe(value)
+}
+
+func labeledName(name string, labelValues []string) string {
+	parts := []string{name}
+	for i := 1; i < len(labelValues); i += 2 {
+		parts = append(parts, labelValues[i])
+	}
+	return strings.Join(parts, ".")
+}
+
+// secondsToMillis adapts histograms, observed in seconds, to StatsD timers,
+// which are in milliseconds.
+type secondsToMillis struct {
+	next metrics.Histogram
+}
+
+func (h secondsToMillis) With(labelValues ...string) metrics.Histogram {
+	return secondsToMillis{h.next.With(labelValues...)}
+}
+
+func (h secondsToMillis) Observe(value float64) {
+	h.next.Observe(value * 1000)
+}
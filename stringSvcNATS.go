@@ -0,0 +1,247 @@
+package stringsvc
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	httptransport "github.com/go-kit/kit/transport/http"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"go.opentelemetry.io/otel/propagation"
+
+	"github.com/mcclayac/gokit/svcerrors"
+	"github.com/mcclayac/gokit/tracing"
+)
+
+// natsConfig configures the NATS JetStream worker.
+type natsConfig struct {
+	URL     string // the NATS worker is off when empty
+	Stream  string // must already exist and capture Subject.>
+	Subject string // requests for a method arrive on Subject.<method>
+	Durable string
+	// DeadLetterSubject must be captured by a stream, and not be under
+	// Subject. Messages given up on are dropped when it is empty.
+	DeadLetterSubject string
+}
+
+const (
+	// natsAckWait is how long JetStream waits for an ack before
+	// redelivering; handlers get the same time to finish.
+	natsAckWait = 30 * time.Second
+	// replyToHeader names the message header holding the subject the
+	// response should be published to. Requests without it get no reply.
+	replyToHeader = "Reply-To"
+)
+
+// natsWorker consumes requests from a durable JetStream consumer. Each
+// message is decoded by its Content-Type header and passed to the endpoint
+// named by the last token of its subject. Messages are acked once handled,
+// nacked with exponential backoff when they may succeed on retry, and
+// dead-lettered when they never can or MaxDeliver attempts have been made.
+type natsWorker struct {
+	nc        *nats.Conn
+	js        jetstream.JetStream
+	cons      jetstream.Consumer
+	cfg       workerConfig
+	endpoints map[string]messageEndpoint
+	env       *appEnv
+	logger    log.Logger
+}
+
+func newNATSWorker(ctx context.Context, cfg workerConfig, endpoints map[string]messageEndpoint, env *appEnv, logger log.Logger) (*natsWorker, error) {
+	nc, err := nats.Connect(cfg.NATS.URL, nats.Name("stringsvc-worker"))
+	if err != nil {
+		return nil, err
+	}
+	js, err := jetstream.New(nc)
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+	cons, err := js.CreateOrUpdateConsumer(ctx, cfg.NATS.Stream, jetstream.ConsumerConfig{
+		Durable:       cfg.NATS.Durable,
+		FilterSubject: cfg.NATS.Subject + ".>",
+		AckPolicy:     jetstream.AckExplicitPolicy,
+		AckWait:       natsAckWait,
+		MaxDeliver:    cfg.MaxDeliver,
+	})
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+	return &natsWorker{nc: nc, js: js, cons: cons, cfg: cfg, endpoints: endpoints, env: env, logger: logger}, nil
+}
+
+func (w *natsWorker) Run(ctx context.Context) error {
+	defer w.nc.Drain()
+	cc, err := w.cons.Consume(w.handle)
+	if err != nil {
+		return err
+	}
+	defer cc.Stop()
+	<-ctx.Done()
+	return nil
+}
+
+func (w *natsWorker) DeadLetters() deadLetterQueue {
+	if w.cfg.NATS.DeadLetterSubject == "" {
+		return nil
+	}
+	return w
+}
+
+func (w *natsWorker) handle(msg jetstream.Msg) {
+	subject := msg.Subject()
+	method := subject[strings.LastIndexByte(subject, '.')+1:]
+	logger := log.With(w.logger, "subject", subject)
+
+	ctx, cancel := context.WithTimeout(w.env.attach(context.Background()), natsAckWait)
+	defer cancel()
+	ctx = tracing.Propagator.Extract(ctx, propagation.HeaderCarrier(msg.Headers()))
+	ctx = context.WithValue(ctx, httptransport.ContextKeyRequestXRequestID, msg.Headers().Get(requestIDHeader))
+
+	attempt := 1
+	if md, err := msg.Metadata(); err == nil {
+		attempt = int(md.NumDelivered)
+	}
+	response, outcome, err := handleMessage(ctx, w.endpoints, method, msg.Headers().Get("Content-Type"), msg.Data())
+	switch outcome {
+	case messageRejected:
+		level.Error(logger).Log("msg", "rejecting message", "err", err)
+		w.giveUp(msg, logger, attempt, err)
+	case messageRetry:
+		if attempt >= w.cfg.MaxDeliver {
+			level.Error(logger).Log("msg", "giving up on message", "attempts", attempt, "err", err)
+			w.giveUp(msg, logger, attempt, err)
+			return
+		}
+		delay := redeliveryDelay(w.cfg.Backoff, attempt)
+		level.Warn(logger).Log("msg", "retrying message", "attempt", attempt, "delay", delay, "err", err)
+		msg.NakWithDelay(delay)
+	default:
+		w.reply(msg, response, err)
+		msg.Ack()
+	}
+}
+
+// giveUp dead-letters msg, replies with err and terminates it. If the
+// dead-letter subject can't take the message, it is nacked to be tried
+// again rather than lost.
+func (w *natsWorker) giveUp(msg jetstream.Msg, logger log.Logger, attempts int, err error) {
+	if subject := w.cfg.NATS.DeadLetterSubject; subject != "" {
+		dl := nats.NewMsg(subject)
+		for k, v := range msg.Headers() {
+			dl.Header[k] = v
+		}
+		for k, v := range deadLetterHeaders(msg.Subject(), attempts, err) {
+			dl.Header.Set(k, v)
+		}
+		dl.Data = msg.Data()
+		if _, derr := w.js.PublishMsg(context.Background(), dl); derr != nil {
+			level.Error(logger).Log("msg", "dead-lettering message", "err", derr)
+			msg.Nak()
+			return
+		}
+	}
+	w.reply(msg, nil, err)
+	msg.Term()
+}
+
+// reply publishes the response, or the error envelope when err is non-nil,
+// to the subject in the message's Reply-To header, in the format named by
+// its Accept header.
+func (w *natsWorker) reply(msg jetstream.Msg, response interface{}, err error) {
+	subject := msg.Headers().Get(replyToHeader)
+	if subject == "" {
+		return
+	}
+	contentType, body, err := encodeReply(w.env.codecs, msg.Headers().Get("Accept"), response, err)
+	if err != nil {
+		level.Error(w.logger).Log("msg", "encoding reply", "err", err)
+		return
+	}
+	reply := nats.NewMsg(subject)
+	reply.Header.Set("Content-Type", contentType)
+	reply.Header.Set(requestIDHeader, msg.Headers().Get(requestIDHeader))
+	reply.Data = body
+	w.nc.PublishMsg(reply)
+}
+
+// List reads dead letters from the start of the stream capturing the
+// dead-letter subject. Their IDs are stream sequence numbers.
+func (w *natsWorker) List(ctx context.Context, limit int) ([]deadLetter, error) {
+	s, err := w.deadLetterStream(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cons, err := s.OrderedConsumer(ctx, jetstream.OrderedConsumerConfig{
+		FilterSubjects: []string{w.cfg.NATS.DeadLetterSubject},
+	})
+	if err != nil {
+		return nil, err
+	}
+	batch, err := cons.FetchNoWait(limit)
+	if err != nil {
+		return nil, err
+	}
+	var dls []deadLetter
+	for m := range batch.Messages() {
+		md, err := m.Metadata()
+		if err != nil {
+			return nil, err
+		}
+		dls = append(dls, newDeadLetter(strconv.FormatUint(md.Sequence.Stream, 10), natsHeaderMap(m.Headers()), m.Data()))
+	}
+	return dls, batch.Error()
+}
+
+func (w *natsWorker) Redrive(ctx context.Context, id string) error {
+	notFound := svcerrors.Errorf(svcerrors.CodeNotFound, "no dead letter %q", id)
+	seq, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return notFound
+	}
+	s, err := w.deadLetterStream(ctx)
+	if err != nil {
+		return err
+	}
+	raw, err := s.GetMsg(ctx, seq)
+	if errors.Is(err, jetstream.ErrMsgNotFound) || err == nil && raw.Subject != w.cfg.NATS.DeadLetterSubject {
+		return notFound
+	}
+	if err != nil {
+		return err
+	}
+	msg := nats.NewMsg(raw.Header.Get(deadLetterSourceHeader))
+	for k, v := range raw.Header {
+		if !isDeadLetterHeader(k) {
+			msg.Header[k] = v
+		}
+	}
+	msg.Data = raw.Data
+	if _, err := w.js.PublishMsg(ctx, msg); err != nil {
+		return err
+	}
+	return s.DeleteMsg(ctx, seq)
+}
+
+func (w *natsWorker) deadLetterStream(ctx context.Context) (jetstream.Stream, error) {
+	name, err := w.js.StreamNameBySubject(ctx, w.cfg.NATS.DeadLetterSubject)
+	if err != nil {
+		return nil, err
+	}
+	return w.js.Stream(ctx, name)
+}
+
+func natsHeaderMap(h nats.Header) map[string]string {
+	m := make(map[string]string, len(h))
+	for k := range h {
+		m[k] = h.Get(k)
+	}
+	return m
+}
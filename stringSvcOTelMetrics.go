@@ -0,0 +1,115 @@
+package stringsvc
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/discard"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// defaultOTLPInterval is how often OTLP metrics are pushed by default.
+const defaultOTLPInterval = 30 * time.Second
+
+// otelFactory creates instruments with the OpenTelemetry metrics SDK,
+// pushing them over OTLP/HTTP. Names are dotted and prefixed with
+// "stringsvc.", so request_duration becomes stringsvc.request.duration.
+type otelFactory struct {
+	mp    *sdkmetric.MeterProvider
+	meter metric.Meter
+}
+
+func newOTelFactory(sink metricsSink) (otelFactory, error) {
+	exp, err := otlpmetrichttp.New(context.Background(),
+		otlpmetrichttp.WithEndpoint(sink.OTLPEndpoint),
+		otlpmetrichttp.WithInsecure(),
+	)
+	if err != nil {
+		return otelFactory{}, err
+	}
+	mp := sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(exp, sdkmetric.WithInterval(sink.OTLPInterval))),
+		sdkmetric.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceName(sink.ServiceName),
+			semconv.ServiceVersion(version),
+		)),
+	)
+	return otelFactory{mp, mp.Meter("github.com/mcclayac/gokit")}, nil
+}
+
+func otelName(name string) string {
+	return "stringsvc." + strings.Replace(name, "_", ".", -1)
+}
+
+// Counter and Histogram fall back to discarding instruments if the SDK
+// rejects one, which only happens for invalid names.
+
+func (f otelFactory) Counter(name, help string, _ ...string) metrics.Counter {
+	c, err := f.meter.Float64Counter(otelName(name), metric.WithDescription(help))
+	if err != nil {
+		return discard.NewCounter()
+	}
+	return otelCounter{c: c}
+}
+
+func (f otelFactory) Histogram(name, help string, buckets []float64, _ ...string) metrics.Histogram {
+	h, err := f.meter.Float64Histogram(otelName(name),
+		metric.WithDescription(help),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(buckets...))
+	if err != nil {
+		return discard.NewHistogram()
+	}
+	return otelHistogram{h: h}
+}
+
+func (otelFactory) Handler() http.Handler { return nil }
+
+func (f otelFactory) Stop(ctx context.Context) error {
+	return f.mp.Shutdown(ctx)
+}
+
+// otelCounter and otelHistogram adapt OpenTelemetry instruments to go-kit's
+// metrics interfaces, turning label values into attributes.
+type otelCounter struct {
+	c     metric.Float64Counter
+	attrs []attribute.KeyValue
+}
+
+func (c otelCounter) With(labelValues ...string) metrics.Counter {
+	return otelCounter{c.c, withAttributes(c.attrs, labelValues)}
+}
+
+func (c otelCounter) Add(delta float64) {
+	c.c.Add(context.Background(), delta, metric.WithAttributes(c.attrs...))
+}
+
+type otelHistogram struct {
+	h     metric.Float64Histogram
+	attrs []attribute.KeyValue
+}
+
+func (h otelHistogram) With(labelValues ...string) metrics.Histogram {
+	return otelHistogram{h.h, withAttributes(h.attrs, labelValues)}
+}
+
+func (h otelHistogram) Observe(value float64) {
+	h.h.Record(context.Background(), value, metric.WithAttributes(h.attrs...))
+}
+
+func withAttributes(attrs []attribute.KeyValue, labelValues []string) []attribute.KeyValue {
+	out := append([]attribute.KeyValue(nil), attrs...)
+	for i := 0; i+1 < len(labelValues); i += 2 {
+		out = append(out, attribute.String(labelValues[i], labelValues[i+1]))
+	}
+	return out
+}
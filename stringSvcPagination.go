@@ -0,0 +1,92 @@
+package stringsvc
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	httptransport "github.com/go-kit/kit/transport/http"
+
+	"github.com/mcclayac/gokit/svcerrors"
+)
+
+// List endpoints return a page of results at a time. A request asks for up
+// to limit results, and a full page comes with a page token, passed back
+// as page_token to get the page after it, and a Link header to the same
+// URL. Page tokens are opaque to clients: a cursor, the position of the
+// last result in the list's order, as base64-encoded JSON. A cursor
+// rather than an offset keeps pages from skipping or repeating results as
+// new ones are added.
+const (
+	defaultPageSize = 50
+	maxPageSize     = 1000
+)
+
+// pageParams are the paging query parameters of a list request.
+type pageParams struct {
+	Limit int
+	Token string
+}
+
+// decodePageParams reads the limit and page_token query parameters. limit
+// defaults to defaultPageSize and may be at most maxPageSize.
+func decodePageParams(r *http.Request) (pageParams, error) {
+	q := r.URL.Query()
+	p := pageParams{Limit: defaultPageSize, Token: q.Get("page_token")}
+	if s := q.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return pageParams{}, svcerrors.Errorf(svcerrors.CodeInvalidArgument, "malformed limit %q", s)
+		}
+		p.Limit = n
+	}
+	if p.Limit < 1 || p.Limit > maxPageSize {
+		return pageParams{}, svcerrors.Errorf(svcerrors.CodeInvalidArgument, "limit must be between 1 and %d", maxPageSize)
+	}
+	return p, nil
+}
+
+// encodeCursor returns the page token for cursor c.
+func encodeCursor(c interface{}) string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeCursor reads the cursor in page token s into c. valid reports
+// whether the decoded cursor is complete.
+func decodeCursor(s string, c interface{}, valid func() bool) error {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err == nil {
+		err = json.Unmarshal(b, c)
+	}
+	if err != nil || !valid() {
+		return svcerrors.Errorf(svcerrors.CodeInvalidArgument, "malformed page_token")
+	}
+	return nil
+}
+
+// nextPageURL returns the URL of the request in ctx with its page_token
+// replaced by token, for the next page's Link header.
+func nextPageURL(ctx context.Context, token string) string {
+	uri, _ := ctx.Value(httptransport.ContextKeyRequestURI).(string)
+	u, err := url.ParseRequestURI(uri)
+	if err != nil {
+		return ""
+	}
+	q := u.Query()
+	q.Set("page_token", token)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// pageLinkHeader returns the Link header pointing at next, if there is a
+// next page.
+func pageLinkHeader(next string) http.Header {
+	if next == "" {
+		return nil
+	}
+	return http.Header{"Link": {"<" + next + `>; rel="next"`}}
+}
@@ -0,0 +1,69 @@
+package stringsvc
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	httptransport "github.com/go-kit/kit/transport/http"
+
+	"github.com/mcclayac/gokit/svcerrors"
+)
+
+func TestDecodePageParams(t *testing.T) {
+	for _, c := range []struct {
+		query string
+		limit int
+		ok    bool
+	}{
+		{"", defaultPageSize, true},
+		{"?limit=10&page_token=abc", 10, true},
+		{"?limit=1000", maxPageSize, true},
+		{"?limit=1001", 0, false},
+		{"?limit=0", 0, false},
+		{"?limit=ten", 0, false},
+	} {
+		p, err := decodePageParams(httptest.NewRequest("GET", "/history"+c.query, nil))
+		if !c.ok {
+			if svcerrors.CodeOf(err) != svcerrors.CodeInvalidArgument {
+				t.Errorf("%q: got %v, want INVALID_ARGUMENT", c.query, err)
+			}
+			continue
+		}
+		if err != nil || p.Limit != c.limit {
+			t.Errorf("%q: got limit %d, %v, want %d", c.query, p.Limit, err, c.limit)
+		}
+	}
+}
+
+func TestCursor(t *testing.T) {
+	type cursor struct {
+		Time int64  `json:"t"`
+		ID   string `json:"id"`
+	}
+	token := encodeCursor(cursor{Time: 1700000000, ID: "h1"})
+	var got cursor
+	if err := decodeCursor(token, &got, func() bool { return got.ID != "" }); err != nil || got.ID != "h1" || got.Time != 1700000000 {
+		t.Errorf("round trip: got %+v, %v", got, err)
+	}
+	for _, token := range []string{"not base64!", encodeCursor("a string"), encodeCursor(cursor{Time: 1})} {
+		var c cursor
+		if err := decodeCursor(token, &c, func() bool { return c.ID != "" }); svcerrors.CodeOf(err) != svcerrors.CodeInvalidArgument {
+			t.Errorf("token %q: got %v, want INVALID_ARGUMENT", token, err)
+		}
+	}
+}
+
+func TestNextPageURL(t *testing.T) {
+	ctx := context.WithValue(context.Background(), httptransport.ContextKeyRequestURI, "/history?limit=2&operation=count&page_token=old")
+	want := "/history?limit=2&operation=count&page_token=new"
+	if got := nextPageURL(ctx, "new"); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if h := pageLinkHeader(want); h.Get("Link") != "<"+want+`>; rel="next"` {
+		t.Errorf("got Link %q", h.Get("Link"))
+	}
+	if pageLinkHeader("") != nil {
+		t.Error("the last page has a Link header")
+	}
+}
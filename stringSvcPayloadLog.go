@@ -0,0 +1,61 @@
+package stringsvc
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+
+	"github.com/mcclayac/gokit/redact"
+)
+
+// payloadLoggingMiddleware logs request and response bodies at debug level,
+// for diagnosing malformed requests. JSON bodies are logged with the
+// redactor's paths masked; other formats are logged by size only, as they
+// can't be redacted. Logged bodies are cut off after maxBytes.
+func payloadLoggingMiddleware(logger log.Logger, redactor *redact.Redactor, maxBytes int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				encodeError(r.Context(), err, w)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(rec, r)
+
+			level.Debug(logger).Log(
+				"msg", "payload",
+				"path", r.URL.Path,
+				"request_id", r.Header.Get(requestIDHeader),
+				"status", rec.status,
+				"request", loggablePayload(r.Header.Get("Content-Type"), body, redactor, maxBytes),
+				"response", loggablePayload(w.Header().Get("Content-Type"), rec.body.Bytes(), redactor, maxBytes),
+			)
+		})
+	}
+}
+
+func loggablePayload(contentType string, body []byte, redactor *redact.Redactor, maxBytes int) string {
+	if len(body) == 0 {
+		return ""
+	}
+	mt, _, _ := mime.ParseMediaType(contentType)
+	if mt != "" && mt != "application/json" {
+		return fmt.Sprintf("[%d bytes of %s]", len(body), mt)
+	}
+	redacted, err := redactor.JSON(body)
+	if err != nil {
+		return fmt.Sprintf("[%d bytes of invalid JSON]", len(body))
+	}
+	if len(redacted) > maxBytes {
+		return fmt.Sprintf("%s...[truncated, %d bytes]", redacted[:maxBytes], len(redacted))
+	}
+	return string(redacted)
+}
@@ -0,0 +1,416 @@
+package stringsvc
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/mcclayac/gokit/svcerrors"
+)
+
+// A policy file lets operators tune each endpoint without a code change.
+// It is YAML or JSON, by extension, with a default policy and policies by
+// path; a path ending in a slash covers the paths under it, and the
+// longest matching path wins. Settings left out of an endpoint's policy
+// are the default's:
+//
+//	default:
+//	  timeout: 10s
+//	  max_body_bytes: 1048576
+//	endpoints:
+//	  /password/hash: {timeout: 2s, rate_limit: 5, rate_burst: 10, auth: required}
+//	  /detect: {cache_ttl: 1m}
+//	  /uppercase: {cache_control: "public, max-age=3600", vary: [Accept]}
+//
+// Responses are cached only for the endpoints whose own policy sets
+// cache_ttl, which the default can't, and only for GET and HEAD requests:
+// POST calls, such as /uuid or /links, make something new each time.
+//
+// The caching headers, cache_control, expires (a duration from the time
+// of the response) and vary, are set on successful responses to GET and
+// HEAD requests, for caches in front of the service; /hostname, whose
+// answer differs by instance, is always sent with Cache-Control: no-store.
+//
+// The file is read at startup and again on SIGHUP; a file that fails to
+// load is logged and the policies in force kept. Reloading empties the
+// response cache and resets the rate limits, unless they are kept in Redis
+// with -rate-limit-redis-addr, to be shared by the replicas; each path's
+// bucket then carries over, at its new rate.
+
+// policyFile is the policy file's contents.
+type policyFile struct {
+	Default   endpointPolicy            `json:"default"`
+	Endpoints map[string]endpointPolicy `json:"endpoints"`
+}
+
+// endpointPolicy is the policy of an endpoint. Zero values are unset.
+type endpointPolicy struct {
+	// Timeout bounds each call, along with the caller's deadline.
+	Timeout policyDuration `json:"timeout,omitempty"`
+	// RateLimit is calls per second, shared by all callers and, with
+	// -rate-limit-redis-addr, all replicas, with bursts of RateBurst;
+	// unlimited when zero.
+	RateLimit float64 `json:"rate_limit,omitempty"`
+	RateBurst int     `json:"rate_burst,omitempty"`
+	// MaxBodyBytes rejects larger request bodies, with
+	// PAYLOAD_TOO_LARGE.
+	MaxBodyBytes int64 `json:"max_body_bytes,omitempty"`
+	// CacheTTL is how long successful responses to GET and HEAD requests
+	// are cached, for the endpoints that set it. Cached responses are per
+	// query, Accept header, principal and tenant, and are served with
+	// X-Cache: hit; requests whose token fails to authenticate skip the
+	// cache.
+	CacheTTL policyDuration `json:"cache_ttl,omitempty"`
+	// Auth is "required" to reject calls without a valid bearer token,
+	// checked before anything else runs, or "optional".
+	Auth string `json:"auth,omitempty"`
+	// CacheControl, Expires and Vary are the caching headers of
+	// successful GET and HEAD responses.
+	CacheControl string         `json:"cache_control,omitempty"`
+	Expires      policyDuration `json:"expires,omitempty"`
+	Vary         []string       `json:"vary,omitempty"`
+}
+
+// policyDuration is a duration written as a string, such as "1m30s".
+type policyDuration time.Duration
+
+func (d *policyDuration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("durations are strings such as \"5s\": %v", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = policyDuration(v)
+	return nil
+}
+
+func (d policyDuration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (p endpointPolicy) check() error {
+	switch {
+	case p.Timeout < 0 || p.CacheTTL < 0 || p.Expires < 0:
+		return svcerrors.New(svcerrors.CodeInvalidArgument, "durations must not be negative")
+	case p.RateLimit < 0 || p.RateBurst < 0 || p.MaxBodyBytes < 0:
+		return svcerrors.New(svcerrors.CodeInvalidArgument, "limits must not be negative")
+	case p.Auth != "" && p.Auth != "optional" && p.Auth != "required":
+		return svcerrors.Errorf(svcerrors.CodeInvalidArgument, "auth must be optional or required, not %q", p.Auth)
+	}
+	return nil
+}
+
+// over returns p with its unset settings taken from def, except CacheTTL,
+// which endpoints opt into.
+func (p endpointPolicy) over(def endpointPolicy) endpointPolicy {
+	if p.Timeout == 0 {
+		p.Timeout = def.Timeout
+	}
+	if p.RateLimit == 0 {
+		p.RateLimit, p.RateBurst = def.RateLimit, def.RateBurst
+	}
+	if p.MaxBodyBytes == 0 {
+		p.MaxBodyBytes = def.MaxBodyBytes
+	}
+	if p.Auth == "" {
+		p.Auth = def.Auth
+	}
+	if p.CacheControl == "" {
+		p.CacheControl = def.CacheControl
+	}
+	if p.Expires == 0 {
+		p.Expires = def.Expires
+	}
+	if p.Vary == nil {
+		p.Vary = def.Vary
+	}
+	return p
+}
+
+// loadPolicyFile reads a policy file, YAML for a .yaml or .yml extension
+// and JSON otherwise.
+func loadPolicyFile(path string) (policyFile, error) {
+	var f policyFile
+	if err := readSettingsFile(path, &f); err != nil {
+		return policyFile{}, err
+	}
+	if err := f.Default.check(); err != nil {
+		return policyFile{}, fmt.Errorf("%s: default: %v", path, err)
+	}
+	if f.Default.CacheTTL != 0 {
+		return policyFile{}, fmt.Errorf("%s: default: cache_ttl is set by endpoint", path)
+	}
+	for p, ep := range f.Endpoints {
+		if !strings.HasPrefix(p, "/") {
+			return policyFile{}, fmt.Errorf("%s: %q is not a path", path, p)
+		}
+		if err := ep.check(); err != nil {
+			return policyFile{}, fmt.Errorf("%s: %s: %v", path, p, err)
+		}
+	}
+	return f, nil
+}
+
+// readSettingsFile decodes an operator's settings file into v, rejecting
+// fields v doesn't have. The file is YAML for a .yaml or .yml extension
+// and JSON otherwise; YAML is converted to JSON, so that both are read by
+// the same rules.
+func readSettingsFile(path string, v interface{}) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if ext := filepath.Ext(path); ext == ".yaml" || ext == ".yml" {
+		var doc interface{}
+		if err := yaml.Unmarshal(b, &doc); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		if b, err = json.Marshal(doc); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
+
+// activePolicy is an endpoint's policy with its rate limiter.
+type activePolicy struct {
+	endpointPolicy
+	limiter rateLimiter // nil when unlimited
+}
+
+// policySet holds the policies in force.
+type policySet struct {
+	path     string
+	limiters limiterSource
+	now      func() time.Time
+
+	mtx      sync.RWMutex
+	def      activePolicy
+	patterns []string // longest first
+	byPath   map[string]activePolicy
+	cache    *responseCache
+}
+
+func newPolicySet(path string, limiters limiterSource, now func() time.Time) *policySet {
+	return &policySet{path: path, limiters: limiters, now: now, cache: newResponseCache(now)}
+}
+
+// Load reads the policy file again and puts its policies in force.
+func (s *policySet) Load() error {
+	f, err := loadPolicyFile(s.path)
+	if err != nil {
+		return err
+	}
+	byPath := make(map[string]activePolicy, len(f.Endpoints))
+	patterns := make([]string, 0, len(f.Endpoints))
+	for p, ep := range f.Endpoints {
+		byPath[p] = s.activate(p, ep.over(f.Default))
+		patterns = append(patterns, p)
+	}
+	sort.Slice(patterns, func(i, j int) bool { return len(patterns[i]) > len(patterns[j]) })
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.def, s.patterns, s.byPath = s.activate("default", f.Default), patterns, byPath
+	s.cache = newResponseCache(s.now)
+	return nil
+}
+
+// activate gives p, the policy of path or "default", its rate limiter.
+func (s *policySet) activate(path string, p endpointPolicy) activePolicy {
+	ap := activePolicy{endpointPolicy: p}
+	if p.RateLimit > 0 {
+		burst := p.RateBurst
+		if burst == 0 {
+			burst = 1
+		}
+		ap.limiter = s.limiters.limiter("policy:"+path, p.RateLimit, burst)
+	}
+	return ap
+}
+
+// For returns the policy of the endpoint at path.
+func (s *policySet) For(path string) (activePolicy, *responseCache) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	if p, ok := s.byPath[path]; ok {
+		return p, s.cache
+	}
+	for _, pattern := range s.patterns {
+		if strings.HasSuffix(pattern, "/") && strings.HasPrefix(path, pattern) {
+			return s.byPath[pattern], s.cache
+		}
+	}
+	return s.def, s.cache
+}
+
+// policyMiddleware applies the policy of each request's path. Paths that
+// require auth refuse requests without a principal, as
+// authenticatingMiddleware finds it; without -auth-clients, that is every
+// request.
+func policyMiddleware(s *policySet) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p, cache := s.For(r.URL.Path)
+			if _, ok := principal(r.Context()); p.Auth == "required" && !ok {
+				err := authError(r.Context())
+				if err == nil {
+					err = svcerrors.Errorf(svcerrors.CodeUnauthenticated, "%s needs a bearer token", r.URL.Path)
+				}
+				encodeError(r.Context(), err, w)
+				return
+			}
+			if p.limiter != nil {
+				allowed, state := p.limiter.Allow(r.Context())
+				noteRateLimit(r.Context(), state)
+				if !allowed {
+					encodeError(r.Context(), svcerrors.ErrRateLimited, w)
+					return
+				}
+			}
+			// A tenant's own limit, applied by tenantConfigMiddleware,
+			// replaces the policy's.
+			if p.MaxBodyBytes > 0 && tenantOverridesFromContext(r.Context()).MaxBodyBytes == 0 {
+				if r.ContentLength > p.MaxBodyBytes {
+					encodeError(r.Context(), svcerrors.Errorf(svcerrors.CodePayloadTooLarge, "request body is over %d bytes", p.MaxBodyBytes), w)
+					return
+				}
+				r.Body = http.MaxBytesReader(w, r.Body, p.MaxBodyBytes)
+			}
+			if p.Timeout > 0 {
+				ctx, cancel := context.WithTimeout(r.Context(), time.Duration(p.Timeout))
+				defer cancel()
+				r = r.WithContext(ctx)
+			}
+			if (r.Method == http.MethodGet || r.Method == http.MethodHead) && (p.CacheControl != "" || p.Expires > 0 || len(p.Vary) > 0) {
+				h := cacheHeaders{CacheControl: p.CacheControl, Vary: p.Vary}
+				if p.Expires > 0 {
+					h.Expires = s.now().Add(time.Duration(p.Expires))
+				}
+				r = r.WithContext(context.WithValue(r.Context(), cacheHeadersContextKey{}, h))
+			}
+			if p.CacheTTL > 0 && (r.Method == http.MethodGet || r.Method == http.MethodHead) && authError(r.Context()) == nil {
+				cache.serve(w, r, time.Duration(p.CacheTTL), next)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// cacheHeaders are the caching headers policyMiddleware has encodeResponse
+// set on a successful response.
+type cacheHeaders struct {
+	CacheControl string
+	Expires      time.Time // unset when zero
+	Vary         []string
+}
+
+type cacheHeadersContextKey struct{}
+
+// setCacheHeaders sets the caching headers the request's policy gives, if
+// any, on w.
+func setCacheHeaders(ctx context.Context, w http.ResponseWriter) {
+	h, ok := ctx.Value(cacheHeadersContextKey{}).(cacheHeaders)
+	if !ok {
+		return
+	}
+	if h.CacheControl != "" {
+		w.Header().Set("Cache-Control", h.CacheControl)
+	}
+	if !h.Expires.IsZero() {
+		w.Header().Set("Expires", h.Expires.UTC().Format(http.TimeFormat))
+	}
+	for _, v := range h.Vary {
+		w.Header().Add("Vary", v)
+	}
+}
+
+// responseCacheSize bounds the responses a responseCache keeps, and
+// responseCacheMaxBytes the size of each; streamed responses aren't kept.
+const (
+	responseCacheSize     = 10000
+	responseCacheMaxBytes = 1 << 20
+)
+
+// responseCache keeps successful responses for their policy's cache TTL.
+// Expired responses are swept lazily, when the cache is full.
+type responseCache struct {
+	now func() time.Time
+
+	mtx     sync.Mutex
+	entries map[[sha256.Size]byte]cachedResponse
+}
+
+type cachedResponse struct {
+	resp    recordedResponse
+	expires time.Time
+}
+
+func newResponseCache(now func() time.Time) *responseCache {
+	return &responseCache{now: now, entries: map[[sha256.Size]byte]cachedResponse{}}
+}
+
+// serve answers r, a GET or HEAD request, from the cache, or with next,
+// caching a 2xx response.
+func (c *responseCache) serve(w http.ResponseWriter, r *http.Request, ttl time.Duration, next http.Handler) {
+	var subject string
+	if t, ok := principal(r.Context()); ok {
+		subject = t.Subject
+	}
+	h := sha256.New()
+	for _, s := range []string{r.Method, r.URL.RequestURI(), r.Header.Get("Accept"), subject, tenantVariant(r.Context())} {
+		h.Write([]byte(s + "\n"))
+	}
+	var key [sha256.Size]byte
+	copy(key[:], h.Sum(nil))
+
+	now := c.now()
+	c.mtx.Lock()
+	e, ok := c.entries[key]
+	c.mtx.Unlock()
+	if ok && now.Before(e.expires) {
+		replay(w, r, &e.resp)
+		w.Header().Set("X-Cache", "hit")
+		w.WriteHeader(e.resp.Status)
+		w.Write(e.resp.Body)
+		return
+	}
+
+	rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK, max: responseCacheMaxBytes}
+	next.ServeHTTP(rec, r)
+	if rec.status < 200 || rec.status > 299 || rec.unkept {
+		return
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if len(c.entries) >= responseCacheSize {
+		for k, e := range c.entries {
+			if !now.Before(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= responseCacheSize {
+			return
+		}
+	}
+	c.entries[key] = cachedResponse{recordedResponse{Status: rec.status, Header: w.Header().Clone(), Body: rec.body.Bytes()}, now.Add(ttl)}
+}
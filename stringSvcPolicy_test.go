@@ -0,0 +1,114 @@
+package stringsvc
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestPolicySet(t *testing.T, file string) *policySet {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	if err := os.WriteFile(path, []byte(file), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	now := func() time.Time { return time.Unix(0, 0) }
+	s := newPolicySet(path, limiterSource{now: now}, now)
+	if err := s.Load(); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestPolicyCache(t *testing.T) {
+	s := newTestPolicySet(t, `
+default: {max_body_bytes: 16}
+endpoints:
+  /detect: {cache_ttl: 1m}
+`)
+	calls := 0
+	h := policyMiddleware(s)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		fmt.Fprint(w, calls)
+	}))
+	call := func(method, path string, ctx context.Context) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(method, path, nil).WithContext(ctx))
+		return w
+	}
+	bg := context.Background()
+
+	call("GET", "/detect?text=hello", bg)
+	if w := call("GET", "/detect?text=hello", bg); w.Header().Get("X-Cache") != "hit" || w.Body.String() != "1" {
+		t.Errorf("repeated GET: got %q, X-Cache %q, want the cached 1", w.Body, w.Header().Get("X-Cache"))
+	}
+	if w := call("POST", "/detect?text=hello", bg); w.Body.String() != "2" {
+		t.Errorf("POST: got %q, want a fresh 2", w.Body)
+	}
+	call("GET", "/uuid", bg)
+	if w := call("GET", "/uuid", bg); w.Header().Get("X-Cache") != "" {
+		t.Error("an endpoint without cache_ttl was served from the cache")
+	}
+
+	payments := context.WithValue(bg, tenantKey{}, tenant{ID: "payments"})
+	if w := call("GET", "/detect?text=hello", payments); w.Header().Get("X-Cache") != "" {
+		t.Error("another tenant was served the cached response")
+	}
+	revoked := context.WithValue(bg, authErrorKey{}, errInvalidToken)
+	if w := call("GET", "/detect?text=hello", revoked); w.Header().Get("X-Cache") != "" {
+		t.Error("a request whose token failed was served from the cache")
+	}
+}
+
+func TestPolicyRateLimit(t *testing.T) {
+	s := newTestPolicySet(t, `
+endpoints:
+  /count: {rate_limit: 1, rate_burst: 2}
+`)
+	h := policyMiddleware(s)(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	call := func(path string) int {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w.Code
+	}
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		if got := call("/count?s=a"); got != want {
+			t.Errorf("call %d: got %d, want %d", i+1, got, want)
+		}
+	}
+	if got := call("/uppercase?s=a"); got != http.StatusOK {
+		t.Errorf("another path: got %d, want 200", got)
+	}
+	if err := s.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if got := call("/count?s=a"); got != http.StatusOK {
+		t.Errorf("after reloading: got %d, want 200", got)
+	}
+}
+
+func TestPolicyMaxBodyBytes(t *testing.T) {
+	s := newTestPolicySet(t, "default: {max_body_bytes: 4}\n")
+	h := policyMiddleware(s)(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/uppercase", strings.NewReader(`{"s":"hello"}`)))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("got %d, want 413", w.Code)
+	}
+}
+
+func TestPolicyDefaultCacheTTL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	if err := os.WriteFile(path, []byte("default: {cache_ttl: 1m}\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadPolicyFile(path); err == nil {
+		t.Error("a default cache_ttl was accepted")
+	}
+}
@@ -0,0 +1,187 @@
+package stringsvc
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-kit/kit/endpoint"
+	"gopkg.in/yaml.v3"
+
+	"github.com/mcclayac/gokit/redact"
+	"github.com/mcclayac/gokit/svcerrors"
+)
+
+// A profile sets flags from YAML files of flag names and values, layered
+// over the defaults in this order:
+//
+//	<dir>/base.yaml       shared by every environment
+//	<dir>/<profile>.yaml  the environment, such as dev, staging or prod
+//	<dir>/local.yaml      a developer's own overrides, kept out of git
+//
+// Flags given on the command line override them all. Only the profile's
+// own file must exist. A value may be a list, for flags that take
+// comma-separated values:
+//
+//	kafka-brokers: [kafka-1:9092, kafka-2:9092]
+//	trace-sample-ratio: 0.1
+
+// profileEnv names the profile when -profile isn't given.
+const profileEnv = "STRINGSVC_PROFILE"
+
+// profileConfig selects the profile and keeps the effective settings.
+type profileConfig struct {
+	Name string
+	Dir  string
+
+	// Settings are every flag's effective value and where it came from,
+	// for /admin/config. Secrets are masked.
+	Settings []configSetting
+}
+
+// configSetting is a flag's effective value. Source is "default",
+// "command line", or the profile file that set it.
+type configSetting struct {
+	Name   string `json:"name" xml:"name"`
+	Value  string `json:"value" xml:"value"`
+	Source string `json:"source" xml:"source"`
+}
+
+// profileLayer is the flags set by one profile file.
+type profileLayer struct {
+	file   string
+	values map[string]string
+}
+
+// loadProfile returns the layers of the profile, base first.
+func loadProfile(cfg profileConfig) ([]profileLayer, error) {
+	var layers []profileLayer
+	files := []string{"base.yaml"}
+	if cfg.Name != "" {
+		if strings.ContainsAny(cfg.Name, `/\.`) {
+			return nil, fmt.Errorf("-profile %q is not a profile name", cfg.Name)
+		}
+		files = append(files, cfg.Name+".yaml")
+	}
+	files = append(files, "local.yaml")
+	for _, name := range files {
+		path := filepath.Join(cfg.Dir, name)
+		b, err := os.ReadFile(path)
+		if os.IsNotExist(err) && name != cfg.Name+".yaml" {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var doc map[string]interface{}
+		if err := yaml.Unmarshal(b, &doc); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		layer := profileLayer{file: path, values: map[string]string{}}
+		for k, v := range doc {
+			switch v := v.(type) {
+			case map[string]interface{}:
+				return nil, fmt.Errorf("%s: %s: want a value or a list, not a map", path, k)
+			case []interface{}:
+				vs := make([]string, len(v))
+				for i, e := range v {
+					vs[i] = fmt.Sprint(e)
+				}
+				layer.values[k] = strings.Join(vs, ",")
+			case nil:
+				layer.values[k] = ""
+			default:
+				layer.values[k] = fmt.Sprint(v)
+			}
+		}
+		layers = append(layers, layer)
+	}
+	return layers, nil
+}
+
+// applyProfile sets fs's flags from the profile's layers, except those set
+// on the command line, and returns the effective settings.
+func applyProfile(fs *flag.FlagSet, cfg profileConfig) ([]configSetting, error) {
+	sources := map[string]string{}
+	fs.Visit(func(f *flag.Flag) { sources[f.Name] = "command line" })
+	layers, err := loadProfile(cfg)
+	if err != nil {
+		return nil, err
+	}
+	for _, l := range layers {
+		names := make([]string, 0, len(l.values))
+		for name := range l.values {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			switch {
+			case name == "profile" || name == "profile-dir":
+				return nil, fmt.Errorf("%s: %s can't be set in a profile", l.file, name)
+			case fs.Lookup(name) == nil:
+				return nil, fmt.Errorf("%s: no flag %s", l.file, name)
+			case sources[name] == "command line":
+				continue
+			}
+			if err := fs.Set(name, l.values[name]); err != nil {
+				return nil, fmt.Errorf("%s: %s: %v", l.file, name, err)
+			}
+			sources[name] = l.file
+		}
+	}
+	var settings []configSetting
+	fs.VisitAll(func(f *flag.Flag) {
+		source := sources[f.Name]
+		if source == "" {
+			source = "default"
+		}
+		settings = append(settings, configSetting{f.Name, redactSetting(f.Name, f.Value.String()), source})
+	})
+	return settings, nil
+}
+
+// secretFlagSuffixes end the names of flags whose values are secrets.
+var secretFlagSuffixes = []string{"-key", "-secret", "-dsn", "-password", "-token"}
+
+// redactSetting masks the value of a secret flag, and the password of a
+// URL with credentials.
+func redactSetting(name, value string) string {
+	if value == "" {
+		return value
+	}
+	for _, suffix := range secretFlagSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return redact.Mask
+		}
+	}
+	if u, err := url.Parse(value); err == nil && u.User != nil {
+		return u.Redacted()
+	}
+	return value
+}
+
+type configRequest struct{}
+
+type configResponse struct {
+	Profile  string          `json:"profile" xml:"profile"`
+	Settings []configSetting `json:"settings" xml:"settings>setting"`
+}
+
+func makeConfigEndpoint(cfg profileConfig) endpoint.Endpoint {
+	return func(context.Context, interface{}) (interface{}, error) {
+		return configResponse{cfg.Name, cfg.Settings}, nil
+	}
+}
+
+func decodeConfigRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	if r.Method != http.MethodGet {
+		return nil, svcerrors.Errorf(svcerrors.CodeInvalidArgument, "%s /admin/config: want GET", r.Method)
+	}
+	return configRequest{}, nil
+}
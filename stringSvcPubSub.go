@@ -0,0 +1,314 @@
+package stringsvc
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	httptransport "github.com/go-kit/kit/transport/http"
+	"go.opentelemetry.io/otel/propagation"
+
+	"github.com/mcclayac/gokit/svcerrors"
+	"github.com/mcclayac/gokit/tracing"
+)
+
+// pubsubConfig configures the Google Cloud Pub/Sub worker. Credentials come
+// from Application Default Credentials.
+type pubsubConfig struct {
+	Project        string
+	Subscription   string        // the Pub/Sub worker is off when empty
+	AckDeadline    time.Duration // lease period, renewed while a message is handled
+	MaxOutstanding int           // messages handled at once
+	// DeadLetterTopic receives the messages given up on, which are dropped
+	// when it is empty. DeadLetterSubscription, a subscription to it, is
+	// needed to list and redrive them.
+	DeadLetterTopic        string
+	DeadLetterSubscription string
+}
+
+// pubsubWorker receives from a subscription. Messages carry the same
+// attributes as SQS messages (Operation, Content-Type, Accept, Reply-To
+// naming a topic, X-Request-Id and the trace headers). Messages with an
+// ordering key are handled one at a time per key, in the order they
+// arrive, which keeps them in order when the subscription has message
+// ordering enabled. A message that may succeed on retry is held for the
+// backoff delay and then nacked, so later messages with its ordering key
+// wait for it. Pub/Sub only reports delivery attempts to subscriptions with
+// a dead-letter policy; without one, failing messages are retried until
+// they expire rather than dead-lettered.
+type pubsubWorker struct {
+	client    *pubsub.Client
+	sub       *pubsub.Subscription
+	topic     string // that sub is attached to, where redriven messages go
+	cfg       workerConfig
+	endpoints map[string]messageEndpoint
+	env       *appEnv
+	logger    log.Logger
+
+	mtx    sync.Mutex
+	keys   map[string]*keyLock
+	topics sync.Map // name -> *pubsub.Topic
+}
+
+func newPubSubWorker(ctx context.Context, cfg workerConfig, endpoints map[string]messageEndpoint, env *appEnv, logger log.Logger) (*pubsubWorker, error) {
+	client, err := pubsub.NewClient(ctx, cfg.PubSub.Project)
+	if err != nil {
+		return nil, err
+	}
+	sub := client.Subscription(cfg.PubSub.Subscription)
+	subCfg, err := sub.Config(ctx)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	sub.ReceiveSettings.MinExtensionPeriod = cfg.PubSub.AckDeadline
+	sub.ReceiveSettings.MaxExtensionPeriod = cfg.PubSub.AckDeadline
+	sub.ReceiveSettings.MaxOutstandingMessages = cfg.PubSub.MaxOutstanding
+	return &pubsubWorker{
+		client:    client,
+		sub:       sub,
+		topic:     subCfg.Topic.ID(),
+		cfg:       cfg,
+		endpoints: endpoints,
+		env:       env,
+		logger:    logger,
+		keys:      map[string]*keyLock{},
+	}, nil
+}
+
+func (w *pubsubWorker) Run(ctx context.Context) error {
+	defer w.client.Close()
+	defer w.stopTopics()
+	return w.sub.Receive(ctx, w.handle)
+}
+
+func (w *pubsubWorker) DeadLetters() deadLetterQueue {
+	if w.cfg.PubSub.DeadLetterTopic == "" || w.cfg.PubSub.DeadLetterSubscription == "" {
+		return nil
+	}
+	return w
+}
+
+func (w *pubsubWorker) handle(_ context.Context, m *pubsub.Message) {
+	if m.OrderingKey != "" {
+		defer w.lockKey(m.OrderingKey)()
+	}
+	logger := log.With(w.logger, "message_id", m.ID)
+
+	// Like the SQS worker, handling runs to completion even when the worker
+	// is stopped; Receive waits for it.
+	ctx := tracing.Propagator.Extract(w.env.attach(context.Background()), propagation.MapCarrier(m.Attributes))
+	ctx = context.WithValue(ctx, httptransport.ContextKeyRequestXRequestID, m.Attributes[requestIDHeader])
+
+	attempt := 1
+	if m.DeliveryAttempt != nil {
+		attempt = *m.DeliveryAttempt
+	}
+	response, outcome, err := handleMessage(ctx, w.endpoints, m.Attributes[sqsOperationAttribute], m.Attributes["Content-Type"], m.Data)
+	switch outcome {
+	case messageRejected:
+		level.Error(logger).Log("msg", "rejecting message", "err", err)
+		w.giveUp(m, logger, attempt, err)
+	case messageRetry:
+		if m.DeliveryAttempt != nil && attempt >= w.cfg.MaxDeliver {
+			level.Error(logger).Log("msg", "giving up on message", "attempts", attempt, "err", err)
+			w.giveUp(m, logger, attempt, err)
+			return
+		}
+		delay := redeliveryDelay(w.cfg.Backoff, attempt)
+		level.Warn(logger).Log("msg", "retrying message", "attempt", attempt, "delay", delay, "err", err)
+		time.Sleep(delay)
+		m.Nack()
+	default:
+		w.reply(m, response, err)
+		m.Ack()
+	}
+}
+
+// giveUp dead-letters m, replies with err and acks it. If the dead-letter
+// topic can't take the message, it is nacked to be tried again rather than
+// lost.
+func (w *pubsubWorker) giveUp(m *pubsub.Message, logger log.Logger, attempts int, err error) {
+	if w.cfg.PubSub.DeadLetterTopic != "" {
+		attrs := map[string]string{}
+		for k, v := range m.Attributes {
+			attrs[k] = v
+		}
+		for k, v := range deadLetterHeaders(w.topic, attempts, err) {
+			attrs[k] = v
+		}
+		if derr := w.publish(context.Background(), w.cfg.PubSub.DeadLetterTopic, &pubsub.Message{
+			Data:        m.Data,
+			Attributes:  attrs,
+			OrderingKey: m.OrderingKey,
+		}); derr != nil {
+			level.Error(logger).Log("msg", "dead-lettering message", "err", derr)
+			m.Nack()
+			return
+		}
+	}
+	w.reply(m, nil, err)
+	m.Ack()
+}
+
+// reply publishes the response, or the error envelope when err is non-nil,
+// to the topic in the message's Reply-To attribute, with the message's
+// ordering key.
+func (w *pubsubWorker) reply(m *pubsub.Message, response interface{}, err error) {
+	name := m.Attributes[sqsReplyToAttribute]
+	if name == "" {
+		return
+	}
+	contentType, body, err := encodeReply(w.env.codecs, m.Attributes["Accept"], response, err)
+	if err != nil {
+		level.Error(w.logger).Log("msg", "encoding reply", "err", err)
+		return
+	}
+	attrs := map[string]string{"Content-Type": contentType}
+	if id := m.Attributes[requestIDHeader]; id != "" {
+		attrs[requestIDHeader] = id
+	}
+	err = w.publish(context.Background(), name, &pubsub.Message{
+		Data:        body,
+		Attributes:  attrs,
+		OrderingKey: m.OrderingKey,
+	})
+	if err != nil {
+		level.Error(w.logger).Log("msg", "publishing reply", "topic", name, "err", err)
+	}
+}
+
+// publish publishes m to the named topic and waits for the server to
+// accept it.
+func (w *pubsubWorker) publish(ctx context.Context, topic string, m *pubsub.Message) error {
+	_, err := w.topicHandle(topic).Publish(ctx, m).Get(ctx)
+	return err
+}
+
+func (w *pubsubWorker) topicHandle(name string) *pubsub.Topic {
+	if t, ok := w.topics.Load(name); ok {
+		return t.(*pubsub.Topic)
+	}
+	t := w.client.Topic(name)
+	t.EnableMessageOrdering = true
+	if actual, loaded := w.topics.LoadOrStore(name, t); loaded {
+		t.Stop()
+		return actual.(*pubsub.Topic)
+	}
+	return t
+}
+
+func (w *pubsubWorker) stopTopics() {
+	w.topics.Range(func(_, t interface{}) bool {
+		t.(*pubsub.Topic).Stop()
+		return true
+	})
+}
+
+// pubsubScanTime bounds how long List and Redrive receive from the
+// dead-letter subscription.
+const pubsubScanTime = 5 * time.Second
+
+// List receives up to limit dead letters from the dead-letter subscription
+// and nacks them, so they stay in it. Receiving counts as a delivery. Their
+// IDs are Pub/Sub message IDs.
+func (w *pubsubWorker) List(ctx context.Context, limit int) ([]deadLetter, error) {
+	var dls []deadLetter
+	seen := map[string]bool{}
+	err := w.scanDeadLetters(ctx, func(m *pubsub.Message) bool {
+		m.Nack()
+		if !seen[m.ID] {
+			seen[m.ID] = true
+			dls = append(dls, newDeadLetter(m.ID, m.Attributes, m.Data))
+		}
+		return len(dls) >= limit
+	})
+	return dls, err
+}
+
+// Redrive receives from the dead-letter subscription until it finds the
+// message with the given ID, which it publishes to the worker's topic and
+// acks. Other messages are nacked.
+func (w *pubsubWorker) Redrive(ctx context.Context, id string) error {
+	var found bool
+	var perr error
+	err := w.scanDeadLetters(ctx, func(m *pubsub.Message) bool {
+		if m.ID != id {
+			m.Nack()
+			return false
+		}
+		dl := newDeadLetter(m.ID, m.Attributes, m.Data)
+		if perr = w.publish(ctx, dl.Source, &pubsub.Message{Data: m.Data, Attributes: dl.Headers, OrderingKey: m.OrderingKey}); perr != nil {
+			m.Nack()
+		} else {
+			m.Ack()
+		}
+		found = true
+		return true
+	})
+	switch {
+	case err != nil:
+		return err
+	case perr != nil:
+		return perr
+	case !found:
+		return svcerrors.Errorf(svcerrors.CodeNotFound, "no dead letter %q", id)
+	}
+	return nil
+}
+
+// scanDeadLetters passes messages from the dead-letter subscription to fn,
+// one at a time, until fn returns true or pubsubScanTime has passed. fn
+// must ack or nack each message.
+func (w *pubsubWorker) scanDeadLetters(ctx context.Context, fn func(*pubsub.Message) (done bool)) error {
+	ctx, cancel := context.WithTimeout(ctx, pubsubScanTime)
+	defer cancel()
+	var (
+		mtx  sync.Mutex
+		done bool
+	)
+	return w.client.Subscription(w.cfg.PubSub.DeadLetterSubscription).Receive(ctx, func(_ context.Context, m *pubsub.Message) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		if done {
+			m.Nack()
+			return
+		}
+		if done = fn(m); done {
+			cancel()
+		}
+	})
+}
+
+// keyLock serializes the messages with one ordering key. It is removed from
+// pubsubWorker.keys when no message holds or waits for it.
+type keyLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lockKey blocks until no other message with key is being handled, and
+// returns the function that releases the key.
+func (w *pubsubWorker) lockKey(key string) (unlock func()) {
+	w.mtx.Lock()
+	l, ok := w.keys[key]
+	if !ok {
+		l = &keyLock{}
+		w.keys[key] = l
+	}
+	l.refs++
+	w.mtx.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		w.mtx.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(w.keys, key)
+		}
+		w.mtx.Unlock()
+	}
+}
@@ -0,0 +1,163 @@
+package stringsvc
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-kit/kit/endpoint"
+	qrcode "github.com/skip2/go-qrcode"
+
+	"github.com/mcclayac/gokit/svcerrors"
+)
+
+// QRCodeService renders strings as QR codes.
+type QRCodeService interface {
+	// QRCode renders s as a size by size pixel image in format, "png" or
+	// "svg", with error correction level "L", "M", "Q" or "H".
+	QRCode(ctx context.Context, s, format string, size int, level string) (qrImage, error)
+}
+
+// qrImage is a rendered QR code.
+type qrImage struct {
+	ContentType string `json:"content_type" xml:"content_type"`
+	Data        []byte `json:"data" xml:"data"`
+}
+
+var qrLevels = map[string]qrcode.RecoveryLevel{
+	"L": qrcode.Low,
+	"M": qrcode.Medium,
+	"Q": qrcode.High,
+	"H": qrcode.Highest,
+}
+
+// qrcodeService is a concrete implementation of QRCodeService.
+type qrcodeService struct{}
+
+func (qrcodeService) QRCode(ctx context.Context, s, format string, size int, level string) (qrImage, error) {
+	if err := ctx.Err(); err != nil {
+		return qrImage{}, err
+	}
+	if s == "" {
+		return qrImage{}, ErrEmpty
+	}
+	rl, ok := qrLevels[level]
+	if !ok {
+		return qrImage{}, svcerrors.Errorf(svcerrors.CodeInvalidArgument, "unknown error correction level %q: want L, M, Q or H", level)
+	}
+	q, err := qrcode.New(s, rl)
+	if err != nil {
+		// The only way for valid input to fail is to be too long for the
+		// largest QR code at this level.
+		return qrImage{}, svcerrors.Errorf(svcerrors.CodeInvalidArgument, "cannot encode input: %v", err)
+	}
+	switch format {
+	case "png":
+		b, err := q.PNG(size)
+		return qrImage{"image/png", b}, err
+	case "svg":
+		return qrImage{"image/svg+xml", qrSVG(q.Bitmap(), size)}, nil
+	default:
+		return qrImage{}, svcerrors.Errorf(svcerrors.CodeInvalidArgument, "unknown image format %q: want png or svg", format)
+	}
+}
+
+// qrSVG draws bitmap, which includes the quiet zone, as an SVG image of
+// size pixels square, one path for all the dark modules.
+func qrSVG(bitmap [][]bool, size int) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`,
+		size, size, len(bitmap), len(bitmap))
+	b.WriteString(`<rect width="100%" height="100%" fill="#fff"/><path fill="#000" d="`)
+	for y, row := range bitmap {
+		for x, dark := range row {
+			if dark {
+				fmt.Fprintf(&b, "M%d %dh1v1h-1z", x, y)
+			}
+		}
+	}
+	b.WriteString(`"/></svg>`)
+	return b.Bytes()
+}
+
+// qrcodeRequest holds the input and rendering parameters. QR codes hold at
+// most 2953 bytes, fewer at higher error correction levels.
+type qrcodeRequest struct {
+	S      string `json:"s" xml:"s" validate:"required,max=2953"`
+	Format string `json:"format" xml:"format"` // defaults from the Accept header, else png
+	Size   int    `json:"size" xml:"size" validate:"min=0,max=4096"`
+	Level  string `json:"level" xml:"level"`
+}
+
+type qrcodeResponse struct {
+	Image qrImage `json:"image" xml:"image"`
+	Err   error   `json:"-" xml:"-"`
+}
+
+// Failed implements endpoint.Failer.
+func (r qrcodeResponse) Failed() error { return r.Err }
+
+// The defaults for the optional rendering parameters.
+const (
+	defaultQRSize  = 256
+	defaultQRLevel = "M"
+)
+
+func makeQRCodeEndpoint(svc QRCodeService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(qrcodeRequest)
+		if req.Format == "" {
+			req.Format = "png"
+		}
+		if req.Size == 0 {
+			req.Size = defaultQRSize
+		}
+		if req.Level == "" {
+			req.Level = defaultQRLevel
+		}
+		img, err := svc.QRCode(ctx, req.S, req.Format, req.Size, strings.ToUpper(req.Level))
+		return qrcodeResponse{img, err}, nil
+	}
+}
+
+// decodeQRCodeRequest accepts the parameters in the body, or for GET
+// requests in the query string (s, format, size and level). Without a
+// format, SVG is chosen if the Accept header asks for it.
+func decodeQRCodeRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request qrcodeRequest
+	if r.Method == http.MethodGet {
+		q := r.URL.Query()
+		request.S, request.Format, request.Level = q.Get("s"), q.Get("format"), q.Get("level")
+		if size := q.Get("size"); size != "" {
+			n, err := strconv.Atoi(size)
+			if err != nil {
+				return nil, svcerrors.Errorf(svcerrors.CodeInvalidArgument, "size %q is not a number", size)
+			}
+			request.Size = n
+		}
+	} else if err := decodeBody(r, &request); err != nil {
+		return nil, err
+	}
+	if request.Format == "" && strings.Contains(r.Header.Get("Accept"), "image/svg+xml") {
+		request.Format = "svg"
+	}
+	return request, nil
+}
+
+// encodeQRCodeResponse writes the image itself rather than a codec's
+// encoding of the response, so the endpoint can be used as an image source.
+func encodeQRCodeResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	resp := response.(qrcodeResponse)
+	if resp.Err != nil {
+		encodeError(ctx, resp.Err, w)
+		return nil
+	}
+	setCacheHeaders(ctx, w)
+	w.Header().Set("Content-Type", resp.Image.ContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(resp.Image.Data)))
+	_, err := w.Write(resp.Image.Data)
+	return err
+}
@@ -0,0 +1,49 @@
+package stringsvc
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDecodeQuery(t *testing.T) {
+	ctx := context.Background()
+	req, err := decodeUppercaseRequest(ctx, httptest.NewRequest("GET", "/uppercase?s=hello+world", nil))
+	if err != nil || req.(uppercaseRequest).S != "hello world" {
+		t.Errorf("GET /uppercase: got %+v, %v", req, err)
+	}
+	req, err = decodeCountRequest(ctx, httptest.NewRequest("GET", "/count?s=%C3%A9t%C3%A9", nil))
+	if err != nil || req.(countRequest).S != "été" {
+		t.Errorf("GET /count: got %+v, %v", req, err)
+	}
+	// A body wins over the query.
+	r := httptest.NewRequest("POST", "/uppercase?s=query", strings.NewReader(`{"s":"body"}`))
+	r.Header.Set("Content-Type", "application/json")
+	if req, err := decodeUppercaseRequest(ctx, r); err != nil || req.(uppercaseRequest).S != "body" {
+		t.Errorf("POST with a query: got %+v, %v", req, err)
+	}
+	if _, err := decodeHostnameRequest(ctx, httptest.NewRequest("GET", "/hostname", nil)); err != nil {
+		t.Errorf("GET /hostname: %v", err)
+	}
+}
+
+func TestGetOverHTTP(t *testing.T) {
+	srv := newTestServer(t)
+	for _, c := range []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/uppercase?s=hello", http.StatusOK, `"v":"HELLO"`},
+		{"/count?s=hello", http.StatusOK, `"v":5`},
+		{"/uppercase", http.StatusBadRequest, `"code":"STRING_EMPTY"`},
+		{"/hostname", http.StatusOK, `"v":`},
+	} {
+		resp, b := callTestServer(t, srv, "GET", c.path, nil, "")
+		if resp.StatusCode != c.status || !strings.Contains(string(b), c.body) {
+			t.Errorf("GET %s: got %d %s, want %d with %s", c.path, resp.StatusCode, b, c.status, c.body)
+		}
+	}
+}
@@ -0,0 +1,319 @@
+package stringsvc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/time/rate"
+
+	"github.com/mcclayac/gokit/svcerrors"
+)
+
+// A tenant may have quotas on its requests and on the bytes they carry,
+// request and response bodies together, by UTC day and by month:
+//
+//	tenants:
+//	  - id: search
+//	    quotas:
+//	      daily: {requests: 100000}
+//	      monthly: {requests: 2000000, bytes: 10000000000}
+//	      enforcement: throttle
+//	      throttle_rate: 5
+//
+// Once a quota is used up, enforcement decides what happens until the
+// period ends: "reject" (the default) refuses calls with QUOTA_EXCEEDED,
+// "throttle" lets them through at throttle_rate a second and refuses the
+// rest with RATE_LIMITED, and "log" only logs them, to try out a quota
+// before enforcing it. Every response to a tenant with quotas reports what
+// is left of each, as of the call:
+//
+//	X-Quota-Daily-Requests-Remaining: 41230
+//	X-Quota-Monthly-Bytes-Remaining: 8125000000
+//
+// Counts are kept with the rate limits, in Redis with
+// -rate-limit-redis-addr so that they hold across replicas, and per
+// process otherwise. A call whose bytes take the count past a bytes quota
+// still completes; the calls after it are refused.
+
+// tenantQuotas are a tenant's quotas. Zero limits are unlimited.
+type tenantQuotas struct {
+	Daily        quotaLimits `json:"daily,omitempty"`
+	Monthly      quotaLimits `json:"monthly,omitempty"`
+	Enforcement  string      `json:"enforcement,omitempty"`   // reject, throttle or log
+	ThrottleRate float64     `json:"throttle_rate,omitempty"` // calls a second when throttled
+}
+
+type quotaLimits struct {
+	Requests int64 `json:"requests,omitempty"`
+	Bytes    int64 `json:"bytes,omitempty"`
+}
+
+func (q tenantQuotas) enabled() bool {
+	return q.Daily != (quotaLimits{}) || q.Monthly != (quotaLimits{})
+}
+
+func (q tenantQuotas) check() error {
+	switch {
+	case q.Daily.Requests < 0 || q.Daily.Bytes < 0 || q.Monthly.Requests < 0 || q.Monthly.Bytes < 0:
+		return fmt.Errorf("quotas must not be negative")
+	case q.Enforcement != "" && q.Enforcement != "reject" && q.Enforcement != "throttle" && q.Enforcement != "log":
+		return fmt.Errorf("quota enforcement must be reject, throttle or log, not %q", q.Enforcement)
+	case q.Enforcement == "throttle" && q.ThrottleRate <= 0:
+		return fmt.Errorf("throttled quotas need a positive throttle_rate")
+	}
+	return nil
+}
+
+// quotaCount is what a tenant has used in a period.
+type quotaCount struct {
+	Requests int64
+	Bytes    int64
+}
+
+// quotaCounter keeps the counts quotas are checked against.
+type quotaCounter interface {
+	// Add adds requests and bytes to the count under key, which is
+	// dropped at expires, and returns the new count.
+	Add(ctx context.Context, key string, requests, bytes int64, expires time.Time) (quotaCount, error)
+}
+
+// memoryQuotaCounter counts in this process.
+type memoryQuotaCounter struct {
+	now func() time.Time
+
+	mtx    sync.Mutex
+	counts map[string]memoryQuotaEntry
+}
+
+type memoryQuotaEntry struct {
+	quotaCount
+	expires time.Time
+}
+
+func newMemoryQuotaCounter(now func() time.Time) *memoryQuotaCounter {
+	return &memoryQuotaCounter{now: now, counts: map[string]memoryQuotaEntry{}}
+}
+
+func (c *memoryQuotaCounter) Add(_ context.Context, key string, requests, bytes int64, expires time.Time) (quotaCount, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	now := c.now()
+	e, ok := c.counts[key]
+	if !ok || !now.Before(e.expires) {
+		// A new period; sweep the ones that have ended.
+		for k, old := range c.counts {
+			if !now.Before(old.expires) {
+				delete(c.counts, k)
+			}
+		}
+		e = memoryQuotaEntry{expires: expires}
+	}
+	e.Requests += requests
+	e.Bytes += bytes
+	c.counts[key] = e
+	return e.quotaCount, nil
+}
+
+// redisQuotaCounter keeps counts in Redis hashes, shared by the replicas.
+type redisQuotaCounter struct {
+	rdb    *redis.Client
+	prefix string
+}
+
+func (c redisQuotaCounter) Add(ctx context.Context, key string, requests, bytes int64, expires time.Time) (quotaCount, error) {
+	ctx, cancel := context.WithTimeout(ctx, redisLimiterTimeout)
+	defer cancel()
+	k := c.prefix + key
+	pipe := c.rdb.TxPipeline()
+	r := pipe.HIncrBy(ctx, k, "requests", requests)
+	b := pipe.HIncrBy(ctx, k, "bytes", bytes)
+	pipe.ExpireAt(ctx, k, expires)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return quotaCount{}, err
+	}
+	return quotaCount{r.Val(), b.Val()}, nil
+}
+
+// quotaPeriod is a day or a month, as it is counted.
+type quotaPeriod struct {
+	name   string // "daily" or "monthly"
+	key    string
+	ends   time.Time
+	limits quotaLimits
+}
+
+// quotaPeriods returns the periods of q that t falls in, those with limits.
+func quotaPeriods(tenantID string, q tenantQuotas, t time.Time) []quotaPeriod {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	month := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	var periods []quotaPeriod
+	if q.Daily != (quotaLimits{}) {
+		periods = append(periods, quotaPeriod{"daily", tenantID + ":" + day.Format("2006-01-02"), day.AddDate(0, 0, 1), q.Daily})
+	}
+	if q.Monthly != (quotaLimits{}) {
+		periods = append(periods, quotaPeriod{"monthly", tenantID + ":" + month.Format(usageMonth), month.AddDate(0, 1, 0), q.Monthly})
+	}
+	return periods
+}
+
+// exceeded reports whether c has used up a limit of p.
+func (p quotaPeriod) exceeded(c quotaCount) bool {
+	return (p.limits.Requests > 0 && c.Requests >= p.limits.Requests) || (p.limits.Bytes > 0 && c.Bytes >= p.limits.Bytes)
+}
+
+// setRemaining reports what c leaves of p's limits in w's headers.
+func (p quotaPeriod) setRemaining(w http.ResponseWriter, c quotaCount) {
+	name := "Daily"
+	if p.name == "monthly" {
+		name = "Monthly"
+	}
+	remaining := func(limit, used int64) string {
+		if used > limit {
+			return "0"
+		}
+		return strconv.FormatInt(limit-used, 10)
+	}
+	if p.limits.Requests > 0 {
+		w.Header().Set("X-Quota-"+name+"-Requests-Remaining", remaining(p.limits.Requests, c.Requests))
+	}
+	if p.limits.Bytes > 0 {
+		w.Header().Set("X-Quota-"+name+"-Bytes-Remaining", remaining(p.limits.Bytes, c.Bytes))
+	}
+}
+
+// quotaEnforcer checks and counts tenants' calls against their quotas.
+type quotaEnforcer struct {
+	counter quotaCounter
+	now     func() time.Time
+	logger  log.Logger
+
+	mtx       sync.Mutex
+	throttles map[string]localLimiter
+}
+
+func newQuotaEnforcer(counter quotaCounter, now func() time.Time, logger log.Logger) *quotaEnforcer {
+	return &quotaEnforcer{counter: counter, now: now, logger: logger, throttles: map[string]localLimiter{}}
+}
+
+// throttle returns the limiter of a tenant over its quota.
+func (e *quotaEnforcer) throttle(t tenant) localLimiter {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	l, ok := e.throttles[t.ID]
+	if !ok || l.limiter.Limit() != rate.Limit(t.Quotas.ThrottleRate) {
+		l = newLocalLimiter(t.Quotas.ThrottleRate, 1, e.now)
+		e.throttles[t.ID] = l
+	}
+	return l
+}
+
+// quotaMiddleware enforces the quotas of each request's tenant. Counts
+// that can't be read or updated are logged, and the call let through.
+func quotaMiddleware(e *quotaEnforcer) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			t, ok := tenantFromContext(r.Context())
+			if !ok || !t.Quotas.enabled() {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ctx := r.Context()
+			periods := quotaPeriods(t.ID, t.Quotas, e.now())
+			over, resets := "", time.Time{}
+			for _, p := range periods {
+				c, err := e.counter.Add(ctx, p.key, 0, 0, p.ends)
+				if err != nil {
+					level.Warn(e.logger).Log("msg", "reading quota, letting the call through", "tenant", t.ID, "err", err)
+					continue
+				}
+				if over == "" && p.exceeded(c) {
+					over, resets = p.name, p.ends
+				}
+			}
+			if over != "" {
+				switch t.Quotas.Enforcement {
+				case "log":
+					level.Warn(e.logger).Log("msg", "over quota", "tenant", t.ID, "quota", over)
+				case "throttle":
+					allowed, state := e.throttle(t).Allow(ctx)
+					noteRateLimit(ctx, state)
+					if !allowed {
+						encodeError(ctx, svcerrors.ErrRateLimited, w)
+						return
+					}
+				default:
+					noteRetryAfter(ctx, resets.Sub(e.now()))
+					encodeError(ctx, svcerrors.Errorf(svcerrors.CodeQuotaExceeded, "tenant %q has used its %s quota", t.ID, over), w)
+					return
+				}
+			}
+
+			for _, p := range periods {
+				c, err := e.counter.Add(ctx, p.key, 1, 0, p.ends)
+				if err != nil {
+					level.Warn(e.logger).Log("msg", "counting quota", "tenant", t.ID, "err", err)
+					continue
+				}
+				p.setRemaining(w, c)
+			}
+			// Bytes are counted as they are read and written, since
+			// chunked bodies have no Content-Length to go by.
+			cr := &countingReadCloser{ReadCloser: r.Body}
+			r.Body = cr
+			cw := &countingResponseWriter{ResponseWriter: w}
+			next.ServeHTTP(cw, r)
+			n := cr.n + cw.n
+			if n == 0 {
+				return
+			}
+			// The call is over; count its bytes even if the client has
+			// gone.
+			ctx = context.Background()
+			for _, p := range periods {
+				if _, err := e.counter.Add(ctx, p.key, 0, n, p.ends); err != nil {
+					level.Warn(e.logger).Log("msg", "counting quota", "tenant", t.ID, "err", err)
+				}
+			}
+		})
+	}
+}
+
+// countingReadCloser counts the bytes of the request body read.
+type countingReadCloser struct {
+	io.ReadCloser
+	n int64
+}
+
+func (r *countingReadCloser) Read(b []byte) (int, error) {
+	n, err := r.ReadCloser.Read(b)
+	r.n += int64(n)
+	return n, err
+}
+
+// countingResponseWriter counts the bytes of the body written.
+type countingResponseWriter struct {
+	http.ResponseWriter
+	n int64
+}
+
+func (w *countingResponseWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.n += int64(n)
+	return n, err
+}
+
+// Flush lets streamed responses through.
+func (w *countingResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
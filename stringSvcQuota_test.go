@@ -0,0 +1,89 @@
+package stringsvc
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestQuotaMiddleware(t *testing.T) {
+	clock := time.Date(2026, 3, 31, 23, 0, 0, 0, time.UTC)
+	now := func() time.Time { return clock }
+	h := quotaMiddleware(newQuotaEnforcer(newMemoryQuotaCounter(now), now, log.NewNopLogger()))(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) }))
+	call := func(q tenantQuotas) *httptest.ResponseRecorder {
+		ctx := context.WithValue(context.Background(), tenantKey{}, tenant{ID: "search-" + q.Enforcement, Quotas: q})
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/count?s=a", nil).WithContext(ctx))
+		return w
+	}
+
+	reject := tenantQuotas{Daily: quotaLimits{Requests: 2}}
+	for _, want := range []string{"1", "0"} {
+		if w := call(reject); w.Code != http.StatusOK || w.Header().Get("X-Quota-Daily-Requests-Remaining") != want {
+			t.Errorf("within the quota: got %d, %s remaining, want 200, %s", w.Code, w.Header().Get("X-Quota-Daily-Requests-Remaining"), want)
+		}
+	}
+	if w := call(reject); w.Code != http.StatusTooManyRequests {
+		t.Errorf("over the quota: got %d, want 429", w.Code)
+	}
+
+	logged := tenantQuotas{Daily: quotaLimits{Requests: 1}, Enforcement: "log"}
+	call(logged)
+	if w := call(logged); w.Code != http.StatusOK {
+		t.Errorf("over a logged quota: got %d, want 200", w.Code)
+	}
+
+	throttled := tenantQuotas{Daily: quotaLimits{Requests: 1}, Enforcement: "throttle", ThrottleRate: 1}
+	call(throttled)
+	if w := call(throttled); w.Code != http.StatusOK {
+		t.Errorf("first call over a throttled quota: got %d, want 200", w.Code)
+	}
+	if w := call(throttled); w.Code != http.StatusTooManyRequests {
+		t.Errorf("second call in the second over a throttled quota: got %d, want 429", w.Code)
+	}
+
+	clock = clock.Add(time.Hour)
+	if w := call(reject); w.Code != http.StatusOK {
+		t.Errorf("the next day: got %d, want 200", w.Code)
+	}
+}
+
+func TestQuotaChunkedBytes(t *testing.T) {
+	now := time.Now
+	h := quotaMiddleware(newQuotaEnforcer(newMemoryQuotaCounter(now), now, log.NewNopLogger()))(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { io.Copy(w, r.Body) }))
+	ctx := context.WithValue(context.Background(), tenantKey{}, tenant{ID: "uploads", Quotas: tenantQuotas{Daily: quotaLimits{Bytes: 10}}})
+	for i, want := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		r := httptest.NewRequest("POST", "/uppercase", strings.NewReader(`{"s":"chunked"}`)).WithContext(ctx)
+		r.ContentLength = -1 // as for Transfer-Encoding: chunked
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != want {
+			t.Errorf("call %d: got %d, want %d", i+1, w.Code, want)
+		}
+	}
+}
+
+func TestQuotaPeriods(t *testing.T) {
+	q := tenantQuotas{Daily: quotaLimits{Requests: 10}, Monthly: quotaLimits{Bytes: 1000}}
+	periods := quotaPeriods("search", q, time.Date(2026, 12, 31, 18, 0, 0, 0, time.UTC))
+	if len(periods) != 2 {
+		t.Fatalf("got %d periods, want 2", len(periods))
+	}
+	if p := periods[0]; p.key != "search:2026-12-31" || !p.ends.Equal(time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("daily: got %s ending %s", p.key, p.ends)
+	}
+	if p := periods[1]; !p.ends.Equal(time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)) || !p.exceeded(quotaCount{Bytes: 1000}) || p.exceeded(quotaCount{Requests: 1e6, Bytes: 999}) {
+		t.Errorf("monthly: got %+v", p)
+	}
+	if err := (tenantQuotas{Enforcement: "throttle"}).check(); err == nil {
+		t.Error("a throttled quota without a rate passed the check")
+	}
+}
@@ -0,0 +1,194 @@
+package stringsvc
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/time/rate"
+
+	"github.com/mcclayac/gokit/svcerrors"
+)
+
+// rateLimiter decides whether a call may go ahead now.
+type rateLimiter interface {
+	// Allow reports whether a call may go ahead now, and the state of the
+	// limit after it.
+	Allow(ctx context.Context) (bool, rateLimitState)
+}
+
+// rateLimitState is the state of a token bucket limit, as reported to
+// clients.
+type rateLimitState struct {
+	Limit      int           // the burst: calls that may be made at once
+	Remaining  int           // calls that may be made at once now
+	Reset      time.Duration // until the bucket is full again
+	RetryAfter time.Duration // until a call is allowed, if this one wasn't
+}
+
+// bucketState is the state of a bucket holding tokens, refilled at r a
+// second up to burst, after a call that was allowed or not.
+func bucketState(r float64, burst int, tokens float64, allowed bool) rateLimitState {
+	s := rateLimitState{Limit: burst, Remaining: int(math.Max(0, math.Floor(tokens)))}
+	if r > 0 {
+		s.Reset = time.Duration(math.Max(0, float64(burst)-tokens) / r * float64(time.Second))
+		if !allowed {
+			s.RetryAfter = time.Duration(math.Max(0, 1-tokens) / r * float64(time.Second))
+		}
+	}
+	return s
+}
+
+// localLimiter limits the calls to this process.
+type localLimiter struct {
+	limiter *rate.Limiter
+	now     func() time.Time
+}
+
+func newLocalLimiter(r float64, burst int, now func() time.Time) localLimiter {
+	return localLimiter{rate.NewLimiter(rate.Limit(r), burst), now}
+}
+
+func (l localLimiter) Allow(context.Context) (bool, rateLimitState) {
+	now := l.now()
+	allowed := l.limiter.AllowN(now, 1)
+	return allowed, bucketState(float64(l.limiter.Limit()), l.limiter.Burst(), l.limiter.TokensAt(now), allowed)
+}
+
+// rateLimitConfig configures where rate limits are kept.
+type rateLimitConfig struct {
+	RedisAddr   string // limits are per process when empty
+	RedisPrefix string
+	Headers     string // x, ietf or off; see rateLimitHeadersMiddleware
+}
+
+// limiterSource makes the limiters of named limits: in Redis, shared by
+// the replicas, with -rate-limit-redis-addr, and per process otherwise.
+type limiterSource struct {
+	rdb    *redis.Client // nil for per-process limits
+	prefix string
+	now    func() time.Time
+	logger log.Logger
+}
+
+// limiter returns a limiter of r calls a second with bursts of burst for
+// the limit called name.
+func (s limiterSource) limiter(name string, r float64, burst int) rateLimiter {
+	if s.rdb == nil {
+		return newLocalLimiter(r, burst, s.now)
+	}
+	return newRedisLimiter(s.rdb, s.prefix+name, r, burst, s.now, s.logger)
+}
+
+// redisLimiterTimeout bounds each call to Redis, and redisLimiterRetry is
+// how long a redisLimiter limits locally after Redis fails.
+const (
+	redisLimiterTimeout = 50 * time.Millisecond
+	redisLimiterRetry   = 5 * time.Second
+)
+
+// tokenBucketScript takes a token from the bucket in KEYS[1], which holds
+// ARGV[2] tokens and gains ARGV[1] a second, returning 1 if there was one
+// and 0 if not, and the tokens left.
+// It uses Redis's clock, so replicas whose clocks differ share one bucket.
+// An idle bucket expires once it would be full again.
+var tokenBucketScript = redis.NewScript(`
+local rate, burst = tonumber(ARGV[1]), tonumber(ARGV[2])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) + tonumber(t[2]) / 1000000
+local b = redis.call('HMGET', KEYS[1], 'tokens', 'at')
+local tokens, at = tonumber(b[1]) or burst, tonumber(b[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - at) * rate)
+local allowed = 0
+if tokens >= 1 then
+	tokens, allowed = tokens - 1, 1
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'at', tostring(now))
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / rate * 1000) + 1000)
+return {allowed, tostring(tokens)}`)
+
+// redisLimiter keeps a token bucket in Redis, so a limit holds across all
+// the replicas sharing it. While Redis can't be reached it falls back to a
+// local bucket with the same rate and burst, retrying Redis every
+// redisLimiterRetry.
+type redisLimiter struct {
+	rdb    *redis.Client
+	key    string
+	rate   float64
+	burst  int
+	local  localLimiter
+	now    func() time.Time
+	logger log.Logger
+
+	mtx       sync.Mutex
+	downUntil time.Time
+}
+
+func newRedisLimiter(rdb *redis.Client, key string, r float64, burst int, now func() time.Time, logger log.Logger) *redisLimiter {
+	return &redisLimiter{
+		rdb:    rdb,
+		key:    key,
+		rate:   r,
+		burst:  burst,
+		local:  newLocalLimiter(r, burst, now),
+		now:    now,
+		logger: logger,
+	}
+}
+
+func (l *redisLimiter) Allow(ctx context.Context) (bool, rateLimitState) {
+	l.mtx.Lock()
+	down := l.now().Before(l.downUntil)
+	l.mtx.Unlock()
+	if down {
+		return l.local.Allow(ctx)
+	}
+	ctx, cancel := context.WithTimeout(ctx, redisLimiterTimeout)
+	defer cancel()
+	res, err := tokenBucketScript.Run(ctx, l.rdb, []string{l.key}, l.rate, l.burst).Slice()
+	var (
+		allowed int64
+		tokens  float64
+	)
+	if err == nil {
+		if len(res) != 2 {
+			err = fmt.Errorf("token bucket script returned %d values, not 2", len(res))
+		} else {
+			allowed, _ = res[0].(int64)
+			s, _ := res[1].(string)
+			tokens, err = strconv.ParseFloat(s, 64)
+		}
+	}
+	if err != nil {
+		l.mtx.Lock()
+		if !l.now().Before(l.downUntil) {
+			level.Warn(l.logger).Log("msg", "rate limiting locally", "key", l.key, "err", err)
+		}
+		l.downUntil = l.now().Add(redisLimiterRetry)
+		l.mtx.Unlock()
+		return l.local.Allow(ctx)
+	}
+	return allowed == 1, bucketState(l.rate, l.burst, tokens, allowed == 1)
+}
+
+// rateLimitingMiddleware rejects calls with RATE_LIMITED once limiter runs
+// out of tokens, rather than queueing them.
+func rateLimitingMiddleware(limiter rateLimiter) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			allowed, state := limiter.Allow(ctx)
+			noteRateLimit(ctx, state)
+			if !allowed {
+				return nil, svcerrors.ErrRateLimited
+			}
+			return next(ctx, request)
+		}
+	}
+}
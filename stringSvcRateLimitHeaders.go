@@ -0,0 +1,131 @@
+package stringsvc
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Responses to calls that a rate limit applied to say where the caller
+// stands, so that well-behaved clients can slow down before they are
+// refused. With -rate-limit-headers x (the default) they carry
+//
+//	X-RateLimit-Limit: 20
+//	X-RateLimit-Remaining: 17
+//	X-RateLimit-Reset: 1790000000
+//
+// the reset being when the limit is whole again, in Unix seconds; with
+// ietf they carry the IETF draft's RateLimit-Limit, RateLimit-Remaining and
+// RateLimit-Reset, the reset being in seconds from now. Limits are token
+// buckets, so the limit is the burst, and a caller regains a call every
+// 1/rate seconds rather than all of them at the reset. When several limits
+// apply, the headers report the one with the fewest calls left. A call
+// refused with 429 carries Retry-After, the seconds until it may be made
+// again.
+
+// rateLimitReport collects the states of the limits that applied to a
+// request.
+type rateLimitReport struct {
+	mtx        sync.Mutex
+	state      *rateLimitState // the most restrictive
+	retryAfter time.Duration
+}
+
+type rateLimitReportKey struct{}
+
+// noteRateLimit reports the state of a limit that applied to the request
+// of ctx, if its response reports limits.
+func noteRateLimit(ctx context.Context, s rateLimitState) {
+	r, ok := ctx.Value(rateLimitReportKey{}).(*rateLimitReport)
+	if !ok {
+		return
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if r.state == nil || s.Remaining < r.state.Remaining || (s.Remaining == r.state.Remaining && s.Reset > r.state.Reset) {
+		r.state = &s
+	}
+	if s.RetryAfter > r.retryAfter {
+		r.retryAfter = s.RetryAfter
+	}
+}
+
+// noteRetryAfter reports that the request of ctx may not be made again
+// for d, for refusals other than a limit's, such as a spent quota.
+func noteRetryAfter(ctx context.Context, d time.Duration) {
+	if r, ok := ctx.Value(rateLimitReportKey{}).(*rateLimitReport); ok {
+		r.mtx.Lock()
+		if d > r.retryAfter {
+			r.retryAfter = d
+		}
+		r.mtx.Unlock()
+	}
+}
+
+// rateLimitHeadersMiddleware has responses report the limits that applied
+// to their requests, in style "x" or "ietf".
+func rateLimitHeadersMiddleware(style string, now func() time.Time) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			report := &rateLimitReport{}
+			rw := &rateLimitWriter{ResponseWriter: w, report: report, style: style, now: now}
+			next.ServeHTTP(rw, r.WithContext(context.WithValue(r.Context(), rateLimitReportKey{}, report)))
+		})
+	}
+}
+
+// rateLimitWriter sets the rate limit headers as the status is written.
+type rateLimitWriter struct {
+	http.ResponseWriter
+	report      *rateLimitReport
+	style       string
+	now         func() time.Time
+	wroteHeader bool
+}
+
+// seconds rounds d up to whole seconds.
+func seconds(d time.Duration) int64 {
+	return int64((d + time.Second - 1) / time.Second)
+}
+
+func (w *rateLimitWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.report.mtx.Lock()
+		state, retryAfter := w.report.state, w.report.retryAfter
+		w.report.mtx.Unlock()
+		h := w.Header()
+		if state != nil {
+			prefix, reset := "X-RateLimit-", strconv.FormatInt(w.now().Add(state.Reset).Unix(), 10)
+			if w.style == "ietf" {
+				prefix, reset = "RateLimit-", strconv.FormatInt(seconds(state.Reset), 10)
+			}
+			h.Set(prefix+"Limit", strconv.Itoa(state.Limit))
+			h.Set(prefix+"Remaining", strconv.Itoa(state.Remaining))
+			h.Set(prefix+"Reset", reset)
+		}
+		if code == http.StatusTooManyRequests && retryAfter > 0 {
+			h.Set("Retry-After", strconv.FormatInt(seconds(retryAfter), 10))
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *rateLimitWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush lets streamed responses through.
+func (w *rateLimitWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,45 @@
+package stringsvc
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimitHeaders(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	// Two limits applied to the call; the headers report the tighter.
+	h := func(status int) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			noteRateLimit(r.Context(), rateLimitState{Limit: 20, Remaining: 17, Reset: 3 * time.Second})
+			noteRateLimit(r.Context(), rateLimitState{Limit: 5, Remaining: 0, Reset: 10 * time.Second, RetryAfter: 1500 * time.Millisecond})
+			w.WriteHeader(status)
+		})
+	}
+	for _, c := range []struct {
+		style, prefix, reset string
+	}{
+		{"x", "X-RateLimit-", "1700000010"},
+		{"ietf", "RateLimit-", "10"},
+	} {
+		w := httptest.NewRecorder()
+		rateLimitHeadersMiddleware(c.style, func() time.Time { return now })(h(http.StatusTooManyRequests)).ServeHTTP(w, httptest.NewRequest("GET", "/count?s=a", nil))
+		for name, want := range map[string]string{
+			c.prefix + "Limit":     "5",
+			c.prefix + "Remaining": "0",
+			c.prefix + "Reset":     c.reset,
+			"Retry-After":          "2",
+		} {
+			if got := w.Header().Get(name); got != want {
+				t.Errorf("%s: got %s %q, want %q", c.style, name, got, want)
+			}
+		}
+	}
+
+	w := httptest.NewRecorder()
+	rateLimitHeadersMiddleware("x", func() time.Time { return now })(h(http.StatusOK)).ServeHTTP(w, httptest.NewRequest("GET", "/count?s=a", nil))
+	if w.Header().Get("Retry-After") != "" {
+		t.Error("an allowed call has Retry-After")
+	}
+}
@@ -0,0 +1,49 @@
+package stringsvc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mcclayac/gokit/svcerrors"
+)
+
+func TestRateLimitingMiddleware(t *testing.T) {
+	clock := time.Unix(1700000000, 0)
+	limiter := newLocalLimiter(1, 2, func() time.Time { return clock })
+	calls := 0
+	e := rateLimitingMiddleware(limiter)(func(context.Context, interface{}) (interface{}, error) {
+		calls++
+		return nil, nil
+	})
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if _, err := e(ctx, nil); err != nil {
+			t.Fatalf("call %d within the burst: %v", i+1, err)
+		}
+	}
+	if _, err := e(ctx, nil); svcerrors.CodeOf(err) != svcerrors.CodeRateLimited || calls != 2 {
+		t.Errorf("call past the burst: got %v after %d calls, want RATE_LIMITED after 2", err, calls)
+	}
+	allowed, state := limiter.Allow(ctx)
+	if allowed || state.Limit != 2 || state.Remaining != 0 || state.RetryAfter != time.Second {
+		t.Errorf("empty bucket: got %v, %+v, want refused, limit 2, none remaining, retry after 1s", allowed, state)
+	}
+
+	clock = clock.Add(time.Second)
+	if _, err := e(ctx, nil); err != nil {
+		t.Errorf("a second later: %v", err)
+	}
+}
+
+func TestBucketState(t *testing.T) {
+	s := bucketState(2, 10, 4.5, true)
+	if s.Limit != 10 || s.Remaining != 4 || s.Reset != 2750*time.Millisecond || s.RetryAfter != 0 {
+		t.Errorf("got %+v, want 4 of 10 remaining, full in 2.75s", s)
+	}
+	s = bucketState(2, 10, -1, false)
+	if s.Remaining != 0 || s.RetryAfter != time.Second {
+		t.Errorf("overdrawn: got %+v, want none remaining, retry after 1s", s)
+	}
+}
@@ -0,0 +1,295 @@
+package stringsvc
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+
+	"github.com/mcclayac/gokit/redact"
+)
+
+// Recording and replay check a candidate build against the traffic of a
+// running one. With -record-file, the service appends each call it serves
+// to the file, one JSON exchange per line, and the replay subcommand later
+// sends the recorded requests to the candidate and reports every response
+// that differs from the recorded one:
+//
+//	stringsvc -record-file calls.ndjson ...
+//	stringsvc replay -file calls.ndjson -target http://localhost:9091
+//
+// Recordings are sanitized: bodies and query strings have the values at the
+// -record-redact paths masked, as in the payload log, and only the
+// Content-Type and Accept headers are kept. Bodies other than JSON can't
+// be masked, so they are left out unless -record-redact is empty. The
+// admin endpoints aren't recorded.
+
+// recordConfig configures recording.
+type recordConfig struct {
+	File   string // recording is off when empty
+	Redact string // comma-separated JSON paths
+}
+
+// exchange is a recorded call.
+type exchange struct {
+	Time     time.Time       `json:"time"`
+	Request  exchangeMessage `json:"request"`
+	Response exchangeMessage `json:"response"`
+}
+
+// exchangeMessage is a recorded request or response. A JSON body is kept
+// as JSON, any other as Data; Omitted is set when a body couldn't be kept.
+type exchangeMessage struct {
+	Method  string          `json:"method,omitempty"`
+	URL     string          `json:"url,omitempty"` // path and query
+	Status  int             `json:"status,omitempty"`
+	Header  http.Header     `json:"header,omitempty"`
+	Body    json.RawMessage `json:"body,omitempty"`
+	Data    []byte          `json:"data,omitempty"`
+	Omitted bool            `json:"omitted,omitempty"`
+}
+
+// recordedHeaders are the headers kept in recordings; the rest may carry
+// credentials.
+var recordedHeaders = []string{"Content-Type", "Accept"}
+
+// recorder appends exchanges to a file.
+type recorder struct {
+	redactor *redact.Redactor // nil when nothing is masked
+	logger   log.Logger
+
+	mtx sync.Mutex
+	enc *json.Encoder
+}
+
+func newRecorder(cfg recordConfig, logger log.Logger) (*recorder, error) {
+	f, err := os.OpenFile(cfg.File, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	rec := &recorder{logger: logger, enc: json.NewEncoder(f)}
+	if strings.TrimSpace(cfg.Redact) != "" {
+		rec.redactor = redact.New(strings.Split(cfg.Redact, ",")...)
+	}
+	return rec, nil
+}
+
+// message returns a body and its headers as recorded.
+func (rec *recorder) message(header http.Header, body []byte) exchangeMessage {
+	m := exchangeMessage{Header: http.Header{}}
+	for _, k := range recordedHeaders {
+		if v := header.Get(k); v != "" {
+			m.Header.Set(k, v)
+		}
+	}
+	if len(body) == 0 {
+		return m
+	}
+	mt, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	switch {
+	case rec.redactor == nil:
+		m.Data = body
+	case mt == "" || mt == "application/json":
+		if b, err := rec.redactor.JSON(body); err == nil {
+			m.Body = b
+		} else {
+			m.Omitted = true
+		}
+	default:
+		m.Omitted = true
+	}
+	return m
+}
+
+// url returns the request's path and query, with the query redacted as if
+// it were a JSON object of its parameters.
+func (rec *recorder) url(u *url.URL) string {
+	if u.RawQuery == "" || rec.redactor == nil {
+		return u.RequestURI()
+	}
+	doc, _ := json.Marshal(u.Query())
+	doc, err := rec.redactor.JSON(doc)
+	var params map[string]interface{}
+	if err == nil {
+		err = json.Unmarshal(doc, &params)
+	}
+	if err != nil {
+		return u.Path
+	}
+	q := url.Values{}
+	for k, v := range params {
+		switch v := v.(type) {
+		case string:
+			q.Set(k, v)
+		case []interface{}:
+			for _, s := range v {
+				q.Add(k, fmt.Sprint(s))
+			}
+		}
+	}
+	return u.Path + "?" + q.Encode()
+}
+
+func (rec *recorder) record(e exchange) {
+	rec.mtx.Lock()
+	defer rec.mtx.Unlock()
+	if err := rec.enc.Encode(e); err != nil {
+		level.Error(rec.logger).Log("msg", "recording call", "err", err)
+	}
+}
+
+// recordingMiddleware records the calls to next.
+func recordingMiddleware(rec *recorder) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasPrefix(r.URL.Path, "/admin/") {
+				next.ServeHTTP(w, r)
+				return
+			}
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				encodeError(r.Context(), err, w)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			begin := time.Now()
+
+			rw := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(rw, r)
+
+			e := exchange{
+				Time:     begin.UTC(),
+				Request:  rec.message(r.Header, body),
+				Response: rec.message(w.Header(), rw.body.Bytes()),
+			}
+			e.Request.Method, e.Request.URL = r.Method, rec.url(r.URL)
+			e.Response.Status = rw.status
+			rec.record(e)
+		})
+	}
+}
+
+// defaultVolatilePaths are the JSON paths of response values that differ
+// from call to call, which replays and shadows don't compare.
+const defaultVolatilePaths = "link.code,link.created,link.expires,ids,hash,access_token,id"
+
+// runReplay sends the requests recorded in a file to a candidate and
+// reports the responses that differ from the recorded ones.
+func runReplay(args []string) int {
+	fs := flag.NewFlagSet("stringsvc replay", flag.ContinueOnError)
+	file := fs.String("file", "", "recording to replay, as written with -record-file")
+	target := fs.String("target", "http://localhost:9090", "base URL of the candidate to replay against")
+	redactPaths := fs.String("redact", defaultRedactPaths, "the -record-redact paths the recording was made with, masked in the candidate's responses too")
+	ignore := fs.String("ignore", defaultVolatilePaths, "comma-separated JSON paths of response values that differ from call to call, which aren't compared")
+	timeout := fs.Duration("timeout", 30*time.Second, "timeout of each replayed request")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *file == "" {
+		fmt.Fprintln(os.Stderr, "replay needs -file")
+		return 2
+	}
+	b, err := os.ReadFile(*file)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	compare := redact.New(append(strings.Split(*redactPaths, ","), strings.Split(*ignore, ",")...)...)
+	client := &http.Client{Timeout: *timeout}
+
+	var replayed, differ, skipped int
+	for n, line := range bytes.Split(b, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var e exchange
+		if err := json.Unmarshal(line, &e); err != nil {
+			fmt.Fprintf(os.Stderr, "%s:%d: %v\n", *file, n+1, err)
+			return 1
+		}
+		if e.Request.Omitted || e.Response.Omitted {
+			skipped++
+			continue
+		}
+		replayed++
+		if diff := replay(client, *target, e, compare); diff != "" {
+			differ++
+			fmt.Printf("%s:%d: %s %s: %s\n", *file, n+1, e.Request.Method, e.Request.URL, diff)
+		}
+	}
+	fmt.Printf("%d calls replayed, %d differ, %d skipped for bodies left out of the recording\n", replayed, differ, skipped)
+	if differ > 0 {
+		return 1
+	}
+	return 0
+}
+
+// replay sends e's request to target and describes how the response
+// differs from e's, or returns "" if it doesn't.
+func replay(client *http.Client, target string, e exchange, compare *redact.Redactor) string {
+	body := e.Request.Data
+	if e.Request.Body != nil {
+		body = []byte(e.Request.Body)
+	}
+	req, err := http.NewRequest(e.Request.Method, strings.TrimSuffix(target, "/")+e.Request.URL, bytes.NewReader(body))
+	if err != nil {
+		return err.Error()
+	}
+	for k, v := range e.Request.Header {
+		req.Header[k] = v
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err.Error()
+	}
+	defer resp.Body.Close()
+	got, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err.Error()
+	}
+
+	want := e.Response.Data
+	if e.Response.Body != nil {
+		want = []byte(e.Response.Body)
+	}
+	return diffResponses(resp.StatusCode, resp.Header, got, e.Response.Status, e.Response.Header, want, compare)
+}
+
+// diffResponses describes how a response differs from the one wanted, in
+// status, content type and body, or returns "" if it doesn't. JSON bodies
+// are compared with the values at compare's paths masked.
+func diffResponses(gotStatus int, gotHeader http.Header, got []byte, wantStatus int, wantHeader http.Header, want []byte, compare *redact.Redactor) string {
+	var diffs []string
+	if gotStatus != wantStatus {
+		diffs = append(diffs, fmt.Sprintf("status %d, was %d", gotStatus, wantStatus))
+	}
+	gotType, _, _ := mime.ParseMediaType(gotHeader.Get("Content-Type"))
+	wantType, _, _ := mime.ParseMediaType(wantHeader.Get("Content-Type"))
+	if gotType != wantType {
+		diffs = append(diffs, fmt.Sprintf("content type %q, was %q", gotType, wantType))
+	}
+	if gotType == "application/json" && wantType == "application/json" {
+		// Both sides are masked, and re-marshaled with sorted keys.
+		if g, err := compare.JSON(got); err == nil {
+			got = g
+		}
+		if w, err := compare.JSON(want); err == nil {
+			want = w
+		}
+	}
+	if !bytes.Equal(bytes.TrimSpace(got), bytes.TrimSpace(want)) {
+		diffs = append(diffs, fmt.Sprintf("body\n  got: %s\n  was: %s", bytes.TrimSpace(got), bytes.TrimSpace(want)))
+	}
+	return strings.Join(diffs, "; ")
+}
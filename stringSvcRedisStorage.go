@@ -0,0 +1,571 @@
+package stringsvc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// The Redis stores share a client and put their keys under a common
+// prefix, so several deployments can share one Redis.
+
+// redisShortenerStore keeps each link in a hash that Redis expires with
+// the link.
+type redisShortenerStore struct {
+	rdb    *redis.Client
+	prefix string
+}
+
+func (s *redisShortenerStore) key(code string) string { return s.prefix + "link:" + code }
+
+// createLinkScript stores a link unless its code is in use. ARGV is the
+// URL, the creation and expiry times in Unix nanoseconds (0 for none), the
+// expiry in Unix milliseconds and the hits.
+var createLinkScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 1 then return 0 end
+redis.call('HSET', KEYS[1], 'url', ARGV[1], 'created', ARGV[2], 'expires', ARGV[3], 'hits', ARGV[5])
+if ARGV[4] ~= '0' then redis.call('PEXPIREAT', KEYS[1], ARGV[4]) end
+return 1`)
+
+// hitLinkScript counts a hit on a link and returns its fields, or nil if
+// there is no such link.
+var hitLinkScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then return false end
+redis.call('HINCRBY', KEYS[1], 'hits', 1)
+return redis.call('HMGET', KEYS[1], 'url', 'created', 'expires', 'hits')`)
+
+func (s *redisShortenerStore) Create(ctx context.Context, link shortLink) error {
+	var expires, expiresMillis int64
+	if !link.Expires.IsZero() {
+		expires, expiresMillis = link.Expires.UnixNano(), link.Expires.UnixMilli()
+	}
+	created, err := createLinkScript.Run(ctx, s.rdb, []string{s.key(link.Code)},
+		link.URL, link.Created.UnixNano(), expires, expiresMillis, link.Hits).Int()
+	if err != nil {
+		return err
+	}
+	if created == 0 {
+		return errCodeTaken
+	}
+	return nil
+}
+
+func (s *redisShortenerStore) Hit(ctx context.Context, code string, now time.Time) (shortLink, error) {
+	fields, err := hitLinkScript.Run(ctx, s.rdb, []string{s.key(code)}).StringSlice()
+	if err == redis.Nil {
+		return shortLink{}, errNoLink(code)
+	}
+	if err != nil {
+		return shortLink{}, err
+	}
+	l := redisLink(code, fields)
+	// Redis deletes expired links itself, but its clock may differ a
+	// little from ours.
+	if l.expired(now) {
+		return shortLink{}, errNoLink(code)
+	}
+	return l, nil
+}
+
+// redisLink makes a link from its url, created, expires and hits fields.
+func redisLink(code string, fields []string) shortLink {
+	l := shortLink{Code: code, URL: fields[0]}
+	created, _ := strconv.ParseInt(fields[1], 10, 64)
+	expires, _ := strconv.ParseInt(fields[2], 10, 64)
+	l.Hits, _ = strconv.ParseInt(fields[3], 10, 64)
+	l.Created = time.Unix(0, created).UTC()
+	if expires != 0 {
+		l.Expires = time.Unix(0, expires).UTC()
+	}
+	return l
+}
+
+func (s *redisShortenerStore) Export(ctx context.Context, now time.Time, fn func(shortLink) error) error {
+	return redisScan(ctx, s.rdb, s.key(""), func(key string) error {
+		fields, err := s.rdb.HMGet(ctx, key, "url", "created", "expires", "hits").Result()
+		if err != nil {
+			return err
+		}
+		strs := make([]string, len(fields))
+		for i, f := range fields {
+			if strs[i], _ = f.(string); strs[i] == "" {
+				return nil // expired since the scan found it
+			}
+		}
+		l := redisLink(strings.TrimPrefix(key, s.key("")), strs)
+		if l.expired(now) {
+			return nil
+		}
+		return fn(l)
+	})
+}
+
+// redisScan calls fn with each key starting with prefix. Keys may be
+// missing by the time fn is called.
+func redisScan(ctx context.Context, rdb *redis.Client, prefix string, fn func(key string) error) error {
+	iter := rdb.Scan(ctx, 0, prefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		if err := fn(iter.Val()); err != nil {
+			return err
+		}
+	}
+	return iter.Err()
+}
+
+// redisHistoryStore keeps each record as JSON under its own key, indexed
+// by sorted sets of IDs scored by time: one of all records, and one for
+// each operation and identity. Times are kept to the microsecond, which
+// the scores hold exactly.
+type redisHistoryStore struct {
+	rdb    *redis.Client
+	prefix string
+}
+
+// redisHistoryBatch is how many IDs List reads from an index at a time.
+const redisHistoryBatch = 200
+
+func (h *redisHistoryStore) recordKey(id string) string { return h.prefix + "history:record:" + id }
+
+func (h *redisHistoryStore) indexKey(q historyQuery) string {
+	switch {
+	case q.Operation != "":
+		return h.prefix + "history:operation:" + q.Operation
+	case q.Identity != "":
+		return h.prefix + "history:identity:" + q.Identity
+	}
+	return h.prefix + "history:all"
+}
+
+func (h *redisHistoryStore) Record(ctx context.Context, rec historyRecord) error {
+	rec.Time = rec.Time.Truncate(time.Microsecond)
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	member := redis.Z{Score: float64(rec.Time.UnixMicro()), Member: rec.ID}
+	_, err = h.rdb.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.Set(ctx, h.recordKey(rec.ID), b, 0)
+		p.ZAdd(ctx, h.indexKey(historyQuery{}), member)
+		p.ZAdd(ctx, h.indexKey(historyQuery{Operation: rec.Operation}), member)
+		p.ZAdd(ctx, h.indexKey(historyQuery{Identity: rec.Identity}), member)
+		return nil
+	})
+	return err
+}
+
+// List walks the most selective index newest first, within the time range
+// if there is one, and filters the records it finds. Within a score, Redis
+// orders members by ID, as the other stores do.
+func (h *redisHistoryStore) List(ctx context.Context, q historyQuery) ([]historyRecord, error) {
+	rng := redis.ZRangeBy{Min: "-inf", Max: "+inf", Count: redisHistoryBatch}
+	if !q.Since.IsZero() {
+		rng.Min = strconv.FormatInt(q.Since.UnixMicro(), 10)
+	}
+	until := q.Until
+	if q.After != nil && (until.IsZero() || q.After.Time.Before(until)) {
+		until = q.After.Time
+	}
+	if !until.IsZero() {
+		rng.Max = strconv.FormatInt(until.UnixMicro(), 10)
+	}
+	records := []historyRecord{}
+	for len(records) < q.Limit {
+		ids, err := h.rdb.ZRevRangeByScore(ctx, h.indexKey(q), &rng).Result()
+		if err != nil {
+			return nil, err
+		}
+		if len(ids) == 0 {
+			break
+		}
+		rng.Offset += int64(len(ids))
+		keys := make([]string, len(ids))
+		for i, id := range ids {
+			keys[i] = h.recordKey(id)
+		}
+		vals, err := h.rdb.MGet(ctx, keys...).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range vals {
+			s, ok := v.(string)
+			if !ok {
+				continue // deleted since it was indexed
+			}
+			var r historyRecord
+			if err := json.Unmarshal([]byte(s), &r); err != nil {
+				return nil, err
+			}
+			if q.matches(r) && len(records) < q.Limit {
+				records = append(records, r)
+			}
+		}
+	}
+	return records, nil
+}
+
+// redisJobStore keeps each job as JSON under a key that Redis expires with
+// the job, indexed by its identity in a sorted set scored by when it was
+// created, in microseconds. Index entries outlive their jobs until List
+// finds them gone.
+type redisJobStore struct {
+	rdb    *redis.Client
+	prefix string
+}
+
+func (s *redisJobStore) key(id string) string { return s.prefix + "job:" + id }
+
+func (s *redisJobStore) indexKey(identity string) string {
+	return s.prefix + "jobs:identity:" + identity
+}
+
+func (s *redisJobStore) Put(ctx context.Context, j job, expires time.Time) error {
+	ttl := time.Until(expires)
+	if ttl <= 0 {
+		return s.rdb.Del(ctx, s.key(j.ID)).Err()
+	}
+	b, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+	_, err = s.rdb.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.Set(ctx, s.key(j.ID), b, ttl)
+		p.ZAdd(ctx, s.indexKey(j.Identity), redis.Z{Score: float64(j.Created.UnixMicro()), Member: j.ID})
+		return nil
+	})
+	return err
+}
+
+// List walks the identity's index newest first. Within a microsecond the
+// index orders jobs by ID, but their exact creation times may not, so the
+// jobs found are sorted again.
+func (s *redisJobStore) List(ctx context.Context, q jobQuery) ([]job, error) {
+	rng := redis.ZRangeBy{Min: "-inf", Max: "+inf", Count: redisHistoryBatch}
+	if q.After != nil {
+		rng.Max = strconv.FormatInt(q.After.Created.UnixMicro(), 10)
+	}
+	jobs := []job{}
+	for {
+		ids, err := s.rdb.ZRevRangeByScore(ctx, s.indexKey(q.Identity), &rng).Result()
+		if err != nil {
+			return nil, err
+		}
+		if len(ids) == 0 {
+			break
+		}
+		rng.Offset += int64(len(ids))
+		keys := make([]string, len(ids))
+		for i, id := range ids {
+			keys[i] = s.key(id)
+		}
+		vals, err := s.rdb.MGet(ctx, keys...).Result()
+		if err != nil {
+			return nil, err
+		}
+		var gone []interface{}
+		for i, v := range vals {
+			b, ok := v.(string)
+			if !ok {
+				gone = append(gone, ids[i])
+				continue
+			}
+			var j job
+			if err := json.Unmarshal([]byte(b), &j); err != nil {
+				return nil, err
+			}
+			if q.matches(j) {
+				jobs = append(jobs, j)
+			}
+		}
+		if len(gone) > 0 {
+			if err := s.rdb.ZRem(ctx, s.indexKey(q.Identity), gone...).Err(); err != nil {
+				return nil, err
+			}
+			rng.Offset -= int64(len(gone))
+		}
+		// Once past the microsecond of the last job to return, every job
+		// that could sort before it has been read.
+		if len(jobs) > q.Limit && jobs[len(jobs)-1].Created.UnixMicro() < jobs[q.Limit-1].Created.UnixMicro() {
+			break
+		}
+	}
+	newestJobFirst(jobs)
+	if len(jobs) > q.Limit {
+		jobs = jobs[:q.Limit]
+	}
+	return jobs, nil
+}
+
+func (s *redisJobStore) Export(ctx context.Context, fn func(j job, expires time.Time) error) error {
+	return redisScan(ctx, s.rdb, s.key(""), func(key string) error {
+		b, err := s.rdb.Get(ctx, key).Bytes()
+		if err == redis.Nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		ttl, err := s.rdb.PTTL(ctx, key).Result()
+		if err != nil || ttl <= 0 {
+			return err
+		}
+		var j job
+		if err := json.Unmarshal(b, &j); err != nil {
+			return err
+		}
+		return fn(j, time.Now().Add(ttl).UTC())
+	})
+}
+
+func (s *redisJobStore) Get(ctx context.Context, id string) (job, error) {
+	b, err := s.rdb.Get(ctx, s.key(id)).Bytes()
+	if err == redis.Nil {
+		return job{}, errNoJob(id)
+	}
+	if err != nil {
+		return job{}, err
+	}
+	var j job
+	err = json.Unmarshal(b, &j)
+	return j, err
+}
+
+// redisTokenStore keeps each token as JSON under a key that Redis expires
+// with the token.
+type redisTokenStore struct {
+	rdb    *redis.Client
+	prefix string
+}
+
+func (s *redisTokenStore) key(hash string) string { return s.prefix + "token:" + hash }
+
+func (s *redisTokenStore) Create(ctx context.Context, hash string, t issuedToken) error {
+	b, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	ttl := time.Until(t.Expires)
+	if ttl <= 0 {
+		return nil
+	}
+	return s.rdb.Set(ctx, s.key(hash), b, ttl).Err()
+}
+
+func (s *redisTokenStore) Export(ctx context.Context, now time.Time, fn func(hash string, t issuedToken) error) error {
+	return redisScan(ctx, s.rdb, s.key(""), func(key string) error {
+		b, err := s.rdb.Get(ctx, key).Bytes()
+		if err == redis.Nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var t issuedToken
+		if err := json.Unmarshal(b, &t); err != nil {
+			return err
+		}
+		if !now.Before(t.Expires) {
+			return nil
+		}
+		return fn(strings.TrimPrefix(key, s.key("")), t)
+	})
+}
+
+func (s *redisTokenStore) Lookup(ctx context.Context, hash string, now time.Time) (issuedToken, error) {
+	b, err := s.rdb.Get(ctx, s.key(hash)).Bytes()
+	if err == redis.Nil {
+		return issuedToken{}, errInvalidToken
+	}
+	if err != nil {
+		return issuedToken{}, err
+	}
+	var t issuedToken
+	if err := json.Unmarshal(b, &t); err != nil {
+		return issuedToken{}, err
+	}
+	if !now.Before(t.Expires) {
+		return issuedToken{}, errInvalidToken
+	}
+	return t, nil
+}
+
+func (s *redisTokenStore) Revoke(ctx context.Context, hash string) error {
+	return s.rdb.Del(ctx, s.key(hash)).Err()
+}
+
+// redisTenantConfigStore keeps every tenant's overrides as JSON in one
+// hash, by tenant ID.
+type redisTenantConfigStore struct {
+	rdb    *redis.Client
+	prefix string
+}
+
+func (s *redisTenantConfigStore) key() string { return s.prefix + "tenant_configs" }
+
+func (s *redisTenantConfigStore) Get(ctx context.Context, tenantID string) (tenantOverrides, error) {
+	b, err := s.rdb.HGet(ctx, s.key(), tenantID).Bytes()
+	if err == redis.Nil {
+		return tenantOverrides{}, errNoTenantConfig(tenantID)
+	}
+	if err != nil {
+		return tenantOverrides{}, err
+	}
+	var o tenantOverrides
+	err = json.Unmarshal(b, &o)
+	return o, err
+}
+
+func (s *redisTenantConfigStore) Put(ctx context.Context, tenantID string, o tenantOverrides) error {
+	b, err := json.Marshal(o)
+	if err != nil {
+		return err
+	}
+	return s.rdb.HSet(ctx, s.key(), tenantID, b).Err()
+}
+
+func (s *redisTenantConfigStore) Delete(ctx context.Context, tenantID string) error {
+	return s.rdb.HDel(ctx, s.key(), tenantID).Err()
+}
+
+func (s *redisTenantConfigStore) List(ctx context.Context) (map[string]tenantOverrides, error) {
+	all, err := s.rdb.HGetAll(ctx, s.key()).Result()
+	if err != nil {
+		return nil, err
+	}
+	configs := make(map[string]tenantOverrides, len(all))
+	for id, v := range all {
+		var o tenantOverrides
+		if err := json.Unmarshal([]byte(v), &o); err != nil {
+			return nil, err
+		}
+		configs[id] = o
+	}
+	return configs, nil
+}
+
+// redisKeyStore keeps every API key as JSON in one hash, by ID.
+type redisKeyStore struct {
+	rdb    *redis.Client
+	prefix string
+}
+
+func (s *redisKeyStore) key() string { return s.prefix + "api_keys" }
+
+func (s *redisKeyStore) Create(ctx context.Context, k apiKey) error {
+	b, err := json.Marshal(k)
+	if err != nil {
+		return err
+	}
+	ok, err := s.rdb.HSetNX(ctx, s.key(), k.ID, b).Result()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errKeyTaken(k.ID)
+	}
+	return nil
+}
+
+func (s *redisKeyStore) Get(ctx context.Context, id string) (apiKey, error) {
+	b, err := s.rdb.HGet(ctx, s.key(), id).Bytes()
+	if err == redis.Nil {
+		return apiKey{}, errNoKey(id)
+	}
+	if err != nil {
+		return apiKey{}, err
+	}
+	var k apiKey
+	err = json.Unmarshal(b, &k)
+	return k, err
+}
+
+func (s *redisKeyStore) Update(ctx context.Context, k apiKey) error {
+	b, err := json.Marshal(k)
+	if err != nil {
+		return err
+	}
+	if ok, err := s.rdb.HExists(ctx, s.key(), k.ID).Result(); err != nil {
+		return err
+	} else if !ok {
+		return errNoKey(k.ID)
+	}
+	return s.rdb.HSet(ctx, s.key(), k.ID, b).Err()
+}
+
+func (s *redisKeyStore) List(ctx context.Context) ([]apiKey, error) {
+	all, err := s.rdb.HGetAll(ctx, s.key()).Result()
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]apiKey, 0, len(all))
+	for _, v := range all {
+		var k apiKey
+		if err := json.Unmarshal([]byte(v), &k); err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+	return keys, nil
+}
+
+// redisIdempotencyClaimTTL is how long a request's claim on its
+// idempotency key lasts in Redis, in case the instance handling it dies
+// before recording its response. A retry arriving after that runs again.
+const redisIdempotencyClaimTTL = 5 * time.Minute
+
+// redisIdempotencyStore keeps each recorded response as JSON under a key
+// that Redis expires with it. A claimed key holds an empty value until
+// its response is recorded.
+type redisIdempotencyStore struct {
+	rdb    *redis.Client
+	prefix string
+}
+
+// key hashes the idempotency key, which holds the caller's identity.
+func (s *redisIdempotencyStore) key(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return s.prefix + "idempotency:" + hex.EncodeToString(sum[:])
+}
+
+func (s *redisIdempotencyStore) Begin(ctx context.Context, key, fingerprint string, ttl time.Duration) (*recordedResponse, bool, error) {
+	claim := redisIdempotencyClaimTTL
+	if ttl < claim {
+		claim = ttl
+	}
+	ok, err := s.rdb.SetNX(ctx, s.key(key), "", claim).Result()
+	if err != nil || ok {
+		return nil, ok, err
+	}
+	b, err := s.rdb.Get(ctx, s.key(key)).Bytes()
+	if err == redis.Nil || (err == nil && len(b) == 0) {
+		// In flight, or released since; either way, not to be run now.
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	var resp recordedResponse
+	if err := json.Unmarshal(b, &resp); err != nil {
+		return nil, false, err
+	}
+	return &resp, false, nil
+}
+
+func (s *redisIdempotencyStore) Finish(ctx context.Context, key string, resp *recordedResponse, ttl time.Duration) error {
+	b, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	return s.rdb.Set(ctx, s.key(key), b, ttl).Err()
+}
+
+func (s *redisIdempotencyStore) Abort(ctx context.Context, key string) error {
+	return s.rdb.Del(ctx, s.key(key)).Err()
+}
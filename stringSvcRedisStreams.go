@@ -0,0 +1,309 @@
+package stringsvc
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	httptransport "github.com/go-kit/kit/transport/http"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/propagation"
+
+	"github.com/mcclayac/gokit/svcerrors"
+	"github.com/mcclayac/gokit/tracing"
+)
+
+// redisStreamsConfig configures the Redis Streams worker.
+type redisStreamsConfig struct {
+	Addr             string // the Redis Streams worker is off when empty
+	Stream           string
+	Group            string
+	Consumer         string // defaults to the hostname
+	ResponseStream   string // responses are not written when empty
+	ResponseMaxLen   int64  // approximate cap on the response stream's length
+	ClaimIdle        time.Duration
+	Count            int    // entries read at once
+	DeadLetterStream string // entries given up on are dropped when empty
+}
+
+// redisBodyField is the stream entry field holding the request or response.
+// The other fields are named like the SQS message attributes.
+const redisBodyField = "body"
+
+// redisStreamsWorker reads requests as a member of a consumer group,
+// creating the group and stream if needed. Entries are acknowledged once
+// handled. Entries that may succeed on retry are left pending and claimed
+// again once they have been idle for their backoff delay; entries left
+// pending by other consumers are claimed after ClaimIdle, in case the
+// consumer died. Entries that fail on their MaxDeliver'th delivery, or can
+// never be handled, are dead-lettered. When ResponseStream is set, the
+// response to each entry is added to it, with the request entry's ID in
+// the request_entry field.
+type redisStreamsWorker struct {
+	rdb       *redis.Client
+	cfg       workerConfig
+	endpoints map[string]messageEndpoint
+	env       *appEnv
+	logger    log.Logger
+}
+
+// redisEntry is a stream entry and the number of times it has been
+// delivered, counting this one.
+type redisEntry struct {
+	msg     redis.XMessage
+	attempt int
+}
+
+func newRedisStreamsWorker(ctx context.Context, cfg workerConfig, endpoints map[string]messageEndpoint, env *appEnv, logger log.Logger) (*redisStreamsWorker, error) {
+	if cfg.Redis.Consumer == "" {
+		host, err := os.Hostname()
+		if err != nil {
+			return nil, err
+		}
+		cfg.Redis.Consumer = host
+	}
+	rdb := redis.NewClient(&redis.Options{Addr: cfg.Redis.Addr})
+	err := rdb.XGroupCreateMkStream(ctx, cfg.Redis.Stream, cfg.Redis.Group, "$").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		rdb.Close()
+		return nil, fmt.Errorf("creating consumer group: %v", err)
+	}
+	return &redisStreamsWorker{rdb: rdb, cfg: cfg, endpoints: endpoints, env: env, logger: logger}, nil
+}
+
+func (w *redisStreamsWorker) Run(ctx context.Context) error {
+	defer w.rdb.Close()
+	for ctx.Err() == nil {
+		claimed, err := w.claim(ctx)
+		if err == nil {
+			w.handleAll(claimed)
+		}
+		var streams []redis.XStream
+		if err == nil {
+			streams, err = w.rdb.XReadGroup(ctx, &redis.XReadGroupArgs{
+				Group:    w.cfg.Redis.Group,
+				Consumer: w.cfg.Redis.Consumer,
+				Streams:  []string{w.cfg.Redis.Stream, ">"},
+				Count:    int64(w.cfg.Redis.Count),
+				Block:    time.Second,
+			}).Result()
+		}
+		switch {
+		case ctx.Err() != nil:
+		case err == redis.Nil:
+		case err != nil:
+			level.Error(w.logger).Log("msg", "reading stream", "err", err)
+			select {
+			case <-time.After(w.cfg.Backoff):
+			case <-ctx.Done():
+			}
+		default:
+			for _, s := range streams {
+				entries := make([]redisEntry, len(s.Messages))
+				for i, m := range s.Messages {
+					entries[i] = redisEntry{m, 1}
+				}
+				w.handleAll(entries)
+			}
+		}
+	}
+	return nil
+}
+
+func (w *redisStreamsWorker) DeadLetters() deadLetterQueue {
+	if w.cfg.Redis.DeadLetterStream == "" {
+		return nil
+	}
+	return w
+}
+
+// claim takes over the pending entries that are due for another attempt.
+func (w *redisStreamsWorker) claim(ctx context.Context) ([]redisEntry, error) {
+	pending, err := w.rdb.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: w.cfg.Redis.Stream,
+		Group:  w.cfg.Redis.Group,
+		Start:  "-",
+		End:    "+",
+		Count:  int64(w.cfg.Redis.Count),
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	var claimed []redisEntry
+	for _, p := range pending {
+		minIdle := w.cfg.Redis.ClaimIdle
+		if p.Consumer == w.cfg.Redis.Consumer {
+			// Nothing of ours is in flight between batches, so this entry
+			// failed and was left for a retry.
+			minIdle = redeliveryDelay(w.cfg.Backoff, int(p.RetryCount))
+		}
+		if p.Idle < minIdle {
+			continue
+		}
+		msgs, err := w.rdb.XClaim(ctx, &redis.XClaimArgs{
+			Stream:   w.cfg.Redis.Stream,
+			Group:    w.cfg.Redis.Group,
+			Consumer: w.cfg.Redis.Consumer,
+			MinIdle:  minIdle,
+			Messages: []string{p.ID},
+		}).Result()
+		if err != nil {
+			return claimed, err
+		}
+		for _, m := range msgs {
+			claimed = append(claimed, redisEntry{m, int(p.RetryCount) + 1})
+		}
+	}
+	return claimed, nil
+}
+
+// handleAll handles entries concurrently and waits for them. Like the SQS
+// worker, handling runs to completion even when the worker is stopped.
+func (w *redisStreamsWorker) handleAll(entries []redisEntry) {
+	var wg sync.WaitGroup
+	for _, e := range entries {
+		wg.Add(1)
+		go func(e redisEntry) {
+			defer wg.Done()
+			w.handle(e)
+		}(e)
+	}
+	wg.Wait()
+}
+
+func (w *redisStreamsWorker) handle(e redisEntry) {
+	fields := redisFieldMap(e.msg.Values)
+	logger := log.With(w.logger, "entry", e.msg.ID)
+
+	ctx := tracing.Propagator.Extract(w.env.attach(context.Background()), propagation.MapCarrier(fields))
+	ctx = context.WithValue(ctx, httptransport.ContextKeyRequestXRequestID, fields[requestIDHeader])
+
+	response, outcome, err := handleMessage(ctx, w.endpoints, fields[sqsOperationAttribute], fields["Content-Type"], []byte(fields[redisBodyField]))
+	switch outcome {
+	case messageRejected:
+		level.Error(logger).Log("msg", "rejecting entry", "err", err)
+		w.giveUp(e, fields, logger, err)
+	case messageRetry:
+		if e.attempt >= w.cfg.MaxDeliver {
+			level.Error(logger).Log("msg", "giving up on entry", "attempts", e.attempt, "err", err)
+			w.giveUp(e, fields, logger, err)
+			return
+		}
+		// Left pending; claim picks it up again after the backoff.
+		level.Warn(logger).Log("msg", "retrying entry", "attempt", e.attempt, "err", err)
+	default:
+		w.reply(e.msg.ID, fields, response, err)
+		w.ack(e.msg.ID)
+	}
+}
+
+// giveUp dead-letters e, replies with err and acknowledges it. If the
+// dead-letter stream can't take the entry, it is left pending to be
+// claimed again rather than lost.
+func (w *redisStreamsWorker) giveUp(e redisEntry, fields map[string]string, logger log.Logger, err error) {
+	if w.cfg.Redis.DeadLetterStream != "" {
+		values := map[string]interface{}{}
+		for k, v := range fields {
+			values[k] = v
+		}
+		for k, v := range deadLetterHeaders(w.cfg.Redis.Stream, e.attempt, err) {
+			values[k] = v
+		}
+		derr := w.rdb.XAdd(context.Background(), &redis.XAddArgs{Stream: w.cfg.Redis.DeadLetterStream, Values: values}).Err()
+		if derr != nil {
+			level.Error(logger).Log("msg", "dead-lettering entry", "err", derr)
+			return
+		}
+	}
+	w.reply(e.msg.ID, fields, nil, err)
+	w.ack(e.msg.ID)
+}
+
+func (w *redisStreamsWorker) ack(id string) {
+	if err := w.rdb.XAck(context.Background(), w.cfg.Redis.Stream, w.cfg.Redis.Group, id).Err(); err != nil {
+		level.Error(w.logger).Log("msg", "acknowledging entry", "entry", id, "err", err)
+	}
+}
+
+// reply adds the response, or the error envelope when err is non-nil, to
+// the response stream, in the format named by the entry's Accept field.
+func (w *redisStreamsWorker) reply(id string, fields map[string]string, response interface{}, err error) {
+	if w.cfg.Redis.ResponseStream == "" {
+		return
+	}
+	contentType, body, err := encodeReply(w.env.codecs, fields["Accept"], response, err)
+	if err != nil {
+		level.Error(w.logger).Log("msg", "encoding reply", "err", err)
+		return
+	}
+	values := map[string]interface{}{
+		"request_entry": id,
+		"Content-Type":  contentType,
+		redisBodyField:  body,
+	}
+	if rid := fields[requestIDHeader]; rid != "" {
+		values[requestIDHeader] = rid
+	}
+	err = w.rdb.XAdd(context.Background(), &redis.XAddArgs{
+		Stream: w.cfg.Redis.ResponseStream,
+		MaxLen: w.cfg.Redis.ResponseMaxLen,
+		Approx: true,
+		Values: values,
+	}).Err()
+	if err != nil {
+		level.Error(w.logger).Log("msg", "adding reply", "err", err)
+	}
+}
+
+// List returns the newest dead letters first. Their IDs are entry IDs in
+// the dead-letter stream.
+func (w *redisStreamsWorker) List(ctx context.Context, limit int) ([]deadLetter, error) {
+	msgs, err := w.rdb.XRevRangeN(ctx, w.cfg.Redis.DeadLetterStream, "+", "-", int64(limit)).Result()
+	if err != nil {
+		return nil, err
+	}
+	dls := make([]deadLetter, len(msgs))
+	for i, m := range msgs {
+		fields := redisFieldMap(m.Values)
+		body := fields[redisBodyField]
+		delete(fields, redisBodyField)
+		dls[i] = newDeadLetter(m.ID, fields, []byte(body))
+	}
+	return dls, nil
+}
+
+func (w *redisStreamsWorker) Redrive(ctx context.Context, id string) error {
+	msgs, err := w.rdb.XRangeN(ctx, w.cfg.Redis.DeadLetterStream, id, id, 1).Result()
+	if err != nil {
+		return err
+	}
+	if len(msgs) == 0 {
+		return svcerrors.Errorf(svcerrors.CodeNotFound, "no dead letter %q", id)
+	}
+	fields := redisFieldMap(msgs[0].Values)
+	values := map[string]interface{}{}
+	for k, v := range fields {
+		if !isDeadLetterHeader(k) {
+			values[k] = v
+		}
+	}
+	if err := w.rdb.XAdd(ctx, &redis.XAddArgs{Stream: fields[deadLetterSourceHeader], Values: values}).Err(); err != nil {
+		return err
+	}
+	return w.rdb.XDel(ctx, w.cfg.Redis.DeadLetterStream, id).Err()
+}
+
+func redisFieldMap(values map[string]interface{}) map[string]string {
+	m := make(map[string]string, len(values))
+	for k, v := range values {
+		if s, ok := v.(string); ok {
+			m[k] = s
+		}
+	}
+	return m
+}
@@ -0,0 +1,137 @@
+package stringsvc
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	httptransport "github.com/go-kit/kit/transport/http"
+)
+
+const requestIDHeader = "X-Request-Id"
+
+// requestIDMiddleware makes sure every request has an X-Request-Id, keeping
+// the caller's if it sent one, and echoes it in the response.
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if id == "" {
+			b := make([]byte, 16)
+			rand.Read(b)
+			id = hex.EncodeToString(b)
+			r.Header.Set(requestIDHeader, id)
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requestID returns the request ID put in ctx by the HTTP transport.
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(httptransport.ContextKeyRequestXRequestID).(string)
+	return id
+}
+
+// identity returns who made the request: the client a bearer token was
+// issued to, or for callers without one their address, as clientAddr
+// resolves it.
+func identity(ctx context.Context) string {
+	if t, ok := principal(ctx); ok {
+		return t.Subject
+	}
+	return clientAddr(ctx)
+}
+
+// callerIdentity returns the identity of r's caller for HTTP middlewares,
+// which run before authMiddleware: the subject of the principal
+// authenticatingMiddleware found, if any, and otherwise its address.
+func callerIdentity(r *http.Request) string {
+	return identity(httptransport.PopulateRequestContext(r.Context(), r))
+}
+
+// clientAddr returns the address of the caller. It is the remote address
+// of the connection, unless that is a proxy trusted by -trusted-proxies: then X-Forwarded-For
+// is walked from the right, past the hops added by trusted proxies, to the
+// first one that wasn't. Hops further left were written by the caller, who
+// can claim to be anyone, so they are never used.
+func clientAddr(ctx context.Context) string {
+	addr, _ := ctx.Value(httptransport.ContextKeyRequestRemoteAddr).(string)
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	trusted := appEnvFromContext(ctx).proxies
+	if !trusted.Contains(addr) {
+		return addr
+	}
+	xff, _ := ctx.Value(httptransport.ContextKeyRequestXForwardedFor).(string)
+	hops := strings.Split(xff, ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			break
+		}
+		addr = hop
+		if !trusted.Contains(hop) {
+			break
+		}
+	}
+	return addr
+}
+
+// proxyList is a list of addresses and CIDR ranges. It implements
+// flag.Value, parsing lists like "10.0.0.0/8,192.168.1.10".
+type proxyList []*net.IPNet
+
+func (l *proxyList) Set(s string) error {
+	var list proxyList
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		if !strings.Contains(v, "/") {
+			ip := net.ParseIP(v)
+			if ip == nil {
+				return fmt.Errorf("%q: want an IP address or CIDR range", v)
+			}
+			bits := 8 * net.IPv4len
+			if ip.To4() == nil {
+				bits = 8 * net.IPv6len
+			}
+			list = append(list, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(v)
+		if err != nil {
+			return fmt.Errorf("%q: want an IP address or CIDR range", v)
+		}
+		list = append(list, n)
+	}
+	*l = list
+	return nil
+}
+
+func (l *proxyList) String() string {
+	parts := make([]string, len(*l))
+	for i, n := range *l {
+		parts[i] = n.String()
+	}
+	return strings.Join(parts, ",")
+}
+
+// Contains reports whether addr is in the list.
+func (l proxyList) Contains(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range l {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,75 @@
+package stringsvc
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// sloThresholds holds the latency objective of each endpoint. It implements
+// flag.Value, parsing lists like "uppercase=100ms,count=50ms".
+type sloThresholds struct {
+	Default time.Duration
+	Methods map[string]time.Duration
+}
+
+// For returns the latency objective of method.
+func (t sloThresholds) For(method string) time.Duration {
+	if d, ok := t.Methods[method]; ok {
+		return d
+	}
+	return t.Default
+}
+
+func (t *sloThresholds) Set(s string) error {
+	methods := map[string]time.Duration{}
+	for _, kv := range strings.Split(s, ",") {
+		if kv = strings.TrimSpace(kv); kv == "" {
+			continue
+		}
+		i := strings.IndexByte(kv, '=')
+		if i < 0 {
+			return fmt.Errorf("%q: want method=duration", kv)
+		}
+		d, err := time.ParseDuration(kv[i+1:])
+		if err != nil || d <= 0 {
+			return fmt.Errorf("%q: want a positive duration", kv)
+		}
+		methods[kv[:i]] = d
+	}
+	t.Methods = methods
+	return nil
+}
+
+func (t *sloThresholds) String() string {
+	var parts []string
+	for m, d := range t.Methods {
+		parts = append(parts, m+"="+d.String())
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+// Buckets returns histogram bucket bounds, in seconds, that include every
+// threshold, so that the share of requests within each objective can be read
+// exactly from the histogram.
+func (t sloThresholds) Buckets(base []float64) []float64 {
+	seen := map[float64]bool{}
+	var buckets []float64
+	add := func(b float64) {
+		if !seen[b] {
+			seen[b] = true
+			buckets = append(buckets, b)
+		}
+	}
+	for _, b := range base {
+		add(b)
+	}
+	add(t.Default.Seconds())
+	for _, d := range t.Methods {
+		add(d.Seconds())
+	}
+	sort.Float64s(buckets)
+	return buckets
+}
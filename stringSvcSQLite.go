@@ -0,0 +1,140 @@
+package stringsvc
+
+import (
+	"context"
+	"database/sql"
+	"net/url"
+	"time"
+
+	_ "modernc.org/sqlite" // registers the "sqlite" database/sql driver; pure Go, no cgo
+)
+
+// openSQLite opens the SQLite database file at path, creating it if need
+// be. The database is in WAL mode so reads don't wait for writes, and a
+// writer waits up to five seconds for another to finish instead of failing.
+//
+// SQLite has no time type, so the SQLite stores keep times as Unix
+// nanoseconds, which sort and compare correctly as integers. The tables
+// are created by the migrations in package migrate.
+func openSQLite(ctx context.Context, path string) (*sql.DB, error) {
+	dsn := "file:" + path + "?" + url.Values{"_pragma": {"journal_mode(WAL)", "busy_timeout(5000)", "foreign_keys(1)"}}.Encode()
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func unixNanos(t time.Time) sql.NullInt64 {
+	return sql.NullInt64{Int64: t.UnixNano(), Valid: !t.IsZero()}
+}
+
+func fromUnixNanos(n sql.NullInt64) time.Time {
+	if !n.Valid {
+		return time.Time{}
+	}
+	return time.Unix(0, n.Int64).UTC()
+}
+
+// sqliteShortenerStore keeps links in SQLite. Expired links stay in the
+// table until their code is reused.
+type sqliteShortenerStore struct {
+	db *sql.DB
+}
+
+func (s *sqliteShortenerStore) Create(ctx context.Context, link shortLink) error {
+	res, err := s.db.ExecContext(ctx, `
+		INSERT INTO short_links (code, url, created_at, expires_at, hits) VALUES (?1, ?2, ?3, ?4, ?5)
+		ON CONFLICT (code) DO UPDATE SET url = ?2, created_at = ?3, expires_at = ?4, hits = ?5
+		WHERE short_links.expires_at <= ?3`,
+		link.Code, link.URL, link.Created.UnixNano(), unixNanos(link.Expires), link.Hits)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return errCodeTaken
+	}
+	return nil
+}
+
+func (s *sqliteShortenerStore) Hit(ctx context.Context, code string, now time.Time) (shortLink, error) {
+	l := shortLink{Code: code}
+	var created, expires sql.NullInt64
+	err := s.db.QueryRowContext(ctx, `
+		UPDATE short_links SET hits = hits + 1
+		WHERE code = ?1 AND (expires_at IS NULL OR expires_at > ?2)
+		RETURNING url, created_at, expires_at, hits`,
+		code, now.UnixNano()).Scan(&l.URL, &created, &expires, &l.Hits)
+	if err == sql.ErrNoRows {
+		return shortLink{}, errNoLink(code)
+	}
+	l.Created, l.Expires = fromUnixNanos(created), fromUnixNanos(expires)
+	return l, err
+}
+
+func (s *sqliteShortenerStore) Export(ctx context.Context, now time.Time, fn func(shortLink) error) error {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT code, url, created_at, expires_at, hits FROM short_links
+		WHERE expires_at IS NULL OR expires_at > ?1`, now.UnixNano())
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			l                shortLink
+			created, expires sql.NullInt64
+		)
+		if err := rows.Scan(&l.Code, &l.URL, &created, &expires, &l.Hits); err != nil {
+			return err
+		}
+		l.Created, l.Expires = fromUnixNanos(created), fromUnixNanos(expires)
+		if err := fn(l); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// sqliteHistoryStore keeps history records in SQLite.
+type sqliteHistoryStore struct {
+	db *sql.DB
+}
+
+func (h *sqliteHistoryStore) Record(ctx context.Context, rec historyRecord) error {
+	_, err := h.db.ExecContext(ctx, `
+		INSERT INTO request_history (id, time, operation, identity, request_id, input_sha256, status, result_size, duration_ms)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		rec.ID, rec.Time.UnixNano(), rec.Operation, rec.Identity, rec.RequestID, rec.InputHash, rec.Status, rec.ResultSize, rec.DurationMS)
+	return err
+}
+
+func (h *sqliteHistoryStore) List(ctx context.Context, q historyQuery) ([]historyRecord, error) {
+	query, args := historyListQuery(q,
+		func(int) string { return "?" },
+		func(t time.Time) interface{} { return t.UnixNano() })
+	rows, err := h.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	records := []historyRecord{}
+	for rows.Next() {
+		var (
+			r historyRecord
+			t sql.NullInt64
+		)
+		if err := rows.Scan(&r.ID, &t, &r.Operation, &r.Identity, &r.RequestID, &r.InputHash, &r.Status, &r.ResultSize, &r.DurationMS); err != nil {
+			return nil, err
+		}
+		r.Time = fromUnixNanos(t)
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
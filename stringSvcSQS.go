@@ -0,0 +1,321 @@
+package stringsvc
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	httptransport "github.com/go-kit/kit/transport/http"
+	"go.opentelemetry.io/otel/propagation"
+
+	"github.com/mcclayac/gokit/svcerrors"
+	"github.com/mcclayac/gokit/tracing"
+)
+
+// sqsConfig configures the SQS worker. AWS credentials and region come from
+// the usual environment variables, shared config files or instance role.
+type sqsConfig struct {
+	QueueURL           string // the SQS worker is off when empty
+	WaitTime           time.Duration
+	MaxMessages        int
+	VisibilityTimeout  time.Duration
+	DeadLetterQueueURL string // messages given up on are dropped when empty
+}
+
+// Message attributes read by the SQS worker. The trace context is read
+// from attributes named like its HTTP headers (traceparent, b3, ...).
+const (
+	sqsOperationAttribute = "Operation" // the method to call
+	sqsReplyToAttribute   = "Reply-To"  // queue URL to send the response to
+)
+
+// sqsDeadLetterAttributes are the attributes of a request kept when it is
+// dead-lettered. SQS allows ten attributes, and the dead-letter headers
+// take the other five.
+var sqsDeadLetterAttributes = []string{sqsOperationAttribute, "Content-Type", "Accept", sqsReplyToAttribute, requestIDHeader}
+
+// sqsWorker long-polls a queue. Each message names its method in the
+// Operation attribute and is decoded by its Content-Type attribute; since
+// SQS bodies are text, only text formats work. While a message is handled
+// its visibility timeout is kept extended. Messages are deleted once
+// handled, made visible again after an exponential backoff when they may
+// succeed on retry, and dead-lettered when they never can or MaxDeliver
+// receives have been made.
+type sqsWorker struct {
+	client    *sqs.Client
+	cfg       workerConfig
+	endpoints map[string]messageEndpoint
+	env       *appEnv
+	logger    log.Logger
+}
+
+func newSQSWorker(ctx context.Context, cfg workerConfig, endpoints map[string]messageEndpoint, env *appEnv, logger log.Logger) (*sqsWorker, error) {
+	awsCfg, err := awsconfig.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &sqsWorker{client: sqs.NewFromConfig(awsCfg), cfg: cfg, endpoints: endpoints, env: env, logger: logger}, nil
+}
+
+// Run finishes the messages it has already received before returning.
+func (w *sqsWorker) Run(ctx context.Context) error {
+	for {
+		out, err := w.client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:                    aws.String(w.cfg.SQS.QueueURL),
+			MaxNumberOfMessages:         int32(w.cfg.SQS.MaxMessages),
+			WaitTimeSeconds:             int32(w.cfg.SQS.WaitTime / time.Second),
+			VisibilityTimeout:           int32(w.cfg.SQS.VisibilityTimeout / time.Second),
+			MessageAttributeNames:       []string{"All"},
+			MessageSystemAttributeNames: []sqstypes.MessageSystemAttributeName{sqstypes.MessageSystemAttributeNameApproximateReceiveCount},
+		})
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			level.Error(w.logger).Log("msg", "receiving messages", "err", err)
+			select {
+			case <-time.After(w.cfg.Backoff):
+			case <-ctx.Done():
+				return nil
+			}
+			continue
+		}
+		var wg sync.WaitGroup
+		for _, m := range out.Messages {
+			wg.Add(1)
+			go func(m sqstypes.Message) {
+				defer wg.Done()
+				w.handle(m)
+			}(m)
+		}
+		wg.Wait()
+	}
+}
+
+func (w *sqsWorker) DeadLetters() deadLetterQueue {
+	if w.cfg.SQS.DeadLetterQueueURL == "" {
+		return nil
+	}
+	return w
+}
+
+func (w *sqsWorker) handle(m sqstypes.Message) {
+	attrs := sqsAttributeMap(m.MessageAttributes)
+	logger := log.With(w.logger, "message_id", aws.ToString(m.MessageId))
+
+	// Handling runs to completion even when the worker is stopped, so that
+	// the message is never left half processed.
+	ctx, cancel := context.WithCancel(w.env.attach(context.Background()))
+	defer cancel()
+	ctx = tracing.Propagator.Extract(ctx, propagation.MapCarrier(attrs))
+	ctx = context.WithValue(ctx, httptransport.ContextKeyRequestXRequestID, attrs[requestIDHeader])
+	go w.extendVisibility(ctx, m.ReceiptHandle)
+
+	attempt, _ := strconv.Atoi(m.Attributes[string(sqstypes.MessageSystemAttributeNameApproximateReceiveCount)])
+	response, outcome, err := handleMessage(ctx, w.endpoints, attrs[sqsOperationAttribute], attrs["Content-Type"], []byte(aws.ToString(m.Body)))
+	cancel()
+	switch outcome {
+	case messageRejected:
+		level.Error(logger).Log("msg", "rejecting message", "err", err)
+		w.giveUp(m, attrs, logger, attempt, err)
+	case messageRetry:
+		if attempt >= w.cfg.MaxDeliver {
+			level.Error(logger).Log("msg", "giving up on message", "attempts", attempt, "err", err)
+			w.giveUp(m, attrs, logger, attempt, err)
+			return
+		}
+		delay := redeliveryDelay(w.cfg.Backoff, attempt)
+		level.Warn(logger).Log("msg", "retrying message", "attempt", attempt, "delay", delay, "err", err)
+		w.setVisibility(context.Background(), w.cfg.SQS.QueueURL, m.ReceiptHandle, delay)
+	default:
+		w.reply(attrs, response, err)
+		w.delete(context.Background(), w.cfg.SQS.QueueURL, m.ReceiptHandle)
+	}
+}
+
+// giveUp dead-letters m, replies with err and deletes it. If the
+// dead-letter queue can't take the message, it is left to be received
+// again rather than lost.
+func (w *sqsWorker) giveUp(m sqstypes.Message, attrs map[string]string, logger log.Logger, attempts int, err error) {
+	if w.cfg.SQS.DeadLetterQueueURL != "" {
+		dlAttrs := map[string]string{}
+		for _, k := range sqsDeadLetterAttributes {
+			if v := attrs[k]; v != "" {
+				dlAttrs[k] = v
+			}
+		}
+		for k, v := range deadLetterHeaders(w.cfg.SQS.QueueURL, attempts, err) {
+			dlAttrs[k] = v
+		}
+		if derr := w.send(context.Background(), w.cfg.SQS.DeadLetterQueueURL, dlAttrs, aws.ToString(m.Body)); derr != nil {
+			level.Error(logger).Log("msg", "dead-lettering message", "err", derr)
+			return
+		}
+	}
+	w.reply(attrs, nil, err)
+	w.delete(context.Background(), w.cfg.SQS.QueueURL, m.ReceiptHandle)
+}
+
+// extendVisibility keeps the message hidden from other consumers until ctx
+// is done, renewing its visibility timeout halfway through each period.
+func (w *sqsWorker) extendVisibility(ctx context.Context, receipt *string) {
+	t := time.NewTicker(w.cfg.SQS.VisibilityTimeout / 2)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			w.setVisibility(ctx, w.cfg.SQS.QueueURL, receipt, w.cfg.SQS.VisibilityTimeout)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (w *sqsWorker) setVisibility(ctx context.Context, queueURL string, receipt *string, d time.Duration) {
+	_, err := w.client.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          aws.String(queueURL),
+		ReceiptHandle:     receipt,
+		VisibilityTimeout: int32(d / time.Second),
+	})
+	if err != nil && ctx.Err() == nil {
+		level.Warn(w.logger).Log("msg", "changing message visibility", "err", err)
+	}
+}
+
+func (w *sqsWorker) delete(ctx context.Context, queueURL string, receipt *string) error {
+	_, err := w.client.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+		QueueUrl:      aws.String(queueURL),
+		ReceiptHandle: receipt,
+	})
+	if err != nil {
+		level.Error(w.logger).Log("msg", "deleting message", "err", err)
+	}
+	return err
+}
+
+func (w *sqsWorker) send(ctx context.Context, queueURL string, attrs map[string]string, body string) error {
+	msgAttrs := make(map[string]sqstypes.MessageAttributeValue, len(attrs))
+	for k, v := range attrs {
+		msgAttrs[k] = sqstypes.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(v)}
+	}
+	_, err := w.client.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:          aws.String(queueURL),
+		MessageBody:       aws.String(body),
+		MessageAttributes: msgAttrs,
+	})
+	return err
+}
+
+// reply sends the response, or the error envelope when err is non-nil, to
+// the queue in the message's Reply-To attribute, in the format named by its
+// Accept attribute.
+func (w *sqsWorker) reply(attrs map[string]string, response interface{}, err error) {
+	queueURL := attrs[sqsReplyToAttribute]
+	if queueURL == "" {
+		return
+	}
+	contentType, body, err := encodeReply(w.env.codecs, attrs["Accept"], response, err)
+	if err != nil {
+		level.Error(w.logger).Log("msg", "encoding reply", "err", err)
+		return
+	}
+	replyAttrs := map[string]string{"Content-Type": contentType}
+	if id := attrs[requestIDHeader]; id != "" {
+		replyAttrs[requestIDHeader] = id
+	}
+	if err := w.send(context.Background(), queueURL, replyAttrs, string(body)); err != nil {
+		level.Error(w.logger).Log("msg", "sending reply", "err", err)
+	}
+}
+
+// List receives up to limit dead letters. SQS can't read a queue without
+// receiving from it, so each listed message's receive count goes up. The
+// messages are hidden while the list is gathered, so that none is seen
+// twice, and made visible again before List returns. Their IDs are SQS
+// message IDs.
+func (w *sqsWorker) List(ctx context.Context, limit int) ([]deadLetter, error) {
+	msgs, release, err := w.receiveDeadLetters(ctx, limit)
+	defer release()
+	dls := make([]deadLetter, len(msgs))
+	for i, m := range msgs {
+		dls[i] = newDeadLetter(aws.ToString(m.MessageId), sqsAttributeMap(m.MessageAttributes), []byte(aws.ToString(m.Body)))
+	}
+	return dls, err
+}
+
+// maxRedriveScan bounds how many dead letters Redrive receives looking for
+// the one it was asked for.
+const maxRedriveScan = 1000
+
+func (w *sqsWorker) Redrive(ctx context.Context, id string) error {
+	msgs, release, err := w.receiveDeadLetters(ctx, maxRedriveScan)
+	defer release()
+	if err != nil {
+		return err
+	}
+	for i, m := range msgs {
+		if aws.ToString(m.MessageId) != id {
+			continue
+		}
+		dl := newDeadLetter(id, sqsAttributeMap(m.MessageAttributes), []byte(aws.ToString(m.Body)))
+		if err := w.send(ctx, dl.Source, dl.Headers, aws.ToString(m.Body)); err != nil {
+			return err
+		}
+		if err := w.delete(ctx, w.cfg.SQS.DeadLetterQueueURL, m.ReceiptHandle); err != nil {
+			return err
+		}
+		msgs[i].ReceiptHandle = nil // gone; nothing to release
+		return nil
+	}
+	return svcerrors.Errorf(svcerrors.CodeNotFound, "no dead letter %q", id)
+}
+
+// receiveDeadLetters receives up to limit messages from the dead-letter
+// queue, hiding them until release is called.
+func (w *sqsWorker) receiveDeadLetters(ctx context.Context, limit int) (msgs []sqstypes.Message, release func(), err error) {
+	release = func() {
+		for _, m := range msgs {
+			if m.ReceiptHandle != nil {
+				w.setVisibility(context.Background(), w.cfg.SQS.DeadLetterQueueURL, m.ReceiptHandle, 0)
+			}
+		}
+	}
+	for len(msgs) < limit {
+		n := limit - len(msgs)
+		if n > 10 {
+			n = 10
+		}
+		out, err := w.client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:              aws.String(w.cfg.SQS.DeadLetterQueueURL),
+			MaxNumberOfMessages:   int32(n),
+			WaitTimeSeconds:       1,
+			VisibilityTimeout:     int32(w.cfg.SQS.VisibilityTimeout / time.Second),
+			MessageAttributeNames: []string{"All"},
+		})
+		if err != nil {
+			return msgs, release, err
+		}
+		if len(out.Messages) == 0 {
+			break
+		}
+		msgs = append(msgs, out.Messages...)
+	}
+	return msgs, release, nil
+}
+
+func sqsAttributeMap(attrs map[string]sqstypes.MessageAttributeValue) map[string]string {
+	m := make(map[string]string, len(attrs))
+	for k, v := range attrs {
+		if v.StringValue != nil {
+			m[k] = *v.StringValue
+		}
+	}
+	return m
+}
@@ -0,0 +1,72 @@
+package stringsvc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// sseWriter writes server-sent events. Handlers that can stream find it in
+// the request context; the first event commits the response as an event
+// stream, after which errors must be sent as events too.
+type sseWriter struct {
+	mtx     sync.Mutex
+	w       http.ResponseWriter
+	started bool
+}
+
+type sseContextKey struct{}
+
+// sseMiddleware offers streaming to requests that accept text/event-stream.
+func sseMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		sw := &sseWriter{w: w}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), sseContextKey{}, sw)))
+	})
+}
+
+// sseFromContext returns the request's sseWriter, or nil if the client
+// doesn't accept an event stream.
+func sseFromContext(ctx context.Context) *sseWriter {
+	sw, _ := ctx.Value(sseContextKey{}).(*sseWriter)
+	return sw
+}
+
+// Started reports whether an event has been written.
+func (s *sseWriter) Started() bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.started
+}
+
+// Event writes an event named name whose data is v as JSON, and flushes it.
+func (s *sseWriter) Event(name string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if !s.started {
+		h := s.w.Header()
+		h.Set("Content-Type", "text/event-stream")
+		h.Set("Cache-Control", "no-cache")
+		h.Set("X-Accel-Buffering", "no") // keep proxies such as nginx from buffering the stream
+		s.w.WriteHeader(http.StatusOK)
+		s.started = true
+	}
+	if _, err := fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", name, data); err != nil {
+		return err
+	}
+	if f, ok := s.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
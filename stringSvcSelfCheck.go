@@ -0,0 +1,221 @@
+package stringsvc
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/redis/go-redis/v9"
+	"github.com/segmentio/kafka-go"
+
+	"github.com/mcclayac/gokit/migrate"
+)
+
+// Self-checks probe what the service depends on: its databases and stores,
+// the Kafka brokers, the clock. They run once at startup, where their
+// report is logged, and again on every call to /readyz. A failing critical
+// check makes the service not ready; any other failing check makes it
+// degraded, still ready since it has a fallback.
+//
+//	GET /readyz            {"status":"ready"}
+//	GET /readyz?verbose=1  the status with each check's result
+
+// selfCheckTimeout bounds each check.
+const selfCheckTimeout = 2 * time.Second
+
+// selfCheck is a probe of a dependency. It returns a detail worth
+// reporting even when it passes, such as a version.
+type selfCheck struct {
+	name     string
+	critical bool
+	check    func(ctx context.Context) (detail string, err error)
+}
+
+// selfChecks are the registered checks.
+type selfChecks struct {
+	now func() time.Time
+
+	mtx    sync.Mutex
+	checks []selfCheck
+}
+
+func newSelfChecks(now func() time.Time) *selfChecks {
+	return &selfChecks{now: now}
+}
+
+// Register adds a check. A critical check failing makes the service not
+// ready.
+func (s *selfChecks) Register(name string, critical bool, check func(ctx context.Context) (string, error)) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.checks = append(s.checks, selfCheck{name, critical, check})
+}
+
+// readinessReport is the result of running the checks.
+type readinessReport struct {
+	Status  string        `json:"status"` // "ready", "degraded" or "not_ready"
+	Time    time.Time     `json:"time"`
+	Version string        `json:"version"`
+	Checks  []checkResult `json:"checks,omitempty"`
+}
+
+type checkResult struct {
+	Name     string        `json:"name"`
+	OK       bool          `json:"ok"`
+	Critical bool          `json:"critical"`
+	Detail   string        `json:"detail,omitempty"`
+	Err      string        `json:"error,omitempty"`
+	Took     time.Duration `json:"took_ns"`
+}
+
+// Run runs the checks at once, each within selfCheckTimeout, and reports
+// them in the order they were registered.
+func (s *selfChecks) Run(ctx context.Context) readinessReport {
+	s.mtx.Lock()
+	checks := append([]selfCheck(nil), s.checks...)
+	s.mtx.Unlock()
+	report := readinessReport{Status: "ready", Time: s.now(), Version: version, Checks: make([]checkResult, len(checks))}
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c selfCheck) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, selfCheckTimeout)
+			defer cancel()
+			begin := time.Now()
+			detail, err := c.check(ctx)
+			r := checkResult{Name: c.name, OK: err == nil, Critical: c.critical, Detail: detail, Took: time.Since(begin)}
+			if err != nil {
+				r.Err = err.Error()
+			}
+			report.Checks[i] = r
+		}(i, c)
+	}
+	wg.Wait()
+	for _, r := range report.Checks {
+		switch {
+		case r.OK:
+		case r.Critical:
+			report.Status = "not_ready"
+		case report.Status == "ready":
+			report.Status = "degraded"
+		}
+	}
+	return report
+}
+
+// Log logs each check's result and then the status.
+func (r readinessReport) Log(logger log.Logger) {
+	for _, c := range r.Checks {
+		l := level.Info(logger)
+		switch {
+		case !c.OK && c.Critical:
+			l = level.Error(logger)
+		case !c.OK:
+			l = level.Warn(logger)
+		}
+		keyvals := []interface{}{"msg", "self-check", "check", c.Name, "ok", c.OK, "critical", c.Critical, "took", c.Took}
+		if c.Detail != "" {
+			keyvals = append(keyvals, "detail", c.Detail)
+		}
+		if c.Err != "" {
+			keyvals = append(keyvals, "err", c.Err)
+		}
+		l.Log(keyvals...)
+	}
+	l := level.Info(logger)
+	if r.Status != "ready" {
+		l = level.Warn(logger)
+	}
+	l.Log("msg", "readiness", "status", r.Status, "checks", len(r.Checks))
+}
+
+// readyzHandler serves /readyz. It answers 503 when a critical check
+// fails, and lists the checks only with verbose=1, since they name the
+// service's dependencies.
+func readyzHandler(s *selfChecks) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := s.Run(r.Context())
+		code := http.StatusOK
+		if report.Status == "not_ready" {
+			code = http.StatusServiceUnavailable
+		}
+		if v := r.URL.Query().Get("verbose"); v != "1" && v != "true" {
+			report.Checks = nil
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(report)
+	})
+}
+
+// databaseCheck pings db and reports its schema against this build's
+// migrations.
+func databaseCheck(db *sql.DB, dialect migrate.Dialect) func(context.Context) (string, error) {
+	return func(ctx context.Context) (string, error) {
+		if err := db.PingContext(ctx); err != nil {
+			return "", err
+		}
+		v, err := migrate.Version(ctx, db)
+		if err != nil {
+			return "", err
+		}
+		if latest := migrate.Latest(dialect); v < latest {
+			return fmt.Sprintf("schema version %d, behind this build's %d", v, latest), nil
+		}
+		return fmt.Sprintf("schema version %d", v), nil
+	}
+}
+
+// redisCheck pings a Redis server.
+func redisCheck(rdb *redis.Client) func(context.Context) (string, error) {
+	return func(ctx context.Context) (string, error) {
+		return rdb.Options().Addr, rdb.Ping(ctx).Err()
+	}
+}
+
+// kafkaCheck connects to the brokers, and passes if any of them answers
+// with the cluster's brokers.
+func kafkaCheck(brokers []string) func(context.Context) (string, error) {
+	return func(ctx context.Context) (string, error) {
+		var errs []string
+		for _, addr := range brokers {
+			conn, err := kafka.DialContext(ctx, "tcp", addr)
+			if err != nil {
+				errs = append(errs, err.Error())
+				continue
+			}
+			defer conn.Close()
+			cluster, err := conn.Brokers()
+			if err != nil {
+				errs = append(errs, err.Error())
+				continue
+			}
+			return fmt.Sprintf("%d brokers, via %s", len(cluster), addr), nil
+		}
+		return "", fmt.Errorf("no broker reachable: %s", strings.Join(errs, "; "))
+	}
+}
+
+// clockCheck checks that the clock has been set, as it is not on a host
+// that booted without a time source, and reports when it is fixed.
+func clockCheck(now func() time.Time, fixed bool) func(context.Context) (string, error) {
+	return func(context.Context) (string, error) {
+		t := now()
+		if t.Year() < 2020 {
+			return "", fmt.Errorf("clock reads %s; is the host's time synchronized?", t.Format(time.RFC3339))
+		}
+		if fixed {
+			return "fixed at " + t.Format(time.RFC3339), nil
+		}
+		return t.UTC().Format(time.RFC3339), nil
+	}
+}
@@ -0,0 +1,216 @@
+package stringsvc
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	httptransport "github.com/go-kit/kit/transport/http"
+
+	"github.com/mcclayac/gokit/codec"
+)
+
+// Option configures the handlers built by NewHTTPHandler and NewServer.
+type Option func(*serverOptions)
+
+type serverOptions struct {
+	router       Router
+	svc          StringService
+	middlewares  func(method string) endpoint.Middleware
+	wrappers     []func(http.Handler) http.Handler // outermost first
+	errorEncoder httptransport.ErrorEncoder
+	serverOpts   []httptransport.ServerOption
+	env          *appEnv
+	codecs       []extraCodec
+	http2        http2Config
+}
+
+// extraCodec is a wire format added by WithCodec.
+type extraCodec struct {
+	c          codec.Codec
+	mediaTypes []string
+}
+
+// Router is what handlers are registered with. *http.ServeMux is one;
+// others must match patterns as it does, a trailing slash matching the
+// whole subtree, since paths such as /s/ rely on it.
+type Router interface {
+	http.Handler
+	Handle(pattern string, handler http.Handler)
+}
+
+func newServerOptions(opts []Option) *serverOptions {
+	o := &serverOptions{
+		router:       http.NewServeMux(),
+		svc:          stringService{},
+		middlewares:  func(string) endpoint.Middleware { return validatingMiddleware },
+		errorEncoder: encodeError,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.env == nil {
+		o.env = newAppEnv()
+	}
+	for _, c := range o.codecs {
+		o.env.codecs.Register(c.c, c.mediaTypes...)
+	}
+	return o
+}
+
+// WithRouter registers the handlers with r instead of a new ServeMux, so
+// that they can be served alongside others.
+func WithRouter(r Router) Option {
+	return func(o *serverOptions) { o.router = r }
+}
+
+// WithStringService serves svc from NewServer, instead of the plain
+// implementation.
+func WithStringService(svc StringService) Option {
+	return func(o *serverOptions) { o.svc = svc }
+}
+
+// WithMiddlewares wraps each endpoint in the middleware mw returns for its
+// method name. It replaces the default, which only validates requests, so
+// mw should end with validatingMiddleware.
+func WithMiddlewares(mw func(method string) endpoint.Middleware) Option {
+	return func(o *serverOptions) { o.middlewares = mw }
+}
+
+// WithHTTPMiddleware wraps each handler in mws, outermost first, after
+// those of earlier WithHTTPMiddleware options.
+func WithHTTPMiddleware(mws ...func(http.Handler) http.Handler) Option {
+	return func(o *serverOptions) { o.wrappers = append(o.wrappers, mws...) }
+}
+
+// WithErrorEncoder writes failures, both transport and business errors,
+// with enc instead of encodeError.
+func WithErrorEncoder(enc httptransport.ErrorEncoder) Option {
+	return func(o *serverOptions) { o.errorEncoder = enc }
+}
+
+// WithServerOptions passes opts to every go-kit server, such as request
+// functions to run before decoding.
+func WithServerOptions(opts ...httptransport.ServerOption) Option {
+	return func(o *serverOptions) { o.serverOpts = append(o.serverOpts, opts...) }
+}
+
+// WithCodec adds a wire format, negotiated for mediaTypes and the codec's
+// own content type by the handlers built with the option.
+func WithCodec(c codec.Codec, mediaTypes ...string) Option {
+	return func(o *serverOptions) { o.codecs = append(o.codecs, extraCodec{c, mediaTypes}) }
+}
+
+// WithHTTP2 limits the streams of each HTTP/2 connection NewServer
+// accepts, and closes those idle for idleTimeout. Zero leaves the
+// defaults of golang.org/x/net/http2.
+func WithHTTP2(maxConcurrentStreams uint32, idleTimeout time.Duration) Option {
+	return func(o *serverOptions) {
+		o.http2.MaxConcurrentStreams = maxConcurrentStreams
+		o.http2.IdleTimeout = idleTimeout
+	}
+}
+
+// WithH2C has NewServer speak HTTP/2 in cleartext too, with prior
+// knowledge or by upgrade. It is for servers without TLS.
+func WithH2C() Option {
+	return func(o *serverOptions) { o.http2.H2C = true }
+}
+
+// withAppEnv shares e between the handlers built with the option, in
+// place of an appEnv of their own.
+func withAppEnv(e *appEnv) Option {
+	return func(o *serverOptions) { o.env = e }
+}
+
+// server returns the go-kit server for e, which decodes requests with dec.
+func (o *serverOptions) server(e endpoint.Endpoint, dec httptransport.DecodeRequestFunc) *httptransport.Server {
+	opts := append(append([]httptransport.ServerOption(nil), o.serverOpts...), httptransport.ServerErrorEncoder(o.errorEncoder))
+	return httptransport.NewServer(e, dec, o.encodeResponse, opts...)
+}
+
+// encodeResponse is encodeResponse with business errors written by the
+// configured error encoder.
+func (o *serverOptions) encodeResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	if f, ok := response.(endpoint.Failer); ok && f.Failed() != nil {
+		o.errorEncoder(ctx, f.Failed(), w)
+		return nil
+	}
+	return encodeResponse(ctx, w, response)
+}
+
+// handle registers h at path, wrapped in the HTTP middlewares.
+func (o *serverOptions) handle(path string, h http.Handler) {
+	for i := len(o.wrappers) - 1; i >= 0; i-- {
+		h = o.wrappers[i](h)
+	}
+	o.router.Handle(path, h)
+}
+
+// registerStringHandlers registers the string service's endpoints.
+func (o *serverOptions) registerStringHandlers(svc StringService) {
+	o.handle("/uppercase", etagMiddleware(o.server(o.middlewares("uppercase")(makeUppercaseEndpoint(svc)), decodeUppercaseRequest)))
+	o.handle("/count", o.server(o.middlewares("count")(makeCountEndpoint(svc)), decodeCountRequest))
+}
+
+// NewHTTPHandler returns a handler serving svc's endpoints, /uppercase and
+// /count, as configured by opts.
+func NewHTTPHandler(svc StringService, opts ...Option) http.Handler {
+	o := newServerOptions(opts)
+	o.registerStringHandlers(svc)
+	return o.env.handler(o.router)
+}
+
+// NewServer returns a server for addr, serving the string service and
+// anything else registered with the router of opts, over HTTP/1.1 and
+// HTTP/2 as opts configure it.
+func NewServer(addr string, opts ...Option) *http.Server {
+	o := newServerOptions(opts)
+	o.registerStringHandlers(o.svc)
+	srv := &http.Server{Addr: addr, Handler: o.env.handler(o.router)}
+	configureHTTP2(srv, o.http2)
+	return srv
+}
+
+// appEnv is what an App's handlers share that would otherwise be package
+// state, so that Apps built from different flags can run in one process,
+// as they do in tests. NewServer and NewHTTPHandler put it in the context
+// of each request, and the workers in that of each message; code reached
+// without one uses defaultEnv.
+type appEnv struct {
+	codecs   *codec.Registry // the wire formats negotiated
+	proxies  proxyList       // whose X-Forwarded-For is believed
+	features *featureSet     // nil without feature flags
+	reporter errorReporter
+}
+
+func newAppEnv() *appEnv {
+	return &appEnv{codecs: newCodecRegistry(), reporter: nopReporter{}}
+}
+
+// defaultEnv has the default codecs, trusts no proxies, has every feature
+// off and reports no errors. It is never changed.
+var defaultEnv = newAppEnv()
+
+type appEnvKey struct{}
+
+// appEnvFromContext returns the appEnv of ctx, or defaultEnv.
+func appEnvFromContext(ctx context.Context) *appEnv {
+	if e, ok := ctx.Value(appEnvKey{}).(*appEnv); ok {
+		return e
+	}
+	return defaultEnv
+}
+
+// attach returns a copy of ctx carrying e.
+func (e *appEnv) attach(ctx context.Context) context.Context {
+	return context.WithValue(ctx, appEnvKey{}, e)
+}
+
+// handler returns h serving every request with e in its context.
+func (e *appEnv) handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(w, r.WithContext(e.attach(r.Context())))
+	})
+}
@@ -0,0 +1,280 @@
+package stringsvc
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/go-kit/kit/metrics"
+
+	"github.com/mcclayac/gokit/redact"
+)
+
+// Shadowing tries a candidate, a new build or a new codec, on live
+// traffic. With -shadow-url, the service mirrors -shadow-percent of the
+// calls it serves to the candidate once it has answered them, compares
+// the candidate's responses with its own as the replay subcommand does,
+// and records how they differ and how much slower or faster the candidate
+// was:
+//
+//	stringsvc -shadow-url http://candidate:9090 -shadow-percent 10 ...
+//
+// Callers only ever get the service's own responses: mirrored calls are
+// made in the background, by -shadow-workers goroutines, and are dropped
+// when those fall behind. Differences are logged, with the values at the
+// -payload-log-redact paths masked, and counted in shadow_requests by
+// result (match, differ, error, dropped or skipped); shadow_latency_delta
+// is the candidate's latency less the service's, in seconds.
+//
+// Only the calls at -shadow-paths are mirrored, which by default are the
+// operations without side effects, so the candidate never writes to
+// stores it may share with the service. Mirrored calls carry the original
+// headers without the credentials, Authorization and cookies, so the
+// candidate must let them through unauthenticated, as with -auth-public.
+// Calls whose request or response is larger than -shadow-max-bytes are
+// served as usual but not mirrored, which keeps what is held for
+// comparison bounded; streamed responses pass through as they are
+// written either way.
+
+// shadowConfig configures shadowing.
+type shadowConfig struct {
+	URL      string // shadowing is off when empty
+	Percent  float64
+	Workers  int
+	Timeout  time.Duration
+	Ignore   string // comma-separated JSON paths of values not compared
+	Paths    string // comma-separated paths of the calls mirrored
+	MaxBytes int    // of the request and response bodies of a mirrored call
+}
+
+// defaultShadowPaths are the paths mirrored by default: the operations
+// that have no side effects and don't call out to paid providers.
+const defaultShadowPaths = "/uppercase,/count,/math/add,/math/subtract,/math/multiply,/math/divide,/time/format,/time/convert,/detect,/qrcode,/spellcheck"
+
+// shadowStrippedHeaders are the request headers never sent to the
+// candidate.
+var shadowStrippedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie"}
+
+// shadowLatencyBuckets are the buckets of shadow_latency_delta, in seconds
+// either side of the service's latency.
+var shadowLatencyBuckets = []float64{-1, -.25, -.1, -.025, -.005, 0, .005, .025, .1, .25, 1}
+
+// shadowCall is a served call to mirror.
+type shadowCall struct {
+	method  string
+	uri     string
+	header  http.Header
+	body    []byte
+	status  int
+	rheader http.Header
+	rbody   []byte
+	elapsed time.Duration
+}
+
+// shadower mirrors calls to a candidate.
+type shadower struct {
+	target   string
+	percent  float64
+	paths    map[string]bool
+	maxBytes int
+	client   *http.Client
+	compare  *redact.Redactor
+	queue    chan shadowCall
+	done     chan struct{} // closed by Close
+	workers  sync.WaitGroup
+	results  metrics.Counter   // labeled by result
+	delta    metrics.Histogram // seconds
+	logger   log.Logger
+}
+
+// newShadower starts cfg.Workers goroutines mirroring calls, until Close.
+// compare masks the values that aren't compared.
+func newShadower(cfg shadowConfig, compare *redact.Redactor, f metricsFactory, logger log.Logger) *shadower {
+	s := &shadower{
+		target:   strings.TrimSuffix(cfg.URL, "/"),
+		percent:  cfg.Percent,
+		paths:    map[string]bool{},
+		maxBytes: cfg.MaxBytes,
+		client:   &http.Client{Timeout: cfg.Timeout},
+		compare:  compare,
+		queue:    make(chan shadowCall, 16*cfg.Workers),
+		done:     make(chan struct{}),
+		results:  f.Counter("shadow_requests", "Number of calls mirrored to the shadow candidate, by result.", "result"),
+		delta: f.Histogram("shadow_latency_delta", "The shadow candidate's latency less the service's.",
+			shadowLatencyBuckets),
+		logger: logger,
+	}
+	for _, path := range strings.Split(cfg.Paths, ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			s.paths[path] = true
+		}
+	}
+	s.workers.Add(cfg.Workers)
+	for i := 0; i < cfg.Workers; i++ {
+		go s.run()
+	}
+	return s
+}
+
+// Close stops the workers, waiting for the calls they are mirroring.
+// Calls still queued are dropped.
+func (s *shadower) Close() {
+	close(s.done)
+	s.workers.Wait()
+}
+
+// sample reports whether to mirror a call to path.
+func (s *shadower) sample(path string) bool {
+	return s.paths[path] && (s.percent >= 100 || rand.Float64()*100 < s.percent)
+}
+
+// enqueue hands a call to the workers, or drops it if they are behind.
+func (s *shadower) enqueue(c shadowCall) {
+	select {
+	case s.queue <- c:
+	default:
+		s.results.With("result", "dropped").Add(1)
+	}
+}
+
+func (s *shadower) run() {
+	defer s.workers.Done()
+	for {
+		select {
+		case c := <-s.queue:
+			s.mirror(c)
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// mirror sends c's request to the candidate and records the outcome.
+func (s *shadower) mirror(c shadowCall) {
+	logger := log.With(s.logger, "method", c.method, "path", strings.SplitN(c.uri, "?", 2)[0])
+	req, err := http.NewRequest(c.method, s.target+c.uri, bytes.NewReader(c.body))
+	if err != nil {
+		s.results.With("result", "error").Add(1)
+		level.Warn(logger).Log("msg", "mirroring call to shadow", "err", err)
+		return
+	}
+	for k, v := range c.header {
+		req.Header[k] = v
+	}
+	for _, k := range shadowStrippedHeaders {
+		req.Header.Del(k)
+	}
+	begin := time.Now()
+	resp, err := s.client.Do(req)
+	if err != nil {
+		s.results.With("result", "error").Add(1)
+		level.Warn(logger).Log("msg", "mirroring call to shadow", "err", err)
+		return
+	}
+	defer resp.Body.Close()
+	got, err := io.ReadAll(io.LimitReader(resp.Body, int64(s.maxBytes)+1))
+	elapsed := time.Since(begin)
+	if err != nil {
+		s.results.With("result", "error").Add(1)
+		level.Warn(logger).Log("msg", "reading shadow response", "err", err)
+		return
+	}
+	s.delta.Observe((elapsed - c.elapsed).Seconds())
+	if diff := diffResponses(resp.StatusCode, resp.Header, got, c.status, c.rheader, c.rbody, s.compare); diff != "" {
+		s.results.With("result", "differ").Add(1)
+		level.Warn(logger).Log("msg", "shadow response differs", "diff", diff, "took", elapsed, "primary_took", c.elapsed)
+		return
+	}
+	s.results.With("result", "match").Add(1)
+}
+
+// shadowingMiddleware mirrors a sample of the calls to next.
+func shadowingMiddleware(s *shadower) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !s.sample(r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			body, err := io.ReadAll(io.LimitReader(r.Body, int64(s.maxBytes)+1))
+			if err != nil {
+				encodeError(r.Context(), err, w)
+				return
+			}
+			if len(body) > s.maxBytes {
+				s.results.With("result", "skipped").Add(1)
+				r.Body = readCloser{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+				next.ServeHTTP(w, r)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			begin := time.Now()
+
+			rw := &shadowRecorder{ResponseWriter: w, status: http.StatusOK, max: s.maxBytes}
+			next.ServeHTTP(rw, r)
+			if rw.overflow {
+				s.results.With("result", "skipped").Add(1)
+				return
+			}
+
+			s.enqueue(shadowCall{
+				method:  r.Method,
+				uri:     r.URL.RequestURI(),
+				header:  r.Header.Clone(),
+				body:    body,
+				status:  rw.status,
+				rheader: w.Header().Clone(),
+				rbody:   rw.body.Bytes(),
+				elapsed: time.Since(begin),
+			})
+		})
+	}
+}
+
+// readCloser reads from one reader and closes another, for a body that has
+// been partly read into memory.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// shadowRecorder passes a response through while keeping a copy of its
+// status and up to max bytes of its body, giving up on the copy once the
+// body is larger.
+type shadowRecorder struct {
+	http.ResponseWriter
+	status   int
+	max      int
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (r *shadowRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *shadowRecorder) Write(b []byte) (int, error) {
+	if !r.overflow {
+		if r.body.Len()+len(b) > r.max {
+			r.overflow = true
+			r.body = bytes.Buffer{}
+		} else {
+			r.body.Write(b)
+		}
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher, so that streamed responses pass through.
+func (r *shadowRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
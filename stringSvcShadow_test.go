@@ -0,0 +1,58 @@
+package stringsvc
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestShadowSample(t *testing.T) {
+	for _, percent := range []float64{0, 25, 100} {
+		s := &shadower{percent: percent, paths: map[string]bool{"/uppercase": true}}
+		sampled := 0
+		for i := 0; i < 10000; i++ {
+			if s.sample("/uppercase") {
+				sampled++
+			}
+			if s.sample("/shorten") {
+				t.Fatalf("at %v%%: sampled a path that isn't mirrored", percent)
+			}
+		}
+		if want := int(percent * 100); sampled < want-300 || sampled > want+300 {
+			t.Errorf("at %v%%: sampled %d of 10000 calls, want about %d", percent, sampled, want)
+		}
+	}
+}
+
+func TestShadowingLeavesResponses(t *testing.T) {
+	mirrored := make(chan *http.Request, 10)
+	candidate := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mirrored <- r
+		http.Error(w, "candidate is broken", http.StatusInternalServerError)
+	}))
+	defer candidate.Close()
+
+	plain := newTestServer(t)
+	shadowed := newTestServer(t, "-shadow-url", candidate.URL, "-shadow-percent", "100", "-shadow-paths", "/uppercase", "-shadow-workers", "1")
+	header := http.Header{"Cookie": {"session=secret"}}
+	for _, path := range []string{"/count?s=hello", "/uppercase?s=hello"} {
+		want, wantBody := callTestServer(t, plain, "GET", path, header, "")
+		got, gotBody := callTestServer(t, shadowed, "GET", path, header, "")
+		if got.StatusCode != want.StatusCode || string(gotBody) != string(wantBody) {
+			t.Errorf("GET %s shadowed: got %d %s, want %d %s", path, got.StatusCode, gotBody, want.StatusCode, wantBody)
+		}
+	}
+
+	select {
+	case r := <-mirrored:
+		if r.URL.RequestURI() != "/uppercase?s=hello" {
+			t.Errorf("mirrored %s, want only /uppercase?s=hello", r.URL.RequestURI())
+		}
+		if r.Header.Get("Cookie") != "" {
+			t.Errorf("mirrored the caller's cookie %q", r.Header.Get("Cookie"))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the call to /uppercase wasn't mirrored")
+	}
+}
@@ -0,0 +1,353 @@
+package stringsvc
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/metrics"
+
+	"github.com/mcclayac/gokit/svcerrors"
+)
+
+// ShortenerService maps long URLs to short codes.
+type ShortenerService interface {
+	// Shorten stores url under a new code. The link expires after ttl, or
+	// the configured default when ttl is zero.
+	Shorten(ctx context.Context, url string, ttl time.Duration) (shortLink, error)
+	// Resolve returns the link stored under code and counts a hit on it.
+	Resolve(ctx context.Context, code string) (shortLink, error)
+}
+
+// shortLink is a stored URL.
+type shortLink struct {
+	Code    string    `json:"code" xml:"code"`
+	URL     string    `json:"url" xml:"url"`
+	Created time.Time `json:"created" xml:"created"`
+	Expires time.Time `json:"expires,omitempty" xml:"expires,omitempty"` // zero when the link never expires
+	Hits    int64     `json:"hits" xml:"hits"`
+}
+
+func (l shortLink) expired(now time.Time) bool {
+	return !l.Expires.IsZero() && !now.Before(l.Expires)
+}
+
+// errCodeTaken is returned by shortenerStore.Create when the code is in use.
+var errCodeTaken = errors.New("short code is taken")
+
+// shortenerStore holds links by code. Implementations must be safe for
+// concurrent use.
+type shortenerStore interface {
+	// Create stores link with its hits, failing with errCodeTaken if its
+	// code belongs to a link that hasn't expired.
+	Create(ctx context.Context, link shortLink) error
+	// Hit counts a hit on the link stored under code and returns it,
+	// failing with NOT_FOUND if there is none or it has expired.
+	Hit(ctx context.Context, code string, now time.Time) (shortLink, error)
+	// Export calls fn with each link that hasn't expired by now, stopping
+	// at the first error.
+	Export(ctx context.Context, now time.Time, fn func(shortLink) error) error
+}
+
+const (
+	// shortCodeLen base62 characters give about 3.5e12 codes.
+	shortCodeLen      = 7
+	shortCodeAlphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	// shortCodeAttempts is how many random codes Shorten tries before giving
+	// up, each having collided with a live link.
+	shortCodeAttempts = 5
+)
+
+// shortenerConfig configures the ShortenerService.
+type shortenerConfig struct {
+	DefaultTTL time.Duration // links never expire by default when zero
+	MaxTTL     time.Duration // unlimited when zero
+}
+
+// shortenerService is a concrete implementation of ShortenerService.
+type shortenerService struct {
+	cfg   shortenerConfig
+	store shortenerStore
+	now   func() time.Time
+	// newCode returns a candidate code for a new link; taken codes are
+	// retried with another.
+	newCode func() (string, error)
+	// created counts links; resolved counts Resolve calls by result, "hit"
+	// or "miss". Hits per link are kept in the store.
+	created  metrics.Counter
+	resolved metrics.Counter
+}
+
+func (s shortenerService) Shorten(ctx context.Context, rawurl string, ttl time.Duration) (shortLink, error) {
+	if err := ctx.Err(); err != nil {
+		return shortLink{}, err
+	}
+	u, err := url.Parse(rawurl)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return shortLink{}, svcerrors.Errorf(svcerrors.CodeInvalidArgument, "%q is not an absolute http or https URL", rawurl)
+	}
+	if ttl == 0 {
+		ttl = s.cfg.DefaultTTL
+	}
+	if ttl < 0 || (s.cfg.MaxTTL > 0 && (ttl == 0 || ttl > s.cfg.MaxTTL)) {
+		return shortLink{}, svcerrors.Errorf(svcerrors.CodeInvalidArgument, "ttl must be positive and at most %v", s.cfg.MaxTTL)
+	}
+	now := s.now().UTC()
+	link := shortLink{URL: u.String(), Created: now}
+	if ttl > 0 {
+		link.Expires = now.Add(ttl)
+	}
+	for i := 0; i < shortCodeAttempts; i++ {
+		if link.Code, err = s.newCode(); err != nil {
+			return shortLink{}, err
+		}
+		err = s.store.Create(ctx, link)
+		if err != errCodeTaken {
+			break
+		}
+	}
+	if err != nil {
+		return shortLink{}, err
+	}
+	s.created.Add(1)
+	return link, nil
+}
+
+func (s shortenerService) Resolve(ctx context.Context, code string) (shortLink, error) {
+	if err := ctx.Err(); err != nil {
+		return shortLink{}, err
+	}
+	link, err := s.store.Hit(ctx, code, s.now())
+	result := "hit"
+	if err != nil {
+		result = "miss"
+	}
+	s.resolved.With("result", result).Add(1)
+	return link, err
+}
+
+func newShortCode() (string, error) {
+	b := make([]byte, shortCodeLen)
+	max := big.NewInt(int64(len(shortCodeAlphabet)))
+	for i := range b {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b[i] = shortCodeAlphabet[n.Int64()]
+	}
+	return string(b), nil
+}
+
+func errNoLink(code string) error {
+	return svcerrors.Errorf(svcerrors.CodeNotFound, "no link %q", code)
+}
+
+// memoryShortenerStore is an in-process shortenerStore. Expired links are
+// swept lazily as new links arrive.
+type memoryShortenerStore struct {
+	mtx       sync.Mutex
+	links     map[string]shortLink
+	lastSweep time.Time
+}
+
+func newMemoryShortenerStore() *memoryShortenerStore {
+	return &memoryShortenerStore{links: map[string]shortLink{}}
+}
+
+func (s *memoryShortenerStore) Create(_ context.Context, link shortLink) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.sweep(link.Created)
+	if l, ok := s.links[link.Code]; ok && !l.expired(link.Created) {
+		return errCodeTaken
+	}
+	s.links[link.Code] = link
+	return nil
+}
+
+func (s *memoryShortenerStore) Hit(_ context.Context, code string, now time.Time) (shortLink, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	l, ok := s.links[code]
+	if !ok || l.expired(now) {
+		return shortLink{}, errNoLink(code)
+	}
+	l.Hits++
+	s.links[code] = l
+	return l, nil
+}
+
+func (s *memoryShortenerStore) Export(_ context.Context, now time.Time, fn func(shortLink) error) error {
+	s.mtx.Lock()
+	links := make([]shortLink, 0, len(s.links))
+	for _, l := range s.links {
+		if !l.expired(now) {
+			links = append(links, l)
+		}
+	}
+	s.mtx.Unlock()
+	for _, l := range links {
+		if err := fn(l); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sweep drops expired links, at most once a minute.
+func (s *memoryShortenerStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < time.Minute {
+		return
+	}
+	s.lastSweep = now
+	for code, l := range s.links {
+		if l.expired(now) {
+			delete(s.links, code)
+		}
+	}
+}
+
+// postgresShortenerStore keeps links in PostgreSQL. Expired links stay in
+// the table until their code is reused.
+type postgresShortenerStore struct {
+	db *sql.DB
+}
+
+func (s *postgresShortenerStore) Create(ctx context.Context, link shortLink) error {
+	res, err := s.db.ExecContext(ctx, `
+		INSERT INTO short_links (code, url, created_at, expires_at, hits) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (code) DO UPDATE SET url = $2, created_at = $3, expires_at = $4, hits = $5
+		WHERE short_links.expires_at <= $3`,
+		link.Code, link.URL, link.Created, nullTime(link.Expires), link.Hits)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return errCodeTaken
+	}
+	return nil
+}
+
+func (s *postgresShortenerStore) Hit(ctx context.Context, code string, now time.Time) (shortLink, error) {
+	l := shortLink{Code: code}
+	var expires sql.NullTime
+	err := s.db.QueryRowContext(ctx, `
+		UPDATE short_links SET hits = hits + 1
+		WHERE code = $1 AND (expires_at IS NULL OR expires_at > $2)
+		RETURNING url, created_at, expires_at, hits`,
+		code, now).Scan(&l.URL, &l.Created, &expires, &l.Hits)
+	if err == sql.ErrNoRows {
+		return shortLink{}, errNoLink(code)
+	}
+	l.Expires = expires.Time
+	return l, err
+}
+
+func (s *postgresShortenerStore) Export(ctx context.Context, now time.Time, fn func(shortLink) error) error {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT code, url, created_at, expires_at, hits FROM short_links
+		WHERE expires_at IS NULL OR expires_at > $1`, now)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			l       shortLink
+			expires sql.NullTime
+		)
+		if err := rows.Scan(&l.Code, &l.URL, &l.Created, &expires, &l.Hits); err != nil {
+			return err
+		}
+		l.Expires = expires.Time
+		if err := fn(l); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+// For each method, we define request and response structs.
+type shortenRequest struct {
+	URL string `json:"url" xml:"url" validate:"required,max=2048"`
+	TTL int64  `json:"ttl_seconds" xml:"ttl_seconds" validate:"min=0"`
+}
+
+type resolveRequest struct {
+	Code string `json:"code" xml:"code" validate:"required,max=64"`
+}
+
+// linkResponse is the response of every ShortenerService method.
+type linkResponse struct {
+	Link shortLink `json:"link" xml:"link"`
+	Err  error     `json:"-" xml:"-"`
+}
+
+// Failed implements endpoint.Failer.
+func (r linkResponse) Failed() error { return r.Err }
+
+func makeShortenEndpoint(svc ShortenerService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(shortenRequest)
+		l, err := svc.Shorten(ctx, req.URL, time.Duration(req.TTL)*time.Second)
+		return linkResponse{l, err}, nil
+	}
+}
+
+func makeResolveEndpoint(svc ShortenerService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(resolveRequest)
+		l, err := svc.Resolve(ctx, req.Code)
+		return linkResponse{l, err}, nil
+	}
+}
+
+func decodeShortenRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request shortenRequest
+	if err := decodeBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+func decodeResolveRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request resolveRequest
+	if err := decodeBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+// decodeRedirectRequest reads the code from the path /s/{code}.
+func decodeRedirectRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return resolveRequest{Code: strings.TrimPrefix(r.URL.Path, "/s/")}, nil
+}
+
+// encodeRedirect redirects to the resolved link. Links may expire or be
+// reused, so the redirect is temporary.
+func encodeRedirect(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	resp := response.(linkResponse)
+	if resp.Err != nil {
+		encodeError(ctx, resp.Err, w)
+		return nil
+	}
+	w.Header().Set("Location", resp.Link.URL)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusFound)
+	return nil
+}
@@ -0,0 +1,76 @@
+package stringsvc
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/mcclayac/gokit/kit"
+)
+
+// slowRequestMiddleware logs a warning for every call that takes longer than
+// threshold, with enough detail to find it again: the caller, request and
+// trace IDs, and the size of the request body.
+func slowRequestMiddleware(logger log.Logger, threshold time.Duration, method string) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+			defer func(begin time.Time) {
+				took := time.Since(begin)
+				if took <= threshold {
+					return
+				}
+				keyvals := []interface{}{
+					"msg", "slow request",
+					"method", method,
+					"took", took,
+					"threshold", threshold,
+					"code", kit.OutcomeCode(response, err),
+					"identity", identity(ctx),
+					"request_id", requestID(ctx),
+					"request_bytes", requestBytes(ctx),
+				}
+				if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+					keyvals = append(keyvals, "trace_id", sc.TraceID().String())
+				}
+				level.Warn(logger).Log(keyvals...)
+			}(time.Now())
+			return next(ctx, request)
+		}
+	}
+}
+
+type requestBytesKey struct{}
+
+// countRequestBytes is a go-kit http transport RequestFunc that counts the
+// bytes the request decoder reads from the body, for requestBytes.
+func countRequestBytes(ctx context.Context, r *http.Request) context.Context {
+	c := &countingReadCloser{ReadCloser: r.Body}
+	r.Body = c
+	return context.WithValue(ctx, requestBytesKey{}, c)
+}
+
+// requestBytes returns the number of request body bytes read so far, or -1
+// if they weren't counted.
+func requestBytes(ctx context.Context) int64 {
+	if c, ok := ctx.Value(requestBytesKey{}).(*countingReadCloser); ok {
+		return c.n
+	}
+	return -1
+}
+
+type countingReadCloser struct {
+	io.ReadCloser
+	n int64
+}
+
+func (c *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
+}
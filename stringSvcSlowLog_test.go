@@ -0,0 +1,30 @@
+package stringsvc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mcclayac/gokit/testutil"
+)
+
+func TestSlowRequestMiddleware(t *testing.T) {
+	slow := func(context.Context, interface{}) (interface{}, error) {
+		time.Sleep(time.Millisecond)
+		return countResponse{V: 5}, nil
+	}
+	req := countRequest{S: "hello"}
+
+	logs := &testutil.Logs{}
+	if _, err := slowRequestMiddleware(logs, 0, "count")(slow)(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	logs.ExpectLog(t, "level", "warn", "msg", "slow request", "method", "count", "code", "OK")
+	logs.ExpectLogField(t, "threshold", time.Duration(0))
+
+	logs.Reset()
+	if _, err := slowRequestMiddleware(logs, time.Hour, "count")(slow)(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	logs.ExpectNoLog(t, "msg", "slow request")
+}
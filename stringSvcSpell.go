@@ -0,0 +1,162 @@
+package stringsvc
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+
+	"github.com/mcclayac/gokit/spell"
+)
+
+// SpellService finds misspelled words.
+type SpellService interface {
+	// Check returns the words in text that are not in the dictionary, with
+	// up to maxSuggestions suggested corrections each.
+	Check(ctx context.Context, text string, maxSuggestions int) ([]misspelling, error)
+}
+
+// misspelling is a word missing from the dictionary.
+type misspelling struct {
+	Word        string   `json:"word" xml:"word"`
+	Offset      int      `json:"offset" xml:"offset"` // in bytes
+	Suggestions []string `json:"suggestions" xml:"suggestions>suggestion"`
+}
+
+// spellConfig configures the SpellService.
+type spellConfig struct {
+	Dictionary     string        // word list or hunspell .dic file; spell checking is off when empty
+	ReloadInterval time.Duration // how often the file is checked for changes; never when zero
+}
+
+// spellService is a concrete implementation of SpellService. Its dictionary
+// can be replaced while it serves.
+type spellService struct {
+	dict atomic.Value // *spell.Dictionary
+}
+
+func (s *spellService) Check(ctx context.Context, text string, maxSuggestions int) ([]misspelling, error) {
+	dict := s.dict.Load().(*spell.Dictionary)
+	misspellings := []misspelling{}
+	for _, t := range spell.Tokenize(text) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if known(dict, t.Word) {
+			continue
+		}
+		misspellings = append(misspellings, misspelling{t.Word, t.Offset, dict.Suggest(t.Word, maxSuggestions)})
+	}
+	return misspellings, nil
+}
+
+// known reports whether word is in dict, either whole or, if it is
+// hyphenated, part by part.
+func known(dict *spell.Dictionary, word string) bool {
+	if dict.Contains(word) {
+		return true
+	}
+	parts := strings.Split(word, "-")
+	if len(parts) == 1 {
+		return false
+	}
+	for _, p := range parts {
+		if !dict.Contains(p) {
+			return false
+		}
+	}
+	return true
+}
+
+// loadDictionary reads the dictionary file at path.
+func loadDictionary(path string) (*spell.Dictionary, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return spell.Load(f)
+}
+
+// newSpellService loads the dictionary in cfg and, if cfg.ReloadInterval is
+// set, reloads it whenever the file's modification time changes. A
+// dictionary that fails to reload is logged and the old one kept.
+func newSpellService(cfg spellConfig, logger log.Logger) (*spellService, error) {
+	info, err := os.Stat(cfg.Dictionary)
+	if err != nil {
+		return nil, err
+	}
+	dict, err := loadDictionary(cfg.Dictionary)
+	if err != nil {
+		return nil, err
+	}
+	s := &spellService{}
+	s.dict.Store(dict)
+	if cfg.ReloadInterval > 0 {
+		go s.watch(cfg, info.ModTime(), logger)
+	}
+	return s, nil
+}
+
+func (s *spellService) watch(cfg spellConfig, loaded time.Time, logger log.Logger) {
+	for range time.Tick(cfg.ReloadInterval) {
+		info, err := os.Stat(cfg.Dictionary)
+		if err != nil {
+			level.Error(logger).Log("msg", "checking dictionary", "err", err)
+			continue
+		}
+		if info.ModTime().Equal(loaded) {
+			continue
+		}
+		dict, err := loadDictionary(cfg.Dictionary)
+		if err != nil {
+			level.Error(logger).Log("msg", "reloading dictionary", "err", err)
+			continue
+		}
+		loaded = info.ModTime()
+		s.dict.Store(dict)
+		level.Info(logger).Log("msg", "reloaded dictionary", "words", dict.Len())
+	}
+}
+
+// defaultSuggestions is the number of suggestions returned for each word
+// when the request doesn't say.
+const defaultSuggestions = 5
+
+type spellcheckRequest struct {
+	Text           string `json:"text" xml:"text" validate:"required,max=65536"`
+	MaxSuggestions int    `json:"max_suggestions" xml:"max_suggestions" validate:"min=0,max=20"`
+}
+
+type spellcheckResponse struct {
+	Misspellings []misspelling `json:"misspellings" xml:"misspellings>misspelling"`
+	Err          error         `json:"-" xml:"-"`
+}
+
+// Failed implements endpoint.Failer.
+func (r spellcheckResponse) Failed() error { return r.Err }
+
+func makeSpellcheckEndpoint(svc SpellService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(spellcheckRequest)
+		if req.MaxSuggestions == 0 {
+			req.MaxSuggestions = defaultSuggestions
+		}
+		v, err := svc.Check(ctx, req.Text, req.MaxSuggestions)
+		return spellcheckResponse{v, err}, nil
+	}
+}
+
+func decodeSpellcheckRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request spellcheckRequest
+	if err := decodeBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
@@ -0,0 +1,111 @@
+package stringsvc
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// storageConfig chooses where short links, history, jobs, issued tokens,
+// API keys, tenants' overrides and the responses kept for idempotency keys
+// are kept.
+type storageConfig struct {
+	// Backend is "memory", "sql" or "redis". When empty, the stores use
+	// the SQL database if one is open and memory otherwise.
+	Backend     string
+	RedisAddr   string
+	RedisPrefix string
+}
+
+// memoryHistoryCapacity is how many records the in-memory history keeps.
+const memoryHistoryCapacity = 100000
+
+// storage is the set of stores the service keeps its state in.
+type storage struct {
+	backend string
+	links   shortenerStore
+	history historyStore
+	jobs    jobStore
+	tokens  tokenStore
+	keys    keyStore
+	// tenantConfigs are the tenants' overrides.
+	tenantConfigs tenantConfigStore
+	// idempotency keeps responses for replay: in Redis with the redis
+	// backend, and in memory otherwise.
+	idempotency idempotencyStore
+	// rdb is the Redis client the stores share, if they use Redis.
+	rdb *redis.Client
+}
+
+// openStorage builds the stores for cfg over the open databases: pg,
+// lite or neither. Stores that expire entries themselves tell the time
+// with now.
+func openStorage(ctx context.Context, cfg storageConfig, pg, lite *sql.DB, now func() time.Time) (*storage, error) {
+	s := &storage{backend: cfg.Backend}
+	if s.backend == "" {
+		s.backend = "memory"
+		if pg != nil || lite != nil {
+			s.backend = "sql"
+		}
+	}
+	switch s.backend {
+	case "memory":
+		s.links = newMemoryShortenerStore()
+		s.history = newMemoryHistoryStore(memoryHistoryCapacity)
+		s.jobs = newMemoryJobStore(now)
+		s.tokens = newMemoryTokenStore()
+		s.keys = newMemoryKeyStore()
+		s.tenantConfigs = newMemoryTenantConfigStore()
+	case "sql":
+		switch {
+		case pg != nil:
+			s.links = &postgresShortenerStore{pg}
+			s.history = &postgresHistoryStore{db: pg}
+			s.jobs = newPostgresJobStore(pg, now)
+			s.tokens = newPostgresTokenStore(pg)
+			s.keys = newPostgresKeyStore(pg)
+			s.tenantConfigs = newPostgresTenantConfigStore(pg)
+		case lite != nil:
+			s.links = &sqliteShortenerStore{lite}
+			s.history = &sqliteHistoryStore{lite}
+			s.jobs = newSQLiteJobStore(lite, now)
+			s.tokens = newSQLiteTokenStore(lite)
+			s.keys = newSQLiteKeyStore(lite)
+			s.tenantConfigs = newSQLiteTenantConfigStore(lite)
+		default:
+			return nil, errors.New("-storage sql needs -postgres-dsn or -sqlite-path")
+		}
+	case "redis":
+		s.rdb = redis.NewClient(&redis.Options{Addr: cfg.RedisAddr})
+		if err := s.rdb.Ping(ctx).Err(); err != nil {
+			s.rdb.Close()
+			return nil, fmt.Errorf("connecting to Redis: %v", err)
+		}
+		s.links = &redisShortenerStore{s.rdb, cfg.RedisPrefix}
+		s.history = &redisHistoryStore{s.rdb, cfg.RedisPrefix}
+		s.jobs = &redisJobStore{s.rdb, cfg.RedisPrefix}
+		s.tokens = &redisTokenStore{s.rdb, cfg.RedisPrefix}
+		s.keys = &redisKeyStore{s.rdb, cfg.RedisPrefix}
+		s.tenantConfigs = &redisTenantConfigStore{s.rdb, cfg.RedisPrefix}
+		s.idempotency = &redisIdempotencyStore{s.rdb, cfg.RedisPrefix}
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", s.backend)
+	}
+	if s.idempotency == nil {
+		s.idempotency = newMemoryIdempotencyStore(now)
+	}
+	return s, nil
+}
+
+// Close closes the Redis client, if there is one. The SQL databases belong
+// to the caller.
+func (s *storage) Close() error {
+	if s.rdb == nil {
+		return nil
+	}
+	return s.rdb.Close()
+}
@@ -0,0 +1,80 @@
+package stringsvc
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/coreos/go-systemd/v22/activation"
+	"github.com/coreos/go-systemd/v22/daemon"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// Under systemd, the service takes its listening socket from a socket
+// unit when it has one, and reports to the service manager: ready once it
+// serves, stopping when it stops, and, when the unit sets WatchdogSec,
+// alive every half period while its critical self-checks pass, so that
+// systemd restarts it when they don't. Outside systemd all of this does
+// nothing. -graceful-upgrades hands sockets over itself and leaves
+// systemd out; run it as Type=simple with -pid-file.
+//
+//	[Service]
+//	Type=notify
+//	WatchdogSec=30s
+
+// systemdListener returns the listener systemd passed by socket
+// activation, or nil if there isn't one.
+func systemdListener() (net.Listener, error) {
+	lns, err := activation.Listeners()
+	if err != nil {
+		return nil, err
+	}
+	switch len(lns) {
+	case 0:
+		return nil, nil
+	case 1:
+		if lns[0] == nil {
+			return nil, fmt.Errorf("the socket systemd passed is not a stream socket")
+		}
+		return lns[0], nil
+	}
+	return nil, fmt.Errorf("systemd passed %d sockets; the service listens on one", len(lns))
+}
+
+// notifySystemd sends state, such as daemon.SdNotifyReady, to the service
+// manager, if there is one.
+func notifySystemd(state string, logger log.Logger) {
+	if _, err := daemon.SdNotify(false, state); err != nil {
+		level.Warn(logger).Log("msg", "notifying systemd", "state", state, "err", err)
+	}
+}
+
+// runWatchdog tells systemd the service is alive every half watchdog
+// period while the critical checks pass, until ctx is done. It returns at
+// once if the watchdog is off.
+func runWatchdog(ctx context.Context, checks *selfChecks, logger log.Logger) {
+	interval, err := daemon.SdWatchdogEnabled(false)
+	if err != nil {
+		level.Warn(logger).Log("msg", "reading systemd watchdog settings", "err", err)
+		return
+	}
+	if interval == 0 {
+		return
+	}
+	t := time.NewTicker(interval / 2)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		if report := checks.Run(ctx); report.Status == "not_ready" {
+			level.Warn(logger).Log("msg", "not ready; withholding systemd watchdog heartbeat")
+			continue
+		}
+		notifySystemd(daemon.SdNotifyWatchdog, logger)
+	}
+}
@@ -0,0 +1,206 @@
+package stringsvc
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/mcclayac/gokit/svcerrors"
+)
+
+// With -tenants-file, one deployment serves several business units, each a
+// tenant. Every request is resolved to the tenant of the client its bearer
+// token was issued to. It may also name the tenant, with the X-Tenant-ID
+// header (-tenant-header) or its host's subdomain of -tenant-domain, so
+// that payments.strings.internal is the payments tenant; as the caller
+// chooses both, a request naming a tenant its token doesn't act for is
+// refused, unless it names the -tenant-default tenant. A request without a
+// token's tenant is the -tenant-default tenant's, or refused if there
+// isn't one. The admin and token endpoints
+// serve operators and clients, not tenants, and are resolved to none.
+//
+// The tenant scopes what a request touches: it is logged with each call and
+// labels the request metrics, short links live in a space of their own per
+// tenant, a tenant's feature flag settings override the global ones, and
+// its quotas (see stringSvcQuota.go) limit its use. Operators can also
+// override some settings per tenant at run time (see
+// stringSvcTenantConfig.go). The tenants file lists them:
+//
+//	tenants:
+//	  - id: payments
+//	    name: Payments
+//	    features: {count_runes: true}
+//	  - id: search
+//	    name: Search
+//
+// Auth clients name the tenant their tokens act for with "tenant".
+
+// tenancyConfig configures multi-tenancy, which is off when File is empty.
+type tenancyConfig struct {
+	File    string
+	Header  string
+	Domain  string // tenants aren't resolved from the host when empty
+	Default string // requests naming no tenant are refused when empty
+}
+
+// tenantsFile is the tenants file's contents.
+type tenantsFile struct {
+	Tenants []tenant `json:"tenants"`
+}
+
+// tenant is a business unit served by the deployment.
+type tenant struct {
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+	// Features overrides the feature flags for the tenant's callers.
+	Features map[string]bool `json:"features,omitempty"`
+	// Quotas limits the tenant's use by the day and month.
+	Quotas tenantQuotas `json:"quotas,omitempty"`
+}
+
+// tenantIDPattern keeps tenant IDs usable as DNS labels, metric label
+// values and key prefixes.
+var tenantIDPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// tenantSet holds the tenants and resolves requests to them.
+type tenantSet struct {
+	cfg  tenancyConfig
+	byID map[string]tenant
+}
+
+// loadTenants reads the tenants file of cfg.
+func loadTenants(cfg tenancyConfig) (*tenantSet, error) {
+	var f tenantsFile
+	if err := readSettingsFile(cfg.File, &f); err != nil {
+		return nil, err
+	}
+	s := &tenantSet{cfg: cfg, byID: make(map[string]tenant, len(f.Tenants))}
+	for _, t := range f.Tenants {
+		if !tenantIDPattern.MatchString(t.ID) {
+			return nil, fmt.Errorf("%s: tenant ID %q must be lowercase letters, digits and inner hyphens", cfg.File, t.ID)
+		}
+		if err := t.Quotas.check(); err != nil {
+			return nil, fmt.Errorf("%s: tenant %q: %v", cfg.File, t.ID, err)
+		}
+		if _, ok := s.byID[t.ID]; ok {
+			return nil, fmt.Errorf("%s: tenant %q is listed twice", cfg.File, t.ID)
+		}
+		s.byID[t.ID] = t
+	}
+	if _, ok := s.byID[cfg.Default]; cfg.Default != "" && !ok {
+		return nil, fmt.Errorf("%s: the default tenant %q isn't listed", cfg.File, cfg.Default)
+	}
+	return s, nil
+}
+
+// resolve returns the tenant of r, whose bearer token belongs to the
+// tenant claim, if not empty.
+func (s *tenantSet) resolve(r *http.Request, claim string) (tenant, error) {
+	named := r.Header.Get(s.cfg.Header)
+	if named == "" && s.cfg.Domain != "" {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if sub := strings.TrimSuffix(strings.ToLower(host), "."+s.cfg.Domain); sub != host && !strings.Contains(sub, ".") {
+			named = sub
+		}
+	}
+	switch {
+	case named == "" || named == claim:
+	case claim != "":
+		return tenant{}, svcerrors.Errorf(svcerrors.CodePermissionDenied, "the token acts for tenant %q, not %q", claim, named)
+	case named != s.cfg.Default:
+		return tenant{}, svcerrors.Errorf(svcerrors.CodeUnauthenticated, "acting for tenant %q needs a bearer token issued for it", named)
+	}
+	id := claim
+	if id == "" {
+		id = s.cfg.Default
+	}
+	if id == "" {
+		return tenant{}, svcerrors.New(svcerrors.CodeUnauthenticated, "the request has no tenant; send a bearer token issued for one")
+	}
+	t, ok := s.byID[id]
+	if !ok {
+		return tenant{}, svcerrors.Errorf(svcerrors.CodeInvalidArgument, "unknown tenant %q", id)
+	}
+	return t, nil
+}
+
+type tenantKey struct{}
+
+// tenantFromContext returns the tenant of the request, if tenancy is on.
+func tenantFromContext(ctx context.Context) (tenant, bool) {
+	t, ok := ctx.Value(tenantKey{}).(tenant)
+	return t, ok
+}
+
+// tenantLabel is the tenant's ID as a metrics label, "none" without one.
+func tenantLabel(ctx context.Context) string {
+	if t, ok := tenantFromContext(ctx); ok {
+		return t.ID
+	}
+	return "none"
+}
+
+// tenantMiddleware resolves each request's tenant and puts it in the
+// context. The tenant claimed is that of the principal
+// authenticatingMiddleware found; a token that doesn't authenticate claims
+// none, and is refused later by authMiddleware.
+func tenantMiddleware(s *tenantSet) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasPrefix(r.URL.Path, "/admin/") || strings.HasPrefix(r.URL.Path, "/auth/") {
+				next.ServeHTTP(w, r)
+				return
+			}
+			// The tenant, and so the response, follows the token and
+			// the header naming it.
+			w.Header().Add("Vary", "Authorization")
+			w.Header().Add("Vary", s.cfg.Header)
+			var claim string
+			if p, ok := principal(r.Context()); ok {
+				claim = p.Tenant
+			}
+			t, err := s.resolve(r, claim)
+			if err != nil {
+				encodeError(r.Context(), err, w)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tenantKey{}, t)))
+		})
+	}
+}
+
+// tenantShortenerStore keeps each tenant's short links apart, by storing
+// their codes behind the tenant's ID. Exports and imports, being the
+// operator's, see the stored codes.
+type tenantShortenerStore struct {
+	shortenerStore
+}
+
+// tenantCode is the code under which a tenant's link is stored.
+func tenantCode(ctx context.Context, code string) string {
+	if t, ok := tenantFromContext(ctx); ok {
+		return t.ID + ":" + code
+	}
+	return code
+}
+
+func (s tenantShortenerStore) Create(ctx context.Context, link shortLink) error {
+	link.Code = tenantCode(ctx, link.Code)
+	return s.shortenerStore.Create(ctx, link)
+}
+
+func (s tenantShortenerStore) Hit(ctx context.Context, code string, now time.Time) (shortLink, error) {
+	l, err := s.shortenerStore.Hit(ctx, tenantCode(ctx, code), now)
+	if err != nil {
+		return l, err
+	}
+	l.Code = code
+	return l, nil
+}
@@ -0,0 +1,77 @@
+package stringsvc
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mcclayac/gokit/svcerrors"
+)
+
+func TestTenantResolve(t *testing.T) {
+	s := &tenantSet{
+		cfg:  tenancyConfig{Header: "X-Tenant-ID", Domain: "strings.internal", Default: "search"},
+		byID: map[string]tenant{"payments": {ID: "payments"}, "search": {ID: "search"}},
+	}
+	for _, c := range []struct {
+		header, host, claim string
+		want                string
+		code                svcerrors.Code
+	}{
+		{claim: "payments", want: "payments"},
+		{header: "payments", claim: "payments", want: "payments"},
+		{want: "search"},
+		{header: "search", want: "search"},
+		{header: "payments", code: svcerrors.CodeUnauthenticated},
+		{host: "payments.strings.internal", code: svcerrors.CodeUnauthenticated},
+		{header: "search", claim: "payments", code: svcerrors.CodePermissionDenied},
+	} {
+		r := httptest.NewRequest("POST", "/uppercase", nil)
+		if c.header != "" {
+			r.Header.Set("X-Tenant-ID", c.header)
+		}
+		if c.host != "" {
+			r.Host = c.host
+		}
+		got, err := s.resolve(r, c.claim)
+		if c.code != "" {
+			if code := svcerrors.CodeOf(err); code != c.code {
+				t.Errorf("header %q, host %q, claim %q: got %v, want %s", c.header, c.host, c.claim, err, c.code)
+			}
+			continue
+		}
+		if err != nil || got.ID != c.want {
+			t.Errorf("header %q, host %q, claim %q: got %q, %v, want %q", c.header, c.host, c.claim, got.ID, err, c.want)
+		}
+	}
+}
+
+func TestTenantMiddlewareClaim(t *testing.T) {
+	s := &tenantSet{
+		cfg:  tenancyConfig{Header: "X-Tenant-ID"},
+		byID: map[string]tenant{"payments": {ID: "payments"}},
+	}
+	var got string
+	h := tenantMiddleware(s)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = tenantLabel(r.Context())
+	}))
+
+	r := httptest.NewRequest("POST", "/uppercase", nil)
+	r.Header.Set("X-Tenant-ID", "payments")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized || got != "" {
+		t.Errorf("unauthenticated header: got %d, tenant %q, want 401", w.Code, got)
+	}
+
+	ctx := context.WithValue(r.Context(), principalKey{}, issuedToken{Subject: "billing", Tenant: "payments"})
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r.WithContext(ctx))
+	if w.Code != http.StatusOK || got != "payments" {
+		t.Errorf("token's tenant: got %d, tenant %q, want payments", w.Code, got)
+	}
+	if vary := w.Header().Values("Vary"); len(vary) != 2 || vary[0] != "Authorization" || vary[1] != "X-Tenant-ID" {
+		t.Errorf("got Vary %q, want Authorization and X-Tenant-ID", vary)
+	}
+}
@@ -0,0 +1,366 @@
+package stringsvc
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
+
+	"github.com/mcclayac/gokit/svcerrors"
+)
+
+// A tenant may override some of the global configuration for its own
+// requests. Overrides are set at run time through the admin API rather
+// than in the tenants file:
+//
+//	curl -X PUT -d '{"locale": "tr", "max_body_bytes": 65536, "operations": ["uppercase", "count"]}' \
+//		http://localhost:9090/admin/tenants/payments
+//
+// locale is the language whose casing rules Uppercase follows, so that
+// the payments tenant's "i" uppercases to "İ"; max_body_bytes replaces the
+// policy file's limit on request bodies; and operations, when not empty,
+// are the only operations the tenant's callers may call, named as in token
+// scopes. Overrides are kept in the storage backend, so the replicas share
+// them, and read with each request, so a change applies from the next
+// one. GET /admin/tenants lists the tenants with their overrides, GET
+// /admin/tenants/{id} returns one tenant's, and DELETE drops them.
+
+// tenantOverrides are the settings a tenant overrides. Zero values leave
+// the global setting in force.
+type tenantOverrides struct {
+	Locale       string   `json:"locale,omitempty" xml:"locale,omitempty"`
+	MaxBodyBytes int64    `json:"max_body_bytes,omitempty" xml:"max_body_bytes,omitempty"`
+	Operations   []string `json:"operations,omitempty" xml:"operations>operation,omitempty"`
+}
+
+func (o tenantOverrides) check() error {
+	if o.Locale != "" {
+		if _, err := language.Parse(o.Locale); err != nil {
+			return fmt.Errorf("locale %q: %v", o.Locale, err)
+		}
+	}
+	if o.MaxBodyBytes < 0 {
+		return fmt.Errorf("max_body_bytes must not be negative")
+	}
+	for _, op := range o.Operations {
+		if op == "" {
+			return fmt.Errorf("operations must not be empty strings")
+		}
+	}
+	return nil
+}
+
+// tenantConfigStore keeps tenants' overrides by tenant ID.
+// Implementations must be safe for concurrent use.
+type tenantConfigStore interface {
+	// Get returns the overrides of a tenant, failing with NOT_FOUND if it
+	// has none.
+	Get(ctx context.Context, tenantID string) (tenantOverrides, error)
+	// Put sets the overrides of a tenant, replacing any it had.
+	Put(ctx context.Context, tenantID string, o tenantOverrides) error
+	// Delete drops the overrides of a tenant, if it has any.
+	Delete(ctx context.Context, tenantID string) error
+	// List returns the overrides of the tenants that have them.
+	List(ctx context.Context) (map[string]tenantOverrides, error)
+}
+
+func errNoTenantConfig(tenantID string) error {
+	return svcerrors.Errorf(svcerrors.CodeNotFound, "tenant %q has no overrides", tenantID)
+}
+
+// memoryTenantConfigStore keeps overrides in memory.
+type memoryTenantConfigStore struct {
+	mtx     sync.RWMutex
+	configs map[string]tenantOverrides
+}
+
+func newMemoryTenantConfigStore() *memoryTenantConfigStore {
+	return &memoryTenantConfigStore{configs: map[string]tenantOverrides{}}
+}
+
+func (s *memoryTenantConfigStore) Get(_ context.Context, tenantID string) (tenantOverrides, error) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	o, ok := s.configs[tenantID]
+	if !ok {
+		return tenantOverrides{}, errNoTenantConfig(tenantID)
+	}
+	return o, nil
+}
+
+func (s *memoryTenantConfigStore) Put(_ context.Context, tenantID string, o tenantOverrides) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.configs[tenantID] = o
+	return nil
+}
+
+func (s *memoryTenantConfigStore) Delete(_ context.Context, tenantID string) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	delete(s.configs, tenantID)
+	return nil
+}
+
+func (s *memoryTenantConfigStore) List(context.Context) (map[string]tenantOverrides, error) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	configs := make(map[string]tenantOverrides, len(s.configs))
+	for id, o := range s.configs {
+		configs[id] = o
+	}
+	return configs, nil
+}
+
+// sqlTenantConfigStore keeps overrides as JSON in the tenant_configs table
+// in PostgreSQL or SQLite.
+type sqlTenantConfigStore struct {
+	db          *sql.DB
+	placeholder func(n int) string
+}
+
+func newPostgresTenantConfigStore(db *sql.DB) *sqlTenantConfigStore {
+	return &sqlTenantConfigStore{db, func(n int) string { return fmt.Sprintf("$%d", n) }}
+}
+
+func newSQLiteTenantConfigStore(db *sql.DB) *sqlTenantConfigStore {
+	return &sqlTenantConfigStore{db, func(n int) string { return fmt.Sprintf("?%d", n) }}
+}
+
+func (s *sqlTenantConfigStore) Get(ctx context.Context, tenantID string) (tenantOverrides, error) {
+	var b []byte
+	err := s.db.QueryRowContext(ctx, `SELECT config FROM tenant_configs WHERE tenant = `+s.placeholder(1), tenantID).Scan(&b)
+	if err == sql.ErrNoRows {
+		return tenantOverrides{}, errNoTenantConfig(tenantID)
+	}
+	if err != nil {
+		return tenantOverrides{}, err
+	}
+	var o tenantOverrides
+	err = json.Unmarshal(b, &o)
+	return o, err
+}
+
+func (s *sqlTenantConfigStore) Put(ctx context.Context, tenantID string, o tenantOverrides) error {
+	b, err := json.Marshal(o)
+	if err != nil {
+		return err
+	}
+	p := s.placeholder
+	_, err = s.db.ExecContext(ctx, fmt.Sprintf(`
+		INSERT INTO tenant_configs (tenant, config) VALUES (%s, %s)
+		ON CONFLICT (tenant) DO UPDATE SET config = excluded.config`, p(1), p(2)),
+		tenantID, string(b))
+	return err
+}
+
+func (s *sqlTenantConfigStore) Delete(ctx context.Context, tenantID string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM tenant_configs WHERE tenant = `+s.placeholder(1), tenantID)
+	return err
+}
+
+func (s *sqlTenantConfigStore) List(ctx context.Context) (map[string]tenantOverrides, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT tenant, config FROM tenant_configs`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	configs := map[string]tenantOverrides{}
+	for rows.Next() {
+		var (
+			id string
+			b  []byte
+			o  tenantOverrides
+		)
+		if err := rows.Scan(&id, &b); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(b, &o); err != nil {
+			return nil, fmt.Errorf("tenant %q: %v", id, err)
+		}
+		configs[id] = o
+	}
+	return configs, rows.Err()
+}
+
+type tenantOverridesKey struct{}
+
+// tenantOverridesFromContext returns the overrides of the request's
+// tenant, none if it has no tenant or the tenant overrides nothing.
+func tenantOverridesFromContext(ctx context.Context) tenantOverrides {
+	o, _ := ctx.Value(tenantOverridesKey{}).(tenantOverrides)
+	return o
+}
+
+// tenantVariant identifies, for caches and entity tags, the responses of
+// the request's tenant: its ID and the overrides that change what
+// operations return. It is empty without tenancy.
+func tenantVariant(ctx context.Context) string {
+	t, ok := tenantFromContext(ctx)
+	if !ok {
+		return ""
+	}
+	return t.ID + "\x00" + tenantOverridesFromContext(ctx).Locale
+}
+
+// tenantConfigMiddleware reads the overrides of each request's tenant
+// from store and puts them in the context, applying the tenant's limit on
+// request bodies. A tenant whose overrides can't be read gets the global
+// configuration, with a warning.
+func tenantConfigMiddleware(store tenantConfigStore, logger log.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			t, ok := tenantFromContext(r.Context())
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+			o, err := store.Get(r.Context(), t.ID)
+			if err != nil {
+				if svcerrors.CodeOf(err) != svcerrors.CodeNotFound {
+					level.Warn(logger).Log("msg", "reading tenant overrides, using the global configuration", "tenant", t.ID, "err", err)
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+			if o.MaxBodyBytes > 0 {
+				if r.ContentLength > o.MaxBodyBytes {
+					encodeError(r.Context(), svcerrors.Errorf(svcerrors.CodePayloadTooLarge, "request body is over %d bytes", o.MaxBodyBytes), w)
+					return
+				}
+				r.Body = http.MaxBytesReader(w, r.Body, o.MaxBodyBytes)
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tenantOverridesKey{}, o)))
+		})
+	}
+}
+
+// tenantOperationsMiddleware refuses calls to method by tenants that
+// haven't enabled it.
+func tenantOperationsMiddleware(method string) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			if t, ok := tenantFromContext(ctx); ok {
+				if o := tenantOverridesFromContext(ctx); len(o.Operations) > 0 && !hasScope(o.Operations, method) {
+					return nil, svcerrors.Errorf(svcerrors.CodePermissionDenied, "%s is not enabled for tenant %q", method, t.ID)
+				}
+			}
+			return next(ctx, request)
+		}
+	}
+}
+
+// tenantStringService follows the casing rules of the tenant's locale.
+type tenantStringService struct {
+	StringService
+}
+
+func (s tenantStringService) Uppercase(ctx context.Context, str string) (string, error) {
+	v, err := s.StringService.Uppercase(ctx, str)
+	o := tenantOverridesFromContext(ctx)
+	if err != nil || o.Locale == "" {
+		return v, err
+	}
+	tag, err := language.Parse(o.Locale)
+	if err != nil {
+		// The locale was checked when it was set.
+		return v, nil
+	}
+	return cases.Upper(tag).String(str), nil
+}
+
+// tenantConfigRequest reads, sets or drops the overrides of Tenant, or
+// lists every tenant's when Tenant is empty.
+type tenantConfigRequest struct {
+	Method    string
+	Tenant    string
+	Overrides tenantOverrides
+}
+
+type tenantConfigsResponse struct {
+	Tenants []tenantConfig `json:"tenants" xml:"tenants>tenant"`
+}
+
+// tenantConfig is a tenant with its overrides.
+type tenantConfig struct {
+	ID        string          `json:"id" xml:"id"`
+	Name      string          `json:"name,omitempty" xml:"name,omitempty"`
+	Overrides tenantOverrides `json:"overrides" xml:"overrides"`
+}
+
+func makeTenantConfigEndpoint(tenants *tenantSet, store tenantConfigStore) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(tenantConfigRequest)
+		if req.Tenant == "" {
+			configs, err := store.List(ctx)
+			if err != nil {
+				return nil, err
+			}
+			resp := tenantConfigsResponse{Tenants: []tenantConfig{}}
+			for _, t := range tenants.Tenants() {
+				resp.Tenants = append(resp.Tenants, tenantConfig{t.ID, t.Name, configs[t.ID]})
+			}
+			return resp, nil
+		}
+		t, ok := tenants.byID[req.Tenant]
+		if !ok {
+			return nil, svcerrors.Errorf(svcerrors.CodeNotFound, "no tenant %q", req.Tenant)
+		}
+		switch req.Method {
+		case http.MethodPut:
+			if err := req.Overrides.check(); err != nil {
+				return nil, svcerrors.Errorf(svcerrors.CodeInvalidArgument, "%v", err)
+			}
+			if err := store.Put(ctx, t.ID, req.Overrides); err != nil {
+				return nil, err
+			}
+			return tenantConfig{t.ID, t.Name, req.Overrides}, nil
+		case http.MethodDelete:
+			if err := store.Delete(ctx, t.ID); err != nil {
+				return nil, err
+			}
+			return tenantConfig{t.ID, t.Name, tenantOverrides{}}, nil
+		}
+		o, err := store.Get(ctx, t.ID)
+		if err != nil && svcerrors.CodeOf(err) != svcerrors.CodeNotFound {
+			return nil, err
+		}
+		return tenantConfig{t.ID, t.Name, o}, nil
+	}
+}
+
+func decodeTenantConfigRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	req := tenantConfigRequest{Method: r.Method, Tenant: strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/tenants"), "/")}
+	switch {
+	case r.Method == http.MethodGet:
+	case (r.Method == http.MethodPut || r.Method == http.MethodDelete) && req.Tenant != "":
+	default:
+		return nil, svcerrors.Errorf(svcerrors.CodeInvalidArgument, "%s %s: want GET, or PUT or DELETE of a tenant", r.Method, r.URL.Path)
+	}
+	if r.Method == http.MethodPut {
+		if err := decodeBody(r, &req.Overrides); err != nil {
+			return nil, err
+		}
+	}
+	return req, nil
+}
+
+// Tenants returns the tenants, by ID.
+func (s *tenantSet) Tenants() []tenant {
+	list := make([]tenant, 0, len(s.byID))
+	for _, t := range s.byID {
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
@@ -0,0 +1,205 @@
+package stringsvc
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+
+	"github.com/mcclayac/gokit/svcerrors"
+)
+
+// TimeService reports and converts times. Time zones are IANA names such as
+// "Europe/Paris"; an empty name means UTC.
+type TimeService interface {
+	// Now returns the current time in tz.
+	Now(ctx context.Context, tz string) (time.Time, error)
+	// Format formats ts in tz with a Go reference layout, or the name of
+	// one of the time package's layouts such as "RFC1123".
+	Format(ctx context.Context, ts time.Time, layout, tz string) (string, error)
+	// Convert reads the wall-clock time local, which has no offset, in
+	// fromTZ and returns the same instant in toTZ.
+	Convert(ctx context.Context, local string, fromTZ, toTZ string) (time.Time, error)
+}
+
+// localLayout is the layout of the wall-clock times passed to Convert.
+const localLayout = "2006-01-02T15:04:05.999999999"
+
+// namedLayouts are the layouts Format accepts by name.
+var namedLayouts = map[string]string{
+	"ANSIC":       time.ANSIC,
+	"UnixDate":    time.UnixDate,
+	"RubyDate":    time.RubyDate,
+	"RFC822":      time.RFC822,
+	"RFC822Z":     time.RFC822Z,
+	"RFC850":      time.RFC850,
+	"RFC1123":     time.RFC1123,
+	"RFC1123Z":    time.RFC1123Z,
+	"RFC3339":     time.RFC3339,
+	"RFC3339Nano": time.RFC3339Nano,
+	"Kitchen":     time.Kitchen,
+	"Stamp":       time.Stamp,
+	"StampMilli":  time.StampMilli,
+	"StampMicro":  time.StampMicro,
+	"StampNano":   time.StampNano,
+}
+
+// timeService is a concrete implementation of TimeService.
+type timeService struct {
+	now func() time.Time
+}
+
+func (s timeService) Now(ctx context.Context, tz string) (time.Time, error) {
+	if err := ctx.Err(); err != nil {
+		return time.Time{}, err
+	}
+	loc, err := loadZone(tz)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return s.now().In(loc), nil
+}
+
+func (timeService) Format(ctx context.Context, ts time.Time, layout, tz string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	loc, err := loadZone(tz)
+	if err != nil {
+		return "", err
+	}
+	if named, ok := namedLayouts[layout]; ok {
+		layout = named
+	}
+	// A layout without any reference elements formats every time the same
+	// way, which is almost certainly a mistake.
+	if layoutProbes[0].Format(layout) == layoutProbes[1].Format(layout) {
+		return "", svcerrors.Errorf(svcerrors.CodeLayoutInvalid, "layout %q has no reference time elements", layout)
+	}
+	return ts.In(loc).Format(layout), nil
+}
+
+func (timeService) Convert(ctx context.Context, local string, fromTZ, toTZ string) (time.Time, error) {
+	if err := ctx.Err(); err != nil {
+		return time.Time{}, err
+	}
+	from, err := loadZone(fromTZ)
+	if err != nil {
+		return time.Time{}, err
+	}
+	to, err := loadZone(toTZ)
+	if err != nil {
+		return time.Time{}, err
+	}
+	t, err := time.ParseInLocation(localLayout, local, from)
+	if err != nil {
+		return time.Time{}, svcerrors.Errorf(svcerrors.CodeTimestampInvalid, "%q is not a time like 2006-01-02T15:04:05", local)
+	}
+	return t.In(to), nil
+}
+
+// layoutProbes differ in every element a layout can show, so they format
+// the same way only with a layout that has none.
+var layoutProbes = [2]time.Time{
+	time.Date(2006, 1, 2, 15, 4, 5, 0, time.FixedZone("MST", -7*60*60)),
+	time.Date(2017, 11, 14, 9, 38, 59, 123456789, time.FixedZone("CET", 60*60)),
+}
+
+// loadZone returns the location named tz. "Local" is refused: it would
+// report the server's own zone.
+func loadZone(tz string) (*time.Location, error) {
+	if tz == "" {
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil || tz == "Local" {
+		return nil, svcerrors.Errorf(svcerrors.CodeTimeZoneInvalid, "unknown time zone %q", tz)
+	}
+	return loc, nil
+}
+
+// For each method, we define request and response structs. Times are
+// exchanged as RFC 3339 strings so every codec carries them the same way.
+type nowRequest struct {
+	TZ string `json:"tz" xml:"tz" validate:"max=64"`
+}
+
+type formatRequest struct {
+	TS     string `json:"ts" xml:"ts" validate:"required,max=64"`
+	Layout string `json:"layout" xml:"layout" validate:"required,max=256"`
+	TZ     string `json:"tz" xml:"tz" validate:"max=64"`
+}
+
+type convertRequest struct {
+	TS   string `json:"ts" xml:"ts" validate:"required,max=64"`
+	From string `json:"from" xml:"from" validate:"max=64"`
+	To   string `json:"to" xml:"to" validate:"max=64"`
+}
+
+// timeResponse is the response of every TimeService method.
+type timeResponse struct {
+	V   string `json:"v" xml:"v"`
+	Err error  `json:"-" xml:"-"`
+}
+
+// Failed implements endpoint.Failer.
+func (r timeResponse) Failed() error { return r.Err }
+
+func makeNowEndpoint(svc TimeService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(nowRequest)
+		t, err := svc.Now(ctx, req.TZ)
+		if err != nil {
+			return timeResponse{Err: err}, nil
+		}
+		return timeResponse{V: t.Format(time.RFC3339Nano)}, nil
+	}
+}
+
+func makeFormatEndpoint(svc TimeService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(formatRequest)
+		ts, err := time.Parse(time.RFC3339Nano, req.TS)
+		if err != nil {
+			return timeResponse{Err: svcerrors.Errorf(svcerrors.CodeTimestampInvalid, "%q is not an RFC 3339 time", req.TS)}, nil
+		}
+		v, err := svc.Format(ctx, ts, req.Layout, req.TZ)
+		return timeResponse{v, err}, nil
+	}
+}
+
+func makeConvertEndpoint(svc TimeService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(convertRequest)
+		t, err := svc.Convert(ctx, req.TS, req.From, req.To)
+		if err != nil {
+			return timeResponse{Err: err}, nil
+		}
+		return timeResponse{V: t.Format(time.RFC3339Nano)}, nil
+	}
+}
+
+func decodeNowRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request nowRequest
+	if err := decodeBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+func decodeFormatRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request formatRequest
+	if err := decodeBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+func decodeConvertRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request convertRequest
+	if err := decodeBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
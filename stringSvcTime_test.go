@@ -0,0 +1,47 @@
+package stringsvc
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+	_ "time/tzdata" // the zones don't depend on the machine's
+
+	"github.com/mcclayac/gokit/svcerrors"
+)
+
+func TestTimeZones(t *testing.T) {
+	ctx := context.Background()
+	svc := timeService{now: func() time.Time { return time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC) }}
+
+	if v, err := svc.Convert(ctx, "2024-03-01T12:00:00", "America/New_York", "Europe/Paris"); err != nil || v.Format(time.RFC3339) != "2024-03-01T18:00:00+01:00" {
+		t.Errorf("converting: got %v, %v", v, err)
+	}
+	if v, err := svc.Now(ctx, ""); err != nil || v.Location() != time.UTC {
+		t.Errorf("no zone: got %v, %v, want UTC", v, err)
+	}
+	for _, tz := range []string{"Mars/Olympus_Mons", "Local", "../../etc/passwd"} {
+		errs := map[string]error{}
+		_, errs["now"] = svc.Now(ctx, tz)
+		_, errs["format"] = svc.Format(ctx, time.Now(), time.RFC3339, tz)
+		_, errs["convert from"] = svc.Convert(ctx, "2024-03-01T12:00:00", tz, "UTC")
+		_, errs["convert to"] = svc.Convert(ctx, "2024-03-01T12:00:00", "UTC", tz)
+		for method, err := range errs {
+			if svcerrors.CodeOf(err) != svcerrors.CodeTimeZoneInvalid {
+				t.Errorf("%s in %q: got %v, want TIME_ZONE_INVALID", method, tz, err)
+			}
+		}
+	}
+	if _, err := svc.Format(ctx, time.Now(), "no reference", ""); svcerrors.CodeOf(err) != svcerrors.CodeLayoutInvalid {
+		t.Errorf("a layout without reference elements: got %v, want LAYOUT_INVALID", err)
+	}
+}
+
+func TestTimeZoneErrorOverHTTP(t *testing.T) {
+	srv := newTestServer(t)
+	resp, b := callTestServer(t, srv, "POST", "/time/now", http.Header{"Content-Type": {"application/json"}}, `{"tz":"Mars/Olympus_Mons"}`)
+	if resp.StatusCode != http.StatusBadRequest || !strings.Contains(string(b), `"code":"TIME_ZONE_INVALID"`) {
+		t.Errorf("got %d %s, want 400 TIME_ZONE_INVALID", resp.StatusCode, b)
+	}
+}
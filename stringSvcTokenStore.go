@@ -0,0 +1,185 @@
+package stringsvc
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tokenStore keeps issued tokens by the SHA-256 of the token.
+// Implementations must be safe for concurrent use.
+type tokenStore interface {
+	// Create stores t under hash until it expires.
+	Create(ctx context.Context, hash string, t issuedToken) error
+	// Lookup returns the token stored under hash, failing with
+	// UNAUTHENTICATED if there is none or it has expired by now.
+	Lookup(ctx context.Context, hash string, now time.Time) (issuedToken, error)
+	// Revoke deletes the token stored under hash, if there is one.
+	Revoke(ctx context.Context, hash string) error
+	// Export calls fn with each token that hasn't expired by now and its
+	// hash, stopping at the first error.
+	Export(ctx context.Context, now time.Time, fn func(hash string, t issuedToken) error) error
+}
+
+// memoryTokenStore keeps tokens in memory. Expired tokens are swept lazily
+// as tokens are issued.
+type memoryTokenStore struct {
+	mtx       sync.Mutex
+	tokens    map[string]issuedToken
+	lastSweep time.Time
+}
+
+func newMemoryTokenStore() *memoryTokenStore {
+	return &memoryTokenStore{tokens: map[string]issuedToken{}}
+}
+
+func (s *memoryTokenStore) Create(_ context.Context, hash string, t issuedToken) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.sweep(t.Created)
+	s.tokens[hash] = t
+	return nil
+}
+
+func (s *memoryTokenStore) Lookup(_ context.Context, hash string, now time.Time) (issuedToken, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	t, ok := s.tokens[hash]
+	if !ok || !now.Before(t.Expires) {
+		return issuedToken{}, errInvalidToken
+	}
+	return t, nil
+}
+
+func (s *memoryTokenStore) Revoke(_ context.Context, hash string) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	delete(s.tokens, hash)
+	return nil
+}
+
+func (s *memoryTokenStore) Export(_ context.Context, now time.Time, fn func(hash string, t issuedToken) error) error {
+	s.mtx.Lock()
+	tokens := make(map[string]issuedToken, len(s.tokens))
+	for hash, t := range s.tokens {
+		if now.Before(t.Expires) {
+			tokens[hash] = t
+		}
+	}
+	s.mtx.Unlock()
+	for hash, t := range tokens {
+		if err := fn(hash, t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sweep drops expired tokens, at most once a minute.
+func (s *memoryTokenStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < time.Minute {
+		return
+	}
+	s.lastSweep = now
+	for hash, t := range s.tokens {
+		if !now.Before(t.Expires) {
+			delete(s.tokens, hash)
+		}
+	}
+}
+
+// sqlTokenStore keeps tokens in the auth_tokens table in PostgreSQL or
+// SQLite. Expired tokens are deleted at most once a minute as tokens are
+// issued.
+type sqlTokenStore struct {
+	db          *sql.DB
+	placeholder func(n int) string
+
+	mtx       sync.Mutex
+	lastSweep time.Time
+}
+
+func newPostgresTokenStore(db *sql.DB) *sqlTokenStore {
+	return &sqlTokenStore{db: db, placeholder: func(n int) string { return fmt.Sprintf("$%d", n) }}
+}
+
+func newSQLiteTokenStore(db *sql.DB) *sqlTokenStore {
+	return &sqlTokenStore{db: db, placeholder: func(n int) string { return fmt.Sprintf("?%d", n) }}
+}
+
+func (s *sqlTokenStore) Create(ctx context.Context, hash string, t issuedToken) error {
+	p := s.placeholder
+	if _, err := s.db.ExecContext(ctx, fmt.Sprintf(`
+		INSERT INTO auth_tokens (token_sha256, id, subject, tenant, scopes, created_at, expires_at)
+		VALUES (%s, %s, %s, %s, %s, %s, %s)`, p(1), p(2), p(3), p(4), p(5), p(6), p(7)),
+		hash, t.ID, t.Subject, t.Tenant, strings.Join(t.Scopes, " "), t.Created.UnixNano(), t.Expires.UnixNano()); err != nil {
+		return err
+	}
+	s.mtx.Lock()
+	sweep := t.Created.Sub(s.lastSweep) >= time.Minute
+	if sweep {
+		s.lastSweep = t.Created
+	}
+	s.mtx.Unlock()
+	if !sweep {
+		return nil
+	}
+	_, err := s.db.ExecContext(ctx, `DELETE FROM auth_tokens WHERE expires_at <= `+p(1), t.Created.UnixNano())
+	return err
+}
+
+func (s *sqlTokenStore) Export(ctx context.Context, now time.Time, fn func(hash string, t issuedToken) error) error {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT token_sha256, id, subject, tenant, scopes, created_at, expires_at FROM auth_tokens
+		WHERE expires_at > `+s.placeholder(1), now.UnixNano())
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			hash, scopes     string
+			t                issuedToken
+			created, expires int64
+		)
+		if err := rows.Scan(&hash, &t.ID, &t.Subject, &t.Tenant, &scopes, &created, &expires); err != nil {
+			return err
+		}
+		t.Scopes = strings.Fields(scopes)
+		t.Created, t.Expires = time.Unix(0, created).UTC(), time.Unix(0, expires).UTC()
+		if err := fn(hash, t); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (s *sqlTokenStore) Lookup(ctx context.Context, hash string, now time.Time) (issuedToken, error) {
+	var (
+		t                issuedToken
+		scopes           string
+		created, expires int64
+	)
+	p := s.placeholder
+	err := s.db.QueryRowContext(ctx, fmt.Sprintf(`
+		SELECT id, subject, tenant, scopes, created_at, expires_at FROM auth_tokens
+		WHERE token_sha256 = %s AND expires_at > %s`, p(1), p(2)),
+		hash, now.UnixNano()).Scan(&t.ID, &t.Subject, &t.Tenant, &scopes, &created, &expires)
+	if err == sql.ErrNoRows {
+		return issuedToken{}, errInvalidToken
+	}
+	if err != nil {
+		return issuedToken{}, err
+	}
+	t.Scopes = strings.Fields(scopes)
+	t.Created, t.Expires = time.Unix(0, created).UTC(), time.Unix(0, expires).UTC()
+	return t, nil
+}
+
+func (s *sqlTokenStore) Revoke(ctx context.Context, hash string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM auth_tokens WHERE token_sha256 = `+s.placeholder(1), hash)
+	return err
+}
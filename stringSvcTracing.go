@@ -0,0 +1,64 @@
+package stringsvc
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/zipkin"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// tracingConfig selects where spans are exported.
+type tracingConfig struct {
+	Exporter    string  // "" (tracing off), "otlp", "zipkin" or "jaeger"
+	Endpoint    string  // collector address; each exporter has a local default
+	ServiceName string  // service.name resource attribute
+	SampleRatio float64 // fraction of new traces sampled; callers' decisions are kept
+}
+
+// newTracerProvider returns a TracerProvider exporting to the configured
+// backend, and a function that flushes and stops it. Jaeger is sent OTLP,
+// which it ingests natively, on its OTLP/HTTP port.
+func newTracerProvider(cfg tracingConfig) (trace.TracerProvider, func(context.Context) error, error) {
+	var (
+		exp sdktrace.SpanExporter
+		err error
+	)
+	switch cfg.Exporter {
+	case "":
+		return noop.NewTracerProvider(), func(context.Context) error { return nil }, nil
+	case "otlp", "jaeger":
+		endpoint := cfg.Endpoint
+		if endpoint == "" {
+			endpoint = "localhost:4318"
+		}
+		exp, err = otlptracehttp.New(context.Background(), otlptracehttp.WithEndpoint(endpoint), otlptracehttp.WithInsecure())
+	case "zipkin":
+		endpoint := cfg.Endpoint
+		if endpoint == "" {
+			endpoint = "http://localhost:9411/api/v2/spans"
+		}
+		exp, err = zipkin.New(endpoint)
+	default:
+		return nil, nil, fmt.Errorf("unknown trace exporter %q", cfg.Exporter)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceName(cfg.ServiceName),
+			semconv.ServiceVersion(version),
+		)),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	return tp, tp.Shutdown, nil
+}
@@ -0,0 +1,256 @@
+package stringsvc
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/sony/gobreaker"
+	"golang.org/x/time/rate"
+
+	"github.com/mcclayac/gokit/svcerrors"
+	"github.com/mcclayac/gokit/translate"
+)
+
+// TranslateService translates text through the configured providers.
+type TranslateService interface {
+	// Translate translates text from source, detected when empty, to
+	// target.
+	Translate(ctx context.Context, text, source, target string) (translation, error)
+}
+
+// translation is a translated text and where it came from.
+type translation struct {
+	Text           string
+	DetectedSource string
+	Provider       string
+}
+
+// translateConfig configures the TranslateService.
+type translateConfig struct {
+	Providers  []string // tried in order; translation is off when empty
+	DeepLKey   string
+	GoogleKey  string
+	LibreURL   string
+	LibreKey   string
+	Timeout    time.Duration // for each provider call
+	RateLimits providerRates
+	// The circuit breaker of each provider opens after BreakerFailures
+	// consecutive failures, and lets a trial call through after
+	// BreakerCooldown.
+	BreakerFailures uint32
+	BreakerCooldown time.Duration
+	CacheTTL        time.Duration // caching is off when zero
+	CacheSize       int
+}
+
+// providerRates holds the calls per second allowed to each provider. It
+// implements flag.Value, parsing lists like "deepl=5,google=10".
+type providerRates map[string]float64
+
+func (r *providerRates) Set(s string) error {
+	rates := providerRates{}
+	for _, kv := range strings.Split(s, ",") {
+		if kv = strings.TrimSpace(kv); kv == "" {
+			continue
+		}
+		i := strings.IndexByte(kv, '=')
+		if i < 0 {
+			return fmt.Errorf("%q: want provider=rate", kv)
+		}
+		v, err := strconv.ParseFloat(kv[i+1:], 64)
+		if err != nil || v <= 0 {
+			return fmt.Errorf("%q: want a positive rate", kv)
+		}
+		rates[kv[:i]] = v
+	}
+	*r = rates
+	return nil
+}
+
+func (r *providerRates) String() string {
+	var parts []string
+	for p, v := range *r {
+		parts = append(parts, p+"="+strconv.FormatFloat(v, 'g', -1, 64))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+// newTranslateProviders returns the configured providers, in order, each
+// guarded by its own rate limit and circuit breaker.
+func newTranslateProviders(cfg translateConfig) ([]translate.Provider, error) {
+	client := &http.Client{Timeout: cfg.Timeout}
+	var providers []translate.Provider
+	for _, name := range cfg.Providers {
+		var p translate.Provider
+		switch name {
+		case "deepl":
+			p = translate.DeepL{Key: cfg.DeepLKey, Client: client}
+		case "google":
+			p = translate.Google{Key: cfg.GoogleKey, Client: client}
+		case "libretranslate":
+			if cfg.LibreURL == "" {
+				return nil, errors.New("libretranslate needs a server URL")
+			}
+			p = translate.LibreTranslate{URL: cfg.LibreURL, Key: cfg.LibreKey, Client: client}
+		default:
+			return nil, fmt.Errorf("unknown translation provider %q", name)
+		}
+		var limiter *rate.Limiter
+		if r, ok := cfg.RateLimits[name]; ok {
+			limiter = rate.NewLimiter(rate.Limit(r), int(r)+1)
+		}
+		providers = append(providers, translate.Guard(p, limiter, gobreaker.Settings{
+			Timeout: cfg.BreakerCooldown,
+			ReadyToTrip: func(c gobreaker.Counts) bool {
+				return c.ConsecutiveFailures >= cfg.BreakerFailures
+			},
+		}))
+	}
+	return providers, nil
+}
+
+// translateService is a concrete implementation of TranslateService. It
+// falls back to the next provider when one fails, is rate limited or has
+// its circuit open; a request a provider rejects as bad is not retried.
+type translateService struct {
+	providers []translate.Provider
+	cache     *translationCache // nil when caching is off
+}
+
+func (s translateService) Translate(ctx context.Context, text, source, target string) (translation, error) {
+	if err := ctx.Err(); err != nil {
+		return translation{}, err
+	}
+	key := translationKey(text, source, target)
+	if t, ok := s.cache.Get(key); ok {
+		return t, nil
+	}
+	limited := true
+	var errs []string
+	for _, p := range s.providers {
+		r, err := p.Translate(ctx, text, source, target)
+		if err == nil {
+			t := translation{r.Text, r.DetectedSource, p.Name()}
+			s.cache.Put(key, t)
+			return t, nil
+		}
+		if ctx.Err() != nil {
+			return translation{}, ctx.Err()
+		}
+		var se *translate.StatusError
+		if errors.As(err, &se) && se.BadRequest() {
+			return translation{}, svcerrors.Errorf(svcerrors.CodeInvalidArgument, "%s rejected the request: %s", p.Name(), se.Message)
+		}
+		limited = limited && errors.Is(err, translate.ErrRateLimited)
+		errs = append(errs, err.Error())
+	}
+	if limited {
+		return translation{}, svcerrors.ErrRateLimited
+	}
+	return translation{}, svcerrors.Errorf(svcerrors.CodeTranslationUnavailable, "no translation provider succeeded: %s", strings.Join(errs, "; "))
+}
+
+func translationKey(text, source, target string) [sha256.Size]byte {
+	return sha256.Sum256([]byte(source + "\x00" + target + "\x00" + text))
+}
+
+// translationCache is an in-process cache of translations, keyed by a hash
+// of the text and languages. Expired entries are swept lazily; when the
+// cache is full, arbitrary entries make way for new ones.
+type translationCache struct {
+	ttl  time.Duration
+	size int
+	now  func() time.Time
+
+	mtx     sync.Mutex
+	entries map[[sha256.Size]byte]translationEntry
+}
+
+type translationEntry struct {
+	t       translation
+	expires time.Time
+}
+
+func newTranslationCache(ttl time.Duration, size int, now func() time.Time) *translationCache {
+	return &translationCache{ttl: ttl, size: size, now: now, entries: map[[sha256.Size]byte]translationEntry{}}
+}
+
+// Get returns the cached translation for key. A nil cache holds nothing.
+func (c *translationCache) Get(key [sha256.Size]byte) (translation, bool) {
+	if c == nil {
+		return translation{}, false
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	e, ok := c.entries[key]
+	if !ok || c.now().After(e.expires) {
+		return translation{}, false
+	}
+	return e.t, true
+}
+
+func (c *translationCache) Put(key [sha256.Size]byte, t translation) {
+	if c == nil {
+		return
+	}
+	now := c.now()
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if len(c.entries) >= c.size {
+		for k, e := range c.entries {
+			if now.After(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		for k := range c.entries {
+			if len(c.entries) < c.size {
+				break
+			}
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = translationEntry{t, now.Add(c.ttl)}
+}
+
+// For each method, we define request and response structs.
+type translateRequest struct {
+	Text   string `json:"text" xml:"text" validate:"required,max=5000"`
+	Source string `json:"source" xml:"source" validate:"max=16"`
+	Target string `json:"target" xml:"target" validate:"required,max=16"`
+}
+
+type translateResponse struct {
+	V              string `json:"v" xml:"v"`
+	DetectedSource string `json:"detected_source,omitempty" xml:"detected_source,omitempty"`
+	Provider       string `json:"provider" xml:"provider"`
+	Err            error  `json:"-" xml:"-"`
+}
+
+// Failed implements endpoint.Failer.
+func (r translateResponse) Failed() error { return r.Err }
+
+func makeTranslateEndpoint(svc TranslateService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(translateRequest)
+		t, err := svc.Translate(ctx, req.Text, req.Source, req.Target)
+		return translateResponse{t.Text, t.DetectedSource, t.Provider, err}, nil
+	}
+}
+
+func decodeTranslateRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request translateRequest
+	if err := decodeBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
@@ -0,0 +1,87 @@
+package stringsvc
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/cloudflare/tableflip"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// With -graceful-upgrades, the service can be restarted, or upgraded to a
+// new binary, without refusing a connection: on SIGUSR2 it starts the
+// binary at its own path again, handing over the listening socket. Once
+// the new process is ready, the old one stops accepting connections,
+// drains the requests in flight for up to -drain-timeout and exits. If
+// the new process fails to start, the old one carries on. -pid-file always
+// names the process serving, for the service manager:
+//
+//	cp stringsvc.new /usr/local/bin/stringsvc
+//	kill -USR2 $(cat /run/stringsvc.pid)
+//
+// SIGTERM and SIGINT drain and exit the same way, without a successor.
+
+// upgradeConfig configures graceful upgrades.
+type upgradeConfig struct {
+	Enabled      bool
+	PIDFile      string
+	Timeout      time.Duration // how long the new process has to become ready
+	DrainTimeout time.Duration
+}
+
+// serveUpgradable serves srv on a listener inherited from the previous
+// process, or a new one, until it is upgraded or stopped. It returns once
+// the requests in flight have drained, nil unless serving failed.
+func serveUpgradable(srv *http.Server, addrFile string, cfg upgradeConfig, tls tlsConfig, logger log.Logger) error {
+	upg, err := tableflip.New(tableflip.Options{PIDFile: cfg.PIDFile, UpgradeTimeout: cfg.Timeout})
+	if err != nil {
+		return err
+	}
+	defer upg.Stop()
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGUSR2, syscall.SIGTERM, os.Interrupt)
+	defer signal.Stop(sigs)
+	go func() {
+		for sig := range sigs {
+			if sig != syscall.SIGUSR2 {
+				level.Info(logger).Log("msg", "stopping", "signal", sig)
+				upg.Stop()
+				return
+			}
+			level.Info(logger).Log("msg", "upgrading")
+			if err := upg.Upgrade(); err != nil {
+				level.Error(logger).Log("msg", "upgrade failed, still serving", "err", err)
+			}
+		}
+	}()
+
+	ln, err := upg.Listen("tcp", srv.Addr)
+	if err != nil {
+		return err
+	}
+	if err := writeAddrFile(ln, addrFile); err != nil {
+		return err
+	}
+	served := make(chan error, 1)
+	go func() { served <- serveHTTP(srv, ln, tls) }()
+	level.Info(logger).Log("transport", "HTTP", "addr", ln.Addr(), "inherited", upg.HasParent())
+	if err := upg.Ready(); err != nil {
+		return err
+	}
+
+	select {
+	case err := <-served:
+		return err
+	case <-upg.Exit():
+	}
+	level.Info(logger).Log("msg", "draining", "timeout", cfg.DrainTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.DrainTimeout)
+	defer cancel()
+	return srv.Shutdown(ctx)
+}
@@ -0,0 +1,229 @@
+package stringsvc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	httptransport "github.com/go-kit/kit/transport/http"
+
+	"github.com/mcclayac/gokit/svcerrors"
+)
+
+// POST /process/file runs an operation over an uploaded text file and
+// returns the result as a download:
+//
+//	curl -F op=uppercase -F file=@notes.txt -OJ http://localhost:9090/process/file
+//
+// The operation is the op field, which must come before the file part, or
+// the op query parameter. The file is processed a line at a time as it
+// arrives and the result written as it is made, so neither is held in
+// memory; the upload is limited to -upload-max-bytes. A client that
+// accepts text/event-stream instead gets progress events as the file is
+// read, and a done event with the result, or an error event.
+
+// fileOps are the operations a file can be processed with, each applied to
+// a line at a time, its newline included.
+var fileOps = map[string]func(StringService) func(context.Context, string) (string, error){
+	"uppercase": func(svc StringService) func(context.Context, string) (string, error) { return svc.Uppercase },
+}
+
+// uploadProgressInterval is how many bytes of the file are read between
+// progress events.
+const uploadProgressInterval = 256 << 10
+
+// uploadProgress is a progress event. Total is the length of the whole
+// request, when the client sent it, so it a little overstates the file's.
+type uploadProgress struct {
+	Bytes int64 `json:"bytes"`
+	Total int64 `json:"total,omitempty"`
+}
+
+// uploadResult is the done event.
+type uploadResult struct {
+	Filename string `json:"filename"`
+	Bytes    int64  `json:"bytes"`
+	V        string `json:"v"`
+}
+
+// processFileHandler serves POST /process/file, with uploads of up to
+// maxBytes.
+func processFileHandler(svc StringService, maxBytes int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := httptransport.PopulateRequestContext(r.Context(), r)
+		if r.ContentLength > maxBytes {
+			encodeError(ctx, svcerrors.Errorf(svcerrors.CodePayloadTooLarge, "upload of %d bytes is over the limit of %d", r.ContentLength, maxBytes), w)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+		mr, err := r.MultipartReader()
+		if err != nil {
+			encodeError(ctx, svcerrors.Errorf(svcerrors.CodeUnsupportedMediaType, "expected a multipart/form-data body: %v", err), w)
+			return
+		}
+		opName, file, err := nextFilePart(mr, r.URL.Query().Get("op"))
+		if err != nil {
+			encodeError(ctx, uploadError(err), w)
+			return
+		}
+		newOp, ok := fileOps[opName]
+		if !ok {
+			encodeError(ctx, svcerrors.Errorf(svcerrors.CodeInvalidArgument, "op %q is not one of %s", opName, strings.Join(fileOpNames(), ", ")), w)
+			return
+		}
+		op := newOp(svc)
+		filename := resultFilename(file.FileName(), opName)
+
+		if sw := sseFromContext(ctx); sw != nil {
+			var out bytes.Buffer
+			n, err := processLines(ctx, file, &out, op, func(n int64) {
+				sw.Event("progress", uploadProgress{Bytes: n, Total: r.ContentLength})
+			})
+			if err != nil {
+				err = uploadError(err)
+				reportUnexpected(ctx, err)
+				sw.Event("error", errorResponse{svcerrors.From(err)})
+				return
+			}
+			sw.Event("done", uploadResult{Filename: filename, Bytes: n, V: out.String()})
+			return
+		}
+
+		out := &attachmentWriter{w: w, filename: filename}
+		if _, err := processLines(ctx, file, out, op, nil); err != nil {
+			if out.started {
+				// The status is sent; cut the download short rather
+				// than let it look complete.
+				panic(http.ErrAbortHandler)
+			}
+			encodeError(ctx, uploadError(err), w)
+			return
+		}
+		if !out.started {
+			out.start() // an empty file
+		}
+	})
+}
+
+// nextFilePart reads the form up to its file part, and returns the op
+// field, defaulting to op, and the file.
+func nextFilePart(mr *multipart.Reader, op string) (string, *multipart.Part, error) {
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			return "", nil, svcerrors.New(svcerrors.CodeInvalidArgument, "the form has no file part")
+		}
+		if err != nil {
+			return "", nil, err
+		}
+		switch p.FormName() {
+		case "op":
+			b, err := io.ReadAll(io.LimitReader(p, 64))
+			if err != nil {
+				return "", nil, err
+			}
+			op = strings.TrimSpace(string(b))
+		case "file":
+			return op, p, nil
+		}
+	}
+}
+
+// processLines applies op to each line read from r and writes the results
+// to w. It calls progress, if it isn't nil, every uploadProgressInterval
+// bytes and at the end, and returns the bytes read.
+func processLines(ctx context.Context, r io.Reader, w io.Writer, op func(context.Context, string) (string, error), progress func(int64)) (int64, error) {
+	br := bufio.NewReader(r)
+	var n, reported int64
+	for {
+		line, err := br.ReadString('\n')
+		if line != "" {
+			n += int64(len(line))
+			v, opErr := op(ctx, line)
+			if opErr != nil {
+				return n, opErr
+			}
+			if _, werr := io.WriteString(w, v); werr != nil {
+				return n, werr
+			}
+			if progress != nil && n-reported >= uploadProgressInterval {
+				progress(n)
+				reported = n
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return n, err
+		}
+	}
+	if progress != nil && n != reported {
+		progress(n)
+	}
+	return n, nil
+}
+
+// uploadError turns an error reading or processing an upload into a
+// service error.
+func uploadError(err error) error {
+	var tooLarge *http.MaxBytesError
+	var e *svcerrors.Error
+	switch {
+	case errors.As(err, &tooLarge):
+		return svcerrors.Errorf(svcerrors.CodePayloadTooLarge, "upload is over the limit of %d bytes", tooLarge.Limit)
+	case errors.As(err, &e), errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return err
+	}
+	return svcerrors.Errorf(svcerrors.CodeInvalidArgument, "malformed upload: %v", err)
+}
+
+// resultFilename names the result of op over the file name: notes.txt
+// becomes notes.uppercase.txt.
+func resultFilename(name, op string) string {
+	if name == "" {
+		return op + ".txt"
+	}
+	ext := filepath.Ext(name)
+	return strings.TrimSuffix(name, ext) + "." + op + ext
+}
+
+func fileOpNames() []string {
+	names := make([]string, 0, len(fileOps))
+	for name := range fileOps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// attachmentWriter writes a text download, sending its headers with the
+// first write.
+type attachmentWriter struct {
+	w        http.ResponseWriter
+	filename string
+	started  bool
+}
+
+func (a *attachmentWriter) start() {
+	h := a.w.Header()
+	h.Set("Content-Type", "text/plain; charset=utf-8")
+	h.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": a.filename}))
+	a.w.WriteHeader(http.StatusOK)
+	a.started = true
+}
+
+func (a *attachmentWriter) Write(p []byte) (int, error) {
+	if !a.started {
+		a.start()
+	}
+	return a.w.Write(p)
+}
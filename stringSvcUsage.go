@@ -0,0 +1,338 @@
+package stringsvc
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+
+	"github.com/mcclayac/gokit/svcerrors"
+)
+
+// usageMonth is the layout of the months usage is rolled up by.
+const usageMonth = "2006-01"
+
+// usageTotals is what a caller used.
+type usageTotals struct {
+	Calls    int64 `json:"calls" xml:"calls"`
+	BytesIn  int64 `json:"bytes_in" xml:"bytes_in"`   // requests encoded as JSON
+	BytesOut int64 `json:"bytes_out" xml:"bytes_out"` // responses encoded as JSON
+	// ComputeMicros is the time spent handling calls, in microseconds.
+	ComputeMicros int64 `json:"compute_us" xml:"compute_us"`
+}
+
+func (t *usageTotals) add(u usageTotals) {
+	t.Calls += u.Calls
+	t.BytesIn += u.BytesIn
+	t.BytesOut += u.BytesOut
+	t.ComputeMicros += u.ComputeMicros
+}
+
+// usageKey identifies a rollup. Tenant is empty when the service isn't
+// multi-tenant.
+type usageKey struct {
+	Month     string `json:"month" xml:"month"`
+	Tenant    string `json:"tenant,omitempty" xml:"tenant,omitempty"`
+	Identity  string `json:"identity" xml:"identity"`
+	Operation string `json:"operation" xml:"operation"`
+}
+
+// usageRollup is a caller's usage of one operation over a month.
+type usageRollup struct {
+	usageKey
+	usageTotals
+}
+
+// usageStore keeps usage rollups. Implementations must be safe for
+// concurrent use.
+type usageStore interface {
+	// Add adds each rollup to the stored one with the same key.
+	Add(ctx context.Context, rollups []usageRollup) error
+	// List returns the rollups for month, only those of identity if it
+	// isn't empty.
+	List(ctx context.Context, month, identity string) ([]usageRollup, error)
+}
+
+// sqlUsageStore keeps rollups in the usage_rollups table in PostgreSQL or
+// SQLite, whose SQL differs here only in how parameters are written.
+type sqlUsageStore struct {
+	db          *sql.DB
+	placeholder func(n int) string
+}
+
+func newPostgresUsageStore(db *sql.DB) *sqlUsageStore {
+	return &sqlUsageStore{db, func(n int) string { return fmt.Sprintf("$%d", n) }}
+}
+
+func newSQLiteUsageStore(db *sql.DB) *sqlUsageStore {
+	return &sqlUsageStore{db, func(n int) string { return fmt.Sprintf("?%d", n) }}
+}
+
+func (s *sqlUsageStore) Add(ctx context.Context, rollups []usageRollup) error {
+	p := s.placeholder
+	query := fmt.Sprintf(`
+		INSERT INTO usage_rollups (month, tenant, identity, operation, calls, bytes_in, bytes_out, compute_us)
+		VALUES (%s, %s, %s, %s, %s, %s, %s, %s)
+		ON CONFLICT (month, tenant, identity, operation) DO UPDATE SET
+			calls = usage_rollups.calls + excluded.calls,
+			bytes_in = usage_rollups.bytes_in + excluded.bytes_in,
+			bytes_out = usage_rollups.bytes_out + excluded.bytes_out,
+			compute_us = usage_rollups.compute_us + excluded.compute_us`,
+		p(1), p(2), p(3), p(4), p(5), p(6), p(7), p(8))
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, r := range rollups {
+		if _, err := tx.ExecContext(ctx, query, r.Month, r.Tenant, r.Identity, r.Operation, r.Calls, r.BytesIn, r.BytesOut, r.ComputeMicros); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *sqlUsageStore) List(ctx context.Context, month, identity string) ([]usageRollup, error) {
+	query := `SELECT month, tenant, identity, operation, calls, bytes_in, bytes_out, compute_us FROM usage_rollups WHERE month = ` + s.placeholder(1)
+	args := []interface{}{month}
+	if identity != "" {
+		query += ` AND identity = ` + s.placeholder(2)
+		args = append(args, identity)
+	}
+	query += ` ORDER BY tenant, identity, operation`
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var rollups []usageRollup
+	for rows.Next() {
+		var r usageRollup
+		if err := rows.Scan(&r.Month, &r.Tenant, &r.Identity, &r.Operation, &r.Calls, &r.BytesIn, &r.BytesOut, &r.ComputeMicros); err != nil {
+			return nil, err
+		}
+		rollups = append(rollups, r)
+	}
+	return rollups, rows.Err()
+}
+
+// usageMeter rolls up usage in memory and adds it to the store every flush
+// interval, so at most one interval's usage is lost if the process dies.
+type usageMeter struct {
+	store  usageStore
+	logger log.Logger
+	done   chan struct{}
+	wg     sync.WaitGroup
+	// flushing is held by flush while it moves rollups into the store, so
+	// that List sees each call exactly once.
+	flushing sync.RWMutex
+
+	mtx     sync.Mutex
+	pending map[usageKey]usageTotals
+}
+
+func newUsageMeter(store usageStore, flushInterval time.Duration, logger log.Logger) *usageMeter {
+	m := &usageMeter{
+		store:   store,
+		logger:  logger,
+		done:    make(chan struct{}),
+		pending: map[usageKey]usageTotals{},
+	}
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		t := time.NewTicker(flushInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				m.flushAndLog()
+			case <-m.done:
+				return
+			}
+		}
+	}()
+	return m
+}
+
+// Add records a call.
+func (m *usageMeter) Add(key usageKey, u usageTotals) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	t := m.pending[key]
+	t.add(u)
+	m.pending[key] = t
+}
+
+// flush adds the pending rollups to the store. If that fails, they are kept
+// for the next flush.
+func (m *usageMeter) flush() error {
+	m.flushing.Lock()
+	defer m.flushing.Unlock()
+
+	m.mtx.Lock()
+	pending := m.pending
+	m.pending = map[usageKey]usageTotals{}
+	m.mtx.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+	rollups := make([]usageRollup, 0, len(pending))
+	for k, t := range pending {
+		rollups = append(rollups, usageRollup{k, t})
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err := m.store.Add(ctx, rollups)
+	if err != nil {
+		m.mtx.Lock()
+		for k, t := range pending {
+			p := m.pending[k]
+			p.add(t)
+			m.pending[k] = p
+		}
+		m.mtx.Unlock()
+	}
+	return err
+}
+
+func (m *usageMeter) flushAndLog() {
+	if err := m.flush(); err != nil {
+		level.Error(m.logger).Log("msg", "flushing usage", "err", err)
+	}
+}
+
+// List returns the rollups for month, including usage not yet flushed,
+// sorted by tenant, identity and operation.
+func (m *usageMeter) List(ctx context.Context, month, identity string) ([]usageRollup, error) {
+	m.flushing.RLock()
+	defer m.flushing.RUnlock()
+
+	stored, err := m.store.List(ctx, month, identity)
+	if err != nil {
+		return nil, err
+	}
+	merged := map[usageKey]usageTotals{}
+	for _, r := range stored {
+		merged[r.usageKey] = r.usageTotals
+	}
+	m.mtx.Lock()
+	for k, t := range m.pending {
+		if k.Month == month && (identity == "" || k.Identity == identity) {
+			u := merged[k]
+			u.add(t)
+			merged[k] = u
+		}
+	}
+	m.mtx.Unlock()
+	rollups := make([]usageRollup, 0, len(merged))
+	for k, t := range merged {
+		rollups = append(rollups, usageRollup{k, t})
+	}
+	sort.Slice(rollups, func(i, j int) bool {
+		a, b := rollups[i].usageKey, rollups[j].usageKey
+		if a.Tenant != b.Tenant {
+			return a.Tenant < b.Tenant
+		}
+		if a.Identity != b.Identity {
+			return a.Identity < b.Identity
+		}
+		return a.Operation < b.Operation
+	})
+	return rollups, nil
+}
+
+// Close stops the flush loop and flushes the remaining usage.
+func (m *usageMeter) Close() {
+	close(m.done)
+	m.wg.Wait()
+	m.flushAndLog()
+}
+
+// meteringMiddleware records every call's usage against its caller.
+func meteringMiddleware(m *usageMeter, method string) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+			defer func(begin time.Time) {
+				in, _ := json.Marshal(request)
+				var out []byte
+				if response != nil {
+					out, _ = json.Marshal(response)
+				}
+				var tenantID string
+				if t, ok := tenantFromContext(ctx); ok {
+					tenantID = t.ID
+				}
+				m.Add(usageKey{begin.UTC().Format(usageMonth), tenantID, identity(ctx), method}, usageTotals{
+					Calls:         1,
+					BytesIn:       int64(len(in)),
+					BytesOut:      int64(len(out)),
+					ComputeMicros: time.Since(begin).Microseconds(),
+				})
+			}(time.Now())
+			return next(ctx, request)
+		}
+	}
+}
+
+type usageRequest struct {
+	Month    string `json:"month" validate:"required,pattern=^[0-9]{4}-[0-9]{2}$"`
+	Identity string `json:"identity" validate:"max=256"`
+}
+
+type usageResponse struct {
+	Month   string        `json:"month" xml:"month"`
+	Total   usageTotals   `json:"total" xml:"total"`
+	Rollups []usageRollup `json:"rollups" xml:"rollups>rollup"`
+}
+
+// makeUsageEndpoint returns the caller's own usage.
+func makeUsageEndpoint(m *usageMeter) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(usageRequest)
+		return listUsage(ctx, m, req.Month, identity(ctx))
+	}
+}
+
+// makeAdminUsageEndpoint returns every caller's usage, or one caller's if
+// the request names one.
+func makeAdminUsageEndpoint(m *usageMeter) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(usageRequest)
+		return listUsage(ctx, m, req.Month, req.Identity)
+	}
+}
+
+func listUsage(ctx context.Context, m *usageMeter, month, identity string) (usageResponse, error) {
+	if _, err := time.Parse(usageMonth, month); err != nil {
+		return usageResponse{}, svcerrors.Errorf(svcerrors.CodeInvalidArgument, "malformed month %q: want YYYY-MM", month)
+	}
+	rollups, err := m.List(ctx, month, identity)
+	if err != nil {
+		return usageResponse{}, err
+	}
+	resp := usageResponse{Month: month, Rollups: rollups}
+	for _, r := range rollups {
+		resp.Total.add(r.usageTotals)
+	}
+	return resp, nil
+}
+
+// decodeUsageRequest reads the month (YYYY-MM, the current month by
+// default) and identity query parameters.
+func decodeUsageRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	q := r.URL.Query()
+	request := usageRequest{Month: q.Get("month"), Identity: q.Get("identity")}
+	if request.Month == "" {
+		request.Month = time.Now().UTC().Format(usageMonth)
+	}
+	return request, nil
+}
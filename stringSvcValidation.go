@@ -0,0 +1,202 @@
+package stringsvc
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-kit/kit/endpoint"
+
+	"github.com/mcclayac/gokit/svcerrors"
+)
+
+// validatingMiddleware rejects requests whose fields break the rules declared
+// in their `validate` struct tags, before they reach the service.
+func validatingMiddleware(next endpoint.Endpoint) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		if err := validate(request); err != nil {
+			return nil, err
+		}
+		return next(ctx, request)
+	}
+}
+
+// validate checks the fields of the struct v against their `validate` tags.
+// Rules are comma separated:
+//
+//	required     the field must not be the zero value
+//	min=N, max=N bounds on the length of strings and slices (in bytes for
+//	             strings) or on the value of integers
+//	pattern=RE   strings must match the regular expression RE; since RE may
+//	             itself contain commas, pattern must be the last rule
+//
+// Fields that are structs, or slices of them, are checked too, and their
+// violations named by path, as in "operations[2].method".
+//
+// Every failing field is reported in the returned error, one violation per
+// field. The error is PATTERN_INVALID if only pattern rules failed and
+// INVALID_ARGUMENT otherwise.
+func validate(v interface{}) error {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Struct {
+		return nil
+	}
+	var violations []svcerrors.FieldViolation
+	if err := validateStruct(rv, "", &violations); err != nil {
+		return err
+	}
+	if len(violations) == 0 {
+		return nil
+	}
+	code := svcerrors.CodePatternInvalid
+	for _, v := range violations {
+		if v.Rule != "pattern" {
+			code = svcerrors.CodeInvalidArgument
+		}
+	}
+	err := svcerrors.New(code, "request validation failed")
+	err.Fields = violations
+	return err
+}
+
+// validateStruct appends the violations of rv's fields, named under prefix,
+// to violations.
+func validateStruct(rv reflect.Value, prefix string, violations *[]svcerrors.FieldViolation) error {
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		f := rt.Field(i)
+		if f.PkgPath != "" {
+			continue // unexported
+		}
+		name := prefix + fieldName(f)
+		if tag, ok := f.Tag.Lookup("validate"); ok {
+			for _, rule := range splitRules(tag) {
+				msg, err := checkRule(rv.Field(i), rule)
+				if err != nil {
+					return fmt.Errorf("%s.%s: %v", rt.Name(), f.Name, err)
+				}
+				if msg != "" {
+					rule, _ := splitRule(rule)
+					*violations = append(*violations, svcerrors.FieldViolation{Field: name, Rule: rule, Message: msg})
+					break
+				}
+			}
+		}
+		if err := validateNested(rv.Field(i), name, violations); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateNested checks v if it is a struct, a pointer to one or a slice of
+// them.
+func validateNested(v reflect.Value, name string, violations *[]svcerrors.FieldViolation) error {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if !v.IsNil() {
+			return validateNested(v.Elem(), name, violations)
+		}
+	case reflect.Struct:
+		return validateStruct(v, name+".", violations)
+	case reflect.Slice, reflect.Array:
+		if k := v.Type().Elem().Kind(); k != reflect.Struct && k != reflect.Ptr {
+			return nil
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err := validateNested(v.Index(i), fmt.Sprintf("%s[%d]", name, i), violations); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func splitRules(tag string) []string {
+	if i := strings.Index(tag, "pattern="); i >= 0 {
+		return append(splitRules(strings.TrimSuffix(tag[:i], ",")), tag[i:])
+	}
+	if tag == "" {
+		return nil
+	}
+	return strings.Split(tag, ",")
+}
+
+func splitRule(rule string) (name, arg string) {
+	if i := strings.IndexByte(rule, '='); i >= 0 {
+		return rule[:i], rule[i+1:]
+	}
+	return rule, ""
+}
+
+// checkRule returns a message describing why v breaks rule, or "" if it
+// doesn't. The error is for malformed rules, which are programming mistakes.
+func checkRule(v reflect.Value, rule string) (string, error) {
+	name, arg := splitRule(rule)
+	switch name {
+	case "required":
+		if v.IsZero() {
+			return "is required", nil
+		}
+	case "min", "max":
+		n, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("bad %s rule %q", name, arg)
+		}
+		var got int64
+		switch v.Kind() {
+		case reflect.String, reflect.Slice, reflect.Map:
+			got = int64(v.Len())
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			got = v.Int()
+		default:
+			return "", fmt.Errorf("%s rule on unsupported kind %s", name, v.Kind())
+		}
+		if name == "min" && got < n {
+			return fmt.Sprintf("must be at least %d", n), nil
+		}
+		if name == "max" && got > n {
+			return fmt.Sprintf("must be at most %d", n), nil
+		}
+	case "pattern":
+		if v.Kind() != reflect.String {
+			return "", fmt.Errorf("pattern rule on unsupported kind %s", v.Kind())
+		}
+		re, err := compilePattern(arg)
+		if err != nil {
+			return "", err
+		}
+		if !re.MatchString(v.String()) {
+			return fmt.Sprintf("must match %s", arg), nil
+		}
+	default:
+		return "", fmt.Errorf("unknown rule %q", name)
+	}
+	return "", nil
+}
+
+var patterns sync.Map // string -> *regexp.Regexp
+
+func compilePattern(expr string) (*regexp.Regexp, error) {
+	if re, ok := patterns.Load(expr); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	patterns.Store(expr, re)
+	return re, nil
+}
+
+// fieldName is the name a field has on the wire.
+func fieldName(f reflect.StructField) string {
+	if name := strings.Split(f.Tag.Get("json"), ",")[0]; name != "" && name != "-" {
+		return name
+	}
+	return f.Name
+}
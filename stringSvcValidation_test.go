@@ -0,0 +1,45 @@
+package stringsvc
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/mcclayac/gokit/svcerrors"
+)
+
+func TestValidate(t *testing.T) {
+	ops := []jobOperation{{Method: "uppercase", Request: json.RawMessage(`{"s":"a"}`)}}
+	for _, c := range []struct {
+		name   string
+		req    submitJobRequest
+		code   svcerrors.Code
+		fields []string
+	}{
+		{"valid", submitJobRequest{Operations: ops, Callback: "https://example.com/done"}, "", nil},
+		{"bad pattern", submitJobRequest{Operations: ops, Callback: "ftp://example.com"}, svcerrors.CodePatternInvalid, []string{"callback_url"}},
+		{"nested required", submitJobRequest{Operations: append(ops, jobOperation{})}, svcerrors.CodeInvalidArgument, []string{"operations[1].method"}},
+		{"both", submitJobRequest{Operations: []jobOperation{{}}, Callback: "mailto:x"}, svcerrors.CodeInvalidArgument, []string{"operations[0].method", "callback_url"}},
+	} {
+		err := validate(c.req)
+		if svcerrors.CodeOf(err) != c.code {
+			t.Errorf("%s: got %v, want %q", c.name, err, c.code)
+			continue
+		}
+		var got []string
+		if e := svcerrors.From(err); e != nil {
+			for _, f := range e.Fields {
+				got = append(got, f.Field)
+			}
+		}
+		if len(got) != len(c.fields) {
+			t.Errorf("%s: got fields %q, want %q", c.name, got, c.fields)
+			continue
+		}
+		for i := range got {
+			if got[i] != c.fields[i] {
+				t.Errorf("%s: got fields %q, want %q", c.name, got, c.fields)
+				break
+			}
+		}
+	}
+}
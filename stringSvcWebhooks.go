@@ -0,0 +1,159 @@
+package stringsvc
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// webhookConfig configures the callbacks made when jobs complete.
+type webhookConfig struct {
+	Secret      string // callbacks are refused when empty
+	MaxAttempts int
+	Backoff     time.Duration // delay before the first retry, doubled on each retry
+	Timeout     time.Duration // per attempt
+	Allow       proxyList     // private addresses callbacks may reach anyway
+}
+
+const (
+	webhookTimestampHeader = "X-Webhook-Timestamp"
+	webhookSignatureHeader = "X-Webhook-Signature"
+)
+
+// webhookDelivery records one attempt to deliver a callback.
+type webhookDelivery struct {
+	Attempt int       `json:"attempt" xml:"attempt"`
+	Time    time.Time `json:"time" xml:"time"`
+	Status  int       `json:"status,omitempty" xml:"status,omitempty"` // zero if there was no response
+	Err     string    `json:"err,omitempty" xml:"err,omitempty"`
+}
+
+// webhookSender POSTs signed callbacks. The receiver verifies a delivery by
+// computing the hex HMAC-SHA256, keyed with the shared secret, of the
+// X-Webhook-Timestamp header, a '.', and the body, and comparing it with
+// the X-Webhook-Signature header, which has the form "sha256=<hex>".
+// Including the timestamp lets receivers reject replayed deliveries.
+//
+// Callback URLs are the submitter's, and the status of every delivery is
+// theirs to read, so callbacks only reach public addresses: loopback,
+// private, link-local and other special addresses are refused when
+// connecting, after DNS resolution, unless -webhook-allow lists them.
+// Redirects aren't followed, and proxies from the environment aren't used.
+type webhookSender struct {
+	cfg    webhookConfig
+	client *http.Client
+	now    func() time.Time // times deliveries; signatures always use the real time, which receivers check
+}
+
+func newWebhookSender(cfg webhookConfig, now func() time.Time) *webhookSender {
+	dialer := &net.Dialer{Timeout: cfg.Timeout, Control: callbackControl(cfg.Allow)}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   cfg.Timeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return &webhookSender{cfg: cfg, client: client, now: now}
+}
+
+// errCallbackAddress is the error of deliveries to addresses callbacks may
+// not reach.
+var errCallbackAddress = errors.New("callbacks may not reach this address")
+
+// sharedAddressSpace is the carrier-grade NAT range of RFC 6598, which
+// net.IP doesn't count as private.
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// callbackControl returns a net.Dialer Control func refusing connections
+// to addresses that aren't public, except those allow lists.
+func callbackControl(allow proxyList) func(network, address string, _ syscall.RawConn) error {
+	return func(network, address string, _ syscall.RawConn) error {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return err
+		}
+		ip := net.ParseIP(host)
+		if ip == nil {
+			return fmt.Errorf("%s: %w", host, errCallbackAddress)
+		}
+		if allow.Contains(host) {
+			return nil
+		}
+		if !ip.IsGlobalUnicast() || ip.IsPrivate() || sharedAddressSpace.Contains(ip) {
+			return fmt.Errorf("%s: %w", host, errCallbackAddress)
+		}
+		return nil
+	}
+}
+
+// Deliver posts body to url until it is accepted with a 2xx status, the
+// receiver answers with a status that retrying won't change, MaxAttempts
+// attempts have been made, the address is one callbacks may not reach, or
+// ctx is done. record is called after every attempt.
+func (s *webhookSender) Deliver(ctx context.Context, url string, body []byte, record func(webhookDelivery)) {
+	delay := s.cfg.Backoff
+	for attempt := 1; ; attempt++ {
+		d := webhookDelivery{Attempt: attempt, Time: s.now().UTC()}
+		status, err := s.post(ctx, url, body)
+		d.Status = status
+		if err != nil {
+			d.Err = err.Error()
+		}
+		record(d)
+		if err == nil || errors.Is(err, errCallbackAddress) || !retryableStatus(status) || attempt >= s.cfg.MaxAttempts {
+			return
+		}
+		select {
+		case <-time.After(delay):
+			delay *= 2
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *webhookSender) post(ctx context.Context, url string, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set(webhookTimestampHeader, ts)
+	req.Header.Set(webhookSignatureHeader, "sha256="+s.sign(ts, body))
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("callback answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+func (s *webhookSender) sign(ts string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(s.cfg.Secret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// retryableStatus reports whether a delivery that got status (zero for no
+// response at all) is worth retrying.
+func retryableStatus(status int) bool {
+	return status == 0 || status == http.StatusRequestTimeout || status == http.StatusTooManyRequests || status >= 500
+}
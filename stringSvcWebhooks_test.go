@@ -0,0 +1,39 @@
+package stringsvc
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWebhookPrivateAddresses(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "/", http.StatusFound)
+		}
+	}))
+	defer srv.Close()
+	cfg := webhookConfig{Secret: "s", MaxAttempts: 3, Backoff: time.Millisecond, Timeout: time.Second}
+	deliver := func(s *webhookSender, url string) []webhookDelivery {
+		var ds []webhookDelivery
+		s.Deliver(context.Background(), url, []byte("{}"), func(d webhookDelivery) { ds = append(ds, d) })
+		return ds
+	}
+
+	if ds := deliver(newWebhookSender(cfg, time.Now), srv.URL); len(ds) != 1 || ds[0].Status != 0 || ds[0].Err == "" {
+		t.Errorf("loopback callback: got deliveries %+v, want one refused", ds)
+	}
+
+	if err := cfg.Allow.Set("127.0.0.0/8"); err != nil {
+		t.Fatal(err)
+	}
+	s := newWebhookSender(cfg, time.Now)
+	if ds := deliver(s, srv.URL); len(ds) != 1 || ds[0].Status != http.StatusOK {
+		t.Errorf("allowed callback: got deliveries %+v, want one 200", ds)
+	}
+	if ds := deliver(s, srv.URL+"/redirect"); len(ds) != 1 || ds[0].Status != http.StatusFound {
+		t.Errorf("redirecting callback: got deliveries %+v, want the 302 unfollowed", ds)
+	}
+}
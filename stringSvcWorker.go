@@ -0,0 +1,96 @@
+package stringsvc
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+// workerConfig configures worker mode, in which the service consumes
+// requests from a message broker instead of serving them over HTTP. At
+// most one broker may be configured.
+type workerConfig struct {
+	MaxDeliver int           // attempts before a failing request is given up on
+	Backoff    time.Duration // first redelivery delay, doubled on each attempt
+
+	NATS   natsConfig
+	SQS    sqsConfig
+	PubSub pubsubConfig
+	Redis  redisStreamsConfig
+}
+
+// brokers returns the names of the configured brokers.
+func (c workerConfig) brokers() []string {
+	var names []string
+	if c.NATS.URL != "" {
+		names = append(names, "nats")
+	}
+	if c.SQS.QueueURL != "" {
+		names = append(names, "sqs")
+	}
+	if c.PubSub.Subscription != "" {
+		names = append(names, "pubsub")
+	}
+	if c.Redis.Addr != "" {
+		names = append(names, "redis")
+	}
+	return names
+}
+
+// Enabled reports whether a broker is configured.
+func (c workerConfig) Enabled() bool {
+	return len(c.brokers()) > 0
+}
+
+// brokerWorker consumes requests from a broker, passing each to the
+// endpoint for the method it names.
+type brokerWorker interface {
+	// Run consumes requests until ctx is done, then disconnects from the
+	// broker.
+	Run(ctx context.Context) error
+	// DeadLetters returns the dead-letter queue, or nil if none is
+	// configured, in which case messages given up on are dropped.
+	DeadLetters() deadLetterQueue
+}
+
+// newWorker connects to the configured broker, to handle messages with
+// the codecs and error reporter of env.
+func newWorker(ctx context.Context, cfg workerConfig, endpoints map[string]messageEndpoint, env *appEnv, logger log.Logger) (brokerWorker, error) {
+	brokers := cfg.brokers()
+	if len(brokers) != 1 {
+		return nil, fmt.Errorf("worker mode needs exactly one broker, have %v", brokers)
+	}
+	logger = log.With(logger, "broker", brokers[0])
+	endpoints = brokerEndpoints(endpoints)
+	switch brokers[0] {
+	case "nats":
+		return newNATSWorker(ctx, cfg, endpoints, env, logger)
+	case "sqs":
+		return newSQSWorker(ctx, cfg, endpoints, env, logger)
+	case "pubsub":
+		return newPubSubWorker(ctx, cfg, endpoints, env, logger)
+	default:
+		return newRedisStreamsWorker(ctx, cfg, endpoints, env, logger)
+	}
+}
+
+// brokerPrincipal is who requests from a broker are made by. Brokers are
+// reached only by the producers their own access control lets in, and
+// requests carry no tokens, so they may make any operation.
+var brokerPrincipal = issuedToken{ID: "broker", Subject: "broker", Scopes: []string{"*"}}
+
+// brokerEndpoints returns endpoints that call those of endpoints as
+// brokerPrincipal.
+func brokerEndpoints(endpoints map[string]messageEndpoint) map[string]messageEndpoint {
+	wrapped := make(map[string]messageEndpoint, len(endpoints))
+	for method, me := range endpoints {
+		e := me.e
+		me.e = func(ctx context.Context, request interface{}) (interface{}, error) {
+			return e(context.WithValue(ctx, principalKey{}, brokerPrincipal), request)
+		}
+		wrapped[method] = me
+	}
+	return wrapped
+}
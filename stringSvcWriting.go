@@ -0,0 +1,260 @@
+package stringsvc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"text/template"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-kit/kit/endpoint"
+
+	"github.com/mcclayac/gokit/llm"
+	"github.com/mcclayac/gokit/svcerrors"
+)
+
+// WritingService summarizes and rewrites text with a language model. When
+// emit is non-nil, the result is also passed to it piece by piece as it is
+// generated.
+type WritingService interface {
+	Summarize(ctx context.Context, text string, maxTokens int, emit func(string) error) (string, error)
+	// Rewrite rewrites text in the given style, such as "formal" or
+	// "plain English".
+	Rewrite(ctx context.Context, text, style string, maxTokens int, emit func(string) error) (string, error)
+}
+
+// llmConfig configures the WritingService.
+type llmConfig struct {
+	URL             string // OpenAI-compatible API base URL; the endpoints are off when empty
+	Key             string
+	Model           string
+	Timeout         time.Duration // for each call, including streamed ones
+	MaxInputTokens  int           // estimated; longer inputs are refused
+	MaxOutputTokens int           // the most a request may ask for, and the default
+	Prompts         string        // JSON file of prompt templates; built-in prompts when empty
+}
+
+// promptTemplate is a system and user message pair. Both are text/template
+// templates executed with a promptData.
+type promptTemplate struct {
+	System string `json:"system"`
+	User   string `json:"user"`
+}
+
+type promptData struct {
+	Text  string
+	Style string // for rewrite
+}
+
+// llmPrompts holds the templates for each method. A prompts file holds the
+// same JSON object; methods it leaves out keep the built-in templates.
+type llmPrompts struct {
+	Summarize promptTemplate `json:"summarize"`
+	Rewrite   promptTemplate `json:"rewrite"`
+}
+
+var defaultPrompts = llmPrompts{
+	Summarize: promptTemplate{
+		System: "You summarize text accurately and concisely. Reply with the summary only, in the language of the text.",
+		User:   "{{.Text}}",
+	},
+	Rewrite: promptTemplate{
+		System: "You rewrite text in the requested style, keeping its meaning. Reply with the rewritten text only.",
+		User:   "Style: {{.Style}}\n\n{{.Text}}",
+	},
+}
+
+// compiledPrompt is a promptTemplate ready to execute.
+type compiledPrompt struct {
+	system, user *template.Template
+}
+
+func compilePrompt(name string, p promptTemplate) (compiledPrompt, error) {
+	system, err := template.New(name + ".system").Parse(p.System)
+	if err != nil {
+		return compiledPrompt{}, err
+	}
+	user, err := template.New(name + ".user").Parse(p.User)
+	if err != nil {
+		return compiledPrompt{}, err
+	}
+	return compiledPrompt{system, user}, nil
+}
+
+func (p compiledPrompt) messages(data promptData) ([]llm.Message, error) {
+	var system, user bytes.Buffer
+	if err := p.system.Execute(&system, data); err != nil {
+		return nil, err
+	}
+	if err := p.user.Execute(&user, data); err != nil {
+		return nil, err
+	}
+	return []llm.Message{{Role: "system", Content: system.String()}, {Role: "user", Content: user.String()}}, nil
+}
+
+// writingService is a concrete implementation of WritingService.
+type writingService struct {
+	cfg       llmConfig
+	provider  llm.Provider
+	summarize compiledPrompt
+	rewrite   compiledPrompt
+}
+
+func newWritingService(cfg llmConfig, provider llm.Provider) (*writingService, error) {
+	prompts := defaultPrompts
+	if cfg.Prompts != "" {
+		b, err := os.ReadFile(cfg.Prompts)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(b, &prompts); err != nil {
+			return nil, fmt.Errorf("%s: %v", cfg.Prompts, err)
+		}
+	}
+	s := &writingService{cfg: cfg, provider: provider}
+	var err error
+	if s.summarize, err = compilePrompt("summarize", prompts.Summarize); err != nil {
+		return nil, err
+	}
+	if s.rewrite, err = compilePrompt("rewrite", prompts.Rewrite); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *writingService) Summarize(ctx context.Context, text string, maxTokens int, emit func(string) error) (string, error) {
+	return s.complete(ctx, s.summarize, promptData{Text: text}, maxTokens, emit)
+}
+
+func (s *writingService) Rewrite(ctx context.Context, text, style string, maxTokens int, emit func(string) error) (string, error) {
+	return s.complete(ctx, s.rewrite, promptData{Text: text, Style: style}, maxTokens, emit)
+}
+
+// estimateTokens approximates the tokens in s at four characters each,
+// which is close for English with common tokenizers.
+func estimateTokens(s string) int {
+	return (utf8.RuneCountInString(s) + 3) / 4
+}
+
+func (s *writingService) complete(ctx context.Context, prompt compiledPrompt, data promptData, maxTokens int, emit func(string) error) (string, error) {
+	if data.Text == "" {
+		return "", ErrEmpty
+	}
+	if n := estimateTokens(data.Text); n > s.cfg.MaxInputTokens {
+		return "", svcerrors.Errorf(svcerrors.CodeTokenBudgetExceeded, "text is about %d tokens, more than the %d allowed", n, s.cfg.MaxInputTokens)
+	}
+	if maxTokens == 0 {
+		maxTokens = s.cfg.MaxOutputTokens
+	}
+	if maxTokens > s.cfg.MaxOutputTokens {
+		return "", svcerrors.Errorf(svcerrors.CodeTokenBudgetExceeded, "max_tokens may be at most %d", s.cfg.MaxOutputTokens)
+	}
+	msgs, err := prompt.messages(data)
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.Timeout)
+	defer cancel()
+	c, err := s.provider.Complete(ctx, llm.Request{Model: s.cfg.Model, Messages: msgs, MaxTokens: maxTokens}, emit)
+	var se *llm.StatusError
+	switch {
+	case errors.As(err, &se) && se.StatusCode == http.StatusBadRequest:
+		return "", svcerrors.Errorf(svcerrors.CodeInvalidArgument, "model rejected the request: %s", se.Message)
+	case errors.As(err, &se) && se.StatusCode == http.StatusTooManyRequests:
+		return "", svcerrors.ErrRateLimited
+	case errors.As(err, &se):
+		return "", svcerrors.Errorf(svcerrors.CodeLLMUnavailable, "model unavailable: %v", err)
+	case err != nil:
+		return "", err
+	}
+	return c.Text, nil
+}
+
+// For each method, we define request and response structs. Both methods
+// stream their result as server-sent events to clients that accept
+// text/event-stream: "delta" events carry each piece of text as
+// {"v": "..."}, and the last event is "done" with the whole response, or
+// "error" with the error envelope.
+type summarizeRequest struct {
+	Text      string `json:"text" xml:"text" validate:"required,max=200000"`
+	MaxTokens int    `json:"max_tokens" xml:"max_tokens" validate:"min=0"`
+}
+
+type rewriteRequest struct {
+	Text      string `json:"text" xml:"text" validate:"required,max=200000"`
+	Style     string `json:"style" xml:"style" validate:"required,max=200"`
+	MaxTokens int    `json:"max_tokens" xml:"max_tokens" validate:"min=0"`
+}
+
+// writingResponse is the response of every WritingService method.
+type writingResponse struct {
+	V   string `json:"v" xml:"v"`
+	Err error  `json:"-" xml:"-"`
+}
+
+// Failed implements endpoint.Failer.
+func (r writingResponse) Failed() error { return r.Err }
+
+// streamDeltas returns the emit function that sends each piece of text as a
+// delta event, or nil if the client isn't streaming.
+func streamDeltas(ctx context.Context) func(string) error {
+	sw := sseFromContext(ctx)
+	if sw == nil {
+		return nil
+	}
+	return func(delta string) error {
+		return sw.Event("delta", writingResponse{V: delta})
+	}
+}
+
+func makeSummarizeEndpoint(svc WritingService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(summarizeRequest)
+		v, err := svc.Summarize(ctx, req.Text, req.MaxTokens, streamDeltas(ctx))
+		return writingResponse{v, err}, nil
+	}
+}
+
+func makeRewriteEndpoint(svc WritingService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(rewriteRequest)
+		v, err := svc.Rewrite(ctx, req.Text, req.Style, req.MaxTokens, streamDeltas(ctx))
+		return writingResponse{v, err}, nil
+	}
+}
+
+func decodeSummarizeRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request summarizeRequest
+	if err := decodeBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+func decodeRewriteRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request rewriteRequest
+	if err := decodeBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+// encodeWritingResponse ends the event stream with a done or error event
+// when the client is streaming, and is encodeResponse otherwise.
+func encodeWritingResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	sw := sseFromContext(ctx)
+	if sw == nil {
+		return encodeResponse(ctx, w, response)
+	}
+	resp := response.(writingResponse)
+	if resp.Err != nil {
+		reportUnexpected(ctx, resp.Err)
+		return sw.Event("error", errorResponse{svcerrors.From(resp.Err)})
+	}
+	return sw.Event("done", resp)
+}
@@ -0,0 +1,166 @@
+// Package stringsvctest runs the string service for integration tests:
+//
+//	srv := stringsvctest.StartTestServer(t, stringsvctest.WithHistory())
+//	v, err := srv.Client.Uppercase(ctx, "hello")
+//
+// The server is the service itself, with its whole handler stack, built
+// in-process by stringsvc.NewApp from the same flags the serve subcommand
+// takes and served by an httptest.Server on an ephemeral port.
+package stringsvctest
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	stringsvc "github.com/mcclayac/gokit"
+	"github.com/mcclayac/gokit/client"
+)
+
+// Server is a running service.
+type Server struct {
+	// URL is the service's base URL, such as http://127.0.0.1:54321.
+	URL string
+	// Client calls the service. It doesn't retry or trip a circuit
+	// breaker unless WithClientOptions says to, so that failures show.
+	Client *client.Client
+}
+
+// Option configures a test server.
+type Option func(*options)
+
+type options struct {
+	args       []string
+	sqlite     bool
+	clock      *Clock
+	clientOpts []client.Option
+}
+
+// WithFlags passes command-line flags to the service, such as
+// WithFlags("-strict-json") or WithFlags("-job-workers", "1").
+func WithFlags(args ...string) Option {
+	return func(o *options) { o.args = append(o.args, args...) }
+}
+
+// WithHistory records calls for /history.
+func WithHistory() Option { return WithFlags("-history") }
+
+// WithPayloadLog logs request and response bodies, which are shown with
+// the rest of the service's log when a test fails.
+func WithPayloadLog() Option { return WithFlags("-payload-log") }
+
+// WithSQLite keeps the service's state in a SQLite database in a temporary
+// directory, instead of in memory.
+func WithSQLite() Option {
+	return func(o *options) { o.sqlite = true }
+}
+
+// WithClock has the service read the time from c instead of the system
+// clock: the time methods, new IDs, and the creation and expiry of links,
+// tokens and cached responses all see c, which the test moves.
+func WithClock(c *Clock) Option {
+	return func(o *options) { o.clock = c }
+}
+
+// WithClientOptions configures Server.Client.
+func WithClientOptions(opts ...client.Option) Option {
+	return func(o *options) { o.clientOpts = append(o.clientOpts, opts...) }
+}
+
+// StartTestServer starts the service. The service is stopped when the test
+// ends, and its log is written to the test's log if the test failed.
+func StartTestServer(t testing.TB, opts ...Option) *Server {
+	t.Helper()
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	var args []string
+	if o.sqlite {
+		args = append(args, "-sqlite-path", filepath.Join(t.TempDir(), "stringsvc.db"))
+	}
+	now := time.Now
+	if o.clock != nil {
+		now = o.clock.Now
+	}
+	logs := &syncBuffer{}
+	app, err := stringsvc.NewApp(append(args, o.args...), logs, now)
+	if err != nil {
+		t.Fatalf("stringsvctest: starting the service: %v\n%s", err, logs.String())
+	}
+	ts := httptest.NewUnstartedServer(nil)
+	ts.Config = app.Server()
+	ts.Start()
+	t.Cleanup(func() {
+		ts.Close()
+		app.Close()
+		if t.Failed() {
+			t.Logf("stringsvctest: service log:\n%s", logs.String())
+		}
+	})
+
+	c, err := client.New(ts.URL, append([]client.Option{
+		client.WithRetry(client.RetryPolicy{}),
+		client.WithoutBreaker(),
+	}, o.clientOpts...)...)
+	if err != nil {
+		t.Fatalf("stringsvctest: %v", err)
+	}
+	return &Server{URL: ts.URL, Client: c}
+}
+
+// Clock is a clock that only moves when told to. It is safe for the
+// service and the test to use at once.
+type Clock struct {
+	mtx sync.Mutex
+	t   time.Time
+}
+
+// NewClock returns a clock reading t.
+func NewClock(t time.Time) *Clock {
+	return &Clock{t: t}
+}
+
+// Now returns the clock's time.
+func (c *Clock) Now() time.Time {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.t
+}
+
+// Set sets the clock to t.
+func (c *Clock) Set(t time.Time) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.t = t
+}
+
+// Advance moves the clock on by d.
+func (c *Clock) Advance(d time.Duration) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.t = c.t.Add(d)
+}
+
+// syncBuffer collects the service's log, which its goroutines write
+// concurrently.
+type syncBuffer struct {
+	mtx sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return b.buf.String()
+}
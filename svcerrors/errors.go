@@ -0,0 +1,207 @@
+// Package svcerrors defines the coded errors returned by the string service.
+// Each error carries a stable, machine-readable Code so that clients can
+// branch on the kind of failure instead of matching on message text.
+package svcerrors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Code identifies a class of error. Codes are part of the wire format and
+// must never be renamed once released.
+type Code string
+
+// The error catalog.
+const (
+	CodeInternal               Code = "INTERNAL"
+	CodeInvalidArgument        Code = "INVALID_ARGUMENT"
+	CodeUnsupportedMediaType   Code = "UNSUPPORTED_MEDIA_TYPE"
+	CodeNotAcceptable          Code = "NOT_ACCEPTABLE"
+	CodeStringEmpty            Code = "STRING_EMPTY"
+	CodePatternInvalid         Code = "PATTERN_INVALID"
+	CodeRateLimited            Code = "RATE_LIMITED"
+	CodeIdempotencyConflict    Code = "IDEMPOTENCY_CONFLICT"
+	CodeIdempotencyMismatch    Code = "IDEMPOTENCY_MISMATCH"
+	CodeDeadlineExceeded       Code = "DEADLINE_EXCEEDED"
+	CodeCanceled               Code = "CANCELED"
+	CodeHostnameUnavailable    Code = "HOSTNAME_UNAVAILABLE"
+	CodeNotFound               Code = "NOT_FOUND"
+	CodeJobQueueFull           Code = "JOB_QUEUE_FULL"
+	CodeDivisionByZero         Code = "DIVISION_BY_ZERO"
+	CodeNumberOverflow         Code = "NUMBER_OVERFLOW"
+	CodeTimeZoneInvalid        Code = "TIME_ZONE_INVALID"
+	CodeLayoutInvalid          Code = "LAYOUT_INVALID"
+	CodeTimestampInvalid       Code = "TIMESTAMP_INVALID"
+	CodeHashInvalid            Code = "HASH_INVALID"
+	CodeTranslationUnavailable Code = "TRANSLATION_UNAVAILABLE"
+	CodeTokenBudgetExceeded    Code = "TOKEN_BUDGET_EXCEEDED"
+	CodeLLMUnavailable         Code = "LLM_UNAVAILABLE"
+	CodeUnauthenticated        Code = "UNAUTHENTICATED"
+	CodePermissionDenied       Code = "PERMISSION_DENIED"
+	CodePayloadTooLarge        Code = "PAYLOAD_TOO_LARGE"
+	CodeQuotaExceeded          Code = "QUOTA_EXCEEDED"
+	CodeMaintenance            Code = "MAINTENANCE"
+)
+
+// Error is an error with a Code. It marshals to JSON as
+// {"code": "...", "message": "..."}, plus a "fields" list when the error is
+// the result of request validation.
+type Error struct {
+	Code    Code             `json:"code" xml:"code"`
+	Message string           `json:"message" xml:"message"`
+	Fields  []FieldViolation `json:"fields,omitempty" xml:"fields>field,omitempty"`
+}
+
+// FieldViolation describes a single request field that failed validation.
+type FieldViolation struct {
+	Field   string `json:"field" xml:"field"`
+	Rule    string `json:"rule" xml:"rule"`
+	Message string `json:"message" xml:"message"`
+}
+
+// New returns an Error with the given code and message.
+func New(code Code, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+// Errorf returns an Error with the given code and a formatted message.
+func Errorf(code Code, format string, args ...interface{}) *Error {
+	return New(code, fmt.Sprintf(format, args...))
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Is reports whether target is an *Error with the same code, so that
+// errors.Is(err, svcerrors.ErrStringEmpty) holds for any STRING_EMPTY error.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// Sentinel errors for the catalog entries that have a fixed message.
+var (
+	ErrStringEmpty         = New(CodeStringEmpty, "empty string")
+	ErrRateLimited         = New(CodeRateLimited, "rate limit exceeded")
+	ErrHostnameUnavailable = New(CodeHostnameUnavailable, "hostname unavailable")
+	ErrJobQueueFull        = New(CodeJobQueueFull, "job queue is full")
+	ErrInternal            = New(CodeInternal, "internal error")
+	ErrDivisionByZero      = New(CodeDivisionByZero, "division by zero")
+	ErrNumberOverflow      = New(CodeNumberOverflow, "result is out of range")
+)
+
+// From converts err into an *Error. Errors that already carry a code are
+// returned as is, and context errors get DEADLINE_EXCEEDED or CANCELED;
+// anything else is reported as INTERNAL, with a fixed message: its own
+// may hold database, cache or file system details meant for logs and error
+// reports, not callers. From(nil) is nil.
+func From(err error) *Error {
+	if err == nil {
+		return nil
+	}
+	var e *Error
+	switch {
+	case errors.As(err, &e):
+		return e
+	case errors.Is(err, context.DeadlineExceeded):
+		return New(CodeDeadlineExceeded, err.Error())
+	case errors.Is(err, context.Canceled):
+		return New(CodeCanceled, err.Error())
+	}
+	return New(CodeInternal, ErrInternal.Message)
+}
+
+// CodeOf returns the code carried by err. Errors without a code report
+// CodeInternal, and CodeOf(nil) is the empty code.
+func CodeOf(err error) Code {
+	if e := From(err); e != nil {
+		return e.Code
+	}
+	return ""
+}
+
+// Retryable reports whether a request that failed with code may succeed if
+// it is sent again unchanged.
+func Retryable(code Code) bool {
+	switch code {
+	case CodeInternal, CodeRateLimited, CodeHostnameUnavailable, CodeDeadlineExceeded, CodeJobQueueFull,
+		CodeTranslationUnavailable, CodeLLMUnavailable, CodeMaintenance:
+		return true
+	default:
+		return false
+	}
+}
+
+// HTTPStatus maps a code to the HTTP status used by the transport layer.
+func HTTPStatus(code Code) int {
+	switch code {
+	case CodeInvalidArgument, CodeStringEmpty, CodePatternInvalid, CodeDivisionByZero, CodeNumberOverflow,
+		CodeTimeZoneInvalid, CodeLayoutInvalid, CodeTimestampInvalid, CodeHashInvalid,
+		CodeTokenBudgetExceeded:
+		return http.StatusBadRequest
+	case CodeUnauthenticated:
+		return http.StatusUnauthorized
+	case CodePermissionDenied:
+		return http.StatusForbidden
+	case CodePayloadTooLarge:
+		return http.StatusRequestEntityTooLarge
+	case CodeUnsupportedMediaType:
+		return http.StatusUnsupportedMediaType
+	case CodeNotAcceptable:
+		return http.StatusNotAcceptable
+	case CodeRateLimited, CodeQuotaExceeded:
+		return http.StatusTooManyRequests
+	case CodeIdempotencyConflict:
+		return http.StatusConflict
+	case CodeIdempotencyMismatch:
+		return http.StatusUnprocessableEntity
+	case CodeNotFound:
+		return http.StatusNotFound
+	case CodeHostnameUnavailable, CodeJobQueueFull, CodeTranslationUnavailable, CodeLLMUnavailable, CodeMaintenance:
+		return http.StatusServiceUnavailable
+	case CodeDeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case CodeCanceled:
+		return 499 // client closed request, as logged by nginx
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// CodeForStatus maps an HTTP status back to a code, for error responses
+// that don't carry one, such as those from a proxy. Statuses that several
+// codes share map to the most general of them.
+func CodeForStatus(status int) Code {
+	switch status {
+	case http.StatusUnauthorized:
+		return CodeUnauthenticated
+	case http.StatusForbidden:
+		return CodePermissionDenied
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusNotAcceptable:
+		return CodeNotAcceptable
+	case http.StatusConflict:
+		return CodeIdempotencyConflict
+	case http.StatusRequestEntityTooLarge:
+		return CodePayloadTooLarge
+	case http.StatusUnsupportedMediaType:
+		return CodeUnsupportedMediaType
+	case http.StatusUnprocessableEntity:
+		return CodeIdempotencyMismatch
+	case http.StatusTooManyRequests:
+		return CodeRateLimited
+	case 499:
+		return CodeCanceled
+	case http.StatusGatewayTimeout:
+		return CodeDeadlineExceeded
+	}
+	if status >= 400 && status < 500 {
+		return CodeInvalidArgument
+	}
+	return CodeInternal
+}
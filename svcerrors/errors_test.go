@@ -0,0 +1,86 @@
+package svcerrors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestFrom(t *testing.T) {
+	for _, c := range []struct {
+		err     error
+		code    Code
+		message string
+	}{
+		{ErrStringEmpty, CodeStringEmpty, "empty string"},
+		{fmt.Errorf("uppercasing: %w", ErrStringEmpty), CodeStringEmpty, "empty string"},
+		{fmt.Errorf("calling: %w", context.DeadlineExceeded), CodeDeadlineExceeded, "calling: context deadline exceeded"},
+		{context.Canceled, CodeCanceled, "context canceled"},
+		{errors.New("dial tcp 10.0.0.5:5432: connection refused"), CodeInternal, "internal error"},
+	} {
+		e := From(c.err)
+		if e.Code != c.code || e.Message != c.message {
+			t.Errorf("From(%q): got %s %q, want %s %q", c.err, e.Code, e.Message, c.code, c.message)
+		}
+	}
+	if From(nil) != nil || CodeOf(nil) != "" {
+		t.Error("From(nil) or CodeOf(nil) isn't empty")
+	}
+}
+
+func TestIs(t *testing.T) {
+	err := fmt.Errorf("counting: %w", Errorf(CodeStringEmpty, "s is %q", ""))
+	if !errors.Is(err, ErrStringEmpty) {
+		t.Error("a STRING_EMPTY error isn't ErrStringEmpty")
+	}
+	if errors.Is(err, ErrRateLimited) {
+		t.Error("a STRING_EMPTY error is ErrRateLimited")
+	}
+}
+
+func TestHTTPStatus(t *testing.T) {
+	// Codes that are the most general for their status come back from
+	// CodeForStatus as they went in.
+	for _, code := range []Code{
+		CodeInternal, CodeInvalidArgument, CodeUnauthenticated, CodePermissionDenied,
+		CodeNotFound, CodeNotAcceptable, CodeIdempotencyConflict, CodePayloadTooLarge,
+		CodeUnsupportedMediaType, CodeIdempotencyMismatch, CodeRateLimited, CodeCanceled,
+		CodeDeadlineExceeded,
+	} {
+		if got := CodeForStatus(HTTPStatus(code)); got != code {
+			t.Errorf("%s: status %d maps back to %s", code, HTTPStatus(code), got)
+		}
+	}
+	for status, code := range map[int]Code{
+		http.StatusTeapot:             CodeInvalidArgument,
+		http.StatusBadGateway:         CodeInternal,
+		http.StatusServiceUnavailable: CodeInternal,
+	} {
+		if got := CodeForStatus(status); got != code {
+			t.Errorf("CodeForStatus(%d): got %s, want %s", status, got, code)
+		}
+	}
+	if got := HTTPStatus(CodePatternInvalid); got != http.StatusBadRequest {
+		t.Errorf("PATTERN_INVALID: got %d, want 400", got)
+	}
+	if got := HTTPStatus("NO_SUCH_CODE"); got != http.StatusInternalServerError {
+		t.Errorf("an unknown code: got %d, want 500", got)
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	for code, want := range map[Code]bool{
+		CodeRateLimited:     true,
+		CodeMaintenance:     true,
+		CodeInternal:        true,
+		CodeStringEmpty:     false,
+		CodeUnauthenticated: false,
+		CodeQuotaExceeded:   false,
+	} {
+		if got := Retryable(code); got != want {
+			t.Errorf("Retryable(%s): got %v, want %v", code, got, want)
+		}
+	}
+}
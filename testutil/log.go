@@ -0,0 +1,120 @@
+package testutil
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Logs is a go-kit log.Logger that keeps the records logged to it, so that
+// tests can check what middleware logs without parsing its output:
+//
+//	logs := &testutil.Logs{}
+//	... call an endpoint wrapped in slowRequestMiddleware(logs, 0, "uppercase") ...
+//	logs.ExpectLog(t, "level", "warn", "msg", "slow request", "method", "uppercase")
+//
+// The zero value is ready to use.
+type Logs struct {
+	mtx     sync.Mutex
+	records []Record
+}
+
+// Record is a logged record. Values are kept as logged; a key without a
+// value maps to nil.
+type Record map[string]interface{}
+
+// Get returns the value of key formatted with fmt.Sprint, as the logfmt
+// and JSON loggers would show it, and whether r has key.
+func (r Record) Get(key string) (string, bool) {
+	v, ok := r[key]
+	if !ok {
+		return "", false
+	}
+	return fmt.Sprint(v), true
+}
+
+func (l *Logs) Log(keyvals ...interface{}) error {
+	r := make(Record, len(keyvals)/2)
+	for i := 0; i < len(keyvals); i += 2 {
+		var v interface{}
+		if i+1 < len(keyvals) {
+			v = keyvals[i+1]
+		}
+		r[fmt.Sprint(keyvals[i])] = v
+	}
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	l.records = append(l.records, r)
+	return nil
+}
+
+// Records returns the records logged so far, oldest first.
+func (l *Logs) Records() []Record {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	return append([]Record(nil), l.records...)
+}
+
+// Find returns the records that have all the fields in keyvals, given as
+// key-value pairs and compared as formatted by Get, such as
+// Find("level", "error", "method", "count").
+func (l *Logs) Find(keyvals ...interface{}) []Record {
+	var found []Record
+	for _, r := range l.Records() {
+		ok := true
+		for i := 0; i+1 < len(keyvals); i += 2 {
+			if v, has := r.Get(fmt.Sprint(keyvals[i])); !has || v != fmt.Sprint(keyvals[i+1]) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			found = append(found, r)
+		}
+	}
+	return found
+}
+
+// Reset forgets the records logged so far.
+func (l *Logs) Reset() {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	l.records = nil
+}
+
+// ExpectLogField reports an error of t unless a record has key with the
+// value want, compared as formatted by Get.
+func (l *Logs) ExpectLogField(t testing.TB, key string, want interface{}) {
+	t.Helper()
+	if len(l.Find(key, want)) == 0 {
+		t.Errorf("no record has %s=%v; logged:\n%s", key, want, l)
+	}
+}
+
+// ExpectLog reports an error of t unless a record has all the fields in
+// keyvals, matched as by Find.
+func (l *Logs) ExpectLog(t testing.TB, keyvals ...interface{}) {
+	t.Helper()
+	if len(l.Find(keyvals...)) == 0 {
+		t.Errorf("no record has %v; logged:\n%s", keyvals, l)
+	}
+}
+
+// ExpectNoLog reports an error of t if a record has all the fields in
+// keyvals, such as ExpectNoLog(t, "level", "error").
+func (l *Logs) ExpectNoLog(t testing.TB, keyvals ...interface{}) {
+	t.Helper()
+	if found := l.Find(keyvals...); len(found) > 0 {
+		t.Errorf("%d records have %v; logged:\n%s", len(found), keyvals, l)
+	}
+}
+
+// String lists the records logged, one per line.
+func (l *Logs) String() string {
+	var b strings.Builder
+	for _, r := range l.Records() {
+		fmt.Fprintf(&b, "\t%v\n", map[string]interface{}(r))
+	}
+	return b.String()
+}
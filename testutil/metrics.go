@@ -0,0 +1,188 @@
+package testutil
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/go-kit/kit/metrics"
+)
+
+// Metrics keeps the values of the instruments created with it in memory,
+// so that tests can check what middleware records without scraping
+// /metrics. It has the methods of the service's metrics factory and can
+// stand in for a real backend:
+//
+//	m := testutil.NewMetrics()
+//	em := newEndpointMetrics(m, sloThresholds{})
+//	... call an endpoint wrapped in instrumentingMiddleware(em, "uppercase") ...
+//	m.ExpectCounter(t, "requests", 1, "method", "uppercase", "code", "OK")
+type Metrics struct {
+	mtx    sync.Mutex
+	series map[string][]*series
+}
+
+// series is the values recorded under one set of label values.
+type series struct {
+	labels map[string]string
+	sum    float64   // of a counter
+	obs    []float64 // of a histogram
+}
+
+// NewMetrics returns an empty Metrics.
+func NewMetrics() *Metrics {
+	return &Metrics{series: map[string][]*series{}}
+}
+
+// Counter returns a counter recorded under name. help and the label
+// names aren't kept.
+func (m *Metrics) Counter(name, help string, labels ...string) metrics.Counter {
+	return &counter{m, name, nil}
+}
+
+// Histogram returns a histogram recorded under name, which keeps every
+// observation rather than buckets.
+func (m *Metrics) Histogram(name, help string, buckets []float64, labels ...string) metrics.Histogram {
+	return &histogram{m, name, nil}
+}
+
+// Handler returns nil: there's nothing to scrape.
+func (m *Metrics) Handler() http.Handler { return nil }
+
+func (m *Metrics) Stop(context.Context) error { return nil }
+
+// record finds the series of name with labelValues, adding it if it's new,
+// and passes it to fn.
+func (m *Metrics) record(name string, labelValues []string, fn func(s *series)) {
+	labels := labelMap(labelValues)
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	for _, s := range m.series[name] {
+		if sameLabels(s.labels, labels) {
+			fn(s)
+			return
+		}
+	}
+	s := &series{labels: labels}
+	m.series[name] = append(m.series[name], s)
+	fn(s)
+}
+
+// matching returns the series of name that have all the label values in
+// labelValues, and maybe others.
+func (m *Metrics) matching(name string, labelValues []string) []*series {
+	want := labelMap(labelValues)
+	var found []*series
+	for _, s := range m.series[name] {
+		ok := true
+		for k, v := range want {
+			if s.labels[k] != v {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			found = append(found, s)
+		}
+	}
+	return found
+}
+
+// CounterValue returns the sum of the counters named name with the label
+// values in labelValues, given as name-value pairs. Labels left out match
+// any value, so CounterValue("requests", "method", "count") counts the
+// calls of every outcome.
+func (m *Metrics) CounterValue(name string, labelValues ...string) float64 {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	var sum float64
+	for _, s := range m.matching(name, labelValues) {
+		sum += s.sum
+	}
+	return sum
+}
+
+// Observations returns the values observed by the histograms named name
+// with the label values in labelValues, matched as by CounterValue.
+func (m *Metrics) Observations(name string, labelValues ...string) []float64 {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	var obs []float64
+	for _, s := range m.matching(name, labelValues) {
+		obs = append(obs, s.obs...)
+	}
+	return obs
+}
+
+// ExpectCounter reports an error of t unless CounterValue(name,
+// labelValues...) is want.
+func (m *Metrics) ExpectCounter(t testing.TB, name string, want float64, labelValues ...string) {
+	t.Helper()
+	if got := m.CounterValue(name, labelValues...); got != want {
+		t.Errorf("counter %s%v = %v, want %v", name, labelValues, got, want)
+	}
+}
+
+// ExpectObservations reports an error of t unless the histograms named name
+// with labelValues observed n values.
+func (m *Metrics) ExpectObservations(t testing.TB, name string, n int, labelValues ...string) {
+	t.Helper()
+	if got := len(m.Observations(name, labelValues...)); got != n {
+		t.Errorf("histogram %s%v observed %d values, want %d", name, labelValues, got, n)
+	}
+}
+
+type counter struct {
+	m           *Metrics
+	name        string
+	labelValues []string
+}
+
+func (c *counter) With(labelValues ...string) metrics.Counter {
+	return &counter{c.m, c.name, append(append([]string(nil), c.labelValues...), labelValues...)}
+}
+
+func (c *counter) Add(delta float64) {
+	c.m.record(c.name, c.labelValues, func(s *series) { s.sum += delta })
+}
+
+type histogram struct {
+	m           *Metrics
+	name        string
+	labelValues []string
+}
+
+func (h *histogram) With(labelValues ...string) metrics.Histogram {
+	return &histogram{h.m, h.name, append(append([]string(nil), h.labelValues...), labelValues...)}
+}
+
+func (h *histogram) Observe(value float64) {
+	h.m.record(h.name, h.labelValues, func(s *series) { s.obs = append(s.obs, value) })
+}
+
+// labelMap turns name-value pairs into a map. A name without a value gets
+// "unknown", as in the go-kit backends.
+func labelMap(labelValues []string) map[string]string {
+	labels := make(map[string]string, len(labelValues)/2)
+	for i := 0; i < len(labelValues); i += 2 {
+		v := "unknown"
+		if i+1 < len(labelValues) {
+			v = labelValues[i+1]
+		}
+		labels[labelValues[i]] = v
+	}
+	return labels
+}
+
+func sameLabels(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || w != v {
+			return false
+		}
+	}
+	return true
+}
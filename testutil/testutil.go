@@ -0,0 +1,185 @@
+// Package testutil holds helpers for the service's tests: Metrics and Logs
+// capture what middleware records, and Text and Check drive property-based
+// tests of the string operations with properties that should hold for any
+// text:
+//
+//	testutil.Check(t,
+//		testutil.KeepsValidUTF8("uppercase", strings.ToUpper),
+//		testutil.Idempotent("uppercase", strings.ToUpper),
+//		testutil.ReverseInvariant("count", func(s string) int { return svc.Count(ctx, s) }),
+//	)
+//
+// Text is a testing/quick generator, so it can also be taken as an
+// argument of a function passed to quick.Check directly. Generated text
+// favours what breaks byte and rune handling: combining marks, runes next
+// to the surrogate range, bidi controls, joiners, case mappings that change
+// length and invalid UTF-8.
+package testutil
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/quick"
+	"unicode/utf8"
+)
+
+// Tricky holds samples of each kind of text Text generates, by kind. They
+// make good seeds for fuzzing too.
+var Tricky = map[string][]string{
+	"ascii":     {"", "a", "hello, world", "abc123 def", "can't-stop co-op", "\x00", "\t\r\n"},
+	"combining": {"e\u0301", "\u0301", "a\u0308\u0304", "\u0345", "Z\u0353\u0354\u0351\u0352", "\u20dd"},
+	"surrogate": {"\ud7ff", "\ue000", "\ufffd", "\uffff", "\U00010000", "\U0010ffff", "\xed\xa0\x80", "\xed\xbf\xbf", "\xed\x9f\xbf"},
+	"bidi":      {"\u200e", "\u200f", "\u202a", "\u202b", "\u202c", "\u202d", "\u202e", "\u2066", "\u2067", "\u2068", "\u2069", "abc\u202edef"},
+	"joiners":   {"\u200b", "\u200c", "\u200d", "\ufeff", "\U0001F469\u200d\U0001F469\u200d\U0001F467", "\U0001F44D\U0001F3FD"},
+	"case":      {"\u0130stanbul", "stra\u00dfe", "\ufb03", "\u01c5ungla", "\u0390", "\u01f0", "\u017f", "\u212a", "\u00df"},
+	"scripts":   {"日本語のテキスト", "Привет, мир", "مرحبا بالعالم", "שלום", "नमस्ते", "ไทย"},
+	"invalid":   {"\xff", "\xfe\xff", "\x80", "a\x80b", "\xc0\xaf", "\xe2\x82", "\xf4\x90\x80\x80", "\xf8\x88\x80\x80\x80"},
+}
+
+// kinds lists Tricky's keys in a fixed order, so that a seed always
+// generates the same text.
+var kinds = []string{"ascii", "combining", "surrogate", "bidi", "joiners", "case", "scripts", "invalid"}
+
+// Samples returns all of Tricky's samples, kind by kind.
+func Samples() []string {
+	var all []string
+	for _, k := range kinds {
+		all = append(all, Tricky[k]...)
+	}
+	return all
+}
+
+// Text is generated text, usually but not always valid UTF-8.
+type Text string
+
+// Generate implements quick.Generator. The text is made of up to size
+// pieces, each a Tricky sample or a random rune.
+func (Text) Generate(rng *rand.Rand, size int) reflect.Value {
+	var b strings.Builder
+	for n := rng.Intn(size + 1); n > 0; n-- {
+		if rng.Intn(4) == 0 {
+			b.WriteRune(randomRune(rng))
+			continue
+		}
+		samples := Tricky[kinds[rng.Intn(len(kinds))]]
+		b.WriteString(samples[rng.Intn(len(samples))])
+	}
+	return reflect.ValueOf(Text(b.String()))
+}
+
+// randomRune returns a rune from the Basic Multilingual Plane, or beyond
+// it one time in eight.
+func randomRune(rng *rand.Rand) rune {
+	if rng.Intn(8) == 0 {
+		return rune(0x10000 + rng.Intn(utf8.MaxRune-0x10000+1))
+	}
+	r := rune(rng.Intn(0x10000))
+	if utf8.ValidRune(r) {
+		return r
+	}
+	return r - 0x800 // out of the surrogates, just below them
+}
+
+// Reverse returns s with its runes in reverse order. Runs of bytes that
+// aren't valid UTF-8 are moved as they are, so the result has the same
+// bytes, and decodes to as many runes, as s; combining marks end up before
+// what they combined with.
+func Reverse(s string) string {
+	b := make([]byte, len(s))
+	end := len(b)
+	for len(s) > 0 {
+		n := 0
+		for n < len(s) {
+			r, size := utf8.DecodeRuneInString(s[n:])
+			if r != utf8.RuneError || size != 1 {
+				if n == 0 {
+					n = size
+				}
+				break
+			}
+			n++
+		}
+		end -= n
+		copy(b[end:], s[:n])
+		s = s[n:]
+	}
+	return string(b)
+}
+
+// Property is a property of string operations that should hold for any
+// text.
+type Property struct {
+	Name string
+	// Holds returns an error describing how s breaks the property, or nil.
+	Holds func(s string) error
+}
+
+// MaxCount is how many texts Check tries per property.
+var MaxCount = 1000
+
+// Check checks each property on generated text, and reports the texts
+// that break them as errors of t.
+func Check(t testing.TB, props ...Property) {
+	t.Helper()
+	for _, p := range props {
+		var failure error
+		err := quick.Check(func(s Text) bool {
+			failure = p.Holds(string(s))
+			return failure == nil
+		}, &quick.Config{MaxCount: MaxCount})
+		if ce, ok := err.(*quick.CheckError); ok {
+			t.Errorf("%s: %v (input %+q, try %d)", p.Name, failure, ce.In[0], ce.Count)
+		} else if err != nil {
+			t.Errorf("%s: %v", p.Name, err)
+		}
+	}
+}
+
+// KeepsValidUTF8 is the property that f maps valid UTF-8 to valid UTF-8.
+func KeepsValidUTF8(name string, f func(string) string) Property {
+	return Property{name + " keeps valid UTF-8", func(s string) error {
+		if v := f(s); utf8.ValidString(s) && !utf8.ValidString(v) {
+			return fmt.Errorf("gave invalid %+q", v)
+		}
+		return nil
+	}}
+}
+
+// Idempotent is the property that f changes nothing more when applied
+// again to its own result, as a case mapping does.
+func Idempotent(name string, f func(string) string) Property {
+	return Property{name + " is idempotent", func(s string) error {
+		if v, w := f(s), f(f(s)); v != w {
+			return fmt.Errorf("gave %+q, and %+q when applied again", v, w)
+		}
+		return nil
+	}}
+}
+
+// ReverseInvariant is the property that f gives the same result for text
+// and its Reverse, as a count of bytes or runes does.
+func ReverseInvariant(name string, f func(string) int) Property {
+	return Property{name + " ignores order", func(s string) error {
+		if n, r := f(s), f(Reverse(s)); n != r {
+			return fmt.Errorf("gave %d, and %d for the reverse", n, r)
+		}
+		return nil
+	}}
+}
+
+// Additive is the property that f of two texts joined is the sum of f of
+// each, as a count is. The text is split at every byte.
+func Additive(name string, f func(string) int) Property {
+	return Property{name + " adds up", func(s string) error {
+		n := f(s)
+		for i := 0; i <= len(s); i++ {
+			if a, b := f(s[:i]), f(s[i:]); a+b != n {
+				return fmt.Errorf("gave %d, but %d and %d for the parts split at %d", n, a, b, i)
+			}
+		}
+		return nil
+	}}
+}
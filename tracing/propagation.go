@@ -0,0 +1,34 @@
+// Package tracing carries trace context across HTTP hops, so that spans
+// started by callers, this service and its downstream calls join one trace.
+package tracing
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/contrib/propagators/b3"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// Propagator reads and writes W3C Trace Context (traceparent, tracestate),
+// W3C Baggage, and B3 in both its single-header and multi-header forms.
+// Extraction accepts whichever of them the caller sent.
+var Propagator propagation.TextMapPropagator = propagation.NewCompositeTextMapPropagator(
+	propagation.TraceContext{},
+	propagation.Baggage{},
+	b3.New(b3.WithInjectEncoding(b3.B3MultipleHeader|b3.B3SingleHeader)),
+)
+
+// HTTPToContext is a go-kit http transport RequestFunc that moves the
+// trace context in the request headers into ctx. Use it with ServerBefore.
+func HTTPToContext(ctx context.Context, r *http.Request) context.Context {
+	return Propagator.Extract(ctx, propagation.HeaderCarrier(r.Header))
+}
+
+// ContextToHTTP is a go-kit http transport RequestFunc that writes the
+// trace context in ctx to the outgoing request headers. Use it with
+// ClientBefore.
+func ContextToHTTP(ctx context.Context, r *http.Request) context.Context {
+	Propagator.Inject(ctx, propagation.HeaderCarrier(r.Header))
+	return ctx
+}
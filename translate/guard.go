@@ -0,0 +1,54 @@
+package translate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/sony/gobreaker"
+	"golang.org/x/time/rate"
+)
+
+// ErrRateLimited is returned by a guarded provider that has used up its
+// rate limit.
+var ErrRateLimited = errors.New("translate: provider rate limit reached")
+
+// ErrUnavailable is returned, wrapped, by a guarded provider whose circuit
+// breaker is open.
+var ErrUnavailable = errors.New("translate: provider unavailable")
+
+// Guarded is a Provider with a rate limit and a circuit breaker.
+type Guarded struct {
+	Provider
+	limiter *rate.Limiter
+	breaker *gobreaker.CircuitBreaker
+}
+
+// Guard wraps p. Calls beyond limiter's rate fail with ErrRateLimited
+// without reaching p; limiter may be nil for no limit. The breaker, named
+// after p, opens on the failures settings.ReadyToTrip counts. Requests the
+// provider rejects as bad, and canceled calls, are not failures.
+func Guard(p Provider, limiter *rate.Limiter, settings gobreaker.Settings) *Guarded {
+	settings.Name = p.Name()
+	settings.IsSuccessful = func(err error) bool {
+		var se *StatusError
+		return err == nil || errors.Is(err, context.Canceled) || (errors.As(err, &se) && se.BadRequest())
+	}
+	return &Guarded{Provider: p, limiter: limiter, breaker: gobreaker.NewCircuitBreaker(settings)}
+}
+
+func (g *Guarded) Translate(ctx context.Context, text, source, target string) (Result, error) {
+	if g.limiter != nil && !g.limiter.Allow() {
+		return Result{}, ErrRateLimited
+	}
+	r, err := g.breaker.Execute(func() (interface{}, error) {
+		return g.Provider.Translate(ctx, text, source, target)
+	})
+	if err == gobreaker.ErrOpenState || err == gobreaker.ErrTooManyRequests {
+		return Result{}, fmt.Errorf("%w: %s: %v", ErrUnavailable, g.Name(), err)
+	}
+	if err != nil {
+		return Result{}, err
+	}
+	return r.(Result), nil
+}
@@ -0,0 +1,125 @@
+package translate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// DeepL translates with the DeepL API.
+type DeepL struct {
+	Key string
+	// URL is the API's base URL. It defaults to the free API for keys
+	// ending in ":fx" and the pro API otherwise.
+	URL    string
+	Client *http.Client
+}
+
+func (DeepL) Name() string { return "deepl" }
+
+func (p DeepL) Translate(ctx context.Context, text, source, target string) (Result, error) {
+	url := p.URL
+	if url == "" {
+		url = "https://api.deepl.com"
+		if strings.HasSuffix(p.Key, ":fx") {
+			url = "https://api-free.deepl.com"
+		}
+	}
+	in := struct {
+		Text       []string `json:"text"`
+		SourceLang string   `json:"source_lang,omitempty"`
+		TargetLang string   `json:"target_lang"`
+	}{[]string{text}, strings.ToUpper(source), strings.ToUpper(target)}
+	var out struct {
+		Translations []struct {
+			DetectedSourceLanguage string `json:"detected_source_language"`
+			Text                   string `json:"text"`
+		} `json:"translations"`
+	}
+	header := http.Header{"Authorization": {"DeepL-Auth-Key " + p.Key}}
+	if err := postJSON(ctx, p.Client, p.Name(), url+"/v2/translate", header, in, &out); err != nil {
+		return Result{}, err
+	}
+	if len(out.Translations) != 1 {
+		return Result{}, fmt.Errorf("deepl: got %d translations for one text", len(out.Translations))
+	}
+	t := out.Translations[0]
+	return Result{Text: t.Text, DetectedSource: strings.ToLower(t.DetectedSourceLanguage)}, nil
+}
+
+// Google translates with the Google Cloud Translation API (v2), using an
+// API key.
+type Google struct {
+	Key    string
+	URL    string // defaults to https://translation.googleapis.com
+	Client *http.Client
+}
+
+func (Google) Name() string { return "google" }
+
+func (p Google) Translate(ctx context.Context, text, source, target string) (Result, error) {
+	url := p.URL
+	if url == "" {
+		url = "https://translation.googleapis.com"
+	}
+	in := struct {
+		Q      string `json:"q"`
+		Source string `json:"source,omitempty"`
+		Target string `json:"target"`
+		Format string `json:"format"`
+	}{text, source, target, "text"}
+	var out struct {
+		Data struct {
+			Translations []struct {
+				TranslatedText         string `json:"translatedText"`
+				DetectedSourceLanguage string `json:"detectedSourceLanguage"`
+			} `json:"translations"`
+		} `json:"data"`
+	}
+	header := http.Header{"X-Goog-Api-Key": {p.Key}}
+	if err := postJSON(ctx, p.Client, p.Name(), url+"/language/translate/v2", header, in, &out); err != nil {
+		return Result{}, err
+	}
+	if len(out.Data.Translations) != 1 {
+		return Result{}, fmt.Errorf("google: got %d translations for one text", len(out.Data.Translations))
+	}
+	t := out.Data.Translations[0]
+	return Result{Text: t.TranslatedText, DetectedSource: t.DetectedSourceLanguage}, nil
+}
+
+// LibreTranslate translates with a LibreTranslate server.
+type LibreTranslate struct {
+	URL    string
+	Key    string // for servers that require one
+	Client *http.Client
+}
+
+func (LibreTranslate) Name() string { return "libretranslate" }
+
+func (p LibreTranslate) Translate(ctx context.Context, text, source, target string) (Result, error) {
+	if p.URL == "" {
+		return Result{}, errors.New("libretranslate: no server URL")
+	}
+	if source == "" {
+		source = "auto"
+	}
+	in := struct {
+		Q      string `json:"q"`
+		Source string `json:"source"`
+		Target string `json:"target"`
+		Format string `json:"format"`
+		APIKey string `json:"api_key,omitempty"`
+	}{text, source, target, "text", p.Key}
+	var out struct {
+		TranslatedText   string `json:"translatedText"`
+		DetectedLanguage struct {
+			Language string `json:"language"`
+		} `json:"detectedLanguage"`
+	}
+	if err := postJSON(ctx, p.Client, p.Name(), strings.TrimSuffix(p.URL, "/")+"/translate", nil, in, &out); err != nil {
+		return Result{}, err
+	}
+	return Result{Text: out.TranslatedText, DetectedSource: out.DetectedLanguage.Language}, nil
+}
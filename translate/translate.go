@@ -0,0 +1,84 @@
+// Package translate translates text through external machine translation
+// providers, all behind the Provider interface. Guard adds a rate limit
+// and a circuit breaker to any provider.
+package translate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Result is a translated text.
+type Result struct {
+	Text string
+	// DetectedSource is the source language the provider detected, when
+	// none was given.
+	DetectedSource string
+}
+
+// Provider translates text. Languages are ISO 639-1 codes such as "en",
+// optionally with a region as in "pt-BR"; an empty source language asks the
+// provider to detect it.
+type Provider interface {
+	Name() string
+	Translate(ctx context.Context, text, source, target string) (Result, error)
+}
+
+// StatusError is returned when a provider answers with an HTTP error.
+type StatusError struct {
+	Provider   string
+	StatusCode int
+	Message    string // from the response body, if any
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s: %d %s: %s", e.Provider, e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// BadRequest reports whether the provider rejected the request itself, for
+// example for an unsupported language, so that another attempt or provider
+// would fare no better.
+func (e *StatusError) BadRequest() bool {
+	return e.StatusCode == http.StatusBadRequest
+}
+
+// maxErrorBody bounds how much of an error response is kept as its message.
+const maxErrorBody = 1024
+
+// postJSON posts in as JSON to url and decodes the response into out.
+// header is applied to the request.
+func postJSON(ctx context.Context, client *http.Client, provider, url string, header http.Header, in, out interface{}) error {
+	b, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s: %w", provider, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		return &StatusError{Provider: provider, StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("%s: decoding response: %w", provider, err)
+	}
+	return nil
+}